@@ -0,0 +1,246 @@
+// Command cursor-agent-acp serves the Agent Client Protocol over stdio,
+// backed by the cursor-agent CLI.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/doctor"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/redact"
+	"github.com/spjoes/cursor-agent-acp/internal/server"
+	"github.com/spjoes/cursor-agent-acp/internal/wirelog"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("cursor-agent-acp", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to a JSON config file")
+	logLevel := flags.String("log-level", "", "override logLevel (error, warn, info, debug)")
+	validate := flags.Bool("validate", false, "validate the configuration and exit")
+	var printConfig bool
+	for _, name := range []string{"print-config", "validate-config"} {
+		flags.BoolVar(&printConfig, name, false, "print the effective configuration (defaults, file and CURSOR_ACP_* overrides) as JSON, validate it and exit")
+	}
+	doctorMode := flags.Bool("doctor", false, "check cursor-agent, authentication, the session directory, node and stdio framing; prints a report to stderr and JSON to stdout")
+	traceFile := flags.String("trace-file", "", "record every JSON-RPC message (secrets masked, long strings cut) to this file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath, config.Default())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: %v\n", err)
+		return 1
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if printConfig {
+		return printEffectiveConfig(cfg)
+	}
+	if *doctorMode {
+		return runDoctor(cfg)
+	}
+	if errs := config.Validate(cfg); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "cursor-agent-acp: invalid config: %v\n", err)
+		}
+		return 1
+	}
+	if *validate {
+		fmt.Fprintln(os.Stderr, "Configuration is valid")
+		return 0
+	}
+
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: %v\n", err)
+		return 1
+	}
+	redactor.AddValues(searchAPIKey(cfg))
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: open log file: %v\n", err)
+		return 1
+	}
+	defer logger.Close()
+	logger.SetRedactor(redactor)
+	if flags.NArg() > 0 {
+		if flags.Arg(0) == "auth" {
+			return runAuth(cfg, logger, flags.Args()[1:])
+		}
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: unknown command %q\n", flags.Arg(0))
+		return 2
+	}
+	return serve(cfg, logger, redactor, serveOptions{configPath: *configPath, logLevel: *logLevel, traceFile: *traceFile})
+}
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = time.Second
+
+type serveOptions struct {
+	configPath string
+	logLevel   string // --log-level, which wins over the file on reload too
+	traceFile  string
+}
+
+// searchAPIKey returns the value of the variable named by the web search
+// apiKeyEnv setting, so the key itself is masked wherever it shows up.
+func searchAPIKey(cfg config.Config) string {
+	if cfg.Tools.Web.Search.APIKeyEnv == "" {
+		return ""
+	}
+	return strings.TrimSpace(os.Getenv(cfg.Tools.Web.Search.APIKeyEnv))
+}
+
+// printEffectiveConfig writes cfg to stdout, with secret-looking values
+// masked, and reports validation errors on stderr.
+func printEffectiveConfig(cfg config.Config) int {
+	// An invalid redaction pattern leaves key-based masking only; Validate
+	// reports the pattern below.
+	redactor, _ := redact.New(cfg.Redaction)
+	out, err := json.MarshalIndent(redactor.Value(cfg), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+	errs := config.Validate(cfg)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: invalid config: %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return 0
+}
+
+func runDoctor(cfg config.Config) int {
+	report := doctor.Run(context.Background(), cfg, logging.New("error"))
+	_ = report.WriteText(os.Stderr)
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+func newLogger(cfg config.Config) (*logging.Logger, error) {
+	if cfg.LogFile == "" {
+		return logging.New(cfg.LogLevel), nil
+	}
+	return logging.NewWithRotatingFile(cfg.LogLevel, cfg.LogFile, logging.Rotation{
+		MaxSize:    cfg.LogRotation.MaxSize,
+		MaxAge:     time.Duration(cfg.LogRotation.MaxAge) * time.Millisecond,
+		MaxBackups: cfg.LogRotation.MaxBackups,
+		Compress:   cfg.LogRotation.Compress,
+	})
+}
+
+func serve(cfg config.Config, logger *logging.Logger, redactor *redact.Redactor, opts serveOptions) int {
+	// stdout carries JSON-RPC only; anything else writing to os.Stdout goes
+	// to stderr instead of corrupting the protocol stream.
+	protocolOut := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = protocolOut }()
+
+	var wire *wirelog.Log
+	if opts.traceFile != "" {
+		var err error
+		if wire, err = wirelog.Open(opts.traceFile, redactor); err != nil {
+			logger.Error("Failed to open trace file", map[string]any{"path": opts.traceFile, "error": err.Error()})
+			return 1
+		}
+		defer wire.Close()
+		logger.Info("Recording JSON-RPC traffic", map[string]any{"path": opts.traceFile})
+	}
+
+	srv := server.New(cfg, logger)
+	srv.SetOutput(protocolOut)
+	srv.SetWireLog(wire)
+	if err := srv.Initialize(); err != nil {
+		logger.Error("Failed to initialize adapter", map[string]any{"error": err.Error()})
+		srv.Close()
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	watchLevelToggle(ctx, logger, logging.ParseLevel(cfg.LogLevel))
+	ignoreBrokenPipe()
+	if opts.configPath != "" {
+		go config.Watch(ctx, opts.configPath, config.Default(), configPollInterval, func(next config.Config, err error) {
+			if err == nil {
+				if opts.logLevel != "" {
+					next.LogLevel = opts.logLevel
+				}
+				err = srv.ApplyConfig(next)
+			}
+			if err != nil {
+				logger.Error("Ignoring config file change", map[string]any{"path": opts.configPath, "error": err.Error()})
+			}
+		})
+	}
+	serveErr := srv.StartStdio(ctx)
+	if errors.Is(serveErr, context.Canceled) {
+		logger.Info("Received termination signal", nil)
+		serveErr = nil
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(srv.Config().ShutdownTimeout)*time.Millisecond)
+	defer cancel()
+	shutdownErr := srv.Shutdown(shutdownCtx)
+
+	if serveErr != nil {
+		logger.Error("stdio transport failed", map[string]any{"error": serveErr.Error()})
+		return 1
+	}
+	if shutdownErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// runAuth hands `auth login|logout|status` to cursor-agent with the
+// terminal attached, so login can prompt or open a browser.
+func runAuth(cfg config.Config, logger *logging.Logger, args []string) int {
+	if len(args) != 1 || (args[0] != "login" && args[0] != "logout" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, "usage: cursor-agent-acp auth <login|logout|status>")
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	cmd := cursor.NewBridge(cfg, logger).Command(ctx, args[0])
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "cursor-agent-acp: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+// watchLevelToggle flips between debug and the configured level on SIGUSR1
+// until ctx is done.
+func watchLevelToggle(ctx context.Context, logger *logging.Logger, configured logging.Level) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				next := logging.DebugLevel
+				if logger.Level() == logging.DebugLevel {
+					next = configured
+					if next == logging.DebugLevel {
+						next = logging.InfoLevel
+					}
+				}
+				logger.SetLevel(next)
+				logger.Info("Log level changed by SIGUSR1", map[string]any{"level": next.String()})
+			}
+		}
+	}()
+}
+
+// ignoreBrokenPipe turns SIGPIPE on a closed stdout into an EPIPE write
+// error, so the server can notice the client left and shut down cleanly
+// instead of being killed by the signal. The signal is caught and dropped
+// rather than ignored: an ignored SIGPIPE would be inherited by cursor-agent
+// and every other child process, which expect the default.
+func ignoreBrokenPipe() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGPIPE)
+	go func() {
+		for range signals {
+		}
+	}()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+// watchLevelToggle is a no-op: Windows has no SIGUSR1. Use the
+// _logging/set_level extension method instead.
+func watchLevelToggle(context.Context, *logging.Logger, logging.Level) {}
+
+// ignoreBrokenPipe is a no-op: writes to a closed pipe already fail with an
+// error on Windows.
+func ignoreBrokenPipe() {}
@@ -23,9 +23,10 @@ type Implementation struct {
 }
 
 type NewSessionRequest struct {
-	Cwd        string           `json:"cwd"`
-	McpServers []map[string]any `json:"mcpServers"`
-	Metadata   map[string]any   `json:"metadata,omitempty"`
+	Cwd              string           `json:"cwd"`
+	McpServers       []map[string]any `json:"mcpServers"`
+	WorkspaceFolders []string         `json:"workspaceFolders,omitempty"`
+	Metadata         map[string]any   `json:"metadata,omitempty"`
 }
 
 type NewSessionResponse struct {
@@ -36,10 +37,11 @@ type NewSessionResponse struct {
 }
 
 type LoadSessionRequest struct {
-	SessionID  string           `json:"sessionId"`
-	Cwd        string           `json:"cwd"`
-	McpServers []map[string]any `json:"mcpServers"`
-	Metadata   map[string]any   `json:"metadata,omitempty"`
+	SessionID        string           `json:"sessionId"`
+	Cwd              string           `json:"cwd"`
+	McpServers       []map[string]any `json:"mcpServers"`
+	WorkspaceFolders []string         `json:"workspaceFolders,omitempty"`
+	Metadata         map[string]any   `json:"metadata,omitempty"`
 }
 
 type LoadSessionResponse struct {
@@ -57,6 +59,15 @@ type SetSessionModeResponse struct {
 	Meta map[string]any `json:"_meta,omitempty"`
 }
 
+type SetSessionCwdRequest struct {
+	SessionID string `json:"sessionId"`
+	Cwd       string `json:"cwd"`
+}
+
+type SetSessionCwdResponse struct {
+	Meta map[string]any `json:"_meta,omitempty"`
+}
+
 type SetSessionModelRequest struct {
 	SessionID string `json:"sessionId"`
 	ModelID   string `json:"modelId"`
@@ -66,6 +77,18 @@ type SetSessionModelResponse struct {
 	Meta map[string]any `json:"_meta,omitempty"`
 }
 
+// ListSessionsRequest pages through sessions, most recently active first.
+// Filter keys, all optional and combined with AND:
+//
+//   - name: case-insensitive substring of metadata.name
+//   - tags: a tag metadata.tags must contain (case-insensitive)
+//   - status: "busy", "active", "inactive" or "expired", or an array of them
+//   - model: the session's current model ID
+//   - cwd: an absolute directory the session cwd must be or be inside
+//   - createdAfter, createdBefore, updatedAfter, updatedBefore: RFC 3339
+//     times; After bounds are inclusive, Before bounds exclusive
+//
+// Other keys are ignored.
 type ListSessionsRequest struct {
 	Limit  int            `json:"limit,omitempty"`
 	Offset int            `json:"offset,omitempty"`
@@ -83,8 +106,33 @@ type UpdateSessionRequest struct {
 	Metadata  map[string]any `json:"metadata,omitempty"`
 }
 
+// DeleteSessionRequest deletes a session. A session with a prompt or tool
+// call in flight is only deleted with Force, which cancels them first.
 type DeleteSessionRequest struct {
 	SessionID string `json:"sessionId"`
+	Force     bool   `json:"force,omitempty"`
+}
+
+// SubscribeSessionRequest is the params of session/subscribe and
+// session/unsubscribe.
+type SubscribeSessionRequest struct {
+	SessionID string `json:"sessionId"`
+}
+
+// ReplayUpdatesRequest asks for the session/update notifications numbered
+// (_meta.sequence) after SinceSequence.
+type ReplayUpdatesRequest struct {
+	SessionID     string `json:"sessionId"`
+	SinceSequence uint64 `json:"sinceSequence"`
+}
+
+type ListCheckpointsRequest struct {
+	SessionID string `json:"sessionId"`
+}
+
+type RestoreCheckpointRequest struct {
+	SessionID    string `json:"sessionId"`
+	CheckpointID string `json:"checkpointId"`
 }
 
 type PromptRequest struct {
@@ -111,9 +159,20 @@ type ToolCallRequest struct {
 }
 
 type ToolDescriptor struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	Parameters  map[string]any `json:"parameters"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Parameters  map[string]any   `json:"parameters"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations describe what a tool does so clients can decide how to
+// present or gate it. Kind is the ACP tool kind (read, edit, execute, ...).
+type ToolAnnotations struct {
+	ReadOnly           bool   `json:"readOnly"`
+	Destructive        bool   `json:"destructive"`
+	RequiresPermission bool   `json:"requiresPermission"`
+	Kind               string `json:"kind"`
+	Provider           string `json:"provider,omitempty"`
 }
 
 type ToolsListResponse struct {
@@ -140,6 +199,16 @@ type ContentBlock struct {
 	Size        any               `json:"size,omitempty"`
 	Resource    *EmbeddedResource `json:"resource,omitempty"`
 	Annotations map[string]any    `json:"annotations,omitempty"`
+	// Diff blocks describe a file edit. OldText is nil for a new file.
+	Path    string  `json:"path,omitempty"`
+	OldText *string `json:"oldText,omitempty"`
+	NewText *string `json:"newText,omitempty"`
+}
+
+// DiffBlock returns a diff block replacing oldText with newText at path;
+// pass a nil oldText for a file that did not exist.
+func DiffBlock(path string, oldText *string, newText string) ContentBlock {
+	return ContentBlock{Type: "diff", Path: path, OldText: oldText, NewText: &newText}
 }
 
 type EmbeddedResource struct {
@@ -169,6 +238,9 @@ type SessionModel struct {
 type SessionModelEntry struct {
 	ModelID string `json:"modelId"`
 	Name    string `json:"name"`
+	// Meta carries the model's contextWindow, vision, audio, reasoning and
+	// tier when they are known.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 type SessionModelState struct {
@@ -191,6 +263,31 @@ type SessionState struct {
 	Status       string    `json:"status"`
 	CurrentMode  string    `json:"currentMode,omitempty"`
 	CurrentModel string    `json:"currentModel,omitempty"`
+	Usage        Usage     `json:"usage,omitzero"`
+}
+
+// TurnUsage is the token usage of one prompt turn and its estimated cost.
+// Estimated is set when cursor-agent reported no usage and the counts were
+// derived from the text sent and received; Priced is false when the model
+// has no known prices.
+type TurnUsage struct {
+	Model           string  `json:"model"`
+	InputTokens     int     `json:"inputTokens"`
+	OutputTokens    int     `json:"outputTokens"`
+	CacheReadTokens int     `json:"cacheReadTokens,omitempty"`
+	Estimated       bool    `json:"estimated,omitempty"`
+	Priced          bool    `json:"priced"`
+	CostUSD         float64 `json:"costUsd"`
+}
+
+// Usage accumulates the TurnUsage of a session's turns.
+type Usage struct {
+	Turns          int     `json:"turns"`
+	InputTokens    int     `json:"inputTokens"`
+	OutputTokens   int     `json:"outputTokens"`
+	CostUSD        float64 `json:"costUsd"`
+	EstimatedTurns int     `json:"estimatedTurns,omitempty"`
+	UnpricedTurns  int     `json:"unpricedTurns,omitempty"`
 }
 
 type SessionData struct {
@@ -202,6 +299,8 @@ type SessionData struct {
 	UpdatedAt    time.Time             `json:"updatedAt"`
 }
 
+// SessionInfo summarizes a session for session/list. Status is "busy" while
+// a prompt runs, otherwise "active", "inactive" or "expired".
 type SessionInfo struct {
 	ID        string         `json:"id"`
 	Metadata  map[string]any `json:"metadata"`
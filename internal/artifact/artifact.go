@@ -0,0 +1,240 @@
+// Package artifact keeps large tool outputs, diffs and generated files on
+// disk so notifications can reference them with resource_link blocks
+// instead of carrying them inline. Clients fetch them with _artifacts/get.
+package artifact
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// URIScheme names artifacts in resource_link blocks:
+// artifact://<sessionId>/<artifactId>.
+const URIScheme = "artifact"
+
+var ErrNotFound = errors.New("artifact not found")
+
+type Artifact struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"sessionId"`
+	Name      string    `json:"name"`
+	MimeType  string    `json:"mimeType"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (a Artifact) URI() string {
+	return URIScheme + "://" + a.SessionID + "/" + a.ID
+}
+
+// ResourceLink is the resource_link content block referencing a.
+func (a Artifact) ResourceLink() map[string]any {
+	return map[string]any{
+		"type":     "resource_link",
+		"uri":      a.URI(),
+		"name":     a.Name,
+		"mimeType": a.MimeType,
+		"size":     a.Size,
+	}
+}
+
+// ParseURI splits an artifact:// URI into its session and artifact IDs.
+func ParseURI(uri string) (sessionID string, id string, ok bool) {
+	rest, ok := strings.CutPrefix(uri, URIScheme+"://")
+	if !ok {
+		return "", "", false
+	}
+	sessionID, id, ok = strings.Cut(rest, "/")
+	return sessionID, id, ok && sessionID != "" && id != ""
+}
+
+// Codec encrypts artifacts at rest. The session manager is one when
+// sessionEncryption is enabled.
+type Codec interface {
+	Seal(data []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+}
+
+// Store keeps each session's artifacts in <dir>/<sessionId>: the data in a
+// file named by the artifact ID and its description beside it in <id>.json.
+type Store struct {
+	dir   string
+	codec Codec
+	// maxPerSession is the number of artifacts kept per session; 0 keeps
+	// them all.
+	maxPerSession int
+	mu            sync.Mutex
+}
+
+func NewStore(dir string) *Store {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return &Store{dir: dir}
+}
+
+// SetCodec encrypts the artifacts written from now on with codec; Get reads
+// both sealed and plain artifacts through it.
+func (s *Store) SetCodec(codec Codec) {
+	s.codec = codec
+}
+
+// SetMaxPerSession keeps only the newest max artifacts of each session.
+func (s *Store) SetMaxPerSession(max int) {
+	s.maxPerSession = max
+}
+
+// Put saves data as a new artifact of sessionID.
+func (s *Store) Put(sessionID, name, mimeType string, data []byte) (Artifact, error) {
+	if !validID(sessionID) {
+		return Artifact{}, fmt.Errorf("invalid sessionId: %q", sessionID)
+	}
+	a := Artifact{
+		ID:        newID(),
+		SessionID: sessionID,
+		Name:      name,
+		MimeType:  mimeType,
+		Size:      int64(len(data)),
+		CreatedAt: time.Now().UTC(),
+	}
+	meta, err := json.Marshal(a)
+	if err != nil {
+		return Artifact{}, err
+	}
+	if s.codec != nil {
+		if data, err = s.codec.Seal(data); err != nil {
+			return Artifact{}, err
+		}
+		if meta, err = s.codec.Seal(meta); err != nil {
+			return Artifact{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.dir, sessionID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Artifact{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, a.ID), data, 0o600); err != nil {
+		return Artifact{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, a.ID+".json"), meta, 0o600); err != nil {
+		_ = os.Remove(filepath.Join(dir, a.ID))
+		return Artifact{}, err
+	}
+	s.prune(dir, a.ID)
+	return a, nil
+}
+
+// prune removes the oldest artifacts in dir beyond maxPerSession, never the
+// one just saved, keep. IDs start with their creation time, so they sort
+// oldest first.
+func (s *Store) prune(dir string, keep string) {
+	if s.maxPerSession <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var ids []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && id != keep {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < s.maxPerSession {
+		return
+	}
+	sort.Strings(ids)
+	for _, id := range ids[:len(ids)-s.maxPerSession+1] {
+		_ = os.Remove(filepath.Join(dir, id+".json"))
+		_ = os.Remove(filepath.Join(dir, id))
+	}
+}
+
+// Get returns the artifact and up to limit bytes of its data from offset;
+// limit <= 0 reads to the end.
+func (s *Store) Get(sessionID, id string, offset, limit int64) (Artifact, []byte, error) {
+	if !validID(sessionID) || !validID(id) {
+		return Artifact{}, nil, ErrNotFound
+	}
+	dir := filepath.Join(s.dir, sessionID)
+	raw, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Artifact{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+	if s.codec != nil {
+		if raw, err = s.codec.Open(raw); err != nil {
+			return Artifact{}, nil, err
+		}
+	}
+	var a Artifact
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return Artifact{}, nil, err
+	}
+	if s.codec != nil {
+		// Sealed data cannot be read in pieces.
+		data, err := os.ReadFile(filepath.Join(dir, id))
+		if err == nil {
+			data, err = s.codec.Open(data)
+		}
+		if err != nil {
+			return Artifact{}, nil, err
+		}
+		offset = min(max(offset, 0), int64(len(data)))
+		end := int64(len(data))
+		if limit > 0 {
+			end = min(end, offset+limit)
+		}
+		return a, data[offset:end], nil
+	}
+	f, err := os.Open(filepath.Join(dir, id))
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+	defer f.Close()
+	offset = min(max(offset, 0), a.Size)
+	n := a.Size - offset
+	if limit > 0 {
+		n = min(n, limit)
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, offset); err != nil && n > 0 {
+		return Artifact{}, nil, err
+	}
+	return a, data, nil
+}
+
+// DeleteSession removes every artifact of sessionID.
+func (s *Store) DeleteSession(sessionID string) error {
+	if !validID(sessionID) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.RemoveAll(filepath.Join(s.dir, sessionID))
+}
+
+// validID keeps IDs from clients from naming paths outside the store.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\:`)
+}
+
+func newID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("art_%d_%s", time.Now().UnixMilli(), hex.EncodeToString(b[:]))
+}
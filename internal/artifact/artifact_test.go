@@ -0,0 +1,110 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorePutGetAndDelete(t *testing.T) {
+	s := NewStore(t.TempDir())
+	a, err := s.Put("sess-1", "build.log", "text/plain", []byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Size != 11 || a.URI() != "artifact://sess-1/"+a.ID {
+		t.Fatalf("unexpected artifact %+v (%s)", a, a.URI())
+	}
+	if sessionID, id, ok := ParseURI(a.URI()); !ok || sessionID != "sess-1" || id != a.ID {
+		t.Fatalf("ParseURI(%q) = %q, %q, %v", a.URI(), sessionID, id, ok)
+	}
+
+	got, data, err := s.Get("sess-1", a.ID, 6, 3)
+	if err != nil || got.Name != "build.log" || string(data) != "wor" {
+		t.Fatalf("Get = %+v %q %v", got, data, err)
+	}
+	if _, data, _ := s.Get("sess-1", a.ID, 6, 0); string(data) != "world" {
+		t.Fatalf("expected the rest of the artifact, got %q", data)
+	}
+	for _, id := range []string{"../sess-1/" + a.ID, "missing", ".."} {
+		if _, _, err := s.Get("sess-1", id, 0, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+	if _, err := s.Put("../escape", "x", "text/plain", nil); err == nil {
+		t.Fatal("expected an invalid session ID to be rejected")
+	}
+
+	if err := s.DeleteSession("sess-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("sess-1", a.ID, 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the artifact to be deleted with its session, got %v", err)
+	}
+}
+
+type testCodec struct{}
+
+func (testCodec) Seal(data []byte) ([]byte, error) {
+	return []byte("sealed:" + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (testCodec) Open(data []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte("sealed:"))
+	if !ok {
+		return data, nil
+	}
+	return base64.StdEncoding.DecodeString(string(rest))
+}
+
+func TestStoreSealsArtifactsWithItsCodec(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	s.SetCodec(testCodec{})
+	a, err := s.Put("sess-1", "secrets.env", "text/plain", []byte("TOKEN=abc123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{a.ID, a.ID + ".json"} {
+		raw, err := os.ReadFile(filepath.Join(dir, "sess-1", name))
+		if err != nil || !bytes.HasPrefix(raw, []byte("sealed:")) {
+			t.Fatalf("expected %s to be sealed on disk, got %q (%v)", name, raw, err)
+		}
+	}
+	got, data, err := s.Get("sess-1", a.ID, 6, 3)
+	if err != nil || got.Name != "secrets.env" || got.Size != 12 || string(data) != "abc" {
+		t.Fatalf("Get = %+v %q %v", got, data, err)
+	}
+}
+
+func TestStoreKeepsTheNewestArtifactsOfASession(t *testing.T) {
+	s := NewStore(t.TempDir())
+	s.SetMaxPerSession(2)
+	var saved []Artifact
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		a, err := s.Put("sess-1", name, "text/plain", []byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, a)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if _, _, err := s.Get("sess-1", saved[0].ID, 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the oldest artifact to be pruned, got %v", err)
+	}
+	for _, a := range saved[1:] {
+		if _, data, err := s.Get("sess-1", a.ID, 0, 0); err != nil || string(data) != a.Name {
+			t.Fatalf("expected %s to be kept, got %q %v", a.Name, data, err)
+		}
+	}
+	if _, err := s.Put("sess-2", "other.log", "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("sess-1", saved[2].ID, 0, 0); err != nil {
+		t.Fatalf("expected other sessions not to count against the cap: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package audit appends tool executions, file writes, terminal commands and
+// permission decisions to a JSONL file per session. A nil *Log records
+// nothing. With a codec each line is sealed and written base64-encoded.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+// Entry kinds.
+const (
+	KindTool       = "tool"
+	KindFileWrite  = "file_write"
+	KindTerminal   = "terminal"
+	KindPermission = "permission"
+)
+
+// adapterFile holds entries that are not tied to a session, e.g. tools/call
+// without a sessionId.
+const adapterFile = "_adapter"
+
+type Entry struct {
+	Timestamp  time.Time      `json:"timestamp"`
+	SessionID  string         `json:"sessionId,omitempty"`
+	Kind       string         `json:"kind"`
+	Name       string         `json:"name"`
+	Outcome    string         `json:"outcome"`
+	Error      string         `json:"error,omitempty"`
+	DurationMs int64          `json:"durationMs,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Codec encrypts audit entries at rest. The session manager is one when
+// sessionEncryption is enabled.
+type Codec interface {
+	Seal(data []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+}
+
+type Log struct {
+	dir    string
+	logger *logging.Logger
+	codec  Codec
+	mu     sync.Mutex
+}
+
+// New returns the audit log, or nil when audit.enabled is false.
+func New(cfg config.AuditConfig, logger *logging.Logger) *Log {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Log{dir: cfg.Dir, logger: logger}
+}
+
+// SetCodec encrypts the entries written from now on with codec; List reads
+// both sealed and plain entries.
+func (l *Log) SetCodec(codec Codec) {
+	if l != nil {
+		l.codec = codec
+	}
+}
+
+// Record appends e to its session's audit file. Failures are logged, never
+// returned, so auditing cannot break the operation being audited.
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	if err := l.append(e); err != nil {
+		l.logger.Error("Failed to write audit entry", map[string]any{"sessionId": e.SessionID, "kind": e.Kind, "error": err.Error()})
+	}
+}
+
+func (l *Log) append(e Entry) error {
+	path, err := l.path(e.SessionID)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if l.codec != nil {
+		sealed, err := l.codec.Seal(line)
+		if err != nil {
+			return err
+		}
+		line = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(l.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (l *Log) path(sessionID string) (string, error) {
+	name := strings.TrimSpace(sessionID)
+	if name == "" {
+		name = adapterFile
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid session id for audit log: %q", sessionID)
+	}
+	return filepath.Join(l.dir, name+".jsonl"), nil
+}
+
+type ListOptions struct {
+	SessionID string
+	Kind      string
+	Since     time.Time
+	// Limit keeps the newest matching entries; 0 returns all of them.
+	Limit int
+}
+
+// List returns matching entries oldest first, and how many matched before
+// Limit was applied.
+func (l *Log) List(opts ListOptions) ([]Entry, int, error) {
+	if l == nil {
+		return nil, 0, errors.New("audit log is disabled; set audit.enabled")
+	}
+	path, err := l.path(opts.SessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line, ok := l.decode(scanner.Bytes())
+		var e Entry
+		if !ok || json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		if opts.Kind != "" && e.Kind != opts.Kind {
+			continue
+		}
+		if !opts.Since.IsZero() && e.Timestamp.Before(opts.Since) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	total := len(entries)
+	if opts.Limit > 0 && total > opts.Limit {
+		entries = entries[total-opts.Limit:]
+	}
+	return entries, total, nil
+}
+
+// decode returns the JSON of a line written by append, opening it when it
+// was sealed.
+func (l *Log) decode(line []byte) ([]byte, bool) {
+	if bytes.HasPrefix(line, []byte("{")) {
+		return line, true
+	}
+	if l.codec == nil {
+		return nil, false
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return nil, false
+	}
+	plain, err := l.codec.Open(sealed)
+	return plain, err == nil
+}
+
+// DeleteSession removes the audit file of sessionID.
+func (l *Log) DeleteSession(sessionID string) error {
+	if l == nil || strings.TrimSpace(sessionID) == "" {
+		return nil
+	}
+	path, err := l.path(sessionID)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func TestRecordAndList(t *testing.T) {
+	dir := t.TempDir()
+	log := New(config.AuditConfig{Enabled: true, Dir: dir}, logging.New("error"))
+
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	log.Record(Entry{Timestamp: old, SessionID: "s1", Kind: KindTool, Name: "write_file", Outcome: "success"})
+	log.Record(Entry{SessionID: "s1", Kind: KindPermission, Name: "Write main.go", Outcome: "reject-once"})
+	log.Record(Entry{SessionID: "s1", Kind: KindTool, Name: "read_file", Outcome: "failure", Error: "boom"})
+	log.Record(Entry{SessionID: "s2", Kind: KindTerminal, Name: "go test ./...", Outcome: "success"})
+	log.Record(Entry{SessionID: "../escape", Kind: KindTool, Name: "x", Outcome: "success"})
+
+	if info, err := os.Stat(filepath.Join(dir, "s1.jsonl")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected private per-session audit file, got %v %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("expected path-like session id to be rejected, got %v", err)
+	}
+
+	entries, total, err := log.List(ListOptions{SessionID: "s1"})
+	if err != nil || total != 3 || len(entries) != 3 || entries[0].Name != "write_file" || entries[2].Error != "boom" {
+		t.Fatalf("unexpected entries %+v total=%d err=%v", entries, total, err)
+	}
+	entries, total, _ = log.List(ListOptions{SessionID: "s1", Kind: KindTool, Limit: 1})
+	if total != 2 || len(entries) != 1 || entries[0].Name != "read_file" {
+		t.Fatalf("expected newest tool entry, got %+v total=%d", entries, total)
+	}
+	entries, _, _ = log.List(ListOptions{SessionID: "s1", Since: old.Add(time.Hour)})
+	if len(entries) != 2 {
+		t.Fatalf("expected since to drop the old entry, got %+v", entries)
+	}
+	if entries, total, err = log.List(ListOptions{SessionID: "unknown"}); err != nil || total != 0 || len(entries) != 0 {
+		t.Fatalf("expected empty list for session without audit file, got %+v %v", entries, err)
+	}
+
+	var disabled *Log
+	disabled.Record(Entry{Kind: KindTool})
+	if _, _, err := disabled.List(ListOptions{}); err == nil {
+		t.Fatal("expected List to fail when auditing is disabled")
+	}
+}
+
+// reverseCodec stands in for session encryption.
+type reverseCodec struct{}
+
+func (reverseCodec) Seal(data []byte) ([]byte, error) { return reversed(data), nil }
+func (reverseCodec) Open(data []byte) ([]byte, error) { return reversed(data), nil }
+
+func reversed(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func TestSealedEntriesAndDeleteSession(t *testing.T) {
+	dir := t.TempDir()
+	log := New(config.AuditConfig{Enabled: true, Dir: dir}, logging.New("error"))
+	log.Record(Entry{SessionID: "s1", Kind: KindTool, Name: "plain_before", Outcome: "success"})
+	log.SetCodec(reverseCodec{})
+	log.Record(Entry{SessionID: "s1", Kind: KindTerminal, Name: "echo secret", Outcome: "success"})
+
+	raw, err := os.ReadFile(filepath.Join(dir, "s1.jsonl"))
+	if err != nil || strings.Contains(string(raw), "echo secret") {
+		t.Fatalf("expected the entry to be sealed, got %q (%v)", raw, err)
+	}
+	entries, total, err := log.List(ListOptions{SessionID: "s1"})
+	if err != nil || total != 2 || entries[0].Name != "plain_before" || entries[1].Name != "echo secret" {
+		t.Fatalf("expected plain and sealed entries, got %+v total=%d err=%v", entries, total, err)
+	}
+
+	if err := log.DeleteSession("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "s1.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("expected the audit file to be removed, got %v", err)
+	}
+	if err := log.DeleteSession("s1"); err != nil {
+		t.Fatalf("deleting a missing audit file should succeed, got %v", err)
+	}
+}
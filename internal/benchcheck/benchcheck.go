@@ -0,0 +1,53 @@
+// Package benchcheck turns benchmarks into performance regression tests:
+// each package's budget test runs its hot-path benchmarks and fails when one
+// exceeds its budget. The checks only run when BENCH_BUDGETS is set (as
+// `make bench-check` does), so timing noise never fails a plain go test.
+package benchcheck
+
+import (
+	"os"
+	"testing"
+)
+
+// EnvVar enables the budget checks.
+const EnvVar = "BENCH_BUDGETS"
+
+// Budget is the most a benchmark may cost per operation. A zero field is
+// not checked. Time budgets are loose, several times what a laptop
+// measures, to catch order-of-magnitude regressions rather than noise;
+// allocation budgets are tighter since they do not vary between machines.
+type Budget struct {
+	NsPerOp     int64
+	AllocsPerOp int64
+	BytesPerOp  int64
+}
+
+// Check runs each benchmark in benchmarks and reports those over budget.
+func Check(t *testing.T, benchmarks map[string]func(*testing.B), budgets map[string]Budget) {
+	t.Helper()
+	if os.Getenv(EnvVar) == "" {
+		t.Skip("set " + EnvVar + "=1 (make bench-check) to check benchmark budgets")
+	}
+	for name, bench := range benchmarks {
+		budget, ok := budgets[name]
+		if !ok {
+			t.Errorf("%s: no budget", name)
+			continue
+		}
+		result := testing.Benchmark(bench)
+		if result.N == 0 {
+			t.Errorf("%s: benchmark failed", name)
+			continue
+		}
+		t.Logf("%s: %s %s", name, result.String(), result.MemString())
+		if budget.NsPerOp > 0 && result.NsPerOp() > budget.NsPerOp {
+			t.Errorf("%s: %d ns/op, budget %d", name, result.NsPerOp(), budget.NsPerOp)
+		}
+		if budget.AllocsPerOp > 0 && result.AllocsPerOp() > budget.AllocsPerOp {
+			t.Errorf("%s: %d allocs/op, budget %d", name, result.AllocsPerOp(), budget.AllocsPerOp)
+		}
+		if budget.BytesPerOp > 0 && result.AllocedBytesPerOp() > budget.BytesPerOp {
+			t.Errorf("%s: %d B/op, budget %d", name, result.AllocedBytesPerOp(), budget.BytesPerOp)
+		}
+	}
+}
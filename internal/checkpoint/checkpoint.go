@@ -0,0 +1,283 @@
+// Package checkpoint snapshots a session's working directory before each
+// agent turn so the changes made during the turn can be reverted.
+//
+// Git working trees are captured as commits built from a temporary index
+// (the user's index, HEAD and stash are never touched) and kept alive by a
+// private ref. Other directories fall back to copying files.
+package checkpoint
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	KindGit   = "git"
+	KindFiles = "files"
+
+	indexFile = "checkpoints.json"
+)
+
+var ErrNotFound = errors.New("checkpoint not found")
+
+type Checkpoint struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"sessionId"`
+	Cwd       string    `json:"cwd"`
+	Kind      string    `json:"kind"`
+	Label     string    `json:"label,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Commit is the snapshot commit for git checkpoints.
+	Commit string `json:"commit,omitempty"`
+	// Files is the number of files captured by a file-copy checkpoint.
+	Files int `json:"files,omitempty"`
+}
+
+type RestoreResult struct {
+	Checkpoint Checkpoint `json:"checkpoint"`
+	Restored   []string   `json:"restored"`
+	Removed    []string   `json:"removed"`
+}
+
+type Manager struct {
+	cfg    config.CheckpointConfig
+	dir    string
+	logger *logging.Logger
+
+	// envPolicy decides what git inherits; SetEnvironment replaces it.
+	envPolicy atomic.Pointer[envpolicy.Policy]
+
+	mu sync.Mutex
+}
+
+func NewManager(cfg config.Config, logger *logging.Logger) *Manager {
+	dir := cfg.Checkpoints.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.SessionDir, "checkpoints")
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	m := &Manager{cfg: cfg.Checkpoints, dir: dir, logger: logger}
+	m.SetEnvironment(cfg.Environment)
+	return m
+}
+
+// SetEnvironment replaces the environment policy applied to the git commands
+// checkpoints run.
+func (m *Manager) SetEnvironment(cfg config.EnvironmentConfig) {
+	m.envPolicy.Store(envpolicy.New(cfg))
+}
+
+func (m *Manager) git() gitRunner {
+	return gitRunner{inherited: m.envPolicy.Load().Filter(os.Environ())}
+}
+
+func (m *Manager) Enabled() bool {
+	return m.cfg.Enabled
+}
+
+// Create snapshots cwd for sessionID. label is a short human description
+// (typically the start of the prompt).
+func (m *Manager) Create(sessionID string, cwd string, label string, requestID string) (Checkpoint, error) {
+	if strings.TrimSpace(sessionID) == "" {
+		return Checkpoint{}, fmt.Errorf("sessionId is required")
+	}
+	if info, err := os.Stat(cwd); err != nil || !info.IsDir() {
+		return Checkpoint{}, fmt.Errorf("cwd is not a directory: %s", cwd)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := Checkpoint{
+		ID:        newID(),
+		SessionID: sessionID,
+		Cwd:       cwd,
+		Label:     truncateLabel(label),
+		RequestID: requestID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if git := m.git(); git.isGitWorkTree(cwd) {
+		commit, err := git.snapshot(cwd, cp)
+		if err != nil {
+			return Checkpoint{}, err
+		}
+		cp.Kind = KindGit
+		cp.Commit = commit
+	} else {
+		n, err := copySnapshot(cwd, m.snapshotDir(sessionID, cp.ID), m.dir, m.cfg.MaxFiles)
+		if err != nil {
+			_ = os.RemoveAll(m.snapshotDir(sessionID, cp.ID))
+			return Checkpoint{}, err
+		}
+		cp.Kind = KindFiles
+		cp.Files = n
+	}
+
+	list, err := m.load(sessionID)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	list = append(list, cp)
+	list = m.prune(list)
+	if err := m.save(sessionID, list); err != nil {
+		return Checkpoint{}, err
+	}
+	m.logger.Debug("Checkpoint created", map[string]any{"sessionId": sessionID, "checkpointId": cp.ID, "kind": cp.Kind})
+	return cp, nil
+}
+
+// List returns the checkpoints of a session, newest first.
+func (m *Manager) List(sessionID string) ([]Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list, err := m.load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Restore reverts the checkpoint's working directory to the snapshot: files
+// are rewritten from the snapshot and files created since are removed.
+// Ignored files are left alone.
+func (m *Manager) Restore(sessionID string, checkpointID string) (RestoreResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list, err := m.load(sessionID)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	var cp *Checkpoint
+	for i := range list {
+		if list[i].ID == checkpointID {
+			cp = &list[i]
+			break
+		}
+	}
+	if cp == nil {
+		return RestoreResult{}, fmt.Errorf("%w: %s", ErrNotFound, checkpointID)
+	}
+
+	result := RestoreResult{Checkpoint: *cp}
+	switch cp.Kind {
+	case KindGit:
+		result.Restored, result.Removed, err = m.git().restore(cp.Cwd, cp.Commit)
+	case KindFiles:
+		result.Restored, result.Removed, err = copyRestore(cp.Cwd, m.snapshotDir(sessionID, cp.ID), m.dir)
+	default:
+		err = fmt.Errorf("unknown checkpoint kind: %s", cp.Kind)
+	}
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	m.logger.Info("Checkpoint restored", map[string]any{"sessionId": sessionID, "checkpointId": cp.ID, "restored": len(result.Restored), "removed": len(result.Removed)})
+	return result, nil
+}
+
+// DeleteSession drops all checkpoints of a session.
+func (m *Manager) DeleteSession(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list, err := m.load(sessionID)
+	if err != nil {
+		return err
+	}
+	for _, cp := range list {
+		m.discard(cp)
+	}
+	return os.RemoveAll(m.sessionDir(sessionID))
+}
+
+func (m *Manager) prune(list []Checkpoint) []Checkpoint {
+	max := m.cfg.MaxPerSession
+	if max <= 0 || len(list) <= max {
+		return list
+	}
+	for _, cp := range list[:len(list)-max] {
+		m.discard(cp)
+	}
+	return append([]Checkpoint(nil), list[len(list)-max:]...)
+}
+
+func (m *Manager) discard(cp Checkpoint) {
+	switch cp.Kind {
+	case KindGit:
+		if _, err := m.git().run(cp.Cwd, nil, "update-ref", "-d", checkpointRef(cp.ID)); err != nil {
+			m.logger.Warn("Failed to delete checkpoint ref", map[string]any{"checkpointId": cp.ID, "error": err.Error()})
+		}
+	case KindFiles:
+		_ = os.RemoveAll(m.snapshotDir(cp.SessionID, cp.ID))
+	}
+}
+
+func (m *Manager) sessionDir(sessionID string) string {
+	return filepath.Join(m.dir, filepath.Base(sessionID))
+}
+
+func (m *Manager) snapshotDir(sessionID string, checkpointID string) string {
+	return filepath.Join(m.sessionDir(sessionID), checkpointID)
+}
+
+func (m *Manager) load(sessionID string) ([]Checkpoint, error) {
+	buf, err := os.ReadFile(filepath.Join(m.sessionDir(sessionID), indexFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Checkpoint{}, nil
+		}
+		return nil, err
+	}
+	var list []Checkpoint
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint index for session %s: %w", sessionID, err)
+	}
+	return list, nil
+}
+
+func (m *Manager) save(sessionID string, list []Checkpoint) error {
+	dir := m.sessionDir(sessionID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, indexFile+".tmp")
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, indexFile))
+}
+
+func newID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("cp_%d_%s", time.Now().UnixMilli(), hex.EncodeToString(b[:]))
+}
+
+func truncateLabel(label string) string {
+	runes := []rune(strings.Join(strings.Fields(label), " "))
+	if len(runes) > 80 {
+		return string(runes[:77]) + "..."
+	}
+	return string(runes)
+}
@@ -0,0 +1,189 @@
+package checkpoint
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	return NewManager(cfg, logging.NewWithOutput("error", io.Discard))
+}
+
+func runGit(dir string, env []string, args ...string) (string, error) {
+	return gitRunner{inherited: os.Environ()}.run(dir, env, args...)
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		full := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(buf)
+}
+
+func TestGitCheckpointRestore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":    "package main\n",
+		"doc.md":     "docs\n",
+		".gitignore": "*.log\n",
+		"wip.txt":    "untracked before turn\n",
+	})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "main.go", "doc.md", ".gitignore"}} {
+		if _, err := runGit(dir, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := runGit(dir, identityEnv(), "commit", "-q", "-m", "init"); err != nil {
+		t.Fatal(err)
+	}
+	headBefore, _ := runGit(dir, nil, "rev-parse", "HEAD")
+
+	m := newTestManager(t)
+	cp, err := m.Create("s1", dir, "refactor main", "req-1")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if cp.Kind != KindGit || cp.Commit == "" {
+		t.Fatalf("expected git checkpoint, got %#v", cp)
+	}
+
+	// Simulate an agent turn.
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n", "new/file.go": "package new\n", "debug.log": "ignored\n", "wip.txt": "changed\n"})
+	if err := os.Remove(filepath.Join(dir, "doc.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.Restore("s1", cp.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	sort.Strings(result.Restored)
+	if strings.Join(result.Restored, ",") != "doc.md,main.go,wip.txt" || strings.Join(result.Removed, ",") != "new/file.go" {
+		t.Fatalf("unexpected restore result: %#v", result)
+	}
+	if readFile(t, filepath.Join(dir, "main.go")) != "package main\n" || readFile(t, filepath.Join(dir, "doc.md")) != "docs\n" || readFile(t, filepath.Join(dir, "wip.txt")) != "untracked before turn\n" {
+		t.Fatalf("files were not restored")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected new directory to be removed, got %v", err)
+	}
+	if readFile(t, filepath.Join(dir, "debug.log")) != "ignored\n" {
+		t.Fatalf("ignored files must be left alone")
+	}
+	if headAfter, _ := runGit(dir, nil, "rev-parse", "HEAD"); headAfter != headBefore {
+		t.Fatalf("HEAD moved from %s to %s", headBefore, headAfter)
+	}
+	if staged, _ := runGit(dir, nil, "diff", "--cached", "--name-only"); strings.TrimSpace(staged) != "" {
+		t.Fatalf("user index was modified: %q", staged)
+	}
+}
+
+func TestFileCheckpointRestoreAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "one\n", "sub/b.txt": "two\n"})
+
+	m := newTestManager(t)
+	m.cfg.MaxPerSession = 2
+	first, err := m.Create("s1", dir, "first", "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if first.Kind != KindFiles || first.Files != 2 {
+		t.Fatalf("expected file checkpoint of 2 files, got %#v", first)
+	}
+
+	writeFiles(t, dir, map[string]string{"a.txt": "edited\n", "c.txt": "new\n"})
+	result, err := m.Restore("s1", first.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if strings.Join(result.Restored, ",") != "a.txt" || strings.Join(result.Removed, ",") != "c.txt" {
+		t.Fatalf("unexpected restore result: %#v", result)
+	}
+	if readFile(t, filepath.Join(dir, "a.txt")) != "one\n" {
+		t.Fatalf("a.txt was not restored")
+	}
+
+	for _, label := range []string{"second", "third"} {
+		if _, err := m.Create("s1", dir, label, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := m.List("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Label != "third" || list[1].Label != "second" {
+		t.Fatalf("expected two newest checkpoints, got %#v", list)
+	}
+	if _, err := m.Restore("s1", first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected pruned checkpoint to be gone, got %v", err)
+	}
+	if _, err := os.Stat(m.snapshotDir("s1", first.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected pruned snapshot files to be deleted")
+	}
+}
+
+func TestGitCheckpointStaysInsideCwd(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{"app/main.go": "package main\n", "other/notes.txt": "notes\n"})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}} {
+		if _, err := runGit(repo, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := runGit(repo, identityEnv(), "commit", "-q", "-m", "init"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t)
+	cp, err := m.Create("s1", filepath.Join(repo, "app"), "edit", "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	writeFiles(t, repo, map[string]string{"app/main.go": "package app\n", "app/extra.go": "package app\n", "other/notes.txt": "edited elsewhere\n", "other/new.txt": "new\n"})
+
+	result, err := m.Restore("s1", cp.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if strings.Join(result.Restored, ",") != "app/main.go" || strings.Join(result.Removed, ",") != "app/extra.go" {
+		t.Fatalf("expected only files under the cwd to be restored, got %#v", result)
+	}
+	if readFile(t, filepath.Join(repo, "other", "notes.txt")) != "edited elsewhere\n" || readFile(t, filepath.Join(repo, "other", "new.txt")) != "new\n" {
+		t.Fatalf("files outside the cwd were touched")
+	}
+}
@@ -0,0 +1,130 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spjoes/cursor-agent-acp/internal/ignore"
+)
+
+const manifestFile = "manifest.json"
+
+type manifestEntry struct {
+	Path string      `json:"path"`
+	Mode os.FileMode `json:"mode"`
+}
+
+// copySnapshot copies every non-ignored regular file under cwd into dest.
+// exclude (the checkpoint store itself) is skipped when it lives under cwd.
+func copySnapshot(cwd string, dest string, exclude string, maxFiles int) (int, error) {
+	entries, err := snapshotFiles(cwd, exclude)
+	if err != nil {
+		return 0, err
+	}
+	if maxFiles > 0 && len(entries) > maxFiles {
+		return 0, fmt.Errorf("%s has %d files, more than checkpoints.maxFiles (%d); use a git repository for large workspaces", cwd, len(entries), maxFiles)
+	}
+
+	manifest := make([]manifestEntry, 0, len(entries))
+	for rel, mode := range entries {
+		src := filepath.Join(cwd, filepath.FromSlash(rel))
+		if err := copyFile(src, filepath.Join(dest, "files", filepath.FromSlash(rel)), 0o600); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return 0, err
+		}
+		manifest = append(manifest, manifestEntry{Path: rel, Mode: mode})
+	}
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dest, manifestFile), buf, 0o600); err != nil {
+		return 0, err
+	}
+	return len(manifest), nil
+}
+
+func copyRestore(cwd string, src string, exclude string) ([]string, []string, error) {
+	buf, err := os.ReadFile(filepath.Join(src, manifestFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("checkpoint snapshot is missing: %w", err)
+	}
+	var manifest []manifestEntry
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid checkpoint manifest: %w", err)
+	}
+	current, err := snapshotFiles(cwd, exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restored := make([]string, 0)
+	removed := make([]string, 0)
+	inSnapshot := make(map[string]bool, len(manifest))
+	for _, entry := range manifest {
+		inSnapshot[entry.Path] = true
+		saved := filepath.Join(src, "files", filepath.FromSlash(entry.Path))
+		target := filepath.Join(cwd, filepath.FromSlash(entry.Path))
+		if _, exists := current[entry.Path]; exists && sameContent(saved, target) {
+			continue
+		}
+		if err := copyFile(saved, target, entry.Mode.Perm()); err != nil {
+			return nil, nil, err
+		}
+		_ = os.Chmod(target, entry.Mode.Perm())
+		restored = append(restored, entry.Path)
+	}
+	for rel := range current {
+		if inSnapshot[rel] {
+			continue
+		}
+		full := filepath.Join(cwd, filepath.FromSlash(rel))
+		if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		removeEmptyParents(cwd, filepath.Dir(full))
+		removed = append(removed, rel)
+	}
+	return restored, removed, nil
+}
+
+func snapshotFiles(cwd string, exclude string) (map[string]os.FileMode, error) {
+	files := map[string]os.FileMode{}
+	err := ignore.Walk(cwd, nil, func(p string, rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if exclude != "" && p == exclude {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[rel] = info.Mode()
+		return nil
+	})
+	return files, err
+}
+
+func sameContent(a string, b string) bool {
+	ab, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	bb, err := os.ReadFile(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
@@ -0,0 +1,243 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	gitTimeout = 2 * time.Minute
+	refPrefix  = "refs/cursor-agent-acp/checkpoints/"
+)
+
+func checkpointRef(id string) string {
+	return refPrefix + id
+}
+
+// gitRunner runs git with inherited as the environment it starts from.
+type gitRunner struct {
+	inherited []string
+}
+
+func (g gitRunner) isGitWorkTree(dir string) bool {
+	out, err := g.run(dir, nil, "rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// snapshot records the working tree under dir (tracked and untracked,
+// non-ignored files) as a commit referenced by a private ref. The rest of
+// the repository is recorded as it is in the user's index.
+func (g gitRunner) snapshot(dir string, cp Checkpoint) (string, error) {
+	top, pathspec, err := g.scope(dir)
+	if err != nil {
+		return "", err
+	}
+	tree, err := g.worktreeTree(top, pathspec)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"commit-tree", tree, "-m", fmt.Sprintf("cursor-agent-acp checkpoint %s\n\nsession: %s\n%s", cp.ID, cp.SessionID, cp.Label)}
+	if head, err := g.run(top, nil, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+		args = append(args, "-p", strings.TrimSpace(head))
+	}
+	commit, err := g.run(top, identityEnv(), args...)
+	if err != nil {
+		return "", err
+	}
+	commit = strings.TrimSpace(commit)
+	if _, err := g.run(top, nil, "update-ref", checkpointRef(cp.ID), commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// restore brings the working tree under dir back to the snapshot commit
+// without touching HEAD, the user's index or files outside dir.
+func (g gitRunner) restore(dir string, commit string) ([]string, []string, error) {
+	top, pathspec, err := g.scope(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := g.worktreeTree(top, pathspec)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := g.run(top, nil, "diff-tree", "-r", "-z", "--no-renames", "--name-status", commit+"^{tree}", current, "--", pathspec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restored := make([]string, 0)
+	removed := make([]string, 0)
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		if status == "A" {
+			removed = append(removed, path)
+		} else {
+			restored = append(restored, path)
+		}
+	}
+
+	for _, rel := range removed {
+		full := filepath.Join(top, filepath.FromSlash(rel))
+		if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		removeEmptyParents(top, filepath.Dir(full))
+	}
+
+	if len(restored) > 0 {
+		index, cleanup, err := tempIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer cleanup()
+		env := []string{"GIT_INDEX_FILE=" + index}
+		if _, err := g.run(top, env, "read-tree", commit); err != nil {
+			return nil, nil, err
+		}
+		stdin := strings.Join(restored, "\x00") + "\x00"
+		if _, err := g.runInput(top, env, stdin, "checkout-index", "-f", "-z", "--stdin"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return restored, removed, nil
+}
+
+// worktreeTree writes the current working tree under pathspec to the object
+// store using a throwaway index seeded from the real one (for its stat
+// cache).
+func (g gitRunner) worktreeTree(top string, pathspec string) (string, error) {
+	index, cleanup, err := tempIndex()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	if real, err := g.run(top, nil, "rev-parse", "--git-path", "index"); err == nil {
+		realPath := strings.TrimSpace(real)
+		if !filepath.IsAbs(realPath) {
+			realPath = filepath.Join(top, realPath)
+		}
+		_ = copyFile(realPath, index, 0o600)
+	}
+	env := []string{"GIT_INDEX_FILE=" + index}
+	if _, err := g.run(top, env, "add", "-A", "--", pathspec); err != nil {
+		return "", err
+	}
+	tree, err := g.run(top, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(tree), nil
+}
+
+// scope returns the top level of dir's repository and a pathspec, run
+// from the top level, that covers dir.
+func (g gitRunner) scope(dir string) (string, string, error) {
+	out, err := g.run(dir, nil, "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		return "", "", err
+	}
+	top, prefix, _ := strings.Cut(strings.TrimRight(out, "\n"), "\n")
+	if prefix == "" {
+		return top, ".", nil
+	}
+	return top, ":(literal)" + prefix, nil
+}
+
+func tempIndex() (string, func(), error) {
+	f, err := os.CreateTemp("", "cursor-agent-acp-index-*")
+	if err != nil {
+		return "", nil, err
+	}
+	name := f.Name()
+	_ = f.Close()
+	_ = os.Remove(name)
+	return name, func() { _ = os.Remove(name) }, nil
+}
+
+// identityEnv supplies a committer identity so snapshots work in repos
+// without user.name/user.email configured.
+func identityEnv() []string {
+	env := make([]string, 0, 4)
+	for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		if os.Getenv(key) == "" {
+			env = append(env, key+"=cursor-agent-acp")
+		}
+	}
+	for _, key := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		if os.Getenv(key) == "" {
+			env = append(env, key+"=cursor-agent-acp@localhost")
+		}
+	}
+	return env
+}
+
+// run runs git in dir with env (KEY=VALUE pairs) added to the inherited
+// environment.
+func (g gitRunner) run(dir string, env []string, args ...string) (string, error) {
+	return g.runInput(dir, env, "", args...)
+}
+
+func (g gitRunner) runInput(dir string, env []string, stdin string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(append(slices.Clip(g.inherited), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C"), env...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+func removeEmptyParents(root string, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
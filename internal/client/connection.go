@@ -1,13 +1,18 @@
 package client
 
+import "context"
+
+// Connection issues requests to the ACP client. Cancelling ctx abandons the
+// wait for the client's reply.
 type Connection interface {
-	ReadTextFile(params ReadTextFileRequest) (ReadTextFileResponse, error)
-	WriteTextFile(params WriteTextFileRequest) (WriteTextFileResponse, error)
-	CreateTerminal(params CreateTerminalRequest) (CreateTerminalResponse, error)
-	GetTerminalOutput(params TerminalOutputRequest) (TerminalOutputResponse, error)
-	WaitForTerminalExit(params WaitForTerminalExitRequest) (WaitForTerminalExitResponse, error)
-	KillTerminal(params KillTerminalRequest) error
-	ReleaseTerminal(params ReleaseTerminalRequest) error
+	ReadTextFile(ctx context.Context, params ReadTextFileRequest) (ReadTextFileResponse, error)
+	WriteTextFile(ctx context.Context, params WriteTextFileRequest) (WriteTextFileResponse, error)
+	ListDirectory(ctx context.Context, params ListDirectoryRequest) (ListDirectoryResponse, error)
+	CreateTerminal(ctx context.Context, params CreateTerminalRequest) (CreateTerminalResponse, error)
+	GetTerminalOutput(ctx context.Context, params TerminalOutputRequest) (TerminalOutputResponse, error)
+	WaitForTerminalExit(ctx context.Context, params WaitForTerminalExitRequest) (WaitForTerminalExitResponse, error)
+	KillTerminal(ctx context.Context, params KillTerminalRequest) error
+	ReleaseTerminal(ctx context.Context, params ReleaseTerminalRequest) error
 }
 
 type ReadTextFileRequest struct {
@@ -29,6 +34,24 @@ type WriteTextFileRequest struct {
 
 type WriteTextFileResponse struct{}
 
+type ListDirectoryRequest struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+}
+
+type DirectoryEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // "file", "directory" or "symlink"
+	Size int64  `json:"size,omitempty"`
+}
+
+type ListDirectoryResponse struct {
+	Entries []DirectoryEntry `json:"entries"`
+}
+
 type EnvVariable struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
@@ -53,6 +76,7 @@ type TerminalExitStatus struct {
 }
 
 type TerminalOutputRequest struct {
+	SessionID  string `json:"sessionId,omitempty"`
 	TerminalID string `json:"terminalId"`
 }
 
@@ -63,6 +87,7 @@ type TerminalOutputResponse struct {
 }
 
 type WaitForTerminalExitRequest struct {
+	SessionID  string `json:"sessionId,omitempty"`
 	TerminalID string `json:"terminalId"`
 }
 
@@ -72,9 +97,11 @@ type WaitForTerminalExitResponse struct {
 }
 
 type KillTerminalRequest struct {
+	SessionID  string `json:"sessionId,omitempty"`
 	TerminalID string `json:"terminalId"`
 }
 
 type ReleaseTerminalRequest struct {
+	SessionID  string `json:"sessionId,omitempty"`
 	TerminalID string `json:"terminalId"`
 }
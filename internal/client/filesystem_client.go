@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
@@ -19,9 +20,17 @@ type WriteFileOptions struct {
 	Content   string
 }
 
+type ListDirectoryOptions struct {
+	SessionID string
+	Path      string
+	Recursive bool
+	Pattern   string
+}
+
 type FileSystemClient interface {
-	ReadTextFile(options ReadFileOptions) (string, error)
-	WriteTextFile(options WriteFileOptions) error
+	ReadTextFile(ctx context.Context, options ReadFileOptions) (string, error)
+	WriteTextFile(ctx context.Context, options WriteFileOptions) error
+	ListDirectory(ctx context.Context, options ListDirectoryOptions) ([]DirectoryEntry, error)
 }
 
 type ACPFileSystemClient struct {
@@ -33,7 +42,7 @@ func NewACPFileSystemClient(conn Connection, logger *logging.Logger) *ACPFileSys
 	return &ACPFileSystemClient{conn: conn, logger: logger}
 }
 
-func (c *ACPFileSystemClient) ReadTextFile(options ReadFileOptions) (string, error) {
+func (c *ACPFileSystemClient) ReadTextFile(ctx context.Context, options ReadFileOptions) (string, error) {
 	req := ReadTextFileRequest{SessionID: options.SessionID, Path: options.Path}
 	if options.Line > 0 {
 		req.Line = options.Line
@@ -41,15 +50,15 @@ func (c *ACPFileSystemClient) ReadTextFile(options ReadFileOptions) (string, err
 	if options.Limit > 0 {
 		req.Limit = options.Limit
 	}
-	resp, err := c.conn.ReadTextFile(req)
+	resp, err := c.conn.ReadTextFile(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file %q: %w", options.Path, err)
 	}
 	return resp.Content, nil
 }
 
-func (c *ACPFileSystemClient) WriteTextFile(options WriteFileOptions) error {
-	_, err := c.conn.WriteTextFile(WriteTextFileRequest{
+func (c *ACPFileSystemClient) WriteTextFile(ctx context.Context, options WriteFileOptions) error {
+	_, err := c.conn.WriteTextFile(ctx, WriteTextFileRequest{
 		SessionID: options.SessionID,
 		Path:      options.Path,
 		Content:   options.Content,
@@ -59,3 +68,16 @@ func (c *ACPFileSystemClient) WriteTextFile(options WriteFileOptions) error {
 	}
 	return nil
 }
+
+func (c *ACPFileSystemClient) ListDirectory(ctx context.Context, options ListDirectoryOptions) ([]DirectoryEntry, error) {
+	resp, err := c.conn.ListDirectory(ctx, ListDirectoryRequest{
+		SessionID: options.SessionID,
+		Path:      options.Path,
+		Recursive: options.Recursive,
+		Pattern:   options.Pattern,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %q: %w", options.Path, err)
+	}
+	return resp.Entries, nil
+}
@@ -0,0 +1,151 @@
+// Package clientcaps answers what the connected ACP client declared in
+// initialize, so the adapter only calls client methods, sends updates and
+// registers tools the client can handle.
+package clientcaps
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// baselineUpdates are the session/update kinds every ACP client handles.
+var baselineUpdates = []string{
+	"user_message_chunk",
+	"agent_message_chunk",
+	"agent_thought_chunk",
+	"tool_call",
+	"tool_call_update",
+	"plan",
+}
+
+// methodCapability maps client methods to the capability that enables them.
+var methodCapability = map[string]string{
+	"fs/read_text_file":  "fs.readTextFile",
+	"fs/write_text_file": "fs.writeTextFile",
+	"fs/list_directory":  "fs.listDirectory",
+}
+
+// Capabilities is safe for concurrent use. Until Set is called (initialize
+// has not run yet) every feature is reported as supported.
+type Capabilities struct {
+	mu    sync.RWMutex
+	raw   map[string]any
+	known bool
+}
+
+func New() *Capabilities {
+	return &Capabilities{}
+}
+
+// Set records the clientCapabilities object from initialize.
+func (c *Capabilities) Set(raw map[string]any) {
+	if raw == nil {
+		raw = map[string]any{}
+	}
+	c.mu.Lock()
+	c.raw, c.known = raw, true
+	c.mu.Unlock()
+}
+
+// Raw returns the clientCapabilities object as sent, or nil before
+// initialize.
+func (c *Capabilities) Raw() map[string]any {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.raw
+}
+
+// Has reports whether the capability at the dotted path (e.g.
+// "fs.readTextFile" or "terminal") is true.
+func (c *Capabilities) Has(path string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.known {
+		return true
+	}
+	v, ok := lookup(c.raw, path)
+	b, isBool := v.(bool)
+	return ok && isBool && b
+}
+
+func (c *Capabilities) ReadTextFile() bool  { return c.Has("fs.readTextFile") }
+func (c *Capabilities) WriteTextFile() bool { return c.Has("fs.writeTextFile") }
+func (c *Capabilities) Terminal() bool      { return c.Has("terminal") }
+
+// EchoUserMessages reports whether the client asked, with
+// _meta.echoUserMessages, to have its prompts sent back as
+// user_message_chunk updates. Editors that show prompts themselves leave it
+// unset.
+func (c *Capabilities) EchoUserMessages() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, _ := lookup(c.raw, "_meta.echoUserMessages")
+	echo, _ := v.(bool)
+	return echo
+}
+
+// SupportsMethod reports whether the client declared the capability behind
+// an agent-to-client method. Methods without a capability are always
+// allowed.
+func (c *Capabilities) SupportsMethod(method string) (capability string, ok bool) {
+	if strings.HasPrefix(method, "terminal/") {
+		return "terminal", c.Terminal()
+	}
+	if capability, gated := methodCapability[method]; gated {
+		return capability, c.Has(capability)
+	}
+	return "", true
+}
+
+// SupportsSessionUpdate reports whether a session/update of this kind may be
+// sent. Kinds beyond the baseline are sent unless the client lists the ones
+// it handles in _meta.sessionUpdates.
+func (c *Capabilities) SupportsSessionUpdate(kind string) bool {
+	if slices.Contains(baselineUpdates, kind) {
+		return true
+	}
+	return c.listed("_meta.sessionUpdates", kind)
+}
+
+// SupportsNotification reports whether an extension notification (a method
+// starting with "_") may be sent. They are sent unless the client lists the
+// ones it handles in _meta.notifications.
+func (c *Capabilities) SupportsNotification(method string) bool {
+	if !strings.HasPrefix(method, "_") {
+		return true
+	}
+	return c.listed("_meta.notifications", method)
+}
+
+// listed reports whether value appears in the string list at path, treating
+// a missing list as "everything".
+func (c *Capabilities) listed(path, value string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := lookup(c.raw, path)
+	if !c.known || !ok {
+		return true
+	}
+	items, _ := v.([]any)
+	for _, item := range items {
+		if s, _ := item.(string); s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(m map[string]any, path string) (any, bool) {
+	var cur any = m
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,41 @@
+package clientcaps
+
+import "testing"
+
+func TestCapabilitiesGateFeatures(t *testing.T) {
+	caps := New()
+	if !caps.Terminal() || !caps.SupportsSessionUpdate("available_commands_update") {
+		t.Fatal("expected everything to be allowed before initialize")
+	}
+
+	caps.Set(map[string]any{
+		"fs": map[string]any{"readTextFile": true, "writeTextFile": false},
+		"_meta": map[string]any{
+			"sessionUpdates": []any{"current_mode_update"},
+			"notifications":  []any{"_cursor/models_updated"},
+		},
+	})
+	if caps.EchoUserMessages() {
+		t.Fatal("expected prompt echo to be opt-in")
+	}
+	if !caps.ReadTextFile() || caps.WriteTextFile() || caps.Terminal() {
+		t.Fatal("unexpected fs/terminal capabilities")
+	}
+	if capability, ok := caps.SupportsMethod("terminal/create"); ok || capability != "terminal" {
+		t.Fatalf("expected terminal/create to be refused, got %q %v", capability, ok)
+	}
+	if _, ok := caps.SupportsMethod("session/request_permission"); !ok {
+		t.Fatal("expected ungated methods to be allowed")
+	}
+	if !caps.SupportsSessionUpdate("tool_call") || !caps.SupportsSessionUpdate("current_mode_update") || caps.SupportsSessionUpdate("available_commands_update") {
+		t.Fatal("unexpected session update gating")
+	}
+	if !caps.SupportsNotification("session/update") || !caps.SupportsNotification("_cursor/models_updated") || caps.SupportsNotification("_adapter/config_changed") {
+		t.Fatal("unexpected notification gating")
+	}
+
+	caps.Set(nil)
+	if !caps.SupportsSessionUpdate("available_commands_update") || caps.Terminal() {
+		t.Fatal("expected lists to default to everything and flags to false once initialized")
+	}
+}
@@ -4,24 +4,310 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"unicode"
 )
 
 type Config struct {
-	LogLevel       string       `json:"logLevel"`
-	SessionDir     string       `json:"sessionDir"`
-	MaxSessions    int          `json:"maxSessions"`
-	SessionTimeout int64        `json:"sessionTimeout"` // milliseconds
-	Tools          ToolsConfig  `json:"tools"`
-	Cursor         CursorConfig `json:"cursor"`
+	LogLevel string `json:"logLevel"`
+	// Locale selects the language of the adapter's own messages to the
+	// user (e.g. "en", "de", "pt-BR"); locales without a catalog use
+	// English.
+	Locale string `json:"locale,omitempty"`
+	// LogFile sends logs to a file instead of stderr, rotated per
+	// LogRotation.
+	LogFile        string            `json:"logFile,omitempty"`
+	LogRotation    LogRotationConfig `json:"logRotation"`
+	SessionDir     string            `json:"sessionDir"`
+	MaxSessions    int               `json:"maxSessions"`
+	SessionTimeout int64             `json:"sessionTimeout"` // milliseconds
+	// ShutdownTimeout bounds how long shutdown waits for cancelled requests
+	// to finish before exiting, in milliseconds.
+	ShutdownTimeout int64 `json:"shutdownTimeout,omitempty"`
+	// MaxMessageBytes bounds one incoming JSON-RPC message. A longer one is
+	// skipped and answered with a parse error; the connection stays up. 0
+	// leaves messages unbounded.
+	MaxMessageBytes int64 `json:"maxMessageBytes,omitempty"`
+	// StdioFallback sends the updates of a session whose clients all
+	// disconnected to the stdio client. Off by default: such updates are
+	// dropped with a warning.
+	StdioFallback bool `json:"stdioFallback,omitempty"`
+	// ReplayUpdates is how many recent session/update notifications are
+	// kept per session for session/replay_updates. 0 keeps none.
+	ReplayUpdates int          `json:"replayUpdates"`
+	Tools         ToolsConfig  `json:"tools"`
+	Cursor        CursorConfig `json:"cursor"`
+
+	SessionEncryption SessionEncryptionConfig `json:"sessionEncryption"`
+	Checkpoints       CheckpointConfig        `json:"checkpoints"`
+	Prompt            PromptConfig            `json:"prompt"`
+	Tracing           TracingConfig           `json:"tracing"`
+	Audit             AuditConfig             `json:"audit"`
+	Redaction         RedactionConfig         `json:"redaction"`
+	Environment       EnvironmentConfig       `json:"environment"`
+	Defaults          SessionDefaultsConfig   `json:"defaults"`
+	Models            ModelsConfig            `json:"models"`
+	ClientRequests    ClientRequestsConfig    `json:"clientRequests"`
+}
+
+// ClientRequestsConfig bounds the requests the adapter sends the client
+// (fs/*, terminal/*, session/request_permission).
+type ClientRequestsConfig struct {
+	// TimeoutMs bounds waiting for the client's answer; Timeouts overrides
+	// it per method (e.g. "fs/read_text_file"). 0 means no limit.
+	// terminal/wait_for_exit is only limited by a Timeouts entry.
+	TimeoutMs int64            `json:"timeoutMs"`
+	Timeouts  map[string]int64 `json:"timeouts,omitempty"`
+	// Retries re-sends an idempotent read (fs/read_text_file,
+	// fs/list_directory) that timed out, RetryDelayMs apart.
+	Retries      int   `json:"retries,omitempty"`
+	RetryDelayMs int64 `json:"retryDelayMs,omitempty"`
+	// HeartbeatMs is how often a terminal/wait_for_exit in progress checks,
+	// with terminal/output, that the client still has the terminal. 0
+	// disables the check.
+	HeartbeatMs int64 `json:"heartbeatMs,omitempty"`
+}
+
+// ModelsConfig describes and restricts the cursor-agent models sessions can
+// use. Patterns are case-insensitive model ID globs (e.g. "gpt-5*").
+type ModelsConfig struct {
+	// Info adds to or replaces the built-in model metadata, keyed by model
+	// ID or glob. An exact ID wins over globs, and longer globs over
+	// shorter ones.
+	Info map[string]ModelInfo `json:"info,omitempty"`
+	// A model can be selected when it matches Allow (or Allow is empty)
+	// and does not match Deny.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Aliases are extra names for models, e.g. "fast": "gpt-5-mini".
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// UnmarshalJSON also reads the earlier form of models, a map of model info
+// keyed by ID or glob, which now lives under models.info: keys other than
+// info, allow, deny and aliases are read as models.info entries. Entries
+// under info win.
+func (m *ModelsConfig) UnmarshalJSON(data []byte) error {
+	type plain ModelsConfig
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	legacy := map[string]ModelInfo{}
+	for key, value := range raw {
+		if slices.ContainsFunc([]string{"info", "allow", "deny", "aliases"}, func(field string) bool { return strings.EqualFold(key, field) }) {
+			continue
+		}
+		var info ModelInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return fmt.Errorf("models.%s: %w", key, err)
+		}
+		legacy[key] = info
+	}
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+	var listed map[string]json.RawMessage
+	_ = json.Unmarshal(raw["info"], &listed)
+	for key, info := range legacy {
+		if _, ok := listed[key]; ok {
+			continue
+		}
+		if m.Info == nil {
+			m.Info = map[string]ModelInfo{}
+		}
+		m.Info[key] = info
+	}
+	return nil
+}
+
+// ModelInfo describes a model for model pickers and prompt handling.
+// Vision gates whether image blocks are attached for the model.
+type ModelInfo struct {
+	ContextWindow int    `json:"contextWindow,omitempty"` // tokens
+	Vision        bool   `json:"vision"`
+	Audio         bool   `json:"audio"`
+	Reasoning     bool   `json:"reasoning"`
+	Tier          string `json:"tier,omitempty"` // "fast", "standard" or "premium"
+	// Prices in USD per million tokens, used to estimate what a turn cost.
+	InputCostPerMTok  float64 `json:"inputCostPerMTok,omitempty"`
+	OutputCostPerMTok float64 `json:"outputCostPerMTok,omitempty"`
+}
+
+// SessionDefaultsConfig is the model and mode new sessions start in unless
+// session/new metadata names another. Model falls back to "auto" when
+// cursor-agent does not offer it.
+type SessionDefaultsConfig struct {
+	Model string `json:"model,omitempty"`
+	Mode  string `json:"mode,omitempty"`
+}
+
+// EnvironmentConfig controls which environment variables reach cursor-agent
+// processes and the terminals the adapter creates. Names are case-insensitive
+// globs; a variable matching Allow is always passed, otherwise one matching
+// Deny is dropped from the inherited environment and rejected when a tool
+// call or session override asks for it. Deny ["*"] turns Allow into a strict
+// allowlist.
+type EnvironmentConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// RedactionConfig masks secrets before they are logged or sent as tool_call
+// rawInput/rawOutput. Patterns are Go regular expressions added to the
+// built-in ones (AWS keys, bearer tokens, GitHub and API tokens, JWTs,
+// private keys).
+type RedactionConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Patterns        []string `json:"patterns,omitempty"`
+	DisableDefaults bool     `json:"disableDefaults,omitempty"`
+}
+
+// AuditConfig appends tool executions, file writes, terminal commands and
+// permission decisions to <dir>/<sessionId>.jsonl.
+type AuditConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir,omitempty"` // defaults to <sessionDir>/audit
+}
+
+// TracingConfig exports OpenTelemetry traces over OTLP/HTTP (JSON). The
+// standard OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_SERVICE_NAME variables also enable it
+// and take precedence; OTEL_SDK_DISABLED=true turns it off.
+type TracingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint is the collector's base URL (/v1/traces is appended) or the
+	// full traces URL. Defaults to http://localhost:4318.
+	Endpoint    string            `json:"endpoint,omitempty"`
+	ServiceName string            `json:"serviceName,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type LogRotationConfig struct {
+	MaxSize    int64 `json:"maxSize,omitempty"` // bytes, 0 disables size-based rotation
+	MaxAge     int64 `json:"maxAge,omitempty"`  // milliseconds, 0 disables age-based rotation
+	MaxBackups int   `json:"maxBackups,omitempty"`
+	Compress   bool  `json:"compress"`
+}
+
+type PromptConfig struct {
+	// AttachImages writes image blocks to temp files that cursor-agent can
+	// open, instead of replacing them with a text placeholder.
+	AttachImages  bool   `json:"attachImages"`
+	AttachmentDir string `json:"attachmentDir,omitempty"` // defaults to the OS temp dir
+	// AudioModels are model ID globs (e.g. "gemini*") for models that accept
+	// audio. Audio blocks sent to them are written to temp files like images;
+	// other models get a text placeholder.
+	AudioModels []string `json:"audioModels,omitempty"`
+	// Resources larger than ResourceInlineLimit bytes, or past the
+	// MaxInlineBytes budget for the whole prompt, are written to temp files
+	// and referenced by path. ResourceInlineLimit 0 inlines everything.
+	ResourceInlineLimit int `json:"resourceInlineLimit,omitempty"`
+	MaxInlineBytes      int `json:"maxInlineBytes,omitempty"`
+	// Streamed text is batched into one agent_message_chunk for up to
+	// CoalesceWindowMs or CoalesceBytes, flushing early at newlines and code
+	// fences. CoalesceWindowMs 0 sends every chunk as it arrives.
+	CoalesceWindowMs int `json:"coalesceWindowMs,omitempty"`
+	CoalesceBytes    int `json:"coalesceBytes,omitempty"`
+	// ProjectRules prepends .cursorrules, .cursor/rules/*.mdc and AGENTS.md
+	// from the session cwd to every prompt, capped at ProjectRulesMaxBytes.
+	// Sessions opt out with "projectRules": false in their metadata.
+	ProjectRules         bool `json:"projectRules"`
+	ProjectRulesMaxBytes int  `json:"projectRulesMaxBytes,omitempty"`
+	// ResolveMentions embeds files named by @path mentions in text blocks
+	// (relative to the session cwd) as resource blocks, each capped at
+	// MentionMaxBytes.
+	ResolveMentions bool `json:"resolveMentions"`
+	MentionMaxBytes int  `json:"mentionMaxBytes,omitempty"`
+	// MaxPromptTokens is the estimated size (about four bytes per token)
+	// the whole prompt, system prefix, project rules and history included,
+	// is cut down to, lowest annotations.priority first. 0 disables the
+	// budget.
+	MaxPromptTokens int `json:"maxPromptTokens,omitempty"`
+	// HistoryMaxBytes caps the summary of recent messages sent along when a
+	// session has no cursor-agent chat to resume, or its chat cannot be
+	// resumed. 0 disables it.
+	HistoryMaxBytes int `json:"historyMaxBytes,omitempty"`
+	// SystemPrefix is prepended to every prompt sent to cursor-agent (team
+	// coding standards, tone, ...). Sessions replace it with "systemPrefix"
+	// in their metadata; "" turns it off.
+	SystemPrefix string `json:"systemPrefix,omitempty"`
+	// Templates are named prompts that /template <name> key=value...
+	// expands, replacing {{key}} in Text. Project templates in the session
+	// cwd's .cursor/templates/<name>.md take precedence.
+	Templates map[string]PromptTemplate `json:"templates,omitempty"`
+	// EchoUserMessages sends each prompt back to every client as
+	// user_message_chunk updates; otherwise only clients that set
+	// _meta.echoUserMessages in their capabilities get them.
+	EchoUserMessages bool `json:"echoUserMessages"`
+	// AnnotateContent adds audience, priority and lastModified annotations
+	// to the content the adapter sends, and MarkInternalContent gives
+	// content without an audience the "user" audience.
+	// CollectDetailedMetric adds input and output sizes to the prompt
+	// response _meta.
+	AnnotateContent       bool `json:"annotateContent"`
+	MarkInternalContent   bool `json:"markInternalContent"`
+	CollectDetailedMetric bool `json:"collectDetailedMetric"`
+}
+
+// PromptTemplate is a prompt with {{variable}} placeholders.
+type PromptTemplate struct {
+	Description string `json:"description,omitempty"`
+	Text        string `json:"text"`
+}
+
+type CheckpointConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Dir           string `json:"dir,omitempty"` // defaults to <sessionDir>/checkpoints
+	MaxPerSession int    `json:"maxPerSession,omitempty"`
+	MaxFiles      int    `json:"maxFiles,omitempty"` // file-copy snapshots only (non-git directories)
+}
+
+type SessionEncryptionConfig struct {
+	Enabled         bool   `json:"enabled"`
+	KeySource       string `json:"keySource,omitempty"` // "env" or "keychain"
+	KeyEnv          string `json:"keyEnv,omitempty"`
+	KeychainService string `json:"keychainService,omitempty"`
+	KeychainAccount string `json:"keychainAccount,omitempty"`
 }
 
 type ToolsConfig struct {
 	Filesystem FilesystemConfig  `json:"filesystem"`
 	Terminal   TerminalConfig    `json:"terminal"`
 	Cursor     CursorToolsConfig `json:"cursor,omitempty"`
+	Git        GitToolsConfig    `json:"git"`
+	Go         GoToolsConfig     `json:"go"`
+	Web        WebToolsConfig    `json:"web"`
+	Index      IndexConfig       `json:"index"`
+	// TimeoutMs bounds a single tool call; Timeouts overrides it per tool
+	// name. 0 means no limit.
+	TimeoutMs int64            `json:"timeoutMs,omitempty"`
+	Timeouts  map[string]int64 `json:"timeouts,omitempty"`
+	// ResultCache reuses read-only tool results (read_file, searches,
+	// get_project_info, ...) with identical parameters within a prompt turn.
+	// Any edit, delete, move or execute tool clears the session's cache.
+	ResultCache bool `json:"resultCache,omitempty"`
+	// Plugins are external executables that provide additional tools.
+	Plugins []PluginConfig `json:"plugins,omitempty"`
+	// DiffContextLines is the number of unchanged lines shown around each
+	// change in unified diffs (edit results and rendered diff blocks).
+	DiffContextLines int `json:"diffContextLines"`
+	// MaxRawOutputBytes caps the JSON size of the rawOutput a tool call
+	// sends to the client; RawOutputLimits overrides it per tool kind
+	// (read, execute, ...). Larger output, and diffs larger than the cap,
+	// are saved as artifacts under <sessionDir>/artifacts and sent as a
+	// preview with a resource_link. 0 sends them whole.
+	MaxRawOutputBytes int64            `json:"maxRawOutputBytes"`
+	RawOutputLimits   map[string]int64 `json:"rawOutputLimits,omitempty"`
+	// MaxArtifactsPerSession keeps only a session's newest artifacts; the
+	// oldest are removed as new ones are saved. 0 keeps them all.
+	MaxArtifactsPerSession int `json:"maxArtifactsPerSession"`
 }
 
 type FilesystemConfig struct {
@@ -29,6 +315,11 @@ type FilesystemConfig struct {
 	AllowedPaths      []string `json:"allowedPaths,omitempty"`
 	MaxFileSize       int64    `json:"maxFileSize,omitempty"`
 	AllowedExtensions []string `json:"allowedExtensions,omitempty"`
+	// AllowWorkspaceFolders also allows the workspaceFolders a client sends
+	// with session/new and session/load, as long as each lies inside one of
+	// WorkspaceFolderRoots and is not a filesystem root.
+	AllowWorkspaceFolders bool     `json:"allowWorkspaceFolders"`
+	WorkspaceFolderRoots  []string `json:"workspaceFolderRoots,omitempty"`
 }
 
 type TerminalConfig struct {
@@ -39,6 +330,15 @@ type TerminalConfig struct {
 	ForbiddenCommands      []string `json:"forbiddenCommands,omitempty"`
 	AllowedCommands        []string `json:"allowedCommands,omitempty"`
 	DefaultCwd             string   `json:"defaultCwd,omitempty"`
+	// CommandSafety is how closely commands are checked against
+	// ForbiddenCommands: "basic" compares the command name only, "standard"
+	// also looks inside sh -c strings, wrappers and find -exec, and "strict"
+	// additionally rejects sudo, piping into a shell and forbidden names
+	// anywhere in the arguments.
+	CommandSafety string `json:"commandSafety,omitempty"`
+	// IdleTimeoutMs kills and releases terminals that nobody has polled or
+	// waited on for this long. Zero keeps them until their session ends.
+	IdleTimeoutMs int64 `json:"idleTimeoutMs,omitempty"`
 }
 
 type CursorToolsConfig struct {
@@ -46,19 +346,137 @@ type CursorToolsConfig struct {
 	MaxSearchResults       int  `json:"maxSearchResults,omitempty"`
 	EnableCodeModification bool `json:"enableCodeModification,omitempty"`
 	EnableTestExecution    bool `json:"enableTestExecution,omitempty"`
+	// StructureDepth is how many directory levels get_project_info lists
+	// when the call does not pass structure_depth.
+	StructureDepth int `json:"structureDepth,omitempty"`
+}
+
+type GitToolsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+type GoToolsConfig struct {
+	Enabled    bool   `json:"enabled"`
+	BinaryPath string `json:"binaryPath,omitempty"` // defaults to go on PATH
+}
+
+// IndexConfig controls the workspace index behind find_files,
+// find_definitions and find_references. Each working directory is indexed
+// in the background on first use and re-scanned every RefreshInterval.
+type IndexConfig struct {
+	Enabled         bool  `json:"enabled"`
+	RefreshInterval int64 `json:"refreshInterval,omitempty"` // milliseconds
+	MaxFiles        int   `json:"maxFiles,omitempty"`
+	// MaxFileSize is the largest file whose symbols and identifiers are
+	// indexed; larger files are still found by name.
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+}
+
+type WebToolsConfig struct {
+	Enabled bool `json:"enabled"`
+	// AllowedDomains, when non-empty, restricts fetches to these hosts and
+	// their subdomains. DeniedDomains always wins.
+	AllowedDomains       []string `json:"allowedDomains,omitempty"`
+	DeniedDomains        []string `json:"deniedDomains,omitempty"`
+	AllowPrivateNetworks bool     `json:"allowPrivateNetworks,omitempty"`
+	MaxResponseBytes     int64    `json:"maxResponseBytes,omitempty"`
+	Timeout              int64    `json:"timeout,omitempty"`  // milliseconds
+	CacheTTL             int64    `json:"cacheTtl,omitempty"` // milliseconds, 0 disables caching
+	MaxCacheEntries      int      `json:"maxCacheEntries,omitempty"`
+	UserAgent            string   `json:"userAgent,omitempty"`
+
+	Search WebSearchConfig `json:"search"`
+}
+
+type WebSearchConfig struct {
+	Enabled bool `json:"enabled"`
+	// Provider is one of brave, tavily or searxng.
+	Provider           string `json:"provider,omitempty"`
+	Endpoint           string `json:"endpoint,omitempty"` // overrides the provider's default URL; required for searxng
+	APIKeyEnv          string `json:"apiKeyEnv,omitempty"`
+	MaxResults         int    `json:"maxResults,omitempty"`
+	RateLimitPerMinute int    `json:"rateLimitPerMinute,omitempty"`
+}
+
+// PluginConfig is an executable that speaks the tool plugin protocol on
+// stdin/stdout (see tools.PluginProvider). Its tools are registered as
+// <name>_<tool>.
+type PluginConfig struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Env is added to the adapter's environment as filtered by the
+	// environment policy, so plugins can be given their own credentials.
+	Env map[string]string `json:"env,omitempty"`
+	// Cwd defaults to the session working directory.
+	Cwd string `json:"cwd,omitempty"`
+	// RequirePermission asks the client before running any of its tools,
+	// whatever the plugin declares.
+	RequirePermission bool `json:"requirePermission,omitempty"`
 }
 
 type CursorConfig struct {
 	Timeout int64 `json:"timeout"` // milliseconds
 	Retries int   `json:"retries"`
+	// CacheTTL is how long version, auth status and model list results are
+	// reused, in milliseconds. 0 disables caching.
+	CacheTTL int64 `json:"cacheTtl,omitempty"`
+	// ModelRefreshInterval re-reads `cursor-agent models` in the background
+	// and notifies clients of changes, in milliseconds. 0 disables it.
+	ModelRefreshInterval int64 `json:"modelRefreshInterval,omitempty"`
+	// BinaryPath is the cursor-agent executable; a bare name is looked up on
+	// the PATH, including a PATH set in Env. Defaults to "cursor-agent".
+	BinaryPath string `json:"binaryPath,omitempty"`
+	// Env is added to the adapter's environment for every cursor-agent
+	// process, e.g. HTTPS_PROXY. Sessions can override both via the
+	// cursorBinaryPath and cursorEnv metadata keys.
+	Env map[string]string `json:"env,omitempty"`
+	// MaxResponseBytes bounds the output kept for one turn. A longer
+	// response is cut off there and the turn ends with max_tokens, keeping
+	// what arrived. 0 leaves it unbounded.
+	MaxResponseBytes int64 `json:"maxResponseBytes,omitempty"`
+
+	ProcessPool CursorProcessPoolConfig `json:"processPool"`
+	Limits      CursorLimitsConfig      `json:"limits"`
+}
+
+// CursorLimitsConfig guards the host against a runaway cursor-agent. 0
+// leaves a limit off. MaxMemoryMB and MaxCPUSeconds are rlimits (data
+// segment size and CPU time) on Unix and job object limits on Windows; Nice
+// lowers the scheduling priority (0-19; below-normal or idle priority on
+// Windows). MaxOutputBytes caps what one prompt may write to stdout.
+type CursorLimitsConfig struct {
+	Nice           int   `json:"nice,omitempty"`
+	MaxMemoryMB    int64 `json:"maxMemoryMb,omitempty"`
+	MaxCPUSeconds  int64 `json:"maxCpuSeconds,omitempty"`
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+}
+
+// CursorProcessPoolConfig keeps one long-lived cursor-agent per session,
+// driven with --input-format stream-json, instead of spawning per prompt.
+type CursorProcessPoolConfig struct {
+	Enabled             bool  `json:"enabled"`
+	MaxProcesses        int   `json:"maxProcesses,omitempty"`
+	IdleTimeout         int64 `json:"idleTimeout,omitempty"`         // milliseconds
+	HealthCheckInterval int64 `json:"healthCheckInterval,omitempty"` // milliseconds
 }
 
 func Default() Config {
 	return Config{
-		LogLevel:       "info",
-		SessionDir:     "~/.cursor-sessions",
-		MaxSessions:    100,
-		SessionTimeout: 3_600_000,
+		LogLevel: "info",
+		Locale:   "en",
+		LogRotation: LogRotationConfig{
+			MaxSize:    10 * 1024 * 1024,
+			MaxAge:     7 * 24 * 3_600_000,
+			MaxBackups: 5,
+			Compress:   true,
+		},
+		SessionDir:      "~/.cursor-sessions",
+		MaxSessions:     100,
+		SessionTimeout:  3_600_000,
+		ShutdownTimeout: 10_000,
+		MaxMessageBytes: 100 << 20,
+		ReplayUpdates:   1000,
 		Tools: ToolsConfig{
 			Filesystem: FilesystemConfig{
 				Enabled:      true,
@@ -71,40 +489,135 @@ func Default() Config {
 				DefaultOutputByteLimit: 10 * 1024 * 1024,
 				MaxOutputByteLimit:     50 * 1024 * 1024,
 				ForbiddenCommands:      []string{"rm", "sudo", "su"},
+				CommandSafety:          "standard",
+				IdleTimeoutMs:          600_000,
 			},
 			Cursor: CursorToolsConfig{
 				Enabled:                true,
 				MaxSearchResults:       50,
 				EnableCodeModification: true,
 				EnableTestExecution:    true,
+				StructureDepth:         2,
+			},
+			Git: GitToolsConfig{
+				Enabled: true,
+			},
+			Go: GoToolsConfig{
+				Enabled: true,
+			},
+			Web: WebToolsConfig{
+				Enabled:          false,
+				MaxResponseBytes: 2 * 1024 * 1024,
+				Timeout:          15_000,
+				CacheTTL:         300_000,
+				MaxCacheEntries:  100,
+				Search: WebSearchConfig{
+					Enabled:            false,
+					Provider:           "brave",
+					APIKeyEnv:          "CURSOR_ACP_SEARCH_API_KEY",
+					MaxResults:         10,
+					RateLimitPerMinute: 30,
+				},
+			},
+			Index: IndexConfig{
+				Enabled:         false,
+				RefreshInterval: 2_000,
+				MaxFiles:        20_000,
+				MaxFileSize:     1024 * 1024,
 			},
+			TimeoutMs:         300_000,
+			DiffContextLines:  3,
+			MaxRawOutputBytes: 256 * 1024,
+
+			MaxArtifactsPerSession: 100,
 		},
 		Cursor: CursorConfig{
-			Timeout: 30000,
-			Retries: 3,
+			Timeout:              30000,
+			Retries:              3,
+			CacheTTL:             300_000,
+			ModelRefreshInterval: 600_000,
+			MaxResponseBytes:     16 << 20,
+			ProcessPool: CursorProcessPoolConfig{
+				Enabled:             false,
+				MaxProcesses:        4,
+				IdleTimeout:         600_000,
+				HealthCheckInterval: 30_000,
+			},
+		},
+		Checkpoints: CheckpointConfig{
+			Enabled:       false,
+			MaxPerSession: 20,
+			MaxFiles:      5000,
+		},
+		Prompt: PromptConfig{
+			AttachImages:         true,
+			ResourceInlineLimit:  32 * 1024,
+			MaxInlineBytes:       96 * 1024,
+			CoalesceWindowMs:     50,
+			CoalesceBytes:        1024,
+			ProjectRules:         true,
+			ProjectRulesMaxBytes: 16 * 1024,
+			ResolveMentions:      true,
+			MentionMaxBytes:      64 * 1024,
+			MaxPromptTokens:      120000,
+			HistoryMaxBytes:      8 * 1024,
+
+			AnnotateContent:       true,
+			CollectDetailedMetric: true,
+		},
+		SessionEncryption: SessionEncryptionConfig{
+			Enabled:         false,
+			KeySource:       "env",
+			KeyEnv:          "CURSOR_ACP_SESSION_KEY",
+			KeychainService: "cursor-agent-acp",
+			KeychainAccount: "session-encryption",
+		},
+		ClientRequests: ClientRequestsConfig{
+			TimeoutMs:    90_000,
+			RetryDelayMs: 500,
+			HeartbeatMs:  30_000,
+		},
+		Redaction: RedactionConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			ServiceName: "cursor-agent-acp",
+		},
+		Environment: EnvironmentConfig{
+			// cursor-agent authenticates with CURSOR_API_KEY.
+			Allow: []string{"CURSOR_API_KEY"},
+			Deny: []string{
+				"*_TOKEN", "*_SECRET", "*_SECRET_KEY", "*_PASSWORD", "*_PASSWD",
+				"*_API_KEY", "*_APIKEY", "*_ACCESS_KEY", "*_PRIVATE_KEY", "*_CREDENTIALS",
+				"CURSOR_ACP_SESSION_KEY",
+			},
 		},
 	}
 }
 
+// Load reads the config file at path (if any) over base, then applies
+// CURSOR_ACP_* environment overrides and normalizes the result.
 func Load(path string, base Config) (Config, error) {
-	if strings.TrimSpace(path) == "" {
-		return Normalize(base)
+	cfg := base
+	if strings.TrimSpace(path) != "" {
+		resolved, err := expandPath(path)
+		if err != nil {
+			return Config{}, err
+		}
+		buf, err := os.ReadFile(resolved)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config file: %w", err)
+		}
+		if err := json.Unmarshal(buf, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config file: %w", err)
+		}
 	}
 
-	resolved, err := expandPath(path)
+	cfg, err := ApplyEnv(cfg, os.Getenv)
 	if err != nil {
 		return Config{}, err
 	}
-	buf, err := os.ReadFile(resolved)
-	if err != nil {
-		return Config{}, fmt.Errorf("read config file: %w", err)
-	}
-
-	cfg := base
-	if err := json.Unmarshal(buf, &cfg); err != nil {
-		return Config{}, fmt.Errorf("parse config file: %w", err)
-	}
-
 	return Normalize(cfg)
 }
 
@@ -119,6 +632,50 @@ func Normalize(cfg Config) (Config, error) {
 	}
 	cfg.SessionDir = resolved
 
+	if cfg.Checkpoints.Dir == "" {
+		cfg.Checkpoints.Dir = filepath.Join(cfg.SessionDir, "checkpoints")
+	} else {
+		dir, err := expandPath(cfg.Checkpoints.Dir)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Checkpoints.Dir = dir
+	}
+
+	if cfg.LogFile != "" {
+		logFile, err := expandPath(cfg.LogFile)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.LogFile = logFile
+	}
+
+	if cfg.Audit.Dir == "" {
+		cfg.Audit.Dir = filepath.Join(cfg.SessionDir, "audit")
+	} else {
+		dir, err := expandPath(cfg.Audit.Dir)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Audit.Dir = dir
+	}
+
+	if cfg.Prompt.AttachmentDir != "" {
+		dir, err := expandPath(cfg.Prompt.AttachmentDir)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Prompt.AttachmentDir = dir
+	}
+
+	if p := cfg.Cursor.BinaryPath; strings.HasPrefix(p, "~") || strings.ContainsRune(p, filepath.Separator) {
+		bin, err := expandPath(p)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Cursor.BinaryPath = bin
+	}
+
 	if cfg.Tools.Terminal.DefaultCwd != "" {
 		cwd, err := expandPath(cfg.Tools.Terminal.DefaultCwd)
 		if err != nil {
@@ -127,6 +684,23 @@ func Normalize(cfg Config) (Config, error) {
 		cfg.Tools.Terminal.DefaultCwd = cwd
 	}
 
+	for i, plugin := range cfg.Tools.Plugins {
+		if strings.HasPrefix(plugin.Command, "~") || strings.ContainsRune(plugin.Command, filepath.Separator) {
+			command, err := expandPath(plugin.Command)
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Tools.Plugins[i].Command = command
+		}
+		if plugin.Cwd != "" {
+			cwd, err := expandPath(plugin.Cwd)
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Tools.Plugins[i].Cwd = cwd
+		}
+	}
+
 	if len(cfg.Tools.Filesystem.AllowedPaths) == 0 {
 		cfg.Tools.Filesystem.AllowedPaths = []string{"."}
 	}
@@ -137,6 +711,13 @@ func Normalize(cfg Config) (Config, error) {
 		}
 		cfg.Tools.Filesystem.AllowedPaths[i] = p
 	}
+	for i := range cfg.Tools.Filesystem.WorkspaceFolderRoots {
+		p, err := expandPath(cfg.Tools.Filesystem.WorkspaceFolderRoots[i])
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Tools.Filesystem.WorkspaceFolderRoots[i] = p
+	}
 
 	return cfg, nil
 }
@@ -147,28 +728,254 @@ func Validate(cfg Config) []error {
 	if cfg.LogLevel != "error" && cfg.LogLevel != "warn" && cfg.LogLevel != "info" && cfg.LogLevel != "debug" {
 		errs = append(errs, fmt.Errorf("invalid logLevel: %s", cfg.LogLevel))
 	}
+	if r := cfg.LogRotation; r.MaxSize < 0 || r.MaxAge < 0 || r.MaxBackups < 0 {
+		errs = append(errs, errors.New("logRotation.maxSize, maxAge and maxBackups must not be negative"))
+	}
 	if cfg.MaxSessions < 1 || cfg.MaxSessions > 1000 {
 		errs = append(errs, errors.New("maxSessions must be between 1 and 1000"))
 	}
 	if cfg.SessionTimeout < 60_000 || cfg.SessionTimeout > 86_400_000 {
 		errs = append(errs, errors.New("sessionTimeout must be between 60000 and 86400000"))
 	}
+	if cfg.ShutdownTimeout < 0 || cfg.ShutdownTimeout > 300_000 {
+		errs = append(errs, errors.New("shutdownTimeout must be between 0 and 300000"))
+	}
+	if cfg.MaxMessageBytes < 0 {
+		errs = append(errs, errors.New("maxMessageBytes must not be negative"))
+	}
+	if cfg.ReplayUpdates < 0 || cfg.ReplayUpdates > 100_000 {
+		errs = append(errs, errors.New("replayUpdates must be between 0 and 100000"))
+	}
 	if cfg.Cursor.Timeout < 5_000 || cfg.Cursor.Timeout > 300_000 {
 		errs = append(errs, errors.New("cursor.timeout must be between 5000 and 300000"))
 	}
 	if cfg.Cursor.Retries < 0 || cfg.Cursor.Retries > 10 {
 		errs = append(errs, errors.New("cursor.retries must be between 0 and 10"))
 	}
+	if cfg.Cursor.MaxResponseBytes < 0 {
+		errs = append(errs, errors.New("cursor.maxResponseBytes must not be negative"))
+	}
 	if cfg.Tools.Terminal.MaxProcesses < 1 || cfg.Tools.Terminal.MaxProcesses > 20 {
 		errs = append(errs, errors.New("tools.terminal.maxProcesses must be between 1 and 20"))
 	}
+	if fs := cfg.Tools.Filesystem; fs.AllowWorkspaceFolders && len(fs.WorkspaceFolderRoots) == 0 {
+		errs = append(errs, errors.New("tools.filesystem.allowWorkspaceFolders requires tools.filesystem.workspaceFolderRoots"))
+	}
+	if cfg.Tools.TimeoutMs < 0 {
+		errs = append(errs, errors.New("tools.timeoutMs must not be negative"))
+	}
+	if d := cfg.Tools.Cursor.StructureDepth; d < 0 || d > 5 {
+		errs = append(errs, errors.New("tools.cursor.structureDepth must be between 0 and 5"))
+	}
+	if idx := cfg.Tools.Index; idx.Enabled && (idx.RefreshInterval < 100 || idx.MaxFiles < 1 || idx.MaxFileSize < 1) {
+		errs = append(errs, errors.New("tools.index.refreshInterval must be at least 100 and maxFiles and maxFileSize must be positive"))
+	}
+	if cfg.Tools.DiffContextLines < 0 || cfg.Tools.DiffContextLines > 100 {
+		errs = append(errs, errors.New("tools.diffContextLines must be between 0 and 100"))
+	}
+	if cfg.Tools.MaxRawOutputBytes < 0 {
+		errs = append(errs, errors.New("tools.maxRawOutputBytes must not be negative"))
+	}
+	if cfg.Tools.MaxArtifactsPerSession < 0 {
+		errs = append(errs, errors.New("tools.maxArtifactsPerSession must not be negative"))
+	}
+	for kind, limit := range cfg.Tools.RawOutputLimits {
+		if limit < 0 {
+			errs = append(errs, fmt.Errorf("tools.rawOutputLimits.%s must not be negative", kind))
+		}
+	}
+	for name, ms := range cfg.Tools.Timeouts {
+		if ms < 0 {
+			errs = append(errs, fmt.Errorf("tools.timeouts.%s must not be negative", name))
+		}
+	}
+	if cr := cfg.ClientRequests; cr.TimeoutMs < 0 || cr.RetryDelayMs < 0 {
+		errs = append(errs, errors.New("clientRequests.timeoutMs and clientRequests.retryDelayMs must not be negative"))
+	}
+	if hb := cfg.ClientRequests.HeartbeatMs; hb != 0 && hb < 1_000 {
+		errs = append(errs, errors.New("clientRequests.heartbeatMs must be 0 (disabled) or at least 1000"))
+	}
+	if r := cfg.ClientRequests.Retries; r < 0 || r > 5 {
+		errs = append(errs, errors.New("clientRequests.retries must be between 0 and 5"))
+	}
+	for method, ms := range cfg.ClientRequests.Timeouts {
+		if ms < 0 {
+			errs = append(errs, fmt.Errorf("clientRequests.timeouts.%s must not be negative", method))
+		}
+	}
+	switch cfg.Tools.Terminal.CommandSafety {
+	case "basic", "standard", "strict":
+	default:
+		errs = append(errs, fmt.Errorf("invalid tools.terminal.commandSafety: %s", cfg.Tools.Terminal.CommandSafety))
+	}
+	if cfg.Tools.Terminal.IdleTimeoutMs != 0 && cfg.Tools.Terminal.IdleTimeoutMs < 1_000 {
+		errs = append(errs, errors.New("tools.terminal.idleTimeoutMs must be 0 (disabled) or at least 1000"))
+	}
 	if cfg.Cursor.Timeout*int64(cfg.Cursor.Retries+1) > 600_000 {
 		errs = append(errs, errors.New("cursor.timeout*(retries+1) must not exceed 600000"))
 	}
+	if cfg.Cursor.ModelRefreshInterval != 0 && cfg.Cursor.ModelRefreshInterval < 10_000 {
+		errs = append(errs, errors.New("cursor.modelRefreshInterval must be 0 (disabled) or at least 10000"))
+	}
+	for _, patterns := range [][]string{cfg.Environment.Allow, cfg.Environment.Deny} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("invalid environment pattern %q: %w", pattern, err))
+			}
+		}
+	}
+	for key := range cfg.Cursor.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			errs = append(errs, fmt.Errorf("invalid cursor.env key: %q", key))
+		}
+	}
+	limits := cfg.Cursor.Limits
+	if limits.Nice < 0 || limits.Nice > 19 {
+		errs = append(errs, errors.New("cursor.limits.nice must be between 0 and 19"))
+	}
+	if limits.MaxMemoryMB < 0 || limits.MaxCPUSeconds < 0 || limits.MaxOutputBytes < 0 {
+		errs = append(errs, errors.New("cursor.limits.maxMemoryMb, maxCpuSeconds and maxOutputBytes must not be negative"))
+	}
+	if pool := cfg.Cursor.ProcessPool; pool.Enabled {
+		if pool.MaxProcesses < 1 || pool.MaxProcesses > 50 {
+			errs = append(errs, errors.New("cursor.processPool.maxProcesses must be between 1 and 50"))
+		}
+		if pool.HealthCheckInterval < 1_000 {
+			errs = append(errs, errors.New("cursor.processPool.healthCheckInterval must be at least 1000"))
+		}
+	}
+	if (cfg.Tools.Web.Enabled || cfg.Tools.Web.Search.Enabled) && (cfg.Tools.Web.Timeout < 1_000 || cfg.Tools.Web.Timeout > 120_000) {
+		errs = append(errs, errors.New("tools.web.timeout must be between 1000 and 120000"))
+	}
+	if cfg.Tools.Web.Search.Enabled {
+		switch cfg.Tools.Web.Search.Provider {
+		case "brave", "tavily":
+		case "searxng":
+			if strings.TrimSpace(cfg.Tools.Web.Search.Endpoint) == "" {
+				errs = append(errs, errors.New("tools.web.search.endpoint is required for the searxng provider"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("invalid tools.web.search.provider: %s", cfg.Tools.Web.Search.Provider))
+		}
+	}
+	plugins := map[string]bool{}
+	for i, plugin := range cfg.Tools.Plugins {
+		switch {
+		case !isPluginName(plugin.Name):
+			errs = append(errs, fmt.Errorf("tools.plugins[%d].name %q must be lowercase letters, digits and underscores, starting with a letter", i, plugin.Name))
+		case reservedPluginNames[plugin.Name]:
+			errs = append(errs, fmt.Errorf("tools.plugins[%d].name %q is reserved for a built-in tool provider", i, plugin.Name))
+		case plugins[plugin.Name]:
+			errs = append(errs, fmt.Errorf("duplicate tools.plugins name %q", plugin.Name))
+		}
+		plugins[plugin.Name] = true
+		if strings.TrimSpace(plugin.Command) == "" {
+			errs = append(errs, fmt.Errorf("tools.plugins[%d].command is required", i))
+		}
+	}
+	if cfg.Checkpoints.Enabled && (cfg.Checkpoints.MaxPerSession < 1 || cfg.Checkpoints.MaxPerSession > 1000) {
+		errs = append(errs, errors.New("checkpoints.maxPerSession must be between 1 and 1000"))
+	}
+	if cfg.Prompt.ResourceInlineLimit < 0 || cfg.Prompt.MaxInlineBytes < 0 {
+		errs = append(errs, errors.New("prompt.resourceInlineLimit and prompt.maxInlineBytes must not be negative"))
+	}
+	for _, pattern := range cfg.Prompt.AudioModels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid prompt.audioModels entry %q: %v", pattern, err))
+		}
+	}
+	if cfg.Prompt.CoalesceWindowMs < 0 || cfg.Prompt.CoalesceBytes < 0 {
+		errs = append(errs, errors.New("prompt.coalesceWindowMs and prompt.coalesceBytes must not be negative"))
+	}
+	if cfg.Prompt.ProjectRulesMaxBytes < 0 {
+		errs = append(errs, errors.New("prompt.projectRulesMaxBytes must not be negative"))
+	}
+	if cfg.Prompt.MentionMaxBytes < 0 {
+		errs = append(errs, errors.New("prompt.mentionMaxBytes must not be negative"))
+	}
+	if cfg.Prompt.MaxPromptTokens < 0 {
+		errs = append(errs, errors.New("prompt.maxPromptTokens must not be negative"))
+	}
+	if cfg.Prompt.HistoryMaxBytes < 0 {
+		errs = append(errs, errors.New("prompt.historyMaxBytes must not be negative"))
+	}
+	for name, tmpl := range cfg.Prompt.Templates {
+		if name == "" || strings.ContainsFunc(name, unicode.IsSpace) || name == "list" {
+			errs = append(errs, fmt.Errorf("prompt.templates name %q must be non-empty, without spaces and not \"list\"", name))
+		}
+		if strings.TrimSpace(tmpl.Text) == "" {
+			errs = append(errs, fmt.Errorf("prompt.templates[%q].text must not be empty", name))
+		}
+	}
+	if mode := cfg.Defaults.Mode; mode != "" && mode != "agent" && mode != "plan" && mode != "ask" {
+		errs = append(errs, fmt.Errorf("defaults.mode must be agent, plan or ask, got %q", mode))
+	}
+	for pattern, info := range cfg.Models.Info {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid models.info entry %q: %v", pattern, err))
+		}
+		if info.ContextWindow < 0 {
+			errs = append(errs, fmt.Errorf("models.info[%q].contextWindow must not be negative", pattern))
+		}
+		if info.InputCostPerMTok < 0 || info.OutputCostPerMTok < 0 {
+			errs = append(errs, fmt.Errorf("models.info[%q] prices must not be negative", pattern))
+		}
+		if info.Tier != "" && info.Tier != "fast" && info.Tier != "standard" && info.Tier != "premium" {
+			errs = append(errs, fmt.Errorf("models.info[%q].tier must be fast, standard or premium, got %q", pattern, info.Tier))
+		}
+	}
+	for _, pattern := range append(slices.Clone(cfg.Models.Allow), cfg.Models.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid models.allow or models.deny entry %q: %v", pattern, err))
+		}
+	}
+	for alias, target := range cfg.Models.Aliases {
+		if strings.TrimSpace(alias) == "" || strings.ContainsAny(alias, " \t") || strings.TrimSpace(target) == "" {
+			errs = append(errs, fmt.Errorf("invalid models.aliases entry %q: %q (names must be single words mapping to a model ID)", alias, target))
+		}
+	}
+	for _, pattern := range cfg.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid redaction.patterns entry %q: %v", pattern, err))
+		}
+	}
+	if endpoint := strings.TrimSpace(cfg.Tracing.Endpoint); endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("tracing.endpoint must be an http or https URL: %s", endpoint))
+		}
+	}
+	if cfg.SessionEncryption.Enabled {
+		switch cfg.SessionEncryption.KeySource {
+		case "env":
+			if strings.TrimSpace(cfg.SessionEncryption.KeyEnv) == "" {
+				errs = append(errs, errors.New("sessionEncryption.keyEnv is required when keySource is env"))
+			}
+		case "keychain":
+			if strings.TrimSpace(cfg.SessionEncryption.KeychainService) == "" {
+				errs = append(errs, errors.New("sessionEncryption.keychainService is required when keySource is keychain"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("invalid sessionEncryption.keySource: %s", cfg.SessionEncryption.KeySource))
+		}
+	}
 
 	return errs
 }
 
+var reservedPluginNames = map[string]bool{"filesystem": true, "cursor": true, "git": true, "go": true, "web": true, "index": true}
+
+func isPluginName(name string) bool {
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
 func EnsureSessionDir(cfg Config) error {
 	return os.MkdirAll(cfg.SessionDir, 0o755)
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadReadsTheEarlierModelsForm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"models":{"my-model":{"contextWindow":1000,"tier":"fast"},"gpt-5*":{"vision":true},"info":{"gpt-5*":{"contextWindow":400000}},"deny":["o3*"]}}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path, Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := cfg.Models.Info
+	if info["my-model"].ContextWindow != 1000 || info["my-model"].Tier != "fast" {
+		t.Fatalf("expected the top-level entry under models.info, got %+v", info)
+	}
+	if info["gpt-5*"].ContextWindow != 400000 || info["gpt-5*"].Vision {
+		t.Fatalf("expected models.info to win over the earlier form, got %+v", info["gpt-5*"])
+	}
+	if len(cfg.Models.Deny) != 1 || cfg.Models.Deny[0] != "o3*" {
+		t.Fatalf("expected models.deny to be read, got %v", cfg.Models.Deny)
+	}
+	if errs := Validate(cfg); len(errs) != 0 {
+		t.Fatalf("unexpected validation errors %v", errs)
+	}
+}
+
+func TestValidateReportsEveryCursorLimitProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Cursor.Limits = CursorLimitsConfig{Nice: 20, MaxMemoryMB: -1}
+	var found []string
+	for _, err := range Validate(cfg) {
+		if strings.HasPrefix(err.Error(), "cursor.limits.") {
+			found = append(found, err.Error())
+		}
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected the nice and negative limit errors, got %q", found)
+	}
+}
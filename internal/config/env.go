@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts every environment variable that overrides a config field.
+// The rest of the name is the field's JSON path in upper snake case, e.g.
+// CURSOR_ACP_LOG_LEVEL, CURSOR_ACP_CURSOR_TIMEOUT or
+// CURSOR_ACP_TOOLS_TERMINAL_FORBIDDEN_COMMANDS.
+const EnvPrefix = "CURSOR_ACP_"
+
+// ApplyEnv overrides cfg with every set CURSOR_ACP_* variable. Lists are
+// comma-separated and maps are comma-separated key=value pairs; both also
+// accept a JSON array or object, which lists and maps of structs require.
+func ApplyEnv(cfg Config, getenv func(string) string) (Config, error) {
+	err := applyEnvValue(reflect.ValueOf(&cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), getenv)
+	return cfg, err
+}
+
+// EnvVars lists the environment variable for every config field.
+func EnvVars() []string {
+	var names []string
+	collectEnvNames(reflect.TypeOf(Config{}), strings.TrimSuffix(EnvPrefix, "_"), &names)
+	return names
+}
+
+func collectEnvNames(t reflect.Type, prefix string, names *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + "_" + envName(field)
+		if field.Type.Kind() == reflect.Struct {
+			collectEnvNames(field.Type, name, names)
+			continue
+		}
+		*names = append(*names, name)
+	}
+}
+
+func applyEnvValue(v reflect.Value, prefix string, getenv func(string) string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + "_" + envName(field)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvValue(v.Field(i), name, getenv); err != nil {
+				return err
+			}
+			continue
+		}
+		raw := getenv(name)
+		if raw == "" {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), strings.TrimSpace(raw)); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if strings.HasPrefix(raw, "[") {
+			return json.Unmarshal([]byte(raw), v.Addr().Interface())
+		}
+		if !envScalar(v.Type().Elem()) {
+			return fmt.Errorf("expected a JSON array of %s", v.Type().Elem())
+		}
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setFromEnv(elem, item); err != nil {
+				return fmt.Errorf("item %q: %w", item, err)
+			}
+			items = reflect.Append(items, elem)
+		}
+		v.Set(items)
+	case reflect.Map:
+		if strings.HasPrefix(raw, "{") {
+			return json.Unmarshal([]byte(raw), v.Addr().Interface())
+		}
+		if v.Type().Key().Kind() != reflect.String || !envScalar(v.Type().Elem()) {
+			return fmt.Errorf("expected a JSON object of %s", v.Type().Elem())
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("expected key=value pairs, got %q", pair)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if elem.Kind() != reflect.String {
+				value = strings.TrimSpace(value)
+			}
+			if err := setFromEnv(elem, value); err != nil {
+				return fmt.Errorf("key %q: %w", strings.TrimSpace(key), err)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// envScalar reports whether values of t can be written as plain text in a
+// comma-separated list or key=value pair; anything else needs JSON.
+func envScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// envName turns a field's JSON name into upper snake case: cacheTtl becomes
+// CACHE_TTL.
+func envName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		name = field.Name
+	}
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
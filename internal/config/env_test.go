@@ -0,0 +1,90 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOverridesFields(t *testing.T) {
+	env := map[string]string{
+		"CURSOR_ACP_LOG_LEVEL":                         "debug",
+		"CURSOR_ACP_SESSION_DIR":                       "/srv/sessions",
+		"CURSOR_ACP_CURSOR_TIMEOUT":                    "45000",
+		"CURSOR_ACP_CURSOR_CACHE_TTL":                  "0",
+		"CURSOR_ACP_CURSOR_PROCESS_POOL_ENABLED":       "true",
+		"CURSOR_ACP_CURSOR_ENV":                        "HTTPS_PROXY=http://proxy:3128,NO_PROXY=",
+		"CURSOR_ACP_TOOLS_TERMINAL_FORBIDDEN_COMMANDS": "rm, shutdown",
+		"CURSOR_ACP_TRACING_HEADERS":                   `{"x-api-key":"k"}`,
+	}
+	cfg, err := ApplyEnv(Default(), func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "debug" || cfg.SessionDir != "/srv/sessions" || cfg.Cursor.Timeout != 45_000 || cfg.Cursor.CacheTTL != 0 || !cfg.Cursor.ProcessPool.Enabled {
+		t.Fatalf("unexpected scalar overrides %+v", cfg)
+	}
+	if cfg.Cursor.Env["HTTPS_PROXY"] != "http://proxy:3128" || cfg.Cursor.Env["NO_PROXY"] != "" || len(cfg.Cursor.Env) != 2 {
+		t.Fatalf("unexpected cursor env %v", cfg.Cursor.Env)
+	}
+	if strings.Join(cfg.Tools.Terminal.ForbiddenCommands, "|") != "rm|shutdown" || cfg.Tools.Terminal.MaxProcesses != Default().Tools.Terminal.MaxProcesses {
+		t.Fatalf("unexpected terminal config %+v", cfg.Tools.Terminal)
+	}
+	if cfg.Tracing.Headers["x-api-key"] != "k" {
+		t.Fatalf("unexpected tracing headers %v", cfg.Tracing.Headers)
+	}
+
+	env = map[string]string{"CURSOR_ACP_CURSOR_RETRIES": "many"}
+	if _, err := ApplyEnv(Default(), func(key string) string { return env[key] }); err == nil || !strings.Contains(err.Error(), "CURSOR_ACP_CURSOR_RETRIES") {
+		t.Fatalf("expected a named parse error, got %v", err)
+	}
+
+	names := EnvVars()
+	for _, want := range []string{"CURSOR_ACP_LOG_LEVEL", "CURSOR_ACP_TOOLS_WEB_SEARCH_API_KEY_ENV", "CURSOR_ACP_LOG_ROTATION_MAX_SIZE"} {
+		if !slices.Contains(names, want) {
+			t.Fatalf("expected %s in %v", want, names)
+		}
+	}
+}
+
+func TestApplyEnvRejectsValuesThatDoNotFitTheField(t *testing.T) {
+	env := map[string]string{
+		"CURSOR_ACP_TOOLS_TIMEOUTS": "read_file=5, run_command=60000",
+		"CURSOR_ACP_TOOLS_PLUGINS":  `[{"name":"a","command":"a-tool"}]`,
+	}
+	cfg, err := ApplyEnv(Default(), func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tools.Timeouts["read_file"] != 5 || cfg.Tools.Timeouts["run_command"] != 60_000 || len(cfg.Tools.Plugins) != 1 || cfg.Tools.Plugins[0].Name != "a" {
+		t.Fatalf("unexpected typed overrides %v %+v", cfg.Tools.Timeouts, cfg.Tools.Plugins)
+	}
+	if _, err := ApplyEnv(Default(), func(key string) string {
+		if key == "CURSOR_ACP_TOOLS_TIMEOUTS" {
+			return "read_file=soon"
+		}
+		return ""
+	}); err == nil || !strings.Contains(err.Error(), "read_file") {
+		t.Fatalf("expected a parse error naming the key, got %v", err)
+	}
+
+	// Every variable must fail with an error, not a panic, on values that
+	// fit no field type.
+	for _, name := range EnvVars() {
+		for _, raw := range []string{"a,b", "x=y", "[1", "{"} {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s=%s panicked: %v", name, raw, r)
+					}
+				}()
+				_, _ = ApplyEnv(Default(), func(key string) string {
+					if key == name {
+						return raw
+					}
+					return ""
+				})
+			}()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Watch polls the config file at path and calls onChange with the newly
+// loaded configuration (built on base, then normalized) whenever the file's
+// size or modification time changes. Load errors are passed to onChange so
+// the caller can keep running with the previous configuration. Watch
+// returns when ctx is done.
+func Watch(ctx context.Context, path string, base Config, interval time.Duration, onChange func(Config, error)) {
+	resolved, err := expandPath(path)
+	if err != nil {
+		onChange(Config{}, err)
+		return
+	}
+	last := fileStamp(resolved)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stamp := fileStamp(resolved)
+		if stamp == last {
+			continue
+		}
+		last = stamp
+		if stamp == (stampInfo{}) {
+			// Removed, or mid-replace by an editor; wait for it to reappear.
+			continue
+		}
+		onChange(Load(resolved, base))
+	}
+}
+
+type stampInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func fileStamp(path string) stampInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stampInfo{}
+	}
+	return stampInfo{size: info.Size(), modTime: info.ModTime()}
+}
+
+// Diff lists the settings that differ between a and b as dotted JSON paths,
+// e.g. "cursor.timeout" or "tools.terminal.forbiddenCommands".
+func Diff(a, b Config) []string {
+	var changed []string
+	diffValue(reflect.ValueOf(a), reflect.ValueOf(b), "", &changed)
+	return changed
+}
+
+func diffValue(a, b reflect.Value, prefix string, changed *[]string) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changed = append(*changed, prefix)
+		}
+		return
+	}
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		diffValue(a.Field(i), b.Field(i), name, changed)
+	}
+}
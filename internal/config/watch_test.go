@@ -0,0 +1,66 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchReloadsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"logLevel":"info"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := Default()
+	base.SessionDir = dir
+	base, err := Normalize(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan Config, 1)
+	errs := make(chan error, 1)
+	go Watch(ctx, path, base, 10*time.Millisecond, func(cfg Config, err error) {
+		if err != nil {
+			errs <- err
+			return
+		}
+		changes <- cfg
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(`{"logLevel":"debug","cursor":{"timeout":60000}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cfg := <-changes:
+		if cfg.LogLevel != "debug" || cfg.Cursor.Timeout != 60_000 || cfg.Cursor.Retries != base.Cursor.Retries {
+			t.Fatalf("unexpected reloaded config %+v", cfg)
+		}
+		if diff := strings.Join(Diff(base, cfg), ","); diff != "logLevel,cursor.timeout" {
+			t.Fatalf("unexpected diff %q", diff)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the change to be picked up")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"logLevel":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "parse config file") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a parse error for the broken file")
+	}
+}
@@ -0,0 +1,151 @@
+package content
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// mime.ExtensionsByType sorts alphabetically, which yields .jfif for JPEG.
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"audio/mpeg": ".mp3",
+	"audio/wav":  ".wav",
+	"text/plain": ".txt",
+}
+
+// Attachments materializes prompt payloads that cursor-agent cannot take on
+// its command line (images, large resources) as files it can open by path.
+// All files for a turn share one temp directory, removed by Cleanup.
+type Attachments struct {
+	opts AttachmentOptions
+
+	mu      sync.Mutex
+	dir     string
+	names   map[string]bool
+	inlined int
+}
+
+type AttachmentOptions struct {
+	Dir    string // defaults to the OS temp dir
+	Images bool
+	Audio  bool
+	// ResourceInlineLimit is the largest resource payload, in bytes, that is
+	// still inlined into the prompt. Zero inlines every resource.
+	ResourceInlineLimit int
+	// MaxInlineBytes caps the resource bytes inlined across the whole prompt;
+	// resources past the budget are attached even when individually small.
+	MaxInlineBytes int
+}
+
+// NewAttachments returns an empty set. Nothing is created on disk until the
+// first Write.
+func NewAttachments(opts AttachmentOptions) *Attachments {
+	return &Attachments{opts: opts, names: map[string]bool{}}
+}
+
+// attachResource reports whether a resource payload of size bytes should be
+// written to a file, and otherwise counts it against the inline budget.
+func (a *Attachments) attachResource(size int) bool {
+	if a == nil || a.opts.ResourceInlineLimit <= 0 {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if size > a.opts.ResourceInlineLimit || (a.opts.MaxInlineBytes > 0 && a.inlined+size > a.opts.MaxInlineBytes) {
+		return true
+	}
+	a.inlined += size
+	return false
+}
+
+// Write stores data under a name derived from name and returns its path.
+func (a *Attachments) Write(name string, data []byte) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dir == "" {
+		if a.opts.Dir != "" {
+			if err := os.MkdirAll(a.opts.Dir, 0o700); err != nil {
+				return "", fmt.Errorf("create attachment dir: %w", err)
+			}
+		}
+		dir, err := os.MkdirTemp(a.opts.Dir, "cursor-acp-turn-")
+		if err != nil {
+			return "", fmt.Errorf("create attachment dir: %w", err)
+		}
+		a.dir = dir
+	}
+
+	name = a.uniqueName(name)
+	full := filepath.Join(a.dir, name)
+	if err := os.WriteFile(full, data, 0o600); err != nil {
+		return "", fmt.Errorf("write attachment %s: %w", name, err)
+	}
+	return full, nil
+}
+
+// Cleanup removes every file written for the turn.
+func (a *Attachments) Cleanup() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(a.dir)
+	a.dir = ""
+	a.names = map[string]bool{}
+	a.inlined = 0
+	return err
+}
+
+func (a *Attachments) uniqueName(name string) string {
+	name = sanitizeFileName(name)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; a.names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+	a.names[candidate] = true
+	return candidate
+}
+
+// attachmentName picks a file name for a block: the URI's base name when it
+// has one, otherwise fallback plus an extension for mimeType.
+func attachmentName(uri string, mimeType string, fallback string) string {
+	if uri != "" && !strings.HasPrefix(uri, "data:") {
+		trimmed := strings.TrimRight(strings.SplitN(strings.SplitN(uri, "?", 2)[0], "#", 2)[0], "/")
+		if base := path.Base(trimmed); base != "." && base != "/" && filepath.Ext(base) != "" {
+			return base
+		}
+	}
+	if ext, ok := preferredExtensions[mimeType]; ok {
+		return fallback + ext
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return fallback + exts[0]
+	}
+	return fallback + ".bin"
+}
+
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r < 0x20:
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
@@ -0,0 +1,213 @@
+package content
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+const (
+	// bytesPerToken approximates the tokenizer: about four bytes of English
+	// or code per token.
+	bytesPerToken = 4
+	// placeholderTokens is the cost of a block that reaches cursor-agent as
+	// a short placeholder or file path (images, audio, binary resources).
+	placeholderTokens = 64
+	// minKeptTokens is the smallest excerpt worth keeping; a block that
+	// would be cut below it is omitted instead.
+	minKeptTokens = 128
+	// excerptNoteBytes bounds the omission note excerpt inserts.
+	excerptNoteBytes = 80
+)
+
+// BudgetOptions describes the prompt the blocks are fitted into.
+type BudgetOptions struct {
+	// MaxTokens is the budget of the whole prompt; <= 0 disables it.
+	MaxTokens int
+	// ReservedTokens is the size of what is sent along with the blocks,
+	// such as the system prompt and project rules.
+	ReservedTokens int
+	// ResourceInlineLimit is the attachments' inline limit: text resources
+	// larger than it are written to files and cost only their path. Zero
+	// means every resource is inlined.
+	ResourceInlineLimit int
+}
+
+// BudgetResult reports how a prompt was fitted into its token budget. The
+// token counts include the reserved tokens.
+type BudgetResult struct {
+	MaxTokens      int           `json:"maxTokens"`
+	ReservedTokens int           `json:"reservedTokens,omitempty"`
+	InputTokens    int           `json:"inputTokens"`
+	OutputTokens   int           `json:"outputTokens"`
+	Reduced        []BudgetEntry `json:"reduced,omitempty"`
+}
+
+// BudgetEntry describes one block that was shortened ("truncated") or
+// replaced by a note ("omitted").
+type BudgetEntry struct {
+	Index      int     `json:"index"`
+	Type       string  `json:"type"`
+	URI        string  `json:"uri,omitempty"`
+	Path       string  `json:"path,omitempty"`
+	Priority   float64 `json:"priority"`
+	Action     string  `json:"action"`
+	Tokens     int     `json:"tokens"`
+	KeptTokens int     `json:"keptTokens"`
+}
+
+// EstimateTokens approximates the tokens block adds to the prompt.
+func EstimateTokens(block acp.ContentBlock) int {
+	switch block.Type {
+	case "text":
+		return textTokens(block.Text)
+	case "resource":
+		if block.Resource != nil && block.Resource.Text != "" {
+			return textTokens(block.Resource.Text) + textTokens(block.Resource.URI)
+		}
+		return placeholderTokens
+	case "resource_link":
+		return textTokens(block.URI+block.Name+block.Title+block.Description) + 16
+	case "diff":
+		return diffSize(block) / bytesPerToken
+	default:
+		return placeholderTokens
+	}
+}
+
+func textTokens(text string) int {
+	return (len(text) + bytesPerToken - 1) / bytesPerToken
+}
+
+// blockPriority is the block's annotations.priority (0 to 1, higher is more
+// important) or, without one, 1 for text the user typed and 0.5 for
+// everything else.
+func blockPriority(block acp.ContentBlock) float64 {
+	if n, ok := numberToFloat(block.Annotations["priority"]); ok {
+		return min(n, 1)
+	}
+	if block.Type == "text" || block.Type == "resource_link" {
+		return 1
+	}
+	return 0.5
+}
+
+// budgetTokens is what block costs in the prompt: like EstimateTokens, but
+// a text resource that will be attached instead of inlined costs only its
+// path.
+func budgetTokens(block acp.ContentBlock, opts BudgetOptions) int {
+	if attached(block, opts) {
+		return placeholderTokens
+	}
+	return EstimateTokens(block)
+}
+
+func attached(block acp.ContentBlock, opts BudgetOptions) bool {
+	return block.Type == "resource" && block.Resource != nil && opts.ResourceInlineLimit > 0 && len(block.Resource.Text) > opts.ResourceInlineLimit
+}
+
+// FitBudget shortens text and text resource blocks, and drops diff blocks,
+// until the estimated size of the prompt is at most opts.MaxTokens. The
+// lowest-priority blocks go first, and the largest among equal priorities;
+// each keeps its beginning and end around an omission note, or is replaced
+// by a note when too little of it would remain. blocks is not modified.
+func FitBudget(blocks []acp.ContentBlock, opts BudgetOptions) ([]acp.ContentBlock, BudgetResult) {
+	maxTokens := opts.MaxTokens
+	tokens := make([]int, len(blocks))
+	total := opts.ReservedTokens
+	for i, block := range blocks {
+		tokens[i] = budgetTokens(block, opts)
+		total += tokens[i]
+	}
+	result := BudgetResult{MaxTokens: maxTokens, ReservedTokens: opts.ReservedTokens, InputTokens: total, OutputTokens: total}
+	if maxTokens <= 0 || total <= maxTokens {
+		return blocks, result
+	}
+
+	candidates := make([]int, 0, len(blocks))
+	for i, block := range blocks {
+		switch {
+		case block.Type == "text", block.Type == "diff":
+			candidates = append(candidates, i)
+		case block.Type == "resource" && block.Resource != nil && block.Resource.Text != "" && !attached(block, opts):
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		i, j := candidates[a], candidates[b]
+		if pi, pj := blockPriority(blocks[i]), blockPriority(blocks[j]); pi != pj {
+			return pi < pj
+		}
+		return tokens[i] > tokens[j]
+	})
+
+	out := append([]acp.ContentBlock(nil), blocks...)
+	for _, i := range candidates {
+		if total <= maxTokens {
+			break
+		}
+		block := out[i]
+		entry := BudgetEntry{Index: i, Type: block.Type, Priority: blockPriority(block), Tokens: tokens[i]}
+		if block.Type == "diff" {
+			// A cut diff no longer applies, so it is dropped whole.
+			entry.Path = block.Path
+			entry.Action = "omitted"
+			block = acp.ContentBlock{Type: "text", Text: fmt.Sprintf("[Diff of %s (%s) omitted to fit the prompt context budget]", block.Path, formatDataSize(int64(diffSize(block))))}
+		} else {
+			text := block.Text
+			if block.Type == "resource" {
+				res := *block.Resource
+				block.Resource = &res
+				text = res.Text
+			}
+			keep := tokens[i] - (total - maxTokens)
+			if keep >= minKeptTokens {
+				text = excerpt(text, keep*bytesPerToken)
+				entry.Action = "truncated"
+			} else {
+				text = fmt.Sprintf("[%s omitted to fit the prompt context budget]", formatDataSize(int64(len(text))))
+				entry.Action = "omitted"
+			}
+			if block.Type == "resource" {
+				entry.URI = block.Resource.URI
+				block.Resource.Text = text
+			} else {
+				block.Text = text
+			}
+		}
+		out[i] = block
+
+		entry.KeptTokens = budgetTokens(block, opts)
+		total += entry.KeptTokens - tokens[i]
+		result.Reduced = append(result.Reduced, entry)
+	}
+	result.OutputTokens = total
+	return out, result
+}
+
+// excerpt shortens text to at most limit bytes: the first two thirds and
+// the last third, cut at line boundaries where possible, around a note
+// saying how much was left out.
+func excerpt(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	// Leave room for the note.
+	limit = max(limit-excerptNoteBytes, 0)
+	headLen := limit * 2 / 3
+	tailLen := limit - headLen
+	head := text[:headLen]
+	if i := strings.LastIndexByte(head, '\n'); i > headLen/2 {
+		head = head[:i+1]
+	}
+	tail := text[len(text)-tailLen:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < tailLen/2 {
+		tail = tail[i+1:]
+	}
+	omitted := len(text) - len(head) - len(tail)
+	head = strings.ToValidUTF8(head, "")
+	tail = strings.ToValidUTF8(tail, "")
+	return fmt.Sprintf("%s\n[... %s omitted to fit the prompt context budget ...]\n%s", strings.TrimSuffix(head, "\n"), formatDataSize(int64(omitted)), tail)
+}
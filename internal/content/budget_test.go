@@ -0,0 +1,74 @@
+package content
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+func TestFitBudgetUnderLimitLeavesBlocks(t *testing.T) {
+	blocks := []acp.ContentBlock{{Type: "text", Text: strings.Repeat("a", 400)}}
+	out, result := FitBudget(blocks, BudgetOptions{MaxTokens: 100})
+	if len(result.Reduced) != 0 || result.InputTokens != 100 || out[0].Text != blocks[0].Text {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, result := FitBudget(blocks, BudgetOptions{}); len(result.Reduced) != 0 {
+		t.Fatalf("budget 0 must be disabled: %+v", result)
+	}
+}
+
+func TestFitBudgetCutsLowestPriorityFirst(t *testing.T) {
+	line := strings.Repeat("x", 79) + "\n"
+	blocks := []acp.ContentBlock{
+		{Type: "text", Text: "Explain these files"},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///a.go", Text: strings.Repeat(line, 200)}},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///b.go", Text: strings.Repeat(line, 200)}, Annotations: map[string]any{"priority": 0.9}},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///c.go", Text: strings.Repeat(line, 100)}, Annotations: map[string]any{"priority": 0.1}},
+	}
+
+	out, result := FitBudget(blocks, BudgetOptions{MaxTokens: 7000})
+	if result.OutputTokens > 7000 || len(result.Reduced) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	omitted, truncated := result.Reduced[0], result.Reduced[1]
+	if omitted.URI != "file:///c.go" || omitted.Action != "omitted" || truncated.URI != "file:///a.go" || truncated.Action != "truncated" {
+		t.Fatalf("unexpected reductions %+v", result.Reduced)
+	}
+	if !strings.HasPrefix(out[3].Resource.Text, "[7.8KB omitted") {
+		t.Fatalf("unexpected omission note %q", out[3].Resource.Text)
+	}
+	a := out[1].Resource.Text
+	if !strings.HasPrefix(a, line) || !strings.HasSuffix(a, line) || !strings.Contains(a, "omitted to fit the prompt context budget ...]\n") {
+		t.Fatalf("truncated resource should keep whole lines at both ends:\n%s", a)
+	}
+	if out[0].Text != blocks[0].Text || out[2].Resource.Text != blocks[2].Resource.Text {
+		t.Fatal("higher-priority blocks must not be cut")
+	}
+	if len(blocks[1].Resource.Text) != 16000 {
+		t.Fatal("FitBudget modified its input")
+	}
+}
+
+func TestFitBudgetCountsReservedTokensAndAttachments(t *testing.T) {
+	oldText, newText := strings.Repeat("old\n", 500), strings.Repeat("new\n", 500)
+	blocks := []acp.ContentBlock{
+		{Type: "text", Text: strings.Repeat("q", 800)},
+		{Type: "diff", Path: "main.go", OldText: &oldText, NewText: &newText},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///big.log", Text: strings.Repeat("x", 40000)}},
+	}
+
+	out, result := FitBudget(blocks, BudgetOptions{MaxTokens: 1000, ReservedTokens: 600, ResourceInlineLimit: 1024})
+	if result.InputTokens != 600+200+1001+placeholderTokens || result.OutputTokens > 1000 {
+		t.Fatalf("unexpected token counts %+v", result)
+	}
+	if len(result.Reduced) != 1 || result.Reduced[0].Path != "main.go" || result.Reduced[0].Action != "omitted" {
+		t.Fatalf("expected only the diff to be dropped, got %+v", result.Reduced)
+	}
+	if out[1].Type != "text" || !strings.HasPrefix(out[1].Text, "[Diff of main.go") {
+		t.Fatalf("unexpected replacement %+v", out[1])
+	}
+	if out[0].Text != blocks[0].Text || out[2].Resource.Text != blocks[2].Resource.Text {
+		t.Fatal("the prompt text and the attached resource must not be cut")
+	}
+}
@@ -0,0 +1,15 @@
+package content
+
+import "github.com/spjoes/cursor-agent-acp/internal/acp"
+
+// diffSize is the number of bytes of text a diff block carries.
+func diffSize(block acp.ContentBlock) int {
+	size := len(block.Path)
+	if block.OldText != nil {
+		size += len(*block.OldText)
+	}
+	if block.NewText != nil {
+		size += len(*block.NewText)
+	}
+	return size
+}
@@ -11,9 +11,9 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"unicode"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/diff"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -27,27 +27,35 @@ type ValidationResult struct {
 	Errors []string
 }
 
-type StreamingState struct {
-	InCodeBlock        bool
-	CodeLanguage       string
-	AccumulatedContent string
-	PendingTextBlocks  []string
-}
-
 type Processor struct {
 	logger *logging.Logger
+	// diffContext is the number of unchanged lines around each change when
+	// diff blocks are rendered as unified diffs.
+	diffContext int
 
 	mu     sync.Mutex
-	stream *StreamingState
+	stream *streamTokenizer
 }
 
 var imageDataPattern = regexp.MustCompile(`\[Image data:[^\]]+\]`)
 
 func NewProcessor(logger *logging.Logger) *Processor {
-	return &Processor{logger: logger}
+	return &Processor{logger: logger, diffContext: diff.DefaultContext}
+}
+
+// SetDiffContext sets the context lines shown around changes in rendered
+// diff blocks.
+func (p *Processor) SetDiffContext(lines int) {
+	p.diffContext = lines
 }
 
 func (p *Processor) ProcessContent(blocks []acp.ContentBlock) (ProcessedContent, error) {
+	return p.ProcessContentWithAttachments(blocks, nil)
+}
+
+// ProcessContentWithAttachments is ProcessContent, except that image data and
+// large resources are written to attachments and referenced by path.
+func (p *Processor) ProcessContentWithAttachments(blocks []acp.ContentBlock, attachments *Attachments) (ProcessedContent, error) {
 	if blocks == nil {
 		blocks = []acp.ContentBlock{}
 	}
@@ -58,7 +66,7 @@ func (p *Processor) ProcessContent(blocks []acp.ContentBlock) (ProcessedContent,
 	totalSize := 0
 
 	for i, block := range blocks {
-		processed, err := p.processContentBlock(block, i)
+		processed, err := p.processContentBlock(block, i, attachments)
 		if err != nil {
 			return ProcessedContent{}, err
 		}
@@ -109,12 +117,7 @@ func (p *Processor) ParseResponse(response string) []acp.ContentBlock {
 
 func (p *Processor) StartStreaming() {
 	p.mu.Lock()
-	p.stream = &StreamingState{
-		InCodeBlock:        false,
-		CodeLanguage:       "",
-		AccumulatedContent: "",
-		PendingTextBlocks:  []string{},
-	}
+	p.stream = &streamTokenizer{}
 	p.mu.Unlock()
 	p.logger.Debug("Started streaming session", nil)
 }
@@ -126,31 +129,23 @@ func (p *Processor) ResetStreaming() {
 	p.logger.Debug("Reset streaming session", nil)
 }
 
-func (p *Processor) FinalizeStreaming() *acp.ContentBlock {
+// FinalizeStreaming returns what is left of the stream: a trailing partial
+// line or an unterminated code block.
+func (p *Processor) FinalizeStreaming() []acp.ContentBlock {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.stream == nil {
 		return nil
 	}
-
-	state := p.stream
+	blocks := p.stream.finish()
 	p.stream = nil
-
-	if state.InCodeBlock && strings.TrimSpace(state.AccumulatedContent) != "" {
-		language := state.CodeLanguage
-		codeBlockText := fmt.Sprintf("```%s\n%s\n```", language, state.AccumulatedContent)
-		return &acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(codeBlockText)}
-	}
-
-	if strings.TrimSpace(state.AccumulatedContent) != "" {
-		text := state.AccumulatedContent
-		return &acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(text)}
-	}
-
-	return nil
+	return blocks
 }
 
-func (p *Processor) ProcessStreamChunk(chunk any) (*acp.ContentBlock, error) {
+// ProcessStreamChunk returns the blocks completed by chunk: text up to the
+// last newline, whole fenced code blocks and image markers. Incomplete
+// content is kept until a later chunk or FinalizeStreaming completes it.
+func (p *Processor) ProcessStreamChunk(chunk any) ([]acp.ContentBlock, error) {
 	if chunk == nil {
 		return nil, nil
 	}
@@ -160,7 +155,7 @@ func (p *Processor) ProcessStreamChunk(chunk any) (*acp.ContentBlock, error) {
 		if block.Type == "text" {
 			block.Text = normalizeStructuralElement(block.Text)
 		}
-		return &block, nil
+		return []acp.ContentBlock{block}, nil
 	}
 
 	chunkData, ok := chunk.(string)
@@ -171,106 +166,9 @@ func (p *Processor) ProcessStreamChunk(chunk any) (*acp.ContentBlock, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.stream == nil {
-		p.stream = &StreamingState{PendingTextBlocks: []string{}}
-	}
-	state := p.stream
-	state.AccumulatedContent += chunkData
-	accumulated := state.AccumulatedContent
-
-	if !state.InCodeBlock {
-		if idx := strings.Index(accumulated, "```"); idx >= 0 {
-			markerLen, language := parseCodeFenceOpening(accumulated[idx:])
-			beforeCode := accumulated[:idx]
-
-			if strings.TrimSpace(beforeCode) != "" {
-				textToReturn := strings.TrimSpace(beforeCode)
-				state.AccumulatedContent = accumulated[idx+markerLen:]
-				state.InCodeBlock = true
-				state.CodeLanguage = language
-				return &acp.ContentBlock{Type: "text", Text: textToReturn}, nil
-			}
-
-			state.InCodeBlock = true
-			state.CodeLanguage = language
-			state.AccumulatedContent = accumulated[idx+markerLen:]
-			return nil, nil
-		}
-
-		if strings.Contains(accumulated, "```") {
-			// Might be a partial code fence marker.
-			return nil, nil
-		}
-
-		if strings.Contains(accumulated, "[Image data:") {
-			match := imageDataPattern.FindString(accumulated)
-			if match != "" {
-				imageIndex := strings.Index(accumulated, match)
-				beforeImage := strings.TrimSpace(accumulated[:imageIndex])
-				state.AccumulatedContent = accumulated[imageIndex+len(match):]
-
-				if beforeImage != "" {
-					return &acp.ContentBlock{Type: "text", Text: beforeImage}, nil
-				}
-				return &acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(match)}, nil
-			}
-		}
-
-		if len(accumulated) > 0 && (strings.Contains(accumulated, "\n") || len(accumulated) > 100) {
-			lastNewline := strings.LastIndex(accumulated, "\n")
-			if lastNewline > 0 {
-				textToReturn := accumulated[:lastNewline+1]
-				state.AccumulatedContent = accumulated[lastNewline+1:]
-				return &acp.ContentBlock{Type: "text", Text: textToReturn}, nil
-			}
-		}
-
-		return nil, nil
+		p.stream = &streamTokenizer{}
 	}
-
-	if state.InCodeBlock {
-		closingIndex := strings.Index(accumulated, "```")
-		if closingIndex >= 0 {
-			beforeMarker := ""
-			if closingIndex > 0 {
-				beforeMarker = accumulated[closingIndex-1 : closingIndex]
-			}
-
-			afterMarker := ""
-			afterMarkerStart := closingIndex + 3
-			if afterMarkerStart < len(accumulated) {
-				afterMarker = accumulated[afterMarkerStart : afterMarkerStart+1]
-			}
-
-			isValidClosing := closingIndex == 0 ||
-				(beforeMarker == "\n" && (afterMarker == "" || afterMarker == "\n" || unicode.IsSpace(rune(afterMarker[0]))))
-
-			if isValidClosing {
-				codeContent := strings.TrimSpace(accumulated[:closingIndex])
-				afterCode := strings.TrimLeft(accumulated[closingIndex+3:], " \t\n\r")
-
-				if len(codeContent) > 0 || closingIndex > 0 {
-					language := state.CodeLanguage
-					codeBlockText := fmt.Sprintf("```%s\n%s\n```", language, codeContent)
-					result := acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(codeBlockText)}
-
-					state.InCodeBlock = false
-					state.CodeLanguage = ""
-					state.AccumulatedContent = afterCode
-					return &result, nil
-				}
-			}
-		}
-
-		return nil, nil
-	}
-
-	if len(accumulated) > 100 {
-		textToReturn := accumulated
-		state.AccumulatedContent = ""
-		return &acp.ContentBlock{Type: "text", Text: textToReturn}, nil
-	}
-
-	return nil, nil
+	return p.stream.write(chunkData), nil
 }
 
 func (p *Processor) GetContentStats(blocks []acp.ContentBlock) map[string]any {
@@ -299,6 +197,8 @@ func (p *Processor) GetContentStats(blocks []acp.ContentBlock) map[string]any {
 			}
 		case "resource_link":
 			totalSize += len(block.URI)
+		case "diff":
+			totalSize += diffSize(block)
 		}
 	}
 
@@ -325,7 +225,7 @@ func (p *Processor) ValidateContentBlocks(blocks any) ValidationResult {
 	return ValidationResult{Valid: len(errors) == 0, Errors: errors}
 }
 
-func (p *Processor) processContentBlock(block acp.ContentBlock, index int) (ProcessedContent, error) {
+func (p *Processor) processContentBlock(block acp.ContentBlock, index int, attachments *Attachments) (ProcessedContent, error) {
 	switch block.Type {
 	case "text":
 		value := sanitizeText(block.Text)
@@ -348,18 +248,28 @@ func (p *Processor) processContentBlock(block acp.ContentBlock, index int) (Proc
 		} else {
 			value += fmt.Sprintf("# Image (%s)\n", block.MimeType)
 		}
+		meta := map[string]any{
+			"mimeType":      block.MimeType,
+			"uri":           maybeString(block.URI),
+			"dataSize":      len(block.Data),
+			"isValidBase64": true,
+			"annotations":   block.Annotations,
+		}
+
+		if attachments != nil && attachments.opts.Images {
+			decoded, _ := base64.StdEncoding.DecodeString(block.Data)
+			path, err := attachments.Write(attachmentName(block.URI, block.MimeType, fmt.Sprintf("image-%d", index+1)), decoded)
+			if err != nil {
+				p.logger.Warn("Failed to attach image, sending a summary instead", map[string]any{"index": index, "error": err.Error()})
+			} else {
+				value += fmt.Sprintf("Attached image file (%s, %s): %s", block.MimeType, formatDataSize(int64(len(decoded))), path)
+				meta["path"] = path
+				return ProcessedContent{Value: value, Metadata: meta}, nil
+			}
+		}
 		value += fmt.Sprintf("[Image data: %s, %s base64]", block.MimeType, formatDataSize(int64(len(block.Data))))
 
-		return ProcessedContent{
-			Value: value,
-			Metadata: map[string]any{
-				"mimeType":      block.MimeType,
-				"uri":           maybeString(block.URI),
-				"dataSize":      len(block.Data),
-				"isValidBase64": true,
-				"annotations":   block.Annotations,
-			},
-		}, nil
+		return ProcessedContent{Value: value, Metadata: meta}, nil
 	case "audio":
 		if !isValidBase64(block.Data) {
 			return ProcessedContent{}, fmt.Errorf("Invalid base64 audio data in block %d", index)
@@ -369,17 +279,25 @@ func (p *Processor) processContentBlock(block acp.ContentBlock, index int) (Proc
 		if parts := strings.SplitN(block.MimeType, "/", 2); len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
 			audioFormat = parts[1]
 		}
+		meta := map[string]any{
+			"mimeType":      block.MimeType,
+			"dataSize":      len(block.Data),
+			"format":        audioFormat,
+			"isValidBase64": true,
+			"annotations":   block.Annotations,
+		}
+		if attachments != nil && attachments.opts.Audio {
+			decoded, _ := base64.StdEncoding.DecodeString(block.Data)
+			path, err := attachments.Write(attachmentName(block.URI, block.MimeType, fmt.Sprintf("audio-%d", index+1)), decoded)
+			if err != nil {
+				p.logger.Warn("Failed to attach audio, sending a placeholder instead", map[string]any{"index": index, "error": err.Error()})
+			} else {
+				meta["path"] = path
+				return ProcessedContent{Value: fmt.Sprintf("Attached audio file (%s, %s): %s", block.MimeType, formatDataSize(int64(len(decoded))), path), Metadata: meta}, nil
+			}
+		}
 		value := fmt.Sprintf("[Audio: %s, %s, format: %s]", block.MimeType, formatDataSize(int64(len(block.Data))), audioFormat)
-		return ProcessedContent{
-			Value: value,
-			Metadata: map[string]any{
-				"mimeType":      block.MimeType,
-				"dataSize":      len(block.Data),
-				"format":        audioFormat,
-				"isValidBase64": true,
-				"annotations":   block.Annotations,
-			},
-		}, nil
+		return ProcessedContent{Value: value, Metadata: meta}, nil
 	case "resource":
 		if block.Resource == nil {
 			return ProcessedContent{}, fmt.Errorf("invalid resource content block at %d", index)
@@ -393,25 +311,40 @@ func (p *Processor) processContentBlock(block acp.ContentBlock, index int) (Proc
 		}
 		value += "\n"
 
-		size := 0
+		size := len(res.Text)
+		if !isText {
+			size = len(res.Blob)
+		}
+		meta := map[string]any{
+			"uri":         res.URI,
+			"mimeType":    maybeString(res.MimeType),
+			"isText":      isText,
+			"size":        size,
+			"annotations": block.Annotations,
+		}
+
+		if size > 0 && attachments.attachResource(size) {
+			data := []byte(res.Text)
+			if !isText {
+				data, _ = base64.StdEncoding.DecodeString(res.Blob)
+			}
+			path, err := attachments.Write(attachmentName(res.URI, res.MimeType, fmt.Sprintf("resource-%d", index+1)), data)
+			if err != nil {
+				p.logger.Warn("Failed to attach resource, inlining it instead", map[string]any{"index": index, "uri": res.URI, "error": err.Error()})
+			} else {
+				value += fmt.Sprintf("[Resource content (%s) saved to %s; read the file for its contents]", formatDataSize(int64(len(data))), path)
+				meta["path"] = path
+				return ProcessedContent{Value: value, Metadata: meta}, nil
+			}
+		}
+
 		if isText {
 			value += res.Text
-			size = len(res.Text)
 		} else if res.Blob != "" {
 			value += fmt.Sprintf("[Binary data: %s]", formatDataSize(int64(len(res.Blob))))
-			size = len(res.Blob)
 		}
 
-		return ProcessedContent{
-			Value: value,
-			Metadata: map[string]any{
-				"uri":         res.URI,
-				"mimeType":    maybeString(res.MimeType),
-				"isText":      isText,
-				"size":        size,
-				"annotations": block.Annotations,
-			},
-		}, nil
+		return ProcessedContent{Value: value, Metadata: meta}, nil
 	case "resource_link":
 		value := ""
 		value += "# Resource Link: " + block.Name + "\n"
@@ -445,6 +378,20 @@ func (p *Processor) processContentBlock(block acp.ContentBlock, index int) (Proc
 				"annotations": block.Annotations,
 			},
 		}, nil
+	case "diff":
+		newText := ""
+		if block.NewText != nil {
+			newText = *block.NewText
+		}
+		value := "# Diff: " + block.Path + "\n```diff\n" + diff.Unified(block.Path, block.OldText, newText, p.diffContext) + "\n```"
+		return ProcessedContent{
+			Value: value,
+			Metadata: map[string]any{
+				"path":        block.Path,
+				"isNewFile":   block.OldText == nil,
+				"annotations": block.Annotations,
+			},
+		}, nil
 	default:
 		name := strings.TrimSpace(block.Type)
 		if name == "" {
@@ -530,6 +477,7 @@ func postProcessBlocks(blocks []acp.ContentBlock) []acp.ContentBlock {
 func isStructuralElement(text string) bool {
 	trimmed := strings.TrimSpace(text)
 	return strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, "~~~") ||
 		strings.HasPrefix(trimmed, "# File:") ||
 		strings.HasPrefix(trimmed, "# Image:") ||
 		strings.HasPrefix(trimmed, "[Image data:")
@@ -568,30 +516,6 @@ func formatDataSize(bytes int64) string {
 	return fmt.Sprintf("%.1f%s", size, units[unitIndex])
 }
 
-func parseCodeFenceOpening(segment string) (markerLen int, language string) {
-	if !strings.HasPrefix(segment, "```") {
-		return 0, ""
-	}
-	rest := segment[3:]
-	consumed := 3
-	i := 0
-	for i < len(rest) && isWordChar(rest[i]) {
-		i++
-	}
-	if i > 0 {
-		language = strings.TrimSpace(rest[:i])
-		consumed += i
-	}
-	if i < len(rest) && rest[i] == '\n' {
-		consumed++
-	}
-	return consumed, language
-}
-
-func isWordChar(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_'
-}
-
 func chunkToContentBlock(chunk any) (acp.ContentBlock, bool) {
 	if block, ok := chunk.(acp.ContentBlock); ok {
 		return block, true
@@ -816,8 +740,20 @@ func (p *Processor) validateContentBlock(block any, index int) []string {
 				errors = append(errors, fmt.Sprintf("Block %d: size must be a bigint or null", index))
 			}
 		}
+	case "diff":
+		if path, ok := blockMap["path"].(string); !ok || strings.TrimSpace(path) == "" {
+			errors = append(errors, fmt.Sprintf("Block %d: path is required and must be a string", index))
+		}
+		if _, ok := blockMap["newText"].(string); !ok {
+			errors = append(errors, fmt.Sprintf("Block %d: newText is required and must be a string", index))
+		}
+		if oldText, exists := blockMap["oldText"]; exists && oldText != nil {
+			if _, ok := oldText.(string); !ok {
+				errors = append(errors, fmt.Sprintf("Block %d: oldText must be a string or null", index))
+			}
+		}
 	default:
-		errors = append(errors, fmt.Sprintf("Block %d: unknown content type '%s' (valid types: text, image, audio, resource, resource_link)", index, typeName))
+		errors = append(errors, fmt.Sprintf("Block %d: unknown content type '%s' (valid types: text, image, audio, resource, resource_link, diff)", index, typeName))
 	}
 
 	if ann, exists := blockMap["annotations"]; exists {
@@ -1,11 +1,16 @@
 package content
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/benchcheck"
+	"github.com/spjoes/cursor-agent-acp/internal/diff"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -91,39 +96,42 @@ func TestParseResponseCodeAndFileSections(t *testing.T) {
 func TestProcessStreamChunkTextAndCodeLifecycle(t *testing.T) {
 	p := newTestProcessor()
 
-	block1, err := p.ProcessStreamChunk("Intro line\n")
+	blocks1, err := p.ProcessStreamChunk("Intro line\n")
 	if err != nil {
 		t.Fatalf("ProcessStreamChunk returned error: %v", err)
 	}
-	if block1 == nil || block1.Type != "text" || block1.Text != "Intro line\n" {
-		t.Fatalf("unexpected first chunk block: %#v", block1)
+	if len(blocks1) != 1 || blocks1[0].Type != "text" || blocks1[0].Text != "Intro line\n" {
+		t.Fatalf("unexpected first chunk blocks: %#v", blocks1)
 	}
 
-	block2, err := p.ProcessStreamChunk("```go\nfmt.")
+	blocks2, err := p.ProcessStreamChunk("```go\nfmt.")
 	if err != nil {
 		t.Fatalf("ProcessStreamChunk returned error: %v", err)
 	}
-	if block2 != nil {
-		t.Fatalf("expected nil for partial code chunk, got %#v", block2)
+	if len(blocks2) != 0 {
+		t.Fatalf("expected no blocks for partial code chunk, got %#v", blocks2)
 	}
 
-	block3, err := p.ProcessStreamChunk("Println(\"hi\")\n```\n")
+	blocks3, err := p.ProcessStreamChunk("Println(\"hi\")\n```\n")
 	if err != nil {
 		t.Fatalf("ProcessStreamChunk returned error: %v", err)
 	}
-	if block3 == nil || block3.Type != "text" || !strings.Contains(block3.Text, "```go") {
-		t.Fatalf("expected formatted code block, got %#v", block3)
+	if len(blocks3) != 1 || blocks3[0].Type != "text" || !strings.Contains(blocks3[0].Text, "```go") {
+		t.Fatalf("expected formatted code block, got %#v", blocks3)
 	}
 }
 
 func TestProcessStreamChunkImageReferenceBehavior(t *testing.T) {
 	p := newTestProcessor()
-	block, err := p.ProcessStreamChunk("Check this [Image data: image/png, 1.5KB base64] screenshot\n")
+	blocks, err := p.ProcessStreamChunk("Check this [Image data: image/png, 1.5KB base64] screenshot\n")
 	if err != nil {
 		t.Fatalf("ProcessStreamChunk returned error: %v", err)
 	}
-	if block == nil || block.Type != "text" || strings.TrimSpace(block.Text) != "Check this" {
-		t.Fatalf("unexpected image reference chunk block: %#v", block)
+	if len(blocks) != 3 || blocks[0].Type != "text" || strings.TrimSpace(blocks[0].Text) != "Check this" {
+		t.Fatalf("unexpected image reference chunk blocks: %#v", blocks)
+	}
+	if blocks[1].Text != "\n[Image data: image/png, 1.5KB base64]\n" || blocks[2].Text != " screenshot\n" {
+		t.Fatalf("expected the marker in a block of its own, got %#v", blocks)
 	}
 }
 
@@ -131,7 +139,7 @@ func TestFinalizeStreamingFlushesRemainder(t *testing.T) {
 	p := newTestProcessor()
 	_, _ = p.ProcessStreamChunk("trailing text without newline")
 	final := p.FinalizeStreaming()
-	if final == nil || final.Type != "text" || strings.TrimSpace(final.Text) != "trailing text without newline" {
+	if len(final) != 1 || final[0].Type != "text" || strings.TrimSpace(final[0].Text) != "trailing text without newline" {
 		t.Fatalf("expected trailing final block, got %#v", final)
 	}
 }
@@ -167,6 +175,7 @@ func TestValidateContentBlocks(t *testing.T) {
 		{Type: "audio", Data: "Zm9v", MimeType: "audio/wav"},
 		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///x", Text: "body"}},
 		{Type: "resource_link", URI: "https://example.com", Name: "Example", Size: 12},
+		acp.DiffBlock("/repo/new.go", nil, ""),
 	}
 
 	validResult := p.ValidateContentBlocks(valid)
@@ -180,6 +189,7 @@ func TestValidateContentBlocks(t *testing.T) {
 		map[string]any{"type": "image", "data": "invalid", "mimeType": 123},
 		map[string]any{"type": "resource_link", "uri": "u", "name": "n", "size": "100"},
 		map[string]any{"type": "text", "text": "x", "annotations": map[string]any{"priority": -1}},
+		map[string]any{"type": "diff", "path": "/repo/a.go", "oldText": 1},
 	}
 	invalidResult := p.ValidateContentBlocks(invalid)
 	if invalidResult.Valid || len(invalidResult.Errors) == 0 {
@@ -197,6 +207,40 @@ func TestValidateContentBlocks(t *testing.T) {
 	if !containsError(invalidResult.Errors, "annotations.priority must be non-negative") {
 		t.Fatalf("expected annotation priority validation error: %#v", invalidResult.Errors)
 	}
+	if !containsError(invalidResult.Errors, "newText is required") || !containsError(invalidResult.Errors, "oldText must be a string or null") {
+		t.Fatalf("expected diff validation errors: %#v", invalidResult.Errors)
+	}
+}
+
+func TestDiffBlocksRoundTrip(t *testing.T) {
+	p := newTestProcessor()
+	old := "package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n"
+	block := acp.DiffBlock("/repo/main.go", &old, strings.Replace(old, "old", "new", 1))
+
+	raw, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	_ = json.Unmarshal(raw, &decoded)
+	streamed, _ := p.ProcessStreamChunk(decoded)
+	if len(streamed) != 1 || streamed[0].Path != "/repo/main.go" || *streamed[0].OldText != old || *streamed[0].NewText != *block.NewText {
+		t.Fatalf("diff block did not round-trip: %s -> %#v", raw, streamed)
+	}
+
+	processed, err := p.ProcessContent([]acp.ContentBlock{block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Diff: /repo/main.go\n```diff\n--- a/repo/main.go\n+++ b/repo/main.go\n@@ -1,5 +1,5 @@\n package main\n \n func main() {\n-\tprintln(\"old\")\n+\tprintln(\"new\")\n }\n```"
+	if processed.Value != want {
+		t.Fatalf("unexpected rendered diff:\n%s", processed.Value)
+	}
+
+	created := diff.Unified("/repo/new.txt", nil, "hello\n", diff.DefaultContext)
+	if created != "--- /dev/null\n+++ b/repo/new.txt\n@@ -0,0 +1,1 @@\n+hello" {
+		t.Fatalf("unexpected new-file diff:\n%s", created)
+	}
 }
 
 func containsError(errors []string, substring string) bool {
@@ -207,3 +251,125 @@ func containsError(errors []string, substring string) bool {
 	}
 	return false
 }
+
+func TestProcessContentWritesImageAttachments(t *testing.T) {
+	p := newTestProcessor()
+	attachments := NewAttachments(AttachmentOptions{Dir: t.TempDir(), Images: true})
+	result, err := p.ProcessContentWithAttachments([]acp.ContentBlock{
+		{Type: "image", Data: "aGVsbG8=", MimeType: "image/png", URI: "file:///tmp/screen.png"},
+		{Type: "image", Data: "d29ybGQ=", MimeType: "image/jpeg"},
+	}, attachments)
+	if err != nil {
+		t.Fatalf("ProcessContentWithAttachments returned error: %v", err)
+	}
+	if strings.Contains(result.Value, "[Image data:") {
+		t.Fatalf("expected attached images instead of placeholders, got %q", result.Value)
+	}
+
+	blocks := result.Metadata["blocks"].([]map[string]any)
+	first, _ := blocks[0]["path"].(string)
+	second, _ := blocks[1]["path"].(string)
+	if filepath.Base(first) != "screen.png" || filepath.Base(second) != "image-2.jpg" {
+		t.Fatalf("unexpected attachment paths: %q, %q", first, second)
+	}
+	if data, err := os.ReadFile(first); err != nil || string(data) != "hello" {
+		t.Fatalf("attachment content = %q, %v", data, err)
+	}
+	if !strings.Contains(result.Value, "Attached image file (image/png, 5.0B): "+first) {
+		t.Fatalf("expected prompt to reference %s, got %q", first, result.Value)
+	}
+
+	if err := attachments.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(first)); !os.IsNotExist(err) {
+		t.Fatalf("expected attachment dir to be removed, got %v", err)
+	}
+}
+
+func TestProcessContentAttachesAudioForAudioModels(t *testing.T) {
+	p := newTestProcessor()
+	blocks := []acp.ContentBlock{{Type: "audio", Data: "aGVsbG8=", MimeType: "audio/wav"}}
+
+	placeholder, err := p.ProcessContentWithAttachments(blocks, NewAttachments(AttachmentOptions{Dir: t.TempDir(), Images: true}))
+	if err != nil || !strings.Contains(placeholder.Value, "[Audio: audio/wav") {
+		t.Fatalf("expected a placeholder without audio support, got %q, %v", placeholder.Value, err)
+	}
+
+	attachments := NewAttachments(AttachmentOptions{Dir: t.TempDir(), Audio: true})
+	defer attachments.Cleanup()
+	result, err := p.ProcessContentWithAttachments(blocks, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := result.Metadata["blocks"].([]map[string]any)[0]["path"].(string)
+	if filepath.Base(path) != "audio-1.wav" || result.Value != "Attached audio file (audio/wav, 5.0B): "+path {
+		t.Fatalf("unexpected audio attachment %q: %q", path, result.Value)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "hello" {
+		t.Fatalf("attachment content = %q, %v", data, err)
+	}
+}
+
+func TestProcessContentAttachesLargeResources(t *testing.T) {
+	p := newTestProcessor()
+	attachments := NewAttachments(AttachmentOptions{Dir: t.TempDir(), ResourceInlineLimit: 16, MaxInlineBytes: 20})
+	large := strings.Repeat("x", 17)
+	result, err := p.ProcessContentWithAttachments([]acp.ContentBlock{
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///repo/big.log", MimeType: "text/plain", Text: large}},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///repo/small.txt", Text: "small enough"}},
+		{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///repo/over-budget.txt", Text: "budget spent"}},
+		{Type: "image", Data: "aGVsbG8=", MimeType: "image/png"},
+	}, attachments)
+	if err != nil {
+		t.Fatalf("ProcessContentWithAttachments returned error: %v", err)
+	}
+	defer attachments.Cleanup()
+
+	blocks := result.Metadata["blocks"].([]map[string]any)
+	big, _ := blocks[0]["path"].(string)
+	if filepath.Base(big) != "big.log" || strings.Contains(result.Value, large) {
+		t.Fatalf("expected large resource to be attached, got path %q in %q", big, result.Value)
+	}
+	if data, _ := os.ReadFile(big); string(data) != large {
+		t.Fatalf("unexpected attachment content %q", data)
+	}
+	if _, ok := blocks[1]["path"]; ok || !strings.Contains(result.Value, "small enough") {
+		t.Fatalf("expected small resource to stay inline, got %q", result.Value)
+	}
+	if over, _ := blocks[2]["path"].(string); filepath.Base(over) != "over-budget.txt" {
+		t.Fatalf("expected resource past the inline budget to be attached, got %#v", blocks[2])
+	}
+	if !strings.Contains(result.Value, "[Image data: image/png,") {
+		t.Fatalf("images must not be attached unless enabled, got %q", result.Value)
+	}
+}
+
+func BenchmarkValidateContentBlocks(b *testing.B) {
+	p := newTestProcessor()
+	var blocks []any
+	for i := 0; i < 50; i++ {
+		blocks = append(blocks,
+			map[string]any{"type": "text", "text": strings.Repeat("word ", 200)},
+			map[string]any{"type": "image", "mimeType": "image/png", "data": "iVBORw0KGgo="},
+			map[string]any{"type": "resource", "resource": map[string]any{"uri": "file:///src/main.go", "mimeType": "text/x-go", "text": "package main\n"}},
+			acp.ContentBlock{Type: "resource_link", URI: "file:///README.md", Name: "README.md"},
+		)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if result := p.ValidateContentBlocks(blocks); !result.Valid {
+			b.Fatalf("unexpected validation errors: %v", result.Errors)
+		}
+	}
+}
+
+func TestBenchmarkBudgets(t *testing.T) {
+	benchcheck.Check(t, map[string]func(*testing.B){
+		"ProcessStreamChunk":    BenchmarkProcessStreamChunk,
+		"ValidateContentBlocks": BenchmarkValidateContentBlocks,
+	}, map[string]benchcheck.Budget{
+		"ProcessStreamChunk":    {NsPerOp: 10_000_000, AllocsPerOp: 16_000, BytesPerOp: 2 << 20},
+		"ValidateContentBlocks": {NsPerOp: 2_000_000, AllocsPerOp: 1_200, BytesPerOp: 96 << 10},
+	})
+}
@@ -0,0 +1,224 @@
+package content
+
+import (
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+// streamPartialFlush is how long an unterminated text line may grow before
+// it is sent without waiting for its newline.
+const streamPartialFlush = 100
+
+const imageMarkerPrefix = "[Image data:"
+
+// streamTokenizer turns streamed markdown into text, fenced code and image
+// marker blocks. Only the current partial line is kept between chunks, so a
+// chunk costs time proportional to its own length plus that line, however
+// long the response grows.
+type streamTokenizer struct {
+	line    strings.Builder // current line, without its newline
+	midLine bool            // the start of line was already sent as text
+
+	fence    string // opening marker, e.g. "```" or "~~~~", while in a code block
+	language string
+	code     strings.Builder
+
+	text strings.Builder // completed text not yet turned into a block
+	out  []acp.ContentBlock
+}
+
+func (t *streamTokenizer) write(chunk string) []acp.ContentBlock {
+	for {
+		i := strings.IndexByte(chunk, '\n')
+		if i < 0 {
+			t.line.WriteString(chunk)
+			break
+		}
+		t.line.WriteString(chunk[:i])
+		t.endLine()
+		chunk = chunk[i+1:]
+	}
+	if t.fence == "" {
+		t.flushPartialLine()
+	}
+	t.emitText()
+	return t.take()
+}
+
+// finish flushes the partial line and closes an unterminated code block.
+func (t *streamTokenizer) finish() []acp.ContentBlock {
+	if line := t.line.String(); line != "" {
+		t.line.Reset()
+		switch {
+		case t.fence != "":
+			if !isClosingFence(line, t.fence) {
+				t.code.WriteString(line)
+			}
+		case t.midLine:
+			t.writeText(line)
+		default:
+			if _, _, ok := openingFence(line); !ok {
+				t.writeText(line)
+			}
+		}
+		t.midLine = false
+	}
+	if t.fence != "" {
+		t.emitCode()
+	}
+	if strings.TrimSpace(t.text.String()) == "" {
+		t.text.Reset()
+	}
+	t.emitText()
+	return t.take()
+}
+
+func (t *streamTokenizer) endLine() {
+	line := t.line.String()
+	t.line.Reset()
+	if t.fence != "" {
+		if isClosingFence(line, t.fence) {
+			t.emitCode()
+			return
+		}
+		t.code.WriteString(line)
+		t.code.WriteByte('\n')
+		return
+	}
+	if !t.midLine {
+		if marker, language, ok := openingFence(line); ok {
+			t.emitText()
+			t.fence, t.language = marker, language
+			return
+		}
+	}
+	t.midLine = false
+	t.writeText(line + "\n")
+}
+
+// flushPartialLine sends a long unterminated line early, once it can no
+// longer turn out to be a fence, holding back a possibly incomplete image
+// marker at its end.
+func (t *streamTokenizer) flushPartialLine() {
+	line := t.line.String()
+	if len(line) <= streamPartialFlush || (!t.midLine && couldOpenFence(line)) {
+		return
+	}
+	keep := len(line) - pendingMarkerLen(line)
+	if keep == 0 {
+		return
+	}
+	t.writeText(line[:keep])
+	t.line.Reset()
+	t.line.WriteString(line[keep:])
+	t.midLine = true
+}
+
+// writeText queues text, splitting complete image markers into blocks of
+// their own.
+func (t *streamTokenizer) writeText(s string) {
+	for {
+		start := strings.Index(s, imageMarkerPrefix)
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], ']')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		t.text.WriteString(s[:start])
+		t.emitText()
+		t.out = append(t.out, acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(s[start:end])})
+		s = s[end:]
+	}
+	t.text.WriteString(s)
+}
+
+func (t *streamTokenizer) emitText() {
+	if t.text.Len() == 0 {
+		return
+	}
+	t.out = append(t.out, acp.ContentBlock{Type: "text", Text: t.text.String()})
+	t.text.Reset()
+}
+
+func (t *streamTokenizer) emitCode() {
+	code := strings.Trim(t.code.String(), "\n")
+	if strings.TrimSpace(code) != "" {
+		t.out = append(t.out, acp.ContentBlock{Type: "text", Text: normalizeStructuralElement(t.fence + t.language + "\n" + code + "\n" + t.fence)})
+	}
+	t.fence, t.language = "", ""
+	t.code.Reset()
+}
+
+func (t *streamTokenizer) take() []acp.ContentBlock {
+	out := t.out
+	t.out = nil
+	return out
+}
+
+// openingFence parses a CommonMark code fence opening: up to three spaces
+// of indentation, then three or more backticks or tildes and an optional
+// info string (which may not contain backticks for a backtick fence).
+func openingFence(line string) (marker, language string, ok bool) {
+	rest, ok := trimFenceIndent(line)
+	if !ok || len(rest) < 3 || (rest[0] != '`' && rest[0] != '~') {
+		return "", "", false
+	}
+	n := 0
+	for n < len(rest) && rest[n] == rest[0] {
+		n++
+	}
+	if n < 3 {
+		return "", "", false
+	}
+	info := strings.TrimSpace(rest[n:])
+	if rest[0] == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		language = fields[0]
+	}
+	return rest[:n], language, true
+}
+
+// isClosingFence reports whether line closes a block opened with marker: the
+// same character, at least as many of them, and nothing else. A shorter or
+// different fence inside the block is content, which is how nested fences
+// are written.
+func isClosingFence(line, marker string) bool {
+	rest, ok := trimFenceIndent(line)
+	if !ok {
+		return false
+	}
+	rest = strings.TrimRight(rest, " \t\r")
+	return len(rest) >= len(marker) && strings.Trim(rest, marker[:1]) == ""
+}
+
+func trimFenceIndent(line string) (string, bool) {
+	rest := strings.TrimLeft(line, " ")
+	return rest, len(line)-len(rest) <= 3
+}
+
+// couldOpenFence reports whether the start of an unterminated line might
+// still become a fence opening.
+func couldOpenFence(partial string) bool {
+	rest, ok := trimFenceIndent(partial)
+	return ok && (rest == "" || rest[0] == '`' || rest[0] == '~')
+}
+
+// pendingMarkerLen is the length of the suffix of s that may be an image
+// marker still being streamed.
+func pendingMarkerLen(s string) int {
+	if i := strings.LastIndex(s, imageMarkerPrefix); i >= 0 && !strings.Contains(s[i:], "]") {
+		return len(s) - i
+	}
+	for n := len(imageMarkerPrefix) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, imageMarkerPrefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
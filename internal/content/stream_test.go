@@ -0,0 +1,128 @@
+package content
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+func streamAll(chunks []string) []acp.ContentBlock {
+	var t streamTokenizer
+	var blocks []acp.ContentBlock
+	for _, chunk := range chunks {
+		blocks = append(blocks, t.write(chunk)...)
+	}
+	return append(blocks, t.finish()...)
+}
+
+func blockTexts(blocks []acp.ContentBlock) []string {
+	texts := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		texts = append(texts, b.Text)
+	}
+	return texts
+}
+
+func TestStreamTokenizerDocuments(t *testing.T) {
+	for name, tc := range map[string]struct {
+		doc  string
+		want []string
+	}{
+		"nested fences": {
+			doc:  "Intro\n````markdown\n```go\nx := 1\n```\n````\nAfter\n",
+			want: []string{"Intro\n", "\n````markdown\n```go\nx := 1\n```\n````\n", "After\n"},
+		},
+		"inline backticks are not fences": {
+			doc:  "Use ```go``` inline.\n~~~\n```\n~~~\n",
+			want: []string{"Use ```go``` inline.\n", "\n~~~\n```\n~~~\n"},
+		},
+		"longer closing fence and no trailing newline": {
+			doc:  "```python\nprint(1)\n`````",
+			want: []string{"\n```python\nprint(1)\n```\n"},
+		},
+		"unterminated fence": {
+			doc:  "Text\n\n```sh\nls -la\n",
+			want: []string{"Text\n\n", "\n```sh\nls -la\n```\n"},
+		},
+		"image markers": {
+			doc:  "See [Image data: image/png, 2KB base64] here\n",
+			want: []string{"See ", "\n[Image data: image/png, 2KB base64]\n", " here\n"},
+		},
+	} {
+		if got := blockTexts(streamAll([]string{tc.doc})); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+// TestStreamTokenizerRandomSplits checks that however a document is split
+// into chunks, the client sees the same text and the same code blocks.
+func TestStreamTokenizerRandomSplits(t *testing.T) {
+	docs := []string{
+		"Intro paragraph.\n\nSecond paragraph with `code` and ``` inline.\n````md\n```go\nfunc main() {}\n```\n````\nDone.\n",
+		strings.Repeat("A long line without breaks that goes past the partial flush limit ", 4) + "[Image data: image/jpeg, 10KB base64] tail\n~~~\nraw\n~~~",
+		"  ```js\n  let x = 1\n  ```\nafter\n[Image data: broken marker without end\n",
+		"```\nunterminated\n",
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, doc := range docs {
+		want := streamAll([]string{doc})
+		for i := 0; i < 200; i++ {
+			var chunks []string
+			for rest := doc; rest != ""; {
+				n := 1 + rng.Intn(min(len(rest), 40))
+				chunks = append(chunks, rest[:n])
+				rest = rest[n:]
+			}
+			got := streamAll(chunks)
+			if strings.Join(blockTexts(got), "") != strings.Join(blockTexts(want), "") {
+				t.Fatalf("split %q:\ngot  %q\nwant %q", chunks, blockTexts(got), blockTexts(want))
+			}
+			if !reflect.DeepEqual(codeBlocks(got), codeBlocks(want)) {
+				t.Fatalf("split %q: code blocks %q, want %q", chunks, codeBlocks(got), codeBlocks(want))
+			}
+		}
+	}
+}
+
+func codeBlocks(blocks []acp.ContentBlock) []string {
+	var code []string
+	for _, b := range blocks {
+		if trimmed := strings.TrimSpace(b.Text); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			code = append(code, b.Text)
+		}
+	}
+	return code
+}
+
+// benchmarkResponse is a 64KiB reply mixing prose, lists and fenced code,
+// split into 256-byte chunks the way cursor-agent streams it.
+func benchmarkResponse() []string {
+	section := "Here is the change you asked for.\n\n- first point\n- second point\n\n```go\nfunc add(a, b int) int {\n\treturn a + b\n}\n```\n\nThat covers it; the tests pass.\n"
+	doc := strings.Repeat(section, 64*1024/len(section))
+	var chunks []string
+	for rest := doc; rest != ""; {
+		n := min(len(rest), 256)
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	return chunks
+}
+
+func BenchmarkProcessStreamChunk(b *testing.B) {
+	p := newTestProcessor()
+	chunks := benchmarkResponse()
+	b.ReportAllocs()
+	for b.Loop() {
+		p.StartStreaming()
+		for _, chunk := range chunks {
+			if _, err := p.ProcessStreamChunk(chunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+		p.FinalizeStreaming()
+	}
+}
@@ -10,28 +10,44 @@ import (
 	"io"
 	"os/exec"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/tracing"
 )
 
 type CommandOptions struct {
 	Cwd     string
 	Timeout time.Duration
-	Env     []string
+	// BinaryPath and Env (KEY=VALUE) override cursor.binaryPath and are
+	// applied on top of cursor.env.
+	BinaryPath string
+	Env        []string
+	// Stdin is written to the process's standard input. Prompts go here
+	// rather than argv, which is limited to 128KiB per argument on Linux and
+	// about 32K characters in total on Windows.
+	Stdin string
+	// MaxStdout stops the process once it has written that many bytes and
+	// returns what it wrote up to there, with Truncated set.
+	MaxStdout int64
 }
 
 type CommandResult struct {
-	Success  bool
-	Stdout   string
-	Stderr   string
-	ExitCode int
-	Error    string
+	Success   bool
+	Stdout    string
+	Stderr    string
+	ExitCode  int
+	Error     string
+	Truncated bool
 }
 
 type AuthStatus struct {
@@ -55,6 +71,12 @@ type PromptResult struct {
 	Raw      string
 	Metadata map[string]any
 	Error    string
+	// Err is the typed failure (cursorerr.ErrNotAuthenticated,
+	// cursorerr.ErrTimeout, ...) when the cause of an unsuccessful result is
+	// recognised.
+	Err error
+	// Usage is nil when cursor-agent did not report token usage.
+	Usage *Usage
 }
 
 type StreamChunk struct {
@@ -85,8 +107,10 @@ type StreamingPromptResult struct {
 	Raw      string
 	Metadata map[string]any
 	Error    string
+	Err      error // see PromptResult.Err
 	Chunks   int
 	Aborted  bool
+	Usage    *Usage // see PromptResult.Usage
 }
 
 type Session struct {
@@ -99,20 +123,80 @@ type Session struct {
 type Bridge struct {
 	cfg    config.Config
 	logger *logging.Logger
+	pool   *processPool
+	cache  cliCache
+	now    func() time.Time
+
+	// envPolicy decides what the started processes inherit; SetEnvironment
+	// replaces it on reload.
+	envPolicy atomic.Pointer[envpolicy.Policy]
+
+	binaryMu sync.Mutex
+	binary   *BinaryLocation
+
+	// timeoutMs and retries start from cfg.Cursor and can change at runtime
+	// through SetLimits.
+	timeoutMs atomic.Int64
+	retries   atomic.Int64
 
 	mu             sync.Mutex
 	activeSessions map[string]Session
 }
 
 func NewBridge(cfg config.Config, logger *logging.Logger) *Bridge {
-	return &Bridge{
+	b := &Bridge{
 		cfg:            cfg,
 		logger:         logger,
 		activeSessions: map[string]Session{},
+		now:            time.Now,
+	}
+	b.SetLimits(cfg.Cursor.Timeout, cfg.Cursor.Retries)
+	b.SetEnvironment(cfg.Environment)
+	if cfg.Cursor.ProcessPool.Enabled {
+		b.pool = newProcessPool(cfg.Cursor.ProcessPool, logger)
 	}
+	return b
+}
+
+// SetLimits changes the cursor-agent timeout (milliseconds) and retry count
+// used by calls that start after it returns.
+func (b *Bridge) SetLimits(timeoutMs int64, retries int) {
+	b.timeoutMs.Store(timeoutMs)
+	b.retries.Store(int64(retries))
+}
+
+// SetEnvironment replaces the environment policy used by cursor-agent calls
+// that start after it returns.
+func (b *Bridge) SetEnvironment(cfg config.EnvironmentConfig) {
+	b.envPolicy.Store(envpolicy.New(cfg))
+}
+
+func (b *Bridge) timeout() time.Duration {
+	return time.Duration(b.timeoutMs.Load()) * time.Millisecond
+}
+
+// InvalidateCache drops cached version, authentication and model results so
+// the next call queries cursor-agent again.
+func (b *Bridge) InvalidateCache() {
+	b.cache.invalidate()
+	b.binaryMu.Lock()
+	b.binary = nil
+	b.binaryMu.Unlock()
+}
+
+func (b *Bridge) cacheTTL() time.Duration {
+	return time.Duration(b.cfg.Cursor.CacheTTL) * time.Millisecond
 }
 
 func (b *Bridge) GetVersion() (string, error) {
+	res := b.cache.version.get(b.cacheTTL(), b.now(), func() (versionResult, bool) {
+		version, err := b.fetchVersion()
+		return versionResult{version: version, err: err}, err == nil
+	})
+	return res.version, res.err
+}
+
+func (b *Bridge) fetchVersion() (string, error) {
 	res, err := b.ExecuteCommand(context.Background(), []string{"--version"}, CommandOptions{})
 	if err != nil {
 		return "", err
@@ -120,18 +204,30 @@ func (b *Bridge) GetVersion() (string, error) {
 	if !res.Success {
 		return "", errors.New(strings.TrimSpace(res.Error))
 	}
-	out := strings.TrimSpace(res.Stdout)
+	return parseVersion(res.Stdout), nil
+}
+
+var versionRegex = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+func parseVersion(output string) string {
+	out := strings.TrimSpace(output)
 	if out == "" {
-		return "unknown", nil
+		return "unknown"
 	}
-	versionRegex := regexp.MustCompile(`\d+\.\d+\.\d+`)
 	if match := versionRegex.FindString(out); match != "" {
-		return match, nil
+		return match
 	}
-	return out, nil
+	return out
 }
 
 func (b *Bridge) CheckAuthentication() AuthStatus {
+	return b.cache.auth.get(b.cacheTTL(), b.now(), func() (AuthStatus, bool) {
+		status := b.fetchAuthentication()
+		return status, status.Error == ""
+	})
+}
+
+func (b *Bridge) fetchAuthentication() AuthStatus {
 	res, err := b.ExecuteCommand(context.Background(), []string{"status"}, CommandOptions{})
 	if err != nil {
 		return AuthStatus{Authenticated: false, Error: err.Error()}
@@ -189,6 +285,20 @@ func (b *Bridge) CreateChat(ctx context.Context) (string, error) {
 }
 
 func (b *Bridge) ListModels() ([]acp.SessionModel, error) {
+	res := b.cache.models.get(b.cacheTTL(), b.now(), func() (modelsResult, bool) {
+		models, err := b.fetchModels()
+		return modelsResult{models: models, err: err}, err == nil
+	})
+	return slices.Clone(res.models), res.err
+}
+
+// RefreshModels bypasses the cache and re-reads the model list.
+func (b *Bridge) RefreshModels() ([]acp.SessionModel, error) {
+	b.cache.models.invalidate()
+	return b.ListModels()
+}
+
+func (b *Bridge) fetchModels() ([]acp.SessionModel, error) {
 	res, err := b.ExecuteCommand(context.Background(), []string{"models"}, CommandOptions{})
 	if err != nil {
 		return nil, err
@@ -222,6 +332,16 @@ func (b *Bridge) SendPrompt(opts PromptOptions) (PromptResult, error) {
 	model, _ := metadata["model"].(string)
 	chatID, _ := metadata["cursorChatId"].(string)
 
+	binary, env := sessionOverrides(metadata)
+	if err := b.checkOverrideEnv(env); err != nil {
+		return PromptResult{}, err
+	}
+	if b.pool != nil && opts.SessionID != "" {
+		if result, ok := b.sendPooledPrompt(ctx, opts, b.spec(binary, env), processKey{cwd: cwd, model: model}, chatID, metadata); ok {
+			return result, nil
+		}
+	}
+
 	args := make([]string, 0, 12)
 	if model != "" {
 		args = append(args, "--model", model)
@@ -233,18 +353,23 @@ func (b *Bridge) SendPrompt(opts PromptOptions) (PromptResult, error) {
 		"--print",
 		"--output-format", "json",
 		"--force",
-		opts.Content,
 	)
 
-	res, err := b.ExecuteCommand(ctx, args, CommandOptions{Cwd: cwd})
+	res, err := b.ExecuteCommand(ctx, args, CommandOptions{Cwd: cwd, Stdin: opts.Content, BinaryPath: binary, Env: env, MaxStdout: b.cfg.Cursor.MaxResponseBytes})
 	if err != nil {
 		return PromptResult{}, err
 	}
 	if !res.Success {
-		return PromptResult{Success: false, Error: res.Error, Raw: res.Stdout}, nil
+		return PromptResult{Success: false, Error: res.Error, Err: classifyOutput(res.Error), Raw: res.Stdout}, nil
 	}
 
 	actualText := strings.TrimSpace(res.Stdout)
+	if res.Truncated {
+		b.logger.Warn("cursor-agent response exceeded cursor.maxResponseBytes, truncating", map[string]any{"sessionId": opts.SessionID, "maxResponseBytes": b.cfg.Cursor.MaxResponseBytes})
+		if partial := partialJSONString(res.Stdout, "result"); partial != "" {
+			actualText = partial
+		}
+	}
 	var parsed map[string]any
 	if err := json.Unmarshal([]byte(res.Stdout), &parsed); err == nil {
 		for _, key := range []string{"result", "response", "content", "message"} {
@@ -264,11 +389,68 @@ func (b *Bridge) SendPrompt(opts PromptOptions) (PromptResult, error) {
 	for k, v := range metadata {
 		meta[k] = v
 	}
+	if res.Truncated {
+		markTruncated(meta, b.cfg.Cursor.MaxResponseBytes)
+	}
+
+	return PromptResult{Success: true, Text: actualText, Raw: res.Stdout, Metadata: meta, Usage: parseUsage(parsed)}, nil
+}
+
+// sendPooledPrompt runs a non-streaming prompt on the session's persistent
+// process. ok is false when the pool is full and the caller should spawn.
+func (b *Bridge) sendPooledPrompt(ctx context.Context, opts PromptOptions, spec commandSpec, key processKey, chatID string, metadata map[string]any) (PromptResult, bool) {
+	timeout := b.timeout()
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	stream := &streamCollector{limit: b.cfg.Cursor.MaxResponseBytes}
+	finalText := ""
+	_, err := b.pool.send(ctx, opts.SessionID, spec, key, chatID, opts.Content, limitOutput(spec.limits.MaxOutputBytes, func(line string) error {
+		if isResultEvent(line) {
+			var event struct {
+				Result string `json:"result"`
+			}
+			if json.Unmarshal([]byte(line), &event) == nil {
+				finalText = event.Result
+			}
+		}
+		return stream.handle(line)
+	}))
+	if errors.Is(err, errPoolFull) {
+		return PromptResult{}, false
+	}
+	if errors.Is(err, errResponseTruncated) {
+		b.logger.Warn("cursor-agent response exceeded cursor.maxResponseBytes, truncating", map[string]any{"sessionId": opts.SessionID, "maxResponseBytes": stream.limit})
+		err = nil
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return PromptResult{Success: false, Error: err.Error(), Err: classifyError(err), Raw: stream.raw.String()}, true
+	}
 
-	return PromptResult{Success: true, Text: actualText, Raw: res.Stdout, Metadata: meta}, nil
+	if strings.TrimSpace(finalText) == "" {
+		finalText = strings.TrimSpace(stream.text.String())
+	}
+	meta := map[string]any{
+		"processedAt":   time.Now().UTC().Format(time.RFC3339),
+		"contentLength": len(opts.Content),
+		"pooled":        true,
+	}
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	if stream.truncated {
+		markTruncated(meta, stream.limit)
+	}
+	return PromptResult{Success: true, Text: finalText, Raw: stream.raw.String(), Metadata: meta, Usage: stream.usage}, true
 }
 
-func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (StreamingPromptResult, error) {
+func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (result StreamingPromptResult, err error) {
 	ctx := opts.Ctx
 	if ctx == nil {
 		ctx = context.Background()
@@ -292,7 +474,6 @@ func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (StreamingProm
 		"--output-format", "stream-json",
 		"--stream-partial-output",
 		"--force",
-		opts.Content,
 	}
 	if model != "" {
 		args = append([]string{"--model", model}, args...)
@@ -301,91 +482,53 @@ func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (StreamingProm
 		args = append([]string{"--resume", chatID}, args...)
 	}
 
-	timeout := time.Duration(b.cfg.Cursor.Timeout) * time.Millisecond
+	timeout := b.timeout()
 	if _, hasDeadline := ctx.Deadline(); !hasDeadline && timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	cmd := exec.CommandContext(ctx, "cursor-agent", args...)
-	cmd.Dir = cwd
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return StreamingPromptResult{}, err
-	}
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Start(); err != nil {
+	binary, env := sessionOverrides(metadata)
+	if err := b.checkOverrideEnv(env); err != nil {
 		return StreamingPromptResult{}, err
 	}
-
-	rawBuilder := strings.Builder{}
-	textBuilder := strings.Builder{}
-	chunkCount := 0
-	streamErr := make(chan error, 1)
-	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
-			if rawBuilder.Len() > 0 {
-				rawBuilder.WriteByte('\n')
-			}
-			rawBuilder.WriteString(line)
-			chunkCount++
-
-			var payload any
-			parsed := json.Unmarshal([]byte(line), &payload) == nil
-			chunk := StreamChunk{Type: "content", Data: line}
-			if parsed {
-				chunk.Data = payload
-				if m, ok := payload.(map[string]any); ok {
-					for _, key := range []string{"result", "response", "content", "message"} {
-						if value, ok := m[key].(string); ok && strings.TrimSpace(value) != "" {
-							if textBuilder.Len() > 0 {
-								textBuilder.WriteByte('\n')
-							}
-							textBuilder.WriteString(value)
-							break
-						}
-					}
-				}
-			} else {
-				if textBuilder.Len() > 0 {
-					textBuilder.WriteByte('\n')
-				}
-				textBuilder.WriteString(line)
-			}
-
-			if opts.OnChunk != nil {
-				if err := opts.OnChunk(chunk); err != nil {
-					streamErr <- err
-					return
-				}
-			}
-			if opts.OnProgress != nil {
-				opts.OnProgress(StreamProgress{
-					Step:     "streaming",
-					Current:  chunkCount,
-					Progress: chunkCount,
-					Message:  fmt.Sprintf("received chunk %d", chunkCount),
-				})
-			}
+	spec := b.spec(binary, env)
+	stream := &streamCollector{opts: opts, limit: b.cfg.Cursor.MaxResponseBytes}
+	ctx, span := tracing.Start(ctx, "cursor-agent stream", map[string]any{"session.id": opts.SessionID, "cursor.model": model})
+	defer func() {
+		spanErr := err
+		if spanErr == nil && !result.Success {
+			spanErr = errors.New(result.Error)
 		}
-		if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
-			streamErr <- err
-			return
-		}
-		streamErr <- nil
+		span.SetAttributes(map[string]any{"cursor.chunks": stream.chunks, "cursor.aborted": result.Aborted})
+		span.End(spanErr)
 	}()
 
-	waitErr := cmd.Wait()
-	readErr := <-streamErr
+	var readErr, waitErr error
+	stderrText := ""
+	pooled := false
+	handle := limitOutput(spec.limits.MaxOutputBytes, stream.handle)
+	if b.pool != nil && opts.SessionID != "" {
+		readErr, waitErr = b.pool.send(ctx, opts.SessionID, spec, processKey{cwd: cwd, model: model}, chatID, opts.Content, handle)
+		if errors.Is(waitErr, errPoolFull) {
+			b.logger.Debug("cursor-agent process pool is full, spawning a one-off process", map[string]any{"sessionId": opts.SessionID})
+		} else {
+			pooled = true
+		}
+	}
+	if !pooled {
+		var startErr error
+		readErr, waitErr, stderrText, startErr = runStreamingCommand(ctx, spec, cwd, args, opts.Content, handle)
+		if startErr != nil {
+			return StreamingPromptResult{}, startError(startErr)
+		}
+	}
+	if errors.Is(readErr, errResponseTruncated) {
+		// The process was stopped for the cut, so how it exited is moot.
+		b.logger.Warn("cursor-agent response exceeded cursor.maxResponseBytes, truncating", map[string]any{"sessionId": opts.SessionID, "maxResponseBytes": stream.limit})
+		readErr, waitErr = nil, nil
+	}
 	if readErr != nil {
 		if opts.OnChunk != nil {
 			_ = opts.OnChunk(StreamChunk{Type: "error", Data: readErr.Error()})
@@ -399,17 +542,18 @@ func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (StreamingProm
 		}
 		return StreamingPromptResult{
 			Success:  false,
-			Raw:      rawBuilder.String(),
-			Text:     strings.TrimSpace(textBuilder.String()),
+			Raw:      stream.raw.String(),
+			Text:     strings.TrimSpace(stream.text.String()),
 			Error:    ctx.Err().Error(),
-			Metadata: metadataWithRuntime(metadata, opts.Content, chunkCount, true),
-			Chunks:   chunkCount,
+			Err:      classifyError(ctx.Err()),
+			Metadata: metadataWithRuntime(metadata, opts.Content, stream.chunks, true),
+			Chunks:   stream.chunks,
 			Aborted:  true,
 		}, nil
 	}
 
 	if waitErr != nil {
-		errMsg := strings.TrimSpace(stderr.String())
+		errMsg := strings.TrimSpace(stderrText)
 		if errMsg == "" {
 			errMsg = waitErr.Error()
 		}
@@ -418,33 +562,163 @@ func (b *Bridge) SendStreamingPrompt(opts StreamingPromptOptions) (StreamingProm
 		}
 		return StreamingPromptResult{
 			Success:  false,
-			Raw:      rawBuilder.String(),
-			Text:     strings.TrimSpace(textBuilder.String()),
+			Raw:      stream.raw.String(),
+			Text:     strings.TrimSpace(stream.text.String()),
 			Error:    errMsg,
-			Metadata: metadataWithRuntime(metadata, opts.Content, chunkCount, true),
-			Chunks:   chunkCount,
+			Err:      exitError(ctx, waitErr, errMsg, spec.limits),
+			Metadata: metadataWithRuntime(metadata, opts.Content, stream.chunks, true),
+			Chunks:   stream.chunks,
 		}, nil
 	}
 
-	text := strings.TrimSpace(textBuilder.String())
+	text := strings.TrimSpace(stream.text.String())
 	if text == "" {
-		text = strings.TrimSpace(rawBuilder.String())
+		text = strings.TrimSpace(stream.raw.String())
 	}
 	if opts.OnChunk != nil {
-		if err := opts.OnChunk(StreamChunk{Type: "done", Data: map[string]any{"complete": true}}); err != nil {
+		done := map[string]any{"complete": !stream.truncated}
+		if stream.truncated {
+			done["truncated"] = true
+		}
+		if err := opts.OnChunk(StreamChunk{Type: "done", Data: done}); err != nil {
 			return StreamingPromptResult{}, err
 		}
 	}
 
+	meta := metadataWithRuntime(metadata, opts.Content, stream.chunks, true)
+	if stream.truncated {
+		markTruncated(meta, stream.limit)
+	}
 	return StreamingPromptResult{
 		Success:  true,
-		Raw:      rawBuilder.String(),
+		Raw:      stream.raw.String(),
 		Text:     text,
-		Metadata: metadataWithRuntime(metadata, opts.Content, chunkCount, true),
-		Chunks:   chunkCount,
+		Metadata: meta,
+		Chunks:   stream.chunks,
+		Usage:    stream.usage,
 	}, nil
 }
 
+// runStreamingCommand spawns a one-off cursor-agent and feeds each stdout
+// line to onLine. err is only set when the process could not be started.
+func runStreamingCommand(ctx context.Context, spec commandSpec, cwd string, args []string, prompt string, onLine func(string) error) (readErr error, waitErr error, stderrText string, err error) {
+	cmd := spec.command(ctx, args...)
+	cmd.Dir = cwd
+	cmd.Stdin = strings.NewReader(prompt)
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := startProcess(cmd, spec.limits); err != nil {
+		return nil, nil, "", err
+	}
+
+	scanner := bufio.NewScanner(stdoutPipe)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if readErr = onLine(line); readErr != nil {
+			break
+		}
+	}
+	if readErr == nil {
+		if serr := scanner.Err(); serr != nil && !errors.Is(serr, io.EOF) {
+			readErr = serr
+		}
+	}
+	// Drain stdout before Wait, which closes the pipe under the reader.
+	if readErr != nil {
+		_ = killProcessGroup(cmd)
+	}
+	waitErr = cmd.Wait()
+	return readErr, waitErr, stderr.String(), nil
+}
+
+// errResponseTruncated stops a turn whose output reached
+// cursor.maxResponseBytes; the turn succeeds with what was collected.
+var errResponseTruncated = errors.New("response exceeded cursor.maxResponseBytes")
+
+// streamCollector accumulates stream-json output and forwards each line to
+// the caller's callbacks. With a limit it stops, setting truncated, at the
+// first line that would take the output past limit bytes.
+type streamCollector struct {
+	opts      StreamingPromptOptions
+	limit     int64
+	truncated bool
+	raw       strings.Builder
+	text      strings.Builder
+	chunks    int
+	usage     *Usage
+	toolArgs  toolArgAssembler
+}
+
+func (c *streamCollector) handle(line string) error {
+	if c.limit > 0 && int64(c.raw.Len()+len(line)+1) > c.limit {
+		c.truncated = true
+		return errResponseTruncated
+	}
+	if c.raw.Len() > 0 {
+		c.raw.WriteByte('\n')
+	}
+	c.raw.WriteString(line)
+	c.chunks++
+
+	var payload any
+	parsed := json.Unmarshal([]byte(line), &payload) == nil
+	chunk := StreamChunk{Type: "content", Data: line}
+	if parsed {
+		chunk.Data = payload
+		if m, ok := payload.(map[string]any); ok && m["type"] == "tool_call" {
+			delta, changed := c.toolArgs.add(m)
+			if !changed {
+				return nil
+			}
+			chunk = StreamChunk{Type: "tool_call", Data: delta}
+		} else if ok {
+			if m["type"] == "result" {
+				if usage := parseUsage(m); usage != nil {
+					c.usage = usage
+				}
+			}
+			for _, key := range []string{"result", "response", "content", "message"} {
+				if value, ok := m[key].(string); ok && strings.TrimSpace(value) != "" {
+					if c.text.Len() > 0 {
+						c.text.WriteByte('\n')
+					}
+					c.text.WriteString(value)
+					break
+				}
+			}
+		}
+	} else {
+		if c.text.Len() > 0 {
+			c.text.WriteByte('\n')
+		}
+		c.text.WriteString(line)
+	}
+
+	if c.opts.OnChunk != nil {
+		if err := c.opts.OnChunk(chunk); err != nil {
+			return err
+		}
+	}
+	if c.opts.OnProgress != nil {
+		c.opts.OnProgress(StreamProgress{
+			Step:     "streaming",
+			Current:  c.chunks,
+			Progress: c.chunks,
+			Message:  fmt.Sprintf("received chunk %d", c.chunks),
+		})
+	}
+	return nil
+}
+
 func (b *Bridge) StartInteractiveSession(sessionID string) (Session, error) {
 	id := strings.TrimSpace(sessionID)
 	if id == "" || id == "new" {
@@ -473,6 +747,9 @@ func (b *Bridge) StartInteractiveSession(sessionID string) (Session, error) {
 	return session, nil
 }
 
+// SendSessionInput sends input to an interactive session's chat and returns
+// the reply. With the process pool enabled the session keeps one
+// cursor-agent process across inputs.
 func (b *Bridge) SendSessionInput(sessionID, input string) (string, error) {
 	b.mu.Lock()
 	session, ok := b.activeSessions[sessionID]
@@ -484,16 +761,24 @@ func (b *Bridge) SendSessionInput(sessionID, input string) (string, error) {
 	b.activeSessions[sessionID] = session
 	b.mu.Unlock()
 
-	// Placeholder behavior preserved from JS implementation.
-	return "Processed: " + input, nil
+	result, err := b.SendPrompt(PromptOptions{SessionID: sessionID, Content: input, Metadata: map[string]any{"cursorChatId": session.ID}})
+	if err != nil {
+		return "", err
+	}
+	if !result.Success {
+		return "", errors.New(result.Error)
+	}
+	return result.Text, nil
 }
 
+// CloseSession forgets an interactive session and stops any persistent
+// process serving sessionID.
 func (b *Bridge) CloseSession(sessionID string) error {
+	if b.pool != nil {
+		b.pool.close(sessionID)
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if _, ok := b.activeSessions[sessionID]; !ok {
-		return nil
-	}
 	delete(b.activeSessions, sessionID)
 	return nil
 }
@@ -509,6 +794,9 @@ func (b *Bridge) GetActiveSessions() []Session {
 }
 
 func (b *Bridge) Close() error {
+	if b.pool != nil {
+		b.pool.closeAll()
+	}
 	b.mu.Lock()
 	b.activeSessions = map[string]Session{}
 	b.mu.Unlock()
@@ -522,17 +810,21 @@ func (b *Bridge) ExecuteCommand(parent context.Context, args []string, options C
 	}
 	timeout := options.Timeout
 	if timeout <= 0 {
-		timeout = time.Duration(b.cfg.Cursor.Timeout) * time.Millisecond
+		timeout = b.timeout()
 	}
 
-	attempts := b.cfg.Cursor.Retries + 1
+	attempts := int(b.retries.Load()) + 1
 	var lastErr error
-	for attempt := 1; attempt <= attempts; attempt++ {
+	attempt := 1
+	for ; attempt <= attempts; attempt++ {
 		res, err := b.executeSingle(ctx, args, options, timeout)
 		if err == nil {
 			return res, nil
 		}
 		lastErr = err
+		if errors.Is(err, cursorerr.ErrNotInstalled) {
+			break
+		}
 		if attempt < attempts {
 			backoff := time.Duration(minInt(1<<(attempt-1), 5)) * time.Second
 			select {
@@ -543,41 +835,80 @@ func (b *Bridge) ExecuteCommand(parent context.Context, args []string, options C
 		}
 	}
 
-	return CommandResult{}, fmt.Errorf("cursor-agent command failed after %d attempts: %w", attempts, lastErr)
+	return CommandResult{}, fmt.Errorf("cursor-agent command failed after %d attempts: %w", minInt(attempt, attempts), lastErr)
 }
 
-func (b *Bridge) executeSingle(parent context.Context, args []string, options CommandOptions, timeout time.Duration) (CommandResult, error) {
+func (b *Bridge) executeSingle(parent context.Context, args []string, options CommandOptions, timeout time.Duration) (res CommandResult, err error) {
 	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "cursor-agent", args...)
+	_, span := tracing.Start(ctx, "cursor-agent", map[string]any{"cursor.args": strings.Join(args, " "), "cursor.cwd": options.Cwd})
+	defer func() {
+		spanErr := err
+		if spanErr == nil && !res.Success {
+			spanErr = errors.New(res.Error)
+		}
+		span.SetAttributes(map[string]any{"process.exit_code": res.ExitCode})
+		span.End(spanErr)
+	}()
+
+	spec := b.spec(options.BinaryPath, options.Env)
+	cmd := spec.command(ctx, args...)
 	if options.Cwd != "" {
 		cmd.Dir = options.Cwd
 	}
-	if len(options.Env) > 0 {
-		cmd.Env = append(cmd.Env, options.Env...)
+	if options.Stdin != "" {
+		cmd.Stdin = strings.NewReader(options.Stdin)
 	}
 
-	stdout, err := cmd.Output()
+	// The tighter of MaxStdout, which truncates, and the output limit, which
+	// fails the command, applies.
+	limit, truncate := spec.limits.MaxOutputBytes, false
+	if options.MaxStdout > 0 && (limit <= 0 || options.MaxStdout <= limit) {
+		limit, truncate = options.MaxStdout, true
+	}
+	var stderr bytes.Buffer
+	stdout := &cappedWriter{limit: limit, exceeded: func() { _ = killProcessGroup(cmd) }}
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err = startProcess(cmd, spec.limits)
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if stdout.over && truncate {
+		return CommandResult{Success: true, Stdout: stdout.buf.String(), Truncated: true}, nil
+	}
+	if stdout.over {
+		return CommandResult{}, outputLimitError(limit)
+	}
 	if err == nil {
-		return CommandResult{Success: true, Stdout: string(stdout), ExitCode: 0}, nil
+		return CommandResult{Success: true, Stdout: stdout.buf.String(), ExitCode: 0}, nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return CommandResult{}, &cursorerr.Error{Kind: cursorerr.ErrTimeout, Message: fmt.Sprintf("command timed out after %s", timeout)}
+	}
+	if ctx.Err() != nil {
+		return CommandResult{}, ctx.Err()
 	}
 
-	res := CommandResult{Success: false}
+	res = CommandResult{Success: false}
 	if exitErr := new(exec.ExitError); errors.As(err, &exitErr) {
 		res.ExitCode = exitErr.ExitCode()
-		res.Stderr = string(exitErr.Stderr)
+		res.Stderr = stderr.String()
 		res.Error = strings.TrimSpace(res.Stderr)
 		if res.Error == "" {
 			res.Error = exitErr.Error()
 		}
+		if limitErr := limitError(err, res.Stderr, spec.limits); limitErr != nil {
+			return CommandResult{}, limitErr
+		}
+		if res.ExitCode == -1 {
+			return CommandResult{}, &cursorerr.Error{Kind: cursorerr.ErrKilled, Message: res.Error}
+		}
 		return res, nil
 	}
-
-	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-		return CommandResult{}, fmt.Errorf("command timed out after %s", timeout)
-	}
-	return CommandResult{}, err
+	return CommandResult{}, startError(err)
 }
 
 func parseModelsOutput(output string) []acp.SessionModel {
@@ -673,6 +1004,46 @@ func metadataWithRuntime(metadata map[string]any, content string, chunks int, st
 	return out
 }
 
+// markTruncated records in meta that the response was cut off at limit
+// bytes, which the prompt handler reports as a max_tokens stop.
+func markTruncated(meta map[string]any, limit int64) {
+	meta["tokenLimitReached"] = true
+	meta["partialCompletion"] = true
+	meta["maxResponseBytes"] = limit
+}
+
+// partialJSONString returns as much of the string field key as the start of
+// a JSON document cut off at an arbitrary byte holds, or "".
+func partialJSONString(data string, key string) string {
+	i := strings.Index(data, `"`+key+`":`)
+	if i < 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(data[i+len(key)+3:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+	rest = rest[1:]
+	end := len(rest)
+	for j := 0; j < len(rest); j++ {
+		if rest[j] == '\\' {
+			j++
+		} else if rest[j] == '"' {
+			end = j
+			break
+		}
+	}
+	value := strings.ToValidUTF8(rest[:end], "")
+	// Drop an escape sequence the cut split, at most 6 bytes of \uXXXX.
+	for cut := 0; cut <= 6 && cut <= len(value); cut++ {
+		var decoded string
+		if json.Unmarshal([]byte(`"`+value[:len(value)-cut]+`"`), &decoded) == nil {
+			return decoded
+		}
+	}
+	return ""
+}
+
 func ParseExitCode(text string) int {
 	text = strings.TrimSpace(text)
 	i, err := strconv.Atoi(text)
@@ -2,13 +2,17 @@ package cursor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -21,6 +25,9 @@ func setupFakeCursorAgent(t *testing.T) {
 	dir := t.TempDir()
 	scriptPath := filepath.Join(dir, "cursor-agent")
 	script := `#!/bin/sh
+if [ -n "$CALL_LOG" ]; then
+  echo "$*" >> "$CALL_LOG"
+fi
 mode=""
 for arg in "$@"; do
   if [ "$arg" = "--version" ]; then
@@ -32,6 +39,12 @@ for arg in "$@"; do
   if [ "$arg" = "agent" ]; then
     mode="agent"
   fi
+  if [ "$arg" = "--input-format" ]; then
+    mode="persistent"
+  fi
+  if [ "$arg" = "--print" ] && [ "$mode" = "" ]; then
+    mode="print"
+  fi
 done
 
 if [ "$mode" = "version" ]; then
@@ -50,8 +63,28 @@ if [ "$mode" = "agent" ]; then
     echo "stream failed" >&2
     exit 1
   fi
+  if [ "$ECHO_STDIN" = "1" ]; then
+    printf '{"content":"stdin=%s args=%s"}\n' "$(wc -c | tr -d ' ')" "$#"
+    exit 0
+  fi
   printf '{"content":"Hello"}\n'
   printf '{"content":" world"}\n'
+  printf '{"type":"result","usage":{"input_tokens":12,"output_tokens":3,"cache_read_input_tokens":8}}\n'
+  exit 0
+fi
+
+if [ "$mode" = "persistent" ]; then
+  n=0
+  while IFS= read -r line; do
+    n=$((n+1))
+    printf '{"type":"assistant","content":"pid=%s turn=%s"}\n' "$$" "$n"
+    printf '{"type":"result","result":"pid=%s turn=%s"}\n' "$$" "$n"
+  done
+  exit 0
+fi
+
+if [ "$mode" = "print" ]; then
+  printf '{"result":"stdin=%s args=%s"}\n' "$(wc -c | tr -d ' ')" "$#"
   exit 0
 fi
 
@@ -63,7 +96,7 @@ exit 1
 	}
 
 	currentPath := os.Getenv("PATH")
-	t.Setenv("PATH", dir+":"+currentPath)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+currentPath)
 }
 
 func newTestBridge() *Bridge {
@@ -136,6 +169,9 @@ func TestSendStreamingPromptEmitsDoneChunk(t *testing.T) {
 	if !strings.Contains(result.Text, "Hello") {
 		t.Fatalf("expected aggregated text in result, got %#v", result)
 	}
+	if result.Usage == nil || *result.Usage != (Usage{InputTokens: 12, OutputTokens: 3, CacheReadTokens: 8}) {
+		t.Fatalf("expected usage from the result event, got %#v", result.Usage)
+	}
 }
 
 func TestSendStreamingPromptEmitsErrorChunkOnFailure(t *testing.T) {
@@ -166,3 +202,459 @@ func TestSendStreamingPromptEmitsErrorChunkOnFailure(t *testing.T) {
 		t.Fatalf("expected error chunk callback on stream failure")
 	}
 }
+
+func TestPromptsArePassedOverStdin(t *testing.T) {
+	setupFakeCursorAgent(t)
+	t.Setenv("ECHO_STDIN", "1")
+	bridge := newTestBridge()
+	// Far beyond Linux's 128KiB per-argument limit.
+	prompt := strings.Repeat("a", 4*1024*1024)
+
+	result, err := bridge.SendPrompt(PromptOptions{SessionID: "s1", Content: prompt})
+	if err != nil || !result.Success {
+		t.Fatalf("SendPrompt failed: %v %#v", err, result)
+	}
+	if want := fmt.Sprintf("stdin=%d args=4", len(prompt)); result.Text != want {
+		t.Fatalf("expected prompt on stdin, got %q", result.Text)
+	}
+
+	streamed, err := bridge.SendStreamingPrompt(StreamingPromptOptions{SessionID: "s1", Content: prompt})
+	if err != nil || !streamed.Success {
+		t.Fatalf("SendStreamingPrompt failed: %v %#v", err, streamed)
+	}
+	if want := fmt.Sprintf("stdin=%d args=6", len(prompt)); streamed.Text != want {
+		t.Fatalf("expected streamed prompt on stdin, got %q", streamed.Text)
+	}
+}
+
+func newPooledTestBridge() *Bridge {
+	cfg := config.Default()
+	cfg.Cursor.Timeout = 2000
+	cfg.Cursor.Retries = 0
+	cfg.Cursor.ProcessPool.Enabled = true
+	return NewBridge(cfg, logging.New("error"))
+}
+
+func TestProcessPoolReusesAndRespawnsProcesses(t *testing.T) {
+	setupFakeCursorAgent(t)
+	bridge := newPooledTestBridge()
+	defer bridge.Close()
+
+	send := func() string {
+		t.Helper()
+		result, err := bridge.SendPrompt(PromptOptions{SessionID: "s1", Content: "hello\nworld"})
+		if err != nil || !result.Success {
+			t.Fatalf("SendPrompt failed: %v %#v", err, result)
+		}
+		return result.Text
+	}
+
+	first := send()
+	pid := strings.TrimSuffix(first, " turn=1")
+	if pid == first {
+		t.Fatalf("unexpected first reply %q", first)
+	}
+	if second := send(); second != pid+" turn=2" {
+		t.Fatalf("expected the same process to answer the second turn, got %q after %q", second, first)
+	}
+
+	proc := bridge.pool.procs["s1"]
+	_ = proc.cmd.Process.Kill()
+	<-proc.exited
+	if third := send(); third == pid+" turn=3" || !strings.HasSuffix(third, " turn=1") {
+		t.Fatalf("expected a respawned process, got %q", third)
+	}
+
+	if err := bridge.CloseSession("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := bridge.pool.size(); n != 0 {
+		t.Fatalf("expected CloseSession to stop the process, pool size %d", n)
+	}
+}
+
+func TestProcessPoolKeysOnEnvironmentAndCwd(t *testing.T) {
+	setupFakeCursorAgent(t)
+	bridge := newPooledTestBridge()
+	defer bridge.Close()
+
+	send := func(cwd string) string {
+		t.Helper()
+		result, err := bridge.SendPrompt(PromptOptions{SessionID: "s1", Content: "hi", Metadata: map[string]any{"cwd": cwd}})
+		if err != nil || !result.Success {
+			t.Fatalf("SendPrompt failed: %v %#v", err, result)
+		}
+		return result.Text
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, second := send("."), send(wd); !strings.HasSuffix(second, " turn=2") {
+		t.Fatalf("expected the same directory to reuse the process, got %q after %q", second, first)
+	}
+	t.Setenv("HOME", t.TempDir())
+	if third := send(wd); !strings.HasSuffix(third, " turn=1") {
+		t.Fatalf("expected an environment change to restart the process, got %q", third)
+	}
+}
+
+func TestProcessPoolStreamsAndReapsIdleProcesses(t *testing.T) {
+	setupFakeCursorAgent(t)
+	bridge := newPooledTestBridge()
+	defer bridge.Close()
+
+	chunkTypes := make([]string, 0)
+	result, err := bridge.SendStreamingPrompt(StreamingPromptOptions{
+		SessionID: "s1",
+		Content:   "hello",
+		OnChunk: func(chunk StreamChunk) error {
+			chunkTypes = append(chunkTypes, chunk.Type)
+			return nil
+		},
+	})
+	if err != nil || !result.Success {
+		t.Fatalf("SendStreamingPrompt failed: %v %#v", err, result)
+	}
+	if strings.Join(chunkTypes, ",") != "content,content,done" || !strings.Contains(result.Text, "turn=1") {
+		t.Fatalf("unexpected stream: %v %q", chunkTypes, result.Text)
+	}
+
+	bridge.pool.cfg.IdleTimeout = 1
+	time.Sleep(5 * time.Millisecond)
+	bridge.pool.checkHealth()
+	if n := bridge.pool.size(); n != 0 {
+		t.Fatalf("expected idle process to be reaped, pool size %d", n)
+	}
+}
+
+func TestVersionAndAuthAreCachedUntilRefresh(t *testing.T) {
+	setupFakeCursorAgent(t)
+	callLog := filepath.Join(t.TempDir(), "calls")
+	t.Setenv("CALL_LOG", callLog)
+	bridge := newTestBridge()
+	now := time.Now()
+	bridge.now = func() time.Time { return now }
+	calls := func() int {
+		buf, _ := os.ReadFile(callLog)
+		return strings.Count(string(buf), "\n")
+	}
+
+	for i := 0; i < 3; i++ {
+		if version, err := bridge.GetVersion(); err != nil || version != "1.2.3" {
+			t.Fatalf("GetVersion = %q, %v", version, err)
+		}
+		if !bridge.CheckAuthentication().Authenticated {
+			t.Fatal("expected authenticated status")
+		}
+	}
+	if n := calls(); n != 2 {
+		t.Fatalf("expected one version and one status call, got %d", n)
+	}
+
+	bridge.InvalidateCache()
+	_, _ = bridge.GetVersion()
+	if n := calls(); n != 3 {
+		t.Fatalf("expected refresh to re-run --version, got %d calls", n)
+	}
+
+	now = now.Add(time.Duration(bridge.cfg.Cursor.CacheTTL)*time.Millisecond + time.Second)
+	_ = bridge.CheckAuthentication()
+	if n := calls(); n != 4 {
+		t.Fatalf("expected expired auth status to be refetched, got %d calls", n)
+	}
+
+	// Failures are not cached: the unsupported models command runs every time.
+	_, _ = bridge.ListModels()
+	_, _ = bridge.ListModels()
+	if n := calls(); n != 6 {
+		t.Fatalf("expected failed model listings to be retried, got %d calls", n)
+	}
+}
+
+func TestBinaryPathAndEnvOverrides(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf '{\"result\":\"%s %s\"}\\n' \"${0##*/}\" \"$PROXY_NAME\"\n"
+	for _, name := range []string{"custom-agent", "cursor-agent"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Cursor.Timeout = 2000
+	cfg.Cursor.Retries = 0
+	cfg.Cursor.BinaryPath = filepath.Join(dir, "custom-agent")
+	cfg.Cursor.Env = map[string]string{"PROXY_NAME": "from-config"}
+	bridge := NewBridge(cfg, logging.New("error"))
+
+	result, err := bridge.SendPrompt(PromptOptions{Content: "hi"})
+	if err != nil || result.Text != "custom-agent from-config" {
+		t.Fatalf("expected configured binary and env, got %q (%v)", result.Text, err)
+	}
+
+	// The session's PATH resolves the bare name and its env wins over config.
+	result, err = bridge.SendPrompt(PromptOptions{Content: "hi", Metadata: map[string]any{
+		"cursorBinaryPath": "cursor-agent",
+		"cursorEnv":        map[string]any{"PATH": dir, "PROXY_NAME": "from-session"},
+	}})
+	if err != nil || result.Text != "cursor-agent from-session" {
+		t.Fatalf("expected session overrides, got %q (%v)", result.Text, err)
+	}
+
+	// Session overrides cannot pass variables the environment policy withholds.
+	if _, err := bridge.SendPrompt(PromptOptions{Content: "hi", Metadata: map[string]any{
+		"cursorEnv": map[string]any{"GITHUB_TOKEN": "ghp_secret"},
+	}}); err == nil || !strings.Contains(err.Error(), "GITHUB_TOKEN") {
+		t.Fatalf("expected the secret override to be rejected, got %v", err)
+	}
+
+	// A reloaded policy applies to the next call.
+	bridge.SetEnvironment(config.EnvironmentConfig{Deny: []string{"PROXY_*"}})
+	if _, err := bridge.SendPrompt(PromptOptions{Content: "hi", Metadata: map[string]any{
+		"cursorEnv": map[string]any{"PROXY_NAME": "from-session"},
+	}}); err == nil || !strings.Contains(err.Error(), "PROXY_NAME") {
+		t.Fatalf("expected the reloaded policy to reject PROXY_NAME, got %v", err)
+	}
+}
+
+func TestBinaryDiscoveryFallsBackToInstallLocations(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", t.TempDir())
+	broken := filepath.Join(home, ".local", "bin", "cursor-agent")
+	working := filepath.Join(home, ".cursor", "bin", "cursor-agent")
+	for path, script := range map[string]string{
+		broken:  "#!/bin/sh\nexit 1\n",
+		working: "#!/bin/sh\necho 'cursor-agent 2.0.1'\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bridge := newTestBridge()
+	loc := bridge.BinaryLocation()
+	if loc.Path != working || loc.Source != BinarySourceDiscovered || loc.Version != "2.0.1" {
+		t.Fatalf("expected the working install to be discovered, got %#v", loc)
+	}
+	if version, err := bridge.GetVersion(); err != nil || version != "2.0.1" {
+		t.Fatalf("expected commands to use the discovered binary, got %q (%v)", version, err)
+	}
+
+	if err := os.Remove(working); err != nil {
+		t.Fatal(err)
+	}
+	bridge.InvalidateCache()
+	if loc := bridge.BinaryLocation(); loc.Source != BinarySourceNotFound || loc.Path != "cursor-agent" {
+		t.Fatalf("expected not_found after the binary was removed, got %#v", loc)
+	}
+}
+
+func TestSessionPathPicksTheDefaultBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	home, sessionBin := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", t.TempDir())
+	installed := filepath.Join(home, ".local", "bin", "cursor-agent")
+	if err := os.MkdirAll(filepath.Dir(installed), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && { echo 'cursor-agent 2.0.1'; exit 0; }\nprintf '{\"result\":\"%s\"}\\n' \"$0\"\n"
+	for _, path := range []string{installed, filepath.Join(sessionBin, "cursor-agent")} {
+		if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Cursor.Timeout = 2000
+	cfg.Cursor.Retries = 0
+	bridge := NewBridge(cfg, logging.New("error"))
+	result, err := bridge.SendPrompt(PromptOptions{Content: "hi"})
+	if err != nil || result.Text != installed {
+		t.Fatalf("expected the discovered binary, got %q (%v)", result.Text, err)
+	}
+	result, err = bridge.SendPrompt(PromptOptions{Content: "hi", Metadata: map[string]any{
+		"cursorEnv": map[string]any{"PATH": sessionBin},
+	}})
+	if want := filepath.Join(sessionBin, "cursor-agent"); err != nil || result.Text != want {
+		t.Fatalf("expected the session PATH to pick %s, got %q (%v)", want, result.Text, err)
+	}
+}
+
+func TestBridgeFailuresAreTyped(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	write := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	bridgeFor := func(binary string, retries int) *Bridge {
+		cfg := config.Default()
+		cfg.Cursor.Timeout = 2000
+		cfg.Cursor.Retries = retries
+		cfg.Cursor.BinaryPath = binary
+		return NewBridge(cfg, logging.New("error"))
+	}
+
+	start := time.Now()
+	_, err := bridgeFor(filepath.Join(dir, "missing"), 3).ExecuteCommand(nil, []string{"--version"}, CommandOptions{})
+	if !errors.Is(err, cursorerr.ErrNotInstalled) || cursorerr.Reason(err) != "not_installed" {
+		t.Fatalf("expected cursorerr.ErrNotInstalled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("a missing binary should not be retried")
+	}
+
+	_, err = bridgeFor(write("killed", "kill -9 $$\n"), 0).ExecuteCommand(nil, []string{"--version"}, CommandOptions{Timeout: time.Second})
+	if !errors.Is(err, cursorerr.ErrKilled) {
+		t.Fatalf("expected cursorerr.ErrKilled, got %v", err)
+	}
+
+	result, err := bridgeFor(write("logged-out", "echo 'Error: not logged in. Run cursor-agent login' >&2\nexit 1\n"), 0).SendPrompt(PromptOptions{Content: "hi"})
+	if err != nil || result.Success || !errors.Is(result.Err, cursorerr.ErrNotAuthenticated) {
+		t.Fatalf("expected cursorerr.ErrNotAuthenticated result, got %#v (%v)", result, err)
+	}
+
+	streamed, err := bridgeFor(write("limited", "echo 'Too many requests, slow down' >&2\nexit 1\n"), 0).SendStreamingPrompt(StreamingPromptOptions{Content: "hi"})
+	if err != nil || streamed.Success || !errors.Is(streamed.Err, cursorerr.ErrRateLimited) {
+		t.Fatalf("expected cursorerr.ErrRateLimited result, got %#v (%v)", streamed, err)
+	}
+
+	result, err = bridgeFor(write("no-chat", "echo 'Error: chat not found: abc' >&2\nexit 1\n"), 0).SendPrompt(PromptOptions{Content: "hi", Metadata: map[string]any{"cursorChatId": "abc"}})
+	if err != nil || result.Success || !errors.Is(result.Err, cursorerr.ErrChatNotFound) || cursorerr.Reason(result.Err) != "chat_not_found" {
+		t.Fatalf("expected cursorerr.ErrChatNotFound result, got %#v (%v)", result, err)
+	}
+}
+
+func TestResourceLimitsStopCursorAgent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	bridgeFor := func(script string, limits config.CursorLimitsConfig) *Bridge {
+		path := filepath.Join(dir, fmt.Sprintf("agent-%d", time.Now().UnixNano()))
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		cfg := config.Default()
+		cfg.Cursor.Timeout = 10000
+		cfg.Cursor.Retries = 0
+		cfg.Cursor.BinaryPath = path
+		cfg.Cursor.Limits = limits
+		return NewBridge(cfg, logging.New("error"))
+	}
+
+	chatty := bridgeFor("while :; do echo '{\"content\":\"x\"}'; done\n", config.CursorLimitsConfig{MaxOutputBytes: 1024})
+	_, err := chatty.SendStreamingPrompt(StreamingPromptOptions{Content: "hi"})
+	if !errors.Is(err, cursorerr.ErrResourceLimit) || cursorerr.Reason(err) != "resource_limit" {
+		t.Fatalf("expected cursorerr.ErrResourceLimit from streaming output cap, got %v", err)
+	}
+	_, err = chatty.ExecuteCommand(nil, []string{"--version"}, CommandOptions{Timeout: 5 * time.Second})
+	if !errors.Is(err, cursorerr.ErrResourceLimit) {
+		t.Fatalf("expected cursorerr.ErrResourceLimit from command output cap, got %v", err)
+	}
+
+	quiet := bridgeFor("echo ok\n", config.CursorLimitsConfig{MaxOutputBytes: 1024, MaxMemoryMB: 512, MaxCPUSeconds: 5, Nice: 5})
+	if res, err := quiet.ExecuteCommand(nil, []string{"--version"}, CommandOptions{}); err != nil || strings.TrimSpace(res.Stdout) != "ok" {
+		t.Fatalf("expected a process within its limits to succeed, got %#v (%v)", res, err)
+	}
+
+	memory := config.CursorLimitsConfig{MaxMemoryMB: 512}
+	complaining := bridgeFor("echo 'fatal: out of memory' >&2\nexit 1\n", memory)
+	if streamed, err := complaining.SendStreamingPrompt(StreamingPromptOptions{Content: "hi"}); err != nil || errors.Is(streamed.Err, cursorerr.ErrResourceLimit) {
+		t.Fatalf("expected stderr alone not to count as the memory limit, got %#v (%v)", streamed, err)
+	}
+	aborted := bridgeFor("kill -ABRT $$\n", memory)
+	if streamed, err := aborted.SendStreamingPrompt(StreamingPromptOptions{Content: "hi"}); err != nil || !errors.Is(streamed.Err, cursorerr.ErrResourceLimit) || !strings.Contains(streamed.Err.Error(), "maxMemoryMb") {
+		t.Fatalf("expected cursorerr.ErrResourceLimit from an abort under the memory limit, got %#v (%v)", streamed, err)
+	}
+
+	spinning := bridgeFor("while :; do :; done\n", config.CursorLimitsConfig{MaxCPUSeconds: 1})
+	streamed, err := spinning.SendStreamingPrompt(StreamingPromptOptions{Content: "hi"})
+	if err != nil || streamed.Success || !errors.Is(streamed.Err, cursorerr.ErrResourceLimit) {
+		t.Fatalf("expected cursorerr.ErrResourceLimit from the CPU limit, got %#v (%v)", streamed, err)
+	}
+}
+
+func TestLongResponsesAreTruncated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cursor-agent")
+	script := `#!/bin/sh
+for arg in "$@"; do
+  if [ "$arg" = "json" ]; then
+    printf '{"type":"result","result":"'
+    while :; do printf 'all work and no play '; done
+  fi
+done
+while :; do echo '{"content":"chunk"}'; done
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Cursor.Timeout = 10000
+	cfg.Cursor.Retries = 0
+	cfg.Cursor.BinaryPath = path
+	cfg.Cursor.MaxResponseBytes = 1000
+	bridge := NewBridge(cfg, logging.New("error"))
+
+	var done any
+	streamed, err := bridge.SendStreamingPrompt(StreamingPromptOptions{Content: "hi", OnChunk: func(chunk StreamChunk) error {
+		if chunk.Type == "done" {
+			done = chunk.Data
+		}
+		return nil
+	}})
+	if err != nil || !streamed.Success {
+		t.Fatalf("expected a truncated success, got %#v (%v)", streamed, err)
+	}
+	if len(streamed.Raw) > 1000 || !strings.HasPrefix(streamed.Text, "chunk") || streamed.Metadata["tokenLimitReached"] != true {
+		t.Fatalf("expected partial output within the limit, got %d bytes, %#v", len(streamed.Raw), streamed.Metadata)
+	}
+	if d, _ := done.(map[string]any); d["truncated"] != true || d["complete"] != false {
+		t.Fatalf("expected a truncated done chunk, got %#v", done)
+	}
+
+	result, err := bridge.SendPrompt(PromptOptions{Content: "hi"})
+	if err != nil || !result.Success || result.Metadata["partialCompletion"] != true {
+		t.Fatalf("expected a truncated success, got %#v (%v)", result, err)
+	}
+	if !strings.HasPrefix(result.Text, "all work and no play") || strings.Contains(result.Text, `"`) {
+		t.Fatalf("expected the partial result text, got %q", result.Text)
+	}
+}
+
+func TestPartialJSONString(t *testing.T) {
+	for data, want := range map[string]string{
+		`{"result":"done","x":1}`:                                  "done",
+		`{"type":"result","result": "line\nbreak and a é cut \u00`: "line\nbreak and a é cut ",
+		`{"result":"trailing escape \`:                             "trailing escape ",
+		`{"other":"value"}`:                                        "",
+	} {
+		if got := partialJSONString(data, "result"); got != want {
+			t.Errorf("partialJSONString(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package cursor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+// cachedValue memoizes one cursor-agent query for a TTL. The lock is held
+// while fetching so concurrent callers share a single CLI invocation.
+// Failed fetches are not cached.
+type cachedValue[T any] struct {
+	mu        sync.Mutex
+	value     T
+	fetchedAt time.Time
+	valid     bool
+}
+
+func (c *cachedValue[T]) get(ttl time.Duration, now time.Time, fetch func() (T, bool)) T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl > 0 && c.valid && now.Sub(c.fetchedAt) < ttl {
+		return c.value
+	}
+	value, ok := fetch()
+	if ok && ttl > 0 {
+		c.value, c.fetchedAt, c.valid = value, now, true
+	} else {
+		c.valid = false
+	}
+	return value
+}
+
+func (c *cachedValue[T]) invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.mu.Unlock()
+}
+
+type versionResult struct {
+	version string
+	err     error
+}
+
+type modelsResult struct {
+	models []acp.SessionModel
+	err    error
+}
+
+// cliCache holds the results initialize asks for on every connection.
+type cliCache struct {
+	version cachedValue[versionResult]
+	auth    cachedValue[AuthStatus]
+	models  cachedValue[modelsResult]
+}
+
+func (c *cliCache) invalidate() {
+	c.version.invalidate()
+	c.auth.invalidate()
+	c.models.invalidate()
+}
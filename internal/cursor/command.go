@@ -0,0 +1,167 @@
+package cursor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+const defaultBinary = "cursor-agent"
+
+// Session metadata keys that override cursor.binaryPath and cursor.env for
+// that session's prompts.
+const (
+	metadataBinaryPath = "cursorBinaryPath"
+	metadataEnv        = "cursorEnv"
+)
+
+// commandSpec describes how to launch cursor-agent for one call: the binary,
+// the KEY=VALUE pairs set on top of the adapter's environment, which is
+// inherited minus what the environment policy withholds, and the resource
+// limits it runs under.
+type commandSpec struct {
+	binary    string
+	env       []string
+	inherited []string
+	limits    config.CursorLimitsConfig
+}
+
+// spec merges config with per-call overrides. Later env entries win. Without
+// a binary override or cursor.binaryPath, cursor-agent is looked up on the
+// PATH of the merged env, so a session's PATH decides which one runs; the
+// discovered location is the fallback.
+func (b *Bridge) spec(binary string, env []string) commandSpec {
+	merged := append(envList(b.cfg.Cursor.Env), env...)
+	inherited := b.envPolicy.Load().Filter(os.Environ())
+	if binary == "" {
+		loc := b.BinaryLocation()
+		binary = loc.Path
+		if loc.Source != BinarySourceConfig {
+			if path := resolveBinary(defaultBinary, append(slices.Clip(inherited), merged...)); path != defaultBinary {
+				binary = path
+			}
+		}
+	}
+	return commandSpec{binary: binary, env: merged, inherited: inherited, limits: b.cfg.Cursor.Limits}
+}
+
+// checkOverrideEnv rejects session env overrides the environment policy
+// withholds. cursor.env is the operator's own configuration and is trusted.
+func (b *Bridge) checkOverrideEnv(env []string) error {
+	names := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		names = append(names, name)
+	}
+	if err := b.envPolicy.Load().Check(names...); err != nil {
+		return fmt.Errorf("%s: %w", metadataEnv, err)
+	}
+	return nil
+}
+
+// sessionOverrides reads the per-session binary and env from prompt metadata.
+func sessionOverrides(metadata map[string]any) (string, []string) {
+	binary, _ := metadata[metadataBinaryPath].(string)
+	var env []string
+	switch values := metadata[metadataEnv].(type) {
+	case map[string]string:
+		env = envList(values)
+	case map[string]any:
+		strs := make(map[string]string, len(values))
+		for k, v := range values {
+			if s, ok := v.(string); ok {
+				strs[k] = s
+			}
+		}
+		env = envList(strs)
+	}
+	return strings.TrimSpace(binary), env
+}
+
+// command builds the exec.Cmd, which is killed when ctx ends. A process that
+// outlives the call, as the process pool needs, gets a context of its own.
+func (c commandSpec) command(ctx context.Context, args ...string) *exec.Cmd {
+	env := c.environ()
+	cmd := exec.CommandContext(ctx, resolveBinary(c.binary, env), args...)
+	cmd.Env = env
+	platformCommand(cmd)
+	limitCommand(cmd, c.limits)
+	return cmd
+}
+
+// processWaitDelay bounds how long Wait waits for output pipes after the
+// process exits or is killed, in case something outside its group holds them.
+const processWaitDelay = 5 * time.Second
+
+// startProcess starts cmd in a process group of its own (a job object on
+// Windows) and kills the whole group when the command's context ends, so
+// cancelling a prompt also stops whatever cursor-agent spawned. limits are applied to the group once it exists.
+func startProcess(cmd *exec.Cmd, limits config.CursorLimitsConfig) error {
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = processWaitDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	afterStart(cmd, limits)
+	return nil
+}
+
+// Command builds a cursor-agent command with the configured binary and env,
+// for callers that drive the CLI directly (e.g. interactive login). It is
+// killed when ctx ends.
+func (b *Bridge) Command(ctx context.Context, args ...string) *exec.Cmd {
+	return b.spec("", nil).command(ctx, args...)
+}
+
+// environ is the environment the command runs with: the inherited variables
+// the env policy allows, then the configured and per-call ones.
+func (c commandSpec) environ() []string {
+	return append(slices.Clip(c.inherited), c.env...)
+}
+
+// key identifies the binary and limits for process reuse; the pool keys the
+// environment separately.
+func (c commandSpec) key() string {
+	return c.binary + "\x00" + fmt.Sprint(c.limits)
+}
+
+// resolveBinary looks a bare command name up on the PATH from env, so a PATH
+// set in cursor.env takes effect. exec.Command would search the adapter's
+// own PATH instead. On Windows the PATHEXT extensions are tried, so
+// "cursor-agent" finds cursor-agent.exe or cursor-agent.cmd.
+func resolveBinary(binary string, env []string) string {
+	if strings.ContainsRune(binary, filepath.Separator) || strings.ContainsRune(binary, '/') {
+		return binary
+	}
+	names := executableNames(binary, env)
+	for _, dir := range filepath.SplitList(envValue(env, "PATH")) {
+		if dir == "" {
+			continue
+		}
+		for _, name := range names {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && isExecutable(info) {
+				return candidate
+			}
+		}
+	}
+	return binary
+}
+
+func envList(values map[string]string) []string {
+	out := make([]string, 0, len(values))
+	for k, v := range values {
+		out = append(out, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(out)
+	return out
+}
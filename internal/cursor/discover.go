@@ -0,0 +1,117 @@
+package cursor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 5 * time.Second
+
+// Binary sources reported by BinaryLocation.
+const (
+	BinarySourceConfig     = "config"
+	BinarySourcePath       = "path"
+	BinarySourceDiscovered = "discovered"
+	BinarySourceNotFound   = "not_found"
+)
+
+// BinaryLocation is the cursor-agent executable the bridge runs by default.
+type BinaryLocation struct {
+	Path    string `json:"path"`
+	Source  string `json:"source"`
+	Version string `json:"version,omitempty"`
+}
+
+// BinaryLocation resolves the default cursor-agent binary: cursor.binaryPath,
+// then the PATH, then the common install locations. The result is kept until
+// InvalidateCache.
+func (b *Bridge) BinaryLocation() BinaryLocation {
+	b.binaryMu.Lock()
+	defer b.binaryMu.Unlock()
+	if b.binary == nil {
+		loc := b.discoverBinary()
+		b.binary = &loc
+		fields := map[string]any{"path": loc.Path, "source": loc.Source}
+		switch loc.Source {
+		case BinarySourceDiscovered:
+			b.logger.Info("Found cursor-agent outside PATH", fields)
+		case BinarySourceNotFound:
+			b.logger.Warn("cursor-agent not found on PATH or in common install locations", fields)
+		default:
+			b.logger.Debug("Resolved cursor-agent binary", fields)
+		}
+	}
+	return *b.binary
+}
+
+func (b *Bridge) discoverBinary() BinaryLocation {
+	if configured := strings.TrimSpace(b.cfg.Cursor.BinaryPath); configured != "" {
+		return BinaryLocation{Path: configured, Source: BinarySourceConfig}
+	}
+	env := append(b.envPolicy.Load().Filter(os.Environ()), envList(b.cfg.Cursor.Env)...)
+	if path := resolveBinary(defaultBinary, env); path != defaultBinary {
+		return BinaryLocation{Path: path, Source: BinarySourcePath}
+	}
+
+	home, _ := os.UserHomeDir()
+	for _, candidate := range binaryCandidates(runtime.GOOS, home, os.Getenv("LOCALAPPDATA")) {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		version, ok := probeBinary(candidate, env)
+		if !ok {
+			b.logger.Debug("Skipping cursor-agent candidate that failed --version", map[string]any{"path": candidate})
+			continue
+		}
+		return BinaryLocation{Path: candidate, Source: BinarySourceDiscovered, Version: version}
+	}
+	return BinaryLocation{Path: defaultBinary, Source: BinarySourceNotFound}
+}
+
+// binaryCandidates lists where the cursor-agent installers put the binary.
+func binaryCandidates(goos, home, localAppData string) []string {
+	var dirs []string
+	names := []string{defaultBinary}
+	if goos == "windows" {
+		names = []string{defaultBinary + ".exe", defaultBinary + ".cmd"}
+		if localAppData != "" {
+			dirs = append(dirs, filepath.Join(localAppData, "cursor-agent"), filepath.Join(localAppData, "Programs", "cursor-agent"))
+		}
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, ".cursor", "bin"), filepath.Join(home, ".local", "bin"))
+		}
+	} else {
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, ".local", "bin"), filepath.Join(home, ".cursor", "bin"))
+		}
+		dirs = append(dirs, "/usr/local/bin", "/opt/homebrew/bin")
+	}
+
+	var out []string
+	for _, dir := range dirs {
+		for _, name := range names {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	return out
+}
+
+// probeBinary checks that path runs and answers --version.
+func probeBinary(path string, env []string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Env = env
+	platformCommand(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return parseVersion(string(out)), true
+}
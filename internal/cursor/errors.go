@@ -0,0 +1,82 @@
+package cursor
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+)
+
+// startError types a failure to launch the binary.
+func startError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return &cursorerr.Error{Kind: cursorerr.ErrNotInstalled, Message: err.Error()}
+	}
+	return err
+}
+
+// classifyOutput recognises auth, rate-limit and --resume failures in CLI
+// output. It returns nil for anything else.
+func classifyOutput(message string) error {
+	msg := strings.ToLower(message)
+	switch {
+	case strings.Contains(msg, "not authenticated"), strings.Contains(msg, "not logged in"),
+		strings.Contains(msg, "unauthorized"), strings.Contains(msg, "please log in"),
+		strings.Contains(msg, "cursor-agent login"):
+		return &cursorerr.Error{Kind: cursorerr.ErrNotAuthenticated, Message: strings.TrimSpace(message)}
+	case strings.Contains(msg, "rate limit"), strings.Contains(msg, "too many requests"):
+		return &cursorerr.Error{Kind: cursorerr.ErrRateLimited, Message: strings.TrimSpace(message)}
+	case strings.Contains(msg, "chat not found"), strings.Contains(msg, "no chat found"),
+		strings.Contains(msg, "conversation not found"), strings.Contains(msg, "could not resume"),
+		strings.Contains(msg, "failed to resume"), strings.Contains(msg, "invalid chat id"):
+		return &cursorerr.Error{Kind: cursorerr.ErrChatNotFound, Message: strings.TrimSpace(message)}
+	default:
+		return nil
+	}
+}
+
+// exitError types a process that ran but did not succeed: stopped for
+// exceeding limits, killed by a signal (other than through ctx) or failing
+// with recognisable output.
+func exitError(ctx context.Context, err error, output string, limits config.CursorLimitsConfig) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &cursorerr.Error{Kind: cursorerr.ErrTimeout, Message: ctx.Err().Error()}
+	}
+	if ctx.Err() == nil {
+		if limitErr := limitError(err, output, limits); limitErr != nil {
+			return limitErr
+		}
+	}
+	if exitErr := new(exec.ExitError); ctx.Err() == nil && errors.As(err, &exitErr) && exitErr.ExitCode() == -1 {
+		message := strings.TrimSpace(output)
+		if message == "" {
+			message = exitErr.Error()
+		}
+		return &cursorerr.Error{Kind: cursorerr.ErrKilled, Message: message}
+	}
+	if typed := classifyError(err); typed != nil {
+		return typed
+	}
+	return classifyOutput(output)
+}
+
+// classifyError returns err as a typed failure when its kind is known, or nil.
+func classifyError(err error) error {
+	var typed *cursorerr.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typed):
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		return &cursorerr.Error{Kind: cursorerr.ErrTimeout, Message: err.Error()}
+	}
+	if started := startError(err); started != err {
+		return started
+	}
+	return classifyOutput(err.Error())
+}
@@ -0,0 +1,96 @@
+//go:build !windows
+
+package cursor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// executableNames lists the file names a PATH search tries for name.
+func executableNames(name string, _ []string) []string {
+	return []string{name}
+}
+
+func isExecutable(info os.FileInfo) bool {
+	return !info.IsDir() && info.Mode()&0o111 != 0
+}
+
+// platformCommand adapts cmd to the platform; Unix runs every executable
+// directly.
+func platformCommand(*exec.Cmd) {}
+
+// setProcessGroup makes the process the leader of a new process group, which
+// the processes it spawns join.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// limitCommand runs cmd through sh when cursor.limits caps memory or CPU
+// time: the shell sets the rlimits with ulimit and execs the binary, so they
+// hold from its first instruction and for everything it spawns.
+func limitCommand(cmd *exec.Cmd, limits config.CursorLimitsConfig) {
+	var script []string
+	if limits.MaxMemoryMB > 0 {
+		script = append(script, fmt.Sprintf("ulimit -d %d", limits.MaxMemoryMB*1024))
+	}
+	if limits.MaxCPUSeconds > 0 {
+		// SIGXCPU at the soft limit; SIGKILL a second later if ignored.
+		script = append(script, fmt.Sprintf("ulimit -S -t %d", limits.MaxCPUSeconds), fmt.Sprintf("ulimit -H -t %d", limits.MaxCPUSeconds+1))
+	}
+	if len(script) == 0 || cmd.Err != nil {
+		return
+	}
+	// Leave a missing binary for Start to report as not installed.
+	if _, err := os.Stat(cmd.Path); err != nil {
+		return
+	}
+	script = append(script, `exec "$0" "$@"`)
+	cmd.Args = append([]string{"sh", "-c", strings.Join(script, " && "), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
+
+// afterStart lowers the priority of the new process group to
+// cursor.limits.nice.
+func afterStart(cmd *exec.Cmd, limits config.CursorLimitsConfig) {
+	if limits.Nice > 0 {
+		_ = syscall.Setpriority(syscall.PRIO_PGRP, cmd.Process.Pid, limits.Nice)
+	}
+}
+
+// cpuLimitExit reports a process killed by SIGXCPU, which the kernel sends
+// once the CPU time rlimit is used up.
+func cpuLimitExit(exitErr *exec.ExitError) bool {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
+
+// memoryLimitExit reports a process that died the way one over its memory
+// rlimit does: aborted (SIGABRT) after an allocation failed, or killed
+// (SIGKILL) by the kernel.
+func memoryLimitExit(exitErr *exec.ExitError) bool {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && (status.Signal() == syscall.SIGABRT || status.Signal() == syscall.SIGKILL)
+}
+
+// killProcessGroup kills the process group startProcess created, or just
+// the process when it has none.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err == nil {
+			return nil
+		}
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package cursor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestResolveBinarySkipsNonExecutableFiles(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(first, "cursor-agent"), []byte("not executable"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(second, "cursor-agent"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"PATH=" + first + string(os.PathListSeparator) + second}
+	if got := resolveBinary("cursor-agent", env); got != filepath.Join(second, "cursor-agent") {
+		t.Fatalf("resolveBinary = %q, want the executable in %s", got, second)
+	}
+	if got := resolveBinary("./cursor-agent", env); got != "./cursor-agent" {
+		t.Fatalf("paths must not be searched, got %q", got)
+	}
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	script := filepath.Join(dir, "cursor-agent")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nsleep 30 &\necho $! > "+pidFile+"\nwait\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := commandSpec{binary: script}.command(ctx)
+	if err := startProcess(cmd, config.CursorLimitsConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var child int
+	for deadline := time.Now().Add(5 * time.Second); child == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the script did not start its child")
+		}
+		data, _ := os.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	cancel()
+	_ = cmd.Wait()
+
+	for deadline := time.Now().Add(5 * time.Second); processAlive(child); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(child, syscall.SIGKILL)
+			t.Fatalf("child %d outlived its cancelled parent", child)
+		}
+	}
+}
+
+// processAlive treats a zombie waiting to be reaped as dead.
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
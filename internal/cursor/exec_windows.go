@@ -0,0 +1,219 @@
+//go:build windows
+
+package cursor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// executableNames lists the file names a PATH search tries for name: name
+// with each PATHEXT extension, as cmd.exe does.
+func executableNames(name string, env []string) []string {
+	return pathExtNames(name, envValue(env, "PATHEXT"))
+}
+
+// isExecutable accepts any file; the extension decides what Windows runs.
+func isExecutable(info os.FileInfo) bool {
+	return !info.IsDir()
+}
+
+// platformCommand runs .cmd and .bat scripts (as npm installs cursor-agent)
+// through cmd.exe with an escaped command line, rather than letting
+// CreateProcess hand the raw arguments to cmd.exe.
+func platformCommand(cmd *exec.Cmd) {
+	if !isBatchFile(cmd.Path) {
+		return
+	}
+	comspec := envValue(cmd.Env, "ComSpec")
+	if comspec == "" {
+		comspec = os.Getenv("ComSpec")
+	}
+	if comspec == "" {
+		comspec = filepath.Join(os.Getenv("SystemRoot"), "System32", "cmd.exe")
+	}
+	script, args := cmd.Path, cmd.Args[1:]
+	cmd.Path = comspec
+	cmd.Args = append([]string{comspec, "/d", "/s", "/c", script}, args...)
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CmdLine = cmdLine(comspec, script, args)
+}
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+)
+
+const (
+	processSetQuota                   = 0x0100
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x2000
+	jobObjectLimitProcessTime         = 0x0002
+	jobObjectLimitProcessMemory       = 0x0100
+
+	belowNormalPriorityClass = 0x4000
+	idlePriorityClass        = 0x0040
+
+	// errorNotEnoughQuota is the exit code of a process the job ended for
+	// using up its CPU time.
+	errorNotEnoughQuota = 1816
+	// statusNoMemory is the exit code of a process that died because an
+	// allocation failed, as one over the job's memory limit does.
+	statusNoMemory = 0xC0000017
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type jobObjectExtendedLimit struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                [6]uint64
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// processJob is the job object a started process was assigned to. It is
+// closed once the process exits, which kills anything it left running.
+type processJob struct {
+	mu     sync.Mutex
+	handle syscall.Handle
+}
+
+func (j *processJob) terminate() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.handle == 0 {
+		return false
+	}
+	r, _, _ := procTerminateJobObject.Call(uintptr(j.handle), 1)
+	return r != 0
+}
+
+func (j *processJob) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.handle != 0 {
+		_ = syscall.CloseHandle(j.handle)
+		j.handle = 0
+	}
+}
+
+var jobs sync.Map // *exec.Cmd -> *processJob
+
+// setProcessGroup is a no-op: the process joins a job object once started.
+func setProcessGroup(*exec.Cmd) {}
+
+// limitCommand starts the process below normal priority when
+// cursor.limits.nice is set, at idle priority from 15 up. Memory and CPU
+// caps are job object limits, set in afterStart.
+func limitCommand(cmd *exec.Cmd, limits config.CursorLimitsConfig) {
+	if limits.Nice <= 0 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if limits.Nice >= 15 {
+		cmd.SysProcAttr.CreationFlags |= idlePriorityClass
+	} else {
+		cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+	}
+}
+
+// afterStart assigns the process to a new job object with
+// JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE and the memory and CPU caps of limits;
+// the processes it spawns from then on join the job too. Without a job the
+// process is killed on its own and runs unlimited.
+func afterStart(cmd *exec.Cmd, limits config.CursorLimitsConfig) {
+	job, err := newKillOnCloseJob(limits)
+	if err != nil {
+		return
+	}
+	proc, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE|syscall.SYNCHRONIZE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		_ = syscall.CloseHandle(job)
+		return
+	}
+	if r, _, _ := procAssignProcessToJobObject.Call(uintptr(job), uintptr(proc)); r == 0 {
+		_ = syscall.CloseHandle(proc)
+		_ = syscall.CloseHandle(job)
+		return
+	}
+	pj := &processJob{handle: job}
+	jobs.Store(cmd, pj)
+	go func() {
+		_, _ = syscall.WaitForSingleObject(proc, syscall.INFINITE)
+		_ = syscall.CloseHandle(proc)
+		jobs.Delete(cmd)
+		pj.close()
+	}()
+}
+
+func newKillOnCloseJob(limits config.CursorLimitsConfig) (syscall.Handle, error) {
+	r, _, err := procCreateJobObjectW.Call(0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	job := syscall.Handle(r)
+	var info jobObjectExtendedLimit
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	if limits.MaxMemoryMB > 0 {
+		info.BasicLimitInformation.LimitFlags |= jobObjectLimitProcessMemory
+		info.ProcessMemoryLimit = uintptr(limits.MaxMemoryMB) << 20
+	}
+	if limits.MaxCPUSeconds > 0 {
+		// In 100-nanosecond ticks.
+		info.BasicLimitInformation.LimitFlags |= jobObjectLimitProcessTime
+		info.BasicLimitInformation.PerProcessUserTimeLimit = limits.MaxCPUSeconds * 10_000_000
+	}
+	if r, _, err := procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info)); r == 0 {
+		_ = syscall.CloseHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
+
+// killProcessGroup terminates the job object startProcess assigned the
+// process to, or just the process when it has none.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if pj, ok := jobs.Load(cmd); ok && pj.(*processJob).terminate() {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// cpuLimitExit reports a process the job ended for exceeding its CPU time.
+func cpuLimitExit(exitErr *exec.ExitError) bool {
+	return exitErr.ExitCode() == errorNotEnoughQuota
+}
+
+// memoryLimitExit reports a process that died for want of memory under the
+// job's memory limit.
+func memoryLimitExit(exitErr *exec.ExitError) bool {
+	return uint32(exitErr.ExitCode()) == statusNoMemory
+}
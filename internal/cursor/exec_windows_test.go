@@ -0,0 +1,64 @@
+//go:build windows
+
+package cursor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestResolveBinaryFindsBatchWrapper(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "cursor-agent.cmd")
+	if err := os.WriteFile(script, []byte("@echo off\r\necho args:%*\r\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "Path="+dir, "PATHEXT=.EXE;.CMD")
+	if got := resolveBinary("cursor-agent", env); !strings.EqualFold(got, script) {
+		t.Fatalf("resolveBinary = %q, want %q", got, script)
+	}
+
+	cmd := commandSpec{binary: "cursor-agent", inherited: env}.command(context.Background(), "--model", "a & b")
+	if !strings.EqualFold(filepath.Base(cmd.Path), "cmd.exe") {
+		t.Fatalf("expected the script to run through cmd.exe, got %q", cmd.Path)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running the wrapper failed: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != `args:--model "a & b"` {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestCancelTerminatesJobObject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := commandSpec{binary: os.Getenv("ComSpec"), inherited: os.Environ()}.command(ctx, "/c", "ping -n 30 127.0.0.1 >nul")
+	if err := startProcess(cmd, config.CursorLimitsConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := jobs.Load(cmd); !ok {
+		t.Fatal("expected the process to be assigned to a job object")
+	}
+
+	start := time.Now()
+	cancel()
+	_ = cmd.Wait()
+	if elapsed := time.Since(start); elapsed >= processWaitDelay {
+		t.Fatalf("Wait took %s: the job's processes kept running", elapsed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := jobs.Load(cmd); ok; _, ok = jobs.Load(cmd) {
+		if time.Now().After(deadline) {
+			t.Fatal("the job object was not released after the process exited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
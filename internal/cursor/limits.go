@@ -0,0 +1,76 @@
+package cursor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+)
+
+// outputLimitError reports output beyond cursor.limits.maxOutputBytes.
+func outputLimitError(limit int64) error {
+	return &cursorerr.Error{Kind: cursorerr.ErrResourceLimit, Message: fmt.Sprintf("output exceeded %d bytes (cursor.limits.maxOutputBytes)", limit)}
+}
+
+// limitOutput wraps onLine to fail, which stops the process, once the lines
+// read add up to more than limit bytes. A limit of 0 leaves onLine as is.
+func limitOutput(limit int64, onLine func(string) error) func(string) error {
+	if limit <= 0 {
+		return onLine
+	}
+	var read int64
+	return func(line string) error {
+		read += int64(len(line)) + 1
+		if read > limit {
+			return outputLimitError(limit)
+		}
+		return onLine(line)
+	}
+}
+
+// cappedWriter buffers up to limit bytes and calls exceeded, once, for the
+// first write that goes past it; the rest is discarded.
+type cappedWriter struct {
+	buf      bytes.Buffer
+	limit    int64
+	over     bool
+	exceeded func()
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.over {
+		return len(p), nil
+	}
+	if w.limit > 0 && int64(w.buf.Len()+len(p)) > w.limit {
+		w.buf.Write(p[:w.limit-int64(w.buf.Len())])
+		w.over = true
+		w.exceeded()
+		return len(p), nil
+	}
+	return w.buf.Write(p)
+}
+
+// limitError reports a process that ended because it exceeded one of
+// limits, going by how it exited, or nil. What it printed goes into the
+// message. A process stopped through its context is not checked.
+func limitError(err error, output string, limits config.CursorLimitsConfig) error {
+	exitErr := new(exec.ExitError)
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	if limits.MaxCPUSeconds > 0 && cpuLimitExit(exitErr) {
+		return &cursorerr.Error{Kind: cursorerr.ErrResourceLimit, Message: fmt.Sprintf("CPU time exceeded %ds (cursor.limits.maxCpuSeconds)", limits.MaxCPUSeconds)}
+	}
+	if limits.MaxMemoryMB > 0 && memoryLimitExit(exitErr) {
+		message := fmt.Sprintf("memory exceeded %dMB (cursor.limits.maxMemoryMb)", limits.MaxMemoryMB)
+		if out := strings.TrimSpace(output); out != "" {
+			message += ": " + out
+		}
+		return &cursorerr.Error{Kind: cursorerr.ErrResourceLimit, Message: message}
+	}
+	return nil
+}
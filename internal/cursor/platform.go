@@ -0,0 +1,116 @@
+package cursor
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultPathExt is used when the environment has no PATHEXT.
+const defaultPathExt = ".COM;.EXE;.BAT;.CMD"
+
+// envFoldCase is set where environment variable names ignore case, so
+// "Path" and "PATH" are the same variable.
+var envFoldCase = runtime.GOOS == "windows"
+
+// envValue returns the last value of name in env.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, kv := range env {
+		key, v, ok := strings.Cut(kv, "=")
+		if ok && (key == name || envFoldCase && strings.EqualFold(key, name)) {
+			value = v
+		}
+	}
+	return value
+}
+
+// pathExtNames lists the file names a Windows PATH search tries for name:
+// name itself when it already has one of the PATHEXT extensions, else name
+// with each of them.
+func pathExtNames(name string, pathExt string) []string {
+	if strings.TrimSpace(pathExt) == "" {
+		pathExt = defaultPathExt
+	}
+	var exts []string
+	for _, ext := range strings.Split(pathExt, ";") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			exts = append(exts, strings.ToLower(ext))
+		}
+	}
+	if ext := strings.ToLower(filepath.Ext(name)); ext != "" {
+		for _, known := range exts {
+			if ext == known {
+				return []string{name}
+			}
+		}
+	}
+	names := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		names = append(names, name+ext)
+	}
+	return names
+}
+
+// isBatchFile reports whether path is a .cmd or .bat script, which Windows
+// runs through cmd.exe.
+func isBatchFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".cmd" || ext == ".bat"
+}
+
+// cmdLine is the command line that runs script with args through cmd.exe.
+// Each argument is quoted for the C runtime and then has the characters
+// cmd.exe interprets escaped with ^, so arguments reach the script as given
+// rather than as cmd.exe syntax.
+func cmdLine(comspec string, script string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, escapeCmd(quoteArg(script)))
+	for _, arg := range args {
+		parts = append(parts, escapeCmd(quoteArg(arg)))
+	}
+	return quoteArg(comspec) + ` /d /s /c "` + strings.Join(parts, " ") + `"`
+}
+
+// quoteArg quotes s the way the C runtime splits command lines, as
+// syscall.EscapeArg does on Windows.
+func quoteArg(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes before a quote are doubled and the quote escaped.
+			b.WriteString(strings.Repeat(`\`, slashes*2+1))
+			b.WriteByte(c)
+			slashes = 0
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+			b.WriteByte(c)
+			slashes = 0
+		}
+	}
+	b.WriteString(strings.Repeat(`\`, slashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+func escapeCmd(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`()%!^"<>&|`, r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
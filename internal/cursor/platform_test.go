@@ -0,0 +1,55 @@
+package cursor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathExtNames(t *testing.T) {
+	cases := []struct {
+		name, pathExt string
+		want          []string
+	}{
+		{"cursor-agent", "", []string{"cursor-agent.com", "cursor-agent.exe", "cursor-agent.bat", "cursor-agent.cmd"}},
+		{"cursor-agent", ".EXE;;.CMD", []string{"cursor-agent.exe", "cursor-agent.cmd"}},
+		{"cursor-agent.CMD", ".EXE;.CMD", []string{"cursor-agent.CMD"}},
+		{"cursor-agent.v2", ".EXE", []string{"cursor-agent.v2.exe"}},
+	}
+	for _, tc := range cases {
+		if got := pathExtNames(tc.name, tc.pathExt); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("pathExtNames(%q, %q) = %v, want %v", tc.name, tc.pathExt, got, tc.want)
+		}
+	}
+}
+
+func TestEnvValue(t *testing.T) {
+	defer func(v bool) { envFoldCase = v }(envFoldCase)
+	env := []string{"Path=C:\\bin", "PATHEXT=.EXE", "PATH=/usr/bin"}
+
+	envFoldCase = false
+	if got := envValue(env, "PATH"); got != "/usr/bin" {
+		t.Fatalf("PATH = %q", got)
+	}
+	env = env[:2]
+	if got := envValue(env, "PATH"); got != "" {
+		t.Fatalf("expected Path not to match PATH where names are case-sensitive, got %q", got)
+	}
+	envFoldCase = true
+	if got := envValue(env, "PATH"); got != `C:\bin` {
+		t.Fatalf("expected Path to match PATH where names ignore case, got %q", got)
+	}
+}
+
+func TestCmdLineEscapesArguments(t *testing.T) {
+	got := cmdLine(`C:\Windows\System32\cmd.exe`, `C:\Program Files\cursor\cursor-agent.cmd`, []string{"--print", "a & b", `say "hi"`, "100%", `dir\`, ""})
+	want := `C:\Windows\System32\cmd.exe /d /s /c "^"C:\Program Files\cursor\cursor-agent.cmd^" --print ^"a ^& b^" ^"say \^"hi\^"^" 100^% dir\ ^"^""`
+	if got != want {
+		t.Fatalf("cmdLine =\n%s\nwant\n%s", got, want)
+	}
+	if got := quoteArg(`C:\dir with space\`); got != `"C:\dir with space\\"` {
+		t.Fatalf("trailing backslashes must be doubled before the closing quote, got %s", got)
+	}
+	if isBatchFile(`C:\bin\cursor-agent.exe`) || !isBatchFile(`C:\bin\cursor-agent.CMD`) {
+		t.Fatal("isBatchFile misclassified a path")
+	}
+}
@@ -0,0 +1,452 @@
+package cursor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/tracing"
+)
+
+var (
+	errPoolFull           = errors.New("cursor-agent process pool is full")
+	errProcessUnavailable = errors.New("cursor-agent process is not running")
+	errProcessExited      = errors.New("cursor-agent process exited")
+)
+
+const stderrTailBytes = 4096
+
+// processKey identifies what a persistent process was started with. A prompt
+// with a different key restarts the session's process.
+type processKey struct {
+	cwd   string
+	model string
+	// env is the process's whole environment, inherited variables included,
+	// so an env policy reload or a session env change restarts it.
+	env     string
+	command string
+}
+
+// agentProcess is a long-lived `cursor-agent agent` child that reads one
+// stream-json user message per stdin line and answers with stream-json
+// events, ending each turn with a {"type":"result"} event.
+type agentProcess struct {
+	key processKey
+	cmd *exec.Cmd
+	// ctx lives as long as the process; cancel ends it, which kills the
+	// process group.
+	ctx    context.Context
+	cancel context.CancelFunc
+	limits config.CursorLimitsConfig
+	stdin  io.WriteCloser
+	lines  chan string
+
+	exited   chan struct{}
+	waitErr  error
+	stderr   tailBuffer
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	turn     sync.Mutex
+	mu       sync.Mutex
+	busy     bool
+	lastUsed time.Time
+}
+
+func startAgentProcess(spec commandSpec, key processKey, chatID string) (*agentProcess, error) {
+	args := []string{
+		"agent",
+		"--print",
+		"--input-format", "stream-json",
+		"--output-format", "stream-json",
+		"--stream-partial-output",
+		"--force",
+	}
+	if key.model != "" {
+		args = append(args, "--model", key.model)
+	}
+	if chatID != "" {
+		args = append(args, "--resume", chatID)
+	}
+
+	// The process serves many prompts, so it lives until the pool stops it
+	// rather than for one request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := spec.command(ctx, args...)
+	cmd.Dir = key.cwd
+	p := &agentProcess{key: key, cmd: cmd, ctx: ctx, cancel: cancel, limits: spec.limits, lines: make(chan string, 64), exited: make(chan struct{}), stopped: make(chan struct{}), lastUsed: time.Now()}
+	cmd.Stderr = &p.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := startProcess(cmd, spec.limits); err != nil {
+		cancel()
+		return nil, err
+	}
+	p.stdin = stdin
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			// After kill nobody reads lines; keep draining so Wait can reap.
+			select {
+			case p.lines <- line:
+			case <-p.stopped:
+			}
+		}
+		close(p.lines)
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *agentProcess) alive() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *agentProcess) idleSince() (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastUsed, !p.busy
+}
+
+func (p *agentProcess) setBusy(busy bool) {
+	p.mu.Lock()
+	p.busy = busy
+	p.lastUsed = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *agentProcess) exitReason() string {
+	<-p.exited
+	if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+		return msg
+	}
+	if p.waitErr != nil {
+		return p.waitErr.Error()
+	}
+	return "exit status 0"
+}
+
+// send runs one turn, passing every output line to onLine until the result
+// event. A turn that does not finish cleanly kills the process, since its
+// conversation state is unknown.
+func (p *agentProcess) send(ctx context.Context, prompt string, onLine func(string) error) error {
+	p.turn.Lock()
+	defer p.turn.Unlock()
+	if !p.alive() {
+		return errProcessUnavailable
+	}
+	p.setBusy(true)
+	defer p.setBusy(false)
+
+	msg, err := json.Marshal(map[string]any{
+		"type": "user",
+		"message": map[string]any{
+			"role":    "user",
+			"content": []map[string]any{{"type": "text", "text": prompt}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// Cancelling the prompt while the write is blocked kills the process,
+	// which ends the write.
+	stop := context.AfterFunc(ctx, p.kill)
+	err = p.write(append(msg, '\n'))
+	stop()
+	if ctx.Err() != nil {
+		p.kill()
+		return ctx.Err()
+	}
+	if err != nil {
+		p.kill()
+		return fmt.Errorf("%w: %v", errProcessUnavailable, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.kill()
+			return ctx.Err()
+		case line, ok := <-p.lines:
+			if !ok {
+				reason := p.exitReason()
+				if err := limitError(p.waitErr, reason, p.limits); err != nil {
+					return err
+				}
+				return fmt.Errorf("%w: %s", errProcessExited, reason)
+			}
+			if err := onLine(line); err != nil {
+				p.kill()
+				return err
+			}
+			if isResultEvent(line) {
+				return nil
+			}
+		}
+	}
+}
+
+// write sends line to the process's stdin. It is bound to the process's
+// lifetime rather than to one prompt, so it gives up only when the process
+// is stopped.
+func (p *agentProcess) write(line []byte) error {
+	written := make(chan error, 1)
+	go func() {
+		_, err := p.stdin.Write(line)
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		return err
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+func (p *agentProcess) kill() {
+	p.stopOnce.Do(func() { close(p.stopped) })
+	_ = p.stdin.Close()
+	if p.cmd.Process != nil {
+		_ = killProcessGroup(p.cmd)
+	}
+	p.cancel()
+}
+
+func isResultEvent(line string) bool {
+	var event struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal([]byte(line), &event) == nil && event.Type == "result"
+}
+
+// processPool keeps one persistent cursor-agent per ACP session so prompts
+// skip process startup. Dead processes are respawned on the next prompt and
+// idle ones are reaped by a background health check.
+type processPool struct {
+	cfg    config.CursorProcessPoolConfig
+	logger *logging.Logger
+
+	mu    sync.Mutex
+	procs map[string]*agentProcess
+	stop  chan struct{}
+}
+
+func newProcessPool(cfg config.CursorProcessPoolConfig, logger *logging.Logger) *processPool {
+	return &processPool{cfg: cfg, logger: logger, procs: map[string]*agentProcess{}}
+}
+
+// send runs a turn on the session's process. readErr is an error returned by
+// onLine; exitErr reports a process that could not run or finish the turn,
+// or errPoolFull when the caller should spawn a one-off process instead.
+func (pp *processPool) send(ctx context.Context, sessionID string, spec commandSpec, key processKey, chatID string, prompt string, onLine func(string) error) (readErr error, exitErr error) {
+	key.command = spec.key()
+	key.env = strings.Join(spec.environ(), "\x00")
+	if abs, err := filepath.Abs(key.cwd); err == nil {
+		key.cwd = abs
+	}
+	_, span := tracing.Start(ctx, "cursor-agent pooled turn", map[string]any{"session.id": sessionID, "cursor.model": key.model})
+	defer func() {
+		if errors.Is(exitErr, errPoolFull) {
+			span.SetAttributes(map[string]any{"cursor.pool_full": true})
+			span.End(nil)
+			return
+		}
+		span.End(errors.Join(readErr, exitErr))
+	}()
+	for attempt := 0; ; attempt++ {
+		proc, err := pp.acquire(spec, sessionID, key, chatID)
+		if err != nil {
+			return nil, err
+		}
+
+		var callbackErr error
+		err = proc.send(ctx, prompt, func(line string) error {
+			callbackErr = onLine(line)
+			return callbackErr
+		})
+		if err == nil {
+			return nil, nil
+		}
+		pp.discard(sessionID, proc)
+		switch {
+		case callbackErr != nil:
+			return callbackErr, nil
+		case errors.Is(err, errProcessUnavailable) && attempt == 0:
+			// Died between turns; nothing was sent, so respawn and retry once.
+			pp.logger.Warn("Persistent cursor-agent process died, respawning", map[string]any{"sessionId": sessionID})
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (pp *processPool) acquire(spec commandSpec, sessionID string, key processKey, chatID string) (*agentProcess, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if proc, ok := pp.procs[sessionID]; ok {
+		if proc.alive() && proc.key == key {
+			return proc, nil
+		}
+		proc.kill()
+		delete(pp.procs, sessionID)
+	}
+	if len(pp.procs) >= pp.cfg.MaxProcesses && !pp.evictIdleLocked() {
+		return nil, errPoolFull
+	}
+
+	proc, err := startAgentProcess(spec, key, chatID)
+	if err != nil {
+		return nil, err
+	}
+	pp.procs[sessionID] = proc
+	if pp.stop == nil {
+		pp.stop = make(chan struct{})
+		go pp.healthLoop(pp.stop)
+	}
+	pp.logger.Debug("Started persistent cursor-agent process", map[string]any{"sessionId": sessionID, "pid": proc.cmd.Process.Pid, "cwd": key.cwd})
+	return proc, nil
+}
+
+// evictIdleLocked stops the least recently used idle process.
+func (pp *processPool) evictIdleLocked() bool {
+	victim := ""
+	var oldest time.Time
+	for id, proc := range pp.procs {
+		lastUsed, idle := proc.idleSince()
+		if idle && (victim == "" || lastUsed.Before(oldest)) {
+			victim, oldest = id, lastUsed
+		}
+	}
+	if victim == "" {
+		return false
+	}
+	pp.procs[victim].kill()
+	delete(pp.procs, victim)
+	return true
+}
+
+func (pp *processPool) discard(sessionID string, proc *agentProcess) {
+	proc.kill()
+	pp.mu.Lock()
+	if pp.procs[sessionID] == proc {
+		delete(pp.procs, sessionID)
+	}
+	pp.mu.Unlock()
+}
+
+func (pp *processPool) close(sessionID string) {
+	pp.mu.Lock()
+	proc, ok := pp.procs[sessionID]
+	delete(pp.procs, sessionID)
+	pp.mu.Unlock()
+	if ok {
+		proc.kill()
+	}
+}
+
+func (pp *processPool) closeAll() {
+	pp.mu.Lock()
+	procs := pp.procs
+	pp.procs = map[string]*agentProcess{}
+	if pp.stop != nil {
+		close(pp.stop)
+		pp.stop = nil
+	}
+	pp.mu.Unlock()
+	for _, proc := range procs {
+		proc.kill()
+	}
+}
+
+func (pp *processPool) healthLoop(stop chan struct{}) {
+	ticker := time.NewTicker(time.Duration(pp.cfg.HealthCheckInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			pp.checkHealth()
+		}
+	}
+}
+
+// checkHealth drops processes that exited on their own or sat idle past the
+// idle timeout. Replacements are started lazily by the next prompt.
+func (pp *processPool) checkHealth() {
+	idleTimeout := time.Duration(pp.cfg.IdleTimeout) * time.Millisecond
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for id, proc := range pp.procs {
+		if !proc.alive() {
+			pp.logger.Warn("Persistent cursor-agent process exited", map[string]any{"sessionId": id, "reason": proc.exitReason()})
+			delete(pp.procs, id)
+			continue
+		}
+		if lastUsed, idle := proc.idleSince(); idle && idleTimeout > 0 && time.Since(lastUsed) > idleTimeout {
+			pp.logger.Debug("Stopping idle cursor-agent process", map[string]any{"sessionId": id})
+			proc.kill()
+			delete(pp.procs, id)
+		}
+	}
+}
+
+func (pp *processPool) size() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return len(pp.procs)
+}
+
+// tailBuffer keeps the last stderrTailBytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailBytes {
+		t.buf = t.buf[len(t.buf)-stderrTailBytes:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
@@ -0,0 +1,191 @@
+package cursor
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ToolCallDelta is the latest view of a tool call cursor-agent is streaming.
+// RawInput holds as much of the tool's arguments as has arrived so far.
+type ToolCallDelta struct {
+	ID       string
+	Name     string
+	RawInput any
+	// Complete is set once cursor-agent reports the tool call finished.
+	Complete bool
+}
+
+// toolArgAssembler rebuilds tool call arguments from the stream-json
+// tool_call events of one turn. Argument deltas ("subtype":"delta") are
+// appended and parsed as partial JSON; other events carry the arguments
+// whole under tool_call.<name>.args.
+type toolArgAssembler struct {
+	calls map[string]*toolArgBuffer
+}
+
+type toolArgBuffer struct {
+	name string
+	args strings.Builder
+	// last is the JSON last reported, so deltas that do not change the
+	// parsed arguments (half a key, say) are not reported again.
+	last string
+}
+
+// add takes a tool_call event and returns the call's updated arguments, or
+// false when there is nothing new to report.
+func (a *toolArgAssembler) add(event map[string]any) (ToolCallDelta, bool) {
+	id, _ := event["call_id"].(string)
+	if id == "" {
+		id, _ = event["id"].(string)
+	}
+	if id == "" {
+		return ToolCallDelta{}, false
+	}
+	if a.calls == nil {
+		a.calls = map[string]*toolArgBuffer{}
+	}
+	call := a.calls[id]
+	if call == nil {
+		call = &toolArgBuffer{}
+		a.calls[id] = call
+	}
+	name, args := toolCallArgs(event)
+	if name != "" {
+		call.name = name
+	}
+
+	subtype, _ := event["subtype"].(string)
+	if subtype == "delta" {
+		for _, key := range []string{"args_delta", "delta", "partial_json"} {
+			if s, ok := event[key].(string); ok {
+				call.args.WriteString(s)
+				break
+			}
+		}
+		text, ok := completeJSON(call.args.String())
+		if !ok || text == call.last {
+			return ToolCallDelta{}, false
+		}
+		call.last = text
+		var input any
+		if json.Unmarshal([]byte(text), &input) != nil {
+			return ToolCallDelta{}, false
+		}
+		return ToolCallDelta{ID: id, Name: call.name, RawInput: input}, true
+	}
+
+	delta := ToolCallDelta{ID: id, Name: call.name, RawInput: args, Complete: subtype == "completed"}
+	if args == nil && call.last != "" {
+		_ = json.Unmarshal([]byte(call.last), &delta.RawInput)
+	}
+	if delta.Complete {
+		delete(a.calls, id)
+	}
+	return delta, true
+}
+
+// toolCallArgs reads the tool name and arguments from an event's
+// "tool_call":{"<name>ToolCall":{"args":{...}}}, or its "name" and "args".
+func toolCallArgs(event map[string]any) (string, any) {
+	name, _ := event["name"].(string)
+	args := event["args"]
+	if call, ok := event["tool_call"].(map[string]any); ok {
+		for key, value := range call {
+			name = strings.TrimSuffix(key, "ToolCall")
+			if m, ok := value.(map[string]any); ok && m["args"] != nil {
+				args = m["args"]
+			}
+			break
+		}
+	}
+	return name, args
+}
+
+// completeJSON closes a JSON document cut off at an arbitrary byte: an open
+// string value is ended and open arrays and objects are closed. A trailing
+// key, or a number or literal that may be incomplete, is dropped. It
+// returns false when nothing of the document is usable yet.
+func completeJSON(data string) (string, bool) {
+	data = strings.TrimSpace(data)
+	if json.Valid([]byte(data)) {
+		return data, true
+	}
+
+	type frame struct {
+		closer byte
+		// key is set in an object while the next string is a key.
+		key bool
+	}
+	var stack []frame
+	closers := func() string {
+		b := make([]byte, 0, len(stack))
+		for i := len(stack) - 1; i >= 0; i-- {
+			b = append(b, stack[i].closer)
+		}
+		return string(b)
+	}
+	// safe is the longest prefix known to end after a whole value, with
+	// the closers it needs.
+	safe, safeClosers := -1, ""
+	mark := func(end int) { safe, safeClosers = end, closers() }
+
+	inString, isKey, escaped := false, false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				if !isKey {
+					mark(i + 1)
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+			isKey = len(stack) > 0 && stack[len(stack)-1].key
+		case '{':
+			stack = append(stack, frame{closer: '}', key: true})
+			mark(i + 1)
+		case '[':
+			stack = append(stack, frame{closer: ']'})
+			mark(i + 1)
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1].closer != c {
+				return "", false
+			}
+			stack = stack[:len(stack)-1]
+			mark(i + 1)
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].key = false
+			}
+		case ',':
+			mark(i)
+			if len(stack) > 0 && stack[len(stack)-1].closer == '}' {
+				stack[len(stack)-1].key = true
+			}
+		}
+	}
+
+	if inString && !isKey {
+		value := strings.ToValidUTF8(data, "")
+		// Drop an escape sequence the cut split, at most 6 bytes of \uXXXX.
+		for cut := 0; cut <= 6 && cut <= len(value); cut++ {
+			if text := value[:len(value)-cut] + `"` + closers(); json.Valid([]byte(text)) {
+				return text, true
+			}
+		}
+	}
+	if safe < 0 {
+		return "", false
+	}
+	text := data[:safe] + safeClosers
+	return text, json.Valid([]byte(text))
+}
@@ -0,0 +1,65 @@
+package cursor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteJSON(t *testing.T) {
+	for data, want := range map[string]string{
+		`{"path":"a.go"}`:                  `{"path":"a.go"}`,
+		`{"path":"src/ma`:                  `{"path":"src/ma"}`,
+		`{"path":"a.go","lines":[1,2`:      `{"path":"a.go","lines":[1]}`,
+		`{"path":"a.go","lin`:              `{"path":"a.go"}`,
+		`{"path":"a.go","lines":`:          `{"path":"a.go"}`,
+		`{"text":"caf\u00`:                 `{"text":"caf"}`,
+		`{"text":"line\`:                   `{"text":"line"}`,
+		`{"edits":[{"old":"x","new":"y"},`: `{"edits":[{"old":"x","new":"y"}]}`,
+		`{"pa`:                             `{}`,
+	} {
+		if got, ok := completeJSON(data); !ok || got != want {
+			t.Errorf("completeJSON(%q) = %q, %v; want %q", data, got, ok, want)
+		}
+	}
+	for _, data := range []string{"", "tru", `{"a":1]`} {
+		if got, ok := completeJSON(data); ok {
+			t.Errorf("completeJSON(%q) = %q, expected nothing usable", data, got)
+		}
+	}
+}
+
+func TestStreamCollectorAssemblesToolArguments(t *testing.T) {
+	var deltas []ToolCallDelta
+	c := &streamCollector{opts: StreamingPromptOptions{OnChunk: func(chunk StreamChunk) error {
+		if chunk.Type != "tool_call" {
+			t.Fatalf("unexpected %s chunk %v", chunk.Type, chunk.Data)
+		}
+		deltas = append(deltas, chunk.Data.(ToolCallDelta))
+		return nil
+	}}}
+	for _, line := range []string{
+		`{"type":"tool_call","subtype":"delta","call_id":"c1","tool_call":{"editToolCall":{}},"args_delta":"{\"pa"}`,
+		`{"type":"tool_call","subtype":"delta","call_id":"c1","args_delta":"th\":\"main.go\",\"text\":\"pack"}`,
+		`{"type":"tool_call","subtype":"delta","call_id":"c1","args_delta":"age"}`,
+		`{"type":"tool_call","subtype":"delta","call_id":"c1","args_delta":" main\"}"}`,
+		`{"type":"tool_call","subtype":"completed","call_id":"c1","tool_call":{"editToolCall":{"args":{"path":"main.go","text":"package main"}}}}`,
+	} {
+		if err := c.handle(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []ToolCallDelta{
+		{ID: "c1", Name: "edit", RawInput: map[string]any{}},
+		{ID: "c1", Name: "edit", RawInput: map[string]any{"path": "main.go", "text": "pack"}},
+		{ID: "c1", Name: "edit", RawInput: map[string]any{"path": "main.go", "text": "package"}},
+		{ID: "c1", Name: "edit", RawInput: map[string]any{"path": "main.go", "text": "package main"}},
+		{ID: "c1", Name: "edit", RawInput: map[string]any{"path": "main.go", "text": "package main"}, Complete: true},
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Fatalf("unexpected deltas:\n%+v\nwant\n%+v", deltas, want)
+	}
+	if c.text.Len() != 0 {
+		t.Fatalf("expected tool call events to stay out of the reply text, got %q", c.text.String())
+	}
+}
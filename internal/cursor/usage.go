@@ -0,0 +1,44 @@
+package cursor
+
+// Usage is the token usage cursor-agent reports on a turn's result event.
+type Usage struct {
+	InputTokens      int `json:"inputTokens"`
+	OutputTokens     int `json:"outputTokens"`
+	CacheReadTokens  int `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int `json:"cacheWriteTokens,omitempty"`
+}
+
+// usageKeys lists the spellings of each field across cursor-agent versions.
+var usageKeys = map[string][]string{
+	"input":      {"inputTokens", "input_tokens", "promptTokens", "prompt_tokens"},
+	"output":     {"outputTokens", "output_tokens", "completionTokens", "completion_tokens"},
+	"cacheRead":  {"cacheReadTokens", "cache_read_input_tokens", "cacheReadInputTokens"},
+	"cacheWrite": {"cacheWriteTokens", "cache_creation_input_tokens", "cacheCreationInputTokens"},
+}
+
+// parseUsage reads the usage object of a result event, or returns nil when
+// the event has none.
+func parseUsage(event map[string]any) *Usage {
+	raw, ok := event["usage"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	field := func(name string) int {
+		for _, key := range usageKeys[name] {
+			if n, ok := raw[key].(float64); ok && n > 0 {
+				return int(n)
+			}
+		}
+		return 0
+	}
+	usage := &Usage{
+		InputTokens:      field("input"),
+		OutputTokens:     field("output"),
+		CacheReadTokens:  field("cacheRead"),
+		CacheWriteTokens: field("cacheWrite"),
+	}
+	if *usage == (Usage{}) {
+		return nil
+	}
+	return usage
+}
@@ -0,0 +1,59 @@
+// Package cursorerr holds the typed cursor-agent failures, so packages that
+// report them (errorfmt, prompt) do not depend on the cursor bridge itself.
+package cursorerr
+
+import "errors"
+
+// Failure kinds returned (wrapped in *Error) by the cursor bridge's
+// ExecuteCommand, SendPrompt and SendStreamingPrompt. Match them with
+// errors.Is.
+var (
+	ErrNotInstalled     = errors.New("cursor-agent is not installed")
+	ErrNotAuthenticated = errors.New("cursor-agent is not authenticated")
+	ErrRateLimited      = errors.New("cursor-agent was rate limited")
+	ErrTimeout          = errors.New("cursor-agent timed out")
+	ErrKilled           = errors.New("cursor-agent was killed")
+	ErrChatNotFound     = errors.New("cursor-agent could not resume the chat")
+	ErrResourceLimit    = errors.New("cursor-agent exceeded a resource limit")
+)
+
+// Error is a cursor-agent failure of a known kind. Message carries the CLI's
+// own output or the underlying error.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Reason returns a stable identifier for the failure kind of err, or "" when
+// it is not a typed cursor-agent failure.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrNotInstalled):
+		return "not_installed"
+	case errors.Is(err, ErrNotAuthenticated):
+		return "not_authenticated"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrKilled):
+		return "killed"
+	case errors.Is(err, ErrChatNotFound):
+		return "chat_not_found"
+	case errors.Is(err, ErrResourceLimit):
+		return "resource_limit"
+	default:
+		return ""
+	}
+}
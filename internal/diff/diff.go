@@ -0,0 +1,248 @@
+// Package diff computes line diffs and renders them as unified diffs.
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around a change.
+const DefaultContext = 3
+
+// maxEdits bounds the work spent finding a minimal diff. Past it the changed
+// region is shown as one block of removed and added lines.
+const maxEdits = 2000
+
+// Unified renders the change from oldText to newText as a unified diff with
+// one hunk per group of changes, each with context unchanged lines around
+// it. A nil oldText is a new file. A negative context means DefaultContext.
+func Unified(path string, oldText *string, newText string, context int) string {
+	if context < 0 {
+		context = DefaultContext
+	}
+	from := "a/" + strings.TrimPrefix(path, "/")
+	var oldLines []string
+	if oldText == nil {
+		from = "/dev/null"
+	} else {
+		oldLines = SplitLines(*oldText)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ b/%s\n", from, strings.TrimPrefix(path, "/"))
+	for _, h := range Hunks(Lines(oldLines, SplitLines(newText)), context) {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.OldStart, h.OldCount), hunkRange(h.NewStart, h.NewCount))
+		for _, op := range h.Ops {
+			b.WriteString(string(op.Kind) + op.Text + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Stats counts the lines added and removed between oldText and newText.
+func Stats(oldText, newText string) (added, removed int) {
+	for _, op := range Lines(SplitLines(oldText), SplitLines(newText)) {
+		switch op.Kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Op is one line of an edit script: ' ' kept, '-' removed or '+' added.
+type Op struct {
+	Kind byte
+	Text string
+}
+
+// Lines returns the edit script from a to b, or nil when they are equal.
+// The common prefix and suffix are trimmed before running Myers' algorithm
+// on the rest.
+func Lines(a, b []string) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(a) && prefix == len(b) {
+		return nil
+	}
+
+	ops := make([]Op, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, Op{' ', line})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if mid, ok := myers(midA, midB); ok {
+		ops = append(ops, mid...)
+	} else {
+		for _, line := range midA {
+			ops = append(ops, Op{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, Op{'+', line})
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, Op{' ', line})
+	}
+	return ops
+}
+
+// myers finds a shortest edit script from a to b. It gives up after
+// maxEdits edits; each step keeps only the diagonals it can reach, so
+// memory grows with the square of the edit distance rather than the input.
+func myers(a, b []string) ([]Op, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	trace := make([][]int, 0)
+	for d := 0; d <= n+m; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func backtrack(trace [][]int, a, b []string) []Op {
+	x, y := len(a), len(b)
+	reversed := make([]Op, 0, x+y)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		prevK := k
+		if d > 0 {
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, Op{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, Op{'+', b[y]})
+		} else {
+			x--
+			reversed = append(reversed, Op{'-', a[x]})
+		}
+	}
+	slices.Reverse(reversed)
+	return reversed
+}
+
+// Hunk is a run of changes with its surrounding context. Starts are
+// zero-based line indexes.
+type Hunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	Ops                []Op
+}
+
+// Hunks groups an edit script into hunks with context unchanged lines
+// around each change, merging changes that are close enough to share them.
+func Hunks(ops []Op, context int) []Hunk {
+	hunks := make([]Hunk, 0)
+	oldLine, newLine := 0, 0
+	oldAt := make([]int, len(ops))
+	newAt := make([]int, len(ops))
+	for i, op := range ops {
+		oldAt[i], newAt[i] = oldLine, newLine
+		if op.Kind != '+' {
+			oldLine++
+		}
+		if op.Kind != '-' {
+			newLine++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-context, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].Kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, len(ops))
+				break
+			}
+			end = run
+		}
+		h := Hunk{OldStart: oldAt[start], NewStart: newAt[start], Ops: ops[start:end]}
+		for _, op := range h.Ops {
+			if op.Kind != '+' {
+				h.OldCount++
+			}
+			if op.Kind != '-' {
+				h.NewCount++
+			}
+		}
+		hunks = append(hunks, h)
+		i = end
+	}
+	return hunks
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// SplitLines splits text into lines without their trailing newlines.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
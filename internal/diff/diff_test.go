@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedSplitsDistantChanges(t *testing.T) {
+	old := numberedLines(20)
+	updated := strings.Replace(strings.Replace(old, "line 2\n", "line two\n", 1), "line 18\n", "", 1)
+
+	got := Unified("f.txt", &old, updated, DefaultContext)
+	want := strings.Join([]string{
+		"--- a/f.txt",
+		"+++ b/f.txt",
+		"@@ -1,5 +1,5 @@",
+		" line 1",
+		"-line 2",
+		"+line two",
+		" line 3",
+		" line 4",
+		" line 5",
+		"@@ -15,6 +15,5 @@",
+		" line 15",
+		" line 16",
+		" line 17",
+		"-line 18",
+		" line 19",
+		" line 20",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+}
+
+func TestUnifiedKeepsUnchangedLinesInsideAHunk(t *testing.T) {
+	old := "a\nb\nc\nd\n"
+	got := Unified("f.txt", &old, "a\nB\nc\nD\n", DefaultContext)
+	want := "--- a/f.txt\n+++ b/f.txt\n@@ -1,4 +1,4 @@\n a\n-b\n+B\n c\n-d\n+D"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+}
+
+func TestLinesFindsMinimalEditScript(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+	edits := 0
+	var oldSide, newSide []string
+	for _, op := range Lines(a, b) {
+		if op.Kind != ' ' {
+			edits++
+		}
+		if op.Kind != '+' {
+			oldSide = append(oldSide, op.Text)
+		}
+		if op.Kind != '-' {
+			newSide = append(newSide, op.Text)
+		}
+	}
+	if edits != 5 {
+		t.Fatalf("edits = %d, want 5", edits)
+	}
+	if strings.Join(oldSide, " ") != strings.Join(a, " ") || strings.Join(newSide, " ") != strings.Join(b, " ") {
+		t.Fatalf("edit script does not reproduce inputs: %v / %v", oldSide, newSide)
+	}
+}
+
+func TestStats(t *testing.T) {
+	added, removed := Stats("a\nb\nc\n", "a\nx\ny\nc\n")
+	if added != 2 || removed != 1 {
+		t.Fatalf("added=%d removed=%d", added, removed)
+	}
+}
+
+func TestUnifiedContext(t *testing.T) {
+	old := numberedLines(10)
+	updated := strings.Replace(old, "line 5\n", "line five\n", 1)
+
+	got := Unified("f.txt", &old, updated, 1)
+	want := "--- a/f.txt\n+++ b/f.txt\n@@ -4,3 +4,3 @@\n line 4\n-line 5\n+line five\n line 6"
+	if got != want {
+		t.Fatalf("unexpected diff with context 1:\n%s", got)
+	}
+	if got := Unified("f.txt", &old, updated, 0); !strings.HasSuffix(got, "@@ -5,1 +5,1 @@\n-line 5\n+line five") {
+		t.Fatalf("unexpected diff with context 0:\n%s", got)
+	}
+	if got := Unified("f.txt", &old, old, DefaultContext); got != "--- a/f.txt\n+++ b/f.txt" {
+		t.Fatalf("unexpected diff for identical text:\n%s", got)
+	}
+}
@@ -0,0 +1,260 @@
+// Package doctor checks the environment the adapter depends on and reports
+// the results both for people and as JSON for support issues.
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/server"
+)
+
+// Check statuses.
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// requiredFlags are the cursor-agent options the adapter passes on every
+// prompt; a CLI whose --help lacks them is likely too old.
+var requiredFlags = []string{"--print", "--output-format", "--resume", "--model"}
+
+var versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+type Check struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type Report struct {
+	AdapterVersion string    `json:"adapterVersion"`
+	Platform       string    `json:"platform"`
+	Arch           string    `json:"arch"`
+	GoVersion      string    `json:"goVersion"`
+	GeneratedAt    time.Time `json:"generatedAt"`
+	OK             bool      `json:"ok"`
+	Checks         []Check   `json:"checks"`
+}
+
+// Run performs every check. OK is false when any check failed; warnings do
+// not count.
+func Run(ctx context.Context, cfg config.Config, logger *logging.Logger) Report {
+	report := Report{
+		AdapterVersion: server.AdapterVersion,
+		Platform:       runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		GoVersion:      runtime.Version(),
+		GeneratedAt:    time.Now().UTC(),
+	}
+	bridge := cursor.NewBridge(cfg, logger)
+	defer bridge.Close()
+
+	binary := checkBinary(bridge)
+	report.Checks = append(report.Checks, checkConfig(cfg), binary)
+	if binary.Status != StatusFail {
+		report.Checks = append(report.Checks, checkVersion(ctx, bridge), checkAuth(bridge))
+	}
+	report.Checks = append(report.Checks,
+		checkSessionDir(cfg),
+		checkNode(cfg.Environment),
+		checkStdioFraming(ctx, cfg, logger),
+	)
+
+	report.OK = true
+	for _, c := range report.Checks {
+		if c.Status == StatusFail {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+func checkConfig(cfg config.Config) Check {
+	errs := config.Validate(cfg)
+	if len(errs) == 0 {
+		return Check{Name: "config", Status: StatusPass, Message: "valid"}
+	}
+	problems := make([]string, 0, len(errs))
+	for _, err := range errs {
+		problems = append(problems, err.Error())
+	}
+	return Check{Name: "config", Status: StatusFail, Message: strings.Join(problems, "; "), Details: map[string]any{"errors": problems}}
+}
+
+func checkBinary(bridge *cursor.Bridge) Check {
+	loc := bridge.BinaryLocation()
+	details := map[string]any{"path": loc.Path, "source": loc.Source}
+	if loc.Source == cursor.BinarySourceNotFound {
+		return Check{Name: "cursor_binary", Status: StatusFail, Message: "cursor-agent not found on PATH or in common install locations; install it or set cursor.binaryPath", Details: details}
+	}
+	return Check{Name: "cursor_binary", Status: StatusPass, Message: fmt.Sprintf("%s (%s)", loc.Path, loc.Source), Details: details}
+}
+
+func checkVersion(ctx context.Context, bridge *cursor.Bridge) Check {
+	version, err := bridge.GetVersion()
+	if err != nil {
+		return Check{Name: "cursor_version", Status: StatusFail, Message: "cursor-agent --version failed: " + err.Error()}
+	}
+	details := map[string]any{"version": version}
+	if !versionPattern.MatchString(version) {
+		return Check{Name: "cursor_version", Status: StatusWarn, Message: fmt.Sprintf("unrecognized version output %q", version), Details: details}
+	}
+
+	var missing []string
+	if res, err := bridge.ExecuteCommand(ctx, []string{"--help"}, cursor.CommandOptions{}); err == nil {
+		help := res.Stdout + res.Stderr
+		for _, flag := range requiredFlags {
+			if !strings.Contains(help, flag) {
+				missing = append(missing, flag)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		details["missingFlags"] = missing
+		return Check{Name: "cursor_version", Status: StatusWarn, Message: fmt.Sprintf("%s; --help does not list %s, consider upgrading", version, strings.Join(missing, ", ")), Details: details}
+	}
+	return Check{Name: "cursor_version", Status: StatusPass, Message: version, Details: details}
+}
+
+func checkAuth(bridge *cursor.Bridge) Check {
+	status := bridge.CheckAuthentication()
+	if !status.Authenticated {
+		message := "not signed in; run `cursor-agent-acp auth login`"
+		if status.Error != "" {
+			message += " (" + status.Error + ")"
+		}
+		return Check{Name: "cursor_auth", Status: StatusFail, Message: message}
+	}
+	who := status.Email
+	if who == "" {
+		who = status.User
+	}
+	details := map[string]any{"plan": status.Plan}
+	if who == "" {
+		return Check{Name: "cursor_auth", Status: StatusPass, Message: "signed in", Details: details}
+	}
+	return Check{Name: "cursor_auth", Status: StatusPass, Message: "signed in as " + who, Details: details}
+}
+
+func checkSessionDir(cfg config.Config) Check {
+	details := map[string]any{"path": cfg.SessionDir}
+	if err := config.EnsureSessionDir(cfg); err != nil {
+		return Check{Name: "session_dir", Status: StatusFail, Message: "cannot create session directory: " + err.Error(), Details: details}
+	}
+	f, err := os.CreateTemp(cfg.SessionDir, ".doctor-*")
+	if err != nil {
+		return Check{Name: "session_dir", Status: StatusFail, Message: "session directory is not writable: " + err.Error(), Details: details}
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return Check{Name: "session_dir", Status: StatusPass, Message: cfg.SessionDir + " is writable", Details: details}
+}
+
+func checkNode(environment config.EnvironmentConfig) Check {
+	path, err := exec.LookPath("node")
+	if err != nil {
+		return Check{Name: "node", Status: StatusWarn, Message: "node not found on PATH (only needed by cursor-agent installs that run on Node)"}
+	}
+	cmd := exec.Command(path, "--version")
+	cmd.Env = envpolicy.New(environment).Filter(os.Environ())
+	out, err := cmd.Output()
+	if err != nil {
+		return Check{Name: "node", Status: StatusWarn, Message: "node --version failed: " + err.Error(), Details: map[string]any{"path": path}}
+	}
+	version := strings.TrimSpace(string(out))
+	return Check{Name: "node", Status: StatusPass, Message: version, Details: map[string]any{"path": path, "version": version}}
+}
+
+// checkStdioFraming sends initialize through the newline-delimited stdio
+// transport of an in-process server and checks that every line written back
+// is a single JSON-RPC message.
+func checkStdioFraming(ctx context.Context, cfg config.Config, logger *logging.Logger) Check {
+	dir, err := os.MkdirTemp("", "cursor-acp-doctor-")
+	if err != nil {
+		return Check{Name: "stdio_framing", Status: StatusFail, Message: err.Error()}
+	}
+	defer os.RemoveAll(dir)
+	cfg.SessionDir = dir
+	cfg.Audit.Enabled = false
+	cfg.Checkpoints.Enabled = false
+	cfg.Tracing.Enabled = false
+	cfg.Cursor.ModelRefreshInterval = 0
+
+	var out bytes.Buffer
+	srv := server.New(cfg, logger)
+	srv.SetOutput(&out)
+	srv.SetInput(strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":1,"clientCapabilities":{}}}` + "\n"))
+	serveErr := srv.StartStdio(ctx)
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	if serveErr != nil {
+		return Check{Name: "stdio_framing", Status: StatusFail, Message: "stdio transport failed: " + serveErr.Error()}
+	}
+
+	lines := 0
+	answered := false
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		lines++
+		var msg struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      any             `json:"id"`
+			Result  json.RawMessage `json:"result"`
+			Error   json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.JSONRPC != "2.0" {
+			return Check{Name: "stdio_framing", Status: StatusFail, Message: fmt.Sprintf("line %d is not a JSON-RPC message: %.120q", lines, scanner.Text())}
+		}
+		if msg.ID == float64(1) {
+			if len(msg.Error) > 0 {
+				return Check{Name: "stdio_framing", Status: StatusFail, Message: "initialize failed: " + string(msg.Error)}
+			}
+			answered = true
+		}
+	}
+	if !answered {
+		return Check{Name: "stdio_framing", Status: StatusFail, Message: "no response to initialize"}
+	}
+	return Check{Name: "stdio_framing", Status: StatusPass, Message: fmt.Sprintf("initialize answered with %d newline-delimited message(s)", lines), Details: map[string]any{"messages": lines}}
+}
+
+// WriteText writes the report as an aligned table with a summary line.
+func (r Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "cursor-agent-acp %s doctor (%s/%s, %s)\n\n", r.AdapterVersion, r.Platform, r.Arch, r.GoVersion)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	failed, warned := 0, 0
+	for _, c := range r.Checks {
+		switch c.Status {
+		case StatusFail:
+			failed++
+		case StatusWarn:
+			warned++
+		}
+		fmt.Fprintf(tw, "  [%s]\t%s\t%s\n", c.Status, c.Name, c.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d failed, %d warnings, %d passed\n", failed, warned, len(r.Checks)-failed-warned)
+	return err
+}
@@ -0,0 +1,71 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func TestRunReportsEveryCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	binDir := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+  --version) echo "cursor-agent 1.2.3" ;;
+  --help) echo "Usage: cursor-agent [--print] [--output-format json] [--model m]" ;;
+  status) echo "Signed in as dev@example.com" ;;
+  *) echo "auto" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(binDir, "cursor-agent"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Run(context.Background(), cfg, logging.New("error"))
+	if !report.OK {
+		t.Fatalf("expected no failed checks, got %+v", report.Checks)
+	}
+	byName := map[string]Check{}
+	for _, c := range report.Checks {
+		byName[c.Name] = c
+	}
+	for _, name := range []string{"config", "cursor_binary", "cursor_version", "cursor_auth", "session_dir", "node", "stdio_framing"} {
+		if _, ok := byName[name]; !ok {
+			t.Fatalf("missing check %q in %+v", name, report.Checks)
+		}
+	}
+	if c := byName["cursor_version"]; c.Status != StatusWarn || !strings.Contains(c.Message, "--resume") {
+		t.Fatalf("expected a warning about the missing --resume flag, got %+v", c)
+	}
+	if c := byName["cursor_auth"]; c.Status != StatusPass || c.Message != "signed in as dev@example.com" {
+		t.Fatalf("unexpected auth check %+v", c)
+	}
+	if c := byName["stdio_framing"]; c.Status != StatusPass {
+		t.Fatalf("unexpected framing check %+v", c)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "[warn]  cursor_version") || !strings.Contains(text.String(), "0 failed") {
+		t.Fatalf("unexpected text report:\n%s", text.String())
+	}
+}
@@ -0,0 +1,91 @@
+// Package envpolicy decides which environment variables the adapter passes
+// to the processes it starts (cursor-agent) and the terminals it asks the
+// client to create, per the environment configuration.
+package envpolicy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// Policy matches variable names against allow and deny globs. A name that
+// matches an allow pattern is always passed; otherwise one that matches a
+// deny pattern is withheld. Matching ignores case. A nil Policy allows
+// everything.
+type Policy struct {
+	allow []string
+	deny  []string
+}
+
+func New(cfg config.EnvironmentConfig) *Policy {
+	return &Policy{allow: upper(cfg.Allow), deny: upper(cfg.Deny)}
+}
+
+func upper(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, strings.ToUpper(p))
+		}
+	}
+	return out
+}
+
+// Allows reports whether the variable name may be passed on.
+func (p *Policy) Allows(name string) bool {
+	if p == nil {
+		return true
+	}
+	name = strings.ToUpper(name)
+	if matchAny(p.allow, name) {
+		return true
+	}
+	return !matchAny(p.deny, name)
+}
+
+// Filter drops the KEY=VALUE entries of env the policy withholds, e.g. to
+// trim os.Environ() before it is inherited by a child process.
+func (p *Policy) Filter(env []string) []string {
+	if p == nil {
+		return env
+	}
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if p.Allows(name) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// Check rejects explicitly requested variables the policy withholds, so a
+// per-call override cannot hand a secret to a terminal or cursor-agent.
+func (p *Policy) Check(names ...string) error {
+	var denied []string
+	for _, name := range names {
+		if !p.Allows(name) {
+			denied = append(denied, name)
+		}
+	}
+	switch len(denied) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("environment variable %s is not allowed by the environment policy", denied[0])
+	default:
+		return fmt.Errorf("environment variables %s are not allowed by the environment policy", strings.Join(denied, ", "))
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
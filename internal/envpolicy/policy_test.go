@@ -0,0 +1,39 @@
+package envpolicy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestDefaultPolicyWithholdsSecrets(t *testing.T) {
+	p := New(config.Default().Environment)
+
+	env := []string{"PATH=/usr/bin", "HOME=/home/me", "GITHUB_TOKEN=ghp_x", "AWS_SECRET_ACCESS_KEY=y", "OPENAI_API_KEY=z", "CURSOR_API_KEY=k", "db_password=p"}
+	want := []string{"PATH=/usr/bin", "HOME=/home/me", "CURSOR_API_KEY=k"}
+	if got := p.Filter(env); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Filter = %q, want %q", got, want)
+	}
+
+	if err := p.Check("HTTPS_PROXY", "NODE_ENV"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := p.Check("HTTPS_PROXY", "NPM_TOKEN", "MY_SECRET")
+	if err == nil || !strings.Contains(err.Error(), "NPM_TOKEN, MY_SECRET") {
+		t.Fatalf("expected both secrets to be rejected, got %v", err)
+	}
+}
+
+func TestAllowOverridesDeny(t *testing.T) {
+	p := New(config.EnvironmentConfig{Allow: []string{"PATH", "LC_*"}, Deny: []string{"*"}})
+	if got := p.Filter([]string{"PATH=/bin", "LC_ALL=C", "HOME=/root"}); !reflect.DeepEqual(got, []string{"PATH=/bin", "LC_ALL=C"}) {
+		t.Fatalf("unexpected filter result %q", got)
+	}
+
+	var nilPolicy *Policy
+	if !nilPolicy.Allows("GITHUB_TOKEN") || nilPolicy.Check("GITHUB_TOKEN") != nil {
+		t.Fatalf("a nil policy should allow everything")
+	}
+}
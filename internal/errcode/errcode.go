@@ -0,0 +1,81 @@
+// Package errcode tags adapter errors with stable, machine-readable codes.
+// errorfmt maps each code to its own JSON-RPC error code and reports it as
+// the "code" field of the error data.
+package errcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code identifies a kind of failure independently of its message.
+type Code string
+
+const (
+	InvalidParams  Code = "INVALID_PARAMS"
+	MethodNotFound Code = "METHOD_NOT_FOUND"
+	Internal       Code = "INTERNAL_ERROR"
+	// RequestInProgress answers a repeated request ID whose first request
+	// has not finished.
+	RequestInProgress Code = "REQUEST_IN_PROGRESS"
+	SessionNotFound   Code = "SESSION_NOT_FOUND"
+	SessionLimit      Code = "SESSION_LIMIT_REACHED"
+	// SessionBusy rejects a request that cannot run while the session is
+	// processing a prompt.
+	SessionBusy      Code = "SESSION_BUSY"
+	InvalidMode      Code = "INVALID_MODE"
+	InvalidModel     Code = "INVALID_MODEL"
+	ModelNotAllowed  Code = "MODEL_NOT_ALLOWED"
+	ToolNotFound     Code = "TOOL_NOT_FOUND"
+	PermissionDenied Code = "PERMISSION_DENIED"
+	// ClientUnsupported is a client request the client did not declare a
+	// capability for.
+	ClientUnsupported Code = "CLIENT_CAPABILITY_MISSING"
+	ClientTimeout     Code = "CLIENT_TIMEOUT"
+	Cancelled         Code = "CANCELLED"
+	AuthRequired      Code = "AUTH_REQUIRED"
+	// CursorUnavailable means the cursor-agent CLI is not installed or
+	// cannot be started.
+	CursorUnavailable Code = "CURSOR_UNAVAILABLE"
+	CursorRateLimited Code = "CURSOR_RATE_LIMITED"
+	CursorTimeout     Code = "CURSOR_TIMEOUT"
+	CursorKilled      Code = "CURSOR_KILLED"
+	// CursorResourceLimit means cursor-agent was stopped for exceeding one
+	// of cursor.limits.
+	CursorResourceLimit Code = "CURSOR_RESOURCE_LIMIT"
+)
+
+// Error is an error tagged with a Code. Its message is the message of the
+// error it was made from.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// New formats an error like fmt.Errorf, %w included, and tags it with code.
+func New(code Code, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// Wrap tags err with code, keeping its message. A nil err stays nil.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// Of returns the code of the outermost tagged error in err's chain, or "".
+func Of(err error) Code {
+	var tagged *Error
+	if errors.As(err, &tagged) {
+		return tagged.Code
+	}
+	return ""
+}
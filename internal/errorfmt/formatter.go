@@ -1,8 +1,11 @@
 package errorfmt
 
 import (
+	"errors"
 	"strings"
 
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
 )
 
@@ -12,7 +15,49 @@ type Formatted struct {
 	Data    map[string]any
 }
 
+// rpcCodes gives every errcode.Code its own JSON-RPC error code.
+var rpcCodes = map[errcode.Code]int{
+	errcode.InvalidParams:       jsonrpc.InvalidParams,
+	errcode.MethodNotFound:      jsonrpc.MethodNotFound,
+	errcode.Internal:            jsonrpc.InternalError,
+	errcode.RequestInProgress:   jsonrpc.RequestInProgress,
+	errcode.SessionNotFound:     jsonrpc.SessionNotFound,
+	errcode.SessionLimit:        jsonrpc.SessionLimitReached,
+	errcode.SessionBusy:         jsonrpc.SessionBusy,
+	errcode.InvalidMode:         jsonrpc.InvalidMode,
+	errcode.InvalidModel:        jsonrpc.InvalidModel,
+	errcode.ModelNotAllowed:     jsonrpc.ModelNotAllowed,
+	errcode.ToolNotFound:        jsonrpc.ToolNotFound,
+	errcode.PermissionDenied:    jsonrpc.PermissionDenied,
+	errcode.ClientUnsupported:   jsonrpc.ClientCapabilityMissing,
+	errcode.ClientTimeout:       jsonrpc.ClientTimeout,
+	errcode.Cancelled:           jsonrpc.Cancelled,
+	errcode.AuthRequired:        jsonrpc.AuthRequired,
+	errcode.CursorUnavailable:   jsonrpc.CursorNotInstalled,
+	errcode.CursorRateLimited:   jsonrpc.CursorRateLimited,
+	errcode.CursorTimeout:       jsonrpc.CursorTimeout,
+	errcode.CursorKilled:        jsonrpc.CursorKilled,
+	errcode.CursorResourceLimit: jsonrpc.CursorResourceLimit,
+}
+
+// Format turns err into a JSON-RPC error. The data always carries the
+// machine-readable "code" of the failure.
 func Format(err error, fallbackMessage string, data map[string]any) Formatted {
+	var rpcErr *jsonrpc.Error
+	if errors.As(err, &rpcErr) {
+		if data == nil {
+			data = map[string]any{}
+		}
+		if extra, ok := rpcErr.Data.(map[string]any); ok {
+			for k, v := range extra {
+				data[k] = v
+			}
+		}
+		if _, ok := data["code"]; !ok {
+			data["code"] = string(codeForRPC(rpcErr.Code))
+		}
+		return Formatted{Code: rpcErr.Code, Message: rpcErr.Message, Data: data}
+	}
 	msg := fallbackMessage
 	if err != nil {
 		msg = err.Error()
@@ -20,24 +65,69 @@ func Format(err error, fallbackMessage string, data map[string]any) Formatted {
 	if msg == "" {
 		msg = "internal error"
 	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	if reason := cursorerr.Reason(err); reason != "" {
+		data["reason"] = reason
+	}
+	code := ErrorCode(err)
+	data["code"] = string(code)
 	return Formatted{
-		Code:    CodeForError(err),
+		Code:    rpcCodes[code],
 		Message: msg,
 		Data:    data,
 	}
 }
 
 func CodeForError(err error) int {
+	return rpcCodes[ErrorCode(err)]
+}
+
+// ErrorCode classifies err: by its errcode tag, then by its cursor-agent
+// failure kind, then by its message. METHOD_NOT_FOUND is never guessed from
+// a message; only unknown methods are tagged with it.
+func ErrorCode(err error) errcode.Code {
 	if err == nil {
-		return jsonrpc.InternalError
+		return errcode.Internal
+	}
+	if code := errcode.Of(err); code != "" {
+		if _, ok := rpcCodes[code]; ok {
+			return code
+		}
+	}
+	switch cursorerr.Reason(err) {
+	case "not_installed":
+		return errcode.CursorUnavailable
+	case "not_authenticated":
+		return errcode.AuthRequired
+	case "rate_limited":
+		return errcode.CursorRateLimited
+	case "timeout":
+		return errcode.CursorTimeout
+	case "killed":
+		return errcode.CursorKilled
+	case "resource_limit":
+		return errcode.CursorResourceLimit
 	}
 	msg := strings.ToLower(err.Error())
 	switch {
 	case strings.Contains(msg, "required"), strings.Contains(msg, "invalid"), strings.Contains(msg, "must"), strings.Contains(msg, "params"):
-		return jsonrpc.InvalidParams
-	case strings.Contains(msg, "unknown method"), strings.Contains(msg, "not found"):
-		return jsonrpc.MethodNotFound
+		return errcode.InvalidParams
 	default:
-		return jsonrpc.InternalError
+		return errcode.Internal
+	}
+}
+
+// codeForRPC is the errcode.Code of a JSON-RPC error code.
+func codeForRPC(rpcCode int) errcode.Code {
+	for code, c := range rpcCodes {
+		if c == rpcCode {
+			return code
+		}
+	}
+	if rpcCode == jsonrpc.ParseError || rpcCode == jsonrpc.InvalidRequest {
+		return errcode.InvalidParams
 	}
+	return errcode.Internal
 }
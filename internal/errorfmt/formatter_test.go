@@ -2,8 +2,11 @@ package errorfmt
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
 )
 
@@ -13,8 +16,12 @@ func TestCodeForError(t *testing.T) {
 		want int
 	}{
 		{errors.New("sessionId is required"), jsonrpc.InvalidParams},
-		{errors.New("resource not found"), jsonrpc.MethodNotFound},
+		{errors.New("resource not found"), jsonrpc.InternalError},
+		{errcode.New(errcode.MethodNotFound, "extension method not found: %s", "x"), jsonrpc.MethodNotFound},
 		{errors.New("boom"), jsonrpc.InternalError},
+		{&cursorerr.Error{Kind: cursorerr.ErrNotAuthenticated}, jsonrpc.AuthRequired},
+		{fmt.Errorf("failed after 2 attempts: %w", &cursorerr.Error{Kind: cursorerr.ErrTimeout, Message: "invalid"}), jsonrpc.CursorTimeout},
+		{&cursorerr.Error{Kind: cursorerr.ErrNotInstalled, Message: "exec: not found"}, jsonrpc.CursorNotInstalled},
 	}
 
 	for _, tc := range cases {
@@ -36,3 +43,53 @@ func TestFormat(t *testing.T) {
 		t.Fatalf("unexpected data: %#v", formatted.Data)
 	}
 }
+
+func TestFormatAddsCursorReason(t *testing.T) {
+	formatted := Format(&cursorerr.Error{Kind: cursorerr.ErrRateLimited}, "fallback", nil)
+	if formatted.Code != jsonrpc.CursorRateLimited || formatted.Data["reason"] != "rate_limited" {
+		t.Fatalf("unexpected formatting: %#v", formatted)
+	}
+}
+
+func TestFormatKeepsJSONRPCErrors(t *testing.T) {
+	err := fmt.Errorf("tool failed: %w", &jsonrpc.Error{Code: jsonrpc.InvalidParams, Message: "bad line", Data: map[string]any{"violations": []string{"/line"}}})
+	formatted := Format(err, "fallback", map[string]any{"name": "x"})
+	if formatted.Code != jsonrpc.InvalidParams || formatted.Message != "bad line" {
+		t.Fatalf("unexpected formatted error %+v", formatted)
+	}
+	if formatted.Data["name"] != "x" || formatted.Data["violations"] == nil {
+		t.Fatalf("expected data from both sources, got %+v", formatted.Data)
+	}
+}
+
+func TestFormatReportsErrorCodes(t *testing.T) {
+	cases := []struct {
+		err      error
+		rpcCode  int
+		dataCode errcode.Code
+	}{
+		{fmt.Errorf("load: %w", errcode.New(errcode.SessionNotFound, "session not found: %s", "s1")), jsonrpc.SessionNotFound, errcode.SessionNotFound},
+		{errcode.New(errcode.InvalidMode, "invalid mode: %s", "x"), jsonrpc.InvalidMode, errcode.InvalidMode},
+		{errcode.Wrap(errcode.PermissionDenied, errors.New("Permission denied")), jsonrpc.PermissionDenied, errcode.PermissionDenied},
+		{&cursorerr.Error{Kind: cursorerr.ErrNotInstalled}, jsonrpc.CursorNotInstalled, errcode.CursorUnavailable},
+		{errors.New("cwd is required"), jsonrpc.InvalidParams, errcode.InvalidParams},
+		{errors.New("boom"), jsonrpc.InternalError, errcode.Internal},
+		{&jsonrpc.Error{Code: jsonrpc.ToolNotFound, Message: "no such tool"}, jsonrpc.ToolNotFound, errcode.ToolNotFound},
+	}
+	seen := map[int]errcode.Code{}
+	for _, tc := range cases {
+		formatted := Format(tc.err, "fallback", nil)
+		if formatted.Code != tc.rpcCode || formatted.Data["code"] != string(tc.dataCode) {
+			t.Fatalf("Format(%q) = %d %v, want %d %s", tc.err.Error(), formatted.Code, formatted.Data["code"], tc.rpcCode, tc.dataCode)
+		}
+		if formatted.Message != tc.err.Error() {
+			t.Fatalf("Format changed the message of %q to %q", tc.err.Error(), formatted.Message)
+		}
+	}
+	for code, rpcCode := range rpcCodes {
+		if other, dup := seen[rpcCode]; dup {
+			t.Fatalf("%s and %s share JSON-RPC code %d", code, other, rpcCode)
+		}
+		seen[rpcCode] = code
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -77,7 +78,7 @@ func (r *Registry) CallMethod(name string, params map[string]any) (map[string]an
 	h, ok := r.methods[name]
 	r.mu.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("extension method not found: %s", name)
+		return nil, errcode.New(errcode.MethodNotFound, "extension method not found: %s", name)
 	}
 	r.logger.Debug("Calling extension method", map[string]any{"name": name, "params": params})
 	res, err := h(params)
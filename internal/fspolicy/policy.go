@@ -0,0 +1,321 @@
+// Package fspolicy validates filesystem paths used by tools against the
+// tools.filesystem configuration (allowed roots, size and extension limits).
+package fspolicy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// Policy checks paths against the allowed roots. realRoots holds the roots
+// with symlinks resolved, index for index. folderRoots are the resolved
+// directories client workspace folders must lie in. base, when set, is the
+// directory relative paths resolve against.
+type Policy struct {
+	base             string
+	roots            []string
+	realRoots        []string
+	maxFileSize      int64
+	extensions       map[string]bool
+	workspaceFolders bool
+	folderRoots      []string
+}
+
+func New(cfg config.FilesystemConfig) *Policy {
+	p := &Policy{maxFileSize: cfg.MaxFileSize, workspaceFolders: cfg.AllowWorkspaceFolders}
+	for _, root := range cfg.AllowedPaths {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		abs, err := filepath.Abs(Normalize(root))
+		if err != nil {
+			continue
+		}
+		p.addRoot(abs)
+	}
+	for _, root := range cfg.WorkspaceFolderRoots {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		abs, err := filepath.Abs(Normalize(root))
+		if err != nil {
+			continue
+		}
+		if real, err := canonical(filepath.Clean(abs)); err == nil {
+			abs = real
+		}
+		p.folderRoots = append(p.folderRoots, abs)
+	}
+	if len(cfg.AllowedExtensions) > 0 {
+		p.extensions = make(map[string]bool, len(cfg.AllowedExtensions))
+		for _, ext := range cfg.AllowedExtensions {
+			p.extensions[normalizeExtension(ext)] = true
+		}
+	}
+	return p
+}
+
+func (p *Policy) Roots() []string {
+	out := make([]string, len(p.roots))
+	copy(out, p.roots)
+	return out
+}
+
+// WithWorkspaceFolders returns a policy that also allows the given absolute
+// workspace folders, or p itself when allowWorkspaceFolders is off or there
+// is nothing to add. Folders that are filesystem roots or that resolve to a
+// directory outside workspaceFolderRoots are ignored.
+func (p *Policy) WithWorkspaceFolders(folders []string) *Policy {
+	if !p.workspaceFolders || len(folders) == 0 {
+		return p
+	}
+	out := *p
+	out.roots = append([]string(nil), p.roots...)
+	out.realRoots = append([]string(nil), p.realRoots...)
+	for _, folder := range folders {
+		if folder = Normalize(folder); p.acceptsFolder(folder) {
+			out.addRoot(folder)
+		}
+	}
+	return &out
+}
+
+// WithBase returns a policy that resolves relative paths against the
+// absolute directory dir, usually the session working directory, instead
+// of the first allowed root. Paths must still lie inside an allowed root.
+func (p *Policy) WithBase(dir string) *Policy {
+	if dir = Normalize(dir); !filepath.IsAbs(dir) {
+		return p
+	}
+	out := *p
+	out.base = filepath.Clean(dir)
+	return &out
+}
+
+// acceptsFolder reports whether a client workspace folder may become a root.
+func (p *Policy) acceptsFolder(folder string) bool {
+	if !filepath.IsAbs(folder) {
+		return false
+	}
+	folder = filepath.Clean(folder)
+	real, err := canonical(folder)
+	if err != nil {
+		return false
+	}
+	if filepath.Dir(folder) == folder || filepath.Dir(real) == real {
+		return false
+	}
+	for _, root := range p.folderRoots {
+		if Within(root, real) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) addRoot(root string) {
+	root = filepath.Clean(root)
+	if slices.Contains(p.roots, root) {
+		return
+	}
+	real, err := canonical(root)
+	if err != nil {
+		real = root
+	}
+	p.roots = append(p.roots, root)
+	p.realRoots = append(p.realRoots, real)
+}
+
+func (p *Policy) MaxFileSize() int64 {
+	return p.maxFileSize
+}
+
+// Resolve cleans path (relative paths are resolved against the base set by
+// WithBase, or else the first allowed root) and rejects it unless it lies inside an allowed root once symlinks
+// in both are resolved, so neither ".." nor a link can lead outside.
+func (p *Policy) Resolve(path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", fmt.Errorf("Valid file path is required. Path must be a non-empty string.")
+	}
+	if len(p.roots) == 0 {
+		return "", fmt.Errorf("Access to %s is not allowed: no allowed paths are configured", path)
+	}
+	path = Normalize(path)
+	if !filepath.IsAbs(path) {
+		base := p.base
+		if base == "" {
+			base = p.roots[0]
+		}
+		path = filepath.Join(base, path)
+	}
+	resolved := filepath.Clean(path)
+	real, err := canonical(resolved)
+	if err != nil {
+		return "", fmt.Errorf("Access to %s is not allowed: %v", resolved, err)
+	}
+	for _, root := range p.realRoots {
+		if Within(root, real) {
+			return resolved, nil
+		}
+	}
+	if real != resolved {
+		return "", fmt.Errorf("Access to %s is not allowed: it resolves to %s, outside the configured allowed paths", resolved, real)
+	}
+	return "", fmt.Errorf("Access to %s is not allowed: path is outside the configured allowed paths", resolved)
+}
+
+// maxLinkHops bounds the symlinks canonical follows, as the OS does.
+const maxLinkHops = 40
+
+// canonical resolves the symlinks in the clean absolute path. Components
+// that do not exist yet (a file about to be written) are kept as they are,
+// but a dangling link is followed to where it points, since creating the
+// path would create its target.
+func canonical(path string) (string, error) {
+	for hops := 0; hops <= maxLinkHops; hops++ {
+		real, next, err := canonicalStep(path)
+		if err != nil || next == "" {
+			return real, err
+		}
+		path = next
+	}
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
+
+// canonicalStep resolves the longest existing prefix of path. When that
+// prefix ends just before a dangling symlink, it returns the path through
+// the link's target to resolve next instead.
+func canonicalStep(path string) (real string, next string, err error) {
+	var rest []string
+	for cur := path; ; {
+		if resolved, err := filepath.EvalSymlinks(cur); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), "", nil
+		}
+		if info, err := os.Lstat(cur); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(cur)
+			if err != nil {
+				return "", "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(cur), target)
+			}
+			return "", filepath.Join(append([]string{filepath.Clean(target)}, rest...)...), nil
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return path, "", nil
+		}
+		rest = append([]string{filepath.Base(cur)}, rest...)
+		cur = parent
+	}
+}
+
+func (p *Policy) CheckExtension(path string) error {
+	if len(p.extensions) == 0 {
+		return nil
+	}
+	ext := normalizeExtension(filepath.Ext(path))
+	if p.extensions[ext] {
+		return nil
+	}
+	if ext == "." {
+		ext = "(none)"
+	}
+	return fmt.Errorf("Access to %s is not allowed: extension %s is not in allowedExtensions", path, ext)
+}
+
+func (p *Policy) CheckSize(path string, size int64) error {
+	if p.maxFileSize <= 0 || size <= p.maxFileSize {
+		return nil
+	}
+	return fmt.Errorf("Access to %s is not allowed: size %d bytes exceeds maxFileSize of %d bytes", path, size, p.maxFileSize)
+}
+
+// ValidateRead resolves path and checks its extension and, when the file is
+// present on the local filesystem, its size.
+func (p *Policy) ValidateRead(path string) (string, error) {
+	resolved, err := p.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if err := p.CheckExtension(resolved); err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(resolved); err == nil && !info.IsDir() {
+		if err := p.CheckSize(resolved, info.Size()); err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+func (p *Policy) ValidateWrite(path string, size int) (string, error) {
+	resolved, err := p.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if err := p.CheckExtension(resolved); err != nil {
+		return "", err
+	}
+	if err := p.CheckSize(resolved, int64(size)); err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// ValidateDir resolves a directory path; extension and size limits do not apply.
+func (p *Policy) ValidateDir(path string) (string, error) {
+	return p.Resolve(path)
+}
+
+// Normalize converts path to the platform's form. On Windows it accepts
+// forward slashes and the "/C:/dir" form of file URI paths.
+func Normalize(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	return windowsPath(path)
+}
+
+func windowsPath(path string) string {
+	path = strings.ReplaceAll(path, "/", `\`)
+	if len(path) >= 3 && path[0] == '\\' && path[2] == ':' && isDriveLetter(path[1]) {
+		path = path[1:]
+	}
+	return path
+}
+
+func isDriveLetter(c byte) bool {
+	c |= 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// caseInsensitive is set where the default filesystems ignore case
+// (Windows, macOS), so that a root and a path differing only in case match.
+var caseInsensitive = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+func Within(root string, target string) bool {
+	root, target = filepath.Clean(root), filepath.Clean(target)
+	if caseInsensitive {
+		root, target = strings.ToLower(root), strings.ToLower(target)
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
@@ -0,0 +1,206 @@
+package fspolicy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestResolveBlocksTraversalOutsideRoots(t *testing.T) {
+	root := t.TempDir()
+	p := New(config.FilesystemConfig{AllowedPaths: []string{root}})
+
+	got, err := p.Resolve("src/../main.go")
+	if err != nil {
+		t.Fatalf("expected relative path inside root to resolve, got %v", err)
+	}
+	if got != filepath.Join(root, "main.go") {
+		t.Fatalf("unexpected resolved path %q", got)
+	}
+
+	for _, path := range []string{"../escape.txt", filepath.Join(root, "..", "escape.txt"), "/etc/passwd"} {
+		if _, err := p.Resolve(path); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+			t.Fatalf("expected %q to be rejected, got %v", path, err)
+		}
+	}
+	if Within(root, root+"-sibling") {
+		t.Fatalf("expected sibling directory with shared prefix to be outside root")
+	}
+}
+
+func TestWithBaseResolvesRelativePathsAgainstIt(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	p := New(config.FilesystemConfig{AllowedPaths: []string{first, second}})
+
+	got, err := p.WithBase(second).Resolve("main.go")
+	if err != nil || got != filepath.Join(second, "main.go") {
+		t.Fatalf("expected main.go under the base, got %q, %v", got, err)
+	}
+	if got, _ := p.WithBase("relative").Resolve("main.go"); got != filepath.Join(first, "main.go") {
+		t.Fatalf("expected a relative base to be ignored, got %q", got)
+	}
+	if _, err := p.WithBase(t.TempDir()).Resolve("main.go"); err == nil {
+		t.Fatal("expected a base outside the allowed roots to grant nothing")
+	}
+}
+
+func TestValidateChecksExtensionAndSize(t *testing.T) {
+	root := t.TempDir()
+	p := New(config.FilesystemConfig{
+		AllowedPaths:      []string{root},
+		MaxFileSize:       8,
+		AllowedExtensions: []string{"go", ".MD"},
+	})
+
+	if _, err := p.ValidateWrite("main.go", 4); err != nil {
+		t.Fatalf("expected .go write to be allowed, got %v", err)
+	}
+	if _, err := p.ValidateWrite("notes.md", 4); err != nil {
+		t.Fatalf("expected extensions to be case-insensitive, got %v", err)
+	}
+	if _, err := p.ValidateWrite("app.exe", 4); err == nil {
+		t.Fatalf("expected disallowed extension to be rejected")
+	}
+	if _, err := p.ValidateWrite("main.go", 9); err == nil {
+		t.Fatalf("expected oversize write to be rejected")
+	}
+
+	big := filepath.Join(root, "big.go")
+	if err := os.WriteFile(big, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ValidateRead(big); err == nil || !strings.Contains(err.Error(), "maxFileSize") {
+		t.Fatalf("expected oversize local file to be rejected, got %v", err)
+	}
+}
+
+func TestWithWorkspaceFoldersAddsRoots(t *testing.T) {
+	root, workspaces := t.TempDir(), t.TempDir()
+	other, elsewhere := filepath.Join(workspaces, "other"), t.TempDir()
+	if err := os.Mkdir(other, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := New(config.FilesystemConfig{AllowedPaths: []string{root}, AllowWorkspaceFolders: true, WorkspaceFolderRoots: []string{workspaces}})
+	target := filepath.Join(other, "a.go")
+
+	if _, err := p.Resolve(target); err == nil {
+		t.Fatalf("expected %q to be outside the configured roots", target)
+	}
+	withFolders := p.WithWorkspaceFolders([]string{other, "relative"})
+	if got, err := withFolders.Resolve(target); err != nil || got != target {
+		t.Fatalf("expected workspace folder to be allowed, got %q %v", got, err)
+	}
+	if got, _ := withFolders.Resolve("main.go"); got != filepath.Join(root, "main.go") {
+		t.Fatalf("relative paths must still resolve against the first root, got %q", got)
+	}
+	if roots := withFolders.Roots(); len(roots) != 2 || len(p.Roots()) != 1 {
+		t.Fatalf("unexpected roots %v (original %v)", roots, p.Roots())
+	}
+
+	fsRoot := filepath.VolumeName(elsewhere) + string(filepath.Separator)
+	if roots := p.WithWorkspaceFolders([]string{fsRoot, elsewhere}).Roots(); len(roots) != 1 {
+		t.Fatalf("expected a filesystem root and a folder outside workspaceFolderRoots to be ignored, got %v", roots)
+	}
+	link := filepath.Join(workspaces, "escape")
+	symlinkOrSkip(t, elsewhere, link)
+	if roots := p.WithWorkspaceFolders([]string{link}).Roots(); len(roots) != 1 {
+		t.Fatalf("expected a folder linking outside workspaceFolderRoots to be ignored, got %v", roots)
+	}
+
+	p = New(config.FilesystemConfig{AllowedPaths: []string{root}})
+	if _, err := p.WithWorkspaceFolders([]string{other}).Resolve(target); err == nil {
+		t.Fatal("expected workspace folders to be ignored when allowWorkspaceFolders is off")
+	}
+}
+
+func symlinkOrSkip(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+}
+
+func TestResolveFollowsSymlinks(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	symlinkOrSkip(t, outside, filepath.Join(root, "escape"))
+	symlinkOrSkip(t, filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt"))
+	symlinkOrSkip(t, filepath.Join(outside, "missing"), filepath.Join(root, "dangling"))
+	symlinkOrSkip(t, "src", filepath.Join(root, "inner"))
+	p := New(config.FilesystemConfig{AllowedPaths: []string{root}})
+
+	for _, path := range []string{"escape/secret.txt", "secret.txt", "dangling/new.txt", "escape/../escape/new.txt"} {
+		if _, err := p.Resolve(path); err == nil || !strings.Contains(err.Error(), "resolves to") {
+			t.Fatalf("expected %q to be rejected as a link outside the root, got %v", path, err)
+		}
+	}
+	for _, path := range []string{"inner/main.go", "src/new/deeper.go", "inner/../main.go"} {
+		if _, err := p.ValidateWrite(path, 1); err != nil {
+			t.Fatalf("expected %q to stay inside the root, got %v", path, err)
+		}
+	}
+
+	// A root given through a link matches paths under its target and
+	// under the link itself.
+	link := filepath.Join(t.TempDir(), "root-link")
+	symlinkOrSkip(t, root, link)
+	p = New(config.FilesystemConfig{AllowedPaths: []string{link}})
+	for _, path := range []string{filepath.Join(root, "src", "a.go"), filepath.Join(link, "src", "a.go")} {
+		if _, err := p.Resolve(path); err != nil {
+			t.Fatalf("expected %q to be allowed through the linked root, got %v", path, err)
+		}
+	}
+}
+
+func TestResolveRejectsSymlinkLoops(t *testing.T) {
+	root := t.TempDir()
+	symlinkOrSkip(t, filepath.Join(root, "b"), filepath.Join(root, "a"))
+	symlinkOrSkip(t, filepath.Join(root, "a"), filepath.Join(root, "b"))
+	p := New(config.FilesystemConfig{AllowedPaths: []string{root}})
+	if _, err := p.Resolve("a/file.txt"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Fatalf("expected a symlink loop to be rejected, got %v", err)
+	}
+}
+
+func TestWithinCaseSensitivity(t *testing.T) {
+	defer func(v bool) { caseInsensitive = v }(caseInsensitive)
+	root := filepath.Join(string(filepath.Separator), "Users", "Me", "Project")
+	target := filepath.Join(string(filepath.Separator), "users", "me", "PROJECT", "main.go")
+
+	caseInsensitive = true
+	if !Within(root, target) {
+		t.Fatal("expected paths differing in case to match on a case-insensitive filesystem")
+	}
+	if Within(root, root+"-Other") {
+		t.Fatal("case folding must not admit sibling directories")
+	}
+	caseInsensitive = false
+	if Within(root, target) {
+		t.Fatal("expected paths differing in case not to match on a case-sensitive filesystem")
+	}
+}
+
+func TestWindowsPathNormalization(t *testing.T) {
+	for in, want := range map[string]string{
+		"C:/Users/me/project/main.go": `C:\Users\me\project\main.go`,
+		"/c:/Users/me":                `c:\Users\me`,
+		`\\server\share\dir`:          `\\server\share\dir`,
+		"src/main.go":                 `src\main.go`,
+	} {
+		if got := windowsPath(in); got != want {
+			t.Errorf("windowsPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if runtime.GOOS != "windows" && Normalize("a/b") != "a/b" {
+		t.Fatal("Normalize must leave paths alone outside Windows")
+	}
+}
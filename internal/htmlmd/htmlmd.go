@@ -0,0 +1,325 @@
+// Package htmlmd converts HTML documents to Markdown. It is a small,
+// dependency-free converter aimed at documentation pages: it keeps headings,
+// paragraphs, links, lists, emphasis, code and tables-as-text, and drops
+// scripts, styles and page chrome (nav, header, footer, aside, forms).
+package htmlmd
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	skipTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+		"nav": true, "header": true, "footer": true, "aside": true, "form": true,
+		"iframe": true, "button": true, "select": true, "head": true,
+	}
+	voidTags = map[string]bool{
+		"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true,
+		"area": true, "base": true, "col": true, "embed": true, "source": true, "track": true, "wbr": true,
+	}
+	blockTags = map[string]bool{
+		"p": true, "div": true, "section": true, "article": true, "main": true, "table": true,
+		"tr": true, "dl": true, "dt": true, "dd": true, "figure": true, "figcaption": true, "body": true,
+	}
+
+	attrPattern  = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*(?:=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	blankLines   = regexp.MustCompile(`\n{3,}`)
+	spaceRun     = regexp.MustCompile(`[ \t\r\n\f]+`)
+)
+
+// Title returns the document <title>, if any.
+func Title(doc string) string {
+	m := titlePattern.FindStringSubmatch(doc)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(spaceRun.ReplaceAllString(html.UnescapeString(m[1]), " "))
+}
+
+type listState struct {
+	ordered bool
+	index   int
+}
+
+type converter struct {
+	out      strings.Builder
+	skip     []string
+	lists    []listState
+	pre      int
+	quote    int
+	pendingA []string
+}
+
+// Convert renders doc as Markdown.
+func Convert(doc string) string {
+	c := &converter{}
+	for i := 0; i < len(doc); {
+		if doc[i] != '<' {
+			j := strings.IndexByte(doc[i:], '<')
+			if j < 0 {
+				j = len(doc) - i
+			}
+			c.text(doc[i : i+j])
+			i += j
+			continue
+		}
+		if strings.HasPrefix(doc[i:], "<!--") {
+			end := strings.Index(doc[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		end := strings.IndexByte(doc[i:], '>')
+		if end < 0 {
+			c.text(doc[i:])
+			break
+		}
+		c.tag(doc[i+1 : i+end])
+		i += end + 1
+		// Raw-text elements: jump straight to the closing tag.
+		if n := len(c.skip); n > 0 && (c.skip[n-1] == "script" || c.skip[n-1] == "style") {
+			closing := "</" + c.skip[n-1]
+			idx := strings.Index(strings.ToLower(doc[i:]), closing)
+			if idx < 0 {
+				break
+			}
+			i += idx
+		}
+	}
+
+	result := c.out.String()
+	lines := strings.Split(result, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	result = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(result) + "\n"
+}
+
+func (c *converter) text(s string) {
+	if len(c.skip) > 0 {
+		return
+	}
+	s = html.UnescapeString(s)
+	if c.pre > 0 {
+		c.out.WriteString(s)
+		return
+	}
+	s = spaceRun.ReplaceAllString(s, " ")
+	if s == " " || s == "" {
+		if s == " " && !c.atLineStart() && !strings.HasSuffix(c.out.String(), " ") {
+			c.out.WriteString(" ")
+		}
+		return
+	}
+	if c.atLineStart() {
+		s = strings.TrimLeft(s, " ")
+		c.out.WriteString(strings.Repeat("> ", c.quote))
+	}
+	c.out.WriteString(s)
+}
+
+func (c *converter) tag(raw string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw[0] == '!' || raw[0] == '?' {
+		return
+	}
+	closing := raw[0] == '/'
+	if closing {
+		raw = raw[1:]
+	}
+	selfClosing := strings.HasSuffix(raw, "/")
+	raw = strings.TrimSuffix(raw, "/")
+	name := raw
+	rest := ""
+	if idx := strings.IndexAny(raw, " \t\r\n"); idx >= 0 {
+		name, rest = raw[:idx], raw[idx+1:]
+	}
+	name = strings.ToLower(name)
+
+	if skipTags[name] {
+		if closing {
+			if n := len(c.skip); n > 0 && c.skip[n-1] == name {
+				c.skip = c.skip[:n-1]
+			}
+		} else if !selfClosing {
+			c.skip = append(c.skip, name)
+		}
+		return
+	}
+	if len(c.skip) > 0 {
+		return
+	}
+
+	if closing {
+		c.closeTag(name)
+		return
+	}
+	c.openTag(name, rest)
+	if selfClosing && !voidTags[name] {
+		c.closeTag(name)
+	}
+}
+
+func (c *converter) openTag(name string, rest string) {
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.blockBreak()
+		c.out.WriteString(strings.Repeat("#", int(name[1]-'0')) + " ")
+	case "br":
+		c.out.WriteString("\n")
+	case "hr":
+		c.blockBreak()
+		c.out.WriteString("---")
+		c.blockBreak()
+	case "ul", "ol":
+		c.lineBreak()
+		c.lists = append(c.lists, listState{ordered: name == "ol"})
+	case "li":
+		c.lineBreak()
+		depth := len(c.lists)
+		if depth == 0 {
+			c.out.WriteString("- ")
+			return
+		}
+		c.out.WriteString(strings.Repeat("  ", depth-1))
+		state := &c.lists[depth-1]
+		state.index++
+		if state.ordered {
+			c.out.WriteString(strconv.Itoa(state.index) + ". ")
+		} else {
+			c.out.WriteString("- ")
+		}
+	case "pre":
+		c.blockBreak()
+		c.out.WriteString("```\n")
+		c.pre++
+	case "code":
+		if c.pre == 0 {
+			c.out.WriteString("`")
+		}
+	case "strong", "b":
+		c.out.WriteString("**")
+	case "em", "i":
+		c.out.WriteString("_")
+	case "blockquote":
+		c.blockBreak()
+		c.quote++
+	case "a":
+		href := attr(rest, "href")
+		if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			c.pendingA = append(c.pendingA, "")
+			return
+		}
+		c.pendingA = append(c.pendingA, href)
+		c.out.WriteString("[")
+	case "img":
+		alt := attr(rest, "alt")
+		src := attr(rest, "src")
+		if src != "" {
+			c.out.WriteString("![" + alt + "](" + src + ")")
+		}
+	case "td", "th":
+		c.out.WriteString(" | ")
+	default:
+		if blockTags[name] {
+			c.blockBreak()
+		}
+	}
+}
+
+func (c *converter) closeTag(name string) {
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6", "p":
+		c.blockBreak()
+	case "ul", "ol":
+		if n := len(c.lists); n > 0 {
+			c.lists = c.lists[:n-1]
+		}
+		c.blockBreak()
+	case "pre":
+		if c.pre > 0 {
+			c.pre--
+			if !strings.HasSuffix(c.out.String(), "\n") {
+				c.out.WriteString("\n")
+			}
+			c.out.WriteString("```")
+			c.blockBreak()
+		}
+	case "code":
+		if c.pre == 0 {
+			c.out.WriteString("`")
+		}
+	case "strong", "b":
+		c.out.WriteString("**")
+	case "em", "i":
+		c.out.WriteString("_")
+	case "blockquote":
+		if c.quote > 0 {
+			c.quote--
+		}
+		c.blockBreak()
+	case "a":
+		n := len(c.pendingA)
+		if n == 0 {
+			return
+		}
+		href := c.pendingA[n-1]
+		c.pendingA = c.pendingA[:n-1]
+		if href != "" {
+			c.out.WriteString("](" + href + ")")
+		}
+	case "tr":
+		c.lineBreak()
+	default:
+		if blockTags[name] {
+			c.blockBreak()
+		}
+	}
+}
+
+func (c *converter) atLineStart() bool {
+	s := c.out.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+func (c *converter) lineBreak() {
+	if !c.atLineStart() {
+		c.out.WriteString("\n")
+	}
+}
+
+func (c *converter) blockBreak() {
+	if c.out.Len() == 0 {
+		return
+	}
+	s := c.out.String()
+	switch {
+	case strings.HasSuffix(s, "\n\n"):
+	case strings.HasSuffix(s, "\n"):
+		c.out.WriteString("\n")
+	default:
+		c.out.WriteString("\n\n")
+	}
+}
+
+func attr(rest string, name string) string {
+	for _, m := range attrPattern.FindAllStringSubmatch(rest, -1) {
+		if strings.EqualFold(m[1], name) {
+			for _, v := range m[2:] {
+				if v != "" {
+					return html.UnescapeString(v)
+				}
+			}
+			return ""
+		}
+	}
+	return ""
+}
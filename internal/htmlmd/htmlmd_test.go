@@ -0,0 +1,44 @@
+package htmlmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertDocumentationPage(t *testing.T) {
+	doc := `<!DOCTYPE html>
+<html><head><title>Go &amp; You</title><style>body { color: red }</style></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<script>var x = "<p>not content</p>";</script>
+<h1>Getting   started</h1>
+<p>Install with <code>go install</code> and read the <a href="https://go.dev/doc">docs</a>.<br>Then <strong>build</strong> it.</p>
+<ul><li>one</li><li>two<ol><li>nested</li></ol></li></ul>
+<pre><code>func main() {
+	fmt.Println("&lt;hi&gt;")
+}</code></pre>
+<blockquote>Quoted text</blockquote>
+<!-- comment -->
+<footer>Copyright</footer>
+</body></html>`
+
+	if got := Title(doc); got != "Go & You" {
+		t.Fatalf("Title() = %q", got)
+	}
+
+	got := Convert(doc)
+	want := "# Getting started\n\n" +
+		"Install with `go install` and read the [docs](https://go.dev/doc).\n" +
+		"Then **build** it.\n\n" +
+		"- one\n- two\n  1. nested\n\n" +
+		"```\nfunc main() {\n\tfmt.Println(\"<hi>\")\n}\n```\n\n" +
+		"> Quoted text\n"
+	if got != want {
+		t.Fatalf("unexpected markdown:\n%s\n--- want ---\n%s", got, want)
+	}
+	for _, unwanted := range []string{"not content", "Copyright", "Home", "color: red"} {
+		if strings.Contains(got, unwanted) {
+			t.Fatalf("expected %q to be dropped", unwanted)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package i18n holds the catalogs of user-facing agent messages: refusal
+// explanations, slash command responses, progress text and setup guidance.
+// Catalogs live in locales/<locale>.json; template.json lists every message
+// for translators.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is used for locales without a catalog and for messages a
+// catalog lacks.
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Catalog is the messages of one locale.
+type Catalog struct {
+	locale   string
+	messages map[string]string
+	lists    map[string][]string
+	fallback *Catalog
+}
+
+var loadCatalogs = sync.OnceValue(func() map[string]*Catalog {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]*Catalog, len(entries))
+	for _, entry := range entries {
+		locale := strings.TrimSuffix(entry.Name(), ".json")
+		buf, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog, err := parseCatalog(locale, buf)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", entry.Name(), err))
+		}
+		catalogs[locale] = catalog
+	}
+	for locale, catalog := range catalogs {
+		if locale != DefaultLocale {
+			catalog.fallback = catalogs[DefaultLocale]
+		}
+	}
+	return catalogs
+})
+
+// parseCatalog reads a catalog file: message IDs mapped to a string or a
+// list of strings. Keys starting with "_" are notes for translators.
+func parseCatalog(locale string, buf []byte) (*Catalog, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+	c := &Catalog{locale: locale, messages: map[string]string{}, lists: map[string][]string{}}
+	for id, value := range raw {
+		if strings.HasPrefix(id, "_") {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			if text != "" {
+				c.messages[id] = text
+			}
+			continue
+		}
+		var list []string
+		if err := json.Unmarshal(value, &list); err != nil {
+			return nil, fmt.Errorf("%s must be a string or a list of strings", id)
+		}
+		if len(list) > 0 {
+			c.lists[id] = list
+		}
+	}
+	return c, nil
+}
+
+// For returns the catalog for locale, trying the language alone when there
+// is none for the region ("pt-BR", then "pt"). ok is false when it falls
+// back to DefaultLocale.
+func For(locale string) (c *Catalog, ok bool) {
+	catalogs := loadCatalogs()
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if locale == "" {
+		return catalogs[DefaultLocale], true
+	}
+	if c, ok := catalogs[locale]; ok {
+		return c, true
+	}
+	if lang, _, found := strings.Cut(locale, "-"); found {
+		if c, ok := catalogs[lang]; ok {
+			return c, true
+		}
+	}
+	return catalogs[DefaultLocale], false
+}
+
+func (c *Catalog) orDefault() *Catalog {
+	if c == nil {
+		return loadCatalogs()[DefaultLocale]
+	}
+	return c
+}
+
+// Locale is the locale the catalog was loaded for.
+func (c *Catalog) Locale() string {
+	return c.locale
+}
+
+// T formats message id with args. Messages refer to args as %[1]s, %[2]d,
+// ... so translations can reorder them. A message missing from c comes from
+// DefaultLocale, or is id itself. A nil Catalog is DefaultLocale's.
+func (c *Catalog) T(id string, args ...any) string {
+	for catalog := c.orDefault(); catalog != nil; catalog = catalog.fallback {
+		if text, ok := catalog.messages[id]; ok {
+			if len(args) == 0 {
+				return text
+			}
+			return fmt.Sprintf(text, args...)
+		}
+	}
+	return id
+}
+
+// List returns the message list id (e.g. alternative wordings).
+func (c *Catalog) List(id string) []string {
+	for catalog := c.orDefault(); catalog != nil; catalog = catalog.fallback {
+		if list, ok := catalog.lists[id]; ok {
+			return list
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package i18n
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestTemplateListsEveryMessage(t *testing.T) {
+	buf, err := os.ReadFile("template.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &template); err != nil {
+		t.Fatalf("template.json: %v", err)
+	}
+	en, _ := For(DefaultLocale)
+	for id := range en.messages {
+		if _, ok := template[id]; !ok {
+			t.Errorf("template.json is missing %q", id)
+		}
+	}
+	for id := range en.lists {
+		if _, ok := template[id]; !ok {
+			t.Errorf("template.json is missing %q", id)
+		}
+	}
+	for locale, catalog := range loadCatalogs() {
+		for id := range catalog.messages {
+			if _, ok := template[id]; !ok {
+				t.Errorf("%s has %q, which template.json does not", locale, id)
+			}
+		}
+	}
+}
+
+func TestForFallsBackToLanguageAndDefault(t *testing.T) {
+	if c, ok := For("EN_us"); !ok || c.Locale() != "en" {
+		t.Fatalf("For(EN_us) = %s, %v", c.Locale(), ok)
+	}
+	if c, ok := For("xx-YY"); ok || c.Locale() != DefaultLocale {
+		t.Fatalf("For(xx-YY) = %s, %v", c.Locale(), ok)
+	}
+}
+
+func TestCatalogFormatsAndFallsBack(t *testing.T) {
+	de, err := parseCatalog("de", []byte(`{"_about": "x", "command.model.switched": "✓ Modell gewechselt zu %[2]s (vorher %[1]s)", "heartbeat": "", "processing": ["Moment..."]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	de.fallback, _ = For(DefaultLocale)
+
+	if got := de.T("command.model.switched", "a", "b"); got != "✓ Modell gewechselt zu b (vorher a)" {
+		t.Fatalf("unexpected translation %q", got)
+	}
+	if got := de.T("heartbeat", "Working...", 12); got != "Working... (12s)" {
+		t.Fatalf("empty message should fall back to English, got %q", got)
+	}
+	if got := de.T("no.such.message"); got != "no.such.message" {
+		t.Fatalf("unknown message should be its ID, got %q", got)
+	}
+	if got := de.List("processing"); !slices.Equal(got, []string{"Moment..."}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	if _, err := parseCatalog("de", []byte(`{"heartbeat": 3}`)); err == nil {
+		t.Fatal("expected an error for a non-string message")
+	}
+}
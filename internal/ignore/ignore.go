@@ -0,0 +1,221 @@
+// Package ignore implements .gitignore matching and a gitignore-aware
+// directory walker.
+package ignore
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type rule struct {
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Matcher holds gitignore rules. Rules are evaluated in order and the last
+// matching rule wins, so negations ("!pattern") can re-include paths.
+type Matcher struct {
+	rules []rule
+}
+
+func NewMatcher() *Matcher {
+	return &Matcher{}
+}
+
+// AddPatterns adds gitignore-syntax lines that apply to paths under base
+// (a slash-separated path relative to the walk root, "" for the root).
+func (m *Matcher) AddPatterns(base string, lines []string) {
+	base = strings.Trim(filepath.ToSlash(base), "/")
+	for _, line := range lines {
+		if r, ok := parseRule(base, line); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+}
+
+// AddFile reads a .gitignore file whose rules apply under base.
+func (m *Matcher) AddFile(file string, base string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	m.AddPatterns(base, lines)
+	return nil
+}
+
+// Match reports whether rel (slash-separated, relative to the walk root) is
+// ignored. A path is also ignored when any of its parent directories is.
+func (m *Matcher) Match(rel string, isDir bool) bool {
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "" || len(m.rules) == 0 {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchOne(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matchOne(rel, isDir)
+}
+
+func (m *Matcher) matchOne(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.matches(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func (r rule) matches(rel string) bool {
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	parts := strings.Split(rel, "/")
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchSegments(r.segments, parts)
+}
+
+func parseRule(base string, line string) (rule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+	r := rule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	r.segments = strings.Split(line, "/")
+	if !r.anchored && r.segments[0] == "**" {
+		r.anchored = true
+	}
+	return r, true
+}
+
+// MatchGlob matches a slash-separated relative path against a glob pattern.
+// In addition to path.Match syntax, "**" matches any number of directories.
+// Patterns without a slash are matched against the base name only.
+func MatchGlob(pattern string, rel string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// WalkFunc receives the absolute path, the slash-separated path relative to
+// the walk root and the directory entry. Returning filepath.SkipDir or
+// filepath.SkipAll behaves as with filepath.WalkDir.
+type WalkFunc func(path string, rel string, d fs.DirEntry) error
+
+// Walk walks root like filepath.WalkDir but skips .git directories and
+// anything excluded by .gitignore files found along the way. Extra patterns
+// are applied as if they were in a .gitignore at the root.
+func Walk(root string, extra []string, fn WalkFunc) error {
+	matcher := NewMatcher()
+	matcher.AddPatterns("", extra)
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if p == root {
+				return walkErr
+			}
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "" && matcher.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			_ = matcher.AddFile(filepath.Join(p, ".gitignore"), rel)
+			if rel == "" {
+				return nil
+			}
+			return fn(p, rel, d)
+		}
+
+		if matcher.Match(rel, false) {
+			return nil
+		}
+		return fn(p, rel, d)
+	})
+}
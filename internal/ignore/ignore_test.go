@@ -0,0 +1,96 @@
+package ignore
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatcherGitignoreSemantics(t *testing.T) {
+	m := NewMatcher()
+	m.AddPatterns("", []string{
+		"# comment",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/root-only.txt",
+		"docs/**/*.tmp",
+	})
+
+	cases := map[string]bool{
+		"app.log":              true,
+		"nested/dir/app.log":   true,
+		"keep.log":             false,
+		"build":                true,
+		"build/out.bin":        true,
+		"src/build/out.bin":    true,
+		"root-only.txt":        true,
+		"sub/root-only.txt":    false,
+		"docs/a/b/c.tmp":       true,
+		"docs/c.tmp":           true,
+		"src/main.go":          false,
+		"notbuild/file.go":     false,
+		"src/docs/a/ignore.go": false,
+	}
+	for rel, want := range cases {
+		isDir := rel == "build"
+		if got := m.Match(rel, isDir); got != want {
+			t.Errorf("Match(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestWalkHonorsNestedGitignore(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":         "node_modules/\n*.secret\n",
+		"main.go":            "",
+		"key.secret":         "",
+		"node_modules/x.js":  "",
+		"pkg/.gitignore":     "generated.go\n",
+		"pkg/generated.go":   "",
+		"pkg/util.go":        "",
+		"other/generated.go": "",
+		".git/config":        "",
+	}
+	for rel, content := range files {
+		full := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	err := Walk(root, []string{"*.md"}, func(_ string, rel string, d fs.DirEntry) error {
+		if !d.IsDir() {
+			got = append(got, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk returned error: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{".gitignore", "main.go", "other/generated.go", "pkg/.gitignore", "pkg/util.go"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	if !MatchGlob("**/*_test.go", "a/b/c_test.go") || !MatchGlob("**/*_test.go", "c_test.go") {
+		t.Fatalf("expected ** to match any depth")
+	}
+	if MatchGlob("src/*.go", "src/a/b.go") {
+		t.Fatalf("expected single * not to cross directories")
+	}
+	if !MatchGlob("*.go", "deep/path/file.go") {
+		t.Fatalf("expected slashless pattern to match base name")
+	}
+}
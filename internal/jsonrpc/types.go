@@ -2,6 +2,7 @@ package jsonrpc
 
 import (
 	"encoding/json"
+	"errors"
 )
 
 const Version = "2.0"
@@ -14,6 +15,36 @@ const (
 	InternalError  = -32603
 )
 
+// Server error codes for cursor-agent failures. AuthRequired matches ACP.
+const (
+	AuthRequired       = -32000
+	CursorNotInstalled = -32001
+	CursorRateLimited  = -32002
+	CursorTimeout      = -32003
+	CursorKilled       = -32004
+)
+
+// RequestInProgress answers a request whose ID matches one the server is
+// still working on.
+const RequestInProgress = -32005
+
+// Server error codes for adapter failures; see errcode for the matching
+// machine-readable codes.
+const (
+	SessionNotFound         = -32006
+	SessionLimitReached     = -32007
+	SessionBusy             = -32008
+	InvalidMode             = -32009
+	InvalidModel            = -32010
+	ModelNotAllowed         = -32011
+	ToolNotFound            = -32012
+	PermissionDenied        = -32013
+	ClientCapabilityMissing = -32014
+	ClientTimeout           = -32015
+	Cancelled               = -32016
+	CursorResourceLimit     = -32017
+)
+
 // Request is a JSON-RPC 2.0 request/notification.
 // HasID distinguishes notifications (no id field) from requests with id: null.
 type Request struct {
@@ -26,29 +57,14 @@ type Request struct {
 }
 
 func (r *Request) UnmarshalJSON(data []byte) error {
-	type alias struct {
-		JSONRPC string          `json:"jsonrpc"`
-		Method  string          `json:"method"`
-		Params  json.RawMessage `json:"params,omitempty"`
-		ID      any             `json:"id"`
-	}
-
-	var raw map[string]json.RawMessage
-	if err := json.Unmarshal(data, &raw); err != nil {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
 		return err
 	}
-
-	var a alias
-	if err := json.Unmarshal(data, &a); err != nil {
-		return err
+	if m.Invalid != nil {
+		return m.Invalid
 	}
-
-	r.JSONRPC = a.JSONRPC
-	r.Method = a.Method
-	r.Params = a.Params
-	r.ID = a.ID
-	_, r.hasID = raw["id"]
-
+	*r = m.Request
 	return nil
 }
 
@@ -56,12 +72,63 @@ func (r Request) IsNotification() bool {
 	return !r.hasID
 }
 
+// Message is one incoming JSON-RPC message decoded in a single pass: a
+// request or notification when HasMethod is set, else a response to a
+// request of ours. Params and Result stay raw for the receiver to decode
+// into its own types.
+type Message struct {
+	Request
+	HasMethod bool
+	Result    json.RawMessage
+	Error     *Error
+	// Invalid is set when a member has the wrong type, e.g. a numeric
+	// method; the message is still well-formed JSON.
+	Invalid error
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var wire struct {
+		JSONRPC json.RawMessage `json:"jsonrpc"`
+		Method  json.RawMessage `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"`
+		Result  json.RawMessage `json:"result"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*m = Message{HasMethod: wire.Method != nil, Result: wire.Result}
+	m.Params = wire.Params
+	m.hasID = wire.ID != nil
+	m.Invalid = errors.Join(
+		decodeMember(wire.JSONRPC, &m.JSONRPC),
+		decodeMember(wire.Method, &m.Method),
+		decodeMember(wire.ID, &m.ID),
+		decodeMember(wire.Error, &m.Error),
+	)
+	return nil
+}
+
+func decodeMember(raw json.RawMessage, v any) error {
+	if raw == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
 type Error struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
 
+// Error lets handlers return an *Error to choose the code and data of their
+// failure response.
+func (e *Error) Error() string {
+	return e.Message
+}
+
 type Response struct {
 	JSONRPC string `json:"jsonrpc"`
 	ID      any    `json:"id"`
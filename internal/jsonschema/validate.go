@@ -0,0 +1,338 @@
+// Package jsonschema validates decoded JSON values against the subset of
+// JSON Schema that tool parameter schemas use: type, properties, required,
+// additionalProperties, items, enum, const, minimum/maximum (and their
+// exclusive forms), minLength/maxLength, pattern and minItems/maxItems.
+// Values may come straight from encoding/json
+// (map[string]any, []any, float64, ...) or be built in Go, so any map,
+// slice or numeric kind is accepted for the matching JSON type.
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Violation is one way a value fails its schema. Path is a JSON Pointer to
+// the offending value, "" for the root.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Error lists every violation found.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		if v.Path == "" {
+			msgs = append(msgs, v.Message)
+		} else {
+			msgs = append(msgs, v.Path+": "+v.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks value against schema and returns an *Error listing every
+// violation, or nil. A nil schema accepts anything.
+func Validate(schema map[string]any, value any) error {
+	var v validator
+	v.validate(schema, value, "")
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &Error{Violations: v.violations}
+}
+
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) fail(path, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema map[string]any, value any, path string) {
+	if schema == nil {
+		return
+	}
+	if types := stringList(schema["type"]); len(types) > 0 && !matchesAnyType(types, value) {
+		v.fail(path, "must be %s, got %s", strings.Join(types, " or "), typeName(value))
+		return
+	}
+	if enum, ok := schema["enum"]; ok {
+		if options := list(enum); !containsValue(options, value) {
+			v.fail(path, "must be one of %s", formatValues(options))
+		}
+	}
+	if want, ok := schema["const"]; ok && !equal(want, value) {
+		v.fail(path, "must be %s", formatValues([]any{want}))
+	}
+	if n, ok := asNumber(value); ok {
+		v.checkRange(schema, n, path)
+	}
+	if str, ok := value.(string); ok {
+		length := utf8.RuneCountInString(str)
+		if min, ok := asNumber(schema["minLength"]); ok && float64(length) < min {
+			v.fail(path, "must be at least %s characters long", formatNumber(min))
+		}
+		if max, ok := asNumber(schema["maxLength"]); ok && float64(length) > max {
+			v.fail(path, "must be at most %s characters long", formatNumber(max))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re := compilePattern(pattern); re != nil && !re.MatchString(str) {
+				v.fail(path, "must match pattern %q", pattern)
+			}
+		}
+	}
+
+	if obj, ok := asObject(value); ok {
+		for _, name := range stringList(schema["required"]) {
+			if obj[name] == nil {
+				v.fail(pointer(path, name), "is required")
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range sortedKeys(obj) {
+			// Tools treat null like an omitted optional parameter.
+			if obj[name] == nil {
+				continue
+			}
+			if sub, ok := props[name].(map[string]any); ok {
+				v.validate(sub, obj[name], pointer(path, name))
+				continue
+			}
+			if _, declared := props[name]; declared {
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					v.fail(pointer(path, name), "is not an allowed property")
+				}
+			case map[string]any:
+				v.validate(extra, obj[name], pointer(path, name))
+			}
+		}
+	}
+	if items, ok := asArray(value); ok {
+		if min, ok := asNumber(schema["minItems"]); ok && float64(len(items)) < min {
+			v.fail(path, "must have at least %s items", formatNumber(min))
+		}
+		if max, ok := asNumber(schema["maxItems"]); ok && float64(len(items)) > max {
+			v.fail(path, "must have at most %s items", formatNumber(max))
+		}
+		if sub, ok := schema["items"].(map[string]any); ok {
+			for i, item := range items {
+				v.validate(sub, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+	}
+}
+
+func (v *validator) checkRange(schema map[string]any, n float64, path string) {
+	if min, ok := asNumber(schema["minimum"]); ok && n < min {
+		v.fail(path, "must be at least %s", formatNumber(min))
+	}
+	if max, ok := asNumber(schema["maximum"]); ok && n > max {
+		v.fail(path, "must be at most %s", formatNumber(max))
+	}
+	if min, ok := asNumber(schema["exclusiveMinimum"]); ok && n <= min {
+		v.fail(path, "must be greater than %s", formatNumber(min))
+	}
+	if max, ok := asNumber(schema["exclusiveMaximum"]); ok && n >= max {
+		v.fail(path, "must be less than %s", formatNumber(max))
+	}
+}
+
+// patterns caches compiled pattern keywords; schemas are long-lived and
+// validated on every call. An invalid pattern is cached as nil and ignored.
+var patterns sync.Map // string -> *regexp.Regexp
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil
+	}
+	patterns.Store(pattern, re)
+	return re
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func pointer(path, name string) string {
+	return path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func matchesAnyType(types []string, value any) bool {
+	for _, t := range types {
+		if matchesType(t, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(t string, value any) bool {
+	switch t {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := asNumber(value)
+		return ok
+	case "integer":
+		n, ok := asNumber(value)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case "object":
+		_, ok := asObject(value)
+		return ok
+	case "array":
+		_, ok := asArray(value)
+		return ok
+	}
+	// Unknown type names are not ours to reject.
+	return true
+}
+
+func typeName(value any) string {
+	switch {
+	case value == nil:
+		return "null"
+	case matchesType("boolean", value):
+		return "boolean"
+	case matchesType("string", value):
+		return "string"
+	case matchesType("number", value):
+		return "number"
+	case matchesType("object", value):
+		return "object"
+	case matchesType("array", value):
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func asNumber(value any) (float64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func asObject(value any) (map[string]any, bool) {
+	if m, ok := value.(map[string]any); ok {
+		return m, true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]any, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+func asArray(value any) ([]any, bool) {
+	if a, ok := value.([]any); ok {
+		return a, true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	a := make([]any, rv.Len())
+	for i := range a {
+		a[i] = rv.Index(i).Interface()
+	}
+	return a, true
+}
+
+// stringList reads a keyword that is a string or a list of strings, e.g.
+// "type" or "required".
+func stringList(v any) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	items, _ := asArray(v)
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func list(v any) []any {
+	items, _ := asArray(v)
+	return items
+}
+
+func containsValue(options []any, value any) bool {
+	for _, option := range options {
+		if equal(option, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares JSON values, treating numbers of any Go type alike.
+func equal(a, b any) bool {
+	if x, ok := asNumber(a); ok {
+		y, ok := asNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func formatValues(values []any) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			parts = append(parts, fmt.Sprintf("%q", s))
+		} else {
+			parts = append(parts, fmt.Sprint(value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateReportsEveryViolation(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path":  map[string]any{"type": "string"},
+			"mode":  map[string]any{"type": "string", "enum": []string{"fast", "full"}},
+			"count": map[string]any{"type": "integer"},
+			"files": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required": []string{"path"},
+	}
+	var params map[string]any
+	if err := json.Unmarshal([]byte(`{"mode":"slow","count":1.5,"files":["a",2],"extra":true}`), &params); err != nil {
+		t.Fatal(err)
+	}
+	err, _ := Validate(schema, params).(*Error)
+	if err == nil {
+		t.Fatalf("expected violations")
+	}
+	want := []Violation{
+		{Path: "/path", Message: "is required"},
+		{Path: "/count", Message: "must be integer, got number"},
+		{Path: "/files/1", Message: "must be string, got number"},
+		{Path: "/mode", Message: `must be one of "fast", "full"`},
+	}
+	if !reflect.DeepEqual(err.Violations, want) {
+		t.Fatalf("violations = %+v, want %+v", err.Violations, want)
+	}
+}
+
+func TestValidateAcceptsGoValues(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"line":  map[string]any{"type": "integer", "enum": []any{1.0, 2.0}},
+			"files": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"note":  map[string]any{"type": "string"},
+		},
+		"required": []any{"line"},
+	}
+	if err := Validate(schema, map[string]any{"line": 2, "files": []string{"a.go"}, "note": nil}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Validate(nil, "anything"); err != nil {
+		t.Fatalf("a nil schema should accept anything: %v", err)
+	}
+}
+
+func TestValidateRangesLengthsAndUnknownProperties(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"line":  map[string]any{"type": "number", "minimum": 1},
+			"ratio": map[string]any{"type": "number", "exclusiveMaximum": 1},
+			"name":  map[string]any{"type": "string", "minLength": 2, "maxLength": 4, "pattern": "^[a-z]+$"},
+			"tags":  map[string]any{"type": "array", "maxItems": 1},
+		},
+		"additionalProperties": false,
+	}
+	params := map[string]any{"line": 0.0, "ratio": 1.0, "name": "héllo", "tags": []any{"a", "b"}, "verbose": true}
+	err, _ := Validate(schema, params).(*Error)
+	if err == nil {
+		t.Fatalf("expected violations")
+	}
+	want := []Violation{
+		{Path: "/line", Message: "must be at least 1"},
+		{Path: "/name", Message: "must be at most 4 characters long"},
+		{Path: "/name", Message: `must match pattern "^[a-z]+$"`},
+		{Path: "/ratio", Message: "must be less than 1"},
+		{Path: "/tags", Message: "must have at most 1 items"},
+		{Path: "/verbose", Message: "is not an allowed property"},
+	}
+	if !reflect.DeepEqual(err.Violations, want) {
+		t.Fatalf("violations = %+v, want %+v", err.Violations, want)
+	}
+
+	if err := Validate(schema, map[string]any{"line": 3, "ratio": 0.5, "name": "ab", "tags": []any{"x"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/redact"
 )
 
 type Level int
@@ -20,10 +23,34 @@ const (
 )
 
 type Logger struct {
-	mu    sync.Mutex
-	level Level
-	out   io.Writer
-	close io.Closer
+	mu       sync.Mutex
+	level    atomic.Int32
+	redactor atomic.Pointer[redact.Redactor]
+	out      io.Writer
+	close    io.Closer
+}
+
+func (l Level) String() string {
+	switch l {
+	case ErrorLevel:
+		return "error"
+	case WarnLevel:
+		return "warn"
+	case DebugLevel:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// ValidLevel reports whether v names a level ParseLevel understands.
+func ValidLevel(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "error", "warn", "info", "debug":
+		return true
+	default:
+		return false
+	}
 }
 
 func ParseLevel(v string) Level {
@@ -40,32 +67,48 @@ func ParseLevel(v string) Level {
 }
 
 func New(level string) *Logger {
-	return &Logger{
-		level: ParseLevel(level),
-		out:   os.Stderr,
-	}
+	l := &Logger{out: os.Stderr}
+	l.SetLevel(ParseLevel(level))
+	return l
 }
 
 func NewWithOutput(level string, out io.Writer) *Logger {
 	if out == nil {
 		out = os.Stderr
 	}
-	return &Logger{
-		level: ParseLevel(level),
-		out:   out,
-	}
+	l := &Logger{out: out}
+	l.SetLevel(ParseLevel(level))
+	return l
 }
 
 func NewWithFile(level string, path string) (*Logger, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	return NewWithRotatingFile(level, path, Rotation{})
+}
+
+// NewWithRotatingFile logs to path, rotating it as configured.
+func NewWithRotatingFile(level string, path string, rotation Rotation) (*Logger, error) {
+	f, err := openRotatingFile(path, rotation)
 	if err != nil {
 		return nil, err
 	}
-	return &Logger{
-		level: ParseLevel(level),
-		out:   f,
-		close: f,
-	}, nil
+	l := &Logger{out: f, close: f}
+	l.SetLevel(ParseLevel(level))
+	return l, nil
+}
+
+// Level returns the current level.
+func (l *Logger) Level() Level {
+	return Level(l.level.Load())
+}
+
+// SetRedactor masks secrets in every message and its metadata.
+func (l *Logger) SetRedactor(r *redact.Redactor) {
+	l.redactor.Store(r)
+}
+
+// SetLevel changes the level; it is safe to call while logging.
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
 }
 
 func (l *Logger) Close() error {
@@ -80,10 +123,17 @@ func (l *Logger) Close() error {
 }
 
 func (l *Logger) log(level Level, tag string, msg string, meta any) {
-	if level > l.level {
+	if level > l.Level() {
 		return
 	}
 
+	if r := l.redactor.Load(); r != nil {
+		msg = r.String(msg)
+		if meta != nil {
+			meta = r.Value(meta)
+		}
+	}
+
 	line := fmt.Sprintf("%s [%s] %s", time.Now().Format(time.RFC3339), tag, msg)
 	if meta != nil {
 		if b, err := json.Marshal(meta); err == nil {
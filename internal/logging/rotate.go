@@ -0,0 +1,167 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// Rotation controls when a log file is rotated and how many old files are
+// kept. Zero values disable the corresponding limit.
+type Rotation struct {
+	MaxSize    int64         // rotate once the file would grow past this many bytes
+	MaxAge     time.Duration // rotate once the file has been open this long
+	MaxBackups int           // rotated files to keep
+	Compress   bool          // gzip rotated files
+}
+
+// rotatingFile is an append-only log file that renames itself to
+// <path>.<timestamp>[.gz] when it reaches its size or age limit. Callers
+// serialise writes (Logger holds its mutex).
+type rotatingFile struct {
+	path     string
+	rotation Rotation
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// backupsMu serialises compression and pruning of rotated files.
+	backupsMu   sync.Mutex
+	compressing sync.WaitGroup
+}
+
+func openRotatingFile(path string, rotation Rotation) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, rotation: rotation}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file, r.size, r.openedAt = f, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := r.rotation.MaxSize > 0 && r.size+int64(len(p)) > r.rotation.MaxSize
+	tooOld := r.rotation.MaxAge > 0 && time.Since(r.openedAt) >= r.rotation.MaxAge
+	if r.size > 0 && (tooBig || tooOld) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines.
+			_, _ = io.WriteString(os.Stderr, "cursor-agent-acp: log rotation failed: "+err.Error()+"\n")
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	backup := r.path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(r.path, backup)
+	if err := r.open(); err != nil {
+		return errors.Join(renameErr, err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	if r.rotation.Compress {
+		r.compressing.Add(1)
+		go func() {
+			defer r.compressing.Done()
+			r.backupsMu.Lock()
+			defer r.backupsMu.Unlock()
+			if err := compressFile(backup); err == nil {
+				r.prune()
+			}
+		}()
+		return nil
+	}
+	r.backupsMu.Lock()
+	r.prune()
+	r.backupsMu.Unlock()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (r *rotatingFile) prune() {
+	if r.rotation.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	backups := matches[:0]
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, r.path+"."), ".gz")
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// The timestamp format sorts chronologically.
+	sort.Strings(backups)
+	for len(backups) > r.rotation.MaxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	err = errors.Join(err, zw.Close(), out.Close())
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (r *rotatingFile) Close() error {
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.compressing.Wait()
+	return err
+}
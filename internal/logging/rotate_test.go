@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesCompressesAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "adapter.log")
+	logger, err := NewWithRotatingFile("info", path, Rotation{MaxSize: 200, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		logger.Info(strings.Repeat("x", 150), nil)
+		// Backup names have millisecond resolution.
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups after pruning, got %v", backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Fatalf("expected compressed backup, got %s", backup)
+		}
+	}
+	f, err := os.Open(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	if !strings.Contains(string(content), strings.Repeat("x", 150)) {
+		t.Fatalf("unexpected backup content %q", content)
+	}
+	current, _ := os.ReadFile(path)
+	if strings.Count(string(current), "\n") != 1 {
+		t.Fatalf("expected only the last line in the current file, got %q", current)
+	}
+}
+
+func TestRotatingFileRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "adapter.log")
+	logger, err := NewWithRotatingFile("info", path, Rotation{MaxAge: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("first", nil)
+	time.Sleep(5 * time.Millisecond)
+	logger.Info("second", nil)
+	_ = logger.Close()
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) == 0 {
+		t.Fatal("expected an age-based rotation")
+	}
+	current, _ := os.ReadFile(path)
+	if !strings.Contains(string(current), "second") || strings.Contains(string(current), "first") {
+		t.Fatalf("unexpected current log %q", current)
+	}
+}
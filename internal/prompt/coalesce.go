@@ -0,0 +1,78 @@
+package prompt
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+// coalescer batches streamed text blocks so a slow client gets a few larger
+// agent_message_chunk updates instead of hundreds of tiny ones. Text is
+// flushed once the window elapses, the buffer reaches maxBytes, or the text
+// ends at a newline or contains a code fence. Other blocks flush the buffer
+// and are sent as-is, so ordering is preserved.
+type coalescer struct {
+	window   time.Duration
+	maxBytes int
+	send     func(acp.ContentBlock)
+
+	mu    sync.Mutex
+	buf   strings.Builder
+	timer *time.Timer
+}
+
+func newCoalescer(window time.Duration, maxBytes int, send func(acp.ContentBlock)) *coalescer {
+	return &coalescer{window: window, maxBytes: maxBytes, send: send}
+}
+
+// Add queues block, sending whatever is due. Clients append consecutive
+// chunks, so joining their text changes nothing they display.
+func (c *coalescer) Add(block acp.ContentBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.window <= 0 || block.Type != "text" || block.Annotations != nil {
+		c.flushLocked()
+		c.send(block)
+		return
+	}
+	c.buf.WriteString(block.Text)
+	if c.atBoundary(block.Text) {
+		c.flushLocked()
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.Flush)
+	}
+}
+
+// Flush sends any buffered text.
+func (c *coalescer) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *coalescer) atBoundary(text string) bool {
+	if c.maxBytes > 0 && c.buf.Len() >= c.maxBytes {
+		return true
+	}
+	return strings.HasSuffix(text, "\n") || strings.Contains(text, "```")
+}
+
+// flushLocked sends while holding mu so a timer flush cannot reorder text
+// around a block added concurrently. The send itself blocks on the client
+// transport, which is what slows a producer down for a slow client.
+func (c *coalescer) flushLocked() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.buf.Len() == 0 {
+		return
+	}
+	text := c.buf.String()
+	c.buf.Reset()
+	c.send(acp.ContentBlock{Type: "text", Text: text})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
@@ -14,11 +15,19 @@ import (
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/checkpoint"
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
 	"github.com/spjoes/cursor-agent-acp/internal/content"
 	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/i18n"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 	"github.com/spjoes/cursor-agent-acp/internal/session"
 	"github.com/spjoes/cursor-agent-acp/internal/slash"
+	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
+	"github.com/spjoes/cursor-agent-acp/internal/tracing"
 )
 
 type NotifyFn func(method string, params any)
@@ -52,11 +61,22 @@ type Handler struct {
 	notify   NotifyFn
 	slash    *slash.Registry
 
+	checkpoints  *checkpoint.Manager
+	toolCalls    *toolcall.Manager
+	echoWanted   func(sessionID string) bool
+	fs           client.FileSystemClient
+	fsPolicy     atomic.Pointer[fspolicy.Policy]
+	promptConfig config.PromptConfig
+	rules        *rulesCache
+	// messages is nil for English.
+	messages *i18n.Catalog
+
 	processingConfig promptProcessingConfig
 
 	mu                   sync.Mutex
 	sessionQueues        map[string]chan struct{}
 	activeCancels        map[string]context.CancelFunc
+	activeTurns          map[string]string
 	activeStreams        map[string]context.CancelFunc
 	activeSessionStreams map[string]map[string]context.CancelFunc
 }
@@ -76,6 +96,7 @@ func NewHandler(sessions *session.Manager, cursorBridge *cursor.Bridge, logger *
 		sessions: sessions,
 		cursor:   cursorBridge,
 		content:  content.NewProcessor(logger),
+		rules:    newRulesCache(),
 		logger:   logger,
 		notify:   notify,
 		slash:    slashRegistry,
@@ -88,16 +109,98 @@ func NewHandler(sessions *session.Manager, cursorBridge *cursor.Bridge, logger *
 		},
 		sessionQueues:        make(map[string]chan struct{}),
 		activeCancels:        make(map[string]context.CancelFunc),
+		activeTurns:          make(map[string]string),
 		activeStreams:        make(map[string]context.CancelFunc),
 		activeSessionStreams: make(map[string]map[string]context.CancelFunc),
 	}
 }
 
+// SetCheckpointManager enables a working-directory snapshot before each turn.
+func (h *Handler) SetCheckpointManager(m *checkpoint.Manager) {
+	h.checkpoints = m
+}
+
+// SetToolCallManager reports the tool calls cursor-agent streams as they
+// are written.
+func (h *Handler) SetToolCallManager(m *toolcall.Manager) {
+	h.toolCalls = m
+}
+
+// SetUserMessageEcho decides, when prompt.echoUserMessages is off, whether
+// a session has a client that asked for its prompts to be echoed.
+func (h *Handler) SetUserMessageEcho(wanted func(sessionID string) bool) {
+	h.echoWanted = wanted
+}
+
+// SetFileSystemClient reads @-mentioned files through the client's
+// fs/read_text_file, so unsaved editor buffers are used.
+func (h *Handler) SetFileSystemClient(fs client.FileSystemClient) {
+	h.fs = fs
+}
+
+// SetFilesystemPolicy sets the policy @-mentioned files read from disk must
+// pass. A nil policy keeps mentions to what the client can read.
+func (h *Handler) SetFilesystemPolicy(policy *fspolicy.Policy) {
+	h.fsPolicy.Store(policy)
+}
+
+// SetMessages sets the catalog the handler's user-facing text comes from.
+func (h *Handler) SetMessages(messages *i18n.Catalog) {
+	h.messages = messages
+}
+
+// SetPromptConfig controls how prompt payloads are handed to cursor-agent,
+// how streamed output is batched and what the adapter adds to the content
+// it sends.
+func (h *Handler) SetPromptConfig(cfg config.PromptConfig) {
+	h.promptConfig = cfg
+	h.processingConfig.EchoUserMessages = cfg.EchoUserMessages
+	h.processingConfig.AnnotateContent = cfg.AnnotateContent
+	h.processingConfig.MarkInternalContent = cfg.MarkInternalContent
+	h.processingConfig.CollectDetailedMetric = cfg.CollectDetailedMetric
+}
+
+// SetDiffContext sets the context lines around changes when diff blocks
+// are rendered for cursor-agent.
+func (h *Handler) SetDiffContext(lines int) {
+	h.content.SetDiffContext(lines)
+}
+
+// projectRulesEnabled applies the projectRules metadata override, from the
+// prompt or else the session, to the configured default.
+func (h *Handler) projectRulesEnabled(sessionMetadata, promptMetadata map[string]any) bool {
+	if v, ok := promptMetadata[metadataProjectRules].(bool); ok {
+		return v
+	}
+	if v, ok := sessionMetadata[metadataProjectRules].(bool); ok {
+		return v
+	}
+	return h.promptConfig.ProjectRules
+}
+
+// SupportsAudio reports whether modelID matches prompt.audioModels, i.e.
+// whether audio blocks are forwarded to it as files.
+func (h *Handler) SupportsAudio(modelID string) bool {
+	modelID = strings.ToLower(modelID)
+	for _, pattern := range h.promptConfig.AudioModels {
+		if ok, _ := path.Match(strings.ToLower(pattern), modelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsImages reports whether modelID accepts images, i.e. whether image
+// blocks are forwarded to it as files when prompt.attachImages is set.
+func (h *Handler) SupportsImages(modelID string) bool {
+	return h.sessions.ModelInfo(modelID).Vision
+}
+
 func (h *Handler) Process(ctx context.Context, req acp.PromptRequest) (acp.PromptResponse, error) {
 	return h.ProcessWithRequestID(ctx, req, "")
 }
 
-func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptRequest, requestID string) (acp.PromptResponse, error) {
+func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptRequest, requestID string) (resp acp.PromptResponse, err error) {
 	sessionID := strings.TrimSpace(req.SessionID)
 	if sessionID == "" {
 		return acp.PromptResponse{}, fmt.Errorf("sessionId is required")
@@ -127,19 +230,43 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 	h.sessions.MarkProcessing(sessionID)
 	defer h.sessions.UnmarkProcessing(sessionID)
 
+	ctx, span := tracing.Start(ctx, "prompt", map[string]any{
+		"session.id":       sessionID,
+		"acp.request_id":   requestID,
+		"prompt.streaming": req.Stream,
+	})
+	defer func() { span.End(err) }()
+
 	pctx, cancel := context.WithCancel(ctx)
+	turnID := "turn_" + strings.TrimPrefix(messageID(), "msg_")
 	h.mu.Lock()
 	h.activeCancels[sessionID] = cancel
+	h.activeTurns[sessionID] = turnID
 	h.mu.Unlock()
+	start := time.Now().UTC()
+	h.sendTurnUpdate(sessionID, map[string]any{"sessionUpdate": "turn_started", "turnId": turnID, "startedAt": start.Format(time.RFC3339Nano)})
 	defer func() {
+		end := time.Now().UTC()
+		stopReason := resp.StopReason
+		if err != nil {
+			stopReason = "error"
+		}
+		h.sendTurnUpdate(sessionID, map[string]any{
+			"sessionUpdate": "turn_completed",
+			"turnId":        turnID,
+			"startedAt":     start.Format(time.RFC3339Nano),
+			"endedAt":       end.Format(time.RFC3339Nano),
+			"durationMs":    end.Sub(start).Milliseconds(),
+			"stopReason":    stopReason,
+		})
 		h.mu.Lock()
 		delete(h.activeCancels, sessionID)
+		delete(h.activeTurns, sessionID)
 		h.mu.Unlock()
 		cancel()
 	}()
 
-	start := time.Now().UTC()
-	processingText := randomProcessingText()
+	processingText := h.randomProcessingText()
 	h.sendThought(sessionID, processingText, 0, 0)
 
 	var heartbeats atomic.Int64
@@ -156,7 +283,7 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 					h.logger.Warn("Session not found during heartbeat", map[string]any{"sessionId": sessionID, "error": err.Error()})
 					return
 				}
-				h.sendThought(sessionID, fmt.Sprintf("%s (%ds)", processingText, elapsed), int(count), elapsed)
+				h.sendThought(sessionID, h.messages.T("heartbeat", processingText, elapsed), int(count), elapsed)
 			case <-heartbeatDone:
 				return
 			case <-pctx.Done():
@@ -171,6 +298,34 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 		metadata = map[string]any{}
 	}
 
+	// /undo, /retry and /template work in streaming prompts too. What
+	// cursor-agent gets and the conversation records is the prompt they
+	// stand for, not the command.
+	commandReply := func(reply string) acp.PromptResponse {
+		h.sendPlainAgentText(sessionID, reply)
+		return acp.PromptResponse{StopReason: stopReasonEndTurn, Meta: map[string]any{"sessionId": sessionID, "turnId": turnID, "streaming": req.Stream}}
+	}
+	if reply := h.undo(sessionID, contentBlocks); reply != "" {
+		return commandReply(reply), nil
+	}
+	retried, isRetry, reply := h.applyRetryCommand(sessionData.Conversation, contentBlocks)
+	if reply != "" {
+		return commandReply(reply), nil
+	}
+	if isRetry {
+		contentBlocks = retried
+		metadata["retry"] = true
+	}
+	cwd, _ := sessionData.Metadata["cwd"].(string)
+	expanded, templateName, reply := h.applyTemplateCommand(cwd, contentBlocks)
+	if reply != "" {
+		return commandReply(reply), nil
+	}
+	if templateName != "" {
+		contentBlocks = expanded
+		metadata["template"] = templateName
+	}
+
 	// Slash command processing is only applied for regular prompts, matching TS behavior.
 	if !req.Stream {
 		if command, input, ok := detectSlashCommand(contentBlocks); ok {
@@ -178,6 +333,8 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 		}
 	}
 
+	sessionCwd, _ := sessionData.Metadata["cwd"].(string)
+	checkpointID := h.createCheckpoint(sessionID, sessionCwd, contentBlocks, requestID)
 	userMessage := acp.ConversationMessage{
 		ID:        messageID(),
 		Role:      "user",
@@ -185,31 +342,259 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 		Timestamp: time.Now().UTC(),
 		Metadata:  cloneMeta(metadata),
 	}
+	if checkpointID != "" {
+		// /undo restore finds the checkpoint of the turn here, which is
+		// stored even when the turn fails or is cancelled.
+		userMessage.Metadata["checkpointId"] = checkpointID
+	}
 	if err := h.sessions.AddMessage(sessionID, userMessage); err != nil {
 		return acp.PromptResponse{}, err
 	}
 	h.echoUserMessage(sessionID, contentBlocks)
 
-	processedContent, err := h.content.ProcessContent(contentBlocks)
-	if err != nil {
-		return acp.PromptResponse{}, err
+	var attachments *content.Attachments
+	model := h.sessions.GetSessionModel(sessionID)
+	images := h.promptConfig.AttachImages && h.SupportsImages(model)
+	audio := h.SupportsAudio(model)
+	if images || audio || h.promptConfig.ResourceInlineLimit > 0 {
+		attachments = content.NewAttachments(content.AttachmentOptions{
+			Dir:                 h.promptConfig.AttachmentDir,
+			Images:              images,
+			Audio:               audio,
+			ResourceInlineLimit: h.promptConfig.ResourceInlineLimit,
+			MaxInlineBytes:      h.promptConfig.MaxInlineBytes,
+		})
+		defer func() {
+			if err := attachments.Cleanup(); err != nil {
+				h.logger.Warn("Failed to remove prompt attachments", map[string]any{"sessionId": sessionID, "error": err.Error()})
+			}
+		}()
 	}
-
-	metadata["contentMetadata"] = processedContent.Metadata
 	if cwd, ok := sessionData.Metadata["cwd"].(string); ok && strings.TrimSpace(cwd) != "" {
 		metadata["cwd"] = cwd
 	}
-	metadata["model"] = h.sessions.GetSessionModel(sessionID)
+	promptBlocks := contentBlocks
+	if cwd, _ := metadata["cwd"].(string); cwd != "" && h.promptConfig.ResolveMentions {
+		var mentioned []string
+		if promptBlocks, mentioned = h.resolveMentions(pctx, sessionID, cwd, contentBlocks); len(mentioned) > 0 {
+			metadata["mentionedFiles"] = mentioned
+		}
+	}
 	if chatID := h.sessions.GetCursorChatID(sessionID); chatID != "" {
 		metadata["cursorChatId"] = chatID
 	}
+	rules := ""
+	if cwd, _ := metadata["cwd"].(string); cwd != "" && h.projectRulesEnabled(sessionData.Metadata, metadata) {
+		var files []string
+		if rules, files = h.rules.load(cwd, h.promptConfig.ProjectRulesMaxBytes); rules != "" {
+			metadata["projectRulesFiles"] = files
+		}
+	}
+	systemPrefix := h.systemPrefix(sessionData.Metadata, metadata)
+
+	// The budget covers the whole prompt: the system prefix and project
+	// rules are reserved first, and history gets what the blocks leave.
+	budgetOptions := content.BudgetOptions{
+		MaxTokens:      h.promptConfig.MaxPromptTokens,
+		ReservedTokens: content.EstimateTokens(acp.ContentBlock{Type: "text", Text: withSystemPrefix(systemPrefix, withProjectRules(rules, ""))}),
+	}
+	if attachments != nil {
+		budgetOptions.ResourceInlineLimit = h.promptConfig.ResourceInlineLimit
+	}
+	promptBlocks, budget := content.FitBudget(promptBlocks, budgetOptions)
+	if len(budget.Reduced) > 0 {
+		h.logger.Info("Prompt exceeded the context budget", map[string]any{"sessionId": sessionID, "inputTokens": budget.InputTokens, "maxTokens": budget.MaxTokens, "reducedBlocks": len(budget.Reduced)})
+		h.sendWarning(sessionID, fmt.Sprintf("The prompt exceeded the context budget of %d tokens, so %d of its blocks were shortened or left out.", budget.MaxTokens, len(budget.Reduced)), map[string]any{
+			"reason":        "context_budget",
+			"inputTokens":   budget.InputTokens,
+			"maxTokens":     budget.MaxTokens,
+			"reducedBlocks": len(budget.Reduced),
+		})
+	}
+	processedContent, err := h.content.ProcessContentWithAttachments(promptBlocks, attachments)
+	if err != nil {
+		return acp.PromptResponse{}, err
+	}
+
+	metadata["contentMetadata"] = processedContent.Metadata
+	metadata["model"] = h.sessions.GetSessionModel(sessionID)
+	for _, key := range []string{"cursorBinaryPath", "cursorEnv"} {
+		if _, ok := metadata[key]; !ok && sessionData.Metadata[key] != nil {
+			metadata[key] = sessionData.Metadata[key]
+		}
+	}
+	promptText := withProjectRules(rules, processedContent.Value)
+	// A session whose chat could not be created with it gets one now. A
+	// chat that is new to the conversation, or no chat at all, starts from
+	// nothing, so recent messages are sent along.
+	history := ""
+	if metadata["cursorChatId"] == nil {
+		h.replaceCursorChat(pctx, sessionID, metadata)
+		history = conversationHistory(sessionData.Conversation, userMessage.ID, h.historyBytes(budget))
+	}
+
+	sentText := withSystemPrefix(systemPrefix, withConversationHistory(history, promptText))
+	turn := h.runCursor(pctx, sessionID, requestID, req.Stream, sentText, metadata)
+	if errors.Is(turn.err, cursorerr.ErrChatNotFound) && len(turn.blocks) == 0 {
+		// The chat is unknown or expired; retry once in a new one.
+		previousChatID := metadata["cursorChatId"]
+		h.logger.Warn("cursor-agent could not resume the session chat, starting a new one", map[string]any{"sessionId": sessionID, "cursorChatId": previousChatID, "error": turn.err.Error()})
+		chatID := h.replaceCursorChat(pctx, sessionID, metadata)
+		h.sendWarning(sessionID, "The previous cursor-agent chat could not be resumed, so this turn continues in a new chat with a summary of the recent conversation.", map[string]any{
+			"reason":         "chat_not_found",
+			"previousChatId": previousChatID,
+			"cursorChatId":   chatID,
+		})
+		history = conversationHistory(sessionData.Conversation, userMessage.ID, h.historyBytes(budget))
+		sentText = withSystemPrefix(systemPrefix, withConversationHistory(history, promptText))
+		turn = h.runCursor(pctx, sessionID, requestID, req.Stream, sentText, metadata)
+		turn.metadata["chatRecreated"] = true
+	}
+	assistantBlocks, responseMetadata, processingErr, aborted := turn.blocks, turn.metadata, turn.err, turn.aborted
+	if history != "" {
+		responseMetadata["historyInjected"] = true
+	}
+
+	if h.processingConfig.CollectDetailedMetric {
+		responseMetadata["contentMetrics"] = map[string]any{
+			"inputBlocks":  len(contentBlocks),
+			"inputSize":    h.calculateContentSize(contentBlocks),
+			"outputBlocks": len(assistantBlocks),
+			"outputSize":   h.calculateContentSize(assistantBlocks),
+		}
+	}
+	responseMetadata["messageBlocks"] = len(assistantBlocks)
+
+	stopData := h.determineStopReason(processingErr, aborted, responseMetadata)
+	finalStopReason := stopData.StopReason
+	if limit, ok := responseMetadata["maxResponseBytes"]; ok && stopData.StopReason == stopReasonMaxTokens {
+		h.sendWarning(sessionID, fmt.Sprintf("The response exceeded %v bytes and was cut short.", limit), map[string]any{
+			"reason":           "response_truncated",
+			"maxResponseBytes": limit,
+		})
+	}
+	if processingErr != nil && stopData.StopReason == stopReasonRefusal {
+		h.sendRefusalExplanation(sessionID, processingErr, stopData)
+		finalStopReason = stopReasonEndTurn
+	}
+
+	var usage map[string]any
+	if processingErr == nil || turn.usage != nil {
+		usage = h.recordUsage(sessionID, metadata, turn, sentText)
+		responseMetadata["usage"] = usage["turn"]
+	}
+
+	if processingErr == nil {
+		for _, message := range assistantMessages(turnID, assistantBlocks, turn.segments, responseMetadata) {
+			if err := h.sessions.AddMessage(sessionID, message); err != nil {
+				return acp.PromptResponse{}, err
+			}
+		}
+	}
+
+	end := time.Now().UTC()
+	meta := map[string]any{
+		"processingStartedAt":  start.Format(time.RFC3339),
+		"processingEndedAt":    end.Format(time.RFC3339),
+		"processingDurationMs": end.Sub(start).Milliseconds(),
+		"sessionId":            sessionID,
+		"turnId":               turnID,
+		"streaming":            req.Stream,
+		"heartbeatsCount":      int(heartbeats.Load()),
+	}
+	if refreshed, err := h.sessions.LoadSession(sessionID); err == nil {
+		meta["sessionMessageCount"] = refreshed.State.MessageCount
+	}
+	if cm, ok := responseMetadata["contentMetrics"]; ok {
+		meta["contentMetrics"] = cm
+	}
+	if stopData.StopReasonDetails != nil {
+		meta["stopReasonDetails"] = stopData.StopReasonDetails
+	}
+	if n := len(assistantBlocks); n > 0 {
+		meta["messageBlocks"] = n
+	}
+	if checkpointID != "" {
+		meta["checkpointId"] = checkpointID
+	}
+	if len(budget.Reduced) > 0 {
+		meta["contextBudget"] = budget
+	}
+	if usage != nil {
+		meta["usage"] = usage
+	}
+
+	span.SetAttributes(map[string]any{
+		"prompt.stop_reason":   finalStopReason,
+		"prompt.output_blocks": len(assistantBlocks),
+	})
+	span.End(processingErr)
 
+	if processingErr != nil {
+		h.logger.Warn("Prompt processing completed with error", map[string]any{
+			"sessionId":          sessionID,
+			"originalStopReason": stopData.StopReason,
+			"finalStopReason":    finalStopReason,
+			"error":              processingErr.Error(),
+			"explanationSent":    finalStopReason == stopReasonEndTurn && stopData.StopReason == stopReasonRefusal,
+		})
+	}
+
+	return acp.PromptResponse{StopReason: finalStopReason, Meta: meta}, nil
+}
+
+// cursorTurn is the outcome of sending one prompt to cursor-agent.
+type cursorTurn struct {
+	blocks []acp.ContentBlock
+	// segments holds the index in blocks of each assistant message after
+	// the first: cursor-agent starts a new one after tool activity.
+	segments []int
+	metadata map[string]any
+	err      error
+	aborted  bool
+	usage    *cursor.Usage
+}
+
+// assistantMessages splits a turn's reply into its assistant messages,
+// numbered by segment so a session/load replay keeps them apart. The last
+// one carries the turn's metadata.
+func assistantMessages(turnID string, blocks []acp.ContentBlock, segments []int, metadata map[string]any) []acp.ConversationMessage {
+	starts := append([]int{0}, segments...)
+	now := time.Now().UTC()
+	messages := make([]acp.ConversationMessage, 0, len(starts))
+	for i, start := range starts {
+		end := len(blocks)
+		meta := map[string]any{}
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		} else {
+			meta = cloneMeta(metadata)
+		}
+		meta["turnId"] = turnID
+		meta["segment"] = i
+		meta["segmentCount"] = len(starts)
+		messages = append(messages, acp.ConversationMessage{
+			ID:        messageID(),
+			Role:      "assistant",
+			Content:   blocks[start:end],
+			Timestamp: now,
+			Metadata:  meta,
+		})
+	}
+	return messages
+}
+
+// runCursor sends promptText to cursor-agent, streaming the reply to the
+// client as it arrives when stream is set.
+func (h *Handler) runCursor(pctx context.Context, sessionID, requestID string, stream bool, promptText string, metadata map[string]any) cursorTurn {
 	assistantBlocks := make([]acp.ContentBlock, 0)
+	var segments []int
 	responseMetadata := map[string]any{}
 	var processingErr error
+	var usage *cursor.Usage
 	aborted := false
 
-	if req.Stream {
+	if stream {
 		streamRequestID := strings.TrimSpace(requestID)
 		if streamRequestID == "" {
 			streamRequestID = messageID()
@@ -219,30 +604,48 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 		h.registerActiveStream(sessionID, streamRequestID, streamCancel)
 		defer h.unregisterActiveStream(sessionID, streamRequestID)
 
+		chunks := newCoalescer(time.Duration(h.promptConfig.CoalesceWindowMs)*time.Millisecond, h.promptConfig.CoalesceBytes, func(block acp.ContentBlock) {
+			h.sendAnnotatedAgentMessage(sessionID, block)
+		})
+		streamedToolCalls := map[string]*streamedToolCall{}
 		h.content.StartStreaming()
 		streamResult, serr := h.cursor.SendStreamingPrompt(cursor.StreamingPromptOptions{
 			SessionID: sessionID,
-			Content:   processedContent.Value,
+			Content:   promptText,
 			Metadata:  metadata,
 			Ctx:       streamCtx,
 			OnChunk: func(chunk cursor.StreamChunk) error {
 				if chunk.Type == "error" {
 					return fmt.Errorf("Stream error: %v", chunk.Data)
 				}
+				if delta, ok := chunk.Data.(cursor.ToolCallDelta); ok && chunk.Type == "tool_call" {
+					if streamedToolCalls[delta.ID] == nil {
+						// A tool call ends the assistant message before it.
+						for _, block := range h.content.FinalizeStreaming() {
+							assistantBlocks = append(assistantBlocks, block)
+							chunks.Add(block)
+						}
+						h.content.StartStreaming()
+						chunks.Flush()
+						if start := len(assistantBlocks); start > 0 && (len(segments) == 0 || segments[len(segments)-1] < start) {
+							segments = append(segments, start)
+						}
+					}
+					h.reportStreamedToolCall(sessionID, streamedToolCalls, delta)
+					return nil
+				}
 				if chunk.Type != "content" {
 					return nil
 				}
 
-				block, berr := h.content.ProcessStreamChunk(chunk.Data)
+				blocks, berr := h.content.ProcessStreamChunk(chunk.Data)
 				if berr != nil {
 					return berr
 				}
-				if block == nil {
-					return nil
+				for _, block := range blocks {
+					assistantBlocks = append(assistantBlocks, block)
+					chunks.Add(block)
 				}
-
-				assistantBlocks = append(assistantBlocks, *block)
-				h.sendAnnotatedAgentMessage(sessionID, *block)
 				return nil
 			},
 			OnProgress: func(progress cursor.StreamProgress) {
@@ -250,17 +653,21 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 			},
 		})
 
-		finalBlock := h.content.FinalizeStreaming()
-		if finalBlock != nil {
-			assistantBlocks = append(assistantBlocks, *finalBlock)
-			h.sendAnnotatedAgentMessage(sessionID, *finalBlock)
+		for _, block := range h.content.FinalizeStreaming() {
+			assistantBlocks = append(assistantBlocks, block)
+			chunks.Add(block)
 		}
+		chunks.Flush()
+		usage = streamResult.Usage
+		h.endStreamedToolCalls(sessionID, streamedToolCalls, streamCtx.Err() != nil || streamResult.Aborted || errors.Is(serr, context.Canceled))
 
 		if serr != nil {
 			processingErr = serr
 			aborted = streamCtx.Err() != nil || errors.Is(serr, context.Canceled)
 		} else if !streamResult.Success {
-			if strings.TrimSpace(streamResult.Error) != "" {
+			if streamResult.Err != nil {
+				processingErr = streamResult.Err
+			} else if strings.TrimSpace(streamResult.Error) != "" {
 				processingErr = errors.New(streamResult.Error)
 			} else {
 				processingErr = errors.New("Streaming error: Unknown error")
@@ -280,16 +687,19 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 	} else {
 		cursorResult, cerr := h.cursor.SendPrompt(cursor.PromptOptions{
 			SessionID: sessionID,
-			Content:   processedContent.Value,
+			Content:   promptText,
 			Metadata:  metadata,
 			Ctx:       pctx,
 		})
+		usage = cursorResult.Usage
 
 		if cerr != nil {
 			processingErr = cerr
 			aborted = pctx.Err() != nil || errors.Is(cerr, context.Canceled)
 		} else if !cursorResult.Success {
-			if strings.TrimSpace(cursorResult.Error) != "" {
+			if cursorResult.Err != nil {
+				processingErr = cursorResult.Err
+			} else if strings.TrimSpace(cursorResult.Error) != "" {
 				processingErr = errors.New(cursorResult.Error)
 			} else {
 				processingErr = errors.New("Cursor CLI error: Unknown error")
@@ -305,69 +715,47 @@ func (h *Handler) ProcessWithRequestID(ctx context.Context, req acp.PromptReques
 		}
 	}
 
-	if h.processingConfig.CollectDetailedMetric {
-		responseMetadata["contentMetrics"] = map[string]any{
-			"inputBlocks":  len(contentBlocks),
-			"inputSize":    h.calculateContentSize(contentBlocks),
-			"outputBlocks": len(assistantBlocks),
-			"outputSize":   h.calculateContentSize(assistantBlocks),
-		}
-	}
-	responseMetadata["messageBlocks"] = len(assistantBlocks)
-
-	stopData := h.determineStopReason(processingErr, aborted, responseMetadata)
-	finalStopReason := stopData.StopReason
-	if processingErr != nil && stopData.StopReason == stopReasonRefusal {
-		h.sendRefusalExplanation(sessionID, processingErr, stopData)
-		finalStopReason = stopReasonEndTurn
-	}
+	return cursorTurn{blocks: assistantBlocks, segments: segments, metadata: responseMetadata, err: processingErr, aborted: aborted, usage: usage}
+}
 
-	if processingErr == nil {
-		assistantMessage := acp.ConversationMessage{
-			ID:        messageID(),
-			Role:      "assistant",
-			Content:   assistantBlocks,
-			Timestamp: time.Now().UTC(),
-			Metadata:  cloneMeta(responseMetadata),
-		}
-		if err := h.sessions.AddMessage(sessionID, assistantMessage); err != nil {
-			return acp.PromptResponse{}, err
+// recordUsage adds the turn's token usage, as cursor-agent reported it or
+// else estimated from the text sent and received, to the session's totals.
+func (h *Handler) recordUsage(sessionID string, metadata map[string]any, turn cursorTurn, sentText string) map[string]any {
+	model, _ := metadata["model"].(string)
+	usage := acp.TurnUsage{Model: model}
+	if turn.usage != nil {
+		usage.InputTokens = turn.usage.InputTokens
+		usage.OutputTokens = turn.usage.OutputTokens
+		usage.CacheReadTokens = turn.usage.CacheReadTokens
+	} else {
+		usage.Estimated = true
+		usage.InputTokens = content.EstimateTokens(acp.ContentBlock{Type: "text", Text: sentText})
+		for _, block := range turn.blocks {
+			usage.OutputTokens += content.EstimateTokens(block)
 		}
 	}
-
-	end := time.Now().UTC()
-	meta := map[string]any{
-		"processingStartedAt":  start.Format(time.RFC3339),
-		"processingEndedAt":    end.Format(time.RFC3339),
-		"processingDurationMs": end.Sub(start).Milliseconds(),
-		"sessionId":            sessionID,
-		"streaming":            req.Stream,
-		"heartbeatsCount":      int(heartbeats.Load()),
-	}
-	if refreshed, err := h.sessions.LoadSession(sessionID); err == nil {
-		meta["sessionMessageCount"] = refreshed.State.MessageCount
-	}
-	if cm, ok := responseMetadata["contentMetrics"]; ok {
-		meta["contentMetrics"] = cm
-	}
-	if stopData.StopReasonDetails != nil {
-		meta["stopReasonDetails"] = stopData.StopReasonDetails
-	}
-	if n := len(assistantBlocks); n > 0 {
-		meta["messageBlocks"] = n
-	}
-
-	if processingErr != nil {
-		h.logger.Warn("Prompt processing completed with error", map[string]any{
-			"sessionId":          sessionID,
-			"originalStopReason": stopData.StopReason,
-			"finalStopReason":    finalStopReason,
-			"error":              processingErr.Error(),
-			"explanationSent":    finalStopReason == stopReasonEndTurn && stopData.StopReason == stopReasonRefusal,
-		})
+	usage, total, err := h.sessions.RecordUsage(sessionID, usage)
+	if err != nil {
+		h.logger.Warn("Failed to record token usage", map[string]any{"sessionId": sessionID, "error": err.Error()})
 	}
+	return map[string]any{"turn": usage, "session": total}
+}
 
-	return acp.PromptResponse{StopReason: finalStopReason, Meta: meta}, nil
+// replaceCursorChat gives the session a new cursor-agent chat and returns
+// its ID, or clears the session's chat when one cannot be created.
+func (h *Handler) replaceCursorChat(ctx context.Context, sessionID string, metadata map[string]any) string {
+	delete(metadata, "cursorChatId")
+	chatID, err := h.cursor.CreateChat(ctx)
+	if err != nil || chatID == "" {
+		h.logger.Warn("Failed to create a new cursor-agent chat", map[string]any{"sessionId": sessionID, "error": fmt.Sprint(err)})
+		_ = h.sessions.SetCursorChatID(sessionID, "")
+		return ""
+	}
+	metadata["cursorChatId"] = chatID
+	if err := h.sessions.SetCursorChatID(sessionID, chatID); err != nil {
+		h.logger.Warn("Failed to store the new cursor-agent chat", map[string]any{"sessionId": sessionID, "error": err.Error()})
+	}
+	return chatID
 }
 
 func (h *Handler) CancelStream(requestID string) bool {
@@ -447,6 +835,119 @@ func (h *Handler) UpdatePlan(sessionID string, entries []map[string]any) {
 	h.sendPlanNotification(sessionID, entries)
 }
 
+// sendTurnUpdate sends a turn_started or turn_completed session update. The
+// server fills in the counts of a completed turn.
+func (h *Handler) sendTurnUpdate(sessionID string, update map[string]any) {
+	h.notify("session/update", map[string]any{"sessionId": sessionID, "update": update})
+}
+
+// toolInputUpdateInterval is the least time between two in_progress updates
+// of a streamed tool call. Every update carries the whole rawInput so far, so
+// sending one per delta would make a long argument quadratic on the wire.
+const toolInputUpdateInterval = 250 * time.Millisecond
+
+// streamedToolCall is a tool call cursor-agent is streaming in this turn.
+type streamedToolCall struct {
+	rawInput any
+	sent     time.Time
+	pending  bool
+	done     bool
+}
+
+// reportStreamedToolCall shows a tool call cursor-agent is streaming as an
+// in_progress tool call whose rawInput grows as its arguments arrive.
+// calls holds the calls of this turn already announced.
+func (h *Handler) reportStreamedToolCall(sessionID string, calls map[string]*streamedToolCall, delta cursor.ToolCallDelta) {
+	if h.toolCalls == nil {
+		return
+	}
+	call := calls[delta.ID]
+	if call == nil {
+		call = &streamedToolCall{rawInput: delta.RawInput, sent: time.Now()}
+		calls[delta.ID] = call
+		h.toolCalls.ReportToolCall(sessionID, delta.Name, map[string]any{
+			"toolCallId": delta.ID,
+			"title":      delta.Name,
+			"status":     "in_progress",
+			"rawInput":   delta.RawInput,
+		})
+		if !delta.Complete {
+			return
+		}
+	}
+	if call.done {
+		return
+	}
+	call.rawInput = delta.RawInput
+	if delta.Complete {
+		call.done = true
+		h.toolCalls.CompleteToolCall(sessionID, delta.ID, map[string]any{"rawInput": delta.RawInput})
+		return
+	}
+	if time.Since(call.sent) < toolInputUpdateInterval {
+		call.pending = true
+		return
+	}
+	call.sent = time.Now()
+	call.pending = false
+	h.toolCalls.UpdateToolCall(sessionID, delta.ID, map[string]any{"rawInput": delta.RawInput, "status": "in_progress"})
+}
+
+// endStreamedToolCalls settles the streamed tool calls cursor-agent never
+// finished once the stream is over, so none stays in_progress and blocks
+// session/delete. They are cancelled when the turn was, and failed otherwise.
+func (h *Handler) endStreamedToolCalls(sessionID string, calls map[string]*streamedToolCall, aborted bool) {
+	if h.toolCalls == nil {
+		return
+	}
+	ids := make([]string, 0, len(calls))
+	for id, call := range calls {
+		if !call.done {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		call := calls[id]
+		call.done = true
+		if call.pending {
+			h.toolCalls.UpdateToolCall(sessionID, id, map[string]any{"rawInput": call.rawInput})
+		}
+		if aborted {
+			h.toolCalls.CancelToolCall(sessionID, id, "Cancelled by user")
+		} else {
+			h.toolCalls.FailToolCall(sessionID, id, map[string]any{"error": "The response ended before the tool call finished"})
+		}
+	}
+}
+
+// CurrentTurn returns the ID of the prompt turn running in sessionID, or ""
+// when none is.
+func (h *Handler) CurrentTurn(sessionID string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.activeTurns[sessionID]
+}
+
+// ActiveSessions lists sessions with a prompt or stream in flight.
+func (h *Handler) ActiveSessions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := map[string]bool{}
+	out := make([]string, 0, len(h.activeCancels)+len(h.activeSessionStreams))
+	for id := range h.activeCancels {
+		seen[id] = true
+		out = append(out, id)
+	}
+	for id := range h.activeSessionStreams {
+		if !seen[id] {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 func (h *Handler) Close() {
 	h.mu.Lock()
 	for _, cancel := range h.activeCancels {
@@ -456,6 +957,7 @@ func (h *Handler) Close() {
 		cancel()
 	}
 	h.activeCancels = map[string]context.CancelFunc{}
+	h.activeTurns = map[string]string{}
 	h.activeStreams = map[string]context.CancelFunc{}
 	h.activeSessionStreams = map[string]map[string]context.CancelFunc{}
 	h.sessionQueues = map[string]chan struct{}{}
@@ -520,7 +1022,7 @@ func (h *Handler) determineStopReason(err error, aborted bool, responseMetadata
 
 	if reason, _ := responseMetadata["reason"].(string); reason == stopReasonMaxTokens || truthy(responseMetadata["tokenLimitReached"]) {
 		details := map[string]any{"contentTruncated": true}
-		for _, key := range []string{"tokensUsed", "tokenLimit", "partialCompletion"} {
+		for _, key := range []string{"tokensUsed", "tokenLimit", "partialCompletion", "maxResponseBytes"} {
 			if v, ok := responseMetadata[key]; ok {
 				details[key] = v
 			}
@@ -565,6 +1067,20 @@ func (h *Handler) determineStopReason(err error, aborted bool, responseMetadata
 }
 
 func classifyRefusalReason(err error, responseMetadata map[string]any) string {
+	switch cursorerr.Reason(err) {
+	case "not_installed":
+		return "capability_unavailable"
+	case "not_authenticated":
+		return "authentication"
+	case "rate_limited":
+		return "rate_limit"
+	case "timeout":
+		return "timeout"
+	case "killed":
+		return "process_killed"
+	case "resource_limit":
+		return "resource_limit"
+	}
 	if err != nil {
 		msg := strings.ToLower(err.Error())
 		if strings.Contains(msg, "cursor-agent") || strings.Contains(msg, "cursor cli") || strings.Contains(msg, "enoent") || strings.Contains(msg, "command not found") {
@@ -606,6 +1122,30 @@ func refusalType(err error) string {
 	return "refused"
 }
 
+// createCheckpoint snapshots the session cwd before the turn runs. Failures
+// are logged and never block the prompt.
+func (h *Handler) createCheckpoint(sessionID string, cwd string, blocks []acp.ContentBlock, requestID string) string {
+	if h.checkpoints == nil || !h.checkpoints.Enabled() {
+		return ""
+	}
+	if strings.TrimSpace(cwd) == "" {
+		return ""
+	}
+	label := ""
+	for _, block := range blocks {
+		if block.Type == "text" && strings.TrimSpace(block.Text) != "" {
+			label = block.Text
+			break
+		}
+	}
+	cp, err := h.checkpoints.Create(sessionID, cwd, label, requestID)
+	if err != nil {
+		h.logger.Warn("Failed to create checkpoint", map[string]any{"sessionId": sessionID, "cwd": cwd, "error": err.Error()})
+		return ""
+	}
+	return cp.ID
+}
+
 func (h *Handler) sendThought(sessionID string, text string, heartbeatNumber int, elapsedSeconds int) {
 	content := map[string]any{
 		"type": "text",
@@ -630,17 +1170,48 @@ func (h *Handler) sendThought(sessionID string, text string, heartbeatNumber int
 	})
 }
 
+// sendWarning reports a recoverable problem with the turn as a thought
+// chunk whose _meta carries "warning": true and details.
+func (h *Handler) sendWarning(sessionID string, text string, details map[string]any) {
+	meta := map[string]any{"warning": true, "severity": "warning"}
+	for k, v := range details {
+		meta[k] = v
+	}
+	h.notify("session/update", map[string]any{
+		"sessionId": sessionID,
+		"update": map[string]any{
+			"sessionUpdate": "agent_thought_chunk",
+			"content": map[string]any{
+				"type":        "text",
+				"text":        h.messages.T("warning", text),
+				"annotations": map[string]any{"_meta": meta},
+			},
+		},
+	})
+}
+
+// echoMaxBlockBytes is the largest resource, image or audio block that is
+// echoed; the client already has it and larger ones are skipped.
+const echoMaxBlockBytes = 8 * 1024
+
+// echoUserMessage sends the prompt back as user_message_chunk updates,
+// marked with _meta.echo so the server only delivers them to clients that
+// want them.
 func (h *Handler) echoUserMessage(sessionID string, blocks []acp.ContentBlock) {
-	if !h.processingConfig.EchoUserMessages {
+	if !h.processingConfig.EchoUserMessages && (h.echoWanted == nil || !h.echoWanted(sessionID)) {
 		return
 	}
 	for _, block := range blocks {
+		if block.Type != "text" && getContentSize(block) > echoMaxBlockBytes {
+			continue
+		}
 		annotated := h.annotateContentBlock(block, h.getDefaultAnnotations(block.Type, true))
 		h.notify("session/update", map[string]any{
 			"sessionId": sessionID,
 			"update": map[string]any{
 				"sessionUpdate": "user_message_chunk",
 				"content":       annotated,
+				"_meta":         map[string]any{"echo": true},
 			},
 		})
 	}
@@ -663,19 +1234,21 @@ func (h *Handler) sendRefusalExplanation(sessionID string, err error, stopData s
 		reason = value
 	}
 
-	explanationText := "Unable to process your request: " + err.Error()
+	explanationText := h.messages.T("refusal.generic", err.Error())
 	lower := strings.ToLower(err.Error())
 
 	if reason == "capability_unavailable" {
 		if strings.Contains(lower, "not installed") || strings.Contains(lower, "not found") || strings.Contains(lower, "enoent") || strings.Contains(lower, "command not found") || strings.Contains(lower, "spawn cursor-agent enoent") {
-			explanationText = "Unable to process your request because the cursor-agent CLI is not installed or not available in PATH.\n\nTo fix this, install cursor-agent CLI: https://cursor.sh/docs/agent"
+			explanationText = h.messages.T("refusal.notInstalled")
 		} else if strings.Contains(lower, "cursor cli error") {
-			explanationText = "Unable to process your request because cursor-agent CLI is not authenticated.\n\nTo authenticate, run: `cursor-agent login`"
+			explanationText = h.messages.T("refusal.notAuthenticated")
 		} else {
-			explanationText = "Unable to process your request because cursor-agent CLI is unavailable.\n\nPlease check that cursor-agent CLI is properly installed and accessible."
+			explanationText = h.messages.T("refusal.unavailable")
 		}
 	} else if reason == "authentication" {
-		explanationText = "Unable to process your request because cursor-agent CLI is not authenticated.\n\nTo authenticate, run: `cursor-agent login`"
+		explanationText = h.messages.T("refusal.notAuthenticated")
+	} else if reason == "resource_limit" {
+		explanationText = h.messages.T("refusal.resourceLimit", err.Error())
 	}
 
 	priority := 5
@@ -841,9 +1414,10 @@ func (h *Handler) processSlashCommand(sessionID string, command string, input st
 func (h *Handler) processModelCommand(sessionID string, input string) (bool, error) {
 	modelID := strings.TrimSpace(input)
 	if modelID == "" {
-		h.sendPlainAgentText(sessionID, "Error: Please specify a model ID. Usage: /model <model-id>")
+		h.sendPlainAgentText(sessionID, h.messages.T("command.model.usage"))
 		return false, nil
 	}
+	modelID = h.sessions.ResolveModel(modelID)
 
 	availableModels := h.sessions.GetAvailableModels()
 	var model *acp.SessionModel
@@ -859,17 +1433,18 @@ func (h *Handler) processModelCommand(sessionID string, input string) (bool, err
 			ids = append(ids, m.ID)
 		}
 		sort.Strings(ids)
-		h.sendPlainAgentText(sessionID, fmt.Sprintf("Error: Unknown model '%s'. Available models: %s", modelID, strings.Join(ids, ", ")))
+		h.sendPlainAgentText(sessionID, h.messages.T("command.model.unknown", modelID, strings.Join(ids, ", ")))
 		return false, nil
 	}
 
 	previousModel := h.sessions.GetSessionModel(sessionID)
 	if _, err := h.sessions.SetSessionModel(sessionID, modelID); err != nil {
-		h.sendPlainAgentText(sessionID, fmt.Sprintf("Error: Failed to change model: %s", err.Error()))
+		h.sendPlainAgentText(sessionID, h.messages.T("command.model.failed", err.Error()))
 		return false, nil
 	}
 
-	h.sendPlainAgentText(sessionID, fmt.Sprintf("✓ Switched model from %s to %s (%s)", previousModel, modelID, model.Name))
+	h.NotifyModelChanged(sessionID, modelID)
+	h.sendPlainAgentText(sessionID, h.messages.T("command.model.switched", previousModel, modelID, model.Name))
 	h.logger.Info("Model changed via /model command", map[string]any{"sessionId": sessionID, "previousModel": previousModel, "newModel": modelID})
 	return true, nil
 }
@@ -887,6 +1462,27 @@ func (h *Handler) sendPlainAgentText(sessionID string, text string) {
 	})
 }
 
+// NotifyModelChanged sends a current_model_update session update so every
+// view of the session shows its new model. Clients that list the updates
+// they handle in _meta.sessionUpdates only get it when they list it.
+func (h *Handler) NotifyModelChanged(sessionID string, modelID string) {
+	name := modelID
+	for _, model := range h.sessions.GetAvailableModels() {
+		if model.ID == modelID && model.Name != "" {
+			name = model.Name
+			break
+		}
+	}
+	h.notify("session/update", map[string]any{
+		"sessionId": sessionID,
+		"update": map[string]any{
+			"sessionUpdate":  "current_model_update",
+			"currentModelId": modelID,
+			"name":           name,
+		},
+	})
+}
+
 func (h *Handler) sendPlanNotification(sessionID string, entries []map[string]any) {
 	mapped := make([]map[string]any, 0, len(entries))
 	for _, entry := range entries {
@@ -942,6 +1538,15 @@ func getContentSize(block acp.ContentBlock) int {
 		return len(block.Resource.Blob)
 	case "resource_link":
 		return len(block.URI) + len(block.Name)
+	case "diff":
+		size := len(block.Path)
+		if block.OldText != nil {
+			size += len(*block.OldText)
+		}
+		if block.NewText != nil {
+			size += len(*block.NewText)
+		}
+		return size
 	default:
 		return 0
 	}
@@ -1008,25 +1613,10 @@ func truthy(v any) bool {
 	}
 }
 
-func randomProcessingText() string {
-	options := []string{
-		"Crunching the numbers (and my will to live)...",
-		"Hold on, consulting the magic 8-ball...",
-		"Doing the thing...",
-		"Asking the hamsters to run faster...",
-		"Spinning up the chaos engines...",
-		"Bribing the servers...",
-		"Waking up the code gremlins...",
-		"Sacrificing a rubber duck to the programming gods...",
-		"Convincing the database to cooperate...",
-		"Rolling the dice...",
-		"Summoning the data from the void...",
-		"Teaching the robots to behave...",
-		"Turning it off and on again...",
-		"Threatening the API with a timeout...",
-		"Hoping this works...",
-		"Doing some wizardry...",
-		"Making the computers think harder...",
+func (h *Handler) randomProcessingText() string {
+	options := h.messages.List("processing")
+	if len(options) == 0 {
+		return "..."
 	}
 	return options[rand.Intn(len(options))]
 }
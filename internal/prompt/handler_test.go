@@ -2,14 +2,25 @@ package prompt
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/checkpoint"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/cursorerr"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/session"
+	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
 )
 
 func newPromptTestHandler(notify NotifyFn) *Handler {
@@ -59,6 +70,19 @@ func TestDetermineStopReasonRefusalClassification(t *testing.T) {
 	if reason, _ := auth.StopReasonDetails["reason"].(string); reason != "authentication" {
 		t.Fatalf("unexpected auth refusal reason: %#v", auth.StopReasonDetails)
 	}
+
+	// Typed bridge errors win over substring matching on the message.
+	for err, want := range map[error]string{
+		&cursorerr.Error{Kind: cursorerr.ErrRateLimited, Message: "cursor-agent: too many requests"}: "rate_limit",
+		&cursorerr.Error{Kind: cursorerr.ErrKilled, Message: "signal: killed"}:                       "process_killed",
+		&cursorerr.Error{Kind: cursorerr.ErrTimeout}:                                                 "timeout",
+		&cursorerr.Error{Kind: cursorerr.ErrResourceLimit, Message: "output exceeded 10 bytes"}:      "resource_limit",
+	} {
+		data := h.determineStopReason(err, false, map[string]any{})
+		if reason, _ := data.StopReasonDetails["reason"].(string); reason != want {
+			t.Fatalf("%v: expected %q, got %#v", err, want, data.StopReasonDetails)
+		}
+	}
 }
 
 func TestSendRefusalExplanation(t *testing.T) {
@@ -173,3 +197,410 @@ func TestSessionQueueSerialization(t *testing.T) {
 		t.Fatalf("second queue entry did not resume after first release")
 	}
 }
+
+func TestCoalescerBatchesStreamedText(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	c := newCoalescer(20*time.Millisecond, 16, func(block acp.ContentBlock) {
+		mu.Lock()
+		sent = append(sent, block.Type+":"+block.Text)
+		mu.Unlock()
+	})
+	snapshot := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+
+	c.Add(acp.ContentBlock{Type: "text", Text: "Hel"})
+	c.Add(acp.ContentBlock{Type: "text", Text: "lo, "})
+	c.Add(acp.ContentBlock{Type: "text", Text: "world\n"})
+	c.Add(acp.ContentBlock{Type: "text", Text: "0123456789abcdef"})
+	c.Add(acp.ContentBlock{Type: "text", Text: "tail"})
+	c.Add(acp.ContentBlock{Type: "image", Data: "AAAA"})
+	c.Add(acp.ContentBlock{Type: "text", Text: "late"})
+	if got := snapshot(); strings.Join(got, "|") != "text:Hello, world\n|text:0123456789abcdef|text:tail|image:" {
+		t.Fatalf("unexpected batches %q", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(snapshot()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := snapshot(); len(got) != 5 || got[4] != "text:late" {
+		t.Fatalf("expected the window to flush buffered text, got %q", got)
+	}
+}
+
+func TestSupportsAudioMatchesModelPatterns(t *testing.T) {
+	h := newPromptTestHandler(nil)
+	h.SetPromptConfig(config.PromptConfig{AudioModels: []string{"gemini*", "GPT-4o*"}})
+	for model, want := range map[string]bool{"gemini-2.5-pro": true, "gpt-4o-mini": true, "auto": false, "sonnet-4": false} {
+		if got := h.SupportsAudio(model); got != want {
+			t.Fatalf("SupportsAudio(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestPromptMovesToNewChatWhenResumeFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "cursor-agent")
+	script := `#!/usr/bin/env bash
+case "$*" in
+  *create-chat*) echo chat_new ;;
+  *"--resume chat_old"*) echo "Error: chat not found: chat_old" >&2; exit 1 ;;
+  *) cat > "` + dir + `/stdin"; echo "$*" > "` + dir + `/args"; echo '{"result":"done"}' ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Cursor.BinaryPath = binary
+	cfg.Cursor.Retries = 0
+	cfg.Prompt.ProjectRules = false
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(func() { sessions.Close() })
+
+	sess, err := sessions.CreateSession(map[string]any{"cwd": dir, "cursorChatId": "chat_old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []acp.ConversationMessage{
+		{ID: "m1", Role: "user", Content: []acp.ContentBlock{{Type: "text", Text: "rename Foo to Bar"}}},
+		{ID: "m2", Role: "assistant", Content: []acp.ContentBlock{{Type: "text", Text: "renamed it"}}},
+	} {
+		if err := sessions.AddMessage(sess.ID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var warnings []map[string]any
+	h := NewHandler(sessions, cursor.NewBridge(cfg, logger), logger, func(method string, params any) {
+		raw, _ := json.Marshal(params)
+		if strings.Contains(string(raw), `"warning":true`) {
+			mu.Lock()
+			warnings = append(warnings, params.(map[string]any))
+			mu.Unlock()
+		}
+	}, nil)
+	h.SetPromptConfig(cfg.Prompt)
+
+	resp, err := h.ProcessWithRequestID(context.Background(), acp.PromptRequest{SessionID: sess.ID, Prompt: []acp.ContentBlock{{Type: "text", Text: "now update the docs"}}}, "r1")
+	if err != nil || resp.StopReason != stopReasonEndTurn {
+		t.Fatalf("prompt failed: %+v %v", resp, err)
+	}
+	if turnID, _ := resp.Meta["turnId"].(string); !strings.HasPrefix(turnID, "turn_") || h.CurrentTurn(sess.ID) != "" {
+		t.Fatalf("unexpected turn %v after the prompt (current %q)", resp.Meta["turnId"], h.CurrentTurn(sess.ID))
+	}
+	if got := sessions.GetCursorChatID(sess.ID); got != "chat_new" {
+		t.Fatalf("session chat = %q, want chat_new", got)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	stdin, _ := os.ReadFile(filepath.Join(dir, "stdin"))
+	if !strings.Contains(string(args), "--resume chat_new") {
+		t.Fatalf("retry did not resume the new chat: %s", args)
+	}
+	if !strings.HasPrefix(string(stdin), "<conversation_history>") || !strings.Contains(string(stdin), "User: rename Foo to Bar\n\nAssistant: renamed it\n</conversation_history>") || !strings.HasSuffix(string(stdin), "now update the docs") {
+		t.Fatalf("unexpected prompt sent to the new chat:\n%s", stdin)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning notification, got %v", warnings)
+	}
+}
+
+func TestBudgetAndTruncationWarnOnAThoughtChunk(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "cursor-agent")
+	script := `#!/usr/bin/env bash
+case "$*" in
+  *create-chat*) echo chat_1 ;;
+  *) cat > /dev/null; echo '{"result":"` + strings.Repeat("x", 200) + `"}' ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Cursor.BinaryPath = binary
+	cfg.Cursor.Retries = 0
+	cfg.Cursor.MaxResponseBytes = 100
+	cfg.Prompt.ProjectRules = false
+	cfg.Prompt.MaxPromptTokens = 50
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(func() { sessions.Close() })
+	sess, err := sessions.CreateSession(map[string]any{"cwd": dir, "cursorChatId": "chat_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	reasons := map[string]bool{}
+	h := NewHandler(sessions, cursor.NewBridge(cfg, logger), logger, func(method string, params any) {
+		update, _ := params.(map[string]any)["update"].(map[string]any)
+		content, _ := update["content"].(map[string]any)
+		annotations, _ := content["annotations"].(map[string]any)
+		meta, _ := annotations["_meta"].(map[string]any)
+		if update["sessionUpdate"] == "agent_thought_chunk" && meta["warning"] == true {
+			mu.Lock()
+			reason, _ := meta["reason"].(string)
+			reasons[reason] = true
+			mu.Unlock()
+		}
+	}, nil)
+	h.SetPromptConfig(cfg.Prompt)
+
+	resp, err := h.ProcessWithRequestID(context.Background(), acp.PromptRequest{SessionID: sess.ID, Prompt: []acp.ContentBlock{{Type: "text", Text: strings.Repeat("long prompt ", 100)}}}, "r1")
+	if err != nil || resp.StopReason != stopReasonMaxTokens {
+		t.Fatalf("unexpected prompt result: %+v %v", resp, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reasons["context_budget"] || !reasons["response_truncated"] {
+		t.Fatalf("expected budget and truncation warnings, got %v", reasons)
+	}
+}
+
+func TestUndoRestoreFindsTheCheckpointOfAFailedTurn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir, cwd := t.TempDir(), t.TempDir()
+	target := filepath.Join(cwd, "main.go")
+	if err := os.WriteFile(target, []byte("before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "cursor-agent")
+	script := `#!/usr/bin/env bash
+case "$*" in
+  *create-chat*) echo chat_1 ;;
+  *) echo after > "` + target + `"; echo "Error: model overloaded" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Cursor.BinaryPath = binary
+	cfg.Cursor.Retries = 0
+	cfg.Prompt.ProjectRules = false
+	cfg.Checkpoints.Enabled = true
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(func() { sessions.Close() })
+	sess, err := sessions.CreateSession(map[string]any{"cwd": cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(sessions, cursor.NewBridge(cfg, logger), logger, func(string, any) {}, nil)
+	h.SetPromptConfig(cfg.Prompt)
+	h.SetCheckpointManager(checkpoint.NewManager(cfg, logger))
+
+	_, _ = h.ProcessWithRequestID(context.Background(), acp.PromptRequest{SessionID: sess.ID, Prompt: []acp.ContentBlock{{Type: "text", Text: "edit main.go"}}}, "r1")
+	if buf, _ := os.ReadFile(target); string(buf) != "after\n" {
+		t.Fatalf("expected the failed turn to have changed main.go, got %q", buf)
+	}
+	if reply := h.undo(sess.ID, []acp.ContentBlock{{Type: "text", Text: "/undo restore"}}); !strings.Contains(reply, "Restored the files to checkpoint") {
+		t.Fatalf("expected the checkpoint to be restored, got %q", reply)
+	}
+	if buf, _ := os.ReadFile(target); string(buf) != "before\n" {
+		t.Fatalf("expected main.go to be restored, got %q", buf)
+	}
+}
+
+func TestStreamedTurnKeepsAssistantMessagesApart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "cursor-agent")
+	script := `#!/usr/bin/env bash
+case "$*" in
+  *create-chat*) echo chat_1 ;;
+  *) cat > /dev/null
+     echo '{"type":"text","text":"Let me look."}'
+     echo '{"type":"tool_call","subtype":"started","call_id":"c1","tool_call":{"readToolCall":{"args":{"path":"a.go"}}}}'
+     echo '{"type":"tool_call","subtype":"completed","call_id":"c1","tool_call":{"readToolCall":{"args":{"path":"a.go"}}}}'
+     echo '{"type":"text","text":"It is fine."}' ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Cursor.BinaryPath = binary
+	cfg.Cursor.Retries = 0
+	cfg.Prompt.ProjectRules = false
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(func() { sessions.Close() })
+	sess, err := sessions.CreateSession(map[string]any{"cwd": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var updates []string
+	record := func(update map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, update["sessionUpdate"].(string))
+	}
+	h := NewHandler(sessions, cursor.NewBridge(cfg, logger), logger, func(method string, params any) {
+		record(params.(map[string]any)["update"].(map[string]any))
+	}, nil)
+	h.SetPromptConfig(cfg.Prompt)
+	h.SetToolCallManager(toolcall.NewManager(logger, func(n map[string]any) {
+		record(n["params"].(map[string]any)["update"].(map[string]any))
+	}, nil))
+
+	resp, err := h.ProcessWithRequestID(context.Background(), acp.PromptRequest{SessionID: sess.ID, Prompt: []acp.ContentBlock{{Type: "text", Text: "check a.go"}}, Stream: true}, "r1")
+	if err != nil || resp.StopReason != stopReasonEndTurn {
+		t.Fatalf("prompt failed: %+v %v", resp, err)
+	}
+	loaded, err := sessions.LoadSession(sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replies []string
+	for i, msg := range loaded.Conversation[1:] {
+		if msg.Role != "assistant" || msg.Metadata["segment"] != i || msg.Metadata["segmentCount"] != 2 || msg.Metadata["turnId"] != resp.Meta["turnId"] {
+			t.Fatalf("unexpected message %d: %+v", i, msg)
+		}
+		for _, block := range msg.Content {
+			replies = append(replies, block.Text)
+		}
+		replies = append(replies, "|")
+	}
+	if got := strings.Join(replies, ""); got != "Let me look.|It is fine.|" {
+		t.Fatalf("unexpected assistant messages %q", got)
+	}
+	if loaded.Conversation[1].Metadata["usage"] != nil || loaded.Conversation[2].Metadata["messageBlocks"] == nil {
+		t.Fatalf("expected the turn metadata on the last message only: %+v", loaded.Conversation[1:])
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(updates, ","); !strings.Contains(got, "agent_message_chunk,tool_call,tool_call_update,agent_message_chunk") {
+		t.Fatalf("unexpected update order %s", got)
+	}
+}
+
+func TestStreamedToolCallLeftOpenIsFailedWhenTheStreamEnds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "cursor-agent")
+	script := `#!/usr/bin/env bash
+case "$*" in
+  *create-chat*) echo chat_1 ;;
+  *) cat > /dev/null
+     echo '{"type":"tool_call","subtype":"delta","call_id":"c1","tool_call":{"editToolCall":{}},"args_delta":"{\"pa"}'
+     for i in $(seq 1 20); do
+       echo '{"type":"tool_call","subtype":"delta","call_id":"c1","args_delta":"x"}'
+     done
+     echo '{"type":"text","text":"Done."}' ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Cursor.BinaryPath = binary
+	cfg.Cursor.Retries = 0
+	cfg.Prompt.ProjectRules = false
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(func() { sessions.Close() })
+	sess, err := sessions.CreateSession(map[string]any{"cwd": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var updates []map[string]any
+	h := NewHandler(sessions, cursor.NewBridge(cfg, logger), logger, func(string, any) {}, nil)
+	h.SetPromptConfig(cfg.Prompt)
+	calls := toolcall.NewManager(logger, func(n map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, n["params"].(map[string]any)["update"].(map[string]any))
+	}, nil)
+	h.SetToolCallManager(calls)
+
+	if _, err := h.ProcessWithRequestID(context.Background(), acp.PromptRequest{SessionID: sess.ID, Prompt: []acp.ContentBlock{{Type: "text", Text: "edit"}}, Stream: true}, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if calls.HasRunningToolCalls(sess.ID) {
+		t.Fatal("expected the unfinished tool call to be settled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updates) > 4 {
+		t.Fatalf("expected the argument updates to be throttled, got %d updates", len(updates))
+	}
+	if last := updates[len(updates)-1]; last["status"] != "failed" {
+		t.Fatalf("expected the tool call to fail, got %+v", last)
+	}
+}
+
+func TestPromptConfigTurnsOffEchoAndAnnotations(t *testing.T) {
+	var updates []map[string]any
+	h := newPromptTestHandler(func(method string, params any) {
+		updates = append(updates, params.(map[string]any)["update"].(map[string]any))
+	})
+	block := acp.ContentBlock{Type: "text", Text: "hi"}
+	large := acp.ContentBlock{Type: "resource", Resource: &acp.EmbeddedResource{URI: "file:///big.txt", Text: strings.Repeat("x", echoMaxBlockBytes+1)}}
+
+	// Prompts are only echoed to sessions whose clients asked for it.
+	h.SetPromptConfig(config.Default().Prompt)
+	h.echoUserMessage("s1", []acp.ContentBlock{block})
+	h.sendAnnotatedAgentMessage("s1", block)
+	if len(updates) != 1 || updates[0]["content"].(acp.ContentBlock).Annotations["lastModified"] == nil {
+		t.Fatalf("expected only an annotated agent message by default, got %#v", updates)
+	}
+	updates = nil
+	h.SetUserMessageEcho(func(sessionID string) bool { return sessionID == "s2" })
+	h.echoUserMessage("s2", []acp.ContentBlock{block, large})
+	if len(updates) != 1 || updates[0]["sessionUpdate"] != "user_message_chunk" || updates[0]["_meta"].(map[string]any)["echo"] != true {
+		t.Fatalf("expected the text block echoed and the large resource skipped, got %#v", updates)
+	}
+
+	updates = nil
+	cfg := config.Default().Prompt
+	cfg.AnnotateContent = false
+	h.SetPromptConfig(cfg)
+	h.SetUserMessageEcho(nil)
+	h.echoUserMessage("s1", []acp.ContentBlock{block})
+	h.sendAnnotatedAgentMessage("s1", block)
+	if len(updates) != 1 || updates[0]["sessionUpdate"] != "agent_message_chunk" || updates[0]["content"].(acp.ContentBlock).Annotations != nil {
+		t.Fatalf("expected only the unannotated agent message, got %#v", updates)
+	}
+}
+
+func TestDetermineStopReasonTruncatedResponse(t *testing.T) {
+	h := newPromptTestHandler(nil)
+	data := h.determineStopReason(nil, false, map[string]any{"tokenLimitReached": true, "partialCompletion": true, "maxResponseBytes": int64(1000)})
+	if data.StopReason != stopReasonMaxTokens {
+		t.Fatalf("expected max_tokens, got %q", data.StopReason)
+	}
+	if data.StopReasonDetails["partialCompletion"] != true || data.StopReasonDetails["maxResponseBytes"] != int64(1000) {
+		t.Fatalf("unexpected details: %#v", data.StopReasonDetails)
+	}
+}
@@ -0,0 +1,204 @@
+package prompt
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/content"
+)
+
+// maxHistoryMessages bounds the messages in an injected history summary.
+const maxHistoryMessages = 20
+
+// conversationHistory summarizes the most recent messages, newest kept
+// first, in at most maxBytes: one "User:" or "Assistant:" entry per message,
+// each cut to a quarter of the budget, with non-text blocks reduced to a
+// short placeholder. skipID is the message being sent now.
+func conversationHistory(messages []acp.ConversationMessage, skipID string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	perMessage := max(maxBytes/4, 256)
+	entries := make([]string, 0, maxHistoryMessages)
+	total := 0
+	for i := len(messages) - 1; i >= 0 && len(entries) < maxHistoryMessages; i-- {
+		msg := messages[i]
+		if msg.ID == skipID || msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		text := summarizeBlocks(msg.Content)
+		if text == "" {
+			continue
+		}
+		if len(text) > perMessage {
+			text = strings.ToValidUTF8(text[:perMessage], "") + " [...]"
+		}
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		entry := role + ": " + text
+		if total+len(entry) > maxBytes {
+			break
+		}
+		entries = append(entries, entry)
+		total += len(entry) + 2
+	}
+	slices.Reverse(entries)
+	return strings.Join(entries, "\n\n")
+}
+
+func summarizeBlocks(blocks []acp.ContentBlock) string {
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			if text := strings.TrimSpace(block.Text); text != "" {
+				parts = append(parts, text)
+			}
+		case "resource":
+			if block.Resource != nil {
+				parts = append(parts, "[resource: "+block.Resource.URI+"]")
+			}
+		case "resource_link":
+			parts = append(parts, "[resource: "+block.URI+"]")
+		case "diff":
+			parts = append(parts, "[edited "+block.Path+"]")
+		case "image", "audio":
+			parts = append(parts, "["+block.Type+"]")
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// withConversationHistory prepends the summary of a conversation that
+// cursor-agent cannot resume to the prompt text.
+// historyBytes is how much conversation history fits in the prompt next to
+// what budget already holds.
+func (h *Handler) historyBytes(budget content.BudgetResult) int {
+	limit := h.promptConfig.HistoryMaxBytes
+	if budget.MaxTokens <= 0 {
+		return limit
+	}
+	// About four bytes per token, as content estimates them.
+	left := (budget.MaxTokens-budget.OutputTokens)*4 - len(withConversationHistory("\n", ""))
+	return max(min(limit, left), 0)
+}
+
+func withConversationHistory(history, prompt string) string {
+	if history == "" {
+		return prompt
+	}
+	return "<conversation_history>\nThe earlier conversation in this session could not be resumed. Its most recent messages were:\n\n" + history + "\n</conversation_history>\n\n" + prompt
+}
+
+// Commands that work on the conversation: /retry [instructions] sends the
+// previous prompt again, /undo [restore] removes the last exchange.
+const (
+	retryCommand = "retry"
+	undoCommand  = "undo"
+)
+
+// findCommand returns the index of the first text block that is the given
+// slash command, and the command's input. Only the first slash command of a
+// prompt counts, as in detectSlashCommand.
+func findCommand(blocks []acp.ContentBlock, command string) (int, string, bool) {
+	for i, block := range blocks {
+		if block.Type != "text" {
+			continue
+		}
+		text := strings.TrimSpace(block.Text)
+		if !strings.HasPrefix(text, "/") {
+			continue
+		}
+		matches := slashCommandPattern.FindStringSubmatch(text)
+		if len(matches) == 0 {
+			continue
+		}
+		if matches[1] != command {
+			return 0, "", false
+		}
+		return i, strings.TrimSpace(matches[2]), true
+	}
+	return 0, "", false
+}
+
+// applyRetryCommand replaces a /retry command with the content of the
+// previous user message in conversation, followed by the other blocks of
+// the prompt and the command's input as extra instructions. reply is set
+// instead when there is nothing to retry.
+func (h *Handler) applyRetryCommand(conversation []acp.ConversationMessage, blocks []acp.ContentBlock) (retried []acp.ContentBlock, ok bool, reply string) {
+	index, input, found := findCommand(blocks, retryCommand)
+	if !found {
+		return blocks, false, ""
+	}
+	var previous []acp.ContentBlock
+	for _, msg := range slices.Backward(conversation) {
+		if msg.Role == "user" {
+			previous = msg.Content
+			break
+		}
+	}
+	if len(previous) == 0 {
+		return nil, false, h.messages.T("command.retry.none")
+	}
+	retried = slices.Clone(previous)
+	retried = append(retried, blocks[:index]...)
+	retried = append(retried, blocks[index+1:]...)
+	if input != "" {
+		retried = append(retried, acp.ContentBlock{Type: "text", Text: input})
+	}
+	return retried, true, ""
+}
+
+// undo handles a /undo command: it removes the last exchange, restores the
+// checkpoint taken before it with "/undo restore", and drops the session's
+// cursor-agent chat, which still holds the exchange, so the next prompt
+// starts a new one with the remaining conversation. It returns the reply
+// for the user, or "" when blocks are not a /undo command.
+func (h *Handler) undo(sessionID string, blocks []acp.ContentBlock) string {
+	_, input, found := findCommand(blocks, undoCommand)
+	if !found {
+		return ""
+	}
+	restore := false
+	switch input {
+	case "":
+	case "restore":
+		restore = true
+	default:
+		return h.messages.T("command.undo.usage")
+	}
+	removed, err := h.sessions.RemoveLastExchange(sessionID)
+	if err != nil {
+		return h.messages.T("command.undo.failed", err.Error())
+	}
+	if len(removed) == 0 {
+		return h.messages.T("command.undo.none")
+	}
+	if err := h.sessions.SetCursorChatID(sessionID, ""); err != nil {
+		h.logger.Warn("Failed to drop the cursor-agent chat after /undo", map[string]any{"sessionId": sessionID, "error": err.Error()})
+	}
+	h.logger.Info("Removed the last exchange via /undo", map[string]any{"sessionId": sessionID, "messages": len(removed)})
+	reply := h.messages.T("command.undo.done", len(removed))
+	if !restore {
+		return reply
+	}
+	// The prompt records its checkpoint; older sessions have it on a reply.
+	checkpointID := ""
+	for _, msg := range removed {
+		if id, ok := msg.Metadata["checkpointId"].(string); ok && id != "" {
+			checkpointID = id
+			break
+		}
+	}
+	if checkpointID == "" || h.checkpoints == nil {
+		return reply + "\n" + h.messages.T("command.undo.noCheckpoint")
+	}
+	result, err := h.checkpoints.Restore(sessionID, checkpointID)
+	if err != nil {
+		return reply + "\n" + h.messages.T("command.undo.restoreFailed", checkpointID, err.Error())
+	}
+	return reply + "\n" + h.messages.T("command.undo.restored", checkpointID, len(result.Restored), len(result.Removed))
+}
@@ -0,0 +1,135 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/checkpoint"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/content"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/session"
+)
+
+func TestHistoryBytesFitsWhatTheBudgetLeaves(t *testing.T) {
+	h := &Handler{promptConfig: config.PromptConfig{HistoryMaxBytes: 8192}}
+	if got := h.historyBytes(content.BudgetResult{}); got != 8192 {
+		t.Fatalf("expected the configured limit without a budget, got %d", got)
+	}
+	if got := h.historyBytes(content.BudgetResult{MaxTokens: 1000, OutputTokens: 900}); got <= 0 || got > 400 {
+		t.Fatalf("expected history to fit the 100 tokens left, got %d bytes", got)
+	}
+	if got := h.historyBytes(content.BudgetResult{MaxTokens: 1000, OutputTokens: 1000}); got != 0 {
+		t.Fatalf("expected no history in a full budget, got %d bytes", got)
+	}
+}
+
+func TestConversationHistoryKeepsRecentMessages(t *testing.T) {
+	text := func(id, role, text string) acp.ConversationMessage {
+		return acp.ConversationMessage{ID: id, Role: role, Content: []acp.ContentBlock{{Type: "text", Text: text}}}
+	}
+	messages := []acp.ConversationMessage{
+		text("1", "user", "first question "+strings.Repeat("x", 300)),
+		text("2", "assistant", "first answer"),
+		{ID: "3", Role: "user", Content: []acp.ContentBlock{
+			{Type: "text", Text: "look at this"},
+			{Type: "resource_link", URI: "file:///a.go"},
+			{Type: "image", Data: "AAAA", MimeType: "image/png"},
+		}},
+		text("4", "assistant", strings.Repeat("y", 400)),
+		text("5", "user", "the prompt being sent"),
+	}
+
+	got := conversationHistory(messages, "5", 1024)
+	want := "User: first question " + strings.Repeat("x", 241) + " [...]\n\n" +
+		"Assistant: first answer\n\n" +
+		"User: look at this\n[resource: file:///a.go]\n[image]\n\n" +
+		"Assistant: " + strings.Repeat("y", 256) + " [...]"
+	if got != want {
+		t.Fatalf("unexpected history:\n%s", got)
+	}
+
+	if got := conversationHistory(messages, "5", 300); !strings.HasPrefix(got, "Assistant: yyy") || strings.Contains(got, "first answer") {
+		t.Fatalf("a small budget should keep only the newest messages:\n%s", got)
+	}
+	if conversationHistory(messages, "5", 0) != "" || withConversationHistory("", "hi") != "hi" {
+		t.Fatal("expected no history when disabled")
+	}
+}
+
+func TestRetryAndUndoCommands(t *testing.T) {
+	cwd := t.TempDir()
+	target := filepath.Join(cwd, "main.go")
+	if err := os.WriteFile(target, []byte("before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Checkpoints.Enabled = true
+	logger := logging.New("error")
+	sessions := session.NewManager(cfg, logger)
+	t.Cleanup(sessions.Close)
+	h := newPromptTestHandler(nil)
+	h.sessions = sessions
+	h.SetCheckpointManager(checkpoint.NewManager(cfg, logger))
+
+	sess, err := sessions.CreateSession(map[string]any{"cwd": cwd, "cursorChatId": "chat_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := func(s string) []acp.ContentBlock { return []acp.ContentBlock{{Type: "text", Text: s}} }
+	if reply := h.undo(sess.ID, text("/undo")); !strings.Contains(reply, "Nothing to undo") {
+		t.Fatalf("expected nothing to undo, got %q", reply)
+	}
+	if _, _, reply := h.applyRetryCommand(nil, text("/retry")); !strings.Contains(reply, "Nothing to retry") {
+		t.Fatalf("expected nothing to retry, got %q", reply)
+	}
+
+	cp, err := h.checkpoints.Create(sess.ID, cwd, "edit main.go", "r2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []acp.ConversationMessage{
+		{ID: "m1", Role: "user", Content: text("explain main.go")},
+		{ID: "m2", Role: "assistant", Content: text("it prints")},
+		{ID: "m3", Role: "user", Content: text("edit main.go"), Metadata: map[string]any{"checkpointId": cp.ID}},
+		{ID: "m4", Role: "assistant", Content: text("edited")},
+	} {
+		if err := sessions.AddMessage(sess.ID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(target, []byte("after\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _ := sessions.LoadSession(sess.ID)
+	retried, ok, reply := h.applyRetryCommand(loaded.Conversation, text("/retry but keep it short"))
+	if !ok || reply != "" || len(retried) != 2 || retried[0].Text != "edit main.go" || retried[1].Text != "but keep it short" {
+		t.Fatalf("unexpected retry %+v ok=%v reply=%q", retried, ok, reply)
+	}
+
+	if reply := h.undo(sess.ID, text("/undo everything")); !strings.Contains(reply, "Usage: /undo") {
+		t.Fatalf("expected usage, got %q", reply)
+	}
+	if reply := h.undo(sess.ID, text("/undo restore")); !strings.Contains(reply, "2 messages") || !strings.Contains(reply, "Restored the files to checkpoint "+cp.ID) {
+		t.Fatalf("unexpected undo reply %q", reply)
+	}
+	loaded, _ = sessions.LoadSession(sess.ID)
+	if len(loaded.Conversation) != 2 || loaded.Conversation[1].ID != "m2" || loaded.State.MessageCount != 2 {
+		t.Fatalf("expected the last exchange to be removed, got %+v", loaded.Conversation)
+	}
+	if chatID := sessions.GetCursorChatID(sess.ID); chatID != "" {
+		t.Fatalf("expected the cursor-agent chat to be dropped, got %q", chatID)
+	}
+	if buf, _ := os.ReadFile(target); string(buf) != "before\n" {
+		t.Fatalf("expected main.go to be restored, got %q", buf)
+	}
+
+	if reply := h.undo(sess.ID, text("/undo restore")); !strings.Contains(reply, "No checkpoint was taken") {
+		t.Fatalf("expected no checkpoint for the first exchange, got %q", reply)
+	}
+}
@@ -0,0 +1,148 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+)
+
+// mentionPattern matches an @path mention at the start of a word, e.g.
+// "@src/main.go" or "(see @README.md)". Addresses like a@b.c do not match.
+var mentionPattern = regexp.MustCompile("(?:^|[\\s(\\[{])@([^\\s@()\\[\\]{}<>\"'`]+)")
+
+const (
+	// maxMentionedFiles bounds the files embedded for one prompt.
+	maxMentionedFiles = 20
+	mentionPriority   = 0.25
+)
+
+// mentionedFiles returns the distinct regular files under cwd named by @path
+// mentions in the text blocks, skipping files the prompt already carries as
+// a resource or resource link.
+func mentionedFiles(cwd string, blocks []acp.ContentBlock) []string {
+	cwd = filepath.Clean(cwd)
+	attached := make(map[string]bool)
+	for _, block := range blocks {
+		uri := block.URI
+		if block.Resource != nil {
+			uri = block.Resource.URI
+		}
+		if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+			attached[filepath.Clean(u.Path)] = true
+		}
+	}
+
+	files := make([]string, 0)
+	for _, block := range blocks {
+		if block.Type != "text" {
+			continue
+		}
+		for _, m := range mentionPattern.FindAllStringSubmatch(block.Text, -1) {
+			name := strings.TrimRight(m[1], ".,;:!?")
+			if name == "" || strings.Contains(name, "://") {
+				continue
+			}
+			p := filepath.FromSlash(name)
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(cwd, p)
+			}
+			p = filepath.Clean(p)
+			if rel, err := filepath.Rel(cwd, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			if attached[p] || !fileExists(p) {
+				continue
+			}
+			attached[p] = true
+			files = append(files, p)
+			if len(files) == maxMentionedFiles {
+				return files
+			}
+		}
+	}
+	return files
+}
+
+// resolveMentions appends a resource block for each file mentioned in
+// blocks. Files are read through the client so unsaved editor buffers are
+// used, falling back to the file on disk when the client cannot serve them
+// and the filesystem policy allows reading it.
+func (h *Handler) resolveMentions(ctx context.Context, sessionID, cwd string, blocks []acp.ContentBlock) ([]acp.ContentBlock, []string) {
+	files := mentionedFiles(cwd, blocks)
+	if len(files) == 0 {
+		return blocks, nil
+	}
+	out := append(make([]acp.ContentBlock, 0, len(blocks)+len(files)), blocks...)
+	embedded := make([]string, 0, len(files))
+	for _, p := range files {
+		text, err := h.readMentionedFile(ctx, sessionID, p)
+		if err != nil {
+			h.logger.Debug("Skipping mentioned file", map[string]any{"sessionId": sessionID, "path": p, "error": err.Error()})
+			continue
+		}
+		if limit := h.promptConfig.MentionMaxBytes; limit > 0 && len(text) > limit {
+			text = strings.ToValidUTF8(text[:limit], "") + fmt.Sprintf("\n[truncated: %d bytes omitted]", len(text)-limit)
+		}
+		out = append(out, acp.ContentBlock{
+			Type: "resource",
+			// Below attached resources, so the context budget cuts
+			// mentioned files first.
+			Annotations: map[string]any{"priority": mentionPriority},
+			Resource: &acp.EmbeddedResource{
+				URI:      (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String(),
+				MimeType: mentionMimeType(p),
+				Text:     text,
+			},
+		})
+		embedded = append(embedded, p)
+	}
+	return out, embedded
+}
+
+func (h *Handler) readMentionedFile(ctx context.Context, sessionID, p string) (string, error) {
+	if h.fs != nil {
+		text, err := h.fs.ReadTextFile(ctx, client.ReadFileOptions{SessionID: sessionID, Path: p})
+		if err == nil {
+			return text, nil
+		}
+		h.logger.Debug("Client could not read mentioned file, reading it from disk", map[string]any{"path": p, "error": err.Error()})
+	}
+	policy := h.fsPolicy.Load()
+	if policy == nil {
+		return "", fmt.Errorf("filesystem tools are disabled")
+	}
+	if h.sessions != nil {
+		policy = policy.WithWorkspaceFolders(h.sessions.GetWorkspaceFolders(sessionID))
+	}
+	// The policy resolves symlinks, so a link under cwd cannot expose a
+	// file outside the allowed paths.
+	resolved, err := policy.ValidateRead(p)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(resolved)
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("binary file")
+	}
+	return string(data), nil
+}
+
+func mentionMimeType(p string) string {
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(p)))
+	if mimeType == "" || !strings.HasPrefix(mimeType, "text/") && !strings.HasPrefix(mimeType, "application/") {
+		return "text/plain"
+	}
+	return mimeType
+}
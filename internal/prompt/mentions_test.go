@@ -0,0 +1,103 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+)
+
+type fakeFileSystem struct {
+	files map[string]string
+	reads []string
+}
+
+func (f *fakeFileSystem) ReadTextFile(_ context.Context, options client.ReadFileOptions) (string, error) {
+	f.reads = append(f.reads, options.Path)
+	if text, ok := f.files[options.Path]; ok {
+		return text, nil
+	}
+	return "", errors.New("client does not support fs/read_text_file")
+}
+
+func (f *fakeFileSystem) WriteTextFile(context.Context, client.WriteFileOptions) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeFileSystem) ListDirectory(context.Context, client.ListDirectoryOptions) ([]client.DirectoryEntry, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestMentionedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "docs/guide.md", "attached.txt"} {
+		writeRulesFile(t, filepath.Join(dir, name), "x")
+	}
+	writeRulesFile(t, filepath.Join(filepath.Dir(dir), "outside.txt"), "x")
+
+	blocks := []acp.ContentBlock{
+		{Type: "text", Text: "Fix @main.go, then (see @docs/guide.md). Mail me@example.com about @missing.go and @../outside.txt or @docs"},
+		{Type: "text", Text: "@main.go again and @attached.txt"},
+		{Type: "resource_link", URI: "file://" + filepath.ToSlash(filepath.Join(dir, "attached.txt"))},
+	}
+	got := mentionedFiles(dir, blocks)
+	want := []string{filepath.Join(dir, "main.go"), filepath.Join(dir, "docs/guide.md")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("mentioned files = %v, want %v", got, want)
+	}
+}
+
+func TestResolveMentionsEmbedsResources(t *testing.T) {
+	dir := t.TempDir()
+	writeRulesFile(t, filepath.Join(dir, "a.go"), "package a // on disk\n")
+	writeRulesFile(t, filepath.Join(dir, "b.txt"), strings.Repeat("b", 20))
+	writeRulesFile(t, filepath.Join(dir, "c.bin"), "\x00\x01")
+
+	fs := &fakeFileSystem{files: map[string]string{filepath.Join(dir, "a.go"): "package a // unsaved buffer\n"}}
+	h := newPromptTestHandler(nil)
+	h.SetFileSystemClient(fs)
+	h.SetFilesystemPolicy(fspolicy.New(config.FilesystemConfig{Enabled: true, AllowedPaths: []string{dir}}))
+	h.promptConfig.MentionMaxBytes = 10
+
+	prompt := []acp.ContentBlock{{Type: "text", Text: "Compare @a.go with @b.txt and @c.bin"}}
+	blocks, files := h.resolveMentions(context.Background(), "s1", dir, prompt)
+	if len(files) != 2 || len(blocks) != 3 || len(fs.reads) != 3 {
+		t.Fatalf("files = %v, blocks = %+v, reads = %v", files, blocks, fs.reads)
+	}
+	a, b := blocks[1].Resource, blocks[2].Resource
+	if a.URI != "file://"+filepath.ToSlash(filepath.Join(dir, "a.go")) || a.Text != "package a \n[truncated: 18 bytes omitted]" {
+		t.Fatalf("unexpected resource for a.go: %+v", a)
+	}
+	if b.MimeType != "text/plain" || b.Text != "bbbbbbbbbb\n[truncated: 10 bytes omitted]" {
+		t.Fatalf("unexpected resource for b.txt (read from disk): %+v", b)
+	}
+}
+
+func TestResolveMentionsReadsDiskOnlyThroughThePolicy(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeRulesFile(t, filepath.Join(dir, "notes.txt"), "notes")
+	writeRulesFile(t, filepath.Join(dir, "key.pem"), "key")
+	writeRulesFile(t, filepath.Join(outside, "secret.txt"), "secret")
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	prompt := []acp.ContentBlock{{Type: "text", Text: "Read @notes.txt, @key.pem and @link.txt"}}
+
+	h := newPromptTestHandler(nil)
+	if _, files := h.resolveMentions(context.Background(), "s1", dir, prompt); len(files) != 0 {
+		t.Fatalf("expected no disk reads without a policy, got %v", files)
+	}
+	h.SetFilesystemPolicy(fspolicy.New(config.FilesystemConfig{Enabled: true, AllowedPaths: []string{dir}, AllowedExtensions: []string{".txt"}}))
+	_, files := h.resolveMentions(context.Background(), "s1", dir, prompt)
+	if len(files) != 1 || files[0] != filepath.Join(dir, "notes.txt") {
+		t.Fatalf("expected only notes.txt to be read, got %v", files)
+	}
+}
@@ -0,0 +1,160 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metadataProjectRules is the session or prompt metadata key that turns
+// project rules injection off for a session ("projectRules": false).
+const metadataProjectRules = "projectRules"
+
+// projectRules is the rules text for one working directory and the stamp
+// of the files it was read from.
+type projectRules struct {
+	stamp string
+	text  string
+	files []string
+}
+
+// rulesCache reads .cursorrules, .cursor/rules/*.mdc and AGENTS.md from a
+// working directory, re-reading them only when one of them changes.
+type rulesCache struct {
+	mu      sync.Mutex
+	entries map[string]projectRules
+}
+
+func newRulesCache() *rulesCache {
+	return &rulesCache{entries: make(map[string]projectRules)}
+}
+
+// rulesFiles lists the rules files present in cwd, in the order they are
+// injected.
+func rulesFiles(cwd string) []string {
+	files := make([]string, 0)
+	if fileExists(filepath.Join(cwd, ".cursorrules")) {
+		files = append(files, ".cursorrules")
+	}
+	mdc, _ := filepath.Glob(filepath.Join(cwd, ".cursor", "rules", "*.mdc"))
+	sort.Strings(mdc)
+	for _, path := range mdc {
+		if rel, err := filepath.Rel(cwd, path); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+	}
+	if fileExists(filepath.Join(cwd, "AGENTS.md")) {
+		files = append(files, "AGENTS.md")
+	}
+	return files
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// load returns the rules for cwd, capped at maxBytes, and the files they
+// came from.
+func (c *rulesCache) load(cwd string, maxBytes int) (string, []string) {
+	files := rulesFiles(cwd)
+	var stamp strings.Builder
+	for _, name := range files {
+		if info, err := os.Stat(filepath.Join(cwd, name)); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	fmt.Fprintf(&stamp, "max:%d", maxBytes)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[cwd]; ok && entry.stamp == stamp.String() {
+		return entry.text, entry.files
+	}
+	text, used := readProjectRules(cwd, files, maxBytes)
+	c.entries[cwd] = projectRules{stamp: stamp.String(), text: text, files: used}
+	return text, used
+}
+
+// readProjectRules concatenates the rules files under a heading each. A
+// .mdc rule is included in full when it has no front matter or sets
+// alwaysApply; other .mdc rules only apply to some files or on request, so
+// they are listed with their description for the agent to open when
+// relevant. Text past maxBytes is cut off with a note.
+func readProjectRules(cwd string, files []string, maxBytes int) (string, []string) {
+	var b strings.Builder
+	used := make([]string, 0, len(files))
+	listed := make([]string, 0)
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(cwd, name))
+		if err != nil {
+			continue
+		}
+		body := strings.TrimSpace(string(data))
+		if strings.HasSuffix(name, ".mdc") {
+			front, rest := splitFrontMatter(body)
+			if front != nil && front["alwaysApply"] != "true" {
+				entry := "- " + name
+				if desc := front["description"]; desc != "" {
+					entry += ": " + desc
+				}
+				if globs := front["globs"]; globs != "" {
+					entry += " (applies to " + globs + ")"
+				}
+				listed = append(listed, entry)
+				used = append(used, name)
+				continue
+			}
+			body = strings.TrimSpace(rest)
+		}
+		if body == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", name, body)
+		used = append(used, name)
+	}
+	if len(listed) > 0 {
+		b.WriteString("## Other rules (read the file when relevant)\n\n" + strings.Join(listed, "\n") + "\n")
+	}
+
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return "", nil
+	}
+	if maxBytes > 0 && len(text) > maxBytes {
+		omitted := len(text) - maxBytes
+		text = strings.ToValidUTF8(text[:maxBytes], "") + fmt.Sprintf("\n\n[project rules truncated: %d bytes omitted]", omitted)
+	}
+	return text, used
+}
+
+// splitFrontMatter separates a leading "---" delimited block of "key: value"
+// lines from the rest of a .mdc rule. front is nil when there is none.
+func splitFrontMatter(text string) (front map[string]string, rest string) {
+	if !strings.HasPrefix(text, "---") {
+		return nil, text
+	}
+	header, body, ok := strings.Cut(strings.TrimPrefix(text, "---"), "\n---")
+	if !ok {
+		return nil, text
+	}
+	front = make(map[string]string)
+	for _, line := range strings.Split(header, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok {
+			front[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return front, body
+}
+
+// withProjectRules prepends rules to the prompt text sent to cursor-agent.
+func withProjectRules(rules, prompt string) string {
+	if rules == "" {
+		return prompt
+	}
+	return "<project_rules>\n" + rules + "\n</project_rules>\n\n" + prompt
+}
@@ -0,0 +1,81 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func writeRulesFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRulesCacheLoadsProjectRules(t *testing.T) {
+	dir := t.TempDir()
+	writeRulesFile(t, filepath.Join(dir, ".cursorrules"), "Use tabs.\n")
+	writeRulesFile(t, filepath.Join(dir, ".cursor", "rules", "always.mdc"), "---\ndescription: Style\nalwaysApply: true\n---\nPrefer small functions.\n")
+	writeRulesFile(t, filepath.Join(dir, ".cursor", "rules", "react.mdc"), "---\ndescription: React conventions\nglobs: src/**/*.tsx\nalwaysApply: false\n---\nUse hooks.\n")
+	writeRulesFile(t, filepath.Join(dir, "AGENTS.md"), "# Agents\nRun make test.\n")
+
+	text, files := newRulesCache().load(dir, 0)
+	want := "## .cursorrules\n\nUse tabs.\n\n" +
+		"## .cursor/rules/always.mdc\n\nPrefer small functions.\n\n" +
+		"## AGENTS.md\n\n# Agents\nRun make test.\n\n" +
+		"## Other rules (read the file when relevant)\n\n- .cursor/rules/react.mdc: React conventions (applies to src/**/*.tsx)"
+	if text != want {
+		t.Fatalf("unexpected rules text:\n%s", text)
+	}
+	if strings.Join(files, ",") != ".cursorrules,.cursor/rules/always.mdc,.cursor/rules/react.mdc,AGENTS.md" {
+		t.Fatalf("files = %v", files)
+	}
+}
+
+func TestRulesCacheTruncatesAndReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "AGENTS.md")
+	writeRulesFile(t, path, strings.Repeat("x", 100))
+	cache := newRulesCache()
+
+	text, _ := cache.load(dir, 40)
+	if !strings.HasPrefix(text, "## AGENTS.md\n\nxxx") || !strings.HasSuffix(text, "[project rules truncated: 74 bytes omitted]") {
+		t.Fatalf("unexpected truncated rules:\n%s", text)
+	}
+
+	writeRulesFile(t, path, "short")
+	_ = os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if text, _ := cache.load(dir, 40); text != "## AGENTS.md\n\nshort" {
+		t.Fatalf("rules not reloaded after change: %q", text)
+	}
+
+	if text, files := cache.load(t.TempDir(), 40); text != "" || files != nil {
+		t.Fatalf("expected no rules for an empty directory, got %q %v", text, files)
+	}
+}
+
+func TestProjectRulesEnabledMetadataOverrides(t *testing.T) {
+	h := newPromptTestHandler(nil)
+	h.SetPromptConfig(config.PromptConfig{ProjectRules: true})
+
+	if !h.projectRulesEnabled(map[string]any{}, map[string]any{}) {
+		t.Fatal("expected rules enabled by config")
+	}
+	if h.projectRulesEnabled(map[string]any{"projectRules": false}, map[string]any{}) {
+		t.Fatal("expected session metadata to opt out")
+	}
+	if !h.projectRulesEnabled(map[string]any{"projectRules": false}, map[string]any{"projectRules": true}) {
+		t.Fatal("expected prompt metadata to win over session metadata")
+	}
+	if got := withProjectRules("R", "hello"); got != "<project_rules>\nR\n</project_rules>\n\nhello" {
+		t.Fatalf("withProjectRules = %q", got)
+	}
+}
@@ -0,0 +1,26 @@
+package prompt
+
+import "strings"
+
+// metadataSystemPrefix is the session or prompt metadata key that replaces
+// prompt.systemPrefix for a session; an empty string turns it off.
+const metadataSystemPrefix = "systemPrefix"
+
+// systemPrefix is the text prepended to every prompt of the session: the
+// prompt metadata override, else the session's, else prompt.systemPrefix.
+func (h *Handler) systemPrefix(sessionMetadata, promptMetadata map[string]any) string {
+	if v, ok := promptMetadata[metadataSystemPrefix].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	if v, ok := sessionMetadata[metadataSystemPrefix].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(h.promptConfig.SystemPrefix)
+}
+
+func withSystemPrefix(prefix, prompt string) string {
+	if prefix == "" {
+		return prompt
+	}
+	return "<system_instructions>\n" + prefix + "\n</system_instructions>\n\n" + prompt
+}
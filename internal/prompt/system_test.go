@@ -0,0 +1,28 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestSystemPrefixMetadataOverrides(t *testing.T) {
+	h := newPromptTestHandler(nil)
+	h.SetPromptConfig(config.PromptConfig{SystemPrefix: "  Use tabs.\n"})
+
+	if got := h.systemPrefix(map[string]any{}, map[string]any{}); got != "Use tabs." {
+		t.Fatalf("expected the configured prefix, got %q", got)
+	}
+	if got := h.systemPrefix(map[string]any{"systemPrefix": "Be terse."}, map[string]any{}); got != "Be terse." {
+		t.Fatalf("expected the session override, got %q", got)
+	}
+	if got := h.systemPrefix(map[string]any{"systemPrefix": "Be terse."}, map[string]any{"systemPrefix": ""}); got != "" {
+		t.Fatalf("expected prompt metadata to turn the prefix off, got %q", got)
+	}
+	if got := withSystemPrefix("P", "hello"); got != "<system_instructions>\nP\n</system_instructions>\n\nhello" {
+		t.Fatalf("withSystemPrefix = %q", got)
+	}
+	if got := withSystemPrefix("", "hello"); got != "hello" {
+		t.Fatalf("withSystemPrefix without a prefix = %q", got)
+	}
+}
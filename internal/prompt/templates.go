@@ -0,0 +1,221 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// templateCommand expands prompt templates: "/template list" shows them and
+// "/template <name> key=value..." replaces the command with the template.
+const templateCommand = "template"
+
+// templateInputVariable receives the words of a /template command that are
+// not key=value pairs.
+const templateInputVariable = "input"
+
+var templateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// promptTemplate is a template from prompt.templates or from the project.
+type promptTemplate struct {
+	config.PromptTemplate
+	project bool
+}
+
+// variables lists the distinct {{variables}} of the template in order of
+// first use.
+func (t promptTemplate) variables() []string {
+	var names []string
+	for _, m := range templateVariablePattern.FindAllStringSubmatch(t.Text, -1) {
+		if !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// loadTemplates merges the configured templates with the project's
+// .cursor/templates/<name>.md files, which may start with front matter
+// giving a description.
+func loadTemplates(cwd string, configured map[string]config.PromptTemplate) map[string]promptTemplate {
+	templates := make(map[string]promptTemplate, len(configured))
+	for name, t := range configured {
+		templates[name] = promptTemplate{PromptTemplate: t}
+	}
+	if cwd == "" {
+		return templates
+	}
+	files, _ := filepath.Glob(filepath.Join(cwd, ".cursor", "templates", "*.md"))
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".md")
+		if name == "list" || strings.ContainsFunc(name, unicode.IsSpace) {
+			continue
+		}
+		t := promptTemplate{project: true}
+		front, body := splitFrontMatter(strings.TrimSpace(string(data)))
+		t.Description = front["description"]
+		t.Text = strings.TrimSpace(body)
+		if t.Text != "" {
+			templates[name] = t
+		}
+	}
+	return templates
+}
+
+// parseTemplateArgs splits /template input into key=value pairs, values
+// optionally in double or single quotes. Other words are joined into the
+// input variable.
+func parseTemplateArgs(input string) map[string]string {
+	args := map[string]string{}
+	var loose []string
+	for _, word := range splitQuoted(input) {
+		key, value, ok := strings.Cut(word, "=")
+		if ok && key != "" && !strings.ContainsAny(key, `"'`) {
+			args[key] = unquote(value)
+			continue
+		}
+		loose = append(loose, unquote(word))
+	}
+	if len(loose) > 0 {
+		if _, ok := args[templateInputVariable]; !ok {
+			args[templateInputVariable] = strings.Join(loose, " ")
+		}
+	}
+	return args
+}
+
+// splitQuoted splits s at whitespace outside quotes. An unterminated quote
+// runs to the end.
+func splitQuoted(s string) []string {
+	var words []string
+	var b strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			b.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+			b.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			inWord = true
+			b.WriteRune(r)
+		}
+	}
+	if inWord {
+		words = append(words, b.String())
+	}
+	return words
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return strings.Trim(s, `"'`)
+}
+
+// expandTemplate fills the template's variables from args. It returns the
+// variables args has no value for instead when there are any.
+func expandTemplate(t promptTemplate, args map[string]string) (string, []string) {
+	var missing []string
+	for _, name := range t.variables() {
+		if _, ok := args[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", missing
+	}
+	return templateVariablePattern.ReplaceAllStringFunc(t.Text, func(m string) string {
+		return args[templateVariablePattern.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// templateList describes each template for /template list.
+func templateList(templates map[string]promptTemplate) string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		t := templates[name]
+		line := "- " + name
+		if vars := t.variables(); len(vars) > 0 {
+			line += " (" + strings.Join(vars, ", ") + ")"
+		}
+		if t.Description != "" {
+			line += ": " + t.Description
+		}
+		if t.project {
+			line += " [project]"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applyTemplateCommand handles a /template command in blocks. It returns
+// the blocks with the command replaced by the expanded template and the
+// template's name or, when nothing should be sent to cursor-agent (a
+// listing, or a command that could not be expanded), the reply for the user.
+func (h *Handler) applyTemplateCommand(cwd string, blocks []acp.ContentBlock) (expanded []acp.ContentBlock, name string, reply string) {
+	index, input, ok := findCommand(blocks, templateCommand)
+	if !ok {
+		return blocks, "", ""
+	}
+	templates := loadTemplates(cwd, h.promptConfig.Templates)
+	name, rest := input, ""
+	if i := strings.IndexFunc(input, unicode.IsSpace); i >= 0 {
+		name, rest = input[:i], input[i+1:]
+	}
+	switch {
+	case name == "":
+		return nil, "", h.messages.T("command.template.usage")
+	case name == "list":
+		if len(templates) == 0 {
+			return nil, "", h.messages.T("command.template.none")
+		}
+		return nil, "", h.messages.T("command.template.list", templateList(templates))
+	}
+	t, ok := templates[name]
+	if !ok {
+		names := make([]string, 0, len(templates))
+		for n := range templates {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, "", h.messages.T("command.template.unknown", name, strings.Join(names, ", "))
+	}
+	text, missing := expandTemplate(t, parseTemplateArgs(rest))
+	if len(missing) > 0 {
+		return nil, "", h.messages.T("command.template.missing", name, strings.Join(missing, ", "))
+	}
+	expanded = slices.Clone(blocks)
+	expanded[index].Text = text
+	return expanded, name, ""
+}
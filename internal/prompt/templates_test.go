@@ -0,0 +1,57 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+func TestTemplateCommandExpandsTemplates(t *testing.T) {
+	cwd := t.TempDir()
+	dir := filepath.Join(cwd, ".cursor", "templates")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	project := "---\ndescription: Project review\n---\nReview {{file}} for {{ concern }}."
+	if err := os.WriteFile(filepath.Join(dir, "review.md"), []byte(project), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newPromptTestHandler(nil)
+	h.SetPromptConfig(config.PromptConfig{Templates: map[string]config.PromptTemplate{
+		"review":  {Text: "overridden by the project"},
+		"explain": {Description: "Explain code", Text: "Explain this: {{input}}"},
+	}})
+	run := func(text string) ([]acp.ContentBlock, string, string) {
+		blocks := []acp.ContentBlock{{Type: "image", Data: "x"}, {Type: "text", Text: text}}
+		return h.applyTemplateCommand(cwd, blocks)
+	}
+
+	blocks, name, reply := run(`/template review file=main.go concern="error handling"`)
+	if reply != "" || name != "review" || blocks[1].Text != "Review main.go for error handling." || blocks[0].Type != "image" {
+		t.Fatalf("unexpected expansion %+v name=%q reply=%q", blocks, name, reply)
+	}
+	if blocks, _, _ = run("/template explain the retry loop"); blocks[1].Text != "Explain this: the retry loop" {
+		t.Fatalf("expected loose words to fill {{input}}, got %q", blocks[1].Text)
+	}
+
+	for text, want := range map[string]string{
+		"/template list":             "- explain (input): Explain code\n- review (file, concern): Project review [project]",
+		"/template":                  "Usage: /template list",
+		"/template nope":             "Unknown template 'nope'. Available templates: explain, review",
+		"/template review file=a.go": "needs values for: concern",
+	} {
+		if blocks, _, reply := run(text); blocks != nil || !strings.Contains(reply, want) {
+			t.Errorf("%s: expected a reply containing %q, got %q", text, want, reply)
+		}
+	}
+
+	plain := []acp.ContentBlock{{Type: "text", Text: "/model gpt-5"}}
+	if blocks, name, reply := h.applyTemplateCommand(cwd, plain); name != "" || reply != "" || blocks[0].Text != "/model gpt-5" {
+		t.Fatalf("expected other commands to be left alone, got %+v %q %q", blocks, name, reply)
+	}
+}
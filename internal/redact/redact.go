@@ -0,0 +1,140 @@
+// Package redact masks secrets in values before they leave the adapter in
+// logs, transcripts or tool_call notifications.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// secretKeys are substrings of object keys whose values are always masked.
+var secretKeys = []string{"password", "passwd", "secret", "apikey", "api_key", "authorization", "credential", "privatekey", "private_key", "cookie"}
+
+// DefaultPatterns match common credentials wherever they appear in text.
+var DefaultPatterns = []string{
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,                                   // AWS access key ID
+	`(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`, // AWS secret access key
+	`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]{8,}=*`,                         // bearer tokens
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,                                  // GitHub tokens
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,                                // GitHub fine-grained tokens
+	`\bsk-[A-Za-z0-9_\-]{20,}`,                                        // OpenAI / Anthropic style API keys
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,                                  // Slack tokens
+	`\bAIza[0-9A-Za-z\-_]{35}\b`,                                      // Google API keys
+	`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,    // JWTs
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// IsSecretKey reports whether values under key should never be shown. Keys
+// ending in "env", such as apiKeyEnv, hold the name of an environment
+// variable rather than the secret itself and are left alone.
+func IsSecretKey(key string) bool {
+	k := strings.ToLower(key)
+	if strings.HasSuffix(k, "env") {
+		return false
+	}
+	// "token" but not token counts such as maxTokens or inputTokens.
+	if strings.Contains(k, "token") && !strings.HasSuffix(k, "tokens") {
+		return true
+	}
+	for _, s := range secretKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor masks secret-looking keys and text matching its patterns. A nil
+// *Redactor masks secret keys only.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// New compiles cfg.Patterns, plus DefaultPatterns unless disabled. It
+// returns nil when redaction is disabled.
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	sources := cfg.Patterns
+	if !cfg.DisableDefaults {
+		sources = append(append([]string{}, DefaultPatterns...), cfg.Patterns...)
+	}
+	r := &Redactor{}
+	for _, src := range sources {
+		re, err := regexp.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", src, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// AddValues masks each non-empty value verbatim, for secrets known only at
+// run time such as the value of the web search apiKeyEnv variable.
+func (r *Redactor) AddValues(values ...string) {
+	if r == nil {
+		return
+	}
+	for _, v := range values {
+		if v != "" {
+			r.patterns = append(r.patterns, regexp.MustCompile(regexp.QuoteMeta(v)))
+		}
+	}
+}
+
+// String masks every pattern match in s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// Value returns a redacted copy of v. Values that are not JSON-shaped
+// (structs, typed slices, ...) are converted through JSON first.
+func (r *Redactor) Value(v any) any {
+	switch val := v.(type) {
+	case nil, bool, float64, int, int64:
+		return v
+	case string:
+		return r.String(val)
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			if IsSecretKey(k) {
+				out[k] = Mask
+				continue
+			}
+			out[k] = r.Value(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = r.Value(item)
+		}
+		return out
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var decoded any
+		if json.Unmarshal(buf, &decoded) != nil {
+			return v
+		}
+		return r.Value(decoded)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spjoes/cursor-agent-acp/internal/artifact"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
+)
+
+// artifactPageBytes is how much of an artifact _artifacts/get returns when
+// the client gives no limit.
+const artifactPageBytes = 1024 * 1024
+
+// getArtifact backs _artifacts/get: the artifact named by "uri", or by
+// "sessionId" and "artifactId", read from "offset" for up to "limit" bytes.
+// Text artifacts are returned in text and others base64 encoded in blob;
+// nextOffset continues a partial read.
+func (s *Server) getArtifact(params map[string]any) (map[string]any, error) {
+	sessionID, _ := params["sessionId"].(string)
+	id, _ := params["artifactId"].(string)
+	if uri, _ := params["uri"].(string); uri != "" {
+		var ok bool
+		if sessionID, id, ok = artifact.ParseURI(uri); !ok {
+			return nil, errcode.New(errcode.InvalidParams, "uri must be an %s:// URI: %s", artifact.URIScheme, uri)
+		}
+	}
+	if sessionID == "" || id == "" {
+		return nil, errcode.New(errcode.InvalidParams, "uri, or sessionId and artifactId, are required")
+	}
+	offset, _ := params["offset"].(float64)
+	limit, ok := params["limit"].(float64)
+	if !ok {
+		limit = artifactPageBytes
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errcode.New(errcode.InvalidParams, "offset and limit must not be negative")
+	}
+
+	a, data, err := s.artifacts.Get(sessionID, id, int64(offset), int64(limit))
+	if errors.Is(err, artifact.ErrNotFound) {
+		return nil, errcode.New(errcode.InvalidParams, "Artifact not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := min(int64(offset), a.Size)
+	result := map[string]any{"artifact": a, "uri": a.URI(), "offset": start}
+	if textMimeType(a.MimeType) {
+		// A page may end inside a character; leave it for the next page.
+		for cut := 0; start+int64(len(data)) < a.Size && cut < utf8.UTFMax && cut < len(data); cut++ {
+			if utf8.Valid(data[:len(data)-cut]) {
+				data = data[:len(data)-cut]
+				break
+			}
+		}
+		result["text"] = string(data)
+	} else {
+		result["blob"] = base64.StdEncoding.EncodeToString(data)
+	}
+	next := start + int64(len(data))
+	result["nextOffset"] = next
+	result["complete"] = next >= a.Size
+	return result, nil
+}
+
+func textMimeType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/json"
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/spjoes/cursor-agent-acp/internal/clientcaps"
+	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
+	"github.com/spjoes/cursor-agent-acp/internal/wirelog"
+)
+
+// connection is one client attached to the server: the stdio client, or one
+// of several clients served through Serve that share the same sessions. It
+// owns the client's capabilities and the agent-to-client requests waiting
+// for its answers.
+type connection struct {
+	id    string
+	write func(buf []byte) error
+	caps  *clientcaps.Capabilities
+	// requests spots retried requests.
+	requests *requestLog
+
+	pendingMu sync.Mutex
+	pending   map[string]chan clientRPCResponse
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// unsubscribed holds the sessions the client left with
+	// session/unsubscribe; requests naming them no longer attach it.
+	// Guarded by Server.connsMu.
+	unsubscribed map[string]bool
+
+	// lost is closed when a write to the client fails; lostErr is why.
+	lost     chan struct{}
+	lostOnce sync.Once
+	lostErr  error
+
+	// outbox holds the messages queued for the client, in order, and
+	// outboxBytes their size; flushing is set while a goroutine writes them
+	// out. Messages are queued under whatever lock orders them and written
+	// after it is released. Guarded by outMu.
+	outMu       sync.Mutex
+	outbox      [][]byte
+	outboxBytes int
+	flushing    bool
+}
+
+// maxOutboxBytes bounds the messages queued for one client. A client that
+// falls this far behind is dropped rather than buffered without limit.
+const maxOutboxBytes = 64 << 20
+
+func newConnection(id string, write func([]byte) error) *connection {
+	return &connection{
+		id:           id,
+		write:        write,
+		caps:         clientcaps.New(),
+		requests:     newRequestLog(),
+		pending:      map[string]chan clientRPCResponse{},
+		unsubscribed: map[string]bool{},
+		closed:       make(chan struct{}),
+		lost:         make(chan struct{}),
+	}
+}
+
+func (c *connection) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// markLost records that the client can no longer be written to. It reports
+// whether this was the first failure.
+func (c *connection) markLost(err error) bool {
+	first := false
+	c.lostOnce.Do(func() {
+		c.lostErr = err
+		close(c.lost)
+		first = true
+	})
+	return first
+}
+
+func (c *connection) isLost() bool {
+	select {
+	case <-c.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// isBrokenPipe reports whether err means the reading end of the client's
+// stream is gone.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed)
+}
+
+type connectionKey struct{}
+
+func withConnection(ctx context.Context, c *connection) context.Context {
+	return context.WithValue(ctx, connectionKey{}, c)
+}
+
+// connFor returns the connection a request arrived on, or the stdio
+// connection for work that did not come from a client request.
+func (s *Server) connFor(ctx context.Context) *connection {
+	if ctx != nil {
+		if c, ok := ctx.Value(connectionKey{}).(*connection); ok {
+			return c
+		}
+	}
+	return s.stdioConn
+}
+
+// attachSession routes sessionID's notifications to c as well as any other
+// connection already using it, unless c unsubscribed from it.
+func (s *Server) attachSession(sessionID string, c *connection) {
+	if sessionID == "" || c == nil {
+		return
+	}
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if c.unsubscribed[sessionID] {
+		return
+	}
+	s.attachSessionLocked(sessionID, c)
+}
+
+func (s *Server) attachSessionLocked(sessionID string, c *connection) {
+	delete(s.orphaned, sessionID)
+	conns := slices.DeleteFunc(s.sessionConns[sessionID], func(other *connection) bool { return other == c })
+	s.sessionConns[sessionID] = append(conns, c)
+}
+
+// claimSession makes c the connection that answers sessionID's client
+// requests (permission, fs and terminal), as the client that created,
+// loaded or prompted it.
+func (s *Server) claimSession(sessionID string, c *connection) {
+	if sessionID == "" || c == nil {
+		return
+	}
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	s.sessionOwners[sessionID] = c
+}
+
+// subscribeSession attaches c to sessionID, undoing an earlier
+// unsubscribeSession. c receives the session's updates but does not answer
+// its client requests.
+func (s *Server) subscribeSession(sessionID string, c *connection) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(c.unsubscribed, sessionID)
+	s.attachSessionLocked(sessionID, c)
+}
+
+// unsubscribeSession detaches c from sessionID and keeps later requests
+// naming the session from attaching it again. It reports whether c was
+// attached.
+func (s *Server) unsubscribeSession(sessionID string, c *connection) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	c.unsubscribed[sessionID] = true
+	conns := s.sessionConns[sessionID]
+	attached := slices.Contains(conns, c)
+	conns = slices.DeleteFunc(conns, func(other *connection) bool { return other == c })
+	if len(conns) == 0 {
+		delete(s.sessionConns, sessionID)
+	} else {
+		s.sessionConns[sessionID] = conns
+	}
+	if s.sessionOwners[sessionID] == c {
+		delete(s.sessionOwners, sessionID)
+	}
+	return attached
+}
+
+// forgetSessionConns drops every connection's ties to the deleted
+// sessionID.
+func (s *Server) forgetSessionConns(sessionID string) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.sessionConns, sessionID)
+	delete(s.sessionOwners, sessionID)
+	delete(s.orphaned, sessionID)
+	delete(s.stdioConn.unsubscribed, sessionID)
+	for _, c := range s.conns {
+		delete(c.unsubscribed, sessionID)
+	}
+}
+
+// sessionConnections lists the connections attached to sessionID, falling
+// back to the stdio connection unless it unsubscribed. A session whose
+// clients all disconnected falls back only with stdioFallback; otherwise its
+// updates are dropped. Either way the first one is logged.
+func (s *Server) sessionConnections(sessionID string) []*connection {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if conns := s.sessionConns[sessionID]; len(conns) > 0 {
+		return slices.Clone(conns)
+	}
+	if warned, ok := s.orphaned[sessionID]; ok {
+		if !warned {
+			s.orphaned[sessionID] = true
+			if s.stdioFallback {
+				s.logger.Warn("The session's clients disconnected, sending its updates to the stdio client", map[string]any{"sessionId": sessionID})
+			} else {
+				s.logger.Warn("The session's clients disconnected, dropping its updates (stdioFallback is off)", map[string]any{"sessionId": sessionID})
+			}
+		}
+		if !s.stdioFallback {
+			return nil
+		}
+	}
+	if s.stdioConn.unsubscribed[sessionID] {
+		return nil
+	}
+	return []*connection{s.stdioConn}
+}
+
+// sessionWantsEcho reports whether prompts in sessionID should be echoed:
+// prompt.echoUserMessages is on or a client of the session asked for echoes.
+func (s *Server) sessionWantsEcho(sessionID string) bool {
+	if s.echoAll {
+		return true
+	}
+	return slices.ContainsFunc(s.sessionConnections(sessionID), func(c *connection) bool {
+		return c.caps.EchoUserMessages()
+	})
+}
+
+// isEcho reports whether a user_message_chunk echoes the prompt rather than
+// replaying the session.
+func isEcho(update map[string]any) bool {
+	meta, _ := update["_meta"].(map[string]any)
+	return meta["echo"] == true
+}
+
+// allConnections lists the stdio connection and every connection served
+// through Serve.
+func (s *Server) allConnections() []*connection {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns := []*connection{s.stdioConn}
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// clientConnFor picks the connection that should answer a client request for
+// sessionID: the one that last created, loaded or prompted the session, else
+// the one the request came in on. Subscribers never answer.
+func (s *Server) clientConnFor(ctx context.Context, sessionID string) *connection {
+	if sessionID != "" {
+		s.connsMu.Lock()
+		owner := s.sessionOwners[sessionID]
+		s.connsMu.Unlock()
+		if owner != nil {
+			return owner
+		}
+	}
+	return s.connFor(ctx)
+}
+
+// Serve handles one client on r/w alongside the stdio client, e.g. for a
+// socket transport accepting several editors. Sessions are shared between
+// clients; each client gets the updates for the sessions it creates, loads
+// or prompts. Serve returns when r is exhausted or ctx is done.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	var mu sync.Mutex
+	c := newConnection(fmt.Sprintf("conn_%d", atomic.AddUint64(&s.connSeq, 1)), func(buf []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := w.Write(buf)
+		return err
+	})
+	s.connsMu.Lock()
+	s.conns[c.id] = c
+	s.connsMu.Unlock()
+	s.logger.Info("Client connected", map[string]any{"connection": c.id})
+	defer s.disconnect(c)
+	return s.serveConnection(ctx, c, r)
+}
+
+// disconnect detaches c from every session, fails its pending requests and
+// cancels the prompts of sessions no other client is using.
+func (s *Server) disconnect(c *connection) {
+	c.close()
+	var orphaned []string
+	s.connsMu.Lock()
+	delete(s.conns, c.id)
+	for sessionID, conns := range s.sessionConns {
+		conns = slices.DeleteFunc(conns, func(other *connection) bool { return other == c })
+		if len(conns) == 0 {
+			delete(s.sessionConns, sessionID)
+			s.orphaned[sessionID] = false
+			orphaned = append(orphaned, sessionID)
+		} else {
+			s.sessionConns[sessionID] = conns
+		}
+	}
+	for sessionID, owner := range s.sessionOwners {
+		if owner == c {
+			delete(s.sessionOwners, sessionID)
+		}
+	}
+	for terminalID, owner := range s.terminalConns {
+		if owner == c {
+			delete(s.terminalConns, terminalID)
+		}
+	}
+	s.connsMu.Unlock()
+	if s.prompt != nil {
+		active := s.prompt.ActiveSessions()
+		for _, sessionID := range orphaned {
+			if !slices.Contains(active, sessionID) {
+				continue
+			}
+			s.logger.Info("Cancelling the prompt of a disconnected client", map[string]any{"connection": c.id, "sessionId": sessionID})
+			s.prompt.CancelSession(sessionID)
+			s.permissions.CancelSessionPermissionRequests(sessionID)
+			s.toolCalls.CancelSessionToolCalls(sessionID)
+		}
+	}
+	s.logger.Info("Client disconnected", map[string]any{"connection": c.id})
+}
+
+// serveConnection reads requests from r until it is exhausted, ctx is done
+// or writing to c fails, in which case it returns an error wrapping the
+// write failure.
+func (s *Server) serveConnection(ctx context.Context, c *connection, r io.Reader) error {
+	lines := make(chan []byte)
+	oversized := make(chan *messageTooLargeError)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		reader := bufio.NewReaderSize(r, 64*1024)
+		for {
+			line, err := readMessage(reader, s.Config().MaxMessageBytes)
+			var tooLarge *messageTooLargeError
+			if errors.As(err, &tooLarge) {
+				select {
+				case oversized <- tooLarge:
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				}
+				continue
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	connCtx := withConnection(ctx, c)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.lost:
+			return fmt.Errorf("client connection lost: %w", c.lostErr)
+		case err := <-readErr:
+			if err == nil {
+				s.logger.Info("Client input closed", map[string]any{"connection": c.id})
+			}
+			return err
+		case line := <-lines:
+			s.handleLine(connCtx, line)
+		case tooLarge := <-oversized:
+			s.logger.Warn("Skipping an oversized message", map[string]any{"connection": c.id, "bytes": tooLarge.size, "maxMessageBytes": tooLarge.limit})
+			s.writeMessageTo(c, jsonrpc.Failure(tooLarge.id, jsonrpc.ParseError, "Parse error", map[string]any{"error": tooLarge.Error(), "maxMessageBytes": tooLarge.limit}))
+		}
+	}
+}
+
+// messageTooLargeError is a message longer than maxMessageBytes. id is the
+// request ID when the start of the message gave it.
+type messageTooLargeError struct {
+	size  int64
+	limit int64
+	id    any
+}
+
+func (e *messageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds maxMessageBytes (%d)", e.size, e.limit)
+}
+
+// messageHeadBytes is how much of an oversized message is kept to find its ID.
+const messageHeadBytes = 4096
+
+// readMessage reads one newline-terminated message, or the unterminated last
+// one, from r; io.EOF means there are no more. A message of more than limit
+// bytes (0 for no limit) is read to its end without being kept and reported
+// as a *messageTooLargeError, so the next call starts at the next message.
+func readMessage(r *bufio.Reader, limit int64) ([]byte, error) {
+	var buf []byte
+	var size int64
+	newline := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		size += int64(len(chunk))
+		newline = len(chunk) > 0 && chunk[len(chunk)-1] == '\n'
+		switch {
+		case limit <= 0 || size <= limit+1:
+			buf = append(buf, chunk...)
+		case len(buf) < messageHeadBytes:
+			buf = append(buf, chunk[:min(len(chunk), messageHeadBytes-len(buf))]...)
+		case cap(buf) > 2*messageHeadBytes:
+			buf = slices.Clone(buf[:messageHeadBytes])
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || size == 0) {
+			return nil, err
+		}
+		break
+	}
+	if newline {
+		size--
+	}
+	if limit > 0 && size > limit {
+		return nil, &messageTooLargeError{size: size, limit: limit, id: leadingRequestID(buf)}
+	}
+	return buf[:size], nil
+}
+
+// leadingRequestID finds the "id" member of a JSON-RPC request in the start
+// of a message, provided it comes before "params", where an "id" could
+// belong to something else.
+func leadingRequestID(head []byte) any {
+	head = head[:min(len(head), messageHeadBytes)]
+	if i := bytes.Index(head, []byte(`"params"`)); i >= 0 {
+		head = head[:i]
+	}
+	match := requestIDPattern.FindSubmatch(head)
+	if match == nil {
+		return nil
+	}
+	var id any
+	if json.Unmarshal(match[1], &id) != nil {
+		return nil
+	}
+	return id
+}
+
+var requestIDPattern = regexp.MustCompile(`"id"\s*:\s*("(?:[^"\\]|\\.)*"|-?\d+)`)
+
+// writeTo sends one JSON-RPC message to c. A failed write means the client
+// is gone: c is marked lost, which ends serveConnection, and later messages
+// are dropped.
+func (s *Server) writeTo(c *connection, buf []byte) {
+	if s.enqueue(c, buf) {
+		s.flush(c)
+	}
+}
+
+// enqueue adds buf to c's outbox without writing it, reporting whether it
+// was queued. It never blocks on the client, so callers may hold a lock that
+// orders their messages; they call flush once it is released.
+func (s *Server) enqueue(c *connection, buf []byte) bool {
+	if c.isLost() {
+		return false
+	}
+	c.outMu.Lock()
+	if queued := c.outboxBytes; queued+len(buf) > maxOutboxBytes {
+		c.outMu.Unlock()
+		if c.markLost(errors.New("client is not reading its messages")) {
+			s.logger.Error("Client fell too far behind, dropping the connection", map[string]any{"connection": c.id, "queuedBytes": queued})
+		}
+		return false
+	}
+	c.outbox = append(c.outbox, buf)
+	c.outboxBytes += len(buf)
+	c.outMu.Unlock()
+	return true
+}
+
+// flush writes out c's outbox. When another goroutine is already flushing
+// it, that one writes the new messages too and flush returns at once.
+func (s *Server) flush(c *connection) {
+	c.outMu.Lock()
+	if c.flushing {
+		c.outMu.Unlock()
+		return
+	}
+	c.flushing = true
+	for len(c.outbox) > 0 {
+		batch := c.outbox
+		c.outbox = nil
+		c.outboxBytes = 0
+		c.outMu.Unlock()
+		for _, buf := range batch {
+			if c.isLost() {
+				break
+			}
+			s.wireLog.Record(wirelog.Outbound, buf)
+			// buf may be queued to other clients too; the full slice
+			// expression makes append copy it rather than write past it.
+			if err := c.write(append(buf[:len(buf):len(buf)], '\n')); err != nil && c.markLost(err) {
+				s.logger.Error("Failed to write to client, dropping the connection", map[string]any{"connection": c.id, "error": err.Error(), "brokenPipe": isBrokenPipe(err)})
+			}
+		}
+		c.outMu.Lock()
+	}
+	c.flushing = false
+	c.outMu.Unlock()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
+	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
+)
+
+const (
+	// recentRequestLimit bounds the answered requests remembered per
+	// connection for retries.
+	recentRequestLimit = 256
+	// recentRequestTTL is how long a retry gets the remembered answer
+	// instead of running the request again.
+	recentRequestTTL = 5 * time.Minute
+)
+
+// requestLog remembers a connection's recent requests so a client retrying
+// one with the same ID, method and params (e.g. after a timeout) does not
+// run it twice. Each connection has its own log, so clients never see each
+// other's answers. Failed requests are forgotten: a retry runs them again.
+type requestLog struct {
+	mu      sync.Mutex
+	entries map[string]*loggedRequest
+	order   []string
+}
+
+type loggedRequest struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	resp        jsonrpc.Response
+	finishedAt  time.Time
+}
+
+func newRequestLog() *requestLog {
+	return &requestLog{entries: map[string]*loggedRequest{}}
+}
+
+// requestKey identifies req within its connection by method and ID. It
+// distinguishes the ID 1 from the ID "1".
+func requestKey(req jsonrpc.Request) string {
+	return fmt.Sprintf("%s\x00%T:%v", req.Method, req.ID, req.ID)
+}
+
+// begin records req as running. When it repeats a request that is still
+// running or was recently answered, begin returns the response to send
+// instead and ok is false.
+func (l *requestLog) begin(req jsonrpc.Request) (resp jsonrpc.Response, ok bool) {
+	key := requestKey(req)
+	fingerprint := requestFingerprint(req)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if entry, seen := l.entries[key]; seen && entry.fingerprint == fingerprint {
+		if !entry.done {
+			return jsonrpc.Failure(req.ID, jsonrpc.RequestInProgress, "request already in progress", map[string]any{"id": req.ID, "method": req.Method, "code": string(errcode.RequestInProgress)}), false
+		}
+		if time.Since(entry.finishedAt) < recentRequestTTL {
+			return entry.resp, false
+		}
+	}
+	if _, seen := l.entries[key]; !seen {
+		l.order = append(l.order, key)
+	}
+	l.entries[key] = &loggedRequest{fingerprint: fingerprint}
+	l.evict()
+	return jsonrpc.Response{}, true
+}
+
+// requestFingerprint identifies the method and params of req without
+// keeping a copy of params, which can be megabytes.
+func requestFingerprint(req jsonrpc.Request) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte{0})
+	h.Write(req.Params)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// finish stores the response to req for retries, or forgets req when it
+// failed.
+func (l *requestLog) finish(req jsonrpc.Request, resp jsonrpc.Response) {
+	key := requestKey(req)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	if resp.Error != nil {
+		delete(l.entries, key)
+		l.order = slices.DeleteFunc(l.order, func(k string) bool { return k == key })
+		return
+	}
+	entry.done, entry.resp, entry.finishedAt = true, resp, time.Now()
+}
+
+// evict drops the oldest answered requests beyond recentRequestLimit.
+// Running requests are kept however many there are.
+func (l *requestLog) evict() {
+	for i := 0; len(l.entries) > recentRequestLimit && i < len(l.order); {
+		key := l.order[i]
+		if entry := l.entries[key]; entry != nil && !entry.done {
+			i++
+			continue
+		}
+		delete(l.entries, key)
+		l.order = append(l.order[:i], l.order[i+1:]...)
+	}
+}
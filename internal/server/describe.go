@@ -0,0 +1,369 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/audit"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+type methodDescriptor struct {
+	Method      string         `json:"method"`
+	Kind        string         `json:"kind"`
+	Description string         `json:"description"`
+	Params      map[string]any `json:"params,omitempty"`
+}
+
+type notificationDescriptor struct {
+	Method      string   `json:"method"`
+	Description string   `json:"description"`
+	Updates     []string `json:"updates,omitempty"`
+}
+
+func objectSchema(required []string, properties map[string]any) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func prop(typ string, description string) map[string]any {
+	return map[string]any{"type": typ, "description": description}
+}
+
+// agentMethods lists the JSON-RPC methods a client may call on the adapter.
+// Keep in sync with the dispatch switch in processRequest.
+var agentMethods = []methodDescriptor{
+	{
+		Method:      "initialize",
+		Kind:        "request",
+		Description: "Negotiate protocol version and exchange capabilities",
+		Params: objectSchema([]string{"protocolVersion"}, map[string]any{
+			"protocolVersion":    prop("integer", "ACP protocol version requested by the client"),
+			"clientCapabilities": prop("object", "Capabilities supported by the client (fs, terminal, ...)"),
+		}),
+	},
+	{
+		Method:      "session/new",
+		Kind:        "request",
+		Description: "Create a new session",
+		Params: objectSchema([]string{"cwd", "mcpServers"}, map[string]any{
+			"cwd":              prop("string", "Absolute working directory for the session"),
+			"mcpServers":       prop("array", "MCP server configurations (may be empty)"),
+			"workspaceFolders": prop("array", "Other absolute roots of a multi-root workspace"),
+			"metadata":         prop("object", "Initial session metadata (name, tags, mode, model, ...)"),
+		}),
+	},
+	{
+		Method:      "session/load",
+		Kind:        "request",
+		Description: "Load an existing session and replay its conversation as session/update notifications",
+		Params: objectSchema([]string{"sessionId", "cwd", "mcpServers"}, map[string]any{
+			"sessionId":        prop("string", "Session to load"),
+			"cwd":              prop("string", "Absolute working directory for the session"),
+			"mcpServers":       prop("array", "MCP server configurations (may be empty)"),
+			"workspaceFolders": prop("array", "Other absolute roots of a multi-root workspace"),
+		}),
+	},
+	{
+		Method:      "session/list",
+		Kind:        "request",
+		Description: "List persisted sessions with pagination and filtering",
+		Params: objectSchema(nil, map[string]any{
+			"limit":  prop("integer", "Maximum number of sessions to return (default 50)"),
+			"offset": prop("integer", "Number of sessions to skip"),
+			"filter": prop("object", "Filters: name, tags, status, model, cwd, createdAfter, createdBefore, updatedAfter, updatedBefore"),
+		}),
+	},
+	{
+		Method:      "session/update",
+		Kind:        "request",
+		Description: "Merge metadata into a session",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Session to update"),
+			"metadata":  prop("object", "Metadata keys to merge"),
+		}),
+	},
+	{
+		Method:      "session/delete",
+		Kind:        "request",
+		Description: "Delete a session and its persisted data; refused while a prompt or tool call is in flight unless forced",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Session to delete"),
+			"force":     prop("boolean", "Cancel a running prompt and tool calls and delete anyway"),
+		}),
+	},
+	{
+		Method:      "session/subscribe",
+		Kind:        "request",
+		Description: "Receive a session's updates on this connection, e.g. to follow a session another client runs",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Session to follow"),
+		}),
+	},
+	{
+		Method:      "session/unsubscribe",
+		Kind:        "request",
+		Description: "Stop a session's updates on this connection until it subscribes again",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Session to stop following"),
+		}),
+	},
+	{
+		Method:      "session/replay_updates",
+		Kind:        "request",
+		Description: "Return the recent session/update notifications numbered after sinceSequence, e.g. after reconnecting mid-turn; complete is false when some were already dropped",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId":     prop("string", "Target session"),
+			"sinceSequence": prop("integer", "Last _meta.sequence the client received (0 for all kept updates)"),
+		}),
+	},
+	{
+		Method:      "session/checkpoints",
+		Kind:        "request",
+		Description: "List the working-directory checkpoints taken before each prompt turn (newest first)",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+		}),
+	},
+	{
+		Method:      "session/restore_checkpoint",
+		Kind:        "request",
+		Description: "Revert the working directory to a checkpoint, undoing the changes made since",
+		Params: objectSchema([]string{"sessionId", "checkpointId"}, map[string]any{
+			"sessionId":    prop("string", "Target session"),
+			"checkpointId": prop("string", "Checkpoint to restore"),
+		}),
+	},
+	{
+		Method:      "session/set_mode",
+		Kind:        "request",
+		Description: "Switch the session mode (agent, plan, ask)",
+		Params: objectSchema([]string{"sessionId", "modeId"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"modeId":    prop("string", "Mode identifier"),
+		}),
+	},
+	{
+		Method:      "session/set_cwd",
+		Kind:        "request",
+		Description: "Move the session to another working directory for subsequent tool calls and prompts",
+		Params: objectSchema([]string{"sessionId", "cwd"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"cwd":       prop("string", "Absolute path of an existing directory"),
+		}),
+	},
+	{
+		Method:      "session/set_model",
+		Kind:        "request",
+		Description: "Switch the model used for subsequent prompts",
+		Params: objectSchema([]string{"sessionId", "modelId"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"modelId":   prop("string", "Model identifier"),
+		}),
+	},
+	{
+		Method:      "session/prompt",
+		Kind:        "request",
+		Description: "Send a user prompt; progress is streamed via session/update notifications",
+		Params: objectSchema([]string{"sessionId", "prompt"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"prompt":    prop("array", "Content blocks (text, image, audio, resource, resource_link)"),
+			"stream":    prop("boolean", "Stream agent output incrementally"),
+		}),
+	},
+	{
+		Method:      "session/cancel",
+		Kind:        "notification",
+		Description: "Cancel in-flight prompts, tool calls and permission requests for a session",
+		Params: objectSchema([]string{"sessionId"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"requestId": prop("string", "Optional prompt request to cancel"),
+		}),
+	},
+	{
+		Method:      "session/request_permission",
+		Kind:        "request",
+		Description: "Resolve a permission request for a tool call",
+		Params: objectSchema([]string{"sessionId", "toolCall", "options"}, map[string]any{
+			"sessionId": prop("string", "Target session"),
+			"toolCall":  prop("object", "Tool call awaiting permission"),
+			"options":   prop("array", "Permission options"),
+		}),
+	},
+	{
+		Method:      "tools/list",
+		Kind:        "request",
+		Description: "List available tools and their parameter schemas",
+	},
+	{
+		Method:      "tools/call",
+		Kind:        "request",
+		Description: "Execute a tool",
+		Params: objectSchema([]string{"name"}, map[string]any{
+			"name":       prop("string", "Tool name"),
+			"parameters": prop("object", "Tool parameters; include sessionId for session-scoped tools"),
+		}),
+	},
+}
+
+// clientMethods lists the JSON-RPC methods the adapter may call on the client.
+var clientMethods = []methodDescriptor{
+	{Method: "fs/read_text_file", Kind: "request", Description: "Read a text file from the client workspace (requires fs.readTextFile)"},
+	{Method: "fs/write_text_file", Kind: "request", Description: "Write a text file in the client workspace (requires fs.writeTextFile)"},
+	{Method: "fs/list_directory", Kind: "request", Description: "List a directory in the client workspace (requires fs.listDirectory; local fallback otherwise)"},
+	{Method: "session/request_permission", Kind: "request", Description: "Ask the user to approve a permission-gated tool call (e.g. git_commit)"},
+	{Method: "terminal/create", Kind: "request", Description: "Start a command in a client terminal (requires terminal)"},
+	{Method: "terminal/output", Kind: "request", Description: "Fetch terminal output"},
+	{Method: "terminal/wait_for_exit", Kind: "request", Description: "Wait for a terminal command to exit"},
+	{Method: "terminal/kill", Kind: "request", Description: "Kill a terminal command"},
+	{Method: "terminal/release", Kind: "request", Description: "Release terminal resources"},
+}
+
+var agentNotifications = []notificationDescriptor{
+	{
+		Method:      "session/update",
+		Description: "Session progress updates; the update kind is in update.sessionUpdate",
+		Updates: []string{
+			"user_message_chunk",
+			"agent_message_chunk",
+			"agent_thought_chunk",
+			"tool_call",
+			"tool_call_update",
+			"plan",
+			"available_commands_update",
+			"current_model_update",
+			"turn_started",
+			"turn_completed",
+		},
+	},
+	{
+		Method:      modelsUpdatedNotification,
+		Description: "The cursor-agent model list changed; carries the full list plus added and removed models",
+	},
+	{
+		Method:      configChangedNotification,
+		Description: "The config file changed; lists the settings applied live and those that need a restart",
+	},
+}
+
+func (s *Server) describe() map[string]any {
+	extensionMethods := s.extensions.RegisteredMethods()
+	sort.Strings(extensionMethods)
+	extensionNotifications := s.extensions.RegisteredNotifications()
+	sort.Strings(extensionNotifications)
+
+	return map[string]any{
+		"adapter": map[string]any{
+			"name":    AdapterName,
+			"title":   AdapterTitle,
+			"version": AdapterVersion,
+		},
+		"protocolVersions": []int{1},
+		"methods":          agentMethods,
+		"clientMethods":    clientMethods,
+		"notifications":    agentNotifications,
+		"tools":            s.tools.ToolDescriptors(),
+		"slashCommands":    s.slash.GetCommands(),
+		"extensions": map[string]any{
+			"methods":       extensionMethods,
+			"notifications": extensionNotifications,
+		},
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) registerBuiltinExtensions() {
+	_ = s.extensions.RegisterMethod("_adapter/describe", func(_ map[string]any) (map[string]any, error) {
+		return s.describe(), nil
+	})
+	_ = s.extensions.RegisterMethod("_adapter/status", func(_ map[string]any) (map[string]any, error) {
+		return s.adapterStatus(), nil
+	})
+	_ = s.extensions.RegisterMethod("_adapter/metrics", func(_ map[string]any) (map[string]any, error) {
+		return s.adapterMetrics(), nil
+	})
+	_ = s.extensions.RegisterMethod("_audit/list", s.listAuditEntries)
+	_ = s.extensions.RegisterMethod("_artifacts/get", s.getArtifact)
+	_ = s.extensions.RegisterMethod("_usage/summary", func(params map[string]any) (map[string]any, error) {
+		sessionID, _ := params["sessionId"].(string)
+		return s.sessions.UsageSummary(sessionID)
+	})
+	_ = s.extensions.RegisterMethod("_logging/set_level", s.setLogLevel)
+	_ = s.extensions.RegisterMethod("_cursor/refresh", func(_ map[string]any) (map[string]any, error) {
+		return s.refreshCursorState(), nil
+	})
+}
+
+// listAuditEntries backs _audit/list: the newest `limit` (default 100)
+// entries of a session's audit log, optionally filtered by kind and since.
+func (s *Server) listAuditEntries(params map[string]any) (map[string]any, error) {
+	opts := audit.ListOptions{Limit: 100}
+	opts.SessionID, _ = params["sessionId"].(string)
+	opts.Kind, _ = params["kind"].(string)
+	if since, ok := params["since"].(string); ok && since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("since must be an RFC 3339 timestamp: %w", err)
+		}
+		opts.Since = t
+	}
+	if limit, ok := params["limit"].(float64); ok {
+		if limit < 0 {
+			return nil, fmt.Errorf("limit must not be negative")
+		}
+		opts.Limit = int(limit)
+	}
+	entries, total, err := s.audit.List(opts)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"entries": entries, "total": total}, nil
+}
+
+// setLogLevel backs _logging/set_level, e.g. {"level": "debug"}.
+func (s *Server) setLogLevel(params map[string]any) (map[string]any, error) {
+	level, _ := params["level"].(string)
+	if !logging.ValidLevel(level) {
+		return nil, fmt.Errorf("level must be one of error, warn, info or debug")
+	}
+	previous := s.logger.Level()
+	s.logger.SetLevel(logging.ParseLevel(level))
+	s.logger.Info("Log level changed", map[string]any{"from": previous.String(), "to": s.logger.Level().String()})
+	return map[string]any{"level": s.logger.Level().String(), "previous": previous.String()}, nil
+}
+
+// refreshCursorState drops the bridge's cached CLI results and queries
+// cursor-agent again, e.g. after the user logs in or upgrades the CLI.
+func (s *Server) refreshCursorState() map[string]any {
+	s.cursor.InvalidateCache()
+	modelsChanged := s.reloadModels()
+
+	binary := s.cursor.BinaryLocation()
+	result := map[string]any{
+		"models":        len(s.sessions.GetAvailableModels()),
+		"modelsChanged": modelsChanged,
+		"binaryPath":    binary.Path,
+		"binarySource":  binary.Source,
+	}
+	version, err := s.cursor.GetVersion()
+	if err != nil {
+		result["available"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	status := s.cursor.CheckAuthentication()
+	result["available"] = true
+	result["version"] = version
+	result["authenticated"] = status.Authenticated
+	if status.Email != "" {
+		result["email"] = status.Email
+	}
+	if status.Error != "" {
+		result["error"] = status.Error
+	}
+	return result
+}
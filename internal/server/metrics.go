@@ -0,0 +1,97 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// methodStats accumulates request latencies for one JSON-RPC method.
+type methodStats struct {
+	count   int64
+	errors  int64
+	totalMs float64
+	maxMs   float64
+}
+
+// requestMetrics records per-method request latencies for _adapter/metrics.
+type requestMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{methods: map[string]*methodStats{}}
+}
+
+func (m *requestMetrics) record(method string, elapsed time.Duration, failed bool) {
+	ms := float64(elapsed.Microseconds()) / 1000
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.methods[method]
+	if !ok {
+		stats = &methodStats{}
+		m.methods[method] = stats
+	}
+	stats.count++
+	if failed {
+		stats.errors++
+	}
+	stats.totalMs += ms
+	if ms > stats.maxMs {
+		stats.maxMs = ms
+	}
+}
+
+func (m *requestMetrics) snapshot() map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.methods))
+	for name := range m.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make(map[string]any, len(names))
+	for _, name := range names {
+		stats := m.methods[name]
+		out[name] = map[string]any{
+			"count":  stats.count,
+			"errors": stats.errors,
+			"avgMs":  stats.totalMs / float64(stats.count),
+			"maxMs":  stats.maxMs,
+		}
+	}
+	return out
+}
+
+// adapterStatus backs _adapter/status.
+func (s *Server) adapterStatus() map[string]any {
+	status := s.Status()
+	healthy := status.Running
+	for _, ok := range status.Components {
+		healthy = healthy && ok
+	}
+	return map[string]any{
+		"running":        status.Running,
+		"healthy":        healthy,
+		"uptimeMs":       status.UptimeMs,
+		"components":     status.Components,
+		"activeStreams":  s.prompt.GetActiveStreamCount(),
+		"activePrompts":  len(s.prompt.ActiveSessions()),
+		"connections":    len(s.allConnections()),
+		"shuttingDown":   s.shuttingDown.Load(),
+		"adapterVersion": AdapterVersion,
+	}
+}
+
+// adapterMetrics backs _adapter/metrics.
+func (s *Server) adapterMetrics() map[string]any {
+	return map[string]any{
+		"uptimeMs":      s.Status().UptimeMs,
+		"activeStreams": s.prompt.GetActiveStreamCount(),
+		"toolCalls":     s.toolCalls.Metrics(),
+		"permissions":   s.permissions.Metrics(),
+		"tools":         s.tools.Metrics(),
+		"requests":      s.requestMetrics.snapshot(),
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+const modelsUpdatedNotification = "_cursor/models_updated"
+
+// startModelRefresh polls `cursor-agent models` so model pickers pick up
+// models added or retired while the adapter is running.
+func (s *Server) startModelRefresh() {
+	interval := time.Duration(s.Config().Cursor.ModelRefreshInterval) * time.Millisecond
+	if interval <= 0 || s.stopModelRefresh != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.stopModelRefresh = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.reloadModels()
+			}
+		}
+	}()
+}
+
+// reloadModels re-reads the model list and, when it changed, updates the
+// /model command and sends _cursor/models_updated. It reports whether the
+// list changed.
+func (s *Server) reloadModels() bool {
+	models, err := s.cursor.RefreshModels()
+	if err != nil {
+		s.logger.Warn("failed to refresh models from cursor-agent", map[string]any{"error": err.Error()})
+		return false
+	}
+	models = s.sessions.AllowedModels(models)
+	added, removed := diffModels(s.sessions.GetAvailableModels(), models)
+	if len(added) == 0 && len(removed) == 0 {
+		return false
+	}
+
+	s.sessions.SetAvailableModels(models)
+	s.refreshModelCommand()
+	s.logger.Info("available models changed", map[string]any{"added": len(added), "removed": len(removed)})
+	s.sendNotification(modelsUpdatedNotification, map[string]any{
+		"models":  models,
+		"added":   added,
+		"removed": removed,
+		"_meta":   map[string]any{"timestamp": time.Now().UTC().Format(time.RFC3339)},
+	})
+	return true
+}
+
+func diffModels(before []acp.SessionModel, after []acp.SessionModel) (added []acp.SessionModel, removed []acp.SessionModel) {
+	old := make(map[string]acp.SessionModel, len(before))
+	for _, m := range before {
+		old[m.ID] = m
+	}
+	seen := make(map[string]bool, len(after))
+	added = []acp.SessionModel{}
+	for _, m := range after {
+		seen[m.ID] = true
+		if _, ok := old[m.ID]; !ok {
+			added = append(added, m)
+		}
+	}
+	removed = []acp.SessionModel{}
+	for _, m := range before {
+		if !seen[m.ID] {
+			removed = append(removed, m)
+		}
+	}
+	return added, removed
+}
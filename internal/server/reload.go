@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const configChangedNotification = "_adapter/config_changed"
+
+// reloadableSettings can change without a restart. Entries ending in "."
+// cover every setting under that prefix.
+var reloadableSettings = []string{
+	"logLevel",
+	"shutdownTimeout",
+	"maxMessageBytes",
+	"cursor.timeout",
+	"cursor.retries",
+	"tools.",
+	"clientRequests.",
+	"environment.",
+}
+
+func reloadable(setting string) bool {
+	for _, prefix := range reloadableSettings {
+		if setting == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(setting, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns the configuration currently in effect.
+func (s *Server) Config() config.Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
+
+// ApplyConfig validates next and applies the settings that are safe to change
+// while running: log level, shutdown and cursor-agent timeouts, the message
+// size limit, retries, tool enablement and limits, client request timeouts
+// and the environment policy. Other changes are reported as requiring a restart. Clients are
+// sent _adapter/config_changed when anything differs.
+func (s *Server) ApplyConfig(next config.Config) error {
+	if errs := config.Validate(next); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	s.cfgMu.Lock()
+	// Diff against the last config loaded rather than the one in effect, so
+	// a setting waiting for a restart is only reported when it changes.
+	previous := s.loadedCfg
+	s.loadedCfg = next
+	applied := []string{}
+	restart := []string{}
+	for _, setting := range config.Diff(previous, next) {
+		if reloadable(setting) {
+			applied = append(applied, setting)
+		} else {
+			restart = append(restart, setting)
+		}
+	}
+	if len(applied)+len(restart) == 0 {
+		s.cfgMu.Unlock()
+		return nil
+	}
+	updated := s.cfg
+	updated.LogLevel = next.LogLevel
+	updated.ShutdownTimeout = next.ShutdownTimeout
+	updated.MaxMessageBytes = next.MaxMessageBytes
+	updated.Cursor.Timeout = next.Cursor.Timeout
+	updated.Cursor.Retries = next.Cursor.Retries
+	updated.Tools = next.Tools
+	updated.ClientRequests = next.ClientRequests
+	updated.Environment = next.Environment
+	s.cfg = updated
+	s.cfgMu.Unlock()
+
+	if changedUnder(applied, "logLevel") {
+		s.logger.SetLevel(logging.ParseLevel(next.LogLevel))
+	}
+	s.cursor.SetLimits(updated.Cursor.Timeout, updated.Cursor.Retries)
+	if changedUnder(applied, "environment.") {
+		s.cursor.SetEnvironment(updated.Environment)
+		s.terminals.SetEnvPolicy(envpolicy.New(updated.Environment))
+		s.checkpoints.SetEnvironment(updated.Environment)
+	}
+	if changedUnder(applied, "tools.") || changedUnder(applied, "environment.") {
+		s.tools.Reconfigure(updated)
+	}
+	if changedUnder(applied, "tools.") {
+		s.toolCalls.SetOutputLimits(s.outputLimits(updated.Tools))
+	}
+	if changedUnder(applied, "tools.filesystem.") {
+		s.prompt.SetFilesystemPolicy(mentionPolicy(updated.Tools.Filesystem))
+	}
+
+	s.logger.Info("Configuration reloaded", map[string]any{"applied": applied, "requiresRestart": restart})
+	s.sendNotification(configChangedNotification, map[string]any{
+		"applied":         applied,
+		"requiresRestart": restart,
+		"_meta":           map[string]any{"timestamp": time.Now().UTC().Format(time.RFC3339)},
+	})
+	return nil
+}
+
+// mentionPolicy is the policy @-mentioned files read from disk must pass:
+// the filesystem tools' policy, or none when those tools are disabled.
+func mentionPolicy(cfg config.FilesystemConfig) *fspolicy.Policy {
+	if !cfg.Enabled {
+		return nil
+	}
+	return fspolicy.New(cfg)
+}
+
+func changedUnder(settings []string, prefix string) bool {
+	for _, setting := range settings {
+		if strings.HasPrefix(setting, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
+)
+
+// replayLogBytes bounds the updates kept for replay per session, however
+// many replayUpdates allows.
+const replayLogBytes = 4 << 20
+
+// updateRing keeps a session's most recent session/update notifications,
+// as sent, for clients that reconnect and missed some. Sequence numbers are
+// consecutive, so the oldest kept update's number locates every other.
+type updateRing struct {
+	items [][]byte
+	first uint64
+	bytes int
+}
+
+// add keeps buf, dropping the oldest updates beyond limit of them or
+// replayLogBytes of data. The newest update is always kept.
+func (r *updateRing) add(seq uint64, buf []byte, limit int) {
+	if len(r.items) == 0 {
+		r.first = seq
+	}
+	r.items = append(r.items, buf)
+	r.bytes += len(buf)
+	for len(r.items) > 1 && (len(r.items) > limit || r.bytes > replayLogBytes) {
+		r.bytes -= len(r.items[0])
+		r.items[0] = nil
+		r.items = r.items[1:]
+		r.first++
+	}
+}
+
+// since returns the kept updates numbered after seq, oldest first.
+func (r *updateRing) since(seq uint64) []json.RawMessage {
+	out := []json.RawMessage{}
+	for i, item := range r.items {
+		if r.first+uint64(i) > seq {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// sessionUpdates is the session/update state of one session. mu serializes
+// its updates so their sequence numbers reach every client in order. log
+// keeps the most recent of them for session/replay_updates; turnCounts counts
+// the updates of each running turn by kind for its turn_completed update.
+type sessionUpdates struct {
+	mu         sync.Mutex
+	seq        uint64
+	log        *updateRing
+	turnCounts map[string]map[string]int
+}
+
+// updatesFor returns sessionID's update state, creating it on first use.
+func (s *Server) updatesFor(sessionID string) *sessionUpdates {
+	s.updatesMu.Lock()
+	defer s.updatesMu.Unlock()
+	state := s.updates[sessionID]
+	if state == nil {
+		state = &sessionUpdates{turnCounts: map[string]map[string]int{}}
+		s.updates[sessionID] = state
+	}
+	return state
+}
+
+// record keeps buf, the update numbered u.seq, for replay. Callers hold
+// u.mu.
+func (u *sessionUpdates) record(buf []byte, limit int) {
+	if u.log == nil {
+		u.log = &updateRing{}
+	}
+	u.log.add(u.seq, buf, limit)
+}
+
+// countTurnUpdate tallies an update of turnID. A turn_completed update is
+// given the turn's counts, which are then dropped. Callers hold u.mu.
+func (u *sessionUpdates) countTurnUpdate(message map[string]any, turnID, kind string) {
+	switch kind {
+	case "turn_started":
+		u.turnCounts[turnID] = map[string]int{}
+	case "turn_completed":
+		counts := u.turnCounts[turnID]
+		delete(u.turnCounts, turnID)
+		params := message["params"].(map[string]any)
+		update := maps.Clone(params["update"].(map[string]any))
+		update["messageChunks"] = counts["agent_message_chunk"]
+		update["thoughtChunks"] = counts["agent_thought_chunk"]
+		update["toolCalls"] = counts["tool_call"]
+		params["update"] = update
+	default:
+		if counts, ok := u.turnCounts[turnID]; ok {
+			counts[kind]++
+		}
+	}
+}
+
+// forgetUpdates drops the update state of a deleted session.
+func (s *Server) forgetUpdates(sessionID string) {
+	s.updatesMu.Lock()
+	delete(s.updates, sessionID)
+	s.updatesMu.Unlock()
+}
+
+// handleReplayUpdates returns the session/update notifications numbered
+// after sinceSequence that are still kept. complete is false when some of
+// them were already dropped, so the client should reload the session.
+func (s *Server) handleReplayUpdates(raw json.RawMessage) (map[string]any, error) {
+	params, err := decodeParams[acp.ReplayUpdatesRequest](raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return nil, fmt.Errorf("sessionId is required")
+	}
+	if !s.sessions.HasSession(params.SessionID) {
+		return nil, errcode.New(errcode.SessionNotFound, "Session not found: %s", params.SessionID)
+	}
+	state := s.updatesFor(params.SessionID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	last := state.seq
+	updates := []json.RawMessage{}
+	complete := params.SinceSequence >= last
+	if ring := state.log; ring != nil {
+		updates = ring.since(params.SinceSequence)
+		complete = complete || ring.first <= params.SinceSequence+1
+	}
+	return map[string]any{
+		"sessionId":    params.SessionID,
+		"updates":      updates,
+		"lastSequence": last,
+		"complete":     complete,
+	}, nil
+}
@@ -1,36 +1,49 @@
 package server
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/artifact"
+	"github.com/spjoes/cursor-agent-acp/internal/audit"
+	"github.com/spjoes/cursor-agent-acp/internal/checkpoint"
 	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
 	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/errorfmt"
 	"github.com/spjoes/cursor-agent-acp/internal/extensions"
+	"github.com/spjoes/cursor-agent-acp/internal/i18n"
 	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
+	"github.com/spjoes/cursor-agent-acp/internal/jsonschema"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 	"github.com/spjoes/cursor-agent-acp/internal/permissions"
 	"github.com/spjoes/cursor-agent-acp/internal/prompt"
+	"github.com/spjoes/cursor-agent-acp/internal/redact"
 	"github.com/spjoes/cursor-agent-acp/internal/session"
 	"github.com/spjoes/cursor-agent-acp/internal/slash"
+	"github.com/spjoes/cursor-agent-acp/internal/terminal"
 	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
 	"github.com/spjoes/cursor-agent-acp/internal/tools"
+	"github.com/spjoes/cursor-agent-acp/internal/tracing"
+	"github.com/spjoes/cursor-agent-acp/internal/wirelog"
 )
 
 const (
@@ -53,8 +66,14 @@ type clientRPCResponse struct {
 }
 
 type Server struct {
-	cfg    config.Config
-	logger *logging.Logger
+	// cfgMu guards cfg, which ApplyConfig updates on a config reload, and
+	// loadedCfg, the last configuration read from the file.
+	cfgMu     sync.RWMutex
+	cfg       config.Config
+	loadedCfg config.Config
+	logger    *logging.Logger
+	// messages is the catalog of user-facing text for config.Locale.
+	messages *i18n.Catalog
 
 	sessions    *session.Manager
 	cursor      *cursor.Bridge
@@ -65,18 +84,62 @@ type Server struct {
 	fsClient    *client.ACPFileSystemClient
 	tools       *tools.Registry
 	prompt      *prompt.Handler
+	checkpoints *checkpoint.Manager
+	artifacts   *artifact.Store
+	terminals   *terminal.Manager
 
 	stdoutMu sync.Mutex
 	stdout   io.Writer
+	stdin    io.Reader
 
 	startTime time.Time
 	running   bool
 
-	clientCapabilities map[string]any
-
-	pendingMu        sync.Mutex
-	pendingClientRPC map[string]chan clientRPCResponse
-	clientRPCSeq     uint64
+	inflight     sync.WaitGroup
+	shuttingDown atomic.Bool
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	closeOnce    sync.Once
+
+	stopModelRefresh chan struct{}
+
+	// stdioConn is the client on stdin/stdout; Serve adds more to conns.
+	// sessionConns and terminalConns route session traffic and terminal
+	// requests to the connections using them. sessionOwners is the
+	// connection that last created, loaded or prompted each session, which
+	// answers the session's client requests; subscribers only follow it.
+	stdioConn     *connection
+	connsMu       sync.Mutex
+	conns         map[string]*connection
+	sessionConns  map[string][]*connection
+	sessionOwners map[string]*connection
+	terminalConns map[string]*connection
+	connSeq       uint64
+	// orphaned holds the sessions whose every client disconnected, and
+	// whether that was logged yet. Their updates reach the stdio client only
+	// with stdioFallback.
+	orphaned      map[string]bool
+	stdioFallback bool
+
+	pendingMu     sync.Mutex
+	clientRPCSeq  uint64
+	sessionRPCs   map[string]map[uint64]context.CancelCauseFunc
+	sessionRPCSeq uint64
+
+	// updates holds each session's session/update state; updatesMu guards
+	// the map only. replayUpdates is how many updates a session keeps for
+	// session/replay_updates.
+	updatesMu     sync.Mutex
+	updates       map[string]*sessionUpdates
+	replayUpdates int
+	// echoAll is prompt.echoUserMessages: prompt echoes go to every client,
+	// not only those that asked for them.
+	echoAll bool
+
+	requestMetrics *requestMetrics
+	tracer         *tracing.Tracer
+	audit          *audit.Log
+	wireLog        *wirelog.Log
 }
 
 var (
@@ -86,29 +149,92 @@ var (
 
 func New(cfg config.Config, logger *logging.Logger) *Server {
 	s := &Server{
-		cfg:              cfg,
-		logger:           logger,
-		stdout:           os.Stdout,
-		pendingClientRPC: map[string]chan clientRPCResponse{},
-	}
+		cfg:            cfg,
+		loadedCfg:      cfg,
+		logger:         logger,
+		stdout:         os.Stdout,
+		stdin:          os.Stdin,
+		shutdownCh:     make(chan struct{}),
+		conns:          map[string]*connection{},
+		sessionConns:   map[string][]*connection{},
+		sessionOwners:  map[string]*connection{},
+		terminalConns:  map[string]*connection{},
+		orphaned:       map[string]bool{},
+		stdioFallback:  cfg.StdioFallback,
+		updates:        map[string]*sessionUpdates{},
+		replayUpdates:  cfg.ReplayUpdates,
+		echoAll:        cfg.Prompt.EchoUserMessages,
+		sessionRPCs:    map[string]map[uint64]context.CancelCauseFunc{},
+		requestMetrics: newRequestMetrics(),
+		tracer:         tracing.New(cfg.Tracing, logger),
+		audit:          audit.New(cfg.Audit, logger),
+	}
+	s.stdioConn = newConnection("stdio", func(buf []byte) error {
+		s.stdoutMu.Lock()
+		defer s.stdoutMu.Unlock()
+		_, err := s.stdout.Write(buf)
+		return err
+	})
 	s.sessions = session.NewManager(cfg, logger)
+	s.sessions.SetOnDelete(s.forgetSession)
 	s.cursor = cursor.NewBridge(cfg, logger)
 	s.extensions = extensions.NewRegistry(logger)
 	s.slash = slash.NewRegistry(logger)
 	s.permissions = permissions.NewHandler(logger)
 	s.toolCalls = toolcall.NewManager(
 		logger,
-		func(notification map[string]any) { s.writeMessage(notification) },
-		func(params permissions.RequestPermissionParams) permissions.PermissionOutcome {
-			return defaultPermissionOutcome(params.Options)
-		},
+		s.sendMessageNotification,
+		s.requestClientPermission,
 	)
 	s.tools = tools.NewRegistry(cfg, logger, s.cursor)
+	if redactor, err := redact.New(cfg.Redaction); err != nil {
+		logger.Warn("Redaction disabled", map[string]any{"error": err.Error()})
+	} else {
+		s.toolCalls.SetRedactor(redactor)
+	}
+	s.artifacts = artifact.NewStore(filepath.Join(cfg.SessionDir, "artifacts"))
+	s.artifacts.SetMaxPerSession(cfg.Tools.MaxArtifactsPerSession)
+	if cfg.SessionEncryption.Enabled {
+		s.artifacts.SetCodec(s.sessions)
+		s.audit.SetCodec(s.sessions)
+	}
+	s.toolCalls.SetOutputLimits(s.outputLimits(cfg.Tools))
 	s.tools.SetToolCallManager(s.toolCalls)
+	s.tools.SetAuditLog(s.audit)
+	s.tools.SetSessionCwdResolver(s.sessions.GetSessionCwd)
+	s.tools.SetWorkspaceFoldersResolver(s.sessions.GetWorkspaceFolders)
 	s.fsClient = client.NewACPFileSystemClient(s, logger)
+	s.terminals = terminal.NewManager(terminal.ManagerConfig{
+		ClientSupportsTerminals: cfg.Tools.Terminal.Enabled,
+		MaxConcurrentTerminals:  cfg.Tools.Terminal.MaxProcesses,
+		DefaultOutputByteLimit:  cfg.Tools.Terminal.DefaultOutputByteLimit,
+		MaxOutputByteLimit:      cfg.Tools.Terminal.MaxOutputByteLimit,
+		ForbiddenCommands:       cfg.Tools.Terminal.ForbiddenCommands,
+		AllowedCommands:         cfg.Tools.Terminal.AllowedCommands,
+		CommandSafety:           cfg.Tools.Terminal.CommandSafety,
+		EnvPolicy:               envpolicy.New(cfg.Environment),
+		DefaultCwd:              cfg.Tools.Terminal.DefaultCwd,
+		IdleTimeout:             time.Duration(cfg.Tools.Terminal.IdleTimeoutMs) * time.Millisecond,
+	}, s, logger)
+	s.tools.SetTerminalManager(s.terminals)
 	s.prompt = prompt.NewHandler(s.sessions, s.cursor, logger, s.sendNotification, s.slash)
+	s.checkpoints = checkpoint.NewManager(cfg, logger)
+	s.prompt.SetCheckpointManager(s.checkpoints)
+	s.prompt.SetToolCallManager(s.toolCalls)
+	s.prompt.SetFileSystemClient(s.fsClient)
+	s.prompt.SetFilesystemPolicy(mentionPolicy(cfg.Tools.Filesystem))
+	s.prompt.SetPromptConfig(cfg.Prompt)
+	s.prompt.SetUserMessageEcho(s.sessionWantsEcho)
+	messages, ok := i18n.For(cfg.Locale)
+	if !ok {
+		logger.Warn("No message catalog for locale, using English", map[string]any{"locale": cfg.Locale})
+	}
+	s.messages = messages
+	s.prompt.SetMessages(messages)
+	s.prompt.SetDiffContext(cfg.Tools.DiffContextLines)
 
 	s.registerDefaultCommands()
+	s.registerBuiltinExtensions()
 	s.slash.OnChange(func(_ []slash.AvailableCommand) {
 		sessions, _, _, err := s.sessions.ListSessions(1000, 0, nil)
 		if err != nil {
@@ -124,35 +250,57 @@ func New(cfg config.Config, logger *logging.Logger) *Server {
 }
 
 func (s *Server) Initialize() error {
-	if err := config.EnsureSessionDir(s.cfg); err != nil {
+	if err := config.EnsureSessionDir(s.Config()); err != nil {
+		return err
+	}
+	if err := s.sessions.AcquireLock(); err != nil {
 		return err
 	}
 
 	s.sessions.LoadModelsFromProvider(s.cursor)
 	s.refreshModelCommand()
+	binary := s.cursor.BinaryLocation()
 	if version, err := s.cursor.GetVersion(); err != nil {
-		s.logger.Warn("cursor-agent CLI not available", map[string]any{"error": err.Error()})
+		s.logger.Warn("cursor-agent CLI not available", map[string]any{"error": err.Error(), "path": binary.Path, "source": binary.Source})
 	} else {
-		s.logger.Info("cursor-agent CLI detected", map[string]any{"version": version})
+		s.logger.Info("cursor-agent CLI detected", map[string]any{"version": version, "path": binary.Path, "source": binary.Source})
 		status := s.cursor.CheckAuthentication()
 		if !status.Authenticated {
 			s.logger.Warn("cursor-agent not authenticated", map[string]any{"error": status.Error})
 		}
 	}
 
+	s.startModelRefresh()
+
 	s.running = true
 	s.startTime = time.Now().UTC()
 	return nil
 }
 
 func (s *Server) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *Server) close() {
 	s.running = false
+	if s.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = s.tracer.Shutdown(ctx)
+		cancel()
+	}
+	if s.stopModelRefresh != nil {
+		close(s.stopModelRefresh)
+		s.stopModelRefresh = nil
+	}
 	if s.prompt != nil {
 		s.prompt.Close()
 	}
 	if s.toolCalls != nil {
 		s.toolCalls.Cleanup()
 	}
+	if s.terminals != nil {
+		s.terminals.Close()
+	}
 	if s.permissions != nil {
 		s.permissions.Cleanup()
 	}
@@ -173,6 +321,24 @@ func (s *Server) Close() {
 	}
 }
 
+// SetWireLog records every JSON-RPC message read or written to w.
+func (s *Server) SetWireLog(w *wirelog.Log) {
+	s.wireLog = w
+}
+
+// SetInput sets where JSON-RPC messages are read from (os.Stdin by default).
+// Call it before StartStdio.
+func (s *Server) SetInput(r io.Reader) {
+	s.stdin = r
+}
+
+// SetOutput sets where JSON-RPC messages are written (os.Stdout by default).
+func (s *Server) SetOutput(w io.Writer) {
+	s.stdoutMu.Lock()
+	s.stdout = w
+	s.stdoutMu.Unlock()
+}
+
 func (s *Server) Status() Status {
 	status := Status{
 		Running: s.running,
@@ -193,78 +359,92 @@ func (s *Server) Status() Status {
 	return status
 }
 
+// StartStdio serves JSON-RPC over stdin/stdout until stdin closes or ctx is
+// cancelled. It does not wait for in-flight requests; call Shutdown to cancel
+// and drain them.
 func (s *Server) StartStdio(ctx context.Context) error {
 	s.logger.Info("Starting ACP adapter with stdio transport", nil)
+	return s.serveConnection(ctx, s.stdioConn, s.stdin)
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-	var inflight sync.WaitGroup
-
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			inflight.Wait()
-			return ctx.Err()
-		default:
-		}
+// handleLine dispatches one incoming message. It is decoded once; params
+// stay raw until the method's handler decodes them into its own type.
+func (s *Server) handleLine(ctx context.Context, line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	s.wireLog.Record(wirelog.Inbound, line)
+	conn := s.connFor(ctx)
 
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+	var msg jsonrpc.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		resp := jsonrpc.Failure(nil, jsonrpc.ParseError, "Parse error", map[string]any{"error": err.Error()})
+		s.writeMessageTo(conn, resp)
+		return
+	}
 
-		var envelope map[string]json.RawMessage
-		if err := json.Unmarshal([]byte(line), &envelope); err != nil {
-			resp := jsonrpc.Failure(nil, jsonrpc.ParseError, "Parse error", map[string]any{"error": err.Error()})
-			s.writeMessage(resp)
-			continue
+	if msg.HasMethod {
+		if msg.Invalid != nil {
+			resp := jsonrpc.Failure(nil, jsonrpc.InvalidRequest, "Invalid request", map[string]any{"error": msg.Invalid.Error()})
+			s.writeMessageTo(conn, resp)
+			return
 		}
-
-		if _, ok := envelope["method"]; ok {
-			var req jsonrpc.Request
-			if err := json.Unmarshal([]byte(line), &req); err != nil {
-				resp := jsonrpc.Failure(nil, jsonrpc.InvalidRequest, "Invalid request", map[string]any{"error": err.Error()})
-				s.writeMessage(resp)
-				continue
+		req := msg.Request
+		if s.shuttingDown.Load() {
+			if !req.IsNotification() {
+				s.writeMessageTo(conn, shuttingDownFailure(req.ID))
 			}
-			inflight.Add(1)
-			go func(request jsonrpc.Request) {
-				defer inflight.Done()
-				resp, postResponse := s.processRequest(ctx, request)
-				if request.IsNotification() {
-					return
-				}
-				s.writeMessage(resp)
-				if postResponse != nil {
-					postResponse()
-				}
-			}(req)
-			continue
+			return
 		}
-
-		if _, ok := envelope["id"]; ok {
-			var resp clientRPCResponse
-			if err := json.Unmarshal([]byte(line), &resp); err != nil {
-				s.logger.Warn("Failed to decode client RPC response", map[string]any{"error": err.Error()})
-				continue
+		if !req.IsNotification() && req.ID != nil {
+			if resp, ok := conn.requests.begin(req); !ok {
+				s.logger.Info("Answering a repeated request without running it again", map[string]any{"method": req.Method, "id": req.ID, "connection": conn.id})
+				s.writeMessageTo(conn, resp)
+				return
 			}
-			s.handleClientRPCResponse(resp)
-			continue
 		}
-
-		s.logger.Warn("Ignoring JSON-RPC message without method or id", map[string]any{"line": line})
+		sessionID := paramsSessionID(req.Params)
+		s.attachSession(sessionID, conn)
+		if req.Method == "session/prompt" || req.Method == "session/load" {
+			s.claimSession(sessionID, conn)
+		}
+		s.inflight.Add(1)
+		go func(request jsonrpc.Request) {
+			defer s.inflight.Done()
+			resp, postResponse := s.processSessionRequest(ctx, request, sessionID)
+			if created, ok := resp.Result.(acp.NewSessionResponse); ok {
+				s.attachSession(created.SessionID, conn)
+				s.claimSession(created.SessionID, conn)
+			}
+			if request.IsNotification() {
+				return
+			}
+			conn.requests.finish(request, resp)
+			s.writeMessageTo(conn, resp)
+			if postResponse != nil {
+				postResponse()
+			}
+		}(req)
+		return
 	}
 
-	if err := scanner.Err(); err != nil {
-		inflight.Wait()
-		return err
+	if !msg.IsNotification() {
+		if msg.Invalid != nil {
+			s.logger.Warn("Failed to decode client RPC response", map[string]any{"error": msg.Invalid.Error()})
+			return
+		}
+		s.handleClientRPCResponse(conn, clientRPCResponse{JSONRPC: msg.JSONRPC, ID: msg.ID, Result: msg.Result, Error: msg.Error})
+		return
 	}
-	inflight.Wait()
-	return nil
+
+	s.logger.Warn("Ignoring JSON-RPC message without method or id", map[string]any{"line": string(line)})
 }
 
 func (s *Server) ProcessRequest(ctx context.Context, req jsonrpc.Request) jsonrpc.Response {
+	if s.shuttingDown.Load() {
+		return shuttingDownFailure(req.ID)
+	}
 	resp, postResponse := s.processRequest(ctx, req)
 	if postResponse != nil && !req.IsNotification() {
 		go postResponse()
@@ -272,7 +452,13 @@ func (s *Server) ProcessRequest(ctx context.Context, req jsonrpc.Request) jsonrp
 	return resp
 }
 
-func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (jsonrpc.Response, func()) {
+func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (resp jsonrpc.Response, after func()) {
+	return s.processSessionRequest(ctx, req, paramsSessionID(req.Params))
+}
+
+// processSessionRequest is processRequest for a request whose params were
+// already searched for the session ID, so large params are not scanned again.
+func (s *Server) processSessionRequest(ctx context.Context, req jsonrpc.Request, sessionID string) (resp jsonrpc.Response, after func()) {
 	if req.JSONRPC != jsonrpc.Version {
 		return jsonrpc.Failure(req.ID, jsonrpc.InvalidRequest, "Invalid JSON-RPC version", nil), nil
 	}
@@ -285,10 +471,30 @@ func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (jsonr
 	var postResponse func()
 
 	s.logger.Debug("Processing request", map[string]any{"method": req.Method, "id": req.ID})
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, req.Method, tracing.KindServer, map[string]any{
+		"rpc.system": "jsonrpc",
+		"rpc.method": req.Method,
+		"session.id": sessionID,
+	})
+	if req.ID != nil {
+		span.SetAttributes(map[string]any{"rpc.jsonrpc.request_id": fmt.Sprint(req.ID)})
+	}
+	defer func() {
+		if resp.Error == nil || resp.Error.Code != jsonrpc.MethodNotFound {
+			s.requestMetrics.record(req.Method, time.Since(start), resp.Error != nil)
+		}
+		var spanErr error
+		if resp.Error != nil {
+			span.SetAttributes(map[string]any{"rpc.jsonrpc.error_code": resp.Error.Code})
+			spanErr = errors.New(resp.Error.Message)
+		}
+		span.End(spanErr)
+	}()
 
 	switch req.Method {
 	case "initialize":
-		result, err = s.handleInitialize(req.Params)
+		result, err = s.handleInitialize(ctx, req.Params)
 	case "session/new":
 		var newResponse acp.NewSessionResponse
 		newResponse, err = s.handleSessionNew(ctx, req.Params)
@@ -318,10 +524,22 @@ func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (jsonr
 		result, err = s.handleSessionUpdate(req.Params)
 	case "session/delete":
 		result, err = s.handleSessionDelete(req.Params)
+	case "session/subscribe":
+		result, err = s.handleSessionSubscribe(ctx, req.Params)
+	case "session/unsubscribe":
+		result, err = s.handleSessionUnsubscribe(ctx, req.Params)
+	case "session/replay_updates":
+		result, err = s.handleReplayUpdates(req.Params)
+	case "session/checkpoints":
+		result, err = s.handleListCheckpoints(req.Params)
+	case "session/restore_checkpoint":
+		result, err = s.handleRestoreCheckpoint(req.Params)
 	case "session/set_mode":
 		result, err = s.handleSetSessionMode(req.Params)
 	case "session/set_model":
 		result, err = s.handleSetSessionModel(req.Params)
+	case "session/set_cwd":
+		result, err = s.handleSetSessionCwd(req.Params)
 	case "session/prompt":
 		result, err = s.handleSessionPrompt(ctx, req)
 	case "session/cancel":
@@ -336,19 +554,19 @@ func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (jsonr
 		if strings.HasPrefix(req.Method, "_") {
 			params, derr := decodeObjectParams(req.Params)
 			if derr != nil {
-				return jsonrpc.Failure(req.ID, jsonrpc.InvalidParams, derr.Error(), nil), nil
+				return jsonrpc.Failure(req.ID, jsonrpc.InvalidParams, derr.Error(), map[string]any{"code": string(errcode.InvalidParams)}), nil
 			}
 			if req.IsNotification() {
 				s.extensions.SendNotification(req.Method, params)
 				return jsonrpc.Success(req.ID, nil), nil
 			}
 			if !s.extensions.HasMethod(req.Method) {
-				return jsonrpc.Failure(req.ID, jsonrpc.MethodNotFound, "Method not found", nil), nil
+				return jsonrpc.Failure(req.ID, jsonrpc.MethodNotFound, "Method not found", map[string]any{"code": string(errcode.MethodNotFound)}), nil
 			}
 			result, err = s.extensions.CallMethod(req.Method, params)
 			break
 		}
-		return jsonrpc.Failure(req.ID, jsonrpc.MethodNotFound, "Unknown method: "+req.Method, nil), nil
+		return jsonrpc.Failure(req.ID, jsonrpc.MethodNotFound, "Unknown method: "+req.Method, map[string]any{"code": string(errcode.MethodNotFound)}), nil
 	}
 
 	if err != nil {
@@ -358,7 +576,7 @@ func (s *Server) processRequest(ctx context.Context, req jsonrpc.Request) (jsonr
 	return jsonrpc.Success(req.ID, result), postResponse
 }
 
-func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse, error) {
+func (s *Server) handleInitialize(ctx context.Context, raw json.RawMessage) (acp.InitializeResponse, error) {
 	initializeStart := time.Now().UTC()
 	params, err := decodeParams[acp.InitializeRequest](raw)
 	if err != nil {
@@ -373,8 +591,13 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 		agreed = 1
 	}
 
-	s.clientCapabilities = params.ClientCapabilities
-	s.tools.ConfigureFilesystemProvider(s.clientCapabilities, s.fsClient)
+	cfg := s.Config()
+	// Filesystem tools are shared by every client; the last one to
+	// initialize decides which of them are registered.
+	caps := s.connFor(ctx).caps
+	caps.Set(params.ClientCapabilities)
+	s.tools.ConfigureFilesystemProvider(caps.Raw(), s.fsClient)
+	clientFS := caps.ReadTextFile() || caps.WriteTextFile()
 
 	connectivitySuccess := false
 	cursorVersion := any(nil)
@@ -388,12 +611,15 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 		cursorError = status.Error
 	}
 	cursorAvailable := connectivitySuccess && cursorAuthenticated
+	cursorBinary := s.cursor.BinaryLocation()
+	// New sessions start on the default model.
+	defaultModel := s.sessions.GetSessionModel("")
 
 	capabilities := map[string]any{
 		"loadSession": true,
 		"promptCapabilities": map[string]any{
-			"image":           cursorAvailable,
-			"audio":           false,
+			"image":           cursorAvailable && s.prompt.SupportsImages(defaultModel),
+			"audio":           cursorAvailable && s.prompt.SupportsAudio(defaultModel),
 			"embeddedContext": cursorAvailable,
 		},
 		"mcpCapabilities": map[string]any{
@@ -410,13 +636,18 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 		"_meta": map[string]any{
 			"streaming":       cursorAvailable,
 			"toolCalling":     cursorAvailable,
-			"fileSystem":      s.cfg.Tools.Filesystem.Enabled,
-			"terminal":        s.cfg.Tools.Terminal.Enabled,
+			"fileSystem":      cfg.Tools.Filesystem.Enabled && clientFS,
+			"terminal":        cfg.Tools.Terminal.Enabled && caps.Terminal(),
 			"cursorAvailable": cursorAvailable,
 			"cursorVersion":   cursorVersion,
-			"description":     "Production-ready ACP adapter for Cursor CLI",
-			"implementation":  "cursor-agent-acp",
-			"repositoryUrl":   "https://github.com/spjoes/cursor-agent-acp",
+			"cursorBinary": map[string]any{
+				"path":   cursorBinary.Path,
+				"source": cursorBinary.Source,
+			},
+			"logLevel":       s.logger.Level().String(),
+			"description":    "Production-ready ACP adapter for Cursor CLI",
+			"implementation": "cursor-agent-acp",
+			"repositoryUrl":  "https://github.com/spjoes/cursor-agent-acp",
 		},
 	}
 	metaCaps, _ := capabilities["_meta"].(map[string]any)
@@ -439,12 +670,12 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 		"cursorCliStatus":          cursorCLIStatus,
 		"cursorVersion":            cursorVersion,
 		"cursorAuthenticated":      cursorAuthenticated,
-		"nodeVersion":              resolvedNodeVersion(),
+		"nodeVersion":              resolvedNodeVersion(cfg.Environment),
 		"platform":                 runtime.GOOS,
 		"arch":                     runtime.GOARCH,
 		"toolsEnabled": map[string]any{
-			"filesystem": s.cfg.Tools.Filesystem.Enabled,
-			"terminal":   s.cfg.Tools.Terminal.Enabled,
+			"filesystem": cfg.Tools.Filesystem.Enabled && clientFS,
+			"terminal":   cfg.Tools.Terminal.Enabled && caps.Terminal(),
 		},
 		"versionNegotiation": map[string]any{
 			"clientRequested": params.ProtocolVersion,
@@ -456,24 +687,24 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 
 	if !connectivitySuccess {
 		guidance := map[string]any{
-			"issue": "cursor-agent CLI not available",
+			"issue": s.messages.T("guidance.cursorUnavailable"),
 		}
 		if strings.TrimSpace(cursorError) != "" {
 			guidance["issue"] = cursorError
 		}
 		lower := strings.ToLower(cursorError)
 		if strings.Contains(lower, "not installed") || strings.Contains(lower, "not found") || strings.Contains(lower, "enoent") || strings.Contains(lower, "command not found") {
-			guidance["resolution"] = "Install cursor-agent CLI: https://cursor.sh/docs/agent"
+			guidance["resolution"] = s.messages.T("guidance.install")
 		}
 		meta["cursorCliGuidance"] = guidance
 	} else if !cursorAuthenticated {
-		issue := "User not authenticated"
+		issue := s.messages.T("guidance.notAuthenticated")
 		if strings.TrimSpace(cursorError) != "" {
 			issue = cursorError
 		}
 		meta["cursorCliGuidance"] = map[string]any{
 			"issue":      issue,
-			"resolution": "Run: cursor-agent login",
+			"resolution": s.messages.T("guidance.login"),
 		}
 	}
 
@@ -491,11 +722,13 @@ func (s *Server) handleInitialize(raw json.RawMessage) (acp.InitializeResponse,
 	return resp, nil
 }
 
-func resolvedNodeVersion() string {
+func resolvedNodeVersion(environment config.EnvironmentConfig) string {
 	nodeVersionOnce.Do(func() {
 		nodeVersion = runtime.Version()
 
-		out, err := exec.Command("node", "--version").Output()
+		cmd := exec.Command("node", "--version")
+		cmd.Env = envpolicy.New(environment).Filter(os.Environ())
+		out, err := cmd.Output()
 		if err != nil {
 			return
 		}
@@ -522,6 +755,10 @@ func (s *Server) handleSessionNew(ctx context.Context, raw json.RawMessage) (acp
 	if params.McpServers == nil {
 		return acp.NewSessionResponse{}, fmt.Errorf("mcpServers is required and must be an array (can be empty)")
 	}
+	folders, err := workspaceFolders(params.Cwd, params.WorkspaceFolders)
+	if err != nil {
+		return acp.NewSessionResponse{}, err
+	}
 
 	metadata := cloneMap(params.Metadata)
 	if metadata == nil {
@@ -529,6 +766,9 @@ func (s *Server) handleSessionNew(ctx context.Context, raw json.RawMessage) (acp
 	}
 	metadata["cwd"] = params.Cwd
 	metadata["mcpServers"] = params.McpServers
+	if len(folders) > 0 {
+		metadata["workspaceFolders"] = folders
+	}
 
 	if chatID, err := s.cursor.CreateChat(ctx); err == nil && chatID != "" {
 		metadata["cursorChatId"] = chatID
@@ -544,6 +784,9 @@ func (s *Server) handleSessionNew(ctx context.Context, raw json.RawMessage) (acp
 		"cwd":            params.Cwd,
 		"mcpServerCount": len(params.McpServers),
 	}
+	if len(folders) > 0 {
+		meta["workspaceFolders"] = folders
+	}
 	if len(params.McpServers) > 0 {
 		servers := make([]map[string]any, 0, len(params.McpServers))
 		for _, rawServer := range params.McpServers {
@@ -590,12 +833,16 @@ func (s *Server) handleSessionLoad(ctx context.Context, raw json.RawMessage) (ac
 	if params.McpServers == nil {
 		return acp.LoadSessionResponse{}, fmt.Errorf("mcpServers is required and must be an array (can be empty)")
 	}
+	folders, err := workspaceFolders(params.Cwd, params.WorkspaceFolders)
+	if err != nil {
+		return acp.LoadSessionResponse{}, err
+	}
 
 	sessionData, err := s.sessions.LoadSession(params.SessionID)
 	if err != nil {
 		return acp.LoadSessionResponse{}, err
 	}
-	_, err = s.sessions.UpdateSession(params.SessionID, mergeMaps(params.Metadata, map[string]any{"cwd": params.Cwd, "mcpServers": params.McpServers}))
+	_, err = s.sessions.UpdateSession(params.SessionID, mergeMaps(params.Metadata, map[string]any{"cwd": params.Cwd, "mcpServers": params.McpServers, "workspaceFolders": folders}))
 	if err != nil {
 		return acp.LoadSessionResponse{}, err
 	}
@@ -609,12 +856,21 @@ func (s *Server) handleSessionLoad(ctx context.Context, raw json.RawMessage) (ac
 		} else {
 			continue
 		}
+		// Chunks of one message share its messageId; the assistant messages
+		// of a turn are numbered by segment.
+		meta := map[string]any{"messageId": msg.ID}
+		for _, key := range []string{"turnId", "segment", "segmentCount"} {
+			if v, ok := msg.Metadata[key]; ok {
+				meta[key] = v
+			}
+		}
 		for _, block := range msg.Content {
 			s.sendNotification("session/update", map[string]any{
 				"sessionId": params.SessionID,
 				"update": map[string]any{
 					"sessionUpdate": updateType,
 					"content":       block,
+					"_meta":         meta,
 				},
 			})
 		}
@@ -673,10 +929,56 @@ func (s *Server) handleSetSessionModel(raw json.RawMessage) (acp.SetSessionModel
 	if err != nil {
 		return acp.SetSessionModelResponse{}, err
 	}
+	model := s.sessions.ResolveModel(params.ModelID)
+	s.prompt.NotifyModelChanged(params.SessionID, model)
 	return acp.SetSessionModelResponse{Meta: map[string]any{
-		"previousModel": prev,
-		"newModel":      params.ModelID,
-		"changedAt":     time.Now().UTC().Format(time.RFC3339),
+		"previousModel":      prev,
+		"newModel":           model,
+		"changedAt":          time.Now().UTC().Format(time.RFC3339),
+		"promptCapabilities": map[string]any{"image": s.prompt.SupportsImages(model), "audio": s.prompt.SupportsAudio(model)},
+	}}, nil
+}
+
+// handleSetSessionCwd moves a session to another working directory: later
+// tool calls resolve paths against it and cursor-agent runs there.
+func (s *Server) handleSetSessionCwd(raw json.RawMessage) (acp.SetSessionCwdResponse, error) {
+	params, err := decodeParams[acp.SetSessionCwdRequest](raw)
+	if err != nil {
+		return acp.SetSessionCwdResponse{}, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return acp.SetSessionCwdResponse{}, fmt.Errorf("sessionId is required")
+	}
+	if strings.TrimSpace(params.Cwd) == "" {
+		return acp.SetSessionCwdResponse{}, fmt.Errorf("cwd (working directory) is required and must be a non-empty string")
+	}
+	if !isAbsPath(params.Cwd) {
+		return acp.SetSessionCwdResponse{}, fmt.Errorf("cwd must be an absolute path (per ACP spec)")
+	}
+	cwd := filepath.Clean(params.Cwd)
+	if info, err := os.Stat(cwd); err != nil {
+		return acp.SetSessionCwdResponse{}, errcode.New(errcode.InvalidParams, "cwd is not accessible: %w", err)
+	} else if !info.IsDir() {
+		return acp.SetSessionCwdResponse{}, errcode.New(errcode.InvalidParams, "cwd must be a directory: %s", cwd)
+	}
+	if !s.sessions.HasSession(params.SessionID) {
+		return acp.SetSessionCwdResponse{}, errcode.New(errcode.SessionNotFound, "Session not found: %s", params.SessionID)
+	}
+	if s.sessions.IsProcessing(params.SessionID) {
+		return acp.SetSessionCwdResponse{}, errcode.New(errcode.SessionBusy, "Session %s is processing a prompt; cancel it before changing its working directory", params.SessionID)
+	}
+
+	prev, err := s.sessions.SetSessionCwd(params.SessionID, cwd)
+	if err != nil {
+		return acp.SetSessionCwdResponse{}, err
+	}
+	// Cached results hold paths relative to the old directory.
+	s.tools.InvalidateResults(params.SessionID)
+	s.logger.Info("Session working directory changed", map[string]any{"sessionId": params.SessionID, "previousCwd": prev, "cwd": cwd})
+	return acp.SetSessionCwdResponse{Meta: map[string]any{
+		"previousCwd": prev,
+		"newCwd":      cwd,
+		"changedAt":   time.Now().UTC().Format(time.RFC3339),
 	}}, nil
 }
 
@@ -715,17 +1017,151 @@ func (s *Server) handleSessionDelete(raw json.RawMessage) (map[string]any, error
 	if strings.TrimSpace(params.SessionID) == "" {
 		return nil, fmt.Errorf("sessionId is required")
 	}
-	if err := s.sessions.DeleteSession(params.SessionID); err != nil {
-		return nil, err
+	if params.Force {
+		s.prompt.CancelSession(params.SessionID)
+		s.toolCalls.CancelSessionToolCalls(params.SessionID)
+		s.permissions.CancelSessionPermissionRequests(params.SessionID)
+		s.cancelSessionRPCs(params.SessionID, errSessionDeleted)
+		if err := s.sessions.DeleteSession(params.SessionID); err != nil {
+			return nil, err
+		}
+	} else {
+		// The busy check and the delete happen under the session manager's
+		// lock, so a prompt cannot start between them.
+		deleted, err := s.sessions.DeleteIdleSession(params.SessionID, func() bool {
+			return s.toolCalls.HasRunningToolCalls(params.SessionID)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, errcode.New(errcode.SessionBusy, "Session %s is processing a prompt; cancel it or pass force to delete it", params.SessionID)
+		}
+		s.permissions.CancelSessionPermissionRequests(params.SessionID)
+		s.cancelSessionRPCs(params.SessionID, errSessionDeleted)
+	}
+	if err := s.artifacts.DeleteSession(params.SessionID); err != nil {
+		s.logger.Warn("Failed to delete session artifacts", map[string]any{"sessionId": params.SessionID, "error": err.Error()})
+	}
+	if err := s.checkpoints.DeleteSession(params.SessionID); err != nil {
+		s.logger.Warn("Failed to delete session checkpoints", map[string]any{"sessionId": params.SessionID, "error": err.Error()})
 	}
+	if err := s.audit.DeleteSession(params.SessionID); err != nil {
+		s.logger.Warn("Failed to delete session audit log", map[string]any{"sessionId": params.SessionID, "error": err.Error()})
+	}
+	_ = s.cursor.CloseSession(params.SessionID)
+	s.terminals.CleanupSession(params.SessionID)
+	s.tools.CancelSession(params.SessionID)
 	return map[string]any{"sessionId": params.SessionID, "deleted": true}, nil
 }
 
+// outputLimits are the tool output caps of cfg, saving oversized output in
+// the server's artifact store.
+func (s *Server) outputLimits(cfg config.ToolsConfig) toolcall.OutputLimits {
+	return toolcall.OutputLimits{
+		Store:       s.artifacts,
+		MaxBytes:    cfg.MaxRawOutputBytes,
+		ByKind:      cfg.RawOutputLimits,
+		DiffContext: cfg.DiffContextLines,
+	}
+}
+
+// forgetSession drops the server's own state for a session the session
+// manager deleted, on request or because it expired.
+func (s *Server) forgetSession(sessionID string) {
+	s.forgetUpdates(sessionID)
+	s.forgetSessionConns(sessionID)
+}
+
+// handleSessionSubscribe sends the session's updates to the calling client
+// from now on, whether or not it created, loaded or prompted the session.
+func (s *Server) handleSessionSubscribe(ctx context.Context, raw json.RawMessage) (map[string]any, error) {
+	params, err := decodeParams[acp.SubscribeSessionRequest](raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return nil, fmt.Errorf("sessionId is required")
+	}
+	if !s.sessions.HasSession(params.SessionID) {
+		return nil, errcode.New(errcode.SessionNotFound, "Session not found: %s", params.SessionID)
+	}
+	s.subscribeSession(params.SessionID, s.connFor(ctx))
+	return map[string]any{"sessionId": params.SessionID, "subscribed": true}, nil
+}
+
+// handleSessionUnsubscribe stops the session's updates to the calling
+// client until it subscribes again.
+func (s *Server) handleSessionUnsubscribe(ctx context.Context, raw json.RawMessage) (map[string]any, error) {
+	params, err := decodeParams[acp.SubscribeSessionRequest](raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return nil, fmt.Errorf("sessionId is required")
+	}
+	wasSubscribed := s.unsubscribeSession(params.SessionID, s.connFor(ctx))
+	return map[string]any{"sessionId": params.SessionID, "subscribed": false, "wasSubscribed": wasSubscribed}, nil
+}
+
+func (s *Server) handleListCheckpoints(raw json.RawMessage) (map[string]any, error) {
+	params, err := decodeParams[acp.ListCheckpointsRequest](raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return nil, fmt.Errorf("sessionId is required")
+	}
+	if !s.sessions.HasSession(params.SessionID) {
+		return nil, errcode.New(errcode.SessionNotFound, "Session not found: %s", params.SessionID)
+	}
+	checkpoints, err := s.checkpoints.List(params.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"sessionId": params.SessionID, "checkpoints": checkpoints}, nil
+}
+
+func (s *Server) handleRestoreCheckpoint(raw json.RawMessage) (map[string]any, error) {
+	params, err := decodeParams[acp.RestoreCheckpointRequest](raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.SessionID) == "" {
+		return nil, fmt.Errorf("sessionId is required")
+	}
+	if strings.TrimSpace(params.CheckpointID) == "" {
+		return nil, fmt.Errorf("checkpointId is required")
+	}
+	if !s.sessions.HasSession(params.SessionID) {
+		return nil, errcode.New(errcode.SessionNotFound, "Session not found: %s", params.SessionID)
+	}
+	if s.sessions.IsProcessing(params.SessionID) {
+		return nil, errcode.New(errcode.SessionBusy, "Session %s is processing a prompt; cancel it before restoring a checkpoint", params.SessionID)
+	}
+	result, err := s.checkpoints.Restore(params.SessionID, params.CheckpointID)
+	if errors.Is(err, checkpoint.ErrNotFound) {
+		return nil, errcode.New(errcode.InvalidParams, "Checkpoint not found: %s", params.CheckpointID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.tools.InvalidateResults(params.SessionID)
+	return map[string]any{
+		"sessionId":  params.SessionID,
+		"restored":   true,
+		"checkpoint": result.Checkpoint,
+		"files":      result.Restored,
+		"removed":    result.Removed,
+	}, nil
+}
+
 func (s *Server) handleSessionPrompt(ctx context.Context, req jsonrpc.Request) (acp.PromptResponse, error) {
 	params, err := decodeParams[acp.PromptRequest](req.Params)
 	if err != nil {
 		return acp.PromptResponse{}, err
 	}
+	s.tools.InvalidateResults(params.SessionID)
 	requestID := ""
 	if req.ID != nil {
 		requestID = fmt.Sprint(req.ID)
@@ -745,8 +1181,11 @@ func (s *Server) handleSessionCancel(req jsonrpc.Request, raw json.RawMessage) (
 		s.prompt.CancelStream(params.RequestID)
 	}
 	s.prompt.CancelSession(params.SessionID)
+	s.tools.CancelSession(params.SessionID)
 	s.toolCalls.CancelSessionToolCalls(params.SessionID)
 	s.permissions.CancelSessionPermissionRequests(params.SessionID)
+	s.cancelSessionRPCs(params.SessionID, errSessionCancelled)
+	s.terminals.CleanupSession(params.SessionID)
 
 	if req.IsNotification() {
 		return nil, nil
@@ -758,7 +1197,7 @@ func (s *Server) handleToolsList() (acp.ToolsListResponse, error) {
 	return acp.ToolsListResponse{Tools: s.tools.ToolDescriptors()}, nil
 }
 
-func (s *Server) handleToolCall(_ context.Context, reqID any, raw json.RawMessage) (any, error) {
+func (s *Server) handleToolCall(ctx context.Context, reqID any, raw json.RawMessage) (any, error) {
 	params, err := decodeParams[acp.ToolCallRequest](raw)
 	if err != nil {
 		return nil, err
@@ -767,7 +1206,13 @@ func (s *Server) handleToolCall(_ context.Context, reqID any, raw json.RawMessag
 		return nil, fmt.Errorf("tool name is required")
 	}
 	sessionID := extractSessionID(params.Parameters)
+	ctx, span := tracing.Start(ctx, "tool "+params.Name, map[string]any{
+		"tool.name":    params.Name,
+		"tool.call_id": fmt.Sprint(reqID),
+		"session.id":   sessionID,
+	})
 	result, err := s.tools.ExecuteToolWithSession(
+		ctx,
 		tools.ToolCall{
 			ID:         fmt.Sprint(reqID),
 			Name:       params.Name,
@@ -775,15 +1220,75 @@ func (s *Server) handleToolCall(_ context.Context, reqID any, raw json.RawMessag
 		},
 		sessionID,
 	)
+	if err == nil && !result.Success {
+		if violations, ok := result.Metadata["violations"].([]jsonschema.Violation); ok {
+			err = &jsonrpc.Error{Code: jsonrpc.InvalidParams, Message: result.Error, Data: map[string]any{"tool": params.Name, "violations": violations}}
+		} else if code, ok := result.Metadata["errorCode"].(errcode.Code); ok {
+			err = errcode.New(code, "%s", result.Error)
+		} else {
+			err = errors.New(result.Error)
+		}
+	}
+	span.End(err)
 	if err != nil {
 		return nil, err
 	}
-	if !result.Success {
-		return nil, errors.New(result.Error)
-	}
 	return result, nil
 }
 
+// requestClientPermission asks the client to approve a tool call via
+// session/request_permission. Any failure is treated as a rejection.
+func (s *Server) requestClientPermission(params permissions.RequestPermissionParams) permissions.PermissionOutcome {
+	start := time.Now()
+	outcome := s.askClientPermission(params)
+	if s.audit != nil {
+		title, _ := params.ToolCall["title"].(string)
+		toolCallID, _ := params.ToolCall["toolCallId"].(string)
+		if title == "" {
+			title = toolCallID
+		}
+		decision := outcome.Outcome
+		if outcome.OptionID != "" {
+			decision = outcome.OptionID
+		}
+		s.audit.Record(audit.Entry{
+			SessionID:  params.SessionID,
+			Kind:       audit.KindPermission,
+			Name:       title,
+			Outcome:    decision,
+			DurationMs: time.Since(start).Milliseconds(),
+			Details:    map[string]any{"toolCallId": toolCallID},
+		})
+	}
+	return outcome
+}
+
+func (s *Server) askClientPermission(params permissions.RequestPermissionParams) permissions.PermissionOutcome {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := s.callSessionClient(ctx, params.SessionID, "session/request_permission", params)
+	if err != nil {
+		s.logger.Warn("Permission request failed", map[string]any{"sessionId": params.SessionID, "error": err.Error()})
+		return permissions.PermissionOutcome{Outcome: "selected", OptionID: "reject-once"}
+	}
+	var response struct {
+		Outcome json.RawMessage `json:"outcome"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return permissions.PermissionOutcome{Outcome: "selected", OptionID: "reject-once"}
+	}
+	var outcome permissions.PermissionOutcome
+	if err := json.Unmarshal(response.Outcome, &outcome); err != nil {
+		// Older clients return the outcome fields at the top level.
+		_ = json.Unmarshal(result, &outcome)
+	}
+	if outcome.Outcome == "" {
+		return permissions.PermissionOutcome{Outcome: "selected", OptionID: "reject-once"}
+	}
+	return outcome
+}
+
 func (s *Server) handleRequestPermission(req jsonrpc.Request) (any, error) {
 	resp, err := s.permissions.HandlePermissionRequest(req)
 	if err != nil {
@@ -812,16 +1317,111 @@ func (s *Server) sendAvailableCommandsUpdate(sessionID string) {
 }
 
 func (s *Server) sendNotification(method string, params any) {
-	message := map[string]any{
+	s.sendMessageNotification(map[string]any{
 		"jsonrpc": jsonrpc.Version,
 		"method":  method,
 		"params":  params,
+	})
+}
+
+// sendMessageNotification writes a notification to every connection using
+// its session (all connections when it has none), skipping clients whose
+// capabilities say they cannot handle it. Every session/update is queued
+// under its session's lock with _meta.sequence (increasing by one per
+// session, so a client can spot reordering or loss) and, during a prompt,
+// _meta.turnId; the writes happen after the lock is released.
+func (s *Server) sendMessageNotification(message map[string]any) {
+	for _, conn := range s.queueNotification(message) {
+		s.flush(conn)
 	}
-	s.writeMessage(message)
+}
+
+// queueNotification stamps message and queues it to its connections,
+// returning those it was queued to.
+func (s *Server) queueNotification(message map[string]any) []*connection {
+	method, _ := message["method"].(string)
+	params, _ := message["params"].(map[string]any)
+	update, _ := params["update"].(map[string]any)
+	kind, _ := update["sessionUpdate"].(string)
+	sessionID, _ := params["sessionId"].(string)
+
+	targets := s.allConnections()
+	if sessionID != "" {
+		targets = s.sessionConnections(sessionID)
+	}
+	var buf []byte
+	if method == "session/update" && sessionID != "" {
+		turnID := ""
+		if s.prompt != nil {
+			turnID = s.prompt.CurrentTurn(sessionID)
+		}
+		state := s.updatesFor(sessionID)
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		state.seq++
+		message = stampUpdate(message, params, state.seq, turnID)
+		if turnID != "" {
+			state.countTurnUpdate(message, turnID, kind)
+		}
+		if s.replayUpdates > 0 {
+			var err error
+			if buf, err = json.Marshal(message); err != nil {
+				s.logger.Error("failed to serialize message", map[string]any{"error": err.Error()})
+				return nil
+			}
+			state.record(buf, s.replayUpdates)
+		}
+	}
+	queued := make([]*connection, 0, len(targets))
+	for _, conn := range targets {
+		if !conn.caps.SupportsNotification(method) {
+			s.logger.Debug("Skipping notification the client does not support", map[string]any{"method": method, "connection": conn.id})
+			continue
+		}
+		if method == "session/update" && !conn.caps.SupportsSessionUpdate(kind) {
+			s.logger.Debug("Skipping session update the client does not support", map[string]any{"sessionUpdate": kind, "connection": conn.id})
+			continue
+		}
+		if kind == "user_message_chunk" && isEcho(update) && !s.echoAll && !conn.caps.EchoUserMessages() {
+			continue
+		}
+		if buf == nil {
+			var err error
+			if buf, err = json.Marshal(message); err != nil {
+				s.logger.Error("failed to serialize message", map[string]any{"error": err.Error()})
+				return queued
+			}
+		}
+		if s.enqueue(conn, buf) {
+			queued = append(queued, conn)
+		}
+	}
+	return queued
+}
+
+// stampUpdate returns message with the sequence number and turn added to
+// its params' _meta, leaving the caller's maps untouched.
+func stampUpdate(message, params map[string]any, seq uint64, turnID string) map[string]any {
+	meta := map[string]any{}
+	if existing, ok := params["_meta"].(map[string]any); ok {
+		maps.Copy(meta, existing)
+	}
+	meta["sequence"] = seq
+	if turnID != "" {
+		meta["turnId"] = turnID
+	}
+	stamped := maps.Clone(params)
+	stamped["_meta"] = meta
+	out := maps.Clone(message)
+	out["params"] = stamped
+	return out
 }
 
 func (s *Server) registerDefaultCommands() {
 	_ = s.slash.RegisterCommand("plan", "Create a detailed implementation plan", "description of what to plan")
+	_ = s.slash.RegisterCommand("retry", "Send the previous prompt again, optionally with extra instructions", "extra instructions")
+	_ = s.slash.RegisterCommand("undo", "Remove the last exchange from the conversation; /undo restore also reverts its file changes", "restore")
+	_ = s.slash.RegisterCommand("template", "Expand a prompt template from the config or .cursor/templates (/template list shows them)", "list | <name> key=value...")
 	s.refreshModelCommand()
 }
 
@@ -832,6 +1432,13 @@ func (s *Server) refreshModelCommand() {
 		modelNames = append(modelNames, model.ID)
 	}
 	description := "Switch to a different model. Available: " + strings.Join(modelNames, ", ")
+	if aliases := s.sessions.ModelAliases(); len(aliases) > 0 {
+		names := make([]string, 0, len(aliases))
+		for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+			names = append(names, alias+" → "+aliases[alias])
+		}
+		description += ". Aliases: " + strings.Join(names, ", ")
+	}
 	if existing := s.slash.GetCommand("model"); existing != nil {
 		if existing.Description == description && existing.Input != nil && existing.Input.Hint == "model-id" {
 			return
@@ -893,7 +1500,7 @@ func extensionNamespace(name string) string {
 	return parts[0]
 }
 
-func (s *Server) ReadTextFile(params client.ReadTextFileRequest) (client.ReadTextFileResponse, error) {
+func (s *Server) ReadTextFile(ctx context.Context, params client.ReadTextFileRequest) (client.ReadTextFileResponse, error) {
 	if strings.TrimSpace(params.SessionID) == "" {
 		return client.ReadTextFileResponse{}, fmt.Errorf("sessionId is required and must be a string")
 	}
@@ -907,7 +1514,7 @@ func (s *Server) ReadTextFile(params client.ReadTextFileRequest) (client.ReadTex
 		return client.ReadTextFileResponse{}, fmt.Errorf("limit must be a positive integer")
 	}
 
-	result, err := s.callClient(context.Background(), "fs/read_text_file", params)
+	result, err := s.callSessionClient(ctx, params.SessionID, "fs/read_text_file", params)
 	if err != nil {
 		return client.ReadTextFileResponse{}, err
 	}
@@ -928,7 +1535,7 @@ func (s *Server) ReadTextFile(params client.ReadTextFileRequest) (client.ReadTex
 	return response, nil
 }
 
-func (s *Server) WriteTextFile(params client.WriteTextFileRequest) (client.WriteTextFileResponse, error) {
+func (s *Server) WriteTextFile(ctx context.Context, params client.WriteTextFileRequest) (client.WriteTextFileResponse, error) {
 	if strings.TrimSpace(params.SessionID) == "" {
 		return client.WriteTextFileResponse{}, fmt.Errorf("sessionId is required and must be a string")
 	}
@@ -936,7 +1543,9 @@ func (s *Server) WriteTextFile(params client.WriteTextFileRequest) (client.Write
 		return client.WriteTextFileResponse{}, fmt.Errorf("path is required and must be a string")
 	}
 
-	result, err := s.callClient(context.Background(), "fs/write_text_file", params)
+	start := time.Now()
+	result, err := s.callSessionClient(ctx, params.SessionID, "fs/write_text_file", params)
+	s.recordAudit(audit.KindFileWrite, params.SessionID, params.Path, start, err, map[string]any{"bytes": len(params.Content)})
 	if err != nil {
 		return client.WriteTextFileResponse{}, err
 	}
@@ -951,7 +1560,26 @@ func (s *Server) WriteTextFile(params client.WriteTextFileRequest) (client.Write
 	return response, nil
 }
 
-func (s *Server) CreateTerminal(params client.CreateTerminalRequest) (client.CreateTerminalResponse, error) {
+func (s *Server) ListDirectory(ctx context.Context, params client.ListDirectoryRequest) (client.ListDirectoryResponse, error) {
+	if strings.TrimSpace(params.SessionID) == "" {
+		return client.ListDirectoryResponse{}, fmt.Errorf("sessionId is required and must be a string")
+	}
+	if strings.TrimSpace(params.Path) == "" {
+		return client.ListDirectoryResponse{}, fmt.Errorf("path is required and must be a string")
+	}
+
+	result, err := s.callSessionClient(ctx, params.SessionID, "fs/list_directory", params)
+	if err != nil {
+		return client.ListDirectoryResponse{}, err
+	}
+	var response client.ListDirectoryResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return client.ListDirectoryResponse{}, fmt.Errorf("invalid fs/list_directory response: %w", err)
+	}
+	return response, nil
+}
+
+func (s *Server) CreateTerminal(ctx context.Context, params client.CreateTerminalRequest) (client.CreateTerminalResponse, error) {
 	if strings.TrimSpace(params.SessionID) == "" {
 		return client.CreateTerminalResponse{}, fmt.Errorf("sessionId is required and must be a string")
 	}
@@ -959,7 +1587,9 @@ func (s *Server) CreateTerminal(params client.CreateTerminalRequest) (client.Cre
 		return client.CreateTerminalResponse{}, fmt.Errorf("command is required and must be a string")
 	}
 
-	result, err := s.callClient(context.Background(), "terminal/create", params)
+	start := time.Now()
+	result, err := s.callSessionClient(ctx, params.SessionID, "terminal/create", params)
+	s.recordAudit(audit.KindTerminal, params.SessionID, strings.TrimSpace(params.Command+" "+strings.Join(params.Args, " ")), start, err, map[string]any{"cwd": params.Cwd})
 	if err != nil {
 		return client.CreateTerminalResponse{}, err
 	}
@@ -978,15 +1608,18 @@ func (s *Server) CreateTerminal(params client.CreateTerminalRequest) (client.Cre
 	if strings.TrimSpace(response.TerminalID) == "" {
 		return client.CreateTerminalResponse{}, fmt.Errorf("invalid terminal/create response: terminalId is required")
 	}
+	s.connsMu.Lock()
+	s.terminalConns[response.TerminalID] = s.clientConnFor(ctx, params.SessionID)
+	s.connsMu.Unlock()
 	return response, nil
 }
 
-func (s *Server) GetTerminalOutput(params client.TerminalOutputRequest) (client.TerminalOutputResponse, error) {
+func (s *Server) GetTerminalOutput(ctx context.Context, params client.TerminalOutputRequest) (client.TerminalOutputResponse, error) {
 	if strings.TrimSpace(params.TerminalID) == "" {
 		return client.TerminalOutputResponse{}, fmt.Errorf("terminalId is required and must be a string")
 	}
 
-	result, err := s.callClient(context.Background(), "terminal/output", params)
+	result, err := s.callSessionClient(ctx, params.SessionID, "terminal/output", params)
 	if err != nil {
 		return client.TerminalOutputResponse{}, err
 	}
@@ -997,12 +1630,16 @@ func (s *Server) GetTerminalOutput(params client.TerminalOutputRequest) (client.
 	return response, nil
 }
 
-func (s *Server) WaitForTerminalExit(params client.WaitForTerminalExitRequest) (client.WaitForTerminalExitResponse, error) {
+func (s *Server) WaitForTerminalExit(ctx context.Context, params client.WaitForTerminalExitRequest) (client.WaitForTerminalExitResponse, error) {
 	if strings.TrimSpace(params.TerminalID) == "" {
 		return client.WaitForTerminalExitResponse{}, fmt.Errorf("terminalId is required and must be a string")
 	}
 
-	result, err := s.callClient(context.Background(), "terminal/wait_for_exit", params)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = withClientWatch(ctx, s.terminalExitWatch(params))
+	result, err := s.callSessionClient(ctx, params.SessionID, "terminal/wait_for_exit", params)
 	if err != nil {
 		return client.WaitForTerminalExitResponse{}, err
 	}
@@ -1013,34 +1650,178 @@ func (s *Server) WaitForTerminalExit(params client.WaitForTerminalExitRequest) (
 	return response, nil
 }
 
-func (s *Server) KillTerminal(params client.KillTerminalRequest) error {
+// terminalExitWatch lets terminal/wait_for_exit wait as long as the command
+// runs: every clientRequests.heartbeatMs it asks for the terminal's output,
+// and gives up once the client no longer knows the terminal or stops
+// answering.
+func (s *Server) terminalExitWatch(params client.WaitForTerminalExitRequest) *clientWatch {
+	return &clientWatch{
+		interval: time.Duration(s.Config().ClientRequests.HeartbeatMs) * time.Millisecond,
+		probe: func(ctx context.Context) error {
+			_, err := s.callClient(ctx, "terminal/output", client.TerminalOutputRequest{SessionID: params.SessionID, TerminalID: params.TerminalID})
+			return err
+		},
+		onAbandon: func(cause error) {
+			s.logger.Debug("Stopped waiting for terminal exit", map[string]any{"sessionId": params.SessionID, "terminalId": params.TerminalID, "reason": cause.Error()})
+		},
+	}
+}
+
+func (s *Server) KillTerminal(ctx context.Context, params client.KillTerminalRequest) error {
 	if strings.TrimSpace(params.TerminalID) == "" {
 		return fmt.Errorf("terminalId is required and must be a string")
 	}
-	_, err := s.callClient(context.Background(), "terminal/kill", params)
+	_, err := s.callClient(s.terminalContext(ctx, params.TerminalID, false), "terminal/kill", params)
 	return err
 }
 
-func (s *Server) ReleaseTerminal(params client.ReleaseTerminalRequest) error {
+func (s *Server) ReleaseTerminal(ctx context.Context, params client.ReleaseTerminalRequest) error {
 	if strings.TrimSpace(params.TerminalID) == "" {
 		return fmt.Errorf("terminalId is required and must be a string")
 	}
-	_, err := s.callClient(context.Background(), "terminal/release", params)
+	_, err := s.callClient(s.terminalContext(ctx, params.TerminalID, true), "terminal/release", params)
 	return err
 }
 
+// terminalContext routes a request for terminalID to the connection whose
+// client created it, forgetting the terminal when release is set.
+func (s *Server) terminalContext(ctx context.Context, terminalID string, release bool) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conn, ok := s.terminalConns[terminalID]
+	if release {
+		delete(s.terminalConns, terminalID)
+	}
+	if !ok {
+		return ctx
+	}
+	return withConnection(ctx, conn)
+}
+
+// retryableClientMethods are the client requests that are safe to send again
+// when the first attempt timed out.
+var retryableClientMethods = map[string]bool{
+	"fs/read_text_file": true,
+	"fs/list_directory": true,
+}
+
+// errClientTimeout is the cause of a single client request outliving its
+// clientRequests timeout.
+var errClientTimeout = errors.New("client request timed out")
+
+// clientTimeoutError reports a client request that went unanswered within
+// its configured timeout on every attempt.
+type clientTimeoutError struct {
+	method   string
+	timeout  time.Duration
+	attempts int
+}
+
+func (e *clientTimeoutError) Error() string {
+	attempts := "1 attempt"
+	if e.attempts > 1 {
+		attempts = fmt.Sprintf("%d attempts", e.attempts)
+	}
+	return fmt.Sprintf("client %s timed out after %s (%s; clientRequests.timeouts.%s raises the limit)", e.method, e.timeout, attempts, e.method)
+}
+
+func (e *clientTimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// clientRequestTimeout is the limit clientRequests sets for method; 0 means
+// none. terminal/wait_for_exit lasts as long as the command, with the
+// heartbeat noticing a client that went away, so clientRequests.timeoutMs
+// does not apply to it.
+func clientRequestTimeout(cfg config.ClientRequestsConfig, method string) time.Duration {
+	ms, ok := cfg.Timeouts[method]
+	if !ok && method != "terminal/wait_for_exit" {
+		ms = cfg.TimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// callClient sends a request to the client of the connection in ctx (the
+// stdio client by default) and waits for its answer, at most the method's
+// clientRequests timeout. Idempotent reads that time out are sent again up
+// to clientRequests.retries times.
 func (s *Server) callClient(ctx context.Context, method string, params any) (json.RawMessage, error) {
 	if strings.TrimSpace(method) == "" {
 		return nil, fmt.Errorf("client method is required")
 	}
+	conn := s.connFor(ctx)
+	if capability, ok := conn.caps.SupportsMethod(method); !ok {
+		return nil, errcode.New(errcode.ClientUnsupported, "client does not support %s (clientCapabilities.%s is not set)", method, capability)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cfg := s.Config().ClientRequests
+	timeout := clientRequestTimeout(cfg, method)
+	attempts := 1
+	if retryableClientMethods[method] {
+		attempts += cfg.Retries
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := s.sendClientRequest(ctx, conn, method, params, timeout)
+		if !errors.Is(err, errClientTimeout) {
+			return result, err
+		}
+		if attempt >= attempts {
+			return nil, errcode.Wrap(errcode.ClientTimeout, &clientTimeoutError{method: method, timeout: timeout, attempts: attempt})
+		}
+		s.logger.Warn("Client request timed out, retrying", map[string]any{"method": method, "attempt": attempt, "timeoutMs": timeout.Milliseconds()})
+		select {
+		case <-time.After(time.Duration(cfg.RetryDelayMs) * time.Millisecond):
+		case <-s.shutdownCh:
+			return nil, errcode.New(errcode.Cancelled, "client %s cancelled: adapter shutting down", method)
+		case <-conn.closed:
+			return nil, errcode.New(errcode.Cancelled, "client %s cancelled: client disconnected", method)
+		case <-ctx.Done():
+			return nil, errcode.New(errcode.Cancelled, "client %s cancelled: %w", method, context.Cause(ctx))
+		}
+	}
+}
+
+// clientWatch keeps a long-lived client request in check: probe runs every
+// interval while the client has not answered, and an error from it abandons
+// the request. onAbandon runs when the request ends without an answer.
+type clientWatch struct {
+	interval  time.Duration
+	probe     func(ctx context.Context) error
+	onAbandon func(cause error)
+}
 
+type clientWatchKey struct{}
+
+// withClientWatch attaches w to the client request made with ctx; requests
+// made from within the probe are not watched.
+func withClientWatch(ctx context.Context, w *clientWatch) context.Context {
+	return context.WithValue(ctx, clientWatchKey{}, w)
+}
+
+func clientWatchFor(ctx context.Context) *clientWatch {
+	w, _ := ctx.Value(clientWatchKey{}).(*clientWatch)
+	return w
+}
+
+// sendClientRequest makes one attempt at a client request. It returns
+// errClientTimeout when timeout, rather than ctx, ends the wait.
+func (s *Server) sendClientRequest(ctx context.Context, conn *connection, method string, params any, timeout time.Duration) (result json.RawMessage, err error) {
 	requestID := fmt.Sprintf("client_%d", atomic.AddUint64(&s.clientRPCSeq, 1))
 	waiter := make(chan clientRPCResponse, 1)
-	s.pendingMu.Lock()
-	s.pendingClientRPC[requestID] = waiter
-	s.pendingMu.Unlock()
+	conn.pendingMu.Lock()
+	conn.pending[requestID] = waiter
+	conn.pendingMu.Unlock()
+	forget := func() {
+		conn.pendingMu.Lock()
+		delete(conn.pending, requestID)
+		conn.pendingMu.Unlock()
+	}
 
-	s.writeMessage(map[string]any{
+	s.writeMessageTo(conn, map[string]any{
 		"jsonrpc": jsonrpc.Version,
 		"id":      requestID,
 		"method":  method,
@@ -1048,43 +1829,138 @@ func (s *Server) callClient(ctx context.Context, method string, params any) (jso
 	})
 
 	waitCtx := ctx
-	if waitCtx == nil {
-		waitCtx = context.Background()
-	}
-	if _, hasDeadline := waitCtx.Deadline(); !hasDeadline {
+	if timeout > 0 {
 		var cancel context.CancelFunc
-		waitCtx, cancel = context.WithTimeout(waitCtx, 90*time.Second)
+		waitCtx, cancel = context.WithTimeoutCause(ctx, timeout, errClientTimeout)
 		defer cancel()
 	}
 
-	select {
-	case resp := <-waiter:
-		if resp.Error != nil {
-			if resp.Error.Data != nil {
-				return nil, fmt.Errorf("client %s failed: %s (code=%d, data=%v)", method, resp.Error.Message, resp.Error.Code, resp.Error.Data)
+	var heartbeat <-chan time.Time
+	probeErr := make(chan error, 1)
+	probing := false
+	watch := clientWatchFor(ctx)
+	if watch != nil && watch.interval > 0 {
+		ticker := time.NewTicker(watch.interval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	probeCtx, cancelProbe := context.WithCancel(withClientWatch(waitCtx, nil))
+	defer cancelProbe()
+	answered := false
+	if watch != nil && watch.onAbandon != nil {
+		defer func() {
+			if !answered {
+				watch.onAbandon(err)
 			}
-			return nil, fmt.Errorf("client %s failed: %s (code=%d)", method, resp.Error.Message, resp.Error.Code)
-		}
-		if len(resp.Result) == 0 {
-			return json.RawMessage(`null`), nil
+		}()
+	}
+
+	for {
+		select {
+		case <-heartbeat:
+			if !probing {
+				probing = true
+				go func() { probeErr <- watch.probe(probeCtx) }()
+			}
+		case perr := <-probeErr:
+			probing = false
+			if perr != nil && probeCtx.Err() == nil {
+				forget()
+				return nil, fmt.Errorf("client %s abandoned: heartbeat failed: %w", method, perr)
+			}
+		case resp := <-waiter:
+			answered = true
+			if resp.Error != nil {
+				if resp.Error.Data != nil {
+					return nil, fmt.Errorf("client %s failed: %s (code=%d, data=%v)", method, resp.Error.Message, resp.Error.Code, resp.Error.Data)
+				}
+				return nil, fmt.Errorf("client %s failed: %s (code=%d)", method, resp.Error.Message, resp.Error.Code)
+			}
+			if len(resp.Result) == 0 {
+				return json.RawMessage(`null`), nil
+			}
+			return resp.Result, nil
+		case <-s.shutdownCh:
+			forget()
+			return nil, errcode.New(errcode.Cancelled, "client %s cancelled: adapter shutting down", method)
+		case <-conn.closed:
+			forget()
+			return nil, errcode.New(errcode.Cancelled, "client %s cancelled: client disconnected", method)
+		case <-waitCtx.Done():
+			forget()
+			cause := context.Cause(waitCtx)
+			if errors.Is(cause, errClientTimeout) {
+				return nil, errClientTimeout
+			}
+			if errors.Is(waitCtx.Err(), context.Canceled) {
+				return nil, errcode.New(errcode.Cancelled, "client %s cancelled: %w", method, cause)
+			}
+			return nil, fmt.Errorf("client %s timed out: %w", method, waitCtx.Err())
 		}
-		return resp.Result, nil
-	case <-waitCtx.Done():
+	}
+}
+
+// callSessionClient is callClient for a request made on behalf of sessionID:
+// session/cancel and session/delete abandon it rather than waiting for the
+// client to answer.
+func (s *Server) callSessionClient(ctx context.Context, sessionID string, method string, params any) (json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancelCause(withConnection(ctx, s.clientConnFor(ctx, sessionID)))
+	defer cancel(nil)
+	if sessionID == "" {
+		return s.callClient(ctx, method, params)
+	}
+
+	s.pendingMu.Lock()
+	s.sessionRPCSeq++
+	id := s.sessionRPCSeq
+	if s.sessionRPCs[sessionID] == nil {
+		s.sessionRPCs[sessionID] = map[uint64]context.CancelCauseFunc{}
+	}
+	s.sessionRPCs[sessionID][id] = cancel
+	s.pendingMu.Unlock()
+	defer func() {
 		s.pendingMu.Lock()
-		delete(s.pendingClientRPC, requestID)
+		delete(s.sessionRPCs[sessionID], id)
+		if len(s.sessionRPCs[sessionID]) == 0 {
+			delete(s.sessionRPCs, sessionID)
+		}
 		s.pendingMu.Unlock()
-		return nil, fmt.Errorf("client %s timed out: %w", method, waitCtx.Err())
+	}()
+
+	return s.callClient(ctx, method, params)
+}
+
+var (
+	errSessionCancelled = errors.New("session cancelled")
+	errSessionDeleted   = errors.New("session deleted")
+)
+
+// cancelSessionRPCs unblocks every client request in flight for sessionID;
+// they fail with cause.
+func (s *Server) cancelSessionRPCs(sessionID string, cause error) {
+	s.pendingMu.Lock()
+	cancels := s.sessionRPCs[sessionID]
+	delete(s.sessionRPCs, sessionID)
+	s.pendingMu.Unlock()
+	if len(cancels) > 0 {
+		s.logger.Debug("Abandoning client requests of session", map[string]any{"sessionId": sessionID, "requests": len(cancels), "reason": cause.Error()})
+	}
+	for _, cancel := range cancels {
+		cancel(cause)
 	}
 }
 
-func (s *Server) handleClientRPCResponse(resp clientRPCResponse) {
+func (s *Server) handleClientRPCResponse(conn *connection, resp clientRPCResponse) {
 	responseID := fmt.Sprint(resp.ID)
-	s.pendingMu.Lock()
-	waiter, ok := s.pendingClientRPC[responseID]
+	conn.pendingMu.Lock()
+	waiter, ok := conn.pending[responseID]
 	if ok {
-		delete(s.pendingClientRPC, responseID)
+		delete(conn.pending, responseID)
 	}
-	s.pendingMu.Unlock()
+	conn.pendingMu.Unlock()
 	if !ok {
 		s.logger.Debug("No pending client RPC for response", map[string]any{"id": responseID})
 		return
@@ -1096,15 +1972,13 @@ func (s *Server) handleClientRPCResponse(resp clientRPCResponse) {
 	}
 }
 
-func (s *Server) writeMessage(v any) {
+func (s *Server) writeMessageTo(conn *connection, v any) {
 	buf, err := json.Marshal(v)
 	if err != nil {
 		s.logger.Error("failed to serialize message", map[string]any{"error": err.Error()})
 		return
 	}
-	s.stdoutMu.Lock()
-	defer s.stdoutMu.Unlock()
-	_, _ = s.stdout.Write(append(buf, '\n'))
+	s.writeTo(conn, buf)
 }
 
 func decodeParams[T any](raw json.RawMessage) (T, error) {
@@ -1133,6 +2007,25 @@ func decodeObjectParams(raw json.RawMessage) (map[string]any, error) {
 	return obj, nil
 }
 
+// workspaceFolders validates the workspaceFolders of session/new and
+// session/load and returns them cleaned, without cwd and repeats.
+func workspaceFolders(cwd string, folders []string) ([]string, error) {
+	out := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		if !isAbsPath(folder) {
+			return nil, errcode.New(errcode.InvalidParams, "workspaceFolders must be absolute paths: %q", folder)
+		}
+		folder = filepath.Clean(folder)
+		if filepath.Dir(folder) == folder {
+			return nil, errcode.New(errcode.InvalidParams, "workspaceFolders must not be a filesystem root: %q", folder)
+		}
+		if folder != filepath.Clean(cwd) && !slices.Contains(out, folder) {
+			out = append(out, folder)
+		}
+	}
+	return out, nil
+}
+
 func isAbsPath(p string) bool {
 	if filepath.IsAbs(p) {
 		return true
@@ -1153,6 +2046,36 @@ func cloneMap(in map[string]any) map[string]any {
 	return out
 }
 
+// recordAudit appends a client-mediated operation to the audit log.
+func (s *Server) recordAudit(kind, sessionID, name string, start time.Time, err error, details map[string]any) {
+	if s.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		SessionID:  sessionID,
+		Kind:       kind,
+		Name:       name,
+		Outcome:    "success",
+		DurationMs: time.Since(start).Milliseconds(),
+		Details:    details,
+	}
+	if err != nil {
+		entry.Outcome, entry.Error = "failure", err.Error()
+	}
+	s.audit.Record(entry)
+}
+
+// paramsSessionID reads params.sessionId for span attributes.
+func paramsSessionID(raw json.RawMessage) string {
+	var params struct {
+		SessionID string `json:"sessionId"`
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, &params) != nil {
+		return ""
+	}
+	return params.SessionID
+}
+
 func extractSessionID(parameters map[string]any) string {
 	if parameters == nil {
 		return ""
@@ -1170,18 +2093,6 @@ func extractSessionID(parameters map[string]any) string {
 	return ""
 }
 
-func defaultPermissionOutcome(options []permissions.PermissionOption) permissions.PermissionOutcome {
-	for _, option := range options {
-		if option.Kind == "allow_once" {
-			return permissions.PermissionOutcome{Outcome: "selected", OptionID: option.OptionID}
-		}
-	}
-	if len(options) > 0 {
-		return permissions.PermissionOutcome{Outcome: "selected", OptionID: options[0].OptionID}
-	}
-	return permissions.PermissionOutcome{Outcome: "selected", OptionID: "reject-once"}
-}
-
 func mergeMaps(parts ...map[string]any) map[string]any {
 	out := map[string]any{}
 	for _, m := range parts {
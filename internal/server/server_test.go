@@ -1,17 +1,35 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/audit"
+	"github.com/spjoes/cursor-agent-acp/internal/benchcheck"
+	"github.com/spjoes/cursor-agent-acp/internal/checkpoint"
+	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
+	"github.com/spjoes/cursor-agent-acp/internal/jsonschema"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -193,7 +211,51 @@ func TestSetSessionModeDoesNotSendCurrentModeNotification(t *testing.T) {
 	}
 }
 
-func newTestServer(t *testing.T) *Server {
+func TestSetSessionModelSendsCurrentModelUpdate(t *testing.T) {
+	s := newTestServer(t)
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": "/tmp", "mcpServers": []map[string]any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-model", "session/set_model", map[string]any{"sessionId": sessionID, "modelId": "auto"}))
+	if resp.Error != nil {
+		t.Fatalf("session/set_model failed: %+v", resp.Error)
+	}
+	if !strings.Contains(stdout.String(), `"sessionUpdate":"current_model_update"`) || !strings.Contains(stdout.String(), `"currentModelId":"auto"`) {
+		t.Fatalf("expected a current_model_update notification, got %s", stdout.String())
+	}
+
+	// A client that lists the updates it handles without this one does not get it.
+	initResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-init", "initialize", map[string]any{
+		"protocolVersion":    1,
+		"clientCapabilities": map[string]any{"_meta": map[string]any{"sessionUpdates": []any{"agent_message_chunk"}}},
+	}))
+	if initResp.Error != nil {
+		t.Fatalf("initialize failed: %+v", initResp.Error)
+	}
+	stdout.Reset()
+	if resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-model-2", "session/set_model", map[string]any{"sessionId": sessionID, "modelId": "auto"})); resp.Error != nil {
+		t.Fatalf("session/set_model failed: %+v", resp.Error)
+	}
+	if strings.Contains(stdout.String(), "current_model_update") {
+		t.Fatalf("unexpected current_model_update for a client that does not list it: %s", stdout.String())
+	}
+}
+
+// fakeCursorBatch is the fake cursor-agent of newTestServer for Windows.
+const fakeCursorBatch = "@echo off\r\n" +
+	"if \"%~1\"==\"\" exit /b 0\r\n" +
+	"if \"%~1\"==\"--version\" (echo cursor-agent 1.2.3& exit /b 0)\r\n" +
+	"if \"%~1\"==\"status\" (echo Signed in as test@example.com& exit /b 0)\r\n" +
+	"if \"%~1\"==\"create-chat\" (echo chat_test_123& exit /b 0)\r\n" +
+	"if \"%~1\"==\"models\" (echo auto& exit /b 0)\r\n" +
+	"echo {}\r\n"
+
+func newTestServer(t testing.TB) *Server {
 	t.Helper()
 
 	fakeBinDir := t.TempDir()
@@ -221,10 +283,14 @@ case "$1" in
     ;;
 esac
 `
+	if runtime.GOOS == "windows" {
+		fakeCursor += ".cmd"
+		script = fakeCursorBatch
+	}
 	if err := os.WriteFile(fakeCursor, []byte(script), 0o755); err != nil {
 		t.Fatalf("failed to write fake cursor-agent: %v", err)
 	}
-	t.Setenv("PATH", fakeBinDir+":"+os.Getenv("PATH"))
+	t.Setenv("PATH", fakeBinDir+string(os.PathListSeparator)+os.Getenv("PATH"))
 
 	cfg := config.Default()
 	cfg.SessionDir = t.TempDir()
@@ -270,3 +336,1549 @@ func splitJSONLines(input string) []string {
 	}
 	return out
 }
+
+func TestAdapterDescribeListsMethodsToolsAndExtensions(t *testing.T) {
+	s := newTestServer(t)
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-describe", "_adapter/describe", map[string]any{}))
+	if resp.Error != nil {
+		t.Fatalf("_adapter/describe failed: %+v", resp.Error)
+	}
+
+	raw, err := json.Marshal(resp.Result)
+	if err != nil {
+		t.Fatalf("failed to encode describe result: %v", err)
+	}
+	var result struct {
+		Methods []struct {
+			Method string `json:"method"`
+		} `json:"methods"`
+		SlashCommands []struct {
+			Name string `json:"name"`
+		} `json:"slashCommands"`
+		Extensions struct {
+			Methods []string `json:"methods"`
+		} `json:"extensions"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("failed to decode describe result: %v", err)
+	}
+
+	methods := map[string]bool{}
+	for _, m := range result.Methods {
+		methods[m.Method] = true
+	}
+	for _, want := range []string{"initialize", "session/new", "session/prompt", "tools/call"} {
+		if !methods[want] {
+			t.Fatalf("expected describe to include %s, got %#v", want, result.Methods)
+		}
+	}
+	if len(result.SlashCommands) == 0 {
+		t.Fatalf("expected slash commands in describe output")
+	}
+	found := false
+	for _, m := range result.Extensions.Methods {
+		if m == "_adapter/describe" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected _adapter/describe among extension methods, got %#v", result.Extensions.Methods)
+	}
+}
+
+func TestCursorRefreshReportsUnavailableCLI(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("HOME", t.TempDir()) // nor in the install locations searched after PATH
+	s := newTestServer(t)
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-refresh", "_cursor/refresh", map[string]any{}))
+	if resp.Error != nil {
+		t.Fatalf("_cursor/refresh failed: %+v", resp.Error)
+	}
+	result, ok := resp.Result.(map[string]any)
+	if !ok || result["available"] != false || result["error"] == nil {
+		t.Fatalf("expected unavailable cursor-agent, got %#v", resp.Result)
+	}
+}
+
+func TestCursorRefreshReportsBrokenCLI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	s := newTestServer(t)
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "cursor-agent"), []byte("#!/bin/sh\necho broken >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-refresh", "_cursor/refresh", map[string]any{}))
+	if resp.Error != nil {
+		t.Fatalf("_cursor/refresh failed: %+v", resp.Error)
+	}
+	result, ok := resp.Result.(map[string]any)
+	if !ok || result["available"] != false || result["error"] == nil {
+		t.Fatalf("expected the failing cursor-agent to be reported unavailable, got %#v", resp.Result)
+	}
+}
+
+func TestAdapterStatusAndMetrics(t *testing.T) {
+	s := newTestServer(t)
+	if resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-tools", "tools/list", map[string]any{})); resp.Error != nil {
+		t.Fatalf("tools/list failed: %+v", resp.Error)
+	}
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-status", "_adapter/status", map[string]any{}))
+	if resp.Error != nil {
+		t.Fatalf("_adapter/status failed: %+v", resp.Error)
+	}
+	status, ok := resp.Result.(map[string]any)
+	if !ok || status["activeStreams"] != 0 || status["components"] == nil {
+		t.Fatalf("unexpected status: %#v", resp.Result)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-metrics", "_adapter/metrics", map[string]any{}))
+	if resp.Error != nil {
+		t.Fatalf("_adapter/metrics failed: %+v", resp.Error)
+	}
+	metrics, ok := resp.Result.(map[string]any)
+	if !ok || metrics["toolCalls"] == nil || metrics["permissions"] == nil || metrics["tools"] == nil {
+		t.Fatalf("unexpected metrics: %#v", resp.Result)
+	}
+	requests, _ := metrics["requests"].(map[string]any)
+	for _, method := range []string{"tools/list", "_adapter/status"} {
+		stats, ok := requests[method].(map[string]any)
+		if !ok || stats["count"] != int64(1) {
+			t.Fatalf("expected one %s request in metrics, got %#v", method, requests)
+		}
+	}
+}
+
+func TestAuditListReturnsToolExecutions(t *testing.T) {
+	s := newTestServer(t)
+	s.audit = audit.New(config.AuditConfig{Enabled: true, Dir: t.TempDir()}, logging.New("error"))
+	s.tools.SetAuditLog(s.audit)
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-tool", "tools/call", map[string]any{
+		"name":       "no_such_tool",
+		"parameters": map[string]any{"sessionId": "sess-audit", "path": "main.go"},
+	}))
+	if resp.Error == nil {
+		t.Fatalf("expected unknown tool to fail, got %#v", resp.Result)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-audit", "_audit/list", map[string]any{"sessionId": "sess-audit", "kind": "tool"}))
+	if resp.Error != nil {
+		t.Fatalf("_audit/list failed: %+v", resp.Error)
+	}
+	result, _ := resp.Result.(map[string]any)
+	entries, _ := result["entries"].([]audit.Entry)
+	if len(entries) != 1 || entries[0].Name != "no_such_tool" || entries[0].Outcome != "failure" || entries[0].Details["locations"] == nil {
+		t.Fatalf("unexpected audit entries: %#v", resp.Result)
+	}
+}
+
+func TestToolCallReportsSchemaViolationsAsInvalidParams(t *testing.T) {
+	s := newTestServer(t)
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-tool", "tools/call", map[string]any{
+		"name":       "git_blame",
+		"parameters": map[string]any{"path": 42, "start_line": 0},
+	}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.InvalidParams {
+		t.Fatalf("expected an invalid params error, got %#v", resp)
+	}
+	data, _ := resp.Error.Data.(map[string]any)
+	violations, _ := data["violations"].([]jsonschema.Violation)
+	want := []jsonschema.Violation{
+		{Path: "/path", Message: "must be string, got number"},
+		{Path: "/start_line", Message: "must be at least 1"},
+	}
+	if data["tool"] != "git_blame" || !reflect.DeepEqual(violations, want) {
+		t.Fatalf("unexpected error data %#v", resp.Error.Data)
+	}
+}
+
+func TestRunCommandRefusesForbiddenCommands(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-tool", "tools/call", map[string]any{
+		"name":       "run_command",
+		"parameters": map[string]any{"sessionId": "sess-1", "command": "bash", "args": []any{"-c", "cd / && rm -rf ."}},
+	}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "forbidden") {
+		t.Fatalf("expected the command to be refused, got %#v", resp)
+	}
+	for _, method := range []string{"session/request_permission", "terminal/create"} {
+		if strings.Contains(stdout.String(), method) {
+			t.Fatalf("refused command still sent %s: %s", method, stdout.String())
+		}
+	}
+}
+
+func TestHandlerErrorsCarryErrorCodes(t *testing.T) {
+	s := newTestServer(t)
+
+	cases := []struct {
+		method  string
+		params  map[string]any
+		rpcCode int
+		code    errcode.Code
+	}{
+		{"session/set_mode", map[string]any{"sessionId": "missing", "modeId": "ask"}, jsonrpc.SessionNotFound, errcode.SessionNotFound},
+		{"tools/call", map[string]any{"name": "no_such_tool", "parameters": map[string]any{}}, jsonrpc.ToolNotFound, errcode.ToolNotFound},
+		{"session/new", map[string]any{"mcpServers": []any{}}, jsonrpc.InvalidParams, errcode.InvalidParams},
+		{"_no/such_method", map[string]any{}, jsonrpc.MethodNotFound, errcode.MethodNotFound},
+	}
+	for _, tc := range cases {
+		resp, _ := s.processRequest(context.Background(), mustRequest(t, "req", tc.method, tc.params))
+		if resp.Error == nil || resp.Error.Code != tc.rpcCode {
+			t.Fatalf("%s: expected code %d, got %#v", tc.method, tc.rpcCode, resp.Error)
+		}
+		if data, _ := resp.Error.Data.(map[string]any); data["code"] != string(tc.code) {
+			t.Fatalf("%s: expected data.code %s, got %#v", tc.method, tc.code, resp.Error.Data)
+		}
+	}
+}
+
+func TestSetSessionCwd(t *testing.T) {
+	s := newTestServer(t)
+	root := t.TempDir()
+	sub := filepath.Join(root, "pkg")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": root, "mcpServers": []any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+
+	for _, cwd := range []string{"pkg", filepath.Join(root, "missing"), filepath.Join(root, "file.txt")} {
+		resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-bad", "session/set_cwd", map[string]any{"sessionId": sessionID, "cwd": cwd}))
+		if resp.Error == nil || resp.Error.Code != jsonrpc.InvalidParams {
+			t.Fatalf("expected %q to be rejected, got %#v", cwd, resp)
+		}
+	}
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-cwd", "session/set_cwd", map[string]any{"sessionId": sessionID, "cwd": sub + string(filepath.Separator)}))
+	if resp.Error != nil {
+		t.Fatalf("session/set_cwd failed: %+v", resp.Error)
+	}
+	meta := resp.Result.(acp.SetSessionCwdResponse).Meta
+	if meta["previousCwd"] != root || meta["newCwd"] != sub {
+		t.Fatalf("unexpected response meta %v", meta)
+	}
+	if got := s.sessions.GetSessionCwd(sessionID); got != sub {
+		t.Fatalf("session cwd = %q, want %q", got, sub)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-missing", "session/set_cwd", map[string]any{"sessionId": "missing", "cwd": sub}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.SessionNotFound {
+		t.Fatalf("expected session not found, got %#v", resp)
+	}
+}
+
+func TestSessionWorkspaceFolders(t *testing.T) {
+	s := newTestServer(t)
+	root := t.TempDir()
+	other := t.TempDir()
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-bad", "session/new", map[string]any{"cwd": root, "mcpServers": []any{}, "workspaceFolders": []any{"relative"}}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.InvalidParams {
+		t.Fatalf("expected relative workspace folder to be rejected, got %#v", resp)
+	}
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-root", "session/new", map[string]any{"cwd": root, "mcpServers": []any{}, "workspaceFolders": []any{filepath.VolumeName(root) + string(filepath.Separator)}}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.InvalidParams {
+		t.Fatalf("expected a filesystem root workspace folder to be rejected, got %#v", resp)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": root, "mcpServers": []any{}, "workspaceFolders": []any{root, other + "/", other}}))
+	if resp.Error != nil {
+		t.Fatalf("session/new failed: %+v", resp.Error)
+	}
+	result := resp.Result.(acp.NewSessionResponse)
+	if got := s.sessions.GetWorkspaceFolders(result.SessionID); len(got) != 1 || got[0] != other {
+		t.Fatalf("workspace folders = %v, want [%s]", got, other)
+	}
+	if folders, _ := result.Meta["workspaceFolders"].([]string); len(folders) != 1 {
+		t.Fatalf("expected workspaceFolders in response meta, got %v", result.Meta)
+	}
+}
+
+func TestSetLogLevelAtRuntime(t *testing.T) {
+	s := newTestServer(t)
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-level", "_logging/set_level", map[string]any{"level": "debug"}))
+	if resp.Error != nil {
+		t.Fatalf("_logging/set_level failed: %+v", resp.Error)
+	}
+	result, _ := resp.Result.(map[string]any)
+	if result["level"] != "debug" || result["previous"] != "error" || s.logger.Level() != logging.DebugLevel {
+		t.Fatalf("unexpected result %#v (logger at %s)", resp.Result, s.logger.Level())
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-bad-level", "_logging/set_level", map[string]any{"level": "verbose"}))
+	if resp.Error == nil || s.logger.Level() != logging.DebugLevel {
+		t.Fatalf("expected invalid level to be rejected, got %#v", resp)
+	}
+}
+
+func TestArtifactsGetPagesThroughArtifacts(t *testing.T) {
+	s := newTestServer(t)
+	text, err := s.artifacts.Put("sess-art", "out.txt", "text/plain", []byte("héllo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := s.artifacts.Put("sess-art", "out.bin", "application/octet-stream", []byte{0, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(params map[string]any) map[string]any {
+		t.Helper()
+		resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-art", "_artifacts/get", params))
+		if resp.Error != nil {
+			t.Fatalf("_artifacts/get %v failed: %+v", params, resp.Error)
+		}
+		return resp.Result.(map[string]any)
+	}
+	// The page ends inside "é", which is left for the next one.
+	first := get(map[string]any{"uri": text.URI(), "limit": 2})
+	if first["text"] != "h" || first["nextOffset"] != int64(1) || first["complete"] != false {
+		t.Fatalf("unexpected first page %#v", first)
+	}
+	rest := get(map[string]any{"sessionId": "sess-art", "artifactId": text.ID, "offset": 1})
+	if rest["text"] != "éllo" || rest["complete"] != true {
+		t.Fatalf("unexpected second page %#v", rest)
+	}
+	if got := get(map[string]any{"uri": binary.URI()}); got["blob"] != "AAEC" {
+		t.Fatalf("expected binary artifacts base64 encoded, got %#v", got)
+	}
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-art-missing", "_artifacts/get", map[string]any{"uri": "artifact://sess-art/nope"}))
+	if resp.Error == nil {
+		t.Fatal("expected an unknown artifact to be rejected")
+	}
+}
+
+func TestApplyConfigReloadsSafeSettings(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	next := s.Config()
+	gitEnabled := next.Tools.Git.Enabled
+	next.LogLevel = "debug"
+	next.Cursor.Timeout = 60_000
+	next.Tools.Git.Enabled = !gitEnabled
+	next.MaxSessions++
+	if err := s.ApplyConfig(next); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+
+	cfg := s.Config()
+	if s.logger.Level() != logging.DebugLevel || cfg.Cursor.Timeout != 60_000 || s.tools.HasTool("git_status") == gitEnabled {
+		t.Fatalf("expected log level, timeout and git tools to be applied, got %+v", cfg)
+	}
+	if cfg.MaxSessions == next.MaxSessions {
+		t.Fatal("expected maxSessions to wait for a restart")
+	}
+
+	var notification struct {
+		Method string `json:"method"`
+		Params struct {
+			Applied         []string `json:"applied"`
+			RequiresRestart []string `json:"requiresRestart"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &notification); err != nil {
+		t.Fatalf("expected one notification, got %q: %v", stdout.String(), err)
+	}
+	applied := strings.Join(notification.Params.Applied, ",")
+	if notification.Method != configChangedNotification || applied != "logLevel,tools.git.enabled,cursor.timeout" || strings.Join(notification.Params.RequiresRestart, ",") != "maxSessions" {
+		t.Fatalf("unexpected notification %+v", notification)
+	}
+
+	stdout.Reset()
+	if err := s.ApplyConfig(next); err != nil || stdout.Len() != 0 {
+		t.Fatalf("expected reapplying the same config to be silent, got %v %q", err, stdout.String())
+	}
+	next.Cursor.Timeout = 1
+	if err := s.ApplyConfig(next); err == nil || s.Config().Cursor.Timeout != 60_000 {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+}
+
+func TestApplyConfigReloadsTheEnvironmentPolicy(t *testing.T) {
+	s := newTestServer(t)
+	s.stdout = &bytes.Buffer{}
+
+	if err := s.terminals.CheckCommand("make", nil, "BUILD_FLAVOR"); err != nil {
+		t.Fatalf("BUILD_FLAVOR should be allowed before the reload, got %v", err)
+	}
+	next := s.Config()
+	next.Environment.Deny = append(next.Environment.Deny, "BUILD_*")
+	if err := s.ApplyConfig(next); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-tool", "tools/call", map[string]any{
+		"name":       "run_command",
+		"parameters": map[string]any{"sessionId": "sess-1", "command": "make", "env": map[string]any{"BUILD_FLAVOR": "release"}},
+	}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "BUILD_FLAVOR is not allowed by the environment policy") {
+		t.Fatalf("expected the reloaded policy to refuse BUILD_FLAVOR, got %#v", resp)
+	}
+}
+
+func TestApplyConfigReloadsToolOutputLimits(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	next := s.Config()
+	next.Tools.MaxRawOutputBytes = 64
+	if err := s.ApplyConfig(next); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	stdout.Reset()
+	id := s.toolCalls.ReportToolCall("sess-1", "run_command", map[string]any{"kind": "execute"})
+	s.toolCalls.CompleteToolCall("sess-1", id, map[string]any{"rawOutput": strings.Repeat("x", 1000)})
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	var last struct {
+		Params struct {
+			Update map[string]any `json:"update"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if summary, ok := last.Params.Update["rawOutput"].(map[string]any); !ok || summary["truncated"] != true {
+		t.Fatalf("expected the reloaded cap to truncate the output, got %v", last.Params.Update["rawOutput"])
+	}
+}
+
+func TestClientCapabilitiesGateRequestsAndNotifications(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-init", "initialize", map[string]any{
+		"protocolVersion": 1,
+		"clientCapabilities": map[string]any{
+			"fs":    map[string]any{"readTextFile": true},
+			"_meta": map[string]any{"notifications": []any{}},
+		},
+	}))
+	if resp.Error != nil {
+		t.Fatalf("initialize failed: %+v", resp.Error)
+	}
+	meta := resp.Result.(acp.InitializeResponse).AgentCapabilities["_meta"].(map[string]any)
+	if meta["terminal"] != false {
+		t.Fatalf("expected terminal to be reported unavailable, got %v", meta["terminal"])
+	}
+
+	_, err := s.CreateTerminal(context.Background(), client.CreateTerminalRequest{SessionID: "s1", Command: "ls"})
+	if err == nil || !strings.Contains(err.Error(), "clientCapabilities.terminal") {
+		t.Fatalf("expected terminal/create to be refused, got %v", err)
+	}
+	_, err = s.WriteTextFile(context.Background(), client.WriteTextFileRequest{SessionID: "s1", Path: "/tmp/x", Content: "x"})
+	if err == nil || !strings.Contains(err.Error(), "fs.writeTextFile") {
+		t.Fatalf("expected fs/write_text_file to be refused, got %v", err)
+	}
+
+	stdout.Reset()
+	s.sendNotification(modelsUpdatedNotification, map[string]any{})
+	if stdout.Len() != 0 {
+		t.Fatalf("expected the unlisted extension notification to be skipped, got %q", stdout.String())
+	}
+	s.sendNotification("session/update", map[string]any{"sessionId": "s1", "update": map[string]any{"sessionUpdate": "agent_message_chunk"}})
+	if !strings.Contains(stdout.String(), "agent_message_chunk") {
+		t.Fatalf("expected baseline session updates to be sent, got %q", stdout.String())
+	}
+}
+
+type pipeClient struct {
+	in    *io.PipeWriter
+	lines chan map[string]any
+}
+
+func connectPipeClient(t *testing.T, s *Server) *pipeClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &pipeClient{in: inW, lines: make(chan map[string]any, 32)}
+	go func() {
+		_ = s.Serve(context.Background(), inR, outW)
+		_ = outW.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			var msg map[string]any
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil {
+				c.lines <- msg
+			}
+		}
+		close(c.lines)
+	}()
+	t.Cleanup(func() { _ = inW.Close() })
+	return c
+}
+
+func (c *pipeClient) send(t *testing.T, msg map[string]any) {
+	t.Helper()
+	buf, _ := json.Marshal(msg)
+	if _, err := c.in.Write(append(buf, '\n')); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *pipeClient) next(t *testing.T) map[string]any {
+	t.Helper()
+	select {
+	case msg := <-c.lines:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+// waitFor skips messages until one has the given method.
+func (c *pipeClient) waitFor(t *testing.T, method string) map[string]any {
+	t.Helper()
+	for {
+		if msg := c.next(t); msg["method"] == method {
+			return msg
+		}
+	}
+}
+
+func (c *pipeClient) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-c.lines:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServeRoutesPerConnection(t *testing.T) {
+	s := newTestServer(t)
+	a, b := connectPipeClient(t, s), connectPipeClient(t, s)
+
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{"protocolVersion": 1, "clientCapabilities": map[string]any{"fs": map[string]any{"readTextFile": true}}}})
+	if msg := a.next(t); msg["id"] != float64(1) || msg["result"] == nil {
+		t.Fatalf("unexpected initialize response %v", msg)
+	}
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{"protocolVersion": 1, "clientCapabilities": map[string]any{}}})
+	b.next(t)
+
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}})
+	var sessionID string
+	for sessionID == "" {
+		msg := a.next(t)
+		if result, ok := msg["result"].(map[string]any); ok && msg["id"] == float64(2) {
+			sessionID, _ = result["sessionId"].(string)
+		}
+	}
+	b.expectNothing(t)
+
+	s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "agent_message_chunk"}})
+	for {
+		msg := a.waitFor(t, "session/update")
+		if update := msg["params"].(map[string]any)["update"].(map[string]any); update["sessionUpdate"] == "agent_message_chunk" {
+			break
+		}
+	}
+	b.expectNothing(t)
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: sessionID, Path: "/tmp/a.txt"})
+		if err == nil && resp.Content != "from a" {
+			err = fmt.Errorf("unexpected content %q", resp.Content)
+		}
+		done <- err
+	}()
+	req := a.waitFor(t, "fs/read_text_file")
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": req["id"], "result": map[string]any{"content": "from a"}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Once b uses the session it shares the updates, but a, which created
+	// it, still answers client requests.
+	b.send(t, map[string]any{"jsonrpc": "2.0", "method": "session/cancel", "params": map[string]any{"sessionId": sessionID}})
+	time.Sleep(50 * time.Millisecond)
+	s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "plan"}})
+	a.waitFor(t, "session/update")
+	b.waitFor(t, "session/update")
+	go func() {
+		_, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: sessionID, Path: "/tmp/a.txt"})
+		done <- err
+	}()
+	req = a.waitFor(t, "fs/read_text_file")
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": req["id"], "result": map[string]any{"content": "from a"}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading the session makes b answer client requests, which its
+	// capabilities refuse.
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/load", "params": map[string]any{"sessionId": sessionID, "cwd": t.TempDir(), "mcpServers": []any{}}})
+	for msg := b.next(t); msg["id"] != float64(2); msg = b.next(t) {
+	}
+	if _, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: sessionID, Path: "/tmp/a.txt"}); err == nil || !strings.Contains(err.Error(), "fs.readTextFile") {
+		t.Fatalf("expected b's capabilities to refuse the request, got %v", err)
+	}
+}
+
+func TestDisconnectedSessionsFallBackToStdioOnlyWhenEnabled(t *testing.T) {
+	s := newTestServer(t)
+	a := connectPipeClient(t, s)
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}})
+	var sessionID string
+	for sessionID == "" {
+		msg := a.next(t)
+		if result, ok := msg["result"].(map[string]any); ok && msg["id"] == float64(1) {
+			sessionID, _ = result["sessionId"].(string)
+		}
+	}
+	_ = a.in.Close()
+	for range a.lines {
+	}
+
+	if conns := s.sessionConnections(sessionID); len(conns) != 0 {
+		t.Fatalf("expected a disconnected session's updates to be dropped, got %d connections", len(conns))
+	}
+	if conns := s.sessionConnections("never-attached"); len(conns) != 1 || conns[0] != s.stdioConn {
+		t.Fatal("expected sessions no client attached to go to the stdio client")
+	}
+
+	s.stdioFallback = true
+	if conns := s.sessionConnections(sessionID); len(conns) != 1 || conns[0] != s.stdioConn {
+		t.Fatalf("expected stdioFallback to route to the stdio client, got %v", conns)
+	}
+}
+
+func TestSessionSubscribeAndUnsubscribe(t *testing.T) {
+	s := newTestServer(t)
+	a, b := connectPipeClient(t, s), connectPipeClient(t, s)
+
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}})
+	var sessionID string
+	for sessionID == "" {
+		msg := a.next(t)
+		if result, ok := msg["result"].(map[string]any); ok && msg["id"] == float64(1) {
+			sessionID, _ = result["sessionId"].(string)
+		}
+	}
+	update := func(kind string) {
+		s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": kind}})
+	}
+	waitForUpdate := func(c *pipeClient, kind string) {
+		t.Helper()
+		for {
+			msg := c.waitFor(t, "session/update")
+			if msg["params"].(map[string]any)["update"].(map[string]any)["sessionUpdate"] == kind {
+				return
+			}
+		}
+	}
+
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "session/subscribe", "params": map[string]any{"sessionId": "missing"}})
+	if msg := b.next(t); msg["error"] == nil {
+		t.Fatalf("expected subscribing to an unknown session to fail, got %v", msg)
+	}
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/subscribe", "params": map[string]any{"sessionId": sessionID}})
+	if msg := b.next(t); msg["result"].(map[string]any)["subscribed"] != true {
+		t.Fatalf("unexpected session/subscribe response %v", msg)
+	}
+	update("plan")
+	waitForUpdate(a, "plan")
+	waitForUpdate(b, "plan")
+	if conns := s.sessionConnections(sessionID); len(conns) != 2 || s.clientConnFor(context.Background(), sessionID) != conns[0] {
+		t.Fatal("expected the creating client, not the follower, to answer client requests")
+	}
+
+	// a stops following the session, even when it names it again.
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/unsubscribe", "params": map[string]any{"sessionId": sessionID}})
+	if msg := a.next(t); msg["result"].(map[string]any)["wasSubscribed"] != true {
+		t.Fatalf("unexpected session/unsubscribe response %v", msg)
+	}
+	a.send(t, map[string]any{"jsonrpc": "2.0", "method": "session/cancel", "params": map[string]any{"sessionId": sessionID}})
+	time.Sleep(50 * time.Millisecond)
+	update("agent_message_chunk")
+	waitForUpdate(b, "agent_message_chunk")
+	a.expectNothing(t)
+
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 3, "method": "session/subscribe", "params": map[string]any{"sessionId": sessionID}})
+	a.next(t)
+	update("agent_thought_chunk")
+	waitForUpdate(a, "agent_thought_chunk")
+}
+
+func TestPromptEchoesGoOnlyToClientsThatAskForThem(t *testing.T) {
+	s := newTestServer(t)
+	a, b := connectPipeClient(t, s), connectPipeClient(t, s)
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{
+		"protocolVersion":    1,
+		"clientCapabilities": map[string]any{"_meta": map[string]any{"echoUserMessages": true}},
+	}})
+	a.next(t)
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{"protocolVersion": 1, "clientCapabilities": map[string]any{}}})
+	b.next(t)
+
+	b.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}})
+	var sessionID string
+	for sessionID == "" {
+		if result, ok := b.next(t)["result"].(map[string]any); ok {
+			sessionID, _ = result["sessionId"].(string)
+		}
+	}
+	if s.sessionWantsEcho(sessionID) {
+		t.Fatal("expected no echo for a session whose client did not ask for it")
+	}
+	a.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/subscribe", "params": map[string]any{"sessionId": sessionID}})
+	a.next(t)
+	if !s.sessionWantsEcho(sessionID) {
+		t.Fatal("expected echo once a client that asked for it follows the session")
+	}
+
+	userChunk := func(meta map[string]any) {
+		s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "user_message_chunk", "_meta": meta}})
+	}
+	nextUserChunk := func(c *pipeClient) map[string]any {
+		t.Helper()
+		for {
+			update := c.waitFor(t, "session/update")["params"].(map[string]any)["update"].(map[string]any)
+			if update["sessionUpdate"] == "user_message_chunk" {
+				return update["_meta"].(map[string]any)
+			}
+		}
+	}
+	// Replayed user messages are not echoes and go to every client.
+	userChunk(map[string]any{"echo": true})
+	userChunk(map[string]any{"messageId": "m1"})
+	if meta := nextUserChunk(a); meta["echo"] != true {
+		t.Fatalf("expected the echo first, got %v", meta)
+	}
+	if meta := nextUserChunk(b); meta["messageId"] != "m1" {
+		t.Fatalf("expected the echo to skip the client that did not ask for it, got %v", meta)
+	}
+	nextUserChunk(a)
+}
+
+func TestReplayUpdatesReturnsMissedUpdates(t *testing.T) {
+	s := newTestServer(t)
+	s.stdout = io.Discard
+	s.replayUpdates = 3
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": t.TempDir(), "mcpServers": []map[string]any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+	for i := range 5 {
+		s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "agent_message_chunk", "content": map[string]any{"type": "text", "text": fmt.Sprint(i)}}})
+	}
+
+	replay := func(since uint64) (sequences []float64, complete bool) {
+		t.Helper()
+		resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-replay", "session/replay_updates", map[string]any{"sessionId": sessionID, "sinceSequence": since}))
+		if resp.Error != nil {
+			t.Fatalf("session/replay_updates failed: %+v", resp.Error)
+		}
+		result := resp.Result.(map[string]any)
+		if result["lastSequence"] != uint64(5) {
+			t.Fatalf("unexpected lastSequence %v", result["lastSequence"])
+		}
+		for _, raw := range result["updates"].([]json.RawMessage) {
+			var msg struct {
+				Method string `json:"method"`
+				Params struct {
+					Meta map[string]any `json:"_meta"`
+				} `json:"params"`
+			}
+			if err := json.Unmarshal(raw, &msg); err != nil || msg.Method != "session/update" {
+				t.Fatalf("unexpected replayed update %s: %v", raw, err)
+			}
+			sequences = append(sequences, msg.Params.Meta["sequence"].(float64))
+		}
+		return sequences, result["complete"].(bool)
+	}
+
+	if seqs, complete := replay(3); !slices.Equal(seqs, []float64{4, 5}) || !complete {
+		t.Fatalf("expected updates 4 and 5, got %v complete=%v", seqs, complete)
+	}
+	if seqs, complete := replay(0); !slices.Equal(seqs, []float64{3, 4, 5}) || complete {
+		t.Fatalf("expected the last three updates, incomplete, got %v complete=%v", seqs, complete)
+	}
+	if seqs, complete := replay(5); len(seqs) != 0 || !complete {
+		t.Fatalf("expected nothing to replay, got %v complete=%v", seqs, complete)
+	}
+}
+
+func TestReplayLogIsBoundedInBytes(t *testing.T) {
+	r := &updateRing{}
+	update := make([]byte, replayLogBytes/3)
+	for seq := uint64(1); seq <= 10; seq++ {
+		r.add(seq, update, 1000)
+	}
+	if r.bytes > replayLogBytes || len(r.items) != 3 || r.first != 8 {
+		t.Fatalf("expected the last three updates within %d bytes, got %d updates from %d (%d bytes)", replayLogBytes, len(r.items), r.first, r.bytes)
+	}
+	r.add(11, make([]byte, 2*replayLogBytes), 1000)
+	if len(r.items) != 1 || r.first != 11 {
+		t.Fatalf("expected an oversized update to replace the rest, got %d updates from %d", len(r.items), r.first)
+	}
+}
+
+func TestExpiredSessionsDropServerState(t *testing.T) {
+	s := newTestServer(t)
+	s.stdout = io.Discard
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": t.TempDir(), "mcpServers": []map[string]any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+	c := newConnection("c", func([]byte) error { return nil })
+	s.attachSession(sessionID, c)
+	s.claimSession(sessionID, c)
+	s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "agent_message_chunk", "content": map[string]any{"type": "text", "text": "hi"}}})
+
+	// Expiry deletes sessions through the session manager, not session/delete.
+	if err := s.sessions.DeleteSession(sessionID); err != nil {
+		t.Fatal(err)
+	}
+	s.updatesMu.Lock()
+	_, kept := s.updates[sessionID]
+	s.updatesMu.Unlock()
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if kept || s.sessionConns[sessionID] != nil || s.sessionOwners[sessionID] != nil {
+		t.Fatalf("expected the session's updates and connections to be dropped (updates kept: %v)", kept)
+	}
+}
+
+func TestStalledClientDoesNotHoldUpOtherSessions(t *testing.T) {
+	s := newTestServer(t)
+	writing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	stalled := newConnection("stalled", func([]byte) error {
+		writing <- struct{}{}
+		<-release
+		return nil
+	})
+	var mu sync.Mutex
+	var got []string
+	other := newConnection("other", func(buf []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, string(buf))
+		return nil
+	})
+	s.attachSession("sess_a", stalled)
+	s.attachSession("sess_b", other)
+	chunk := func(sessionID, text string) {
+		s.sendNotification("session/update", map[string]any{"sessionId": sessionID, "update": map[string]any{"sessionUpdate": "agent_message_chunk", "content": map[string]any{"type": "text", "text": text}}})
+	}
+
+	go chunk("sess_a", "stuck")
+	<-writing
+	done := make(chan struct{})
+	go func() {
+		chunk("sess_a", "queued")
+		chunk("sess_b", "free")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a stalled client held up other updates")
+	}
+	mu.Lock()
+	if len(got) != 1 || !strings.Contains(got[0], `"free"`) {
+		t.Fatalf("expected the other session's update, got %q", got)
+	}
+	mu.Unlock()
+	close(release)
+	<-writing
+}
+
+func TestReloadModelsNotifiesOnChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake cursor-agent script test is unix-only")
+	}
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	binDir := t.TempDir()
+	modelsFile := filepath.Join(binDir, "models.txt")
+	script := "#!/bin/sh\nif [ \"$1\" = models ]; then cat \"" + modelsFile + "\"; fi\n"
+	if err := os.WriteFile(filepath.Join(binDir, "cursor-agent"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	writeModels := func(lines string) {
+		if err := os.WriteFile(modelsFile, []byte("Available models\n"+lines), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeModels("auto - Auto\ngpt-5 - GPT-5\n")
+	if !s.reloadModels() {
+		t.Fatal("expected the first reload to change the model list")
+	}
+	if s.reloadModels() {
+		t.Fatal("expected an unchanged model list not to notify")
+	}
+	writeModels("auto - Auto\nsonnet-4.5 - Sonnet 4.5\n")
+	stdout.Reset()
+	if !s.reloadModels() {
+		t.Fatal("expected a changed model list to notify")
+	}
+
+	var notification struct {
+		Method string `json:"method"`
+		Params struct {
+			Added   []acp.SessionModel `json:"added"`
+			Removed []acp.SessionModel `json:"removed"`
+		} `json:"params"`
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if strings.Contains(line, modelsUpdatedNotification) {
+			if err := json.Unmarshal([]byte(line), &notification); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if notification.Method != modelsUpdatedNotification || len(notification.Params.Added) != 1 || notification.Params.Added[0].ID != "sonnet-4.5" || len(notification.Params.Removed) != 1 || notification.Params.Removed[0].ID != "gpt-5" {
+		t.Fatalf("unexpected notification: %s", stdout.String())
+	}
+	if cmd := s.slash.GetCommand("model"); cmd == nil || !strings.Contains(cmd.Description, "sonnet-4.5") {
+		t.Fatalf("expected /model description to list the new model, got %#v", cmd)
+	}
+}
+
+func TestRestoreCheckpointRevertsWorkingDirectory(t *testing.T) {
+	s := newTestServer(t)
+	cwd := t.TempDir()
+	target := filepath.Join(cwd, "notes.txt")
+	if err := os.WriteFile(target, []byte("before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": cwd, "mcpServers": []map[string]any{}}))
+	if resp.Error != nil {
+		t.Fatalf("session/new failed: %+v", resp.Error)
+	}
+	sessionID := resp.Result.(acp.NewSessionResponse).SessionID
+
+	cp, err := s.checkpoints.Create(sessionID, cwd, "edit notes", "")
+	if err != nil {
+		t.Fatalf("failed to create checkpoint: %v", err)
+	}
+	if err := os.WriteFile(target, []byte("after\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-list", "session/checkpoints", map[string]any{"sessionId": sessionID}))
+	if resp.Error != nil {
+		t.Fatalf("session/checkpoints failed: %+v", resp.Error)
+	}
+	if list := resp.Result.(map[string]any)["checkpoints"].([]checkpoint.Checkpoint); len(list) != 1 || list[0].ID != cp.ID {
+		t.Fatalf("unexpected checkpoint list: %#v", list)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-restore", "session/restore_checkpoint", map[string]any{"sessionId": sessionID, "checkpointId": cp.ID}))
+	if resp.Error != nil {
+		t.Fatalf("session/restore_checkpoint failed: %+v", resp.Error)
+	}
+	if buf, _ := os.ReadFile(target); string(buf) != "before\n" {
+		t.Fatalf("expected file to be restored, got %q", buf)
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-missing", "session/restore_checkpoint", map[string]any{"sessionId": sessionID, "checkpointId": "cp_missing"}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.InvalidParams {
+		t.Fatalf("expected unknown checkpoint to fail with invalid params, got %+v", resp.Error)
+	}
+}
+
+func TestSessionDeleteRefusesBusySessions(t *testing.T) {
+	s := newTestServer(t)
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": t.TempDir(), "mcpServers": []map[string]any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+	s.sessions.MarkProcessing(sessionID)
+
+	listResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-list", "session/list", map[string]any{}))
+	if sessions := listResp.Result.(acp.ListSessionsResponse).Sessions; len(sessions) != 1 || sessions[0].Status != "busy" {
+		t.Fatalf("expected a busy session, got %+v", sessions)
+	}
+
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-delete", "session/delete", map[string]any{"sessionId": sessionID}))
+	if resp.Error == nil || resp.Error.Code != jsonrpc.SessionBusy {
+		t.Fatalf("expected SESSION_BUSY, got %+v", resp.Error)
+	}
+	if !s.sessions.HasSession(sessionID) {
+		t.Fatalf("busy session was deleted")
+	}
+
+	resp, _ = s.processRequest(context.Background(), mustRequest(t, "req-force", "session/delete", map[string]any{"sessionId": sessionID, "force": true}))
+	if resp.Error != nil {
+		t.Fatalf("forced session/delete failed: %+v", resp.Error)
+	}
+	if s.sessions.HasSession(sessionID) {
+		t.Fatalf("expected the session to be deleted")
+	}
+}
+
+func TestSessionCancelUnblocksClientRPCs(t *testing.T) {
+	s := newTestServer(t)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: "s1", Path: "/tmp/file.txt"})
+		errCh <- err
+	}()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		s.pendingMu.Lock()
+		pending := len(s.sessionRPCs["s1"])
+		s.pendingMu.Unlock()
+		if pending > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fs/read_text_file was never sent")
+		}
+	}
+
+	if resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-cancel", "session/cancel", map[string]any{"sessionId": "s1"})); resp.Error != nil {
+		t.Fatalf("session/cancel failed: %+v", resp.Error)
+	}
+	select {
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "cancelled") {
+			t.Fatalf("expected a cancelled error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("session/cancel did not unblock the pending client request")
+	}
+	s.stdioConn.pendingMu.Lock()
+	pendingClientRPCs := len(s.stdioConn.pending)
+	s.stdioConn.pendingMu.Unlock()
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if pendingClientRPCs != 0 || len(s.sessionRPCs) != 0 {
+		t.Fatalf("expected no pending client requests, got %d/%d", pendingClientRPCs, len(s.sessionRPCs))
+	}
+}
+
+func TestSessionDeleteAndCancelAbandonClientRPCs(t *testing.T) {
+	for method, reason := range map[string]string{"session/cancel": "session cancelled", "session/delete": "session deleted"} {
+		s := newTestServer(t)
+		newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": t.TempDir(), "mcpServers": []map[string]any{}}))
+		if newResp.Error != nil {
+			t.Fatalf("session/new failed: %+v", newResp.Error)
+		}
+		sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: sessionID, Path: "/tmp/file.txt"})
+			errCh <- err
+		}()
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			s.pendingMu.Lock()
+			pending := len(s.sessionRPCs[sessionID])
+			s.pendingMu.Unlock()
+			if pending > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("fs/read_text_file was never sent")
+			}
+		}
+
+		if resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-cancel", method, map[string]any{"sessionId": sessionID})); resp.Error != nil {
+			t.Fatalf("%s failed: %+v", method, resp.Error)
+		}
+		select {
+		case err := <-errCh:
+			if err == nil || !strings.Contains(err.Error(), "cancelled: "+reason) {
+				t.Fatalf("%s: expected a %q error, got %v", method, reason, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s did not unblock the pending client request", method)
+		}
+		s.stdioConn.pendingMu.Lock()
+		pendingClientRPCs := len(s.stdioConn.pending)
+		s.stdioConn.pendingMu.Unlock()
+		s.pendingMu.Lock()
+		if pendingClientRPCs != 0 || len(s.sessionRPCs) != 0 {
+			t.Fatalf("%s: expected no pending client requests, got %d/%d", method, pendingClientRPCs, len(s.sessionRPCs))
+		}
+		s.pendingMu.Unlock()
+	}
+}
+
+func TestClientRequestTimeoutsAndRetries(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	s.cfg.ClientRequests = config.ClientRequestsConfig{
+		TimeoutMs:    20,
+		Timeouts:     map[string]int64{"terminal/output": 10},
+		Retries:      2,
+		RetryDelayMs: 1,
+	}
+
+	_, err := s.ReadTextFile(context.Background(), client.ReadTextFileRequest{SessionID: "s1", Path: "/tmp/file.txt"})
+	if err == nil || !strings.Contains(err.Error(), "client fs/read_text_file timed out after 20ms (3 attempts") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if sent := strings.Count(stdout.String(), `"method":"fs/read_text_file"`); sent != 3 {
+		t.Fatalf("expected 3 fs/read_text_file requests, got %d", sent)
+	}
+
+	stdout.Reset()
+	_, err = s.GetTerminalOutput(context.Background(), client.TerminalOutputRequest{TerminalID: "term-1"})
+	if err == nil || !strings.Contains(err.Error(), "client terminal/output timed out after 10ms (1 attempt;") {
+		t.Fatalf("unexpected terminal error: %v", err)
+	}
+	if sent := strings.Count(stdout.String(), `"method":"terminal/output"`); sent != 1 {
+		t.Fatalf("terminal/output must not be retried, sent %d", sent)
+	}
+}
+
+func TestWaitForExitIgnoresTheDefaultClientTimeout(t *testing.T) {
+	cfg := config.ClientRequestsConfig{TimeoutMs: 20}
+	if got := clientRequestTimeout(cfg, "terminal/wait_for_exit"); got != 0 {
+		t.Fatalf("expected no limit on terminal/wait_for_exit, got %s", got)
+	}
+	cfg.Timeouts = map[string]int64{"terminal/wait_for_exit": 5000}
+	if got := clientRequestTimeout(cfg, "terminal/wait_for_exit"); got != 5*time.Second {
+		t.Fatalf("expected the explicit entry to apply, got %s", got)
+	}
+	if got := clientRequestTimeout(cfg, "terminal/output"); got != 20*time.Millisecond {
+		t.Fatalf("expected timeoutMs for other methods, got %s", got)
+	}
+}
+
+func TestTerminalWaitForExitHeartbeat(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.ClientRequests.TimeoutMs = 50
+	s.cfg.ClientRequests.HeartbeatMs = 20
+	c := connectPipeClient(t, s)
+	c.send(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{"protocolVersion": 1, "clientCapabilities": map[string]any{"terminal": true}}})
+	c.next(t)
+	c.send(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}})
+	var sessionID string
+	for sessionID == "" {
+		if result, ok := c.next(t)["result"].(map[string]any); ok {
+			sessionID, _ = result["sessionId"].(string)
+		}
+	}
+
+	wait := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			exit, err := s.WaitForTerminalExit(context.Background(), client.WaitForTerminalExitRequest{SessionID: sessionID, TerminalID: "term-1"})
+			if err == nil && (exit.ExitCode == nil || *exit.ExitCode != 3) {
+				err = fmt.Errorf("unexpected exit %+v", exit)
+			}
+			done <- err
+		}()
+		return done
+	}
+
+	// The wait outlives clientRequests.timeoutMs while the probes succeed.
+	done := wait()
+	req := c.waitFor(t, "terminal/wait_for_exit")
+	for range 3 {
+		probe := c.waitFor(t, "terminal/output")
+		c.send(t, map[string]any{"jsonrpc": "2.0", "id": probe["id"], "result": map[string]any{"output": "", "truncated": false}})
+	}
+	c.send(t, map[string]any{"jsonrpc": "2.0", "id": req["id"], "result": map[string]any{"exitCode": 3}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A probe the client refuses abandons the wait.
+	done = wait()
+	c.waitFor(t, "terminal/wait_for_exit")
+	probe := c.waitFor(t, "terminal/output")
+	c.send(t, map[string]any{"jsonrpc": "2.0", "id": probe["id"], "error": map[string]any{"code": -32602, "message": "unknown terminal"}})
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "heartbeat failed") || !strings.Contains(err.Error(), "unknown terminal") {
+		t.Fatalf("expected the heartbeat to abandon the wait, got %v", err)
+	}
+}
+
+func TestShutdownCancelsWorkAndFlushesSessions(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	sess, err := s.sessions.CreateSession(map[string]any{"cwd": t.TempDir()})
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	s.toolCalls.ReportToolCall(sess.ID, "read_file", map[string]any{"title": "Reading", "status": "in_progress"})
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.GetTerminalOutput(context.Background(), client.TerminalOutputRequest{TerminalID: "term-1"})
+		errCh <- err
+	}()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		s.stdioConn.pendingMu.Lock()
+		pending := len(s.stdioConn.pending)
+		s.stdioConn.pendingMu.Unlock()
+		if pending > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("terminal/output was never sent")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Fatalf("expected the pending client request to be cancelled, got %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, `"status":"failed"`) || !strings.Contains(out, shutdownToolCallTitle) {
+		t.Fatalf("expected a cancelled tool_call update, got %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(s.cfg.SessionDir, sess.ID+".json")); err != nil {
+		t.Fatalf("expected the session to be flushed: %v", err)
+	}
+	if resp := s.ProcessRequest(context.Background(), mustRequest(t, "req-late", "tools/list", nil)); resp.Error == nil {
+		t.Fatalf("expected requests to be refused after shutdown")
+	}
+}
+
+func TestStartStdioReturnsOnEOFAndShutdownDrains(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	s.stdin = strings.NewReader(`{"jsonrpc":"2.0","id":"req-1","method":"tools/list"}` + "\n")
+
+	if err := s.StartStdio(context.Background()); err != nil {
+		t.Fatalf("StartStdio failed: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, `"id":"req-1"`) || strings.Contains(out, "shutting down") {
+		t.Fatalf("expected the in-flight request to be answered before shutdown finished, got %s", stdout.String())
+	}
+}
+
+func TestStdioSkipsOversizedMessages(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.MaxMessageBytes = 1024
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	pad := strings.Repeat("x", 200*1024)
+	s.stdin = strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/list","params":{"pad":"` + pad + `"}}` + "\n" +
+		`{"jsonrpc":"2.0","method":"tools/list","params":{"id":"inner","pad":"` + pad + `"},"id":8}` + "\n" +
+		`{"jsonrpc":"2.0","id":"req-2","method":"tools/list"}`)
+
+	if err := s.StartStdio(context.Background()); err != nil {
+		t.Fatalf("StartStdio failed: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	var responses []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid output line %q: %v", line, err)
+		}
+		responses = append(responses, msg)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("expected three responses, got %s", stdout.String())
+	}
+	for i, wantID := range []any{float64(7), nil} {
+		errObj, _ := responses[i]["error"].(map[string]any)
+		if responses[i]["id"] != wantID || errObj["code"] != float64(jsonrpc.ParseError) {
+			t.Fatalf("expected a parse error for id %v, got %v", wantID, responses[i])
+		}
+	}
+	if responses[2]["id"] != "req-2" || responses[2]["result"] == nil {
+		t.Fatalf("expected the next message to be served, got %v", responses[2])
+	}
+
+	// Without a limit, messages past the old 10MB scanner cap are read.
+	s = newTestServer(t)
+	s.cfg.MaxMessageBytes = 0
+	stdout.Reset()
+	s.stdout = &stdout
+	s.stdin = strings.NewReader(`{"jsonrpc":"2.0","id":"big","method":"tools/list","params":{"pad":"` + strings.Repeat("x", 11<<20) + `"}}` + "\n")
+	if err := s.StartStdio(context.Background()); err != nil {
+		t.Fatalf("StartStdio failed: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, `"id":"big"`) || strings.Contains(out, `"error"`) {
+		t.Fatalf("expected the large message to be served, got %.200s", out)
+	}
+}
+
+type brokenPipeWriter struct{ writes atomic.Int32 }
+
+func (w *brokenPipeWriter) Write([]byte) (int, error) {
+	w.writes.Add(1)
+	return 0, &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}
+}
+
+func TestStdioStopsWhenClientStopsReading(t *testing.T) {
+	s := newTestServer(t)
+	out := &brokenPipeWriter{}
+	s.SetOutput(out)
+	inR, inW := io.Pipe()
+	t.Cleanup(func() { _ = inW.Close() })
+	s.SetInput(inR)
+
+	served := make(chan error, 1)
+	go func() { served <- s.StartStdio(context.Background()) }()
+	if _, err := inW.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":1}}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err == nil || !errors.Is(err, syscall.EPIPE) {
+			t.Fatalf("expected a broken pipe error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartStdio kept running after the client stopped reading")
+	}
+	s.sendNotification("session/update", map[string]any{"update": map[string]any{"sessionUpdate": "plan"}})
+	if n := out.writes.Load(); n != 1 {
+		t.Fatalf("expected writes to stop after the first failure, got %d", n)
+	}
+}
+
+func TestSessionUpdatesCarrySequenceNumbers(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+
+	params := map[string]any{"sessionId": "a", "update": map[string]any{"sessionUpdate": "plan"}, "_meta": map[string]any{"timestamp": "now"}}
+	s.sendNotification("session/update", params)
+	s.sendNotification("session/update", map[string]any{"sessionId": "b", "update": map[string]any{"sessionUpdate": "plan"}})
+	s.sendNotification("session/update", params)
+	if _, ok := params["_meta"].(map[string]any)["sequence"]; ok {
+		t.Fatal("the caller's params must not be modified")
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var msg struct {
+			Params struct {
+				SessionID string         `json:"sessionId"`
+				Meta      map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s:%v", msg.Params.SessionID, msg.Params.Meta["sequence"]))
+		if msg.Params.SessionID == "a" && msg.Params.Meta["timestamp"] != "now" {
+			t.Fatalf("existing _meta fields must be kept: %s", line)
+		}
+	}
+	if strings.Join(got, ",") != "a:1,b:1,a:2" {
+		t.Fatalf("unexpected sequence numbers %v", got)
+	}
+}
+
+func TestPromptIsDelimitedByTurnUpdates(t *testing.T) {
+	s := newTestServer(t)
+	newResp, _ := s.processRequest(context.Background(), mustRequest(t, "req-new", "session/new", map[string]any{"cwd": t.TempDir(), "mcpServers": []map[string]any{}}))
+	if newResp.Error != nil {
+		t.Fatalf("session/new failed: %+v", newResp.Error)
+	}
+	sessionID := newResp.Result.(acp.NewSessionResponse).SessionID
+
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	resp, _ := s.processRequest(context.Background(), mustRequest(t, "req-prompt", "session/prompt", map[string]any{"sessionId": sessionID, "prompt": []map[string]any{{"type": "text", "text": "hi"}}}))
+	if resp.Error != nil {
+		t.Fatalf("session/prompt failed: %+v", resp.Error)
+	}
+
+	var updates []map[string]any
+	var turnIDs []any
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var msg struct {
+			Method string `json:"method"`
+			Params struct {
+				Update map[string]any `json:"update"`
+				Meta   map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		if json.Unmarshal([]byte(line), &msg) != nil || msg.Method != "session/update" || msg.Params.Update["sessionUpdate"] == "available_commands_update" {
+			continue
+		}
+		updates = append(updates, msg.Params.Update)
+		turnIDs = append(turnIDs, msg.Params.Meta["turnId"])
+	}
+	if len(updates) < 3 {
+		t.Fatalf("expected turn updates around the reply, got %s", stdout.String())
+	}
+	first, last := updates[0], updates[len(updates)-1]
+	if first["sessionUpdate"] != "turn_started" || last["sessionUpdate"] != "turn_completed" || first["turnId"] != last["turnId"] {
+		t.Fatalf("unexpected turn boundaries %v ... %v", first, last)
+	}
+	for _, id := range turnIDs {
+		if id != first["turnId"] {
+			t.Fatalf("every update of the turn should carry its turnId, got %v", turnIDs)
+		}
+	}
+	if last["stopReason"] != "end_turn" || last["toolCalls"] != float64(0) || last["thoughtChunks"] == float64(0) {
+		t.Fatalf("unexpected turn summary %v", last)
+	}
+}
+
+func TestRepeatedRequestIDsAreNotRunTwice(t *testing.T) {
+	log := newRequestLog()
+	req := mustRequest(t, "7", "session/prompt", map[string]any{"sessionId": "s1"})
+	if _, ok := log.begin(req); !ok {
+		t.Fatal("first request should run")
+	}
+	if resp, ok := log.begin(req); ok || resp.Error == nil || resp.Error.Code != jsonrpc.RequestInProgress {
+		t.Fatalf("expected request already in progress, got %+v", resp)
+	}
+	if _, ok := log.begin(mustRequest(t, "8", "session/prompt", map[string]any{"sessionId": "s1"})); !ok {
+		t.Fatal("a different ID should run")
+	}
+	if _, ok := log.begin(mustRequest(t, "7", "session/cancel", map[string]any{"sessionId": "s1"})); !ok {
+		t.Fatal("a different method with the same ID should run")
+	}
+	log.finish(req, jsonrpc.Failure(req.ID, jsonrpc.InternalError, "boom", nil))
+	if _, ok := log.begin(req); !ok {
+		t.Fatal("a failed request should run again when retried")
+	}
+
+	// Over a connection, a retry after the answer gets the same answer.
+	s := newTestServer(t)
+	c := connectPipeClient(t, s)
+	newSession := map[string]any{"jsonrpc": "2.0", "id": 2, "method": "session/new", "params": map[string]any{"cwd": t.TempDir(), "mcpServers": []any{}}}
+	var sessionIDs []any
+	for range 2 {
+		c.send(t, newSession)
+		for {
+			msg := c.next(t)
+			if result, ok := msg["result"].(map[string]any); ok && msg["id"] == float64(2) {
+				sessionIDs = append(sessionIDs, result["sessionId"])
+				break
+			}
+		}
+	}
+	if sessionIDs[0] != sessionIDs[1] {
+		t.Fatalf("a retried session/new created a second session: %v", sessionIDs)
+	}
+	if _, total, _, err := s.sessions.ListSessions(0, 0, nil); err != nil || total != 1 {
+		t.Fatalf("expected one session, got %d (%v)", total, err)
+	}
+}
+
+func TestHandleLineClassifiesMessages(t *testing.T) {
+	s := newTestServer(t)
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	s.stdin = strings.NewReader(strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":null}`,
+		`{"jsonrpc":"2.0","id":2,"method":42}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/list"`,
+		`{"jsonrpc":"2.0","method":"session/cancel","params":{"sessionId":"s"}}`,
+		`{"jsonrpc":"2.0","id":"client-1","result":{}}`,
+	}, "\n"))
+	if err := s.StartStdio(context.Background()); err != nil {
+		t.Fatalf("StartStdio failed: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var msg struct {
+			ID    any            `json:"id"`
+			Error *jsonrpc.Error `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid output line %q: %v", line, err)
+		}
+		code := 0
+		if msg.Error != nil {
+			code = msg.Error.Code
+		}
+		got = append(got, fmt.Sprintf("%v:%d", msg.ID, code))
+	}
+	sort.Strings(got)
+	if want := []string{"1:0", "<nil>:-32600", "<nil>:-32700"}; !slices.Equal(got, want) {
+		t.Fatalf("expected responses %v, got %v", want, got)
+	}
+}
+
+// BenchmarkHandleLargeMessage measures decoding and dispatching a request
+// whose params hold a multi-megabyte resource.
+func BenchmarkHandleLargeMessage(b *testing.B) {
+	s := newTestServer(b)
+	s.stdout = io.Discard
+	line := []byte(`{"jsonrpc":"2.0","method":"session/cancel","params":{"sessionId":"bench","resource":{"uri":"file:///big.txt","text":"` + strings.Repeat("x", 4<<20) + `"}}}`)
+	b.SetBytes(int64(len(line)))
+	b.ReportAllocs()
+	for b.Loop() {
+		s.handleLine(context.Background(), line)
+		s.inflight.Wait()
+	}
+}
+
+// BenchmarkSessionUpdateNotification measures stamping and serializing one
+// streamed agent_message_chunk update for a client.
+func BenchmarkSessionUpdateNotification(b *testing.B) {
+	s := newTestServer(b)
+	s.stdout = io.Discard
+	text := strings.Repeat("streamed text ", 20)
+	b.ReportAllocs()
+	for b.Loop() {
+		s.sendNotification("session/update", map[string]any{
+			"sessionId": "bench",
+			"update": map[string]any{
+				"sessionUpdate": "agent_message_chunk",
+				"content":       map[string]any{"type": "text", "text": text, "annotations": map[string]any{"priority": 1, "audience": []string{"user"}}},
+			},
+		})
+	}
+}
+
+func TestBenchmarkBudgets(t *testing.T) {
+	benchcheck.Check(t, map[string]func(*testing.B){
+		"HandleLargeMessage":        BenchmarkHandleLargeMessage,
+		"SessionUpdateNotification": BenchmarkSessionUpdateNotification,
+	}, map[string]benchcheck.Budget{
+		"HandleLargeMessage":        {NsPerOp: 300_000_000, AllocsPerOp: 60, BytesPerOp: 8 << 20},
+		"SessionUpdateNotification": {NsPerOp: 100_000, AllocsPerOp: 60, BytesPerOp: 10 << 10},
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+
+	"github.com/spjoes/cursor-agent-acp/internal/jsonrpc"
+)
+
+const shutdownToolCallTitle = "Cancelled: adapter shutting down"
+
+// Shutdown stops the server after stdin closes or a termination signal.
+// New requests are refused; active prompts, tool calls, permission requests
+// and client RPCs are cancelled; request handlers get until ctx is done to
+// send their final responses; then sessions are flushed to disk and the
+// server is closed. Only the first call has any effect.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		s.shuttingDown.Store(true)
+		close(s.shutdownCh)
+
+		active := s.prompt.ActiveSessions()
+		s.logger.Info("Shutting down", map[string]any{"activePrompts": len(active)})
+		for _, sessionID := range active {
+			s.prompt.CancelSession(sessionID)
+			s.permissions.CancelSessionPermissionRequests(sessionID)
+		}
+		s.toolCalls.CancelAllToolCalls(shutdownToolCallTitle)
+
+		drained := make(chan struct{})
+		go func() {
+			s.inflight.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			s.logger.Warn("Shutdown deadline reached with requests still running", nil)
+		}
+
+		if err = s.sessions.Flush(); err != nil {
+			s.logger.Error("Failed to flush sessions", map[string]any{"error": err.Error()})
+		}
+		s.Close()
+		s.logger.Info("Shutdown complete", nil)
+	})
+	return err
+}
+
+// shuttingDownFailure answers requests that arrive after Shutdown started.
+func shuttingDownFailure(id any) jsonrpc.Response {
+	return jsonrpc.Failure(id, jsonrpc.InternalError, "server is shutting down", nil)
+}
@@ -0,0 +1,120 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+// encryptedMagic prefixes every encrypted session file so plaintext files
+// written before encryption was enabled can still be loaded.
+var encryptedMagic = []byte("CAACP-ENC1\n")
+
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(cfg config.SessionEncryptionConfig) (*sealer, error) {
+	secret, err := loadEncryptionSecret(cfg)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(deriveKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+func (s *sealer) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(plaintext)+s.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, nonce...)
+	return s.aead.Seal(out, nonce, plaintext, encryptedMagic), nil
+}
+
+func (s *sealer) open(data []byte) ([]byte, error) {
+	payload := bytes.TrimPrefix(data, encryptedMagic)
+	if len(payload) < s.aead.NonceSize() {
+		return nil, errors.New("encrypted session file is truncated")
+	}
+	nonce, ciphertext := payload[:s.aead.NonceSize()], payload[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt session file: %w", err)
+	}
+	return plaintext, nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// deriveKey accepts either a base64-encoded 32-byte key or an arbitrary
+// passphrase, which is stretched to 32 bytes with SHA-256.
+func deriveKey(secret string) []byte {
+	if raw, err := base64.StdEncoding.DecodeString(secret); err == nil && len(raw) == 32 {
+		return raw
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func loadEncryptionSecret(cfg config.SessionEncryptionConfig) (string, error) {
+	switch cfg.KeySource {
+	case "", "env":
+		name := cfg.KeyEnv
+		if name == "" {
+			name = "CURSOR_ACP_SESSION_KEY"
+		}
+		secret := strings.TrimSpace(os.Getenv(name))
+		if secret == "" {
+			return "", fmt.Errorf("session encryption key not set: environment variable %s is empty", name)
+		}
+		return secret, nil
+	case "keychain":
+		return readKeychainSecret(cfg.KeychainService, cfg.KeychainAccount)
+	default:
+		return "", fmt.Errorf("unsupported session encryption key source: %s", cfg.KeySource)
+	}
+}
+
+func readKeychainSecret(service string, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("OS keychain is not supported on %s; use keySource \"env\"", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("read session encryption key from keychain (service=%s account=%s): %w", service, account, err)
+	}
+	secret := strings.TrimSpace(string(out))
+	if secret == "" {
+		return "", fmt.Errorf("keychain entry service=%s account=%s is empty", service, account)
+	}
+	return secret, nil
+}
@@ -0,0 +1,219 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	lockFileName      = ".cursor-agent-acp.lock"
+	lockHeartbeat     = 10 * time.Second
+	lockStaleAfter    = 3 * lockHeartbeat
+	lockAcquireTrials = 3
+)
+
+// errLockLost is reported by heartbeat once another instance owns the lock.
+var errLockLost = errors.New("session lock is owned by another instance")
+
+type lockOwner struct {
+	PID         int       `json:"pid"`
+	Hostname    string    `json:"hostname"`
+	Token       string    `json:"token"`
+	StartedAt   time.Time `json:"startedAt"`
+	HeartbeatAt time.Time `json:"heartbeatAt"`
+}
+
+// dirLock is a lock file with an owner record and a heartbeat. A lock whose
+// heartbeat is older than lockStaleAfter is considered abandoned (the owning
+// process crashed) and may be taken over.
+type dirLock struct {
+	path  string
+	owner lockOwner
+	// onLost is called once, from the heartbeat, when another instance has
+	// taken the lock over.
+	onLost func(error)
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func acquireDirLock(dir string, onLost func(error)) (*dirLock, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+	l := &dirLock{
+		path: filepath.Join(dir, lockFileName),
+		owner: lockOwner{
+			PID:         os.Getpid(),
+			Hostname:    hostname,
+			Token:       randomID(),
+			StartedAt:   now,
+			HeartbeatAt: now,
+		},
+		onLost: onLost,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	for attempt := 0; attempt < lockAcquireTrials; attempt++ {
+		err := l.create()
+		if err == nil {
+			go l.heartbeatLoop()
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("create session lock %s: %w", l.path, err)
+		}
+
+		raw, readErr := os.ReadFile(l.path)
+		if errors.Is(readErr, os.ErrNotExist) {
+			continue
+		}
+		current, readErr := parseLockOwner(raw, readErr)
+		if readErr != nil {
+			// Unparseable lock files are treated as abandoned once they are old enough.
+			info, statErr := os.Stat(l.path)
+			if statErr == nil && time.Since(info.ModTime()) < lockStaleAfter {
+				return nil, fmt.Errorf("session directory %s is locked by another cursor-agent-acp instance (unreadable lock file %s)", dir, l.path)
+			}
+		} else if time.Since(current.HeartbeatAt) < lockStaleAfter {
+			return nil, fmt.Errorf("session directory %s is locked by another cursor-agent-acp instance (pid %d on %s, started %s); stop it or use a different sessionDir", dir, current.PID, current.Hostname, current.StartedAt.Format(time.RFC3339))
+		}
+
+		if err := l.takeOver(raw); err != nil {
+			return nil, fmt.Errorf("take over stale session lock %s: %w", l.path, err)
+		}
+	}
+	return nil, fmt.Errorf("could not acquire session lock %s", l.path)
+}
+
+// takeOver removes the lock file if it still holds stale, the contents
+// judged abandoned. Two instances can judge the same lock stale: renaming
+// lets only one of them move a given file aside, and checking what was moved
+// keeps the slower one from discarding the lock the faster one has created
+// since; that lock is put back.
+func (l *dirLock) takeOver(stale []byte) error {
+	aside := l.path + "." + l.owner.Token + ".stale"
+	if err := os.Rename(l.path, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	moved, err := os.ReadFile(aside)
+	if err == nil && bytes.Equal(moved, stale) {
+		return os.Remove(aside)
+	}
+	// os.Link fails rather than replace a lock created in the meantime.
+	if err := os.Link(aside, l.path); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return os.Remove(aside)
+}
+
+func (l *dirLock) create() error {
+	buf, err := json.Marshal(l.owner)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(l.path)
+		return err
+	}
+	return f.Close()
+}
+
+func (l *dirLock) heartbeatLoop() {
+	defer close(l.doneCh)
+	ticker := time.NewTicker(lockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.heartbeat(); errors.Is(err, errLockLost) {
+				if l.onLost != nil {
+					l.onLost(err)
+				}
+				return
+			}
+		case <-l.stopCh:
+			return
+		}
+	}
+}
+
+func (l *dirLock) heartbeat() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, err := readLockOwner(l.path)
+	if err != nil {
+		// A missing lock may be one another instance is putting back; a
+		// lock taken over shows up with its new owner.
+		return err
+	}
+	if current.Token != l.owner.Token {
+		return fmt.Errorf("%w: pid %d on %s", errLockLost, current.PID, current.Hostname)
+	}
+	l.owner.HeartbeatAt = time.Now().UTC()
+	buf, err := json.Marshal(l.owner)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + "." + l.owner.Token + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *dirLock) release() error {
+	close(l.stopCh)
+	<-l.doneCh
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current, err := readLockOwner(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if current.Token != l.owner.Token {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func readLockOwner(path string) (*lockOwner, error) {
+	return parseLockOwner(os.ReadFile(path))
+}
+
+func parseLockOwner(buf []byte, err error) (*lockOwner, error) {
+	if err != nil {
+		return nil, err
+	}
+	var owner lockOwner
+	if err := json.Unmarshal(buf, &owner); err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
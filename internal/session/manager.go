@@ -1,20 +1,25 @@
 package session
 
 import (
+	"cmp"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -29,12 +34,22 @@ type Manager struct {
 	mu         sync.RWMutex
 	sessions   map[string]*acp.SessionData
 	processing map[string]bool
+	// onDelete runs after a session is deleted, by request or on expiry.
+	onDelete func(sessionID string)
 
 	availableModes  []acp.SessionMode
 	availableModels []acp.SessionModel
 
 	cleanupTicker *time.Ticker
 	stopCh        chan struct{}
+
+	sealer    *sealer
+	sealerErr error
+
+	lock *dirLock
+	// lockLost is set once another instance takes SessionDir over; session
+	// files are no longer written from then on.
+	lockLost atomic.Bool
 }
 
 func NewManager(cfg config.Config, logger *logging.Logger) *Manager {
@@ -48,8 +63,15 @@ func NewManager(cfg config.Config, logger *logging.Logger) *Manager {
 			{ID: "plan", Name: "Plan", Description: "Design and plan software systems without implementation"},
 			{ID: "ask", Name: "Ask", Description: "Request permission before making any changes"},
 		},
-		availableModels: []acp.SessionModel{{ID: "auto", Name: "Auto", Provider: "cursor"}},
-		stopCh:          make(chan struct{}),
+		stopCh: make(chan struct{}),
+	}
+	m.availableModels = m.AllowedModels([]acp.SessionModel{{ID: "auto", Name: "Auto", Provider: "cursor"}})
+
+	if cfg.SessionEncryption.Enabled {
+		m.sealer, m.sealerErr = newSealer(cfg.SessionEncryption)
+		if m.sealerErr != nil {
+			logger.Error("session encryption is enabled but the key could not be loaded; sessions will not be persisted", map[string]any{"error": m.sealerErr.Error()})
+		}
 	}
 
 	m.startCleanupLoop()
@@ -61,6 +83,41 @@ func (m *Manager) Close() {
 		m.cleanupTicker.Stop()
 	}
 	close(m.stopCh)
+	m.ReleaseLock()
+}
+
+// AcquireLock takes exclusive ownership of SessionDir so that a second adapter
+// instance pointed at the same directory fails fast instead of corrupting
+// session files with concurrent writes.
+func (m *Manager) AcquireLock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lock != nil {
+		return nil
+	}
+	lock, err := acquireDirLock(m.cfg.SessionDir, func(err error) {
+		m.lockLost.Store(true)
+		m.logger.Error("session directory lock was taken over by another instance; sessions will no longer be persisted", map[string]any{"sessionDir": m.cfg.SessionDir, "error": err.Error()})
+	})
+	if err != nil {
+		return err
+	}
+	m.lockLost.Store(false)
+	m.lock = lock
+	return nil
+}
+
+func (m *Manager) ReleaseLock() {
+	m.mu.Lock()
+	lock := m.lock
+	m.lock = nil
+	m.mu.Unlock()
+	if lock == nil {
+		return
+	}
+	if err := lock.release(); err != nil {
+		m.logger.Warn("failed to release session directory lock", map[string]any{"error": err.Error()})
+	}
 }
 
 func (m *Manager) LoadModelsFromProvider(provider ModelsProvider) {
@@ -74,7 +131,19 @@ func (m *Manager) LoadModelsFromProvider(provider ModelsProvider) {
 	}
 
 	m.mu.Lock()
-	m.availableModels = models
+	m.availableModels = m.AllowedModels(models)
+	if model := m.cfg.Defaults.Model; model != "" && !m.hasModelLocked(m.ResolveModel(model)) {
+		m.logger.Warn("defaults.model is not offered by cursor-agent or not allowed, new sessions use auto", map[string]any{"model": model})
+	}
+	m.mu.Unlock()
+}
+
+func (m *Manager) SetAvailableModels(models []acp.SessionModel) {
+	if len(models) == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.availableModels = m.AllowedModels(models)
 	m.mu.Unlock()
 }
 
@@ -98,7 +167,7 @@ func (m *Manager) GetSessionModeState(sessionID string) *acp.SessionModeState {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	current := "ask"
+	current := m.defaultModeLocked()
 	if s, ok := m.sessions[sessionID]; ok {
 		if s.State.CurrentMode != "" {
 			current = s.State.CurrentMode
@@ -113,19 +182,81 @@ func (m *Manager) GetSessionModelState(sessionID string) *acp.SessionModelState
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	current := "auto"
+	current := m.defaultModelLocked()
 	if s, ok := m.sessions[sessionID]; ok {
 		if s.State.CurrentModel != "" {
 			current = s.State.CurrentModel
 		}
 	}
 	models := make([]acp.SessionModelEntry, 0, len(m.availableModels))
+	names := make(map[string]string, len(m.availableModels))
 	for _, model := range m.availableModels {
-		models = append(models, acp.SessionModelEntry{ModelID: model.ID, Name: model.Name})
+		models = append(models, acp.SessionModelEntry{ModelID: model.ID, Name: model.Name, Meta: modelMeta(model.ID, m.cfg.Models.Info)})
+		names[model.ID] = model.Name
+	}
+	aliases := m.modelAliasesLocked()
+	for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+		target := aliases[alias]
+		meta := modelMeta(target, m.cfg.Models.Info)
+		if meta == nil {
+			meta = map[string]any{}
+		}
+		meta["aliasFor"] = target
+		models = append(models, acp.SessionModelEntry{ModelID: alias, Name: alias + " (" + cmp.Or(names[target], target) + ")", Meta: meta})
 	}
 	return &acp.SessionModelState{AvailableModels: models, CurrentModelID: current}
 }
 
+func (m *Manager) hasModeLocked(modeID string) bool {
+	for _, mode := range m.availableModes {
+		if mode.ID == modeID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) hasModelLocked(modelID string) bool {
+	for _, model := range m.availableModels {
+		if model.ID == modelID {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultModeLocked is defaults.mode, or "ask".
+func (m *Manager) defaultModeLocked() string {
+	if mode := m.cfg.Defaults.Mode; mode != "" && m.hasModeLocked(mode) {
+		return mode
+	}
+	return "ask"
+}
+
+// checkModelLocked reports why modelID cannot be selected, if it cannot.
+func (m *Manager) checkModelLocked(modelID string) error {
+	if !modelAllowed(modelID, m.cfg.Models) {
+		return errcode.New(errcode.ModelNotAllowed, "model not allowed: %s", modelID)
+	}
+	if !m.hasModelLocked(modelID) {
+		return errcode.New(errcode.InvalidModel, "invalid model: %s", modelID)
+	}
+	return nil
+}
+
+// defaultModelLocked is defaults.model when cursor-agent offers it and it
+// is allowed, else "auto" or, when that is not allowed, the first allowed
+// model.
+func (m *Manager) defaultModelLocked() string {
+	if model := m.ResolveModel(m.cfg.Defaults.Model); model != "" && m.hasModelLocked(model) {
+		return model
+	}
+	if !m.hasModelLocked("auto") && len(m.availableModels) > 0 {
+		return m.availableModels[0].ID
+	}
+	return "auto"
+}
+
 func (m *Manager) HasSession(sessionID string) bool {
 	m.mu.RLock()
 	_, ok := m.sessions[sessionID]
@@ -152,7 +283,7 @@ func (m *Manager) CreateSession(metadata map[string]any) (*acp.SessionData, erro
 		m.mu.Lock()
 		if len(m.sessions) >= m.cfg.MaxSessions {
 			m.mu.Unlock()
-			return nil, errors.New("maximum number of sessions reached")
+			return nil, errcode.New(errcode.SessionLimit, "maximum number of sessions reached")
 		}
 	}
 
@@ -162,12 +293,21 @@ func (m *Manager) CreateSession(metadata map[string]any) (*acp.SessionData, erro
 	if strings.TrimSpace(name) == "" {
 		name = "Session " + sessionID[:8]
 	}
-	mode := "ask"
+	mode := m.defaultModeLocked()
 	if v, ok := metadata["mode"].(string); ok && strings.TrimSpace(v) != "" {
+		if !m.hasModeLocked(v) {
+			m.mu.Unlock()
+			return nil, errcode.New(errcode.InvalidMode, "invalid mode: %s", v)
+		}
 		mode = v
 	}
-	model := "auto"
+	model := m.defaultModelLocked()
 	if v, ok := metadata["model"].(string); ok && strings.TrimSpace(v) != "" {
+		v = m.ResolveModel(v)
+		if err := m.checkModelLocked(v); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
 		model = v
 	}
 	metadata["name"] = name
@@ -216,7 +356,7 @@ func (m *Manager) LoadSession(sessionID string) (*acp.SessionData, error) {
 		return nil, err
 	}
 	if s == nil {
-		return nil, fmt.Errorf("session not found: %s", sessionID)
+		return nil, errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
 	}
 
 	m.mu.Lock()
@@ -250,7 +390,7 @@ func (m *Manager) UpdateSession(sessionID string, updates map[string]any) (*acp.
 			return nil, err
 		}
 		if loaded == nil {
-			return nil, fmt.Errorf("session not found: %s", sessionID)
+			return nil, errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
 		}
 		s = loaded
 		m.sessions[sessionID] = s
@@ -273,16 +413,54 @@ func (m *Manager) UpdateSession(sessionID string, updates map[string]any) (*acp.
 	return &copy, nil
 }
 
+// SetOnDelete sets fn to run after each session is deleted, whether by
+// DeleteSession or because it expired, so state kept elsewhere for the
+// session can be dropped with it.
+func (m *Manager) SetOnDelete(fn func(sessionID string)) {
+	m.mu.Lock()
+	m.onDelete = fn
+	m.mu.Unlock()
+}
+
 func (m *Manager) DeleteSession(sessionID string) error {
+	_, err := m.removeSession(sessionID, nil)
+	return err
+}
+
+// DeleteIdleSession deletes sessionID unless it is processing a prompt or
+// busy reports true. Both are checked under the manager's lock, which a
+// prompt needs to start, so nothing can start between the check and the
+// delete. It reports whether the session was deleted.
+func (m *Manager) DeleteIdleSession(sessionID string, busy func() bool) (bool, error) {
+	if busy == nil {
+		busy = func() bool { return false }
+	}
+	return m.removeSession(sessionID, busy)
+}
+
+// removeSession deletes sessionID, or leaves it alone when busy is set and
+// the session is processing or busy reports true.
+func (m *Manager) removeSession(sessionID string, busy func() bool) (bool, error) {
 	m.mu.Lock()
+	if busy != nil && (m.processing[sessionID] || busy()) {
+		m.mu.Unlock()
+		return false, nil
+	}
 	delete(m.sessions, sessionID)
 	delete(m.processing, sessionID)
+	onDelete := m.onDelete
 	m.mu.Unlock()
+	if onDelete != nil {
+		defer onDelete(sessionID)
+	}
 
 	if err := os.Remove(m.sessionPath(sessionID)); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+		return true, err
 	}
-	return nil
+	if err := os.Remove(m.summaryPath(sessionID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return true, err
+	}
+	return true, nil
 }
 
 func (m *Manager) AddMessage(sessionID string, msg acp.ConversationMessage) error {
@@ -298,7 +476,7 @@ func (m *Manager) AddMessage(sessionID string, msg acp.ConversationMessage) erro
 			return err
 		}
 		if loaded == nil {
-			return fmt.Errorf("session not found: %s", sessionID)
+			return errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
 		}
 		s = loaded
 		m.sessions[sessionID] = s
@@ -313,6 +491,48 @@ func (m *Manager) AddMessage(sessionID string, msg acp.ConversationMessage) erro
 	return m.persistSession(s)
 }
 
+// RemoveLastExchange removes the last user message and every message after
+// it (the replies to it) and returns them, oldest first. A conversation
+// without a user message is left as is.
+func (m *Manager) RemoveLastExchange(sessionID string) ([]acp.ConversationMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		m.mu.Unlock()
+		loaded, err := m.loadSessionFromDisk(sessionID)
+		m.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		if loaded == nil {
+			return nil, errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
+		}
+		s = loaded
+		m.sessions[sessionID] = s
+	}
+
+	last := -1
+	for i, msg := range slices.Backward(s.Conversation) {
+		if msg.Role == "user" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return nil, nil
+	}
+	removed := slices.Clone(s.Conversation[last:])
+	s.Conversation = s.Conversation[:last]
+	s.State.MessageCount = len(s.Conversation)
+	now := time.Now().UTC()
+	s.State.LastActivity = now
+	s.UpdatedAt = now
+
+	return removed, m.persistSession(s)
+}
+
 func (m *Manager) ListSessions(limit int, offset int, filter map[string]any) ([]acp.SessionInfo, int, bool, error) {
 	if limit <= 0 {
 		limit = 50
@@ -321,14 +541,18 @@ func (m *Manager) ListSessions(limit int, offset int, filter map[string]any) ([]
 		offset = 0
 	}
 
-	all, err := m.allSessions()
+	f, err := parseFilter(filter)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	all, err := m.allSummaries()
 	if err != nil {
 		return nil, 0, false, err
 	}
 
-	filtered := make([]acp.SessionData, 0, len(all))
+	filtered := make([]sessionSummary, 0, len(all))
 	for _, s := range all {
-		if matchesFilter(s, filter) {
+		if m.matchesFilter(s, f) {
 			filtered = append(filtered, s)
 		}
 	}
@@ -363,15 +587,8 @@ func (m *Manager) SetSessionMode(sessionID string, modeID string) (string, error
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	valid := false
-	for _, mode := range m.availableModes {
-		if mode.ID == modeID {
-			valid = true
-			break
-		}
-	}
-	if !valid {
-		return "", fmt.Errorf("invalid mode: %s", modeID)
+	if !m.hasModeLocked(modeID) {
+		return "", errcode.New(errcode.InvalidMode, "invalid mode: %s", modeID)
 	}
 
 	s, ok := m.sessions[sessionID]
@@ -383,7 +600,7 @@ func (m *Manager) SetSessionMode(sessionID string, modeID string) (string, error
 			return "", err
 		}
 		if loaded == nil {
-			return "", fmt.Errorf("session not found: %s", sessionID)
+			return "", errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
 		}
 		s = loaded
 		m.sessions[sessionID] = s
@@ -405,15 +622,9 @@ func (m *Manager) SetSessionModel(sessionID string, modelID string) (string, err
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	valid := false
-	for _, model := range m.availableModels {
-		if model.ID == modelID {
-			valid = true
-			break
-		}
-	}
-	if !valid {
-		return "", fmt.Errorf("invalid model: %s", modelID)
+	modelID = m.ResolveModel(modelID)
+	if err := m.checkModelLocked(modelID); err != nil {
+		return "", err
 	}
 
 	s, ok := m.sessions[sessionID]
@@ -425,7 +636,7 @@ func (m *Manager) SetSessionModel(sessionID string, modelID string) (string, err
 			return "", err
 		}
 		if loaded == nil {
-			return "", fmt.Errorf("session not found: %s", sessionID)
+			return "", errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
 		}
 		s = loaded
 		m.sessions[sessionID] = s
@@ -449,7 +660,7 @@ func (m *Manager) GetSessionMode(sessionID string) string {
 	if s, ok := m.sessions[sessionID]; ok && s.State.CurrentMode != "" {
 		return s.State.CurrentMode
 	}
-	return "ask"
+	return m.defaultModeLocked()
 }
 
 func (m *Manager) GetSessionModel(sessionID string) string {
@@ -458,7 +669,7 @@ func (m *Manager) GetSessionModel(sessionID string) string {
 	if s, ok := m.sessions[sessionID]; ok && s.State.CurrentModel != "" {
 		return s.State.CurrentModel
 	}
-	return "auto"
+	return m.defaultModelLocked()
 }
 
 func (m *Manager) GetCursorChatID(sessionID string) string {
@@ -472,6 +683,73 @@ func (m *Manager) GetCursorChatID(sessionID string) string {
 	return ""
 }
 
+func (m *Manager) GetSessionCwd(sessionID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s, ok := m.sessions[sessionID]; ok {
+		if cwd, ok := s.Metadata["cwd"].(string); ok {
+			return cwd
+		}
+	}
+	return ""
+}
+
+// GetWorkspaceFolders returns the workspace folders the client sent with
+// session/new or session/load, besides the working directory.
+func (m *Manager) GetWorkspaceFolders(sessionID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	switch folders := s.Metadata["workspaceFolders"].(type) {
+	case []string:
+		return append([]string(nil), folders...)
+	case []any:
+		out := make([]string, 0, len(folders))
+		for _, folder := range folders {
+			if folder, ok := folder.(string); ok {
+				out = append(out, folder)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// SetSessionCwd moves the session to cwd, where its tools and cursor-agent
+// runs from then on, and returns the previous working directory.
+func (m *Manager) SetSessionCwd(sessionID string, cwd string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		m.mu.Unlock()
+		loaded, err := m.loadSessionFromDisk(sessionID)
+		m.mu.Lock()
+		if err != nil {
+			return "", err
+		}
+		if loaded == nil {
+			return "", errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
+		}
+		s = loaded
+		m.sessions[sessionID] = s
+	}
+
+	prev, _ := s.Metadata["cwd"].(string)
+	s.Metadata["cwd"] = cwd
+	now := time.Now().UTC()
+	s.State.LastActivity = now
+	s.UpdatedAt = now
+	if err := m.persistSession(s); err != nil {
+		return "", err
+	}
+	return prev, nil
+}
+
 func (m *Manager) SetCursorChatID(sessionID string, chatID string) error {
 	_, err := m.UpdateSession(sessionID, map[string]any{"cursorChatId": chatID})
 	return err
@@ -550,7 +828,14 @@ func (m *Manager) sessionPath(sessionID string) string {
 	return filepath.Join(m.cfg.SessionDir, sessionID+".json")
 }
 
+func (m *Manager) summaryPath(sessionID string) string {
+	return filepath.Join(m.cfg.SessionDir, "index", sessionID+".json")
+}
+
 func (m *Manager) persistSession(s *acp.SessionData) error {
+	if m.lockLost.Load() {
+		return fmt.Errorf("session directory %s is locked by another instance", m.cfg.SessionDir)
+	}
 	if err := os.MkdirAll(m.cfg.SessionDir, 0o755); err != nil {
 		return err
 	}
@@ -558,7 +843,100 @@ func (m *Manager) persistSession(s *acp.SessionData) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(m.sessionPath(s.ID), buf, 0o644)
+	buf, err = m.encode(buf)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.sessionPath(s.ID), buf, m.fileMode()); err != nil {
+		return err
+	}
+	return m.persistSummary(summarize(s))
+}
+
+// persistSummary writes the index entry session listings read instead of
+// the full session file. It is written after the session, so an entry older
+// than its session file is stale.
+func (m *Manager) persistSummary(sum sessionSummary) error {
+	if err := os.MkdirAll(filepath.Dir(m.summaryPath(sum.ID)), 0o755); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	buf, err = m.encode(buf)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.summaryPath(sum.ID), buf, m.fileMode())
+}
+
+// writeFileAtomic writes and renames so an interrupted write never truncates
+// the file.
+func writeFileAtomic(path string, buf []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Flush persists every session held in memory.
+func (m *Manager) Flush() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var errs []error
+	for _, s := range m.sessions {
+		if err := m.persistSession(s); err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", s.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) encode(buf []byte) ([]byte, error) {
+	if !m.cfg.SessionEncryption.Enabled {
+		return buf, nil
+	}
+	if m.sealer == nil {
+		return nil, fmt.Errorf("session encryption unavailable: %w", m.sealerErr)
+	}
+	return m.sealer.seal(buf)
+}
+
+func (m *Manager) decode(buf []byte) ([]byte, error) {
+	if !isEncrypted(buf) {
+		return buf, nil
+	}
+	if m.sealer == nil {
+		if m.sealerErr != nil {
+			return nil, fmt.Errorf("session file is encrypted but the key is unavailable: %w", m.sealerErr)
+		}
+		return nil, errors.New("session file is encrypted but sessionEncryption is disabled")
+	}
+	return m.sealer.open(buf)
+}
+
+// Seal encrypts data like session files when sessionEncryption is enabled,
+// so other files kept for sessions can be stored the same way.
+func (m *Manager) Seal(data []byte) ([]byte, error) {
+	return m.encode(data)
+}
+
+// Open reads data written by Seal; plain data is returned unchanged.
+func (m *Manager) Open(data []byte) ([]byte, error) {
+	return m.decode(data)
+}
+
+func (m *Manager) fileMode() os.FileMode {
+	if m.cfg.SessionEncryption.Enabled {
+		return 0o600
+	}
+	return 0o644
 }
 
 func (m *Manager) loadSessionFromDisk(sessionID string) (*acp.SessionData, error) {
@@ -569,6 +947,10 @@ func (m *Manager) loadSessionFromDisk(sessionID string) (*acp.SessionData, error
 		}
 		return nil, err
 	}
+	buf, err = m.decode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("load session %s: %w", sessionID, err)
+	}
 	var s acp.SessionData
 	if err := json.Unmarshal(buf, &s); err != nil {
 		return nil, err
@@ -579,12 +961,57 @@ func (m *Manager) loadSessionFromDisk(sessionID string) (*acp.SessionData, error
 	return &s, nil
 }
 
-func (m *Manager) allSessions() ([]acp.SessionData, error) {
+// sessionSummary is what listing a session needs: everything but the
+// conversation, which is most of a session file.
+type sessionSummary struct {
+	ID        string           `json:"id"`
+	Metadata  map[string]any   `json:"metadata"`
+	State     acp.SessionState `json:"state"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+func summarize(s *acp.SessionData) sessionSummary {
+	return sessionSummary{ID: s.ID, Metadata: cloneMetadata(s.Metadata), State: s.State, CreatedAt: s.CreatedAt, UpdatedAt: s.UpdatedAt}
+}
+
+// loadSummary reads the index entry of the session stored in the file
+// described by info. Sessions without a current entry, such as ones saved
+// before the index existed, are loaded in full and their entry written.
+func (m *Manager) loadSummary(sessionID string, info fs.FileInfo) (*sessionSummary, error) {
+	path := m.summaryPath(sessionID)
+	if st, err := os.Stat(path); err == nil && !st.ModTime().Before(info.ModTime()) {
+		buf, err := os.ReadFile(path)
+		if err == nil {
+			buf, err = m.decode(buf)
+		}
+		var sum sessionSummary
+		if err == nil && json.Unmarshal(buf, &sum) == nil && sum.ID == sessionID {
+			if sum.Metadata == nil {
+				sum.Metadata = map[string]any{}
+			}
+			return &sum, nil
+		}
+	}
+	s, err := m.loadSessionFromDisk(sessionID)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	sum := summarize(s)
+	if err := m.persistSummary(sum); err != nil {
+		m.logger.Debug("could not index session", map[string]any{"sessionId": sessionID, "error": err.Error()})
+	}
+	return &sum, nil
+}
+
+// allSummaries summarizes every session in memory or on disk without
+// reading any conversation from disk.
+func (m *Manager) allSummaries() ([]sessionSummary, error) {
 	m.mu.RLock()
-	result := make([]acp.SessionData, 0, len(m.sessions))
+	result := make([]sessionSummary, 0, len(m.sessions))
 	seen := make(map[string]bool, len(m.sessions))
 	for id, s := range m.sessions {
-		result = append(result, cloneSession(*s))
+		result = append(result, summarize(s))
 		seen[id] = true
 	}
 	m.mu.RUnlock()
@@ -605,46 +1032,136 @@ func (m *Manager) allSessions() ([]acp.SessionData, error) {
 		if seen[sessionID] {
 			continue
 		}
-		s, err := m.loadSessionFromDisk(sessionID)
-		if err != nil || s == nil {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		sum, err := m.loadSummary(sessionID, info)
+		if err != nil || sum == nil {
 			continue
 		}
-		result = append(result, cloneSession(*s))
+		result = append(result, *sum)
 	}
 
 	return result, nil
 }
 
-func matchesFilter(s acp.SessionData, filter map[string]any) bool {
-	if len(filter) == 0 {
-		return true
-	}
+// sessionFilter is a parsed session/list filter; see acp.ListSessionsRequest.
+type sessionFilter struct {
+	name          string
+	tag           string
+	statuses      []string
+	model         string
+	cwd           string
+	createdAfter  time.Time
+	createdBefore time.Time
+	updatedAfter  time.Time
+	updatedBefore time.Time
+}
+
+var sessionStatuses = []string{"busy", "active", "inactive", "expired"}
+
+func parseFilter(filter map[string]any) (sessionFilter, error) {
+	var f sessionFilter
 	for k, v := range filter {
 		switch k {
 		case "name":
-			name, _ := s.Metadata["name"].(string)
-			if !strings.Contains(strings.ToLower(name), strings.ToLower(fmt.Sprint(v))) {
-				return false
-			}
+			f.name = strings.ToLower(fmt.Sprint(v))
 		case "tags":
-			want := strings.ToLower(fmt.Sprint(v))
-			ok := false
-			slice, _ := s.Metadata["tags"].([]any)
-			for _, item := range slice {
-				if strings.ToLower(fmt.Sprint(item)) == want {
-					ok = true
-					break
+			f.tag = strings.ToLower(fmt.Sprint(v))
+		case "status":
+			values, _ := v.([]any)
+			if values == nil {
+				values = []any{v}
+			}
+			for _, value := range values {
+				status, _ := value.(string)
+				if !slices.Contains(sessionStatuses, status) {
+					return f, errcode.New(errcode.InvalidParams, "filter.status must be one of %s: %v", strings.Join(sessionStatuses, ", "), value)
 				}
+				f.statuses = append(f.statuses, status)
+			}
+		case "model":
+			f.model = strings.ToLower(fmt.Sprint(v))
+		case "cwd":
+			cwd, _ := v.(string)
+			if !filepath.IsAbs(cwd) {
+				return f, errcode.New(errcode.InvalidParams, "filter.cwd must be an absolute path: %v", v)
 			}
-			if !ok {
-				return false
+			f.cwd = filepath.Clean(cwd)
+		case "createdAfter", "createdBefore", "updatedAfter", "updatedBefore":
+			str, _ := v.(string)
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				return f, errcode.New(errcode.InvalidParams, "filter.%s must be an RFC 3339 time: %v", k, v)
 			}
+			switch k {
+			case "createdAfter":
+				f.createdAfter = t
+			case "createdBefore":
+				f.createdBefore = t
+			case "updatedAfter":
+				f.updatedAfter = t
+			case "updatedBefore":
+				f.updatedBefore = t
+			}
+		}
+	}
+	return f, nil
+}
+
+func (m *Manager) matchesFilter(s sessionSummary, f sessionFilter) bool {
+	if f.name != "" {
+		name, _ := s.Metadata["name"].(string)
+		if !strings.Contains(strings.ToLower(name), f.name) {
+			return false
+		}
+	}
+	if f.tag != "" {
+		slice, _ := s.Metadata["tags"].([]any)
+		if !slices.ContainsFunc(slice, func(item any) bool { return strings.ToLower(fmt.Sprint(item)) == f.tag }) {
+			return false
+		}
+	}
+	if len(f.statuses) > 0 && !slices.Contains(f.statuses, m.sessionStatus(s)) {
+		return false
+	}
+	if f.model != "" && strings.ToLower(s.State.CurrentModel) != f.model {
+		return false
+	}
+	if f.cwd != "" {
+		cwd, _ := s.Metadata["cwd"].(string)
+		if !isWithin(filepath.Clean(cwd), f.cwd) {
+			return false
 		}
 	}
+	if !f.createdAfter.IsZero() && s.CreatedAt.Before(f.createdAfter) {
+		return false
+	}
+	if !f.createdBefore.IsZero() && !s.CreatedAt.Before(f.createdBefore) {
+		return false
+	}
+	if !f.updatedAfter.IsZero() && s.UpdatedAt.Before(f.updatedAfter) {
+		return false
+	}
+	if !f.updatedBefore.IsZero() && !s.UpdatedAt.Before(f.updatedBefore) {
+		return false
+	}
 	return true
 }
 
-func (m *Manager) sessionStatus(s acp.SessionData) string {
+// isWithin reports whether path is dir or inside it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// sessionStatus is "busy" while a prompt runs in the session, otherwise
+// "active", "inactive" or "expired" by how long it has been idle.
+func (m *Manager) sessionStatus(s sessionSummary) string {
+	if m.IsProcessing(s.ID) {
+		return "busy"
+	}
 	delta := time.Since(s.State.LastActivity)
 	timeout := time.Duration(m.cfg.SessionTimeout) * time.Millisecond
 	if delta > timeout {
@@ -1,15 +1,24 @@
 package session
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/benchcheck"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
-func newTestManager(t *testing.T) *Manager {
+func newTestManager(t testing.TB) *Manager {
 	t.Helper()
 	cfg := config.Default()
 	cfg.SessionDir = t.TempDir()
@@ -60,3 +69,452 @@ func TestLoadSessionUpdatesActivityTimestamps(t *testing.T) {
 		t.Fatalf("expected LastActivity to advance on load: before=%s after=%s", initialLastActivity, loaded.State.LastActivity)
 	}
 }
+
+func TestCreateSessionAppliesDefaultsAndValidatesMetadata(t *testing.T) {
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Defaults = config.SessionDefaultsConfig{Model: "sonnet-4", Mode: "agent"}
+	normalized, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatalf("failed to normalize config: %v", err)
+	}
+	m := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+
+	// Until cursor-agent lists the configured model, sessions use auto.
+	if session, err := m.CreateSession(nil); err != nil || session.State.CurrentModel != "auto" || session.State.CurrentMode != "agent" {
+		t.Fatalf("unexpected session state %+v (%v)", session, err)
+	}
+
+	m.SetAvailableModels([]acp.SessionModel{{ID: "auto"}, {ID: "sonnet-4"}, {ID: "gpt-5"}})
+	session, err := m.CreateSession(nil)
+	if err != nil || session.State.CurrentModel != "sonnet-4" || m.GetSessionModel("") != "sonnet-4" {
+		t.Fatalf("expected defaults.model, got %+v (%v)", session, err)
+	}
+	session, err = m.CreateSession(map[string]any{"model": "gpt-5", "mode": "plan"})
+	if err != nil || session.State.CurrentModel != "gpt-5" || session.State.CurrentMode != "plan" {
+		t.Fatalf("expected metadata to override defaults, got %+v (%v)", session, err)
+	}
+
+	if _, err := m.CreateSession(map[string]any{"model": "nope"}); err == nil || err.Error() != "invalid model: nope" {
+		t.Fatalf("expected invalid model error, got %v", err)
+	}
+	if _, err := m.CreateSession(map[string]any{"mode": "yolo"}); err == nil || err.Error() != "invalid mode: yolo" {
+		t.Fatalf("expected invalid mode error, got %v", err)
+	}
+}
+
+func TestEncryptedSessionsRoundTrip(t *testing.T) {
+	t.Setenv("CURSOR_ACP_TEST_SESSION_KEY", "correct horse battery staple")
+
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.SessionEncryption.Enabled = true
+	cfg.SessionEncryption.KeyEnv = "CURSOR_ACP_TEST_SESSION_KEY"
+	normalized, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatalf("failed to normalize config: %v", err)
+	}
+	m := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+
+	session, err := m.CreateSession(map[string]any{"name": "proprietary-secret-project"})
+	if err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+
+	raw, err := os.ReadFile(m.sessionPath(session.ID))
+	if err != nil {
+		t.Fatalf("failed to read session file: %v", err)
+	}
+	if strings.Contains(string(raw), "proprietary-secret-project") {
+		t.Fatalf("expected session file to be encrypted, found plaintext metadata")
+	}
+
+	other := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { other.Close() })
+	loaded, err := other.LoadSession(session.ID)
+	if err != nil {
+		t.Fatalf("LoadSession returned error: %v", err)
+	}
+	if loaded.Metadata["name"] != "proprietary-secret-project" {
+		t.Fatalf("unexpected metadata after decrypt: %#v", loaded.Metadata)
+	}
+
+	t.Setenv("CURSOR_ACP_TEST_SESSION_KEY", "wrong key")
+	wrong := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { wrong.Close() })
+	if _, err := wrong.LoadSession(session.ID); err == nil {
+		t.Fatalf("expected LoadSession with wrong key to fail")
+	}
+}
+
+func TestEncryptionWithoutKeyRefusesToPersist(t *testing.T) {
+	t.Setenv("CURSOR_ACP_TEST_SESSION_KEY", "")
+
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.SessionEncryption.Enabled = true
+	cfg.SessionEncryption.KeyEnv = "CURSOR_ACP_TEST_SESSION_KEY"
+	m := NewManager(cfg, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+
+	if _, err := m.CreateSession(nil); err == nil {
+		t.Fatalf("expected CreateSession to fail without an encryption key")
+	}
+}
+
+func TestAcquireLockFailsForSecondInstance(t *testing.T) {
+	first := newTestManager(t)
+	if err := first.AcquireLock(); err != nil {
+		t.Fatalf("first AcquireLock returned error: %v", err)
+	}
+
+	second := NewManager(first.cfg, logging.New("error"))
+	t.Cleanup(func() { second.Close() })
+	err := second.AcquireLock()
+	if err == nil {
+		t.Fatalf("expected second AcquireLock to fail")
+	}
+	if !strings.Contains(err.Error(), "locked by another cursor-agent-acp instance") {
+		t.Fatalf("unexpected lock error: %v", err)
+	}
+
+	first.ReleaseLock()
+	if err := second.AcquireLock(); err != nil {
+		t.Fatalf("expected AcquireLock to succeed after release, got %v", err)
+	}
+}
+
+func TestAcquireLockTakesOverStaleLock(t *testing.T) {
+	m := newTestManager(t)
+
+	stale := lockOwner{PID: 1, Hostname: "elsewhere", Token: "stale", HeartbeatAt: time.Now().Add(-time.Hour)}
+	buf, _ := json.Marshal(stale)
+	if err := os.WriteFile(filepath.Join(m.cfg.SessionDir, lockFileName), buf, 0o644); err != nil {
+		t.Fatalf("failed to write stale lock: %v", err)
+	}
+
+	if err := m.AcquireLock(); err != nil {
+		t.Fatalf("expected stale lock to be taken over, got %v", err)
+	}
+	owner, err := readLockOwner(filepath.Join(m.cfg.SessionDir, lockFileName))
+	if err != nil {
+		t.Fatalf("failed to read lock owner: %v", err)
+	}
+	if owner.PID != os.Getpid() {
+		t.Fatalf("expected lock to be owned by this process, got pid %d", owner.PID)
+	}
+}
+
+func TestStaleLockTakeoverKeepsALockCreatedMeanwhile(t *testing.T) {
+	m := newTestManager(t)
+	path := filepath.Join(m.cfg.SessionDir, lockFileName)
+	stale, _ := json.Marshal(lockOwner{PID: 1, Token: "stale", HeartbeatAt: time.Now().Add(-time.Hour)})
+	fresh, _ := json.Marshal(lockOwner{PID: 2, Token: "fresh", HeartbeatAt: time.Now()})
+	if err := os.WriteFile(path, fresh, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// This instance judged the lock stale before another one replaced it.
+	l := &dirLock{path: path, owner: lockOwner{Token: "slow"}}
+	if err := l.takeOver(stale); err != nil {
+		t.Fatalf("takeOver returned error: %v", err)
+	}
+	if owner, err := readLockOwner(path); err != nil || owner.Token != "fresh" {
+		t.Fatalf("expected the fresh lock to be kept, got %+v (%v)", owner, err)
+	}
+	if err := m.AcquireLock(); err == nil || !strings.Contains(err.Error(), "pid 2") {
+		t.Fatalf("expected the fresh lock to block AcquireLock, got %v", err)
+	}
+}
+
+func TestLockHeartbeatStopsPersistingOnceTakenOver(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AcquireLock(); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := json.Marshal(lockOwner{PID: 2, Hostname: "elsewhere", Token: "other", HeartbeatAt: time.Now()})
+	if err := os.WriteFile(filepath.Join(m.cfg.SessionDir, lockFileName), other, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.lock.heartbeat()
+	if !errors.Is(err, errLockLost) {
+		t.Fatalf("expected the heartbeat to notice the new owner, got %v", err)
+	}
+	m.lock.onLost(err)
+	if err := m.persistSession(&acp.SessionData{ID: "s1"}); err == nil {
+		t.Fatalf("expected sessions not to be persisted after the lock was lost")
+	}
+}
+
+func TestModelStateIncludesModelMetadata(t *testing.T) {
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Models.Info = map[string]config.ModelInfo{
+		"gpt-5*":      {ContextWindow: 400_000, Vision: true, Tier: "premium"},
+		"gpt-5-local": {ContextWindow: 32_000},
+	}
+	normalized, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatalf("failed to normalize config: %v", err)
+	}
+	m := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+	m.SetAvailableModels([]acp.SessionModel{{ID: "sonnet-4.5-thinking"}, {ID: "gpt-5-codex"}, {ID: "gpt-5-local"}, {ID: "mystery"}})
+
+	metas := map[string]map[string]any{}
+	for _, entry := range m.GetSessionModelState("").AvailableModels {
+		metas[entry.ModelID] = entry.Meta
+	}
+	if meta := metas["sonnet-4.5-thinking"]; meta["reasoning"] != true || meta["vision"] != true || meta["contextWindow"] != 200_000 || meta["tier"] != "standard" {
+		t.Fatalf("unexpected built-in metadata %v", meta)
+	}
+	if meta := metas["gpt-5-codex"]; meta["contextWindow"] != 400_000 || meta["tier"] != "premium" || meta["reasoning"] != false {
+		t.Fatalf("config glob should replace the built-in entry, got %v", meta)
+	}
+	if meta := metas["gpt-5-local"]; meta["contextWindow"] != 32_000 || meta["vision"] != false {
+		t.Fatalf("exact config entry should win over globs, got %v", meta)
+	}
+	if metas["mystery"] != nil || !m.ModelInfo("mystery").Vision || m.ModelInfo("gpt-5-local").Vision {
+		t.Fatalf("unexpected metadata for unknown or text-only models")
+	}
+}
+
+func TestModelPolicyFiltersAndResolvesAliases(t *testing.T) {
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Models.Allow = []string{"gpt-5*", "sonnet-*"}
+	cfg.Models.Deny = []string{"*-high"}
+	cfg.Models.Aliases = map[string]string{"fast": "gpt-5-mini", "gone": "o3"}
+	cfg.Defaults.Model = "fast"
+	normalized, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatalf("failed to normalize config: %v", err)
+	}
+	m := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+	m.SetAvailableModels([]acp.SessionModel{{ID: "auto"}, {ID: "gpt-5"}, {ID: "gpt-5-high"}, {ID: "gpt-5-mini", Name: "GPT-5 Mini"}, {ID: "sonnet-4.5"}, {ID: "o3"}})
+
+	ids := []string{}
+	for _, model := range m.GetAvailableModels() {
+		ids = append(ids, model.ID)
+	}
+	if strings.Join(ids, ",") != "gpt-5,gpt-5-mini,sonnet-4.5" {
+		t.Fatalf("unexpected allowed models %v", ids)
+	}
+
+	session, err := m.CreateSession(nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if got := m.GetSessionModel(session.ID); got != "gpt-5-mini" {
+		t.Fatalf("defaults.model alias should resolve, got %q", got)
+	}
+	if _, err := m.SetSessionModel(session.ID, "gpt-5-high"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("denied model should be rejected, got %v", err)
+	}
+	if _, err := m.SetSessionModel(session.ID, "auto"); err == nil {
+		t.Fatal("model outside models.allow should be rejected")
+	}
+	if _, err := m.SetSessionModel(session.ID, "gpt-5"); err != nil {
+		t.Fatalf("failed to set allowed model: %v", err)
+	}
+
+	state := m.GetSessionModelState(session.ID)
+	alias := state.AvailableModels[len(state.AvailableModels)-1]
+	if len(state.AvailableModels) != 4 || alias.ModelID != "fast" || alias.Name != "fast (GPT-5 Mini)" || alias.Meta["aliasFor"] != "gpt-5-mini" {
+		t.Fatalf("unexpected alias entries %+v", state.AvailableModels)
+	}
+}
+
+func TestListSessionsReadsIndexNotConversations(t *testing.T) {
+	m := newTestManager(t)
+	session, err := m.CreateSession(map[string]any{"name": "Indexed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(m.summaryPath(session.ID)); err != nil {
+		t.Fatalf("expected index entry: %v", err)
+	}
+
+	// A fresh manager lists from disk; the index entry alone must suffice.
+	listed := NewManager(m.cfg, logging.New("error"))
+	t.Cleanup(listed.Close)
+	path := m.sessionPath(session.ID)
+	old := time.Now().Add(-time.Hour)
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	infos, total, _, err := listed.ListSessions(10, 0, map[string]any{"name": "index"})
+	if err != nil || total != 1 || infos[0].ID != session.ID {
+		t.Fatalf("expected the indexed session, got %+v total=%d err=%v", infos, total, err)
+	}
+
+	// Sessions without an entry are loaded once and indexed.
+	legacy, err := m.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Dir(m.summaryPath(legacy.ID))); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _, err := listed.ListSessions(10, 0, nil); err != nil || total != 1 {
+		t.Fatalf("expected only the loadable session, total=%d err=%v", total, err)
+	}
+	if _, err := os.Stat(m.summaryPath(legacy.ID)); err != nil {
+		t.Fatalf("expected the session to be indexed: %v", err)
+	}
+
+	if err := m.DeleteSession(legacy.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(m.summaryPath(legacy.ID)); !os.IsNotExist(err) {
+		t.Fatalf("expected the index entry to be deleted, got %v", err)
+	}
+}
+
+func TestListSessionsFilters(t *testing.T) {
+	m := newTestManager(t)
+	dir := t.TempDir()
+	create := func(cwd, model string, age time.Duration) string {
+		t.Helper()
+		s, err := m.CreateSession(map[string]any{"cwd": cwd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.mu.Lock()
+		stored := m.sessions[s.ID]
+		stored.State.CurrentModel = model
+		stored.State.LastActivity = time.Now().UTC().Add(-age)
+		stored.CreatedAt = stored.CreatedAt.Add(-age)
+		stored.UpdatedAt = stored.UpdatedAt.Add(-age)
+		m.mu.Unlock()
+		return s.ID
+	}
+	timeout := time.Duration(m.cfg.SessionTimeout) * time.Millisecond
+	fresh := create(filepath.Join(dir, "app"), "gpt-5", 0)
+	idle := create(filepath.Join(dir, "app", "web"), "sonnet-4", timeout*3/4)
+	old := create(filepath.Join(dir, "apple"), "gpt-5", 2*timeout)
+	cutoff := time.Now().UTC().Add(-timeout / 4).Format(time.RFC3339)
+
+	for _, tc := range []struct {
+		filter map[string]any
+		want   []string
+	}{
+		{map[string]any{"status": "active"}, []string{fresh}},
+		{map[string]any{"status": []any{"inactive", "expired"}}, []string{idle, old}},
+		{map[string]any{"model": "GPT-5"}, []string{fresh, old}},
+		{map[string]any{"cwd": filepath.Join(dir, "app")}, []string{fresh, idle}},
+		{map[string]any{"createdAfter": cutoff}, []string{fresh}},
+		{map[string]any{"updatedBefore": cutoff, "model": "gpt-5"}, []string{old}},
+	} {
+		infos, total, _, err := m.ListSessions(10, 0, tc.filter)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.filter, err)
+		}
+		got := make([]string, 0, len(infos))
+		for _, info := range infos {
+			got = append(got, info.ID)
+		}
+		if total != len(tc.want) || !slices.Equal(got, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	m.MarkProcessing(fresh)
+	if infos, _, _, err := m.ListSessions(10, 0, map[string]any{"status": "busy"}); err != nil || len(infos) != 1 || infos[0].Status != "busy" {
+		t.Fatalf("expected the processing session to be busy, got %+v err=%v", infos, err)
+	}
+
+	for _, filter := range []map[string]any{
+		{"status": "sleeping"},
+		{"cwd": "relative/dir"},
+		{"updatedAfter": "yesterday"},
+	} {
+		if _, _, _, err := m.ListSessions(10, 0, filter); err == nil {
+			t.Errorf("%v: expected an error", filter)
+		}
+	}
+}
+
+// benchmarkSession creates a session with a 100-message conversation.
+func benchmarkSession(b *testing.B, m *Manager) *acp.SessionData {
+	session, err := m.CreateSession(map[string]any{"cwd": "/tmp/project"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		msg := acp.ConversationMessage{ID: fmt.Sprintf("msg-%d", i), Role: role, Timestamp: time.Now(), Content: []acp.ContentBlock{{Type: "text", Text: strings.Repeat("some conversation text ", 40)}}}
+		if err := m.AddMessage(session.ID, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	loaded, err := m.LoadSession(session.ID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return loaded
+}
+
+func BenchmarkPersistSession(b *testing.B) {
+	m := newTestManager(b)
+	session := benchmarkSession(b, m)
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := m.persistSession(session); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadSessionFromDisk(b *testing.B) {
+	m := newTestManager(b)
+	session := benchmarkSession(b, m)
+	b.ReportAllocs()
+	for b.Loop() {
+		if loaded, err := m.loadSessionFromDisk(session.ID); err != nil || loaded == nil {
+			b.Fatalf("load failed: %v", err)
+		}
+	}
+}
+
+func TestBenchmarkBudgets(t *testing.T) {
+	benchcheck.Check(t, map[string]func(*testing.B){
+		"PersistSession":      BenchmarkPersistSession,
+		"LoadSessionFromDisk": BenchmarkLoadSessionFromDisk,
+	}, map[string]benchcheck.Budget{
+		"PersistSession":      {NsPerOp: 20_000_000, AllocsPerOp: 60, BytesPerOp: 512 << 10},
+		"LoadSessionFromDisk": {NsPerOp: 20_000_000, AllocsPerOp: 700, BytesPerOp: 1 << 20},
+	})
+}
+
+func TestDeleteIdleSessionLeavesBusySessions(t *testing.T) {
+	m := newTestManager(t)
+	sess, err := m.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.MarkProcessing(sess.ID)
+	if deleted, err := m.DeleteIdleSession(sess.ID, nil); deleted || err != nil {
+		t.Fatalf("expected a processing session to be kept, got %v %v", deleted, err)
+	}
+	m.UnmarkProcessing(sess.ID)
+	if deleted, err := m.DeleteIdleSession(sess.ID, func() bool { return true }); deleted || err != nil {
+		t.Fatalf("expected a busy session to be kept, got %v %v", deleted, err)
+	}
+	if deleted, err := m.DeleteIdleSession(sess.ID, func() bool { return false }); !deleted || err != nil {
+		t.Fatalf("expected an idle session to be deleted, got %v %v", deleted, err)
+	}
+	if _, err := m.LoadSession(sess.ID); err == nil {
+		t.Fatal("expected the deleted session to be gone")
+	}
+}
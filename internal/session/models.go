@@ -0,0 +1,160 @@
+package session
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+type modelPattern struct {
+	pattern string
+	info    config.ModelInfo
+}
+
+// builtinModels describes the models cursor-agent offers, matched in order
+// against the lowercased model ID; the first match wins. Prices are list
+// prices and only approximate what a Cursor plan is charged.
+var builtinModels = []modelPattern{
+	{"auto", config.ModelInfo{ContextWindow: 200_000, Vision: true, Tier: "standard"}},
+	{"composer*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Tier: "fast", InputCostPerMTok: 1.25, OutputCostPerMTok: 10}},
+	{"cheetah*", config.ModelInfo{ContextWindow: 200_000, Tier: "fast", InputCostPerMTok: 1.25, OutputCostPerMTok: 10}},
+	{"*opus*thinking*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Reasoning: true, Tier: "premium", InputCostPerMTok: 15, OutputCostPerMTok: 75}},
+	{"*opus*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Tier: "premium", InputCostPerMTok: 15, OutputCostPerMTok: 75}},
+	{"*sonnet*thinking*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Reasoning: true, Tier: "standard", InputCostPerMTok: 3, OutputCostPerMTok: 15}},
+	{"*sonnet*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Tier: "standard", InputCostPerMTok: 3, OutputCostPerMTok: 15}},
+	{"*haiku*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Tier: "fast", InputCostPerMTok: 1, OutputCostPerMTok: 5}},
+	{"gpt-5*mini*", config.ModelInfo{ContextWindow: 272_000, Vision: true, Reasoning: true, Tier: "fast", InputCostPerMTok: 0.25, OutputCostPerMTok: 2}},
+	{"gpt-5*nano*", config.ModelInfo{ContextWindow: 272_000, Vision: true, Reasoning: true, Tier: "fast", InputCostPerMTok: 0.05, OutputCostPerMTok: 0.4}},
+	{"gpt-5*", config.ModelInfo{ContextWindow: 272_000, Vision: true, Reasoning: true, Tier: "standard", InputCostPerMTok: 1.25, OutputCostPerMTok: 10}},
+	{"gpt-4o*", config.ModelInfo{ContextWindow: 128_000, Vision: true, Audio: true, Tier: "standard", InputCostPerMTok: 2.5, OutputCostPerMTok: 10}},
+	{"gpt-4.1*", config.ModelInfo{ContextWindow: 1_047_576, Vision: true, Tier: "standard", InputCostPerMTok: 2, OutputCostPerMTok: 8}},
+	{"o3*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Reasoning: true, Tier: "premium", InputCostPerMTok: 2, OutputCostPerMTok: 8}},
+	{"o4*", config.ModelInfo{ContextWindow: 200_000, Vision: true, Reasoning: true, Tier: "standard", InputCostPerMTok: 1.1, OutputCostPerMTok: 4.4}},
+	{"gemini*flash*", config.ModelInfo{ContextWindow: 1_048_576, Vision: true, Audio: true, Tier: "fast", InputCostPerMTok: 0.3, OutputCostPerMTok: 2.5}},
+	{"gemini*", config.ModelInfo{ContextWindow: 1_048_576, Vision: true, Audio: true, Reasoning: true, Tier: "standard", InputCostPerMTok: 1.25, OutputCostPerMTok: 10}},
+	{"grok*", config.ModelInfo{ContextWindow: 256_000, Reasoning: true, Tier: "standard", InputCostPerMTok: 3, OutputCostPerMTok: 15}},
+	{"deepseek*", config.ModelInfo{ContextWindow: 128_000, Tier: "standard", InputCostPerMTok: 0.27, OutputCostPerMTok: 1.1}},
+}
+
+// unknownModel is assumed for models neither the built-in table nor the
+// config describes: images are still attached, since most models accept
+// them and a missing image is worse than an ignored one.
+var unknownModel = config.ModelInfo{Vision: true}
+
+// lookupModelInfo returns what is known about modelID: the config entry for
+// it (exact ID first, then the longest matching glob), else the first
+// built-in match. known is false when neither describes it.
+func lookupModelInfo(modelID string, overrides map[string]config.ModelInfo) (info config.ModelInfo, known bool) {
+	id := strings.ToLower(modelID)
+	if info, ok := overrides[modelID]; ok {
+		return info, true
+	}
+	patterns := make([]string, 0, len(overrides))
+	for pattern := range overrides {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), id); ok {
+			return overrides[pattern], true
+		}
+	}
+	for _, m := range builtinModels {
+		if ok, _ := path.Match(m.pattern, id); ok {
+			return m.info, true
+		}
+	}
+	return unknownModel, false
+}
+
+// ModelInfo returns the metadata for modelID from models.info and the
+// built-in table.
+func (m *Manager) ModelInfo(modelID string) config.ModelInfo {
+	info, _ := lookupModelInfo(m.ResolveModel(modelID), m.cfg.Models.Info)
+	return info
+}
+
+func matchesAnyModel(patterns []string, id string) bool {
+	id = strings.ToLower(id)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// modelAllowed applies models.allow and models.deny to modelID.
+func modelAllowed(modelID string, cfg config.ModelsConfig) bool {
+	return (len(cfg.Allow) == 0 || matchesAnyModel(cfg.Allow, modelID)) && !matchesAnyModel(cfg.Deny, modelID)
+}
+
+// AllowedModels returns the models that models.allow and models.deny let
+// sessions select.
+func (m *Manager) AllowedModels(models []acp.SessionModel) []acp.SessionModel {
+	out := make([]acp.SessionModel, 0, len(models))
+	for _, model := range models {
+		if modelAllowed(model.ID, m.cfg.Models) {
+			out = append(out, model)
+		}
+	}
+	return out
+}
+
+// ResolveModel returns the model ID a models.aliases name stands for, or
+// modelID itself.
+func (m *Manager) ResolveModel(modelID string) string {
+	if target, ok := m.cfg.Models.Aliases[modelID]; ok {
+		return target
+	}
+	return modelID
+}
+
+// ModelAliases returns the aliases whose model is available, by name.
+func (m *Manager) ModelAliases() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.modelAliasesLocked()
+}
+
+func (m *Manager) modelAliasesLocked() map[string]string {
+	out := make(map[string]string, len(m.cfg.Models.Aliases))
+	for alias, target := range m.cfg.Models.Aliases {
+		if m.hasModelLocked(target) {
+			out[alias] = target
+		}
+	}
+	return out
+}
+
+// modelMeta is the _meta of a model picker entry.
+func modelMeta(modelID string, overrides map[string]config.ModelInfo) map[string]any {
+	info, known := lookupModelInfo(modelID, overrides)
+	if !known {
+		return nil
+	}
+	meta := map[string]any{
+		"vision":    info.Vision,
+		"audio":     info.Audio,
+		"reasoning": info.Reasoning,
+	}
+	if info.ContextWindow > 0 {
+		meta["contextWindow"] = info.ContextWindow
+	}
+	if info.Tier != "" {
+		meta["tier"] = info.Tier
+	}
+	if info.InputCostPerMTok > 0 || info.OutputCostPerMTok > 0 {
+		meta["inputCostPerMTok"] = info.InputCostPerMTok
+		meta["outputCostPerMTok"] = info.OutputCostPerMTok
+	}
+	return meta
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"sort"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
+)
+
+// RecordUsage prices turn with its model's prices and adds it to the
+// session's usage, returning the priced turn and the new session totals.
+func (m *Manager) RecordUsage(sessionID string, turn acp.TurnUsage) (acp.TurnUsage, acp.Usage, error) {
+	info := m.ModelInfo(turn.Model)
+	turn.Priced = info.InputCostPerMTok > 0 || info.OutputCostPerMTok > 0
+	turn.CostUSD = (float64(turn.InputTokens)*info.InputCostPerMTok + float64(turn.OutputTokens)*info.OutputCostPerMTok) / 1_000_000
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		m.mu.Unlock()
+		loaded, err := m.loadSessionFromDisk(sessionID)
+		m.mu.Lock()
+		if err != nil {
+			return turn, acp.Usage{}, err
+		}
+		if loaded == nil {
+			return turn, acp.Usage{}, errcode.New(errcode.SessionNotFound, "session not found: %s", sessionID)
+		}
+		s = loaded
+		m.sessions[sessionID] = s
+	}
+	addUsage(&s.State.Usage, turn)
+	s.State.TokenCount = s.State.Usage.InputTokens + s.State.Usage.OutputTokens
+	return turn, s.State.Usage, m.persistSession(s)
+}
+
+// UsageSummary returns the usage of one session or, when sessionID is
+// empty, the total and per-session usage of every stored session.
+func (m *Manager) UsageSummary(sessionID string) (map[string]any, error) {
+	if sessionID != "" {
+		s, err := m.LoadSession(sessionID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"sessionId": sessionID, "usage": s.State.Usage}, nil
+	}
+
+	sessions, err := m.allSummaries()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].State.Usage.CostUSD > sessions[j].State.Usage.CostUSD })
+	var total acp.Usage
+	perSession := make([]map[string]any, 0, len(sessions))
+	for _, s := range sessions {
+		if s.State.Usage.Turns == 0 {
+			continue
+		}
+		mergeUsage(&total, s.State.Usage)
+		perSession = append(perSession, map[string]any{"sessionId": s.ID, "usage": s.State.Usage})
+	}
+	return map[string]any{"usage": total, "sessions": perSession}, nil
+}
+
+func addUsage(u *acp.Usage, turn acp.TurnUsage) {
+	u.Turns++
+	u.InputTokens += turn.InputTokens
+	u.OutputTokens += turn.OutputTokens
+	u.CostUSD += turn.CostUSD
+	if turn.Estimated {
+		u.EstimatedTurns++
+	}
+	if !turn.Priced {
+		u.UnpricedTurns++
+	}
+}
+
+func mergeUsage(u *acp.Usage, other acp.Usage) {
+	u.Turns += other.Turns
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CostUSD += other.CostUSD
+	u.EstimatedTurns += other.EstimatedTurns
+	u.UnpricedTurns += other.UnpricedTurns
+}
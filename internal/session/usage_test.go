@@ -0,0 +1,60 @@
+package session
+
+import (
+	"math"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func TestRecordUsagePricesTurnsAndSumsSessions(t *testing.T) {
+	cfg := config.Default()
+	cfg.SessionDir = t.TempDir()
+	cfg.Models.Info = map[string]config.ModelInfo{"local-*": {ContextWindow: 32_000}}
+	normalized, err := config.Normalize(cfg)
+	if err != nil {
+		t.Fatalf("failed to normalize config: %v", err)
+	}
+	m := NewManager(normalized, logging.New("error"))
+	t.Cleanup(func() { m.Close() })
+
+	a, err := m.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	turn, total, err := m.RecordUsage(a.ID, acp.TurnUsage{Model: "sonnet-4.5", InputTokens: 100_000, OutputTokens: 10_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !turn.Priced || math.Abs(turn.CostUSD-0.45) > 1e-9 || total.Turns != 1 {
+		t.Fatalf("unexpected priced turn %+v (total %+v)", turn, total)
+	}
+	_, total, _ = m.RecordUsage(a.ID, acp.TurnUsage{Model: "local-llama", InputTokens: 500, OutputTokens: 50, Estimated: true})
+	if total.Turns != 2 || total.InputTokens != 100_500 || total.UnpricedTurns != 1 || total.EstimatedTurns != 1 || math.Abs(total.CostUSD-0.45) > 1e-9 {
+		t.Fatalf("unexpected session total %+v", total)
+	}
+	if _, _, err := m.RecordUsage(b.ID, acp.TurnUsage{Model: "gpt-5", InputTokens: 1_000_000}); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := m.UsageSummary("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := summary["usage"].(acp.Usage)
+	sessions := summary["sessions"].([]map[string]any)
+	if all.Turns != 3 || math.Abs(all.CostUSD-1.7) > 1e-9 || len(sessions) != 2 || sessions[0]["sessionId"] != b.ID {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	one, err := m.UsageSummary(a.ID)
+	if err != nil || one["usage"].(acp.Usage).Turns != 2 {
+		t.Fatalf("unexpected session summary %+v (%v)", one, err)
+	}
+}
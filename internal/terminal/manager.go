@@ -1,12 +1,14 @@
 package terminal
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
@@ -19,6 +21,14 @@ type ManagerConfig struct {
 	AllowedCommands         []string
 	DefaultCwd              string
 	DefaultEnv              []client.EnvVariable
+	// EnvPolicy rejects terminal env variables it withholds. Nil allows all.
+	EnvPolicy *envpolicy.Policy
+	// CommandSafety is how closely commands are checked against
+	// ForbiddenCommands: "basic", "standard" (the default) or "strict".
+	CommandSafety string
+	// IdleTimeout kills and releases terminals nobody has touched for this
+	// long. Zero disables reaping.
+	IdleTimeout time.Duration
 }
 
 type TerminalMetadata struct {
@@ -40,22 +50,30 @@ type CreateParams struct {
 
 type Handle struct {
 	TerminalID string
+	SessionID  string
 	manager    *Manager
 
 	mu       sync.Mutex
 	released bool
 }
 
-func (h *Handle) CurrentOutput() (client.TerminalOutputResponse, error) {
-	return h.manager.conn.GetTerminalOutput(client.TerminalOutputRequest{TerminalID: h.TerminalID})
+func (h *Handle) CurrentOutput(ctx context.Context) (client.TerminalOutputResponse, error) {
+	h.manager.UpdateActivity(h.TerminalID)
+	return h.manager.conn.GetTerminalOutput(ctx, client.TerminalOutputRequest{SessionID: h.SessionID, TerminalID: h.TerminalID})
 }
 
-func (h *Handle) WaitForExit() (client.WaitForTerminalExitResponse, error) {
-	return h.manager.conn.WaitForTerminalExit(client.WaitForTerminalExitRequest{TerminalID: h.TerminalID})
+// WaitForExit blocks until the command exits. The terminal is not idle while
+// someone is waiting on it, however long the command runs.
+func (h *Handle) WaitForExit(ctx context.Context) (client.WaitForTerminalExitResponse, error) {
+	h.manager.setWaiting(h.TerminalID, 1)
+	defer h.manager.setWaiting(h.TerminalID, -1)
+	return h.manager.conn.WaitForTerminalExit(ctx, client.WaitForTerminalExitRequest{SessionID: h.SessionID, TerminalID: h.TerminalID})
 }
 
+// Kill and Release are cleanup and ignore cancellation of the work that
+// started the terminal, so a cancelled prompt still stops its command.
 func (h *Handle) Kill() error {
-	return h.manager.conn.KillTerminal(client.KillTerminalRequest{TerminalID: h.TerminalID})
+	return h.manager.conn.KillTerminal(context.Background(), client.KillTerminalRequest{SessionID: h.SessionID, TerminalID: h.TerminalID})
 }
 
 func (h *Handle) Release() error {
@@ -67,8 +85,11 @@ func (h *Handle) Release() error {
 	h.released = true
 	h.mu.Unlock()
 
+	if !h.manager.tracked(h.TerminalID) {
+		return nil // already stopped by ReapIdle or CleanupSession
+	}
 	defer h.manager.ReleaseTerminal(h.TerminalID)
-	return h.manager.conn.ReleaseTerminal(client.ReleaseTerminalRequest{TerminalID: h.TerminalID})
+	return h.manager.conn.ReleaseTerminal(context.Background(), client.ReleaseTerminalRequest{SessionID: h.SessionID, TerminalID: h.TerminalID})
 }
 
 type Manager struct {
@@ -78,25 +99,35 @@ type Manager struct {
 
 	mu      sync.Mutex
 	termMap map[string]TerminalMetadata
+	waiting map[string]int
+	stop    chan struct{}
 }
 
+// NewManager starts the idle reaper when cfg.IdleTimeout is set; Close stops
+// it.
 func NewManager(cfg ManagerConfig, conn client.Connection, logger *logging.Logger) *Manager {
 	if cfg.MaxConcurrentTerminals <= 0 {
 		cfg.MaxConcurrentTerminals = 5
 	}
-	return &Manager{
+	m := &Manager{
 		cfg:     cfg,
 		conn:    conn,
 		logger:  logger,
 		termMap: map[string]TerminalMetadata{},
+		waiting: map[string]int{},
+	}
+	if cfg.IdleTimeout > 0 {
+		m.stop = make(chan struct{})
+		go m.reapLoop(m.stop)
 	}
+	return m
 }
 
 func (m *Manager) CanCreateTerminals() bool {
 	return m.cfg.ClientSupportsTerminals
 }
 
-func (m *Manager) CreateTerminal(sessionID string, params CreateParams) (*Handle, error) {
+func (m *Manager) CreateTerminal(ctx context.Context, sessionID string, params CreateParams) (*Handle, error) {
 	if !m.cfg.ClientSupportsTerminals {
 		return nil, fmt.Errorf("client does not support terminal operations")
 	}
@@ -106,7 +137,11 @@ func (m *Manager) CreateTerminal(sessionID string, params CreateParams) (*Handle
 	if strings.TrimSpace(params.Command) == "" {
 		return nil, fmt.Errorf("command is required")
 	}
-	if err := m.validateCommand(params.Command); err != nil {
+	names := make([]string, 0, len(params.Env))
+	for _, v := range params.Env {
+		names = append(names, v.Name)
+	}
+	if err := m.CheckCommand(params.Command, params.Args, names...); err != nil {
 		return nil, err
 	}
 
@@ -131,7 +166,7 @@ func (m *Manager) CreateTerminal(sessionID string, params CreateParams) (*Handle
 		env = append(env, m.cfg.DefaultEnv...)
 	}
 
-	resp, err := m.conn.CreateTerminal(client.CreateTerminalRequest{
+	resp, err := m.conn.CreateTerminal(ctx, client.CreateTerminalRequest{
 		SessionID:       sessionID,
 		Command:         params.Command,
 		Args:            params.Args,
@@ -163,16 +198,59 @@ func (m *Manager) CreateTerminal(sessionID string, params CreateParams) (*Handle
 
 	return &Handle{
 		TerminalID: resp.TerminalID,
+		SessionID:  sessionID,
 		manager:    m,
 	}, nil
 }
 
+// CheckCommand returns the error CreateTerminal would refuse command with
+// under the command safety and environment policies.
+func (m *Manager) CheckCommand(command string, args []string, envNames ...string) error {
+	if err := m.validateCommand(command, args); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	policy := m.cfg.EnvPolicy
+	m.mu.Unlock()
+	return policy.Check(envNames...)
+}
+
+// SetEnvPolicy replaces the environment policy for terminals created after
+// it returns.
+func (m *Manager) SetEnvPolicy(policy *envpolicy.Policy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg.EnvPolicy = policy
+}
+
 func (m *Manager) ReleaseTerminal(terminalID string) {
 	m.mu.Lock()
 	delete(m.termMap, terminalID)
+	delete(m.waiting, terminalID)
 	m.mu.Unlock()
 }
 
+func (m *Manager) tracked(terminalID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.termMap[terminalID]
+	return ok
+}
+
+func (m *Manager) setWaiting(terminalID string, delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.termMap[terminalID]; !ok {
+		return
+	}
+	if m.waiting[terminalID] += delta; m.waiting[terminalID] <= 0 {
+		delete(m.waiting, terminalID)
+	}
+	meta := m.termMap[terminalID]
+	meta.LastActivity = time.Now().UTC()
+	m.termMap[terminalID] = meta
+}
+
 func (m *Manager) UpdateActivity(terminalID string) {
 	m.mu.Lock()
 	meta, ok := m.termMap[terminalID]
@@ -194,20 +272,86 @@ func (m *Manager) ActiveTerminals() []TerminalMetadata {
 }
 
 func (m *Manager) Cleanup() {
+	for _, meta := range m.take(func(TerminalMetadata) bool { return true }) {
+		_ = m.conn.ReleaseTerminal(context.Background(), client.ReleaseTerminalRequest{SessionID: meta.SessionID, TerminalID: meta.ID})
+	}
+}
+
+// CleanupSession kills and releases every terminal of sessionID, e.g. when
+// the session is cancelled or deleted. It returns how many were stopped.
+func (m *Manager) CleanupSession(sessionID string) int {
+	stopped := m.take(func(meta TerminalMetadata) bool { return meta.SessionID == sessionID })
+	for _, meta := range stopped {
+		m.terminate(meta, "session cleanup")
+	}
+	return len(stopped)
+}
+
+// ReapIdle kills and releases terminals idle for longer than the idle
+// timeout that nobody is waiting on. It returns how many were stopped.
+func (m *Manager) ReapIdle() int {
+	if m.cfg.IdleTimeout <= 0 {
+		return 0
+	}
+	cutoff := time.Now().UTC().Add(-m.cfg.IdleTimeout)
+	stopped := m.take(func(meta TerminalMetadata) bool {
+		return m.waiting[meta.ID] == 0 && meta.LastActivity.Before(cutoff)
+	})
+	for _, meta := range stopped {
+		m.terminate(meta, "idle timeout")
+	}
+	return len(stopped)
+}
+
+// Close stops the idle reaper.
+func (m *Manager) Close() {
 	m.mu.Lock()
-	ids := make([]string, 0, len(m.termMap))
-	for id := range m.termMap {
-		ids = append(ids, id)
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
 	}
-	m.mu.Unlock()
+}
+
+func (m *Manager) reapLoop(stop chan struct{}) {
+	ticker := time.NewTicker(min(m.cfg.IdleTimeout/4, 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.ReapIdle()
+		}
+	}
+}
+
+// take removes and returns the tracked terminals matching match.
+func (m *Manager) take(match func(TerminalMetadata) bool) []TerminalMetadata {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []TerminalMetadata
+	for id, meta := range m.termMap {
+		if match(meta) {
+			out = append(out, meta)
+			delete(m.termMap, id)
+			delete(m.waiting, id)
+		}
+	}
+	return out
+}
 
-	for _, id := range ids {
-		_ = m.conn.ReleaseTerminal(client.ReleaseTerminalRequest{TerminalID: id})
-		m.ReleaseTerminal(id)
+func (m *Manager) terminate(meta TerminalMetadata, reason string) {
+	m.logger.Info("Stopping terminal", map[string]any{"terminalId": meta.ID, "sessionId": meta.SessionID, "command": meta.Command, "reason": reason})
+	if err := m.conn.KillTerminal(context.Background(), client.KillTerminalRequest{SessionID: meta.SessionID, TerminalID: meta.ID}); err != nil {
+		m.logger.Debug("Failed to kill terminal", map[string]any{"terminalId": meta.ID, "error": err.Error()})
+	}
+	if err := m.conn.ReleaseTerminal(context.Background(), client.ReleaseTerminalRequest{SessionID: meta.SessionID, TerminalID: meta.ID}); err != nil {
+		m.logger.Debug("Failed to release terminal", map[string]any{"terminalId": meta.ID, "error": err.Error()})
 	}
 }
 
-func (m *Manager) validateCommand(command string) error {
+func (m *Manager) validateCommand(command string, args []string) error {
 	command = strings.TrimSpace(command)
 	if command == "" {
 		return fmt.Errorf("command cannot be empty")
@@ -227,11 +371,27 @@ func (m *Manager) validateCommand(command string) error {
 		}
 	}
 
+	if m.cfg.CommandSafety == safetyBasic {
+		for _, forbidden := range m.cfg.ForbiddenCommands {
+			if strings.ToLower(strings.TrimSpace(forbidden)) == cmdLower {
+				return fmt.Errorf("command %q is forbidden", command)
+			}
+		}
+		return nil
+	}
+
+	policy := commandPolicy{strict: m.cfg.CommandSafety == safetyStrict, forbidden: map[string]bool{}}
 	for _, forbidden := range m.cfg.ForbiddenCommands {
-		if strings.ToLower(strings.TrimSpace(forbidden)) == cmdLower {
-			return fmt.Errorf("command %q is forbidden", command)
+		if name := strings.ToLower(strings.TrimSpace(forbidden)); name != "" {
+			policy.forbidden[name] = true
 		}
 	}
+	if len(policy.forbidden) == 0 && !policy.strict {
+		return nil
+	}
+	if reason := policy.checkScript(command, args, 0); reason != "" {
+		return fmt.Errorf("command %q is forbidden: %s", command, reason)
+	}
 	return nil
 }
 
@@ -1,10 +1,14 @@
 package terminal
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
 )
@@ -31,15 +35,19 @@ type fakeConnection struct {
 	releaseCalled bool
 }
 
-func (f *fakeConnection) ReadTextFile(client.ReadTextFileRequest) (client.ReadTextFileResponse, error) {
+func (f *fakeConnection) ReadTextFile(context.Context, client.ReadTextFileRequest) (client.ReadTextFileResponse, error) {
 	return client.ReadTextFileResponse{}, nil
 }
 
-func (f *fakeConnection) WriteTextFile(client.WriteTextFileRequest) (client.WriteTextFileResponse, error) {
+func (f *fakeConnection) WriteTextFile(context.Context, client.WriteTextFileRequest) (client.WriteTextFileResponse, error) {
 	return client.WriteTextFileResponse{}, nil
 }
 
-func (f *fakeConnection) CreateTerminal(params client.CreateTerminalRequest) (client.CreateTerminalResponse, error) {
+func (f *fakeConnection) ListDirectory(context.Context, client.ListDirectoryRequest) (client.ListDirectoryResponse, error) {
+	return client.ListDirectoryResponse{}, nil
+}
+
+func (f *fakeConnection) CreateTerminal(_ context.Context, params client.CreateTerminalRequest) (client.CreateTerminalResponse, error) {
 	f.createReq = params
 	if f.createErr != nil {
 		return client.CreateTerminalResponse{}, f.createErr
@@ -47,26 +55,30 @@ func (f *fakeConnection) CreateTerminal(params client.CreateTerminalRequest) (cl
 	return client.CreateTerminalResponse{TerminalID: "term-1"}, nil
 }
 
-func (f *fakeConnection) GetTerminalOutput(params client.TerminalOutputRequest) (client.TerminalOutputResponse, error) {
+func (f *fakeConnection) GetTerminalOutput(_ context.Context, params client.TerminalOutputRequest) (client.TerminalOutputResponse, error) {
 	f.outputReq = params
 	return f.outputResp, f.outputErr
 }
 
-func (f *fakeConnection) WaitForTerminalExit(params client.WaitForTerminalExitRequest) (client.WaitForTerminalExitResponse, error) {
+func (f *fakeConnection) WaitForTerminalExit(ctx context.Context, params client.WaitForTerminalExitRequest) (client.WaitForTerminalExitResponse, error) {
 	f.waitReq = params
 	if f.waitDelay > 0 {
-		time.Sleep(f.waitDelay)
+		select {
+		case <-time.After(f.waitDelay):
+		case <-ctx.Done():
+			return client.WaitForTerminalExitResponse{}, ctx.Err()
+		}
 	}
 	return f.waitResp, f.waitErr
 }
 
-func (f *fakeConnection) KillTerminal(params client.KillTerminalRequest) error {
+func (f *fakeConnection) KillTerminal(_ context.Context, params client.KillTerminalRequest) error {
 	f.killReq = params
 	f.killCalled = true
 	return f.killErr
 }
 
-func (f *fakeConnection) ReleaseTerminal(params client.ReleaseTerminalRequest) error {
+func (f *fakeConnection) ReleaseTerminal(_ context.Context, params client.ReleaseTerminalRequest) error {
 	f.releaseReq = params
 	f.releaseCalled = true
 	return f.releaseErr
@@ -79,7 +91,7 @@ func TestCreateTerminalValidation(t *testing.T) {
 	manager := NewManager(ManagerConfig{
 		ClientSupportsTerminals: false,
 	}, conn, logger)
-	if _, err := manager.CreateTerminal("s1", CreateParams{Command: "echo"}); err == nil {
+	if _, err := manager.CreateTerminal(context.Background(), "s1", CreateParams{Command: "echo"}); err == nil {
 		t.Fatalf("expected error when client terminal capability is disabled")
 	}
 
@@ -87,7 +99,7 @@ func TestCreateTerminalValidation(t *testing.T) {
 		ClientSupportsTerminals: true,
 		ForbiddenCommands:       []string{"rm"},
 	}, conn, logger)
-	if _, err := manager.CreateTerminal("s1", CreateParams{Command: "rm"}); err == nil {
+	if _, err := manager.CreateTerminal(context.Background(), "s1", CreateParams{Command: "rm"}); err == nil {
 		t.Fatalf("expected forbidden command error")
 	}
 
@@ -95,9 +107,17 @@ func TestCreateTerminalValidation(t *testing.T) {
 		ClientSupportsTerminals: true,
 		AllowedCommands:         []string{"echo"},
 	}, conn, logger)
-	if _, err := manager.CreateTerminal("s1", CreateParams{Command: "ls"}); err == nil {
+	if _, err := manager.CreateTerminal(context.Background(), "s1", CreateParams{Command: "ls"}); err == nil {
 		t.Fatalf("expected allowed commands validation error")
 	}
+
+	manager = NewManager(ManagerConfig{
+		ClientSupportsTerminals: true,
+		EnvPolicy:               envpolicy.New(config.Default().Environment),
+	}, conn, logger)
+	if _, err := manager.CreateTerminal(context.Background(), "s1", CreateParams{Command: "ls", Env: []client.EnvVariable{{Name: "AWS_SECRET_ACCESS_KEY", Value: "x"}}}); err == nil {
+		t.Fatalf("expected environment policy error")
+	}
 }
 
 func TestExecuteSimpleCommand(t *testing.T) {
@@ -115,7 +135,7 @@ func TestExecuteSimpleCommand(t *testing.T) {
 		ClientSupportsTerminals: true,
 	}, conn, logging.New("error"))
 
-	result, err := ExecuteSimpleCommand(manager, "session-1", "echo", []string{"hello"}, nil)
+	result, err := ExecuteSimpleCommand(context.Background(), manager, "session-1", "echo", []string{"hello"}, nil)
 	if err != nil {
 		t.Fatalf("ExecuteSimpleCommand returned error: %v", err)
 	}
@@ -141,7 +161,7 @@ func TestExecuteWithTimeout(t *testing.T) {
 		ClientSupportsTerminals: true,
 	}, conn, logging.New("error"))
 
-	result, err := ExecuteWithTimeout(manager, "session-1", "sleep", []string{"10"}, 40*time.Millisecond, nil)
+	result, err := ExecuteWithTimeout(context.Background(), manager, "session-1", "sleep", []string{"10"}, 40*time.Millisecond, nil)
 	if err != nil {
 		t.Fatalf("ExecuteWithTimeout returned error: %v", err)
 	}
@@ -169,6 +189,7 @@ func TestExecuteWithProgress(t *testing.T) {
 	}, nil)
 
 	result, err := ExecuteWithProgress(
+		context.Background(),
 		manager,
 		toolCalls,
 		"session-1",
@@ -186,3 +207,143 @@ func TestExecuteWithProgress(t *testing.T) {
 		t.Fatalf("expected tool call notifications to be emitted")
 	}
 }
+
+func TestExecuteWithProgressStreamsOutputSnapshots(t *testing.T) {
+	exitCode := 0
+	output := strings.Repeat("compiling package\n", 20) + "done\n"
+	conn := &fakeConnection{
+		outputResp: client.TerminalOutputResponse{Output: output},
+		waitResp:   client.WaitForTerminalExitResponse{ExitCode: &exitCode},
+		waitDelay:  80 * time.Millisecond,
+	}
+	manager := NewManager(ManagerConfig{ClientSupportsTerminals: true}, conn, logging.New("error"))
+
+	var updates []map[string]any
+	toolCalls := toolcall.NewManager(logging.New("error"), func(n map[string]any) {
+		updates = append(updates, n["params"].(map[string]any)["update"].(map[string]any))
+	}, nil)
+
+	if _, err := ExecuteWithProgress(context.Background(), manager, toolCalls, "session-1", "make", nil,
+		ExecuteWithProgressOptions{PollIntervalMs: 10, SnapshotBytes: 40}); err != nil {
+		t.Fatalf("ExecuteWithProgress returned error: %v", err)
+	}
+
+	var live map[string]any
+	for _, update := range updates {
+		if update["status"] == "in_progress" && len(update["content"].([]map[string]any)) == 2 {
+			live = update
+			break
+		}
+	}
+	if live == nil {
+		t.Fatalf("expected an in-progress update with an output snapshot, got %#v", updates)
+	}
+	content := live["content"].([]map[string]any)
+	if content[0]["type"] != "terminal" {
+		t.Fatalf("expected terminal content first, got %#v", content)
+	}
+	text := content[1]["content"].(map[string]any)["text"].(string)
+	if text != "```\n[... 342 earlier bytes omitted]\ncompiling package\ndone\n```" {
+		t.Fatalf("unexpected snapshot %q", text)
+	}
+	if final := updates[len(updates)-1]; final["status"] != "completed" || len(final["content"].([]map[string]any)) != 2 {
+		t.Fatalf("expected the completion to carry the final snapshot, got %#v", final)
+	}
+}
+
+func TestManagerReapsIdleAndSessionTerminals(t *testing.T) {
+	conn := &fakeConnection{}
+	manager := NewManager(ManagerConfig{ClientSupportsTerminals: true, IdleTimeout: time.Hour}, conn, logging.New("error"))
+	defer manager.Close()
+	backdate := func(id string) {
+		manager.mu.Lock()
+		meta := manager.termMap[id]
+		meta.LastActivity = time.Now().Add(-2 * time.Hour)
+		manager.termMap[id] = meta
+		manager.mu.Unlock()
+	}
+
+	handle, err := manager.CreateTerminal(context.Background(), "session-1", CreateParams{Command: "make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := manager.ReapIdle(); n != 0 {
+		t.Fatalf("reaped %d fresh terminals", n)
+	}
+	backdate(handle.TerminalID)
+	manager.setWaiting(handle.TerminalID, 1)
+	backdate(handle.TerminalID)
+	if n := manager.ReapIdle(); n != 0 {
+		t.Fatalf("reaped %d terminals that are being waited on", n)
+	}
+	manager.setWaiting(handle.TerminalID, -1)
+	backdate(handle.TerminalID)
+	if n := manager.ReapIdle(); n != 1 || !conn.killCalled || conn.releaseReq.SessionID != "session-1" {
+		t.Fatalf("expected the idle terminal to be killed and released, got %d %#v", n, conn.releaseReq)
+	}
+	conn.releaseCalled = false
+	if err := handle.Release(); err != nil || conn.releaseCalled {
+		t.Fatalf("expected releasing a reaped terminal to be a no-op, got %v", err)
+	}
+
+	if _, err := manager.CreateTerminal(context.Background(), "session-1", CreateParams{Command: "make"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := manager.CleanupSession("session-2"); n != 0 {
+		t.Fatalf("cleaned up %d terminals of another session", n)
+	}
+	if n := manager.CleanupSession("session-1"); n != 1 || len(manager.ActiveTerminals()) != 0 {
+		t.Fatalf("expected the session's terminal to be stopped, got %d", n)
+	}
+}
+
+// releaseSignal reports releases made from the reaper goroutine.
+type releaseSignal struct {
+	fakeConnection
+	released chan client.ReleaseTerminalRequest
+}
+
+func (r *releaseSignal) ReleaseTerminal(_ context.Context, params client.ReleaseTerminalRequest) error {
+	r.released <- params
+	return nil
+}
+
+func TestManagerReapLoopStopsIdleTerminals(t *testing.T) {
+	conn := &releaseSignal{released: make(chan client.ReleaseTerminalRequest, 1)}
+	manager := NewManager(ManagerConfig{ClientSupportsTerminals: true, IdleTimeout: 40 * time.Millisecond}, conn, logging.New("error"))
+	defer manager.Close()
+
+	handle, err := manager.CreateTerminal(context.Background(), "session-1", CreateParams{Command: "make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-conn.released:
+		if req.TerminalID != handle.TerminalID || !conn.killCalled {
+			t.Fatalf("expected the idle terminal to be killed and released, got %#v", req)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle terminal was not reaped")
+	}
+	if len(manager.ActiveTerminals()) != 0 {
+		t.Fatalf("reaped terminal is still tracked")
+	}
+}
+
+func TestExecuteSimpleCommandStopsOnCancel(t *testing.T) {
+	conn := &fakeConnection{waitDelay: time.Minute}
+	manager := NewManager(ManagerConfig{ClientSupportsTerminals: true}, conn, logging.New("error"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := ExecuteSimpleCommand(ctx, manager, "session-1", "sleep", []string{"60"}, nil); err == nil {
+		t.Fatalf("expected cancellation error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("cancel did not unblock the wait")
+	}
+	if !conn.releaseCalled || conn.releaseReq.SessionID != "session-1" {
+		t.Fatalf("expected the terminal to be released after cancel, got %#v", conn.releaseReq)
+	}
+}
@@ -0,0 +1,388 @@
+package terminal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Command safety levels for ManagerConfig.CommandSafety.
+const (
+	// safetyBasic only compares the command name with ForbiddenCommands.
+	safetyBasic = "basic"
+	// safetyStandard also looks through shells (sh -c, eval), wrappers such
+	// as env, nohup or xargs, find -exec, and command substitutions.
+	safetyStandard = "standard"
+	// safetyStrict additionally rejects privilege escalation, piping into a
+	// shell, eval, and forbidden commands passed as plain arguments.
+	safetyStrict = "strict"
+)
+
+// maxShellDepth bounds how many nested shells and wrappers are analyzed; a
+// command nested deeper is rejected rather than trusted.
+const maxShellDepth = 8
+
+var shells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+	"mksh": true, "ash": true, "fish": true, "csh": true, "tcsh": true,
+}
+
+var privileged = map[string]bool{
+	"sudo": true, "doas": true, "su": true, "pkexec": true, "runas": true,
+}
+
+// wrapper describes a command that runs the rest of its arguments as
+// another command once its own options are skipped.
+type wrapper struct {
+	valueOpts   string // single-letter options that take a separate value
+	operands    int    // operands before the wrapped command, e.g. timeout's duration
+	assignments bool   // NAME=value arguments may precede the command
+}
+
+var wrappers = map[string]wrapper{
+	"sudo":    {valueOpts: "ugChpUrtD"},
+	"doas":    {valueOpts: "uC"},
+	"env":     {valueOpts: "uCS", assignments: true},
+	"nohup":   {},
+	"time":    {valueOpts: "fo"},
+	"exec":    {valueOpts: "a"},
+	"command": {},
+	"builtin": {},
+	"setsid":  {},
+	"nice":    {valueOpts: "n"},
+	"ionice":  {valueOpts: "cnp"},
+	"stdbuf":  {valueOpts: "ioe"},
+	"timeout": {valueOpts: "sk", operands: 1},
+	"xargs":   {valueOpts: "InPLdEsa"},
+	"chroot":  {operands: 1},
+}
+
+// command returns the wrapped command line, or nil if there is none.
+func (w wrapper) command(args []string) []string {
+	i := 0
+	for ; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			i++
+			break
+		}
+		if len(a) < 2 || a[0] != '-' {
+			if w.assignments && isAssignment(a) {
+				continue
+			}
+			break
+		}
+		if len(a) == 2 && strings.IndexByte(w.valueOpts, a[1]) >= 0 {
+			i++
+		}
+	}
+	i += w.operands
+	if i >= len(args) {
+		return nil
+	}
+	return args[i:]
+}
+
+// commandPolicy checks a command line against the forbidden commands.
+type commandPolicy struct {
+	strict    bool
+	forbidden map[string]bool
+}
+
+// checkScript parses script as shell input, with extra appended to its last
+// command, and returns why it is refused or "" if it is allowed.
+func (p commandPolicy) checkScript(script string, extra []string, depth int) string {
+	if depth > maxShellDepth {
+		return "nests commands too deeply to analyze"
+	}
+	segments, subs := parseShell(script)
+	if len(extra) > 0 {
+		if len(segments) == 0 {
+			segments = []shellSegment{{}}
+		}
+		last := &segments[len(segments)-1]
+		last.words = append(last.words, extra...)
+	}
+	for _, seg := range segments {
+		if reason := p.checkArgv(seg.words, seg.piped, depth); reason != "" {
+			return reason
+		}
+	}
+	for _, sub := range subs {
+		if reason := p.checkScript(sub, nil, depth+1); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func (p commandPolicy) checkArgv(argv []string, piped bool, depth int) string {
+	if depth > maxShellDepth {
+		return "nests commands too deeply to analyze"
+	}
+	for len(argv) > 0 && isAssignment(argv[0]) {
+		argv = argv[1:]
+	}
+	if len(argv) == 0 {
+		return ""
+	}
+	name := commandName(argv[0])
+	switch {
+	case p.forbidden[name]:
+		return fmt.Sprintf("runs forbidden command %q", name)
+	case p.strict && privileged[name]:
+		return fmt.Sprintf("escalates privileges with %q", name)
+	case name == "eval":
+		if p.strict {
+			return "uses eval"
+		}
+		return p.checkScript(strings.Join(argv[1:], " "), nil, depth+1)
+	}
+
+	if shells[name] {
+		if script, ok := shellScript(argv[1:]); ok {
+			if reason := p.checkScript(script, nil, depth+1); reason != "" {
+				return reason
+			}
+		} else if piped && p.strict {
+			return fmt.Sprintf("pipes into shell %q", name)
+		}
+	}
+	if w, ok := wrappers[name]; ok {
+		if reason := p.checkArgv(w.command(argv[1:]), piped, depth+1); reason != "" {
+			return reason
+		}
+	}
+	if name == "find" {
+		for i, a := range argv {
+			if a != "-exec" && a != "-execdir" && a != "-ok" && a != "-okdir" {
+				continue
+			}
+			end := i + 1
+			for end < len(argv) && argv[end] != ";" && argv[end] != "+" {
+				end++
+			}
+			if reason := p.checkArgv(argv[i+1:end], false, depth+1); reason != "" {
+				return reason
+			}
+		}
+	}
+	if p.strict {
+		for _, arg := range argv[1:] {
+			if p.forbidden[commandName(arg)] {
+				return fmt.Sprintf("passes forbidden command %q as an argument", arg)
+			}
+		}
+	}
+	return ""
+}
+
+// shellScript returns the script a shell invocation runs with -c, if any.
+func shellScript(args []string) (string, bool) {
+	wantScript := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			if wantScript && i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		case a == "-o" || a == "+o" || a == "-O" || a == "+O":
+			i++
+		case strings.HasPrefix(a, "--"):
+		case len(a) > 1 && (a[0] == '-' || a[0] == '+'):
+			if strings.Contains(a[1:], "c") {
+				wantScript = true
+			}
+		default:
+			return a, wantScript
+		}
+	}
+	return "", false
+}
+
+func commandName(word string) string {
+	return strings.TrimSuffix(strings.ToLower(path.Base(word)), ".exe")
+}
+
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// shellSegment is one simple command of a parsed script.
+type shellSegment struct {
+	words []string
+	piped bool // stdin comes from the previous command through |
+}
+
+// parseShell splits script into simple commands roughly the way a POSIX
+// shell would: quotes and escapes are resolved, redirections are dropped,
+// and the bodies of $(...), `...` and <(...) are returned for separate
+// analysis. It never fails; malformed input is parsed as far as it goes.
+func parseShell(script string) (segments []shellSegment, subs []string) {
+	var (
+		seg      shellSegment
+		word     strings.Builder
+		inWord   bool
+		skipWord bool // the next word is a redirection target
+	)
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		if skipWord {
+			skipWord = false
+		} else {
+			seg.words = append(seg.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	endSegment := func(piped bool) {
+		endWord()
+		if len(seg.words) > 0 {
+			segments = append(segments, seg)
+		}
+		seg = shellSegment{piped: piped}
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(script) {
+				i++
+				if script[i] != '\n' {
+					word.WriteByte(script[i])
+					inWord = true
+				}
+			}
+		case c == '\'':
+			end := strings.IndexByte(script[i+1:], '\'')
+			if end < 0 {
+				end = len(script) - i - 1
+			}
+			word.WriteString(script[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			inWord = true
+			for i++; i < len(script) && script[i] != '"'; i++ {
+				switch {
+				case script[i] == '\\' && i+1 < len(script) && strings.IndexByte("\"\\$`\n", script[i+1]) >= 0:
+					i++
+					word.WriteByte(script[i])
+				case script[i] == '$' && i+1 < len(script) && script[i+1] == '(':
+					body, n := substitution(script[i+2:])
+					subs = append(subs, body)
+					i += 1 + n
+				case script[i] == '`':
+					body, n := backticks(script[i+1:])
+					subs = append(subs, body)
+					i += n
+				default:
+					word.WriteByte(script[i])
+				}
+			}
+		case c == '$' && i+1 < len(script) && script[i+1] == '(':
+			body, n := substitution(script[i+2:])
+			subs = append(subs, body)
+			inWord = true
+			i += 1 + n
+		case c == '`':
+			body, n := backticks(script[i+1:])
+			subs = append(subs, body)
+			inWord = true
+			i += n
+		case c == '#' && !inWord:
+			if end := strings.IndexByte(script[i:], '\n'); end < 0 {
+				i = len(script)
+			} else {
+				i += end - 1
+			}
+		case c == '|':
+			if i+1 < len(script) && script[i+1] == '|' {
+				i++
+				endSegment(false)
+				break
+			}
+			if i+1 < len(script) && script[i+1] == '&' {
+				i++
+			}
+			endSegment(true)
+		case c == ';' || c == '&' || c == '\n' || c == '(' || c == ')':
+			endSegment(false)
+		case c == '<' || c == '>':
+			if i+1 < len(script) && script[i+1] == '(' {
+				body, n := substitution(script[i+2:])
+				subs = append(subs, body)
+				i += 1 + n
+				break
+			}
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			} else {
+				endWord()
+			}
+			for i+1 < len(script) && strings.IndexByte("<>&|", script[i+1]) >= 0 {
+				i++
+			}
+			skipWord = true
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endSegment(false)
+	return segments, subs
+}
+
+// substitution returns the body of a $( or <( group whose opening paren was
+// just consumed, and how many bytes of s it spans including the closing
+// paren.
+func substitution(s string) (string, int) {
+	depth := 1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return s, len(s)
+			}
+			i += end + 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s[:i], i + 1
+			}
+		}
+	}
+	return s, len(s)
+}
+
+// backticks returns the body of a `...` substitution whose opening backtick
+// was just consumed, and how many bytes of s it spans.
+func backticks(s string) (string, int) {
+	end := strings.IndexByte(s, '`')
+	if end < 0 {
+		return s, len(s)
+	}
+	return s[:end], end + 1
+}
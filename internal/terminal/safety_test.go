@@ -0,0 +1,58 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCommandLooksThroughShellsAndWrappers(t *testing.T) {
+	forbidden := []string{"rm", "sudo", "su"}
+	for _, tc := range []struct {
+		level   string
+		command string
+		args    []string
+		reason  string // "" when allowed
+	}{
+		{safetyBasic, "bash", []string{"-c", "rm -rf /"}, ""},
+		{safetyStandard, "rm", nil, `runs forbidden command "rm"`},
+		{safetyStandard, "/bin/RM", []string{"-rf", "/"}, `runs forbidden command "rm"`},
+		{safetyStandard, "bash", []string{"-c", "rm -rf /"}, `runs forbidden command "rm"`},
+		{safetyStandard, "sh", []string{"-lc", `echo hi && "r"m -rf /`}, `runs forbidden command "rm"`},
+		{safetyStandard, "bash -c 'ls; sh -c \"rm x\"'", nil, `runs forbidden command "rm"`},
+		{safetyStandard, "env", []string{"FOO=1", "nohup", "timeout", "5", "rm", "x"}, `runs forbidden command "rm"`},
+		{safetyStandard, "find", []string{".", "-name", "*.tmp", "-exec", "rm", "{}", ";"}, `runs forbidden command "rm"`},
+		{safetyStandard, "sh", []string{"-c", "echo $(rm -rf /)"}, `runs forbidden command "rm"`},
+		{safetyStandard, "sh", []string{"-c", "ls | xargs rm"}, `runs forbidden command "rm"`},
+		{safetyStandard, "sh", []string{"-c", "eval 'rm x'"}, `runs forbidden command "rm"`},
+		{safetyStandard, "bash", []string{"-c", "echo rm > notes.txt # rm"}, ""},
+		{safetyStandard, "git", []string{"rm", "file.go"}, ""},
+		{safetyStandard, "curl", []string{"-s", "https://example.com/install.sh", "|", "bash"}, ""},
+		{safetyStrict, "git", []string{"rm", "file.go"}, `passes forbidden command "rm" as an argument`},
+		{safetyStrict, "sh", []string{"-c", "curl -s https://example.com/install.sh | bash"}, `pipes into shell "bash"`},
+		{safetyStrict, "sh", []string{"-c", "eval ls"}, "uses eval"},
+		{safetyStrict, "go", []string{"test", "./..."}, ""},
+	} {
+		m := &Manager{cfg: ManagerConfig{ForbiddenCommands: forbidden, CommandSafety: tc.level}}
+		if tc.level == safetyStrict {
+			m.cfg.ForbiddenCommands = []string{"rm"}
+		}
+		err := m.validateCommand(tc.command, tc.args)
+		switch {
+		case tc.reason == "" && err != nil:
+			t.Errorf("%s %q %q: unexpected error %v", tc.level, tc.command, tc.args, err)
+		case tc.reason != "" && (err == nil || !strings.HasSuffix(err.Error(), tc.reason)):
+			t.Errorf("%s %q %q: got %v, want %q", tc.level, tc.command, tc.args, err, tc.reason)
+		}
+	}
+}
+
+func TestValidateCommandStrictRejectsPrivilegeEscalation(t *testing.T) {
+	m := &Manager{cfg: ManagerConfig{CommandSafety: safetyStrict}}
+	if err := m.validateCommand("nohup", []string{"sudo", "-u", "root", "make"}); err == nil || !strings.Contains(err.Error(), `escalates privileges with "sudo"`) {
+		t.Fatalf("expected sudo to be rejected, got %v", err)
+	}
+	m.cfg.CommandSafety = safetyStandard
+	if err := m.validateCommand("nohup", []string{"sudo", "-u", "root", "make"}); err != nil {
+		t.Fatalf("standard level should allow sudo when it is not forbidden: %v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package terminal
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
@@ -31,9 +33,14 @@ type ExecuteWithProgressOptions struct {
 	Env             []client.EnvVariable
 	OutputByteLimit int
 	PollIntervalMs  int
+	// SnapshotBytes caps the output tail sent with each progress update
+	// (default 4KiB).
+	SnapshotBytes int
 }
 
-func ExecuteSimpleCommand(manager *Manager, sessionID string, command string, args []string, options *CreateParams) (SimpleCommandResult, error) {
+const defaultSnapshotBytes = 4096
+
+func ExecuteSimpleCommand(ctx context.Context, manager *Manager, sessionID string, command string, args []string, options *CreateParams) (SimpleCommandResult, error) {
 	params := CreateParams{
 		Command: command,
 		Args:    args,
@@ -44,17 +51,17 @@ func ExecuteSimpleCommand(manager *Manager, sessionID string, command string, ar
 		params.OutputByteLimit = options.OutputByteLimit
 	}
 
-	terminal, err := manager.CreateTerminal(sessionID, params)
+	terminal, err := manager.CreateTerminal(ctx, sessionID, params)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
 	defer func() { _ = terminal.Release() }()
 
-	exit, err := terminal.WaitForExit()
+	exit, err := terminal.WaitForExit(ctx)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
-	output, err := terminal.CurrentOutput()
+	output, err := terminal.CurrentOutput(ctx)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
@@ -67,7 +74,7 @@ func ExecuteSimpleCommand(manager *Manager, sessionID string, command string, ar
 	}, nil
 }
 
-func ExecuteWithTimeout(manager *Manager, sessionID string, command string, args []string, timeout time.Duration, options *CreateParams) (TimeoutCommandResult, error) {
+func ExecuteWithTimeout(ctx context.Context, manager *Manager, sessionID string, command string, args []string, timeout time.Duration, options *CreateParams) (TimeoutCommandResult, error) {
 	params := CreateParams{
 		Command: command,
 		Args:    args,
@@ -78,7 +85,7 @@ func ExecuteWithTimeout(manager *Manager, sessionID string, command string, args
 		params.OutputByteLimit = options.OutputByteLimit
 	}
 
-	terminal, err := manager.CreateTerminal(sessionID, params)
+	terminal, err := manager.CreateTerminal(ctx, sessionID, params)
 	if err != nil {
 		return TimeoutCommandResult{}, err
 	}
@@ -87,7 +94,7 @@ func ExecuteWithTimeout(manager *Manager, sessionID string, command string, args
 	exitCh := make(chan client.WaitForTerminalExitResponse, 1)
 	errCh := make(chan error, 1)
 	go func() {
-		exit, err := terminal.WaitForExit()
+		exit, err := terminal.WaitForExit(ctx)
 		if err != nil {
 			errCh <- err
 			return
@@ -112,7 +119,7 @@ func ExecuteWithTimeout(manager *Manager, sessionID string, command string, args
 		}
 	}
 
-	output, err := terminal.CurrentOutput()
+	output, err := terminal.CurrentOutput(ctx)
 	if err != nil {
 		return TimeoutCommandResult{}, err
 	}
@@ -127,6 +134,7 @@ func ExecuteWithTimeout(manager *Manager, sessionID string, command string, args
 }
 
 func ExecuteWithProgress(
+	ctx context.Context,
 	manager *Manager,
 	toolCalls *toolcall.Manager,
 	sessionID string,
@@ -134,7 +142,7 @@ func ExecuteWithProgress(
 	args []string,
 	options ExecuteWithProgressOptions,
 ) (SimpleCommandResult, error) {
-	terminal, err := manager.CreateTerminal(sessionID, CreateParams{
+	terminal, err := manager.CreateTerminal(ctx, sessionID, CreateParams{
 		Command:         command,
 		Args:            args,
 		Cwd:             options.Cwd,
@@ -168,22 +176,41 @@ func ExecuteWithProgress(
 	if options.PollIntervalMs <= 0 {
 		options.PollIntervalMs = 1000
 	}
+	if options.SnapshotBytes <= 0 {
+		options.SnapshotBytes = defaultSnapshotBytes
+	}
 	stopPoll := make(chan struct{})
+	pollDone := make(chan struct{})
 	go func() {
+		defer close(pollDone)
 		ticker := time.NewTicker(time.Duration(options.PollIntervalMs) * time.Millisecond)
 		defer ticker.Stop()
+		lastLen := 0
 		for {
 			select {
 			case <-ticker.C:
 				manager.UpdateActivity(terminal.TerminalID)
+				if toolCalls == nil || toolCallID == "" {
+					continue
+				}
+				out, err := terminal.CurrentOutput(ctx)
+				if err != nil || len(out.Output) == lastLen {
+					continue
+				}
+				lastLen = len(out.Output)
+				toolCalls.UpdateToolCall(sessionID, toolCallID, map[string]any{
+					"status":  "in_progress",
+					"content": progressContent(toolCalls, terminal.TerminalID, out.Output, options.SnapshotBytes),
+				})
 			case <-stopPoll:
 				return
 			}
 		}
 	}()
 
-	exitStatus, err := terminal.WaitForExit()
+	exitStatus, err := terminal.WaitForExit(ctx)
 	close(stopPoll)
+	<-pollDone
 	if err != nil {
 		if toolCalls != nil && toolCallID != "" {
 			toolCalls.FailToolCall(sessionID, toolCallID, map[string]any{
@@ -194,7 +221,7 @@ func ExecuteWithProgress(
 		return SimpleCommandResult{}, err
 	}
 
-	output, err := terminal.CurrentOutput()
+	output, err := terminal.CurrentOutput(ctx)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
@@ -203,7 +230,7 @@ func ExecuteWithProgress(
 		if exitStatus.ExitCode != nil && *exitStatus.ExitCode == 0 {
 			toolCalls.CompleteToolCall(sessionID, toolCallID, map[string]any{
 				"title":   "Command completed successfully",
-				"content": toolCalls.CreateTerminalContent(terminal.TerminalID),
+				"content": progressContent(toolCalls, terminal.TerminalID, output.Output, options.SnapshotBytes),
 				"rawOutput": map[string]any{
 					"exitCode":     exitStatus.ExitCode,
 					"outputLength": len(output.Output),
@@ -212,8 +239,9 @@ func ExecuteWithProgress(
 			})
 		} else {
 			toolCalls.FailToolCall(sessionID, toolCallID, map[string]any{
-				"title": fmt.Sprintf("Command failed (exit code %v)", exitStatus.ExitCode),
-				"error": fmt.Sprintf("Command exited with code %v", exitStatus.ExitCode),
+				"title":   fmt.Sprintf("Command failed (exit code %v)", exitStatus.ExitCode),
+				"error":   fmt.Sprintf("Command exited with code %v", exitStatus.ExitCode),
+				"content": progressContent(toolCalls, terminal.TerminalID, output.Output, options.SnapshotBytes),
 				"rawOutput": map[string]any{
 					"exitCode":     exitStatus.ExitCode,
 					"signal":       exitStatus.Signal,
@@ -233,6 +261,7 @@ func ExecuteWithProgress(
 }
 
 func ExecuteSequential(
+	ctx context.Context,
 	manager *Manager,
 	sessionID string,
 	cwd string,
@@ -242,7 +271,7 @@ func ExecuteSequential(
 	results := make([]SimpleCommandResult, 0, len(commands))
 	for _, cmd := range commands {
 		cmd.Cwd = cwd
-		result, err := ExecuteSimpleCommand(manager, sessionID, cmd.Command, cmd.Args, &cmd)
+		result, err := ExecuteSimpleCommand(ctx, manager, sessionID, cmd.Command, cmd.Args, &cmd)
 		if err != nil {
 			return results, err
 		}
@@ -254,7 +283,7 @@ func ExecuteSequential(
 	return results, nil
 }
 
-func StreamTerminalOutput(handle *Handle, logger *logging.Logger, onOutput func(output string, isComplete bool), pollInterval time.Duration) (SimpleCommandResult, error) {
+func StreamTerminalOutput(ctx context.Context, handle *Handle, logger *logging.Logger, onOutput func(output string, isComplete bool), pollInterval time.Duration) (SimpleCommandResult, error) {
 	if pollInterval <= 0 {
 		pollInterval = time.Second
 	}
@@ -268,7 +297,7 @@ func StreamTerminalOutput(handle *Handle, logger *logging.Logger, onOutput func(
 		defer close(donePoll)
 		for running {
 			<-ticker.C
-			out, err := handle.CurrentOutput()
+			out, err := handle.CurrentOutput(ctx)
 			if err != nil {
 				logger.Warn("Error polling terminal output", map[string]any{"error": err.Error()})
 				running = false
@@ -286,14 +315,14 @@ func StreamTerminalOutput(handle *Handle, logger *logging.Logger, onOutput func(
 		}
 	}()
 
-	exit, err := handle.WaitForExit()
+	exit, err := handle.WaitForExit(ctx)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
 	running = false
 	<-donePoll
 
-	finalOutput, err := handle.CurrentOutput()
+	finalOutput, err := handle.CurrentOutput(ctx)
 	if err != nil {
 		return SimpleCommandResult{}, err
 	}
@@ -309,6 +338,38 @@ func StreamTerminalOutput(handle *Handle, logger *logging.Logger, onOutput func(
 	}, nil
 }
 
+// progressContent is the tool call content for a running command: the live
+// terminal, for clients that embed it, plus a text snapshot of the output
+// tail for clients that do not.
+func progressContent(toolCalls *toolcall.Manager, terminalID, output string, limit int) []map[string]any {
+	content := toolCalls.CreateTerminalContent(terminalID)
+	if output == "" {
+		return content
+	}
+	return append(content, map[string]any{
+		"type":    "content",
+		"content": map[string]any{"type": "text", "text": "```\n" + outputSnapshot(output, limit) + "\n```"},
+	})
+}
+
+// outputSnapshot returns the last limit bytes of output, starting at a line
+// boundary when there is one, with a note about what was cut.
+func outputSnapshot(output string, limit int) string {
+	output = strings.TrimRight(output, "\n")
+	if len(output) <= limit {
+		return output
+	}
+	start := len(output) - limit
+	if i := strings.IndexByte(output[start:], '\n'); i >= 0 && i < limit-1 {
+		start += i + 1
+	} else {
+		for start < len(output) && !utf8.RuneStart(output[start]) {
+			start++
+		}
+	}
+	return fmt.Sprintf("[... %d earlier bytes omitted]\n%s", start, output[start:])
+}
+
 func joinArgs(args []string) string {
 	if len(args) == 0 {
 		return ""
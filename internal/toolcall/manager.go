@@ -5,8 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 	"github.com/spjoes/cursor-agent-acp/internal/permissions"
+	"github.com/spjoes/cursor-agent-acp/internal/redact"
 )
 
 type PermissionRequester func(params permissions.RequestPermissionParams) permissions.PermissionOutcome
@@ -17,6 +19,7 @@ type ToolCallInfo struct {
 	ToolCallID       string
 	SessionID        string
 	ToolName         string
+	Kind             string
 	Status           string
 	StartTime        time.Time
 	EndTime          *time.Time
@@ -28,6 +31,8 @@ type Manager struct {
 	logger            *logging.Logger
 	send              SendNotification
 	requestPermission PermissionRequester
+	redactor          *redact.Redactor
+	outputLimits      OutputLimits
 
 	mu              sync.Mutex
 	activeToolCalls map[string]*ToolCallInfo
@@ -44,6 +49,18 @@ func NewManager(logger *logging.Logger, send SendNotification, permission Permis
 	}
 }
 
+// SetRedactor masks secrets in rawInput and rawOutput before they are sent.
+func (m *Manager) SetRedactor(r *redact.Redactor) {
+	m.redactor = r
+}
+
+func (m *Manager) redactRaw(key string, v any) any {
+	if m.redactor == nil || (key != "rawInput" && key != "rawOutput") {
+		return v
+	}
+	return m.redactor.Value(v)
+}
+
 func (m *Manager) GenerateToolCallID(toolName string) string {
 	m.mu.Lock()
 	m.toolCallCounter++
@@ -76,21 +93,25 @@ func (m *Manager) ReportToolCall(sessionID, toolName string, options map[string]
 	}
 	for _, key := range []string{"kind", "locations", "rawInput", "content", "rawOutput"} {
 		if v, ok := options[key]; ok {
-			update[key] = v
+			update[key] = m.redactRaw(key, v)
 		}
 	}
 
+	kind, _ := options["kind"].(string)
+	info := &ToolCallInfo{
+		ToolCallID: toolCallID,
+		SessionID:  sessionID,
+		ToolName:   toolName,
+		Kind:       kind,
+		Status:     status,
+		StartTime:  now,
+	}
+	m.limitOutput(sessionID, info, update)
 	notification := m.buildNotification(sessionID, update)
+	info.LastNotification = notification
 
 	m.mu.Lock()
-	m.activeToolCalls[toolCallID] = &ToolCallInfo{
-		ToolCallID:       toolCallID,
-		SessionID:        sessionID,
-		ToolName:         toolName,
-		Status:           status,
-		StartTime:        now,
-		LastNotification: notification,
-	}
+	m.activeToolCalls[toolCallID] = info
 	m.mu.Unlock()
 
 	m.logger.Debug("Reporting tool call", map[string]any{"toolCallId": toolCallID, "sessionId": sessionID, "toolName": toolName, "status": status})
@@ -108,6 +129,9 @@ func (m *Manager) UpdateToolCall(sessionID, toolCallID string, updates map[strin
 	}
 
 	now := time.Now().UTC()
+	if kind, ok := updates["kind"].(string); ok && kind != "" {
+		info.Kind = kind
+	}
 	if status, ok := updates["status"].(string); ok && status != "" {
 		info.Status = status
 		if status == "completed" || status == "failed" {
@@ -122,9 +146,10 @@ func (m *Manager) UpdateToolCall(sessionID, toolCallID string, updates map[strin
 	}
 	for _, key := range []string{"title", "kind", "status", "content", "locations", "rawInput", "rawOutput"} {
 		if v, ok := updates[key]; ok {
-			update[key] = v
+			update[key] = m.redactRaw(key, v)
 		}
 	}
+	m.limitOutput(sessionID, info, update)
 
 	notification := m.buildNotification(sessionID, update)
 	m.mu.Lock()
@@ -223,11 +248,50 @@ func (m *Manager) GetSessionToolCalls(sessionID string) []ToolCallInfo {
 	return out
 }
 
+// HasRunningToolCalls reports whether a tool call of the session is pending
+// or in progress.
+func (m *Manager) HasRunningToolCalls(sessionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, call := range m.activeToolCalls {
+		if call.SessionID == sessionID && (call.Status == "pending" || call.Status == "in_progress") {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Manager) CancelSessionToolCalls(sessionID string) {
-	calls := m.GetSessionToolCalls(sessionID)
+	m.cancelToolCalls(m.GetSessionToolCalls(sessionID), "Cancelled by user")
+}
+
+// CancelToolCall marks one unfinished tool call as cancelled, e.g. when its
+// handler was aborted. A call that already finished or was cancelled along
+// with its session is left alone.
+func (m *Manager) CancelToolCall(sessionID, toolCallID, title string) {
+	info := m.GetToolCallInfo(toolCallID)
+	if info == nil || info.SessionID != sessionID {
+		return
+	}
+	m.cancelToolCalls([]ToolCallInfo{*info}, title)
+}
+
+// CancelAllToolCalls fails every unfinished tool call in all sessions, e.g.
+// when the adapter shuts down.
+func (m *Manager) CancelAllToolCalls(title string) {
+	m.mu.Lock()
+	calls := make([]ToolCallInfo, 0, len(m.activeToolCalls))
+	for _, call := range m.activeToolCalls {
+		calls = append(calls, *call)
+	}
+	m.mu.Unlock()
+	m.cancelToolCalls(calls, title)
+}
+
+func (m *Manager) cancelToolCalls(calls []ToolCallInfo, title string) {
 	for _, call := range calls {
 		if call.Status == "pending" || call.Status == "in_progress" {
-			m.UpdateToolCall(sessionID, call.ToolCallID, map[string]any{"status": "failed", "title": "Cancelled by user"})
+			m.UpdateToolCall(call.SessionID, call.ToolCallID, map[string]any{"status": "failed", "title": title})
 		}
 		m.mu.Lock()
 		if info, ok := m.activeToolCalls[call.ToolCallID]; ok {
@@ -265,9 +329,23 @@ func (m *Manager) Cleanup() {
 	m.mu.Unlock()
 }
 
+// ConvertDiffContent turns result blocks into tool call content. Diff blocks
+// become ACP "diff" entries so clients can render the edit; anything else is
+// wrapped as regular content.
 func (m *Manager) ConvertDiffContent(diffBlocks []any) []map[string]any {
 	content := make([]map[string]any, 0)
 	for _, block := range diffBlocks {
+		if diff, ok := block.(acp.ContentBlock); ok && diff.Type == "diff" {
+			entry := map[string]any{"type": "diff", "path": diff.Path, "oldText": nil, "newText": ""}
+			if diff.OldText != nil {
+				entry["oldText"] = *diff.OldText
+			}
+			if diff.NewText != nil {
+				entry["newText"] = *diff.NewText
+			}
+			content = append(content, entry)
+			continue
+		}
 		content = append(content, map[string]any{"type": "content", "content": block})
 	}
 	return content
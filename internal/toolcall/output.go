@@ -0,0 +1,176 @@
+package toolcall
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/artifact"
+	"github.com/spjoes/cursor-agent-acp/internal/diff"
+)
+
+// OutputLimits caps the rawOutput and diffs sent with tool call updates.
+// Output over the limit for the call's kind is saved in Store and replaced
+// by a preview and a resource_link to the artifact; so is a diff whose
+// texts exceed it.
+type OutputLimits struct {
+	Store    *artifact.Store
+	MaxBytes int64
+	// ByKind overrides MaxBytes per tool kind; 0 sends that kind whole.
+	ByKind map[string]int64
+	// DiffContext is the number of unchanged lines around each change in
+	// the unified diffs saved for oversized diffs (tools.diffContextLines).
+	DiffContext int
+}
+
+func (l OutputLimits) limit(kind string) int64 {
+	if n, ok := l.ByKind[kind]; ok {
+		return n
+	}
+	return l.MaxBytes
+}
+
+// SetOutputLimits sets the rawOutput caps. It may be called again, e.g. on
+// a config reload; updates sent from then on use the new caps.
+func (m *Manager) SetOutputLimits(limits OutputLimits) {
+	m.mu.Lock()
+	m.outputLimits = limits
+	m.mu.Unlock()
+}
+
+func (m *Manager) limits() OutputLimits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outputLimits
+}
+
+// limitOutput replaces an oversized update["rawOutput"] with a summary and
+// adds a preview and a link to the full output to the update's content, or
+// the call's current content when the update has none. Oversized diffs in
+// the content become links to the unified diff.
+func (m *Manager) limitOutput(sessionID string, info *ToolCallInfo, update map[string]any) {
+	limits := m.limits()
+	limit := limits.limit(info.Kind)
+	if limit <= 0 {
+		return
+	}
+	if _, ok := update["content"]; ok {
+		update["content"] = m.limitDiffs(sessionID, info, contentEntries(update["content"]), limits)
+	}
+	raw, ok := update["rawOutput"]
+	if !ok || raw == nil {
+		return
+	}
+	data, err := json.Marshal(raw)
+	if err != nil || int64(len(data)) <= limit {
+		return
+	}
+
+	text, isText := raw.(string)
+	if !isText {
+		text = string(data)
+	}
+	preview := outputPreview(text, int(limit))
+	// The preview goes in the content only, where clients show it.
+	summary := map[string]any{
+		"truncated":     true,
+		"originalBytes": len(data),
+	}
+	content := contentEntries(update["content"])
+	if _, ok := update["content"]; !ok {
+		m.mu.Lock()
+		lastNotification := info.LastNotification
+		m.mu.Unlock()
+		if last, ok := lastNotification["update"].(map[string]any); ok {
+			content = contentEntries(last["content"])
+		}
+	}
+	content = append(content, textContent(preview))
+	if a, ok := m.saveArtifact(limits.Store, sessionID, info, info.ToolCallID+".json", "application/json", data); ok {
+		summary["artifactId"] = a.ID
+		summary["uri"] = a.URI()
+		content = append(content, map[string]any{"type": "content", "content": a.ResourceLink()})
+	}
+	update["rawOutput"] = summary
+	update["content"] = content
+}
+
+// limitDiffs replaces each diff entry whose texts exceed limit with a note
+// and a link to the unified diff saved as an artifact.
+func (m *Manager) limitDiffs(sessionID string, info *ToolCallInfo, content []any, limits OutputLimits) []any {
+	limit := limits.limit(info.Kind)
+	for i, entry := range content {
+		e, ok := entry.(map[string]any)
+		if !ok || e["type"] != "diff" {
+			continue
+		}
+		path, _ := e["path"].(string)
+		newText, _ := e["newText"].(string)
+		var oldText *string
+		if s, ok := e["oldText"].(string); ok {
+			oldText = &s
+		}
+		size := len(newText)
+		if oldText != nil {
+			size += len(*oldText)
+		}
+		if int64(size) <= limit {
+			continue
+		}
+		a, ok := m.saveArtifact(limits.Store, sessionID, info, filepath.Base(path)+".diff", "text/x-diff", []byte(diff.Unified(path, oldText, newText, limits.DiffContext)))
+		if !ok {
+			continue
+		}
+		added, removed := len(diff.SplitLines(newText)), 0
+		if oldText != nil {
+			added, removed = diff.Stats(*oldText, newText)
+		}
+		content[i] = textContent(fmt.Sprintf("%s: +%d -%d lines (diff too large to show inline)", path, added, removed))
+		content = append(content, map[string]any{"type": "content", "content": a.ResourceLink()})
+	}
+	return content
+}
+
+func (m *Manager) saveArtifact(store *artifact.Store, sessionID string, info *ToolCallInfo, name, mimeType string, data []byte) (artifact.Artifact, bool) {
+	if store == nil {
+		return artifact.Artifact{}, false
+	}
+	a, err := store.Put(sessionID, name, mimeType, data)
+	if err != nil {
+		m.logger.Warn("Failed to save oversized tool output", map[string]any{"toolCallId": info.ToolCallID, "sessionId": sessionID, "error": err.Error()})
+		return artifact.Artifact{}, false
+	}
+	return a, true
+}
+
+// outputPreview keeps the start and end of text, limit bytes in all, since
+// logs usually end with what matters.
+func outputPreview(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	half := limit / 2
+	omitted := len(text) - 2*half
+	head := strings.ToValidUTF8(text[:half], "")
+	tail := strings.ToValidUTF8(text[len(text)-half:], "")
+	return fmt.Sprintf("%s\n… %d bytes omitted …\n%s", head, omitted, tail)
+}
+
+func textContent(text string) map[string]any {
+	return map[string]any{"type": "content", "content": map[string]any{"type": "text", "text": text}}
+}
+
+func contentEntries(v any) []any {
+	switch c := v.(type) {
+	case []any:
+		return append([]any(nil), c...)
+	case []map[string]any:
+		out := make([]any, 0, len(c)+2)
+		for _, entry := range c {
+			out = append(out, entry)
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package toolcall
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/artifact"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func TestOversizedRawOutputIsSavedAndPreviewed(t *testing.T) {
+	var updates []map[string]any
+	m := NewManager(logging.New("error"), func(n map[string]any) {
+		updates = append(updates, n["params"].(map[string]any)["update"].(map[string]any))
+	}, nil)
+	store := artifact.NewStore(t.TempDir())
+	m.SetOutputLimits(OutputLimits{Store: store, MaxBytes: 64, ByKind: map[string]int64{"read": 0}, DiffContext: 1})
+
+	terminal := m.CreateTerminalContent("term-1")
+	id := m.ReportToolCall("s1", "run_command", map[string]any{"kind": "execute", "content": terminal})
+	log := strings.Repeat("building...\n", 50) + "FAIL: TestThing"
+	m.CompleteToolCall("s1", id, map[string]any{"rawOutput": log})
+
+	encoded, _ := json.Marshal(log)
+	update := updates[len(updates)-1]
+	summary, ok := update["rawOutput"].(map[string]any)
+	if !ok || summary["truncated"] != true || summary["originalBytes"] != len(encoded) || summary["preview"] != nil {
+		t.Fatalf("expected a truncated rawOutput summary without the preview, got %#v", update["rawOutput"])
+	}
+	content := update["content"].([]any)
+	preview := content[1].(map[string]any)["content"].(map[string]any)["text"].(string)
+	if len(preview) > 100 || !strings.HasPrefix(preview, "building...") || !strings.HasSuffix(preview, "FAIL: TestThing") {
+		t.Fatalf("expected the start and end of the output in the preview, got %q", preview)
+	}
+	saved, err := readArtifact(store, summary["uri"])
+	var full string
+	if err != nil || json.Unmarshal(saved, &full) != nil || full != log {
+		t.Fatalf("expected the full output on disk: %v", err)
+	}
+	if len(content) != 3 || content[0].(map[string]any)["type"] != "terminal" {
+		t.Fatalf("expected the terminal content to be kept, got %#v", content)
+	}
+	link := content[2].(map[string]any)["content"].(map[string]any)
+	if link["type"] != "resource_link" || link["uri"] != summary["uri"] || link["name"] != id+".json" {
+		t.Fatalf("expected a resource link to the saved output, got %#v", link)
+	}
+
+	read := m.ReportToolCall("s1", "read_file", map[string]any{"kind": "read"})
+	m.CompleteToolCall("s1", read, map[string]any{"rawOutput": log})
+	if got := updates[len(updates)-1]["rawOutput"]; got != log {
+		t.Fatalf("expected read output to be sent whole, got %#v", got)
+	}
+
+	oldText, newText := strings.Repeat("a\n", 40), strings.Repeat("a\n", 39)+"b\n"
+	edit := m.ReportToolCall("s1", "edit_file", map[string]any{"kind": "edit"})
+	m.CompleteToolCall("s1", edit, map[string]any{"content": []map[string]any{
+		{"type": "diff", "path": "/w/small.txt", "oldText": "x", "newText": "y"},
+		{"type": "diff", "path": "/w/big.txt", "oldText": oldText, "newText": newText},
+	}})
+	content = updates[len(updates)-1]["content"].([]any)
+	if len(content) != 3 || content[0].(map[string]any)["type"] != "diff" {
+		t.Fatalf("expected the small diff inline and the large one linked, got %#v", content)
+	}
+	note := content[1].(map[string]any)["content"].(map[string]any)["text"]
+	link = content[2].(map[string]any)["content"].(map[string]any)
+	if note != "/w/big.txt: +1 -1 lines (diff too large to show inline)" || link["name"] != "big.txt.diff" {
+		t.Fatalf("unexpected large diff entries %q %#v", note, link)
+	}
+	if unified, err := readArtifact(store, link["uri"]); err != nil || !strings.HasSuffix(string(unified), "@@ -39,2 +39,2 @@\n a\n-a\n+b") {
+		t.Fatalf("expected the unified diff in the artifact, got %q (%v)", unified, err)
+	}
+}
+
+func readArtifact(store *artifact.Store, uri any) ([]byte, error) {
+	sessionID, id, _ := artifact.ParseURI(uri.(string))
+	_, data, err := store.Get(sessionID, id, 0, 0)
+	return data, err
+}
@@ -0,0 +1,316 @@
+package tools
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Thresholds above which analyze_code reports a function as an issue.
+const (
+	longFunctionLines  = 80
+	complexFunctionCyc = 15
+)
+
+var languageByExt = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".jsx": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".rs": "rust", ".java": "java", ".kt": "kotlin", ".cs": "csharp",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".rb": "ruby", ".php": "php", ".swift": "swift",
+	".sh": "shell", ".bash": "shell", ".lua": "lua", ".sql": "sql",
+}
+
+type symbolPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// symbolPatterns and importPatterns are rough per-language heuristics for
+// languages without a parser in the standard library. The first non-empty
+// submatch is the name.
+var symbolPatterns = map[string][]symbolPattern{
+	"python": {
+		{"function", regexp.MustCompile(`^\s*(?:async\s+)?def\s+(\w+)`)},
+		{"class", regexp.MustCompile(`^\s*class\s+(\w+)`)},
+	},
+	"javascript": jsSymbolPatterns,
+	"typescript": append([]symbolPattern{
+		{"interface", regexp.MustCompile(`^\s*(?:export\s+)?interface\s+(\w+)`)},
+		{"type", regexp.MustCompile(`^\s*(?:export\s+)?type\s+(\w+)\s*=`)},
+	}, jsSymbolPatterns...),
+	"rust": {
+		{"function", regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?fn\s+(\w+)`)},
+		{"type", regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|trait)\s+(\w+)`)},
+	},
+	"java":   jvmSymbolPatterns,
+	"kotlin": jvmSymbolPatterns,
+	"csharp": jvmSymbolPatterns,
+	"ruby": {
+		{"function", regexp.MustCompile(`^\s*def\s+([\w.?!]+)`)},
+		{"class", regexp.MustCompile(`^\s*(?:class|module)\s+([\w:]+)`)},
+	},
+	"php": {
+		{"function", regexp.MustCompile(`^\s*(?:(?:public|private|protected|static)\s+)*function\s+(\w+)`)},
+		{"class", regexp.MustCompile(`^\s*(?:abstract\s+|final\s+)?class\s+(\w+)`)},
+	},
+	"shell": {
+		{"function", regexp.MustCompile(`^\s*(?:function\s+(\w+)|(\w+)\s*\(\)\s*\{)`)},
+	},
+}
+
+var jsSymbolPatterns = []symbolPattern{
+	{"function", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(\w+)`)},
+	{"function", regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+(\w+)\s*=\s*(?:async\s+)?(?:function|\([^)]*\)\s*=>|\w+\s*=>)`)},
+	{"class", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?class\s+(\w+)`)},
+}
+
+var jvmSymbolPatterns = []symbolPattern{
+	{"class", regexp.MustCompile(`^\s*(?:(?:public|private|protected|internal|abstract|final|static|sealed|data|open)\s+)*(?:class|interface|enum|record|object)\s+(\w+)`)},
+	{"function", regexp.MustCompile(`^\s*(?:(?:public|private|protected|internal|static|final|abstract|override|suspend|async|virtual)\s+)+[\w<>\[\],.? ]*?\b(\w+)\s*\([^;]*$`)},
+	{"function", regexp.MustCompile(`^\s*fun\s+(?:<[^>]*>\s*)?(?:\w+\.)?(\w+)\s*\(`)},
+}
+
+var importPatterns = map[string][]*regexp.Regexp{
+	"python":     {regexp.MustCompile(`^\s*from\s+(\S+)\s+import\b`), regexp.MustCompile(`^\s*import\s+([\w.]+)`)},
+	"javascript": jsImportPatterns,
+	"typescript": jsImportPatterns,
+	"rust":       {regexp.MustCompile(`^\s*(?:pub\s+)?use\s+([\w:]+)`), regexp.MustCompile(`^\s*extern\s+crate\s+(\w+)`)},
+	"java":       {regexp.MustCompile(`^\s*import\s+(?:static\s+)?([\w.*]+)\s*;`)},
+	"kotlin":     {regexp.MustCompile(`^\s*import\s+([\w.*]+)`)},
+	"csharp":     {regexp.MustCompile(`^\s*using\s+([\w.]+)\s*;`)},
+	"c":          {regexp.MustCompile(`^\s*#\s*include\s*[<"]([^>"]+)[>"]`)},
+	"cpp":        {regexp.MustCompile(`^\s*#\s*include\s*[<"]([^>"]+)[>"]`)},
+	"ruby":       {regexp.MustCompile(`^\s*require(?:_relative)?\s+['"]([^'"]+)['"]`)},
+	"php":        {regexp.MustCompile(`^\s*use\s+([\w\\]+)`), regexp.MustCompile(`^\s*(?:require|include)(?:_once)?\s*\(?\s*['"]([^'"]+)['"]`)},
+}
+
+var jsImportPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*import\s+(?:[^'"]*\s+from\s+)?['"]([^'"]+)['"]`),
+	regexp.MustCompile(`^\s*export\s+[^'"]*\s+from\s+['"]([^'"]+)['"]`),
+	regexp.MustCompile(`\brequire\(\s*['"]([^'"]+)['"]\s*\)`),
+}
+
+var todoPattern = regexp.MustCompile(`\b(?:TODO|FIXME|XXX|HACK)\b`)
+
+type codeAnalysis struct {
+	Language string
+	Symbols  []map[string]any
+	Imports  []string
+	Metrics  map[string]any
+	Issues   []map[string]any
+}
+
+// analyzeSource describes a source file: its top-level symbols, imports and
+// line metrics. Go files are parsed with go/parser, which also yields
+// per-function cyclomatic complexity; other languages use line patterns.
+func analyzeSource(path string, src []byte) codeAnalysis {
+	ext := strings.ToLower(filepath.Ext(path))
+	a := codeAnalysis{Language: languageByExt[ext], Symbols: make([]map[string]any, 0), Imports: make([]string, 0), Issues: make([]map[string]any, 0)}
+	if a.Language == "" {
+		a.Language = "unknown"
+	}
+	a.Metrics = lineMetrics(string(src), a.Language)
+
+	if a.Language == "go" {
+		analyzeGoSource(path, src, &a)
+	} else {
+		analyzeByPatterns(string(src), &a)
+	}
+	a.Metrics["symbols"] = len(a.Symbols)
+	a.Metrics["imports"] = len(a.Imports)
+	return a
+}
+
+func analyzeGoSource(path string, src []byte, a *codeAnalysis) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		a.Issues = append(a.Issues, map[string]any{"severity": "error", "message": err.Error()})
+		if file == nil {
+			return
+		}
+	}
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+			a.Imports = append(a.Imports, p)
+		}
+	}
+	maxComplexity := 0
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			start, end := fset.Position(d.Pos()).Line, fset.Position(d.End()).Line
+			complexity := cyclomaticComplexity(d)
+			maxComplexity = max(maxComplexity, complexity)
+			sym := map[string]any{"kind": "function", "name": d.Name.Name, "line": start, "endLine": end, "lines": end - start + 1, "complexity": complexity, "exported": d.Name.IsExported()}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				sym["kind"] = "method"
+				sym["receiver"] = receiverName(d.Recv.List[0].Type)
+			}
+			a.Symbols = append(a.Symbols, sym)
+			a.Issues = append(a.Issues, functionIssues(d.Name.Name, start, end-start+1, complexity)...)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					switch s.Type.(type) {
+					case *ast.StructType:
+						kind = "struct"
+					case *ast.InterfaceType:
+						kind = "interface"
+					}
+					a.Symbols = append(a.Symbols, map[string]any{"kind": kind, "name": s.Name.Name, "line": fset.Position(s.Pos()).Line, "exported": s.Name.IsExported()})
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, name := range s.Names {
+						a.Symbols = append(a.Symbols, map[string]any{"kind": kind, "name": name.Name, "line": fset.Position(name.Pos()).Line, "exported": name.IsExported()})
+					}
+				}
+			}
+		}
+	}
+	a.Metrics["package"] = file.Name.Name
+	a.Metrics["maxComplexity"] = maxComplexity
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	}
+	return ""
+}
+
+// cyclomaticComplexity is 1 plus the number of branch points in fn.
+func cyclomaticComplexity(fn *ast.FuncDecl) int {
+	complexity := 1
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			if n.List != nil {
+				complexity++
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
+
+func functionIssues(name string, line, lines, complexity int) []map[string]any {
+	var issues []map[string]any
+	if lines > longFunctionLines {
+		issues = append(issues, map[string]any{"severity": "warning", "line": line, "message": name + " is " + strconv.Itoa(lines) + " lines long"})
+	}
+	if complexity > complexFunctionCyc {
+		issues = append(issues, map[string]any{"severity": "warning", "line": line, "message": name + " has cyclomatic complexity " + strconv.Itoa(complexity)})
+	}
+	return issues
+}
+
+func analyzeByPatterns(src string, a *codeAnalysis) {
+	seen := map[string]bool{}
+	for i, line := range strings.Split(src, "\n") {
+		for _, p := range symbolPatterns[a.Language] {
+			if name := firstSubmatch(p.re, line); name != "" {
+				a.Symbols = append(a.Symbols, map[string]any{"kind": p.kind, "name": name, "line": i + 1})
+				break
+			}
+		}
+		for _, re := range importPatterns[a.Language] {
+			if name := firstSubmatch(re, line); name != "" && !seen[name] {
+				seen[name] = true
+				a.Imports = append(a.Imports, name)
+			}
+		}
+	}
+}
+
+func firstSubmatch(re *regexp.Regexp, line string) string {
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	for _, group := range m[1:] {
+		if group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+// lineMetrics counts total, code, comment and blank lines. Comments are
+// recognised by the language's line prefix and, for C-like languages,
+// /* */ blocks.
+func lineMetrics(src, language string) map[string]any {
+	linePrefix := "//"
+	blockComments := true
+	switch language {
+	case "python", "ruby", "shell":
+		linePrefix, blockComments = "#", false
+	case "sql", "lua":
+		linePrefix, blockComments = "--", false
+	case "unknown":
+		linePrefix, blockComments = "", false
+	}
+
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	if src == "" {
+		lines = nil
+	}
+	var code, comment, blank, longest, todos int
+	inBlock := false
+	for _, line := range lines {
+		longest = max(longest, len(line))
+		if todoPattern.MatchString(line) {
+			todos++
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inBlock:
+			comment++
+			if strings.Contains(trimmed, "*/") {
+				inBlock = false
+			}
+		case trimmed == "":
+			blank++
+		case linePrefix != "" && strings.HasPrefix(trimmed, linePrefix):
+			comment++
+		case blockComments && strings.HasPrefix(trimmed, "/*"):
+			comment++
+			inBlock = !strings.Contains(trimmed[2:], "*/")
+		default:
+			code++
+		}
+	}
+	return map[string]any{
+		"lines":         len(lines),
+		"codeLines":     code,
+		"commentLines":  comment,
+		"blankLines":    blank,
+		"maxLineLength": longest,
+		"todos":         todos,
+	}
+}
@@ -1,29 +1,42 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
 	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/diff"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
+const (
+	searchContextLines = 2
+	// maxExplainBytes bounds the code sent to cursor-agent by explain_code.
+	maxExplainBytes = 64 << 10
+)
+
+// CursorProvider offers codebase tools that run locally in the session
+// working directory (search, analysis, line-range edits, test runs, project
+// information), plus explain_code, which asks cursor-agent in print mode.
 type CursorProvider struct {
 	cfg    config.Config
 	logger *logging.Logger
 	bridge *cursor.Bridge
+	policy *fspolicy.Policy
 }
 
 func NewCursorProvider(cfg config.Config, logger *logging.Logger, bridge *cursor.Bridge) *CursorProvider {
-	return &CursorProvider{cfg: cfg, logger: logger, bridge: bridge}
+	return &CursorProvider{cfg: cfg, logger: logger, bridge: bridge, policy: fspolicy.New(cfg.Tools.Filesystem)}
 }
 
 func (p *CursorProvider) Name() string {
@@ -31,7 +44,7 @@ func (p *CursorProvider) Name() string {
 }
 
 func (p *CursorProvider) Description() string {
-	return "Cursor CLI integration for code analysis and modification"
+	return "Codebase search, analysis, editing, test and project tools, with code explanations from cursor-agent"
 }
 
 func (p *CursorProvider) GetTools() []Tool {
@@ -42,7 +55,7 @@ func (p *CursorProvider) GetTools() []Tool {
 	return []Tool{
 		{
 			Name:        "search_codebase",
-			Description: "Search for code patterns, symbols, or text across the codebase",
+			Description: "Search for code patterns, symbols, or text across the codebase (regex, honors .gitignore)",
 			Parameters: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -63,7 +76,7 @@ func (p *CursorProvider) GetTools() []Tool {
 				"type": "object",
 				"properties": map[string]any{
 					"file_path":       map[string]any{"type": "string"},
-					"analysis_type":   map[string]any{"type": "string"},
+					"analysis_type":   map[string]any{"type": "string", "enum": []string{"all", "structure", "dependencies", "metrics"}},
 					"include_metrics": map[string]any{"type": "boolean"},
 				},
 				"required": []string{"file_path"},
@@ -76,17 +89,18 @@ func (p *CursorProvider) GetTools() []Tool {
 			Parameters: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
-					"changes": map[string]any{"type": "array"},
+					"changes": map[string]any{"type": "array", "description": "Line-range replacements: {file, startLine, endLine, newContent}"},
 					"dry_run": map[string]any{"type": "boolean"},
 					"backup":  map[string]any{"type": "boolean"},
 				},
 				"required": []string{"changes"},
 			},
-			Handler: p.applyCodeChanges,
+			Handler:     p.applyCodeChanges,
+			Destructive: true,
 		},
 		{
 			Name:        "run_tests",
-			Description: "Execute tests using the project's test runner",
+			Description: "Execute tests using the project's test runner (go test, npm/yarn/pnpm, pytest, cargo test or make test)",
 			Parameters: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -101,13 +115,14 @@ func (p *CursorProvider) GetTools() []Tool {
 		},
 		{
 			Name:        "get_project_info",
-			Description: "Get information about the current project structure and configuration",
+			Description: "Get information about the current project: type, package managers, dependencies, scripts and optionally its directory structure",
 			Parameters: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
 					"include_dependencies": map[string]any{"type": "boolean"},
 					"include_scripts":      map[string]any{"type": "boolean"},
 					"include_structure":    map[string]any{"type": "boolean"},
+					"structure_depth":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxProjectStructureDepth},
 				},
 			},
 			Handler: p.getProjectInfo,
@@ -119,8 +134,8 @@ func (p *CursorProvider) GetTools() []Tool {
 				"type": "object",
 				"properties": map[string]any{
 					"file_path":        map[string]any{"type": "string"},
-					"start_line":       map[string]any{"type": "number"},
-					"end_line":         map[string]any{"type": "number"},
+					"start_line":       map[string]any{"type": "number", "minimum": 1},
+					"end_line":         map[string]any{"type": "number", "minimum": 1},
 					"explanation_type": map[string]any{"type": "string"},
 				},
 				"required": []string{"file_path"},
@@ -132,7 +147,7 @@ func (p *CursorProvider) GetTools() []Tool {
 
 func (p *CursorProvider) Cleanup() error { return nil }
 
-func (p *CursorProvider) searchCodebase(params map[string]any) (acp.ToolResult, error) {
+func (p *CursorProvider) searchCodebase(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	query := getString(params, "query")
 	filePattern := getString(params, "file_pattern")
 	caseSensitive := getBool(params, "case_sensitive", false)
@@ -142,30 +157,31 @@ func (p *CursorProvider) searchCodebase(params map[string]any) (acp.ToolResult,
 		maxResults = 50
 	}
 
-	args := []string{"search"}
-	if query != "" {
-		args = append(args, "--query", query)
-	}
-	if filePattern != "" {
-		args = append(args, "--files", filePattern)
-	}
-	if caseSensitive {
-		args = append(args, "--case-sensitive")
+	pattern, err := compileSearchPattern(query, false, caseSensitive)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid search pattern: %v", err)}, nil
 	}
-	args = append(args, "--limit", strconv.Itoa(maxResults))
-	if includeContext {
-		args = append(args, "--context", "3")
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
 
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{})
+	searchResults := make([]SearchResult, 0)
+	summary, err := searchLocalFiles([]string{dir}, searchOptions{
+		Pattern:     pattern,
+		Include:     filePattern,
+		MaxResults:  maxResults,
+		MaxFileSize: p.policy.MaxFileSize(),
+	}, func(m searchMatch) {
+		searchResults = append(searchResults, SearchResult{File: m.Path, Line: m.Line, Column: m.Column, Content: strings.TrimSpace(m.Text)})
+	})
 	if err != nil {
 		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if !result.Success {
-		return acp.ToolResult{Success: false, Error: result.Error}, nil
+	if includeContext {
+		addSearchContext(searchResults, searchContextLines)
 	}
 
-	searchResults := parseSearchResults(result.Stdout, includeContext)
 	locations := make([]map[string]any, 0, len(searchResults))
 	for i, r := range searchResults {
 		if i >= 10 {
@@ -174,10 +190,40 @@ func (p *CursorProvider) searchCodebase(params map[string]any) (acp.ToolResult,
 		locations = append(locations, map[string]any{"path": filepath.Clean(r.File), "line": r.Line})
 	}
 
-	return acp.ToolResult{Success: true, Result: map[string]any{"query": query, "results": searchResults, "total": len(searchResults), "truncated": len(searchResults) >= maxResults}, Metadata: map[string]any{"searchTime": 0, "filePattern": filePattern, "caseSensitive": caseSensitive, "locations": locations}}, nil
+	payload := map[string]any{"query": query, "results": searchResults, "total": len(searchResults), "truncated": summary.Truncated}
+	if len(summary.Skipped) > 0 {
+		payload["skipped"] = summary.Skipped
+	}
+	return acp.ToolResult{Success: true, Result: payload, Metadata: map[string]any{"filesScanned": summary.FilesScanned, "filePattern": filePattern, "caseSensitive": caseSensitive, "locations": locations}}, nil
+}
+
+// addSearchContext fills in the lines around each result, reading every
+// matched file once.
+func addSearchContext(results []SearchResult, n int) {
+	files := map[string][]string{}
+	for i := range results {
+		lines, ok := files[results[i].File]
+		if !ok {
+			if data, err := os.ReadFile(results[i].File); err == nil {
+				lines = strings.Split(string(data), "\n")
+			}
+			files[results[i].File] = lines
+		}
+		line := results[i].Line - 1
+		if line < 0 || line >= len(lines) {
+			continue
+		}
+		context := make([]string, 0, 2*n)
+		for j := max(line-n, 0); j <= min(line+n, len(lines)-1); j++ {
+			if j != line {
+				context = append(context, lines[j])
+			}
+		}
+		results[i].Context = context
+	}
 }
 
-func (p *CursorProvider) analyzeCode(params map[string]any) (acp.ToolResult, error) {
+func (p *CursorProvider) analyzeCode(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	filePath := getString(params, "file_path")
 	if filePath == "" {
 		return acp.ToolResult{Success: false, Error: "Invalid file path"}, nil
@@ -188,28 +234,46 @@ func (p *CursorProvider) analyzeCode(params map[string]any) (acp.ToolResult, err
 	}
 	includeMetrics := getBool(params, "include_metrics", true)
 
-	resolved := filepath.Clean(filePath)
-	args := []string{"analyze", resolved}
-	if analysisType != "all" {
-		args = append(args, "--type", analysisType)
-	}
-	if includeMetrics {
-		args = append(args, "--metrics")
+	resolved, err := p.resolve(params, filePath, false, 0)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{})
+	src, err := os.ReadFile(resolved)
 	if err != nil {
 		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if !result.Success {
-		return acp.ToolResult{Success: false, Error: result.Error}, nil
+	analysis := analyzeSource(resolved, src)
+
+	result := map[string]any{"file": filePath, "analysisType": analysisType, "language": analysis.Language}
+	if analysisType == "all" || analysisType == "structure" {
+		result["structure"] = analysis.Symbols
+	}
+	if analysisType == "all" || analysisType == "dependencies" {
+		result["dependencies"] = analysis.Imports
+	}
+	if includeMetrics && (analysisType == "all" || analysisType == "metrics") {
+		result["metrics"] = analysis.Metrics
+	}
+	if len(analysis.Issues) > 0 {
+		result["issues"] = analysis.Issues
 	}
-	analysis := parseJSONObjectOrRaw(result.Stdout)
+	return acp.ToolResult{Success: true, Result: result, Metadata: map[string]any{"includeMetrics": includeMetrics, "locations": []map[string]any{{"path": resolved}}}}, nil
+}
+
+type codeChange struct {
+	File       string `json:"file"`
+	StartLine  int    `json:"startLine"`
+	EndLine    int    `json:"endLine"`
+	NewContent string `json:"newContent"`
 
-	return acp.ToolResult{Success: true, Result: mergeMaps(map[string]any{"file": filePath, "analysisType": analysisType}, analysis), Metadata: map[string]any{"analysisTime": 0, "includeMetrics": includeMetrics, "locations": []map[string]any{{"path": resolved}}}}, nil
+	path string
 }
 
-func (p *CursorProvider) applyCodeChanges(params map[string]any) (acp.ToolResult, error) {
+// applyCodeChanges validates every change and computes every new file
+// before writing anything. Changes to one file are applied bottom-up so
+// their line numbers all refer to the original file. If a write fails, the
+// files already written are restored.
+func (p *CursorProvider) applyCodeChanges(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	if !p.cfg.Tools.Cursor.EnableCodeModification {
 		return acp.ToolResult{Success: false, Error: "Code modification is disabled"}, nil
 	}
@@ -219,135 +283,243 @@ func (p *CursorProvider) applyCodeChanges(params map[string]any) (acp.ToolResult
 		return acp.ToolResult{Success: false, Error: "No changes provided"}, nil
 	}
 
-	type CodeChange struct {
-		File       string `json:"file"`
-		StartLine  int    `json:"startLine"`
-		EndLine    int    `json:"endLine"`
-		NewContent string `json:"newContent"`
-	}
-	changes := make([]CodeChange, 0, len(rawChanges))
-	diffs := make([]any, 0, len(rawChanges))
+	byFile := map[string][]codeChange{}
+	order := make([]string, 0)
 	locations := make([]map[string]any, 0, len(rawChanges))
 	for i, rc := range rawChanges {
 		changeMap, ok := rc.(map[string]any)
 		if !ok {
 			return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: Change %d: Missing change object", i+1)}, nil
 		}
-		file := getString(changeMap, "file")
-		startLine := getInt(changeMap, "startLine", 0)
-		endLine := getInt(changeMap, "endLine", 0)
-		newContent := getString(changeMap, "newContent")
-		if file == "" || startLine < 1 || endLine < startLine {
+		change := codeChange{
+			File:       getString(changeMap, "file"),
+			StartLine:  getInt(changeMap, "startLine", 0),
+			EndLine:    getInt(changeMap, "endLine", 0),
+			NewContent: getString(changeMap, "newContent"),
+		}
+		if change.File == "" || change.StartLine < 1 || change.EndLine < change.StartLine {
 			return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: Change %d has invalid fields", i+1)}, nil
 		}
-
-		changes = append(changes, CodeChange{File: file, StartLine: startLine, EndLine: endLine, NewContent: newContent})
-		locations = append(locations, map[string]any{"path": filepath.Clean(file), "line": startLine})
-
-		oldText := ""
-		if b, err := os.ReadFile(file); err == nil {
-			oldText = string(b)
+		resolved, err := p.resolve(params, change.File, true, 0)
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: Change %d: %v", i+1, err)}, nil
+		}
+		change.path = resolved
+		if _, seen := byFile[resolved]; !seen {
+			order = append(order, resolved)
+		}
+		byFile[resolved] = append(byFile[resolved], change)
+		locations = append(locations, map[string]any{"path": resolved, "line": change.StartLine})
+	}
+
+	type fileEdit struct {
+		path     string
+		original string
+		updated  string
+		mode     os.FileMode
+	}
+	edits := make([]fileEdit, 0, len(order))
+	diffs := make([]any, 0, len(order))
+	patches := make([]string, 0, len(order))
+	added, removed := 0, 0
+	dir, _ := p.workDir(params)
+	for _, path := range order {
+		info, err := os.Stat(path)
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: %v", err)}, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: %v", err)}, nil
 		}
-		diffText := formatUnifiedDiff(file, oldText, newContent)
-		diffs = append(diffs, acp.ContentBlock{Type: "resource", Resource: &acp.EmbeddedResource{URI: "diff://" + filepath.Clean(file), Text: diffText, MimeType: "text/x-diff"}, Annotations: map[string]any{"_meta": map[string]any{"diffType": "unified", "originalPath": filepath.Clean(file), "isNewFile": oldText == ""}}})
+		changes := byFile[path]
+		sort.Slice(changes, func(i, j int) bool { return changes[i].StartLine > changes[j].StartLine })
+		updated := string(data)
+		for i, change := range changes {
+			if i > 0 && change.EndLine >= changes[i-1].StartLine {
+				return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: overlapping changes to %s at lines %d-%d and %d-%d", change.File, change.StartLine, change.EndLine, changes[i-1].StartLine, changes[i-1].EndLine)}, nil
+			}
+			if updated, err = replaceLineRange(updated, change.StartLine, change.EndLine, change.NewContent); err != nil {
+				return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid changes: %s: %v", change.File, err)}, nil
+			}
+		}
+		if err := p.policy.CheckSize(path, int64(len(updated))); err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+		original := string(data)
+		edits = append(edits, fileEdit{path: path, original: original, updated: updated, mode: info.Mode().Perm()})
+		diffs = append(diffs, acp.DiffBlock(path, &original, updated))
+		name := path
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+		patches = append(patches, diff.Unified(name, &original, updated, p.cfg.Tools.DiffContextLines))
+		a, r := diff.Stats(original, updated)
+		added += a
+		removed += r
 	}
 
 	dryRun := getBool(params, "dry_run", false)
 	backup := getBool(params, "backup", true)
-
-	args := []string{"apply-changes"}
-	if dryRun {
-		args = append(args, "--dry-run")
-	}
-	if backup {
-		args = append(args, "--backup")
+	result := map[string]any{"applied": !dryRun, "changesCount": len(rawChanges), "files": order, "diff": strings.Join(patches, "\n"), "linesAdded": added, "linesRemoved": removed}
+	if !dryRun {
+		// The backup is only kept when a failed write cannot be rolled back.
+		backupDir := ""
+		if backup {
+			dir, err := os.MkdirTemp("", "cursor-agent-acp-backup-")
+			if err != nil {
+				return acp.ToolResult{Success: false, Error: fmt.Sprintf("Failed to create backup: %v", err)}, nil
+			}
+			for i, edit := range edits {
+				name := filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(edit.path)))
+				if err := os.WriteFile(name, []byte(edit.original), 0o600); err != nil {
+					_ = os.RemoveAll(dir)
+					return acp.ToolResult{Success: false, Error: fmt.Sprintf("Failed to create backup: %v", err)}, nil
+				}
+			}
+			backupDir = dir
+		}
+		for i, edit := range edits {
+			if err := writeFileAtomic(edit.path, []byte(edit.updated), edit.mode); err != nil {
+				failed := make([]string, 0)
+				for _, done := range edits[:i] {
+					if err := writeFileAtomic(done.path, []byte(done.original), done.mode); err != nil {
+						failed = append(failed, done.path)
+					}
+				}
+				if len(failed) > 0 {
+					where := "no backup was made"
+					if backupDir != "" {
+						where = "originals are in " + backupDir
+					}
+					return acp.ToolResult{Success: false, Error: fmt.Sprintf("Failed to write %s and to roll back %s (%s): %v", edit.path, strings.Join(failed, ", "), where, err)}, nil
+				}
+				removeBackup(backupDir)
+				return acp.ToolResult{Success: false, Error: fmt.Sprintf("Failed to write %s, no changes were applied: %v", edit.path, err)}, nil
+			}
+		}
+		removeBackup(backupDir)
 	}
+	return acp.ToolResult{Success: true, Result: result, Metadata: map[string]any{"dryRun": dryRun, "backup": backup, "diffs": diffs, "locations": locations}}, nil
+}
 
-	payload, _ := json.MarshalIndent(changes, "", "  ")
-	tmpFile := filepath.Join(".", ".cursor-changes.json")
-	_ = os.WriteFile(tmpFile, payload, 0o644)
-	defer func() { _ = os.Remove(tmpFile) }()
-	args = append(args, "--changes-file", tmpFile)
+func removeBackup(dir string) {
+	if dir != "" {
+		_ = os.RemoveAll(dir)
+	}
+}
 
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{})
+// writeFileAtomic replaces path through a temporary file in the same
+// directory, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
 	if err != nil {
-		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		return err
 	}
-	if !result.Success {
-		return acp.ToolResult{Success: false, Error: result.Error}, nil
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	apply := parseJSONObjectOrRaw(result.Stdout)
-	return acp.ToolResult{Success: true, Result: mergeMaps(map[string]any{"applied": !dryRun, "changesCount": len(changes)}, apply), Metadata: map[string]any{"applyTime": 0, "dryRun": dryRun, "backup": backup, "diffs": diffs, "locations": locations}}, nil
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func (p *CursorProvider) runTests(params map[string]any) (acp.ToolResult, error) {
+func (p *CursorProvider) runTests(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	if !p.cfg.Tools.Cursor.EnableTestExecution {
 		return acp.ToolResult{Success: false, Error: "Test execution is disabled"}, nil
 	}
-
-	testPattern := getString(params, "test_pattern")
-	testFramework := getString(params, "test_framework")
-	if testFramework == "" {
-		testFramework = "auto"
+	if getBool(params, "watch_mode", false) {
+		return acp.ToolResult{Success: false, Error: "watch_mode is not supported; run the watcher in a terminal instead"}, nil
 	}
-	watch := getBool(params, "watch_mode", false)
-	coverage := getBool(params, "coverage", false)
-	timeout := getInt(params, "timeout", 300)
 
-	args := []string{"test"}
-	if testPattern != "" {
-		args = append(args, "--pattern", testPattern)
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if testFramework != "auto" {
-		args = append(args, "--framework", testFramework)
+	coverage := getBool(params, "coverage", false)
+	runner, err := detectTestRunner(dir, getString(params, "test_framework"))
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if watch {
-		args = append(args, "--watch")
+	pattern := getString(params, "test_pattern")
+	if strings.HasPrefix(pattern, "-") {
+		// Most runners take the pattern as a bare argument, where it would
+		// be read as a flag.
+		return acp.ToolResult{Success: false, Error: "test_pattern must not start with '-'"}, nil
 	}
-	if coverage {
-		args = append(args, "--coverage")
+	argv := runner.command(pattern, coverage)
+
+	if timeout := getInt(params, "timeout", 300); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
 	}
-	if timeout > 0 {
-		args = append(args, "--timeout", strconv.Itoa(timeout))
+	start := time.Now()
+	var onLine func(string)
+	if fn, ok := params["_progress"].(ProgressFunc); ok && fn != nil {
+		progress := &testProgress{report: fn, command: strings.Join(argv, " "), goJSON: runner.name == "go"}
+		onLine = progress.line
 	}
-
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{Timeout: time.Duration(timeout) * time.Second})
+	stdout, stderr, exitCode, err := runTestCommand(ctx, dir, processEnv(p.cfg), argv, onLine)
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return acp.ToolResult{Success: false, Error: fmt.Sprintf("%s timed out", strings.Join(argv, " "))}, nil
+		}
 		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	parsed := parseTestResults(result.Stdout, result.Stderr)
-	return acp.ToolResult{Success: result.Success, Result: mergeMaps(map[string]any{"framework": mapValue(parsed, "framework", testFramework)}, parsed), Error: ternary(!result.Success, result.Error, ""), Metadata: map[string]any{"executionTime": 0, "watchMode": watch, "coverage": coverage}}, nil
-}
 
-func (p *CursorProvider) getProjectInfo(params map[string]any) (acp.ToolResult, error) {
-	includeDependencies := getBool(params, "include_dependencies", true)
-	includeScripts := getBool(params, "include_scripts", true)
-	includeStructure := getBool(params, "include_structure", false)
+	parsed := runner.parse(stdout, stderr)
+	parsed["framework"] = runner.name
+	parsed["command"] = strings.Join(argv, " ")
+	parsed["exitCode"] = exitCode
+	result := acp.ToolResult{Success: exitCode == 0, Result: parsed, Metadata: map[string]any{"executionTime": time.Since(start).Milliseconds(), "coverage": coverage}}
+	if exitCode != 0 {
+		result.Error = fmt.Sprintf("%s exited with status %d", strings.Join(argv, " "), exitCode)
+	}
+	return result, nil
+}
 
-	args := []string{"info"}
-	if includeDependencies {
-		args = append(args, "--dependencies")
+func (p *CursorProvider) getProjectInfo(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	opts := projectInfoOptions{
+		Dependencies: getBool(params, "include_dependencies", true),
+		Scripts:      getBool(params, "include_scripts", true),
+		Structure:    getBool(params, "include_structure", false),
+		Depth:        p.cfg.Tools.Cursor.StructureDepth,
 	}
-	if includeScripts {
-		args = append(args, "--scripts")
+	if depth, ok := intParam(params, "structure_depth"); ok {
+		opts.Depth = depth
 	}
-	if includeStructure {
-		args = append(args, "--structure")
+	if opts.Depth <= 0 {
+		opts.Depth = defaultProjectStructureDepth
 	}
+	opts.Depth = min(opts.Depth, maxProjectStructureDepth)
 
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{})
+	dir, err := p.workDir(params)
 	if err != nil {
 		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if !result.Success {
-		return acp.ToolResult{Success: false, Error: result.Error}, nil
-	}
-	info := parseJSONObjectOrRaw(result.Stdout)
-	return acp.ToolResult{Success: true, Result: info, Metadata: map[string]any{"infoTime": 0, "includeDependencies": includeDependencies, "includeScripts": includeScripts, "includeStructure": includeStructure}}, nil
+	info := collectProjectInfo(dir, opts)
+	return acp.ToolResult{Success: true, Result: info, Metadata: map[string]any{"includeDependencies": opts.Dependencies, "includeScripts": opts.Scripts, "includeStructure": opts.Structure, "structureDepth": opts.Depth}}, nil
 }
 
-func (p *CursorProvider) explainCode(params map[string]any) (acp.ToolResult, error) {
+var explainInstructions = map[string]string{
+	"summary":  "Summarize what this code does in a few sentences.",
+	"detailed": "Explain in detail what this code does, how it works, and any notable edge cases or pitfalls.",
+	"line":     "Walk through this code line by line (or block by block) and explain each part.",
+	"docs":     "Write documentation comments for the functions and types in this code, in the language's usual style.",
+}
+
+// explainCode sends the selected lines to cursor-agent --print. The prompt
+// carries the code itself and cursor-agent runs without --force, so it
+// cannot modify files or run commands while answering.
+func (p *CursorProvider) explainCode(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	filePath := getString(params, "file_path")
 	if filePath == "" {
 		return acp.ToolResult{Success: false, Error: "file_path is required"}, nil
@@ -358,189 +530,113 @@ func (p *CursorProvider) explainCode(params map[string]any) (acp.ToolResult, err
 	if explanationType == "" {
 		explanationType = "summary"
 	}
-
-	args := []string{"explain", filePath}
-	if startLine > 0 {
-		args = append(args, "--start-line", strconv.Itoa(startLine))
+	if p.bridge == nil {
+		return acp.ToolResult{Success: false, Error: "cursor-agent is not available"}, nil
 	}
-	if endLine > 0 {
-		args = append(args, "--end-line", strconv.Itoa(endLine))
-	}
-	args = append(args, "--type", explanationType)
 
-	result, err := p.bridge.ExecuteCommand(nil, prependCursorAgentArg(args), cursor.CommandOptions{})
+	resolved, err := p.resolve(params, filePath, false, 0)
 	if err != nil {
 		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	if !result.Success {
-		return acp.ToolResult{Success: false, Error: result.Error}, nil
+	data, err := os.ReadFile(resolved)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	explanation := parseJSONObjectOrRaw(result.Stdout)
-	return acp.ToolResult{Success: true, Result: mergeMaps(map[string]any{"file": filePath, "startLine": startLine, "endLine": endLine, "explanationType": explanationType}, explanation), Metadata: map[string]any{"explanationTime": 0, "locations": []map[string]any{{"path": filepath.Clean(filePath), "line": startLine}}}}, nil
-}
-
-// parsing helpers
-
-type SearchResult struct {
-	File    string   `json:"file"`
-	Line    int      `json:"line"`
-	Column  int      `json:"column,omitempty"`
-	Content string   `json:"content"`
-	Context []string `json:"context,omitempty"`
-}
-
-func parseSearchResults(output string, includeContext bool) []SearchResult {
-	jsonObj := parseJSONObject(output)
-	if jsonObj != nil {
-		if raw, ok := jsonObj["results"]; ok {
-			if b, err := json.Marshal(raw); err == nil {
-				var parsed []SearchResult
-				if err := json.Unmarshal(b, &parsed); err == nil {
-					return parsed
-				}
-			}
-		}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if startLine < 1 {
+		startLine = 1
 	}
-
-	results := make([]SearchResult, 0)
-	lines := strings.Split(output, "\n")
-	var current *SearchResult
-	re := regexp.MustCompile(`^(.+):(\d+):(\d+)?:\s*(.*)$`)
-	for _, line := range lines {
-		m := re.FindStringSubmatch(line)
-		if len(m) > 0 {
-			if current != nil {
-				results = append(results, *current)
-			}
-			lineNum, _ := strconv.Atoi(m[2])
-			column := 0
-			if m[3] != "" {
-				column, _ = strconv.Atoi(m[3])
-			}
-			current = &SearchResult{File: m[1], Line: lineNum, Column: column, Content: strings.TrimSpace(m[4])}
-			if includeContext {
-				current.Context = []string{}
-			}
-		} else if current != nil && includeContext && strings.TrimSpace(line) != "" {
-			current.Context = append(current.Context, line)
-		}
+	if endLine < 1 || endLine > len(lines) {
+		endLine = len(lines)
 	}
-	if current != nil {
-		results = append(results, *current)
+	if startLine > endLine {
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("start_line %d is past the end of %s (%d lines)", startLine, filePath, len(lines))}, nil
+	}
+	snippet := strings.Join(lines[startLine-1:endLine], "\n")
+	if len(snippet) > maxExplainBytes {
+		snippet = snippet[:maxExplainBytes] + "\n... (truncated)"
 	}
-	return results
-}
 
-func parseJSONObjectOrRaw(output string) map[string]any {
-	if obj := parseJSONObject(output); obj != nil {
-		return obj
+	instruction, ok := explainInstructions[explanationType]
+	if !ok {
+		instruction = fmt.Sprintf("Explain this code (%s).", explanationType)
 	}
-	return map[string]any{"raw": output}
-}
+	prompt := fmt.Sprintf("%s Do not modify any files or run any commands.\n\nFile: %s (lines %d-%d)\n\n```%s\n%s\n```\n",
+		instruction, filePath, startLine, endLine, strings.TrimPrefix(filepath.Ext(resolved), "."), snippet)
 
-func parseJSONObject(output string) map[string]any {
-	start := strings.Index(output, "{")
-	end := strings.LastIndex(output, "}")
-	if start < 0 || end < 0 || end < start {
-		return nil
+	dir, _ := p.workDir(params)
+	res, err := p.bridge.ExecuteCommand(ctx, []string{"--print", "--output-format", "json"}, cursor.CommandOptions{Cwd: dir, Stdin: prompt})
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
 	}
-	candidate := output[start : end+1]
-	var out map[string]any
-	if err := json.Unmarshal([]byte(candidate), &out); err != nil {
-		return nil
+	if !res.Success {
+		return acp.ToolResult{Success: false, Error: res.Error}, nil
 	}
-	return out
-}
-
-func parseTestResults(stdout, stderr string) map[string]any {
-	combined := stdout + "\n" + stderr
-	if obj := parseJSONObject(combined); obj != nil {
-		return obj
-	}
-
-	tests := make([]map[string]any, 0)
-	re := regexp.MustCompile(`(PASS|FAIL|SKIP)\s+(.+?)(?:\s+\((\d+(?:\.\d+)?)s\))?$`)
-	for _, line := range strings.Split(combined, "\n") {
-		m := re.FindStringSubmatch(strings.TrimSpace(line))
-		if len(m) > 0 {
-			test := map[string]any{"file": m[2], "suite": "", "test": m[2], "status": strings.ToLower(m[1])}
-			if m[3] != "" {
-				if d, err := strconv.ParseFloat(m[3], 64); err == nil {
-					test["duration"] = d
-				}
-			}
-			tests = append(tests, test)
+	explanation := strings.TrimSpace(res.Stdout)
+	if obj := parseJSONObject(res.Stdout); obj != nil {
+		if text, ok := obj["result"].(string); ok && strings.TrimSpace(text) != "" {
+			explanation = text
 		}
 	}
 
-	return map[string]any{
-		"framework": "unknown",
-		"tests":     tests,
-		"summary": map[string]any{
-			"total":   len(tests),
-			"passed":  countStatus(tests, "pass"),
-			"failed":  countStatus(tests, "fail"),
-			"skipped": countStatus(tests, "skip"),
+	return acp.ToolResult{
+		Success: true,
+		Result:  map[string]any{"file": filePath, "startLine": startLine, "endLine": endLine, "explanationType": explanationType, "explanation": explanation},
+		Metadata: map[string]any{
+			"content":   []any{acp.ContentBlock{Type: "text", Text: explanation}},
+			"locations": []map[string]any{{"path": resolved, "line": startLine}},
 		},
-		"raw": combined,
-	}
+	}, nil
 }
 
-func countStatus(tests []map[string]any, status string) int {
-	count := 0
-	for _, t := range tests {
-		if strings.Contains(strings.ToLower(fmt.Sprint(t["status"])), status) {
-			count++
-		}
+// workDir is the session working directory, or the first allowed path.
+func (p *CursorProvider) workDir(params map[string]any) (string, error) {
+	if dir := getString(params, "_cwd"); dir != "" {
+		return dir, nil
 	}
-	return count
-}
-
-func mapValue(m map[string]any, key string, def any) any {
-	if v, ok := m[key]; ok {
-		return v
+	roots := p.policy.Roots()
+	if len(roots) == 0 {
+		return "", fmt.Errorf("No working directory available")
 	}
-	return def
+	return roots[0], nil
 }
 
-func mergeMaps(parts ...map[string]any) map[string]any {
-	out := map[string]any{}
-	for _, m := range parts {
-		for k, v := range m {
-			out[k] = v
+// resolve makes path absolute against the session working directory and
+// checks it against the filesystem policy.
+func (p *CursorProvider) resolve(params map[string]any, path string, write bool, size int) (string, error) {
+	path = fspolicy.Normalize(path)
+	if !filepath.IsAbs(path) {
+		if dir := getString(params, "_cwd"); dir != "" {
+			path = filepath.Join(dir, path)
 		}
 	}
-	return out
+	policy := sessionPolicy(p.policy, params)
+	if write {
+		return policy.ValidateWrite(path, size)
+	}
+	return policy.ValidateRead(path)
 }
 
-func ternary(cond bool, whenTrue string, whenFalse string) string {
-	if cond {
-		return whenTrue
-	}
-	return whenFalse
+// parsing helpers
+
+type SearchResult struct {
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Column  int      `json:"column,omitempty"`
+	Content string   `json:"content"`
+	Context []string `json:"context,omitempty"`
 }
 
-func formatUnifiedDiff(filePath, oldContent, newContent string) string {
-	lines := make([]string, 0)
-	lines = append(lines, "--- "+filePath)
-	lines = append(lines, "+++ "+filePath)
-	oldLines := strings.Split(oldContent, "\n")
-	newLines := strings.Split(newContent, "\n")
-	lines = append(lines, fmt.Sprintf("@@ -1,%d +1,%d @@", len(oldLines), len(newLines)))
-	if oldContent != "" {
-		for _, line := range oldLines {
-			lines = append(lines, "-"+line)
-		}
+func parseJSONObject(output string) map[string]any {
+	start := strings.Index(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < 0 || end < start {
+		return nil
 	}
-	for _, line := range newLines {
-		lines = append(lines, "+"+line)
+	candidate := output[start : end+1]
+	var out map[string]any
+	if err := json.Unmarshal([]byte(candidate), &out); err != nil {
+		return nil
 	}
-	return strings.Join(lines, "\n")
-}
-
-func prependCursorAgentArg(args []string) []string {
-	out := make([]string, 0, len(args)+1)
-	out = append(out, "cursor-agent")
-	out = append(out, args...)
 	return out
 }
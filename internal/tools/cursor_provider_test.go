@@ -0,0 +1,330 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func newTestCursorProvider(t *testing.T, files map[string]string) (*CursorProvider, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{dir}
+	return NewCursorProvider(cfg, logging.NewWithOutput("error", io.Discard), nil), dir
+}
+
+func TestCursorSearchCodebaseSearchesLocally(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{
+		"main.go":   "package main\n\n// before\nfunc handleRequest() {}\n// after\n",
+		"notes.txt": "handleRequest is documented here\n",
+	})
+
+	res, err := p.searchCodebase(context.Background(), map[string]any{"_cwd": dir, "query": "handleRequest", "file_pattern": "*.go"})
+	if err != nil || !res.Success {
+		t.Fatalf("search failed: %v %s", err, res.Error)
+	}
+	result := res.Result.(map[string]any)
+	results := result["results"].([]SearchResult)
+	if len(results) != 1 {
+		t.Fatalf("results = %+v, want one match in main.go", results)
+	}
+	got := results[0]
+	if filepath.Base(got.File) != "main.go" || got.Line != 4 {
+		t.Fatalf("match = %+v", got)
+	}
+	if strings.Join(got.Context, "|") != "|// before|// after|" {
+		t.Fatalf("context = %q", got.Context)
+	}
+}
+
+func TestCursorApplyCodeChangesAppliesBottomUp(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{"a.txt": "one\ntwo\nthree\nfour\n"})
+	path := filepath.Join(dir, "a.txt")
+	changes := []any{
+		map[string]any{"file": "a.txt", "startLine": float64(1), "endLine": float64(1), "newContent": "ONE\nONE-B"},
+		map[string]any{"file": "a.txt", "startLine": float64(3), "endLine": float64(4), "newContent": "END"},
+	}
+
+	res, err := p.applyCodeChanges(context.Background(), map[string]any{"_cwd": dir, "changes": changes, "dry_run": true})
+	if err != nil || !res.Success {
+		t.Fatalf("dry run failed: %v %s", err, res.Error)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one\ntwo\nthree\nfour\n" {
+		t.Fatalf("dry run modified file: %q", data)
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("TMP", tmp)
+	res, err = p.applyCodeChanges(context.Background(), map[string]any{"_cwd": dir, "changes": changes})
+	if err != nil || !res.Success {
+		t.Fatalf("apply failed: %v %s", err, res.Error)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "ONE\nONE-B\ntwo\nEND\n" {
+		t.Fatalf("file = %q", data)
+	}
+	result := res.Result.(map[string]any)
+	wantDiff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,4 +1,4 @@\n-one\n+ONE\n+ONE-B\n two\n-three\n-four\n+END"
+	if result["diff"] != wantDiff || result["linesAdded"] != 3 || result["linesRemoved"] != 3 {
+		t.Fatalf("diff = %q (+%v -%v)", result["diff"], result["linesAdded"], result["linesRemoved"])
+	}
+	if _, ok := result["backupDir"]; ok {
+		t.Fatalf("expected no backup to be kept after a successful apply, got %v", result["backupDir"])
+	}
+	if leftover, _ := os.ReadDir(tmp); len(leftover) != 0 {
+		t.Fatalf("expected the backup to be removed, found %v", leftover)
+	}
+}
+
+func TestCursorApplyCodeChangesRejectsOverlaps(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+
+	res, err := p.applyCodeChanges(context.Background(), map[string]any{"_cwd": dir, "backup": false, "changes": []any{
+		map[string]any{"file": "a.txt", "startLine": float64(1), "endLine": float64(2), "newContent": "x"},
+		map[string]any{"file": "a.txt", "startLine": float64(2), "endLine": float64(3), "newContent": "y"},
+	}})
+	if err != nil || res.Success || !strings.Contains(res.Error, "overlapping") {
+		t.Fatalf("expected overlap error, got %+v %v", res, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "one\ntwo\nthree\n" {
+		t.Fatalf("file modified: %q", data)
+	}
+}
+
+func TestCursorAnalyzeCodeGoFile(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{
+		"calc.go": "package calc\n\nimport \"fmt\"\n\n// TODO: more ops\ntype Calc struct{}\n\nfunc (c Calc) Sign(n int) string {\n\tif n < 0 {\n\t\treturn \"neg\"\n\t} else if n > 0 {\n\t\treturn \"pos\"\n\t}\n\treturn fmt.Sprint(n)\n}\n",
+	})
+
+	res, err := p.analyzeCode(context.Background(), map[string]any{"_cwd": dir, "file_path": "calc.go"})
+	if err != nil || !res.Success {
+		t.Fatalf("analyze failed: %v %s", err, res.Error)
+	}
+	result := res.Result.(map[string]any)
+	if result["language"] != "go" {
+		t.Fatalf("language = %v", result["language"])
+	}
+	if imports, _ := result["dependencies"].([]string); len(imports) != 1 || imports[0] != "fmt" {
+		t.Fatalf("dependencies = %#v", result["dependencies"])
+	}
+	var sign map[string]any
+	for _, s := range result["structure"].([]map[string]any) {
+		if s["name"] == "Sign" {
+			sign = s
+		}
+	}
+	if sign == nil || sign["receiver"] != "Calc" || sign["complexity"] != 3 {
+		t.Fatalf("Sign symbol = %#v in %#v", sign, result["structure"])
+	}
+	if metrics := result["metrics"].(map[string]any); metrics["todos"] != 1 {
+		t.Fatalf("metrics = %#v", metrics)
+	}
+}
+
+func TestDetectTestRunner(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"go", map[string]string{"go.mod": "module x\n"}, "go"},
+		{"yarn", map[string]string{"package.json": `{"scripts":{"test":"jest"}}`, "yarn.lock": ""}, "yarn"},
+		{"npm placeholder skipped", map[string]string{"package.json": `{"scripts":{"test":"echo \"Error: no test specified\" && exit 1"}}`, "Makefile": "test:\n\tprove\n"}, "make"},
+		{"pytest", map[string]string{"pyproject.toml": "[project]\nname = \"x\"\n"}, "pytest"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, dir := newTestCursorProvider(t, tc.files)
+			runner, err := detectTestRunner(dir, "auto")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runner.name != tc.want {
+				t.Fatalf("runner = %s, want %s", runner.name, tc.want)
+			}
+		})
+	}
+
+	if _, err := detectTestRunner(t.TempDir(), ""); err == nil {
+		t.Fatal("expected an error for a directory without a test runner")
+	}
+}
+
+func TestParseTestResultsPrefersRunnerSummary(t *testing.T) {
+	out := "PASS src/a.test.js (0.5s)\nFAIL src/b.test.js\nTests:       1 failed, 3 passed, 4 total\n"
+	parsed := parseTestResults(out, "")
+	if tests := parsed["tests"].([]map[string]any); len(tests) != 2 || tests[0]["duration"] != 0.5 {
+		t.Fatalf("tests = %#v", tests)
+	}
+	summary := parsed["summary"].(map[string]any)
+	if summary["total"] != 4 || summary["passed"] != 3 || summary["failed"] != 1 {
+		t.Fatalf("summary = %#v", summary)
+	}
+}
+
+func TestCollectProjectInfo(t *testing.T) {
+	_, dir := newTestCursorProvider(t, map[string]string{
+		"go.mod":           "module example.com/demo\n\ngo 1.22\n\nrequire example.com/single v1.0.0\n\nrequire (\n\texample.com/a v1.2.3\n\texample.com/b v0.1.0 // indirect\n)\n",
+		"Makefile":         "build:\n\tgo build ./...\ntest: build\n\tgo test ./...\n",
+		"cmd/demo/main.go": "package main\n",
+		"internal/x/x.go":  "package x\n",
+		".gitignore":       "dist/\n",
+		"dist/out.bin":     "",
+	})
+
+	info := collectProjectInfo(dir, projectInfoOptions{Dependencies: true, Scripts: true, Structure: true, Depth: 2})
+	if info["name"] != "example.com/demo" {
+		t.Fatalf("name = %v", info["name"])
+	}
+	deps := info["dependencies"].(map[string]any)["go"].([]string)
+	want := []string{"example.com/single v1.0.0", "example.com/a v1.2.3", "example.com/b v0.1.0 (indirect)"}
+	if strings.Join(deps, ",") != strings.Join(want, ",") {
+		t.Fatalf("go deps = %q", deps)
+	}
+	if targets := info["scripts"].(map[string]any)["make"].([]string); strings.Join(targets, ",") != "build,test" {
+		t.Fatalf("make targets = %q", targets)
+	}
+	structure := strings.Join(info["structure"].([]string), ",")
+	if !strings.Contains(structure, "cmd/demo/") || strings.Contains(structure, "main.go") || strings.Contains(structure, "dist") {
+		t.Fatalf("structure = %s", structure)
+	}
+}
+
+func TestCollectProjectInfoReadsNodeAndPythonManifests(t *testing.T) {
+	_, dir := newTestCursorProvider(t, map[string]string{
+		"package.json":   `{"name":"web","version":"1.0.0","scripts":{"test":"vitest"},"dependencies":{"react":"^18"}}`,
+		"pyproject.toml": "[project]\nname = \"tool\"\ndependencies = [\n  \"requests>=2\",\n  \"click\",\n]\n\n[project.scripts]\ntool = \"tool.cli:main\"\n",
+		"pnpm-lock.yaml": "",
+		"uv.lock":        "",
+	})
+
+	info := collectProjectInfo(dir, projectInfoOptions{Dependencies: true, Scripts: true})
+	if info["version"] != "1.0.0" || info["name"] != "tool" {
+		t.Fatalf("info = %#v", info)
+	}
+	deps := info["dependencies"].(map[string]any)
+	if py := deps["python"].([]string); strings.Join(py, ",") != "requests>=2,click" {
+		t.Fatalf("python deps = %q", py)
+	}
+	if managers := info["packageManagers"].([]string); strings.Join(managers, ",") != "pnpm,uv" {
+		t.Fatalf("package managers = %q", managers)
+	}
+	if entry := info["scripts"].(map[string]any)["python"].(map[string]string)["tool"]; entry != "tool.cli:main" {
+		t.Fatalf("python scripts = %#v", info["scripts"])
+	}
+	if _, ok := info["structure"]; ok {
+		t.Fatal("structure should be omitted")
+	}
+}
+
+func TestCursorGetProjectInfoStructureDepth(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{"a/b/c/d.txt": "", "top.txt": ""})
+
+	res, err := p.getProjectInfo(context.Background(), map[string]any{"_cwd": dir, "include_structure": true, "structure_depth": float64(3)})
+	if err != nil || !res.Success {
+		t.Fatalf("get_project_info failed: %v %s", err, res.Error)
+	}
+	structure := strings.Join(res.Result.(map[string]any)["structure"].([]string), ",")
+	if structure != "a/,a/b/,a/b/c/,top.txt" {
+		t.Fatalf("structure = %s", structure)
+	}
+}
+
+func TestParseTestResultsReadsPytestAndCargoLines(t *testing.T) {
+	pytest := parseTestResults("tests/test_api.py::TestUser::test_create PASSED [ 50%]\ntests/test_api.py::test_delete FAILED [100%]\n=== 1 failed, 1 passed in 0.12s ===\n", "")
+	tests := pytest["tests"].([]map[string]any)
+	if len(tests) != 2 || tests[0]["suite"] != "TestUser" || tests[0]["test"] != "test_create" || tests[1]["status"] != "fail" {
+		t.Fatalf("pytest tests = %#v", tests)
+	}
+
+	cargo := parseTestResults("test parser::tests::empty ... ok\ntest parser::tests::slow ... ignored\n\ntest result: ok. 1 passed; 0 failed; 1 ignored\n", "")
+	tests = cargo["tests"].([]map[string]any)
+	if len(tests) != 2 || tests[0]["suite"] != "parser::tests" || tests[1]["status"] != "skip" {
+		t.Fatalf("cargo tests = %#v", tests)
+	}
+	if summary := cargo["summary"].(map[string]any); summary["skipped"] != 1 || summary["passed"] != 1 {
+		t.Fatalf("cargo summary = %#v", summary)
+	}
+}
+
+func TestTestProgressSummarizesGoEvents(t *testing.T) {
+	var updates []map[string]any
+	progress := &testProgress{report: func(u map[string]any) { updates = append(updates, u) }, command: "go test -json ./...", goJSON: true}
+	progress.line(`{"Action":"run","Package":"example.com/demo","Test":"TestA"}`)
+	progress.line(`{"Action":"pass","Package":"example.com/demo","Test":"TestA","Elapsed":0.01}`)
+	progress.last = time.Time{}
+	progress.line(`{"Action":"fail","Package":"example.com/demo","Test":"TestB","Elapsed":0.02}`)
+
+	if len(updates) != 2 {
+		t.Fatalf("updates = %d, want 2", len(updates))
+	}
+	text := updates[1]["content"].([]map[string]any)[0]["content"].(acp.ContentBlock).Text
+	want := "$ go test -json ./...\n1 passed, 1 failed, 0 skipped so far\n--- PASS: TestA (0.01s)\n--- FAIL: TestB (0.02s)\n"
+	if text != want {
+		t.Fatalf("progress text = %q", text)
+	}
+}
+
+func TestCursorRunTestsDetectsGoAndStreamsProgress(t *testing.T) {
+	dir := newTestGoModule(t, map[string]string{
+		"demo_test.go": "package demo\n\nimport \"testing\"\n\nfunc TestOK(t *testing.T) {}\n\nfunc TestBad(t *testing.T) { t.Fatal(\"boom\") }\n",
+	})
+	p, _ := newTestCursorProvider(t, nil)
+
+	var updates int
+	res, err := p.runTests(context.Background(), map[string]any{
+		"_cwd":      dir,
+		"_progress": ProgressFunc(func(map[string]any) { updates++ }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || !strings.Contains(res.Error, "exited with status 1") {
+		t.Fatalf("expected failing run, got %+v", res)
+	}
+	result := res.Result.(map[string]any)
+	if result["framework"] != "go" || !strings.HasPrefix(result["command"].(string), "go test -json") {
+		t.Fatalf("result = %#v", result)
+	}
+	failures := result["tests"].([]map[string]any)
+	if len(failures) != 1 || failures[0]["test"] != "TestBad" {
+		t.Fatalf("failures = %#v", failures)
+	}
+	if updates == 0 {
+		t.Fatal("expected progress updates")
+	}
+}
+
+func TestCursorRunTestsRejectsFlagPatterns(t *testing.T) {
+	p, dir := newTestCursorProvider(t, map[string]string{"go.mod": "module x\n"})
+	res, err := p.runTests(context.Background(), map[string]any{"_cwd": dir, "test_pattern": "-exec=/bin/sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || !strings.Contains(res.Error, "must not start with '-'") {
+		t.Fatalf("expected the pattern to be rejected, got %+v", res)
+	}
+	runner, _ := detectTestRunner(dir, "go")
+	if argv := runner.command("TestX", false); !slices.Contains(argv, "-run=TestX") {
+		t.Fatalf("argv = %v", argv)
+	}
+}
@@ -1,26 +1,46 @@
 package tools
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/diff"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
+const (
+	defaultMaxListEntries   = 1000
+	defaultMaxSearchResults = 200
+	searchProgressBatch     = 25
+	maxBatchReadFiles       = 50
+	batchReadConcurrency    = 8
+)
+
 type FilesystemProvider struct {
 	cfg    config.Config
 	logger *logging.Logger
 
 	clientCapabilities map[string]any
 	fsClient           client.FileSystemClient
+	policy             *fspolicy.Policy
 }
 
 func NewFilesystemProvider(cfg config.Config, logger *logging.Logger, clientCapabilities map[string]any, fsClient client.FileSystemClient) *FilesystemProvider {
-	return &FilesystemProvider{cfg: cfg, logger: logger, clientCapabilities: clientCapabilities, fsClient: fsClient}
+	return &FilesystemProvider{
+		cfg:                cfg,
+		logger:             logger,
+		clientCapabilities: clientCapabilities,
+		fsClient:           fsClient,
+		policy:             fspolicy.New(cfg.Tools.Filesystem),
+	}
 }
 
 func (p *FilesystemProvider) Name() string {
@@ -28,7 +48,7 @@ func (p *FilesystemProvider) Name() string {
 }
 
 func (p *FilesystemProvider) Description() string {
-	return "File system operations via ACP client methods (read/write text files)"
+	return "File system operations via ACP client methods (read/write text files) with local directory listing fallback"
 }
 
 func (p *FilesystemProvider) GetTools() []Tool {
@@ -36,13 +56,76 @@ func (p *FilesystemProvider) GetTools() []Tool {
 		return nil
 	}
 
+	tools := []Tool{
+		{
+			Name:        "list_directory",
+			Description: "List the entries of a directory. Optionally recurse into subdirectories and filter by a glob pattern (supports **).",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path":       map[string]any{"type": "string", "description": "Directory to list (absolute, or relative to root or the first allowed path)"},
+					"recursive":  map[string]any{"type": "boolean", "description": "Optional: Include entries of all subdirectories (default false)"},
+					"pattern":    map[string]any{"type": "string", "description": "Optional: Glob pattern to filter entries, e.g. \"*.go\" or \"src/**/*.ts\""},
+					"maxEntries": map[string]any{"type": "number", "description": "Optional: Maximum number of entries to return (default 1000)"},
+					"root":       rootParam,
+				},
+				"required": []string{"path"},
+			},
+			Handler: p.listDirectory,
+		},
+		{
+			Name:        "glob",
+			Description: "Find files whose path matches a glob pattern (supports ** for any number of directories).",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"pattern":    map[string]any{"type": "string", "description": "Glob pattern relative to path, e.g. \"**/*_test.go\""},
+					"path":       map[string]any{"type": "string", "description": "Optional: Directory to search from (default: root or the first allowed path)"},
+					"maxResults": map[string]any{"type": "number", "description": "Optional: Maximum number of matches to return (default 1000)"},
+					"root":       rootParam,
+				},
+				"required": []string{"pattern"},
+			},
+			Handler: p.glob,
+		},
+	}
+
+	tools = append(tools, Tool{
+		Name:        "search_files",
+		Description: "Search file contents with a regular expression across the allowed paths, honoring .gitignore. Returns matching lines with file/line locations.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":          map[string]any{"type": "string", "description": "Regular expression (or literal text with fixed_strings) to search for"},
+				"path":           map[string]any{"type": "string", "description": "Optional: Directory to search (default: root, or all allowed paths and workspace folders)"},
+				"include":        map[string]any{"type": "string", "description": "Optional: Glob pattern restricting which files are searched, e.g. \"**/*.go\""},
+				"case_sensitive": map[string]any{"type": "boolean", "description": "Optional: Case-sensitive matching (default true)"},
+				"fixed_strings":  map[string]any{"type": "boolean", "description": "Optional: Treat query as a literal string (default false)"},
+				"max_results":    map[string]any{"type": "number", "description": "Optional: Maximum number of matching lines to return (default 200)"},
+				"root":           rootParam,
+			},
+			"required": []string{"query"},
+		},
+		Handler: p.searchFiles,
+	}, Tool{
+		Name:        "read_binary_file",
+		Description: "Read a binary file (image, archive, PDF, ...) from the allowed paths and return its contents base64-encoded along with the detected mime type.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "Path to the file (absolute, or relative to the first allowed path)"},
+			},
+			"required": []string{"path"},
+		},
+		Handler: p.readBinaryFile,
+	})
+
 	fsCaps, _ := p.clientCapabilities["fs"].(map[string]any)
 	if fsCaps == nil {
-		p.logger.Warn("Client capabilities not yet initialized - filesystem tools unavailable", nil)
-		return nil
+		p.logger.Warn("Client capabilities not yet initialized - ACP filesystem tools unavailable", nil)
+		return tools
 	}
 
-	tools := make([]Tool, 0)
 	if capabilityBool(fsCaps, "readTextFile") {
 		tools = append(tools, Tool{
 			Name:        "read_file",
@@ -51,12 +134,35 @@ func (p *FilesystemProvider) GetTools() []Tool {
 				"type": "object",
 				"properties": map[string]any{
 					"path":  map[string]any{"type": "string", "description": "Absolute path to the file to read (relative to client workspace)"},
-					"line":  map[string]any{"type": "number", "description": "Optional: Start reading from this line number (1-based)."},
+					"line":  map[string]any{"type": "number", "minimum": 1, "description": "Optional: Start reading from this line number (1-based)."},
 					"limit": map[string]any{"type": "number", "description": "Optional: Maximum number of lines to read."},
 				},
 				"required": []string{"path"},
 			},
 			Handler: p.readFile,
+		}, Tool{
+			Name:        "read_files",
+			Description: "Read several text files in one call (concurrently). Returns their contents concatenated, each preceded by a header line, plus per-file metadata. Files that fail to read are reported individually.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"files": map[string]any{
+						"type":        "array",
+						"description": "Files to read: either path strings or objects with path and optional line/limit",
+						"items": map[string]any{
+							"type": []string{"string", "object"},
+							"properties": map[string]any{
+								"path":  map[string]any{"type": "string", "description": "Path to the file"},
+								"line":  map[string]any{"type": "number", "minimum": 1, "description": "Optional: Start reading from this line number (1-based)."},
+								"limit": map[string]any{"type": "number", "description": "Optional: Maximum number of lines to read."},
+							},
+							"required": []string{"path"},
+						},
+					},
+				},
+				"required": []string{"files"},
+			},
+			Handler: p.readFiles,
 		})
 	}
 	if capabilityBool(fsCaps, "writeTextFile") {
@@ -71,7 +177,29 @@ func (p *FilesystemProvider) GetTools() []Tool {
 				},
 				"required": []string{"path", "content"},
 			},
-			Handler: p.writeFile,
+			Handler:     p.writeFile,
+			Destructive: true,
+		})
+	}
+	if capabilityBool(fsCaps, "readTextFile") && capabilityBool(fsCaps, "writeTextFile") {
+		tools = append(tools, Tool{
+			Name:        "edit_file",
+			Description: "Apply a targeted edit to a text file: replace old_string with new_string, or replace the line range start_line..end_line with new_content. Prefer this over write_file for small changes.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path":        map[string]any{"type": "string", "description": "Absolute path to the file to edit"},
+					"old_string":  map[string]any{"type": "string", "description": "Exact text to replace. Must match exactly once unless replace_all is true."},
+					"new_string":  map[string]any{"type": "string", "description": "Replacement text for old_string"},
+					"replace_all": map[string]any{"type": "boolean", "description": "Optional: Replace every occurrence of old_string (default false)"},
+					"start_line":  map[string]any{"type": "number", "minimum": 1, "description": "First line (1-based) of the range to replace when not using old_string"},
+					"end_line":    map[string]any{"type": "number", "minimum": 1, "description": "Optional: Last line (1-based, inclusive) of the range; defaults to start_line"},
+					"new_content": map[string]any{"type": "string", "description": "Replacement text for the line range (empty string deletes the lines)"},
+				},
+				"required": []string{"path"},
+			},
+			Handler:     p.editFile,
+			Destructive: true,
 		})
 	}
 
@@ -80,7 +208,7 @@ func (p *FilesystemProvider) GetTools() []Tool {
 
 func (p *FilesystemProvider) Cleanup() error { return nil }
 
-func (p *FilesystemProvider) readFile(params map[string]any) (acp.ToolResult, error) {
+func (p *FilesystemProvider) readFile(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	maxRetries := 3
 	retryDelay := 1 * time.Second
 
@@ -89,7 +217,7 @@ func (p *FilesystemProvider) readFile(params map[string]any) (acp.ToolResult, er
 		if attempt > 0 {
 			time.Sleep(retryDelay * time.Duration(attempt))
 		}
-		result, err := p.readFileOnce(params)
+		result, err := p.readFileOnce(ctx, params)
 		if err == nil {
 			return result, nil
 		}
@@ -101,7 +229,7 @@ func (p *FilesystemProvider) readFile(params map[string]any) (acp.ToolResult, er
 	return acp.ToolResult{Success: false, Error: lastErr.Error()}, nil
 }
 
-func (p *FilesystemProvider) readFileOnce(params map[string]any) (acp.ToolResult, error) {
+func (p *FilesystemProvider) readFileOnce(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	sessionID := getString(params, "_sessionId")
 	if sessionID == "" {
 		return acp.ToolResult{}, fmt.Errorf("Session ID is required for ACP file operations. This is an internal error - please report it.")
@@ -110,6 +238,11 @@ func (p *FilesystemProvider) readFileOnce(params map[string]any) (acp.ToolResult
 	if err != nil {
 		return acp.ToolResult{}, err
 	}
+	policy := sessionPolicy(p.policy, params)
+	path, err = policy.ValidateRead(path)
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
 	line, hasLine := intParam(params, "line")
 	if hasLine && line < 1 {
 		return acp.ToolResult{}, fmt.Errorf("Line number must be a positive integer (1-based)")
@@ -119,7 +252,7 @@ func (p *FilesystemProvider) readFileOnce(params map[string]any) (acp.ToolResult
 		return acp.ToolResult{}, fmt.Errorf("Limit must be a positive integer")
 	}
 
-	content, err := p.fsClient.ReadTextFile(client.ReadFileOptions{
+	content, err := p.fsClient.ReadTextFile(ctx, client.ReadFileOptions{
 		SessionID: sessionID,
 		Path:      path,
 		Line:      line,
@@ -128,6 +261,9 @@ func (p *FilesystemProvider) readFileOnce(params map[string]any) (acp.ToolResult
 	if err != nil {
 		return acp.ToolResult{}, err
 	}
+	if err := policy.CheckSize(path, int64(len(content))); err != nil {
+		return acp.ToolResult{}, err
+	}
 
 	meta := map[string]any{
 		"contentLength":          len(content),
@@ -154,7 +290,138 @@ func (p *FilesystemProvider) readFileOnce(params map[string]any) (acp.ToolResult
 	}, nil
 }
 
-func (p *FilesystemProvider) writeFile(params map[string]any) (acp.ToolResult, error) {
+type batchReadResult struct {
+	Path      string `json:"path"`
+	Success   bool   `json:"success"`
+	StartLine int    `json:"startLine,omitempty"`
+	MaxLines  int    `json:"maxLines,omitempty"`
+	LineCount int    `json:"lineCount,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	content string
+}
+
+func (p *FilesystemProvider) readFiles(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	requests, err := batchReadRequests(params["files"])
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	sessionID := getString(params, "_sessionId")
+
+	results := make([]batchReadResult, len(requests))
+	sem := make(chan struct{}, batchReadConcurrency)
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req map[string]any) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			req["_sessionId"] = sessionID
+			req["_workspaceFolders"] = params["_workspaceFolders"]
+			entry := batchReadResult{Path: getString(req, "path"), StartLine: getInt(req, "line", 0), MaxLines: getInt(req, "limit", 0)}
+			result, err := p.readFileOnce(ctx, req)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				payload, _ := result.Result.(map[string]any)
+				entry.Success = true
+				entry.Path, _ = payload["path"].(string)
+				entry.content, _ = payload["content"].(string)
+				entry.LineCount = lineCount(entry.content)
+				entry.Size = len(entry.content)
+			}
+			results[i] = entry
+		}(i, req)
+	}
+	wg.Wait()
+
+	var combined strings.Builder
+	failed := 0
+	for _, r := range results {
+		header := r.Path
+		switch {
+		case r.MaxLines > 0:
+			header = fmt.Sprintf("%s (lines %d-%d)", r.Path, max(r.StartLine, 1), max(r.StartLine, 1)+r.MaxLines-1)
+		case r.StartLine > 0:
+			header = fmt.Sprintf("%s (from line %d)", r.Path, r.StartLine)
+		}
+		if !r.Success {
+			failed++
+			fmt.Fprintf(&combined, "==> %s <==\n[error: %s]\n\n", header, r.Error)
+			continue
+		}
+		fmt.Fprintf(&combined, "==> %s <==\n%s", header, r.content)
+		if !strings.HasSuffix(r.content, "\n") {
+			combined.WriteString("\n")
+		}
+		combined.WriteString("\n")
+	}
+
+	text := combined.String()
+	return acp.ToolResult{
+		Success: failed < len(results),
+		Error:   batchReadError(failed, len(results)),
+		Result: map[string]any{
+			"content": text,
+			"files":   results,
+			"count":   len(results),
+			"failed":  failed,
+		},
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{
+				Type:     "resource",
+				Resource: &acp.EmbeddedResource{URI: "read_files://batch", MimeType: "text/plain", Text: text},
+			}},
+			"source":    "acp-client",
+			"acpMethod": "fs/read_text_file",
+			"sessionId": sessionID,
+		},
+	}, nil
+}
+
+func batchReadRequests(raw any) ([]map[string]any, error) {
+	items, ok := raw.([]any)
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("files is required and must be a non-empty array")
+	}
+	if len(items) > maxBatchReadFiles {
+		return nil, fmt.Errorf("Too many files requested: %d (maximum is %d)", len(items), maxBatchReadFiles)
+	}
+	requests := make([]map[string]any, 0, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			requests = append(requests, map[string]any{"path": v})
+		case map[string]any:
+			req := map[string]any{"path": v["path"]}
+			if line, ok := v["line"]; ok {
+				req["line"] = line
+			}
+			if limit, ok := v["limit"]; ok {
+				req["limit"] = limit
+			}
+			requests = append(requests, req)
+		default:
+			return nil, fmt.Errorf("files[%d] must be a path string or an object with a path", i)
+		}
+	}
+	return requests, nil
+}
+
+func batchReadError(failed int, total int) string {
+	if failed == 0 {
+		return ""
+	}
+	if failed == total {
+		return "Failed to read all requested files"
+	}
+	return fmt.Sprintf("Failed to read %d of %d files", failed, total)
+}
+
+func (p *FilesystemProvider) writeFile(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	maxRetries := 3
 	retryDelay := 1 * time.Second
 
@@ -163,7 +430,7 @@ func (p *FilesystemProvider) writeFile(params map[string]any) (acp.ToolResult, e
 		if attempt > 0 {
 			time.Sleep(retryDelay * time.Duration(attempt))
 		}
-		result, err := p.writeFileOnce(params)
+		result, err := p.writeFileOnce(ctx, params)
 		if err == nil {
 			return result, nil
 		}
@@ -175,7 +442,7 @@ func (p *FilesystemProvider) writeFile(params map[string]any) (acp.ToolResult, e
 	return acp.ToolResult{Success: false, Error: lastErr.Error()}, nil
 }
 
-func (p *FilesystemProvider) writeFileOnce(params map[string]any) (acp.ToolResult, error) {
+func (p *FilesystemProvider) writeFileOnce(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
 	sessionID := getString(params, "_sessionId")
 	if sessionID == "" {
 		return acp.ToolResult{}, fmt.Errorf("Session ID is required for ACP file operations. This is an internal error - please report it.")
@@ -188,8 +455,12 @@ func (p *FilesystemProvider) writeFileOnce(params map[string]any) (acp.ToolResul
 	if err != nil {
 		return acp.ToolResult{}, err
 	}
+	path, err = sessionPolicy(p.policy, params).ValidateWrite(path, len(content))
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
 
-	if err := p.fsClient.WriteTextFile(client.WriteFileOptions{SessionID: sessionID, Path: path, Content: content}); err != nil {
+	if err := p.fsClient.WriteTextFile(ctx, client.WriteFileOptions{SessionID: sessionID, Path: path, Content: content}); err != nil {
 		return acp.ToolResult{}, err
 	}
 
@@ -209,6 +480,355 @@ func (p *FilesystemProvider) writeFileOnce(params map[string]any) (acp.ToolResul
 	}, nil
 }
 
+func (p *FilesystemProvider) editFile(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	result, err := p.editFileOnce(ctx, params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	return result, nil
+}
+
+func (p *FilesystemProvider) editFileOnce(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	sessionID := getString(params, "_sessionId")
+	if sessionID == "" {
+		return acp.ToolResult{}, fmt.Errorf("Session ID is required for ACP file operations. This is an internal error - please report it.")
+	}
+	path, err := nonEmptyStringParam(params, "path")
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	policy := sessionPolicy(p.policy, params)
+	path, err = policy.ValidateRead(path)
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+
+	original, err := p.fsClient.ReadTextFile(ctx, client.ReadFileOptions{SessionID: sessionID, Path: path})
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+
+	var updated string
+	var replacements int
+	var firstLine int
+	if _, ok := params["old_string"]; ok {
+		updated, replacements, firstLine, err = replaceString(original, getString(params, "old_string"), getString(params, "new_string"), getBool(params, "replace_all", false))
+	} else if startLine, ok := intParam(params, "start_line"); ok {
+		endLine, hasEnd := intParam(params, "end_line")
+		if !hasEnd {
+			endLine = startLine
+		}
+		updated, err = replaceLineRange(original, startLine, endLine, getString(params, "new_content"))
+		replacements, firstLine = 1, startLine
+	} else {
+		err = fmt.Errorf("Either old_string/new_string or start_line/new_content is required")
+	}
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	if err := policy.CheckSize(path, int64(len(updated))); err != nil {
+		return acp.ToolResult{}, err
+	}
+
+	if err := p.fsClient.WriteTextFile(ctx, client.WriteFileOptions{SessionID: sessionID, Path: path, Content: updated}); err != nil {
+		return acp.ToolResult{}, err
+	}
+
+	block := acp.DiffBlock(path, &original, updated)
+	added, removed := diff.Stats(original, updated)
+
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"path":         path,
+			"edited":       true,
+			"replacements": replacements,
+			"diff":         diff.Unified(path, &original, updated, p.cfg.Tools.DiffContextLines),
+			"linesAdded":   added,
+			"linesRemoved": removed,
+			"_meta": map[string]any{
+				"previousLineCount": lineCount(original),
+				"lineCount":         lineCount(updated),
+				"source":            "acp-client",
+				"acpMethods":        []string{"fs/read_text_file", "fs/write_text_file"},
+				"sessionId":         sessionID,
+			},
+		},
+		Metadata: map[string]any{
+			"diffs":     []any{block},
+			"locations": []map[string]any{{"path": path, "line": firstLine}},
+		},
+	}, nil
+}
+
+func replaceString(content, oldString, newString string, replaceAll bool) (string, int, int, error) {
+	if oldString == "" {
+		return "", 0, 0, fmt.Errorf("old_string must be a non-empty string")
+	}
+	if oldString == newString {
+		return "", 0, 0, fmt.Errorf("old_string and new_string must differ")
+	}
+	count := strings.Count(content, oldString)
+	if count == 0 {
+		return "", 0, 0, fmt.Errorf("old_string not found in file")
+	}
+	if count > 1 && !replaceAll {
+		return "", 0, 0, fmt.Errorf("old_string must match exactly once but matches %d locations; include more surrounding context or set replace_all", count)
+	}
+	firstLine := strings.Count(content[:strings.Index(content, oldString)], "\n") + 1
+	if replaceAll {
+		return strings.ReplaceAll(content, oldString, newString), count, firstLine, nil
+	}
+	return strings.Replace(content, oldString, newString, 1), 1, firstLine, nil
+}
+
+func replaceLineRange(content string, startLine, endLine int, newContent string) (string, error) {
+	hasTrailingNewline := strings.HasSuffix(content, "\n")
+	lines := strings.Split(content, "\n")
+	if hasTrailingNewline {
+		lines = lines[:len(lines)-1]
+	}
+	if content == "" {
+		lines = nil
+	}
+	if startLine < 1 || startLine > len(lines)+1 {
+		return "", fmt.Errorf("start_line must be between 1 and %d", len(lines)+1)
+	}
+	if endLine < startLine-1 || endLine > len(lines) {
+		return "", fmt.Errorf("end_line must be between %d and %d", startLine-1, len(lines))
+	}
+
+	var replacement []string
+	if newContent != "" {
+		replacement = strings.Split(strings.TrimSuffix(newContent, "\n"), "\n")
+	}
+	out := make([]string, 0, len(lines)-(endLine-startLine+1)+len(replacement))
+	out = append(out, lines[:startLine-1]...)
+	out = append(out, replacement...)
+	out = append(out, lines[endLine:]...)
+
+	result := strings.Join(out, "\n")
+	if hasTrailingNewline || (content == "" && len(out) > 0 && strings.HasSuffix(newContent, "\n")) {
+		result += "\n"
+	}
+	return result, nil
+}
+
+func (p *FilesystemProvider) listDirectory(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := nonEmptyStringParam(params, "path")
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	policy := sessionPolicy(p.policy, params)
+	root, err := workspaceRoot(params, policy)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	resolved, err := policy.ValidateDir(inRoot(root, dir))
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	recursive := getBool(params, "recursive", false)
+	pattern := getString(params, "pattern")
+	maxEntries := getInt(params, "maxEntries", defaultMaxListEntries)
+
+	fsCaps, _ := p.clientCapabilities["fs"].(map[string]any)
+	if capabilityBool(fsCaps, "listDirectory") && p.fsClient != nil {
+		sessionID := getString(params, "_sessionId")
+		if sessionID == "" {
+			return acp.ToolResult{Success: false, Error: "Session ID is required for ACP file operations. This is an internal error - please report it."}, nil
+		}
+		entries, err := p.fsClient.ListDirectory(ctx, client.ListDirectoryOptions{
+			SessionID: sessionID,
+			Path:      resolved,
+			Recursive: recursive,
+			Pattern:   pattern,
+		})
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+		truncated := false
+		if maxEntries > 0 && len(entries) > maxEntries {
+			entries = entries[:maxEntries]
+			truncated = true
+		}
+		return listDirectoryResult(resolved, entries, truncated, "acp-client"), nil
+	}
+
+	entries, truncated, err := listLocalDirectory(resolved, recursive, pattern, maxEntries)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	return listDirectoryResult(resolved, entries, truncated, "local"), nil
+}
+
+func (p *FilesystemProvider) readBinaryFile(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	path, err := nonEmptyStringParam(params, "path")
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	resolved, err := sessionPolicy(p.policy, params).ValidateRead(path)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	data, mimeType, err := readLocalBinaryFile(resolved, p.policy.MaxFileSize())
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"path":     resolved,
+			"mimeType": mimeType,
+			"size":     len(data),
+			"encoding": "base64",
+			"data":     base64.StdEncoding.EncodeToString(data),
+		},
+		Metadata: map[string]any{
+			"path":     resolved,
+			"mimeType": mimeType,
+			"size":     len(data),
+			"source":   "local",
+		},
+	}, nil
+}
+
+func (p *FilesystemProvider) glob(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	pattern := strings.TrimSpace(getString(params, "pattern"))
+	if pattern == "" {
+		return acp.ToolResult{Success: false, Error: "Pattern is required and must be a non-empty string."}, nil
+	}
+	policy := sessionPolicy(p.policy, params)
+	root, err := workspaceRoot(params, policy)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	dir := getString(params, "path")
+	if dir == "" {
+		dir = "."
+	}
+	resolved, err := policy.ValidateDir(inRoot(root, dir))
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	maxResults := getInt(params, "maxResults", defaultMaxListEntries)
+
+	entries, truncated, err := listLocalDirectory(resolved, true, pattern, maxResults)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	matches := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type != "directory" {
+			matches = append(matches, entry.Path)
+		}
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"path":      resolved,
+			"pattern":   pattern,
+			"matches":   matches,
+			"count":     len(matches),
+			"truncated": truncated,
+		},
+	}, nil
+}
+
+func (p *FilesystemProvider) searchFiles(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	query := getString(params, "query")
+	if query == "" {
+		return acp.ToolResult{Success: false, Error: "Query is required and must be a non-empty string."}, nil
+	}
+	pattern, err := compileSearchPattern(query, getBool(params, "fixed_strings", false), getBool(params, "case_sensitive", true))
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid search pattern: %v", err)}, nil
+	}
+
+	policy := sessionPolicy(p.policy, params)
+	root, err := workspaceRoot(params, policy)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	roots := policy.Roots()
+	if dir := getString(params, "path"); dir != "" || root != "" {
+		if dir == "" {
+			dir = root
+		}
+		resolved, err := policy.ValidateDir(inRoot(root, dir))
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+		roots = []string{resolved}
+	}
+
+	matches := make([]searchMatch, 0)
+	locations := make([]map[string]any, 0)
+	var listing strings.Builder
+	flush := func() {
+		reportProgress(params, map[string]any{
+			"content":   []map[string]any{{"type": "content", "content": acp.ContentBlock{Type: "text", Text: listing.String()}}},
+			"locations": locations,
+		})
+	}
+
+	summary, err := searchLocalFiles(roots, searchOptions{
+		Pattern:     pattern,
+		Include:     getString(params, "include"),
+		MaxResults:  getInt(params, "max_results", defaultMaxSearchResults),
+		MaxFileSize: p.policy.MaxFileSize(),
+	}, func(m searchMatch) {
+		matches = append(matches, m)
+		locations = append(locations, map[string]any{"path": m.Path, "line": m.Line})
+		fmt.Fprintf(&listing, "%s:%d: %s\n", m.Path, m.Line, m.Text)
+		if len(matches)%searchProgressBatch == 0 {
+			flush()
+		}
+	})
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	content := make([]any, 0, 1)
+	if listing.Len() > 0 {
+		content = append(content, acp.ContentBlock{Type: "text", Text: listing.String()})
+	}
+	payload := map[string]any{
+		"query":        query,
+		"matches":      matches,
+		"totalMatches": summary.Matches,
+		"filesScanned": summary.FilesScanned,
+		"filesMatched": summary.FilesMatched,
+		"truncated":    summary.Truncated,
+	}
+	if len(summary.Skipped) > 0 {
+		payload["skipped"] = summary.Skipped
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result:  payload,
+		Metadata: map[string]any{
+			"content":   content,
+			"locations": locations,
+		},
+	}, nil
+}
+
+func listDirectoryResult(dir string, entries []client.DirectoryEntry, truncated bool, source string) acp.ToolResult {
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"path":      dir,
+			"entries":   entries,
+			"count":     len(entries),
+			"truncated": truncated,
+			"_meta": map[string]any{
+				"source": source,
+			},
+		},
+	}
+}
+
 func capabilityBool(m map[string]any, key string) bool {
 	v, ok := m[key]
 	if !ok {
@@ -1,36 +1,62 @@
 package tools
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
 	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
 )
 
 type mockFSClient struct {
 	readContent string
+	files       map[string]string
 	readErr     error
 	writeErr    error
 	lastWrite   client.WriteFileOptions
+	listEntries []client.DirectoryEntry
+	lastList    client.ListDirectoryOptions
 }
 
-func (m *mockFSClient) ReadTextFile(options client.ReadFileOptions) (string, error) {
+func (m *mockFSClient) ReadTextFile(_ context.Context, options client.ReadFileOptions) (string, error) {
 	if m.readErr != nil {
 		return "", m.readErr
 	}
+	if m.files != nil {
+		content, ok := m.files[options.Path]
+		if !ok {
+			return "", errors.New("file not found: " + options.Path)
+		}
+		return content, nil
+	}
 	return m.readContent, nil
 }
 
-func (m *mockFSClient) WriteTextFile(options client.WriteFileOptions) error {
+func (m *mockFSClient) WriteTextFile(_ context.Context, options client.WriteFileOptions) error {
 	m.lastWrite = options
 	return m.writeErr
 }
 
+func (m *mockFSClient) ListDirectory(_ context.Context, options client.ListDirectoryOptions) ([]client.DirectoryEntry, error) {
+	m.lastList = options
+	return m.listEntries, nil
+}
+
 func newTestFilesystemProvider(fsClient client.FileSystemClient) *FilesystemProvider {
 	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{"/tmp"}
 	logger := logging.NewWithOutput("error", io.Discard)
 	caps := map[string]any{
 		"fs": map[string]any{
@@ -45,7 +71,7 @@ func TestFilesystemProviderReadFileResultMetaParity(t *testing.T) {
 	mock := &mockFSClient{readContent: ""}
 	provider := newTestFilesystemProvider(mock)
 
-	result, err := provider.readFileOnce(map[string]any{
+	result, err := provider.readFileOnce(context.Background(), map[string]any{
 		"_sessionId": "session-1",
 		"path":       "/tmp/example.txt",
 	})
@@ -85,7 +111,7 @@ func TestFilesystemProviderWriteFilePathMustBeString(t *testing.T) {
 	mock := &mockFSClient{}
 	provider := newTestFilesystemProvider(mock)
 
-	_, err := provider.writeFileOnce(map[string]any{
+	_, err := provider.writeFileOnce(context.Background(), map[string]any{
 		"_sessionId": "session-1",
 		"path":       123,
 		"content":    "hello",
@@ -103,7 +129,7 @@ func TestFilesystemProviderWriteFileContentStringCoercion(t *testing.T) {
 	mock := &mockFSClient{}
 	provider := newTestFilesystemProvider(mock)
 
-	result, err := provider.writeFileOnce(map[string]any{
+	result, err := provider.writeFileOnce(context.Background(), map[string]any{
 		"_sessionId": "session-1",
 		"path":       "/tmp/example.txt",
 		"content":    42,
@@ -123,7 +149,7 @@ func TestFilesystemProviderWriteFileMissingContentErrorMessageParity(t *testing.
 	mock := &mockFSClient{writeErr: errors.New("unused")}
 	provider := newTestFilesystemProvider(mock)
 
-	_, err := provider.writeFileOnce(map[string]any{
+	_, err := provider.writeFileOnce(context.Background(), map[string]any{
 		"_sessionId": "session-1",
 		"path":       "/tmp/example.txt",
 	})
@@ -135,3 +161,421 @@ func TestFilesystemProviderWriteFileMissingContentErrorMessageParity(t *testing.
 		t.Fatalf("expected error %q, got %q", expected, err.Error())
 	}
 }
+
+func newLocalFilesystemProvider(t *testing.T, caps map[string]any) (*FilesystemProvider, string) {
+	t.Helper()
+	root := t.TempDir()
+	for _, rel := range []string{"main.go", "README.md", "pkg/util.go", "pkg/deep/inner_test.go", ".git/HEAD"} {
+		full := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(full, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{root}
+	return NewFilesystemProvider(cfg, logging.NewWithOutput("error", io.Discard), caps, &mockFSClient{}), root
+}
+
+func TestFilesystemProviderListDirectoryLocalFallback(t *testing.T) {
+	provider, root := newLocalFilesystemProvider(t, nil)
+
+	result, err := provider.listDirectory(context.Background(), map[string]any{"path": root, "recursive": true, "pattern": "**/*.go"})
+	if err != nil || !result.Success {
+		t.Fatalf("listDirectory failed: %v %#v", err, result)
+	}
+	payload := result.Result.(map[string]any)
+	entries := payload["entries"].([]client.DirectoryEntry)
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		rel, _ := filepath.Rel(root, e.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"main.go", "pkg/deep/inner_test.go", "pkg/util.go"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	result, _ = provider.listDirectory(context.Background(), map[string]any{"path": root})
+	if n := result.Result.(map[string]any)["count"]; n != 4 {
+		t.Fatalf("expected 4 top-level entries (including .git), got %v", n)
+	}
+}
+
+func TestFilesystemProviderListDirectoryRejectsPathsOutsideAllowedRoots(t *testing.T) {
+	provider, _ := newLocalFilesystemProvider(t, nil)
+
+	result, err := provider.listDirectory(context.Background(), map[string]any{"path": t.TempDir()})
+	if err != nil {
+		t.Fatalf("listDirectory returned error: %v", err)
+	}
+	if result.Success || !strings.Contains(result.Error, "not allowed") {
+		t.Fatalf("expected access denied result, got %#v", result)
+	}
+}
+
+func TestFilesystemProviderListDirectoryUsesClientWhenCapable(t *testing.T) {
+	ws := t.TempDir()
+	mock := &mockFSClient{listEntries: []client.DirectoryEntry{{Name: "a.txt", Path: filepath.Join(ws, "a.txt"), Type: "file"}}}
+	caps := map[string]any{"fs": map[string]any{"listDirectory": true}}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{ws}
+	provider := NewFilesystemProvider(cfg, logging.NewWithOutput("error", io.Discard), caps, mock)
+
+	result, _ := provider.listDirectory(context.Background(), map[string]any{"_sessionId": "session-1", "path": ws, "recursive": true})
+	if !result.Success {
+		t.Fatalf("expected success, got %#v", result)
+	}
+	if mock.lastList.Path != ws || !mock.lastList.Recursive {
+		t.Fatalf("expected request to be forwarded to client, got %#v", mock.lastList)
+	}
+
+	// The client is only asked about directories the policy allows.
+	mock.lastList = client.ListDirectoryOptions{}
+	result, _ = provider.listDirectory(context.Background(), map[string]any{"_sessionId": "session-1", "path": t.TempDir()})
+	if result.Success || !strings.Contains(result.Error, "not allowed") || mock.lastList.Path != "" {
+		t.Fatalf("expected the path to be rejected before the client is asked, got %#v (client saw %#v)", result, mock.lastList)
+	}
+}
+
+func TestFilesystemProviderGlob(t *testing.T) {
+	provider, root := newLocalFilesystemProvider(t, nil)
+
+	result, _ := provider.glob(context.Background(), map[string]any{"pattern": "**/*_test.go"})
+	if !result.Success {
+		t.Fatalf("glob failed: %#v", result)
+	}
+	matches := result.Result.(map[string]any)["matches"].([]string)
+	if len(matches) != 1 || matches[0] != filepath.Join(root, "pkg", "deep", "inner_test.go") {
+		t.Fatalf("unexpected glob matches: %v", matches)
+	}
+}
+
+func TestFilesystemProviderWorkspaceFolders(t *testing.T) {
+	provider, root := newLocalFilesystemProvider(t, nil)
+	workspaces := t.TempDir()
+	provider.policy = fspolicy.New(config.FilesystemConfig{AllowedPaths: []string{root}, AllowWorkspaceFolders: true, WorkspaceFolderRoots: []string{workspaces}})
+	other := filepath.Join(workspaces, "frontend")
+	if err := os.MkdirAll(filepath.Join(other, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "src", "app.ts"), []byte("const needle = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if result, _ := provider.listDirectory(ctx, map[string]any{"path": other}); result.Success {
+		t.Fatalf("expected folder outside allowedPaths to be rejected without workspaceFolders, got %#v", result)
+	}
+
+	params := func(extra map[string]any) map[string]any {
+		out := map[string]any{"_cwd": root, "_workspaceFolders": []string{other}}
+		for k, v := range extra {
+			out[k] = v
+		}
+		return out
+	}
+	result, _ := provider.listDirectory(ctx, params(map[string]any{"path": "src", "root": "frontend"}))
+	if !result.Success || result.Result.(map[string]any)["path"] != filepath.Join(other, "src") {
+		t.Fatalf("expected src to resolve against the named root, got %#v", result)
+	}
+
+	result, _ = provider.glob(ctx, params(map[string]any{"pattern": "**/*.ts", "root": other}))
+	if matches := result.Result.(map[string]any)["matches"].([]string); len(matches) != 1 {
+		t.Fatalf("expected glob in the hinted root to find app.ts, got %#v", result)
+	}
+
+	result, _ = provider.searchFiles(ctx, params(map[string]any{"query": "needle"}))
+	if n := result.Result.(map[string]any)["totalMatches"]; n != 1 {
+		t.Fatalf("expected search without a root to cover the workspace folder, got %#v", result)
+	}
+	result, _ = provider.searchFiles(ctx, params(map[string]any{"query": "needle", "root": filepath.Base(root)}))
+	if n := result.Result.(map[string]any)["totalMatches"]; n != 0 {
+		t.Fatalf("expected search limited to the first root, got %#v", result)
+	}
+
+	result, _ = provider.searchFiles(ctx, params(map[string]any{"query": "needle", "root": "missing"}))
+	if result.Success || !strings.Contains(result.Error, "Unknown workspace root") || !strings.Contains(result.Error, other) {
+		t.Fatalf("expected unknown root error listing the roots, got %#v", result)
+	}
+
+	provider.policy = fspolicy.New(config.FilesystemConfig{AllowedPaths: []string{root}})
+	if result, _ := provider.listDirectory(ctx, params(map[string]any{"path": other})); result.Success {
+		t.Fatalf("expected workspace folders to be ignored when allowWorkspaceFolders is off, got %#v", result)
+	}
+}
+
+func TestFilesystemProviderEditFileSearchReplace(t *testing.T) {
+	mock := &mockFSClient{readContent: "package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n"}
+	provider := newTestFilesystemProvider(mock)
+
+	result, err := provider.editFileOnce(context.Background(), map[string]any{
+		"_sessionId": "session-1",
+		"path":       "/tmp/main.go",
+		"old_string": "println(\"old\")",
+		"new_string": "println(\"new\")",
+	})
+	if err != nil {
+		t.Fatalf("editFileOnce returned error: %v", err)
+	}
+	if mock.lastWrite.Content != "package main\n\nfunc main() {\n\tprintln(\"new\")\n}\n" {
+		t.Fatalf("unexpected written content: %q", mock.lastWrite.Content)
+	}
+	diffs, ok := result.Metadata["diffs"].([]any)
+	if !ok || len(diffs) != 1 {
+		t.Fatalf("expected one diff block in metadata, got %#v", result.Metadata["diffs"])
+	}
+	if diff := diffs[0].(acp.ContentBlock); diff.Type != "diff" || diff.Path != "/tmp/main.go" || *diff.NewText != mock.lastWrite.Content {
+		t.Fatalf("expected a structured diff block, got %#v", diff)
+	}
+	locations := result.Metadata["locations"].([]map[string]any)
+	if locations[0]["line"] != 4 {
+		t.Fatalf("expected edit location at line 4, got %#v", locations)
+	}
+	wantDiff := "--- a/tmp/main.go\n+++ b/tmp/main.go\n@@ -1,5 +1,5 @@\n package main\n \n func main() {\n-\tprintln(\"old\")\n+\tprintln(\"new\")\n }"
+	if got := result.Result.(map[string]any)["diff"]; got != wantDiff {
+		t.Fatalf("unexpected unified diff:\n%s", got)
+	}
+}
+
+func TestFilesystemProviderEditFileRejectsAmbiguousMatch(t *testing.T) {
+	mock := &mockFSClient{readContent: "a\na\n"}
+	provider := newTestFilesystemProvider(mock)
+
+	_, err := provider.editFileOnce(context.Background(), map[string]any{
+		"_sessionId": "session-1",
+		"path":       "/tmp/a.txt",
+		"old_string": "a",
+		"new_string": "b",
+	})
+	if err == nil || !strings.Contains(err.Error(), "matches 2 locations") {
+		t.Fatalf("expected ambiguous match error, got %v", err)
+	}
+	if mock.lastWrite.Path != "" {
+		t.Fatalf("expected no write on failure")
+	}
+}
+
+func TestReplaceLineRange(t *testing.T) {
+	cases := []struct {
+		name       string
+		content    string
+		start, end int
+		newContent string
+		want       string
+	}{
+		{"replace middle", "one\ntwo\nthree\n", 2, 2, "TWO", "one\nTWO\nthree\n"},
+		{"delete lines", "one\ntwo\nthree\n", 1, 2, "", "three\n"},
+		{"insert before", "one\ntwo\n", 2, 1, "between\n", "one\nbetween\ntwo\n"},
+		{"append", "one\n", 2, 1, "two", "one\ntwo\n"},
+		{"no trailing newline", "one\ntwo", 2, 2, "2\n3", "one\n2\n3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := replaceLineRange(tc.content, tc.start, tc.end, tc.newContent)
+			if err != nil {
+				t.Fatalf("replaceLineRange returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := replaceLineRange("one\n", 5, 5, "x"); err == nil {
+		t.Fatalf("expected out-of-range error")
+	}
+}
+
+func TestFilesystemProviderSearchFilesHonorsGitignoreAndStreams(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "vendor/\n",
+		"main.go":             "package main\n\nfunc TODO() {}\n",
+		"pkg/util.go":         "// TODO: tidy\n",
+		"vendor/dep/x.go":     "// TODO vendored\n",
+		"assets/logo.png":     "TODO\x00binary",
+		"docs/notes/todo.txt": "nothing here\n",
+	}
+	for rel, content := range files {
+		full := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{root}
+	provider := NewFilesystemProvider(cfg, logging.NewWithOutput("error", io.Discard), nil, &mockFSClient{})
+
+	var progress []map[string]any
+	result, err := provider.searchFiles(context.Background(), map[string]any{
+		"query":       "TODO",
+		"max_results": 10,
+		"_progress":   ProgressFunc(func(update map[string]any) { progress = append(progress, update) }),
+	})
+	if err != nil || !result.Success {
+		t.Fatalf("searchFiles failed: %v %#v", err, result)
+	}
+	matches := result.Result.(map[string]any)["matches"].([]searchMatch)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches (vendor and binary excluded), got %#v", matches)
+	}
+	if matches[0].Path != filepath.Join(root, "main.go") || matches[0].Line != 3 || matches[0].Column != 6 {
+		t.Fatalf("unexpected first match: %#v", matches[0])
+	}
+	locations := result.Metadata["locations"].([]map[string]any)
+	if len(locations) != 2 || locations[1]["line"] != 1 {
+		t.Fatalf("unexpected locations: %#v", locations)
+	}
+
+	result, _ = provider.searchFiles(context.Background(), map[string]any{
+		"query":          "todo",
+		"case_sensitive": false,
+		"max_results":    1,
+		"_progress":      ProgressFunc(func(update map[string]any) { progress = append(progress, update) }),
+	})
+	payload := result.Result.(map[string]any)
+	if payload["truncated"] != true || payload["totalMatches"] != 1 {
+		t.Fatalf("expected truncated case-insensitive search, got %#v", payload)
+	}
+	if len(progress) != 0 {
+		t.Fatalf("expected no progress updates below batch size, got %d", len(progress))
+	}
+}
+
+func TestFilesystemProviderSearchFilesReportsFilesWithOverlongLines(t *testing.T) {
+	root := t.TempDir()
+	minified := "TODO first\n" + strings.Repeat("x", 5*1024*1024) + "\nTODO after the long line\n"
+	if err := os.WriteFile(filepath.Join(root, "bundle.js"), []byte(minified), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{root}
+	cfg.Tools.Filesystem.MaxFileSize = 0
+	provider := NewFilesystemProvider(cfg, logging.NewWithOutput("error", io.Discard), nil, &mockFSClient{})
+
+	result, err := provider.searchFiles(context.Background(), map[string]any{"query": "TODO"})
+	if err != nil || !result.Success {
+		t.Fatalf("searchFiles failed: %v %#v", err, result)
+	}
+	payload := result.Result.(map[string]any)
+	skipped, _ := payload["skipped"].([]searchSkip)
+	if payload["totalMatches"] != 1 || len(skipped) != 1 || skipped[0].Path != filepath.Join(root, "bundle.js") || !strings.Contains(skipped[0].Reason, "line 2") {
+		t.Fatalf("expected the file to be reported as cut short, got %#v", payload)
+	}
+}
+
+func TestFilesystemProviderRejectsPathsOutsidePolicy(t *testing.T) {
+	mock := &mockFSClient{}
+	provider := newTestFilesystemProvider(mock)
+
+	if _, err := provider.readFileOnce(context.Background(), map[string]any{"_sessionId": "s1", "path": "/tmp/../etc/passwd"}); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("expected traversal outside allowed paths to be rejected, got %v", err)
+	}
+	if _, err := provider.writeFileOnce(context.Background(), map[string]any{"_sessionId": "s1", "path": "/etc/hosts", "content": "x"}); err == nil {
+		t.Fatalf("expected write outside allowed paths to be rejected")
+	}
+	if mock.lastWrite.Path != "" {
+		t.Fatalf("expected client not to be called, got write to %q", mock.lastWrite.Path)
+	}
+}
+
+func TestFilesystemProviderReadBinaryFile(t *testing.T) {
+	provider, root := newLocalFilesystemProvider(t, nil)
+	png := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0x01}
+	if err := os.WriteFile(filepath.Join(root, "logo.png"), png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := provider.readBinaryFile(context.Background(), map[string]any{"path": "logo.png"})
+	if err != nil || !result.Success {
+		t.Fatalf("readBinaryFile failed: %v %#v", err, result)
+	}
+	payload := result.Result.(map[string]any)
+	if payload["mimeType"] != "image/png" {
+		t.Fatalf("expected image/png, got %v", payload["mimeType"])
+	}
+	decoded, err := base64.StdEncoding.DecodeString(payload["data"].(string))
+	if err != nil || !bytes.Equal(decoded, png) {
+		t.Fatalf("expected round-tripped bytes, got %v %v", decoded, err)
+	}
+
+	provider.policy = fspolicy.New(config.FilesystemConfig{AllowedPaths: []string{root}, MaxFileSize: 4})
+	result, _ = provider.readBinaryFile(context.Background(), map[string]any{"path": "logo.png"})
+	if result.Success || !strings.Contains(result.Error, "maxFileSize") {
+		t.Fatalf("expected oversize file to be rejected, got %#v", result)
+	}
+}
+
+func TestFilesystemProviderReadFilesBatch(t *testing.T) {
+	mock := &mockFSClient{files: map[string]string{
+		"/tmp/a.go": "package a\n",
+		"/tmp/b.go": "package b",
+	}}
+	provider := newTestFilesystemProvider(mock)
+
+	result, err := provider.readFiles(context.Background(), map[string]any{
+		"_sessionId": "s1",
+		"files":      []any{"/tmp/a.go", map[string]any{"path": "/tmp/b.go", "line": float64(1)}, "/tmp/missing.go"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Success || !strings.Contains(result.Error, "1 of 3") {
+		t.Fatalf("expected partial success, got %#v", result)
+	}
+	payload := result.Result.(map[string]any)
+	files := payload["files"].([]batchReadResult)
+	if files[0].Path != "/tmp/a.go" || !files[0].Success || files[2].Success {
+		t.Fatalf("unexpected per-file results: %#v", files)
+	}
+	content := payload["content"].(string)
+	aIdx := strings.Index(content, "==> /tmp/a.go <==\npackage a\n")
+	bIdx := strings.Index(content, "==> /tmp/b.go (from line 1) <==\npackage b\n")
+	if aIdx < 0 || bIdx < aIdx || !strings.Contains(content, "[error: file not found") {
+		t.Fatalf("unexpected combined content:\n%s", content)
+	}
+
+	if result, _ := provider.readFiles(context.Background(), map[string]any{"_sessionId": "s1", "files": []any{}}); result.Success {
+		t.Fatalf("expected empty file list to be rejected")
+	}
+}
+
+func TestReadFilesAcceptsPathStringsThroughTheRegistry(t *testing.T) {
+	mock := &mockFSClient{files: map[string]string{"/tmp/a.go": "package a\n", "/tmp/b.go": "package b\n"}}
+	r := newTestRegistry()
+	r.RegisterProvider(newTestFilesystemProvider(mock))
+
+	call := ToolCall{Name: "read_files", Parameters: map[string]any{"files": []any{"/tmp/a.go", map[string]any{"path": "/tmp/b.go"}}}}
+	result, _ := r.ExecuteToolWithSession(context.Background(), call, "s1")
+	if !result.Success || !strings.Contains(result.Result.(map[string]any)["content"].(string), "==> /tmp/a.go <==") {
+		t.Fatalf("expected string and object items to be read, got %#v", result)
+	}
+	call.Parameters = map[string]any{"files": []any{float64(1)}}
+	if result, _ := r.ExecuteToolWithSession(context.Background(), call, "s1"); result.Success || !strings.Contains(result.Error, "/files/0") {
+		t.Fatalf("expected a number item to be rejected, got %#v", result)
+	}
+}
+
+func TestSearchFileCutsLongLinesAtRuneBoundaries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wide.txt")
+	line := "needle" + strings.Repeat("é", maxSearchLineLength)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var matches []searchMatch
+	if _, err := searchFile(path, regexp.MustCompile("needle"), func(m searchMatch) bool {
+		matches = append(matches, m)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || !utf8.ValidString(matches[0].Text) || !strings.HasSuffix(matches[0].Text, "…") {
+		t.Fatalf("unexpected matches %q", matches)
+	}
+}
@@ -0,0 +1,553 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	gitCommandTimeout  = 30 * time.Second
+	defaultGitLogCount = 20
+	maxGitLogCount     = 500
+)
+
+type GitProvider struct {
+	cfg    config.Config
+	logger *logging.Logger
+	policy *fspolicy.Policy
+}
+
+func NewGitProvider(cfg config.Config, logger *logging.Logger) *GitProvider {
+	return &GitProvider{cfg: cfg, logger: logger, policy: fspolicy.New(cfg.Tools.Filesystem)}
+}
+
+func (p *GitProvider) Name() string {
+	return "git"
+}
+
+func (p *GitProvider) Description() string {
+	return "Git repository inspection (status, diff, log, blame) and permission-gated commits in the session working directory"
+}
+
+func (p *GitProvider) GetTools() []Tool {
+	if !p.cfg.Tools.Git.Enabled {
+		return nil
+	}
+
+	return []Tool{
+		{
+			Name:        "git_status",
+			Description: "Show the current branch and the staged, unstaged and untracked files of the repository.",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{},
+			},
+			Handler: p.status,
+		},
+		{
+			Name:        "git_diff",
+			Description: "Show changes as a unified diff: working tree vs index by default, staged changes, or against a ref.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"staged": map[string]any{"type": "boolean", "description": "Optional: Show staged changes (git diff --cached)"},
+					"ref":    map[string]any{"type": "string", "description": "Optional: Commit, branch or range to diff against, e.g. \"HEAD~1\" or \"main...HEAD\""},
+					"path":   map[string]any{"type": "string", "description": "Optional: Limit the diff to this file or directory"},
+				},
+			},
+			Handler: p.diff,
+		},
+		{
+			Name:        "git_log",
+			Description: "List recent commits (hash, author, date, subject), optionally limited to a path.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"max_count": map[string]any{"type": "number", "description": "Optional: Number of commits to return (default 20)"},
+					"ref":       map[string]any{"type": "string", "description": "Optional: Branch or commit to start from (default HEAD)"},
+					"path":      map[string]any{"type": "string", "description": "Optional: Only commits touching this path"},
+				},
+			},
+			Handler: p.log,
+		},
+		{
+			Name:        "git_blame",
+			Description: "Show which commit and author last changed each line of a file.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path":       map[string]any{"type": "string", "description": "File to blame"},
+					"start_line": map[string]any{"type": "number", "minimum": 1, "description": "Optional: First line (1-based)"},
+					"end_line":   map[string]any{"type": "number", "minimum": 1, "description": "Optional: Last line (inclusive)"},
+				},
+				"required": []string{"path"},
+			},
+			Handler: p.blame,
+		},
+		{
+			Name:        "git_commit",
+			Description: "Create a commit with the given message. Optionally stage specific files or all tracked changes first. Requires user permission.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"message": map[string]any{"type": "string", "description": "Commit message"},
+					"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Optional: Files to stage before committing"},
+					"all":     map[string]any{"type": "boolean", "description": "Optional: Stage all modified tracked files (git commit -a)"},
+				},
+				"required": []string{"message"},
+			},
+			Handler:            p.commit,
+			RequiresPermission: true,
+		},
+	}
+}
+
+func (p *GitProvider) Cleanup() error { return nil }
+
+func (p *GitProvider) status(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	out, err := runGit(ctx, dir, processEnv(p.cfg), "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	branch := ""
+	files := make([]map[string]any, 0)
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "## ") {
+			branch = strings.TrimPrefix(line, "## ")
+			continue
+		}
+		if len(line) < 4 {
+			continue
+		}
+		files = append(files, map[string]any{
+			"path":     line[3:],
+			"index":    strings.TrimSpace(line[:1]),
+			"worktree": strings.TrimSpace(line[1:2]),
+		})
+	}
+
+	text := out
+	if len(files) == 0 {
+		text += "nothing to commit, working tree clean\n"
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":    dir,
+			"branch": branch,
+			"files":  files,
+			"clean":  len(files) == 0,
+		},
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{Type: "text", Text: text}},
+		},
+	}, nil
+}
+
+func (p *GitProvider) diff(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	if getBool(params, "staged", false) {
+		args = append(args, "--cached")
+	}
+	if ref := getString(params, "ref"); ref != "" {
+		if strings.HasPrefix(ref, "-") {
+			return acp.ToolResult{Success: false, Error: "Invalid ref: " + ref}, nil
+		}
+		args = append(args, ref)
+	}
+	if path := getString(params, "path"); path != "" {
+		resolved, err := p.pathspec(params, path)
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+		args = append(args, "--", resolved)
+	}
+	out, err := runGit(ctx, dir, processEnv(p.cfg), args...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	files := splitDiffByFile(out)
+	diffs := make([]any, 0, len(files))
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.path)
+		diffs = append(diffs, acp.ContentBlock{
+			Type: "resource",
+			Resource: &acp.EmbeddedResource{
+				URI:      "diff://" + f.path,
+				MimeType: "text/x-diff",
+				Text:     f.text,
+			},
+			Annotations: map[string]any{"_meta": map[string]any{"diffType": "unified", "originalPath": f.path}},
+		})
+	}
+
+	result := acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":   dir,
+			"diff":  out,
+			"files": paths,
+			"empty": out == "",
+		},
+		Metadata: map[string]any{},
+	}
+	if len(diffs) > 0 {
+		result.Metadata["diffs"] = diffs
+	} else {
+		result.Metadata["content"] = []any{acp.ContentBlock{Type: "text", Text: "No changes"}}
+	}
+	return result, nil
+}
+
+func (p *GitProvider) log(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	count := getInt(params, "max_count", defaultGitLogCount)
+	if count < 1 {
+		count = defaultGitLogCount
+	}
+	if count > maxGitLogCount {
+		count = maxGitLogCount
+	}
+	args := []string{"log", "--no-color", "-n", strconv.Itoa(count), "--format=%H%x1f%an%x1f%ae%x1f%aI%x1f%s%x1e"}
+	if ref := getString(params, "ref"); ref != "" {
+		if strings.HasPrefix(ref, "-") {
+			return acp.ToolResult{Success: false, Error: "Invalid ref: " + ref}, nil
+		}
+		args = append(args, ref)
+	}
+	if path := getString(params, "path"); path != "" {
+		resolved, err := p.pathspec(params, path)
+		if err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+		args = append(args, "--", resolved)
+	}
+	out, err := runGit(ctx, dir, processEnv(p.cfg), args...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	commits := make([]map[string]any, 0)
+	var listing strings.Builder
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		commits = append(commits, map[string]any{
+			"hash":    fields[0],
+			"author":  fields[1],
+			"email":   fields[2],
+			"date":    fields[3],
+			"subject": fields[4],
+		})
+		fmt.Fprintf(&listing, "%s %s %s: %s\n", shortHash(fields[0]), fields[3], fields[1], fields[4])
+	}
+
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":     dir,
+			"commits": commits,
+			"count":   len(commits),
+		},
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{Type: "text", Text: listing.String()}},
+		},
+	}, nil
+}
+
+func (p *GitProvider) blame(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	path, err := nonEmptyStringParam(params, "path")
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	if path, err = sessionPolicy(p.policy, params).ValidateRead(path); err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	args := []string{"blame", "--porcelain"}
+	start, hasStart := intParam(params, "start_line")
+	end, hasEnd := intParam(params, "end_line")
+	if hasStart || hasEnd {
+		if !hasStart {
+			start = 1
+		}
+		if start < 1 || (hasEnd && end < start) {
+			return acp.ToolResult{Success: false, Error: "Invalid line range: start_line must be >= 1 and end_line >= start_line"}, nil
+		}
+		rng := strconv.Itoa(start) + ","
+		if hasEnd {
+			rng += strconv.Itoa(end)
+		}
+		args = append(args, "-L", rng)
+	}
+	args = append(args, "--", path)
+	out, err := runGit(ctx, dir, processEnv(p.cfg), args...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	lines := parseBlamePorcelain(out)
+	var listing strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&listing, "%s (%s) %d: %s\n", shortHash(l.Commit), l.Author, l.Line, l.Text)
+	}
+	locations := []map[string]any{{"path": path}}
+	if len(lines) > 0 {
+		locations[0]["line"] = lines[0].Line
+	}
+
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":   dir,
+			"path":  path,
+			"lines": lines,
+		},
+		Metadata: map[string]any{
+			"content":   []any{acp.ContentBlock{Type: "text", Text: listing.String()}},
+			"locations": locations,
+		},
+	}, nil
+}
+
+func (p *GitProvider) commit(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	message := strings.TrimSpace(getString(params, "message"))
+	if message == "" {
+		return acp.ToolResult{Success: false, Error: "Commit message is required and must be a non-empty string."}, nil
+	}
+
+	if raw, ok := params["files"].([]any); ok && len(raw) > 0 {
+		args := []string{"add", "--"}
+		for _, f := range raw {
+			name, ok := f.(string)
+			if !ok || strings.TrimSpace(name) == "" {
+				return acp.ToolResult{Success: false, Error: "files must be an array of non-empty path strings"}, nil
+			}
+			resolved, err := p.pathspec(params, name)
+			if err != nil {
+				return acp.ToolResult{Success: false, Error: err.Error()}, nil
+			}
+			args = append(args, resolved)
+		}
+		if _, err := runGit(ctx, dir, processEnv(p.cfg), args...); err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error()}, nil
+		}
+	}
+
+	args := []string{"commit", "-m", message}
+	if getBool(params, "all", false) {
+		args = append(args, "-a")
+	}
+	out, err := runGit(ctx, dir, processEnv(p.cfg), args...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	hash, err := runGit(ctx, dir, processEnv(p.cfg), "rev-parse", "HEAD")
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	hash = strings.TrimSpace(hash)
+
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":     dir,
+			"commit":  hash,
+			"message": message,
+			"output":  out,
+		},
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{Type: "text", Text: out}},
+		},
+	}, nil
+}
+
+// workDir returns the session working directory (injected by the registry as
+// _cwd), falling back to the first allowed path.
+func (p *GitProvider) workDir(params map[string]any) (string, error) {
+	dir := getString(params, "_cwd")
+	if dir == "" {
+		roots := p.policy.Roots()
+		if len(roots) == 0 {
+			return "", fmt.Errorf("No working directory available for git commands")
+		}
+		dir = roots[0]
+	}
+	return dir, nil
+}
+
+// pathspec checks a path argument against the filesystem policy, relative
+// to the session working directory, and returns it resolved so that git
+// cannot be pointed outside the allowed paths.
+func (p *GitProvider) pathspec(params map[string]any, path string) (string, error) {
+	return sessionPolicy(p.policy, params).Resolve(path)
+}
+
+// runGit runs git in dir with env (KEY=VALUE pairs) as its environment.
+func runGit(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(slices.Clip(env), "GIT_TERMINAL_PROMPT=0", "GIT_PAGER=cat", "LC_ALL=C")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("git %s timed out after %s", args[0], gitCommandTimeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = strings.TrimSpace(stdout.String())
+			}
+			return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+type fileDiff struct {
+	path string
+	text string
+}
+
+func splitDiffByFile(out string) []fileDiff {
+	files := make([]fileDiff, 0)
+	if strings.TrimSpace(out) == "" {
+		return files
+	}
+	chunks := strings.Split(out, "\ndiff --git ")
+	for i, chunk := range chunks {
+		if i > 0 {
+			chunk = "diff --git " + chunk
+		}
+		if !strings.HasSuffix(chunk, "\n") {
+			chunk += "\n"
+		}
+		files = append(files, fileDiff{path: diffChunkPath(chunk), text: chunk})
+	}
+	return files
+}
+
+func diffChunkPath(chunk string) string {
+	for _, line := range strings.Split(chunk, "\n") {
+		if strings.HasPrefix(line, "+++ ") && line != "+++ /dev/null" {
+			return strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		}
+		if strings.HasPrefix(line, "--- ") && line != "--- /dev/null" {
+			return strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		}
+	}
+	header := strings.SplitN(chunk, "\n", 2)[0]
+	if idx := strings.LastIndex(header, " b/"); idx >= 0 {
+		return header[idx+3:]
+	}
+	return "unknown"
+}
+
+type blameLine struct {
+	Line    int    `json:"line"`
+	Commit  string `json:"commit"`
+	Author  string `json:"author"`
+	Date    string `json:"date,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	Text    string `json:"text"`
+}
+
+// parseBlamePorcelain parses `git blame --porcelain`. Commit details are only
+// printed the first time a commit appears, so they are cached by hash.
+func parseBlamePorcelain(out string) []blameLine {
+	type commitInfo struct{ author, date, summary string }
+	commits := map[string]*commitInfo{}
+	lines := make([]blameLine, 0)
+	var current *blameLine
+
+	for _, raw := range strings.Split(out, "\n") {
+		if strings.HasPrefix(raw, "\t") {
+			if current != nil {
+				info := commits[current.Commit]
+				if info != nil {
+					current.Author, current.Date, current.Summary = info.author, info.date, info.summary
+				}
+				current.Text = raw[1:]
+				lines = append(lines, *current)
+				current = nil
+			}
+			continue
+		}
+		fields := strings.Fields(raw)
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			lineNo, _ := strconv.Atoi(fields[2])
+			current = &blameLine{Commit: fields[0], Line: lineNo}
+			if commits[fields[0]] == nil {
+				commits[fields[0]] = &commitInfo{}
+			}
+			continue
+		}
+		if current == nil || len(fields) == 0 {
+			continue
+		}
+		info := commits[current.Commit]
+		value := strings.TrimSpace(strings.TrimPrefix(raw, fields[0]))
+		switch fields[0] {
+		case "author":
+			info.author = value
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				info.date = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+			}
+		case "summary":
+			info.summary = value
+		}
+	}
+	return lines
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
@@ -0,0 +1,163 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/permissions"
+	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
+)
+
+func newTestGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "Test User"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "commit.gpgsign", "false"},
+	} {
+		if _, err := runGit(context.Background(), dir, os.Environ(), args...); err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(context.Background(), dir, os.Environ(), "add", "main.go"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(context.Background(), dir, os.Environ(), "commit", "-q", "-m", "initial commit"); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTestGitProvider() *GitProvider {
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{os.TempDir()}
+	return NewGitProvider(cfg, logging.NewWithOutput("error", io.Discard))
+}
+
+func TestGitProviderStatusDiffLogBlame(t *testing.T) {
+	dir := newTestGitRepo(t)
+	provider := newTestGitProvider()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { println(1) }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := provider.status(context.Background(), map[string]any{"_cwd": dir})
+	if !status.Success {
+		t.Fatalf("git_status failed: %s", status.Error)
+	}
+	files := status.Result.(map[string]any)["files"].([]map[string]any)
+	if len(files) != 2 || files[0]["path"] != "main.go" || files[0]["worktree"] != "M" || files[1]["index"] != "?" {
+		t.Fatalf("unexpected status entries: %#v", files)
+	}
+
+	diff, _ := provider.diff(context.Background(), map[string]any{"_cwd": dir})
+	if !diff.Success {
+		t.Fatalf("git_diff failed: %s", diff.Error)
+	}
+	blocks := diff.Metadata["diffs"].([]any)
+	block := blocks[0].(acp.ContentBlock)
+	if len(blocks) != 1 || block.Resource.URI != "diff://main.go" || !strings.Contains(block.Resource.Text, "+func main() { println(1) }") {
+		t.Fatalf("unexpected diff blocks: %#v", blocks)
+	}
+
+	logResult, _ := provider.log(context.Background(), map[string]any{"_cwd": dir})
+	commits := logResult.Result.(map[string]any)["commits"].([]map[string]any)
+	if len(commits) != 1 || commits[0]["subject"] != "initial commit" || commits[0]["author"] != "Test User" {
+		t.Fatalf("unexpected log: %#v", commits)
+	}
+
+	blame, _ := provider.blame(context.Background(), map[string]any{"_cwd": dir, "path": "main.go", "start_line": float64(1), "end_line": float64(2)})
+	if !blame.Success {
+		t.Fatalf("git_blame failed: %s", blame.Error)
+	}
+	lines := blame.Result.(map[string]any)["lines"].([]blameLine)
+	if len(lines) != 2 || lines[0].Text != "package main" || lines[1].Author != "Test User" || lines[1].Summary != "initial commit" {
+		t.Fatalf("unexpected blame: %#v", lines)
+	}
+}
+
+func TestGitPathArgumentsFollowTheFilesystemPolicy(t *testing.T) {
+	dir := newTestGitRepo(t)
+	provider := newTestGitProvider()
+	outside, _ := filepath.Abs(".")
+
+	checks := map[string]func(context.Context, map[string]any) (acp.ToolResult, error){
+		"git_diff":  provider.diff,
+		"git_log":   provider.log,
+		"git_blame": provider.blame,
+	}
+	for name, run := range checks {
+		result, _ := run(context.Background(), map[string]any{"_cwd": dir, "path": filepath.Join(outside, "git_provider.go")})
+		if result.Success || !strings.Contains(result.Error, "not allowed") {
+			t.Fatalf("%s: expected the path to be refused, got %#v", name, result)
+		}
+	}
+	result, _ := provider.commit(context.Background(), map[string]any{"_cwd": dir, "message": "escape", "files": []any{filepath.Join(outside, "git_provider.go")}})
+	if result.Success || !strings.Contains(result.Error, "not allowed") {
+		t.Fatalf("expected git_commit to refuse the file, got %#v", result)
+	}
+}
+
+func TestGitCommitRequiresPermission(t *testing.T) {
+	dir := newTestGitRepo(t)
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Tools.Cursor.Enabled = false
+	cfg.Tools.Filesystem.AllowedPaths = []string{dir}
+	logger := logging.NewWithOutput("error", io.Discard)
+	allow := false
+	asked := 0
+	registry := NewRegistry(cfg, logger, nil)
+	registry.SetSessionCwdResolver(func(string) string { return dir })
+	registry.SetToolCallManager(toolcall.NewManager(logger, func(map[string]any) {}, func(params permissions.RequestPermissionParams) permissions.PermissionOutcome {
+		asked++
+		if allow {
+			return permissions.PermissionOutcome{Outcome: "selected", OptionID: "allow-once"}
+		}
+		return permissions.PermissionOutcome{Outcome: "selected", OptionID: "reject-once"}
+	}))
+	call := ToolCall{Name: "git_commit", Parameters: map[string]any{"message": "add notes", "files": []any{"notes.txt"}}}
+
+	result, _ := registry.ExecuteToolWithSession(context.Background(), call, "s1")
+	if result.Success || !strings.Contains(result.Error, "Permission denied") || asked != 1 {
+		t.Fatalf("expected rejected commit, got %#v (asked %d)", result, asked)
+	}
+	if out, _ := runGit(context.Background(), dir, os.Environ(), "log", "--format=%s"); strings.Contains(out, "add notes") {
+		t.Fatalf("commit should not have been created")
+	}
+
+	allow = true
+	result, _ = registry.ExecuteToolWithSession(context.Background(), call, "s1")
+	if !result.Success {
+		t.Fatalf("expected approved commit to succeed, got %s", result.Error)
+	}
+	if out, _ := runGit(context.Background(), dir, os.Environ(), "log", "-1", "--format=%s"); strings.TrimSpace(out) != "add notes" {
+		t.Fatalf("expected new commit, got %q", out)
+	}
+
+	if result, _ := registry.ExecuteTool(context.Background(), call); result.Success {
+		t.Fatalf("expected sessionless commit to be refused")
+	}
+}
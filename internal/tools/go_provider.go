@@ -0,0 +1,493 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	// maxGoTestOutput caps the output kept for one failed test.
+	maxGoTestOutput = 8 << 10
+	// maxGoOutputText caps the raw toolchain output returned to the agent.
+	maxGoOutputText = 64 << 10
+)
+
+// goDiagnostic matches compiler and vet findings such as
+// "./main.go:12:5: undefined: x" or "pkg/a.go:3: unreachable code".
+var goDiagnostic = regexp.MustCompile(`^(?:vet: )?(\S+\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+type GoProvider struct {
+	cfg    config.Config
+	logger *logging.Logger
+	policy *fspolicy.Policy
+}
+
+func NewGoProvider(cfg config.Config, logger *logging.Logger) *GoProvider {
+	return &GoProvider{cfg: cfg, logger: logger, policy: fspolicy.New(cfg.Tools.Filesystem)}
+}
+
+func (p *GoProvider) Name() string {
+	return "go"
+}
+
+func (p *GoProvider) Description() string {
+	return "Go toolchain tools (build, vet, test, package listing) with compiler and test output parsed into diagnostics"
+}
+
+func (p *GoProvider) GetTools() []Tool {
+	if !p.cfg.Tools.Go.Enabled {
+		return nil
+	}
+
+	packages := map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Optional: Package patterns (default [\"./...\"])"}
+	tags := map[string]any{"type": "string", "description": "Optional: Comma-separated build tags"}
+	return []Tool{
+		{
+			Name:        "go_build",
+			Description: "Compile Go packages in the working directory without writing binaries and report compile errors as file/line diagnostics.",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"packages": packages, "tags": tags},
+			},
+			Handler: p.build,
+		},
+		{
+			Name:        "go_vet",
+			Description: "Run go vet on Go packages and report its findings as file/line diagnostics.",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"packages": packages, "tags": tags},
+			},
+			Handler: p.vet,
+		},
+		{
+			Name:        "go_test",
+			Description: "Run Go tests and report the result of each package plus the output of every failed test.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"packages": packages,
+					"tags":     tags,
+					"run":      map[string]any{"type": "string", "description": "Optional: Only run tests matching this regular expression (go test -run)"},
+					"short":    map[string]any{"type": "boolean", "description": "Optional: Pass -short"},
+					"race":     map[string]any{"type": "boolean", "description": "Optional: Enable the race detector"},
+				},
+			},
+			Handler: p.test,
+			// Tests run arbitrary code from the workspace, so they go through
+			// the same approval as terminal commands.
+			RequiresPermission: true,
+			Kind:               "execute",
+		},
+		{
+			Name:        "list_packages",
+			Description: "List the Go packages matching a pattern with their import path, directory, files and load errors.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"pattern": map[string]any{"type": "string", "description": "Optional: Package pattern (default \"./...\")"},
+				},
+			},
+			Handler: p.listPackages,
+		},
+	}
+}
+
+func (p *GoProvider) Cleanup() error { return nil }
+
+func (p *GoProvider) build(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	return p.check(ctx, params, "build", "-o", goDevNull())
+}
+
+func (p *GoProvider) vet(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	return p.check(ctx, params, "vet")
+}
+
+// check runs go build or go vet, whose findings share the compiler's
+// "file:line:col: message" format. A failing build is a successful tool call
+// with ok=false, so the diagnostics reach the client.
+func (p *GoProvider) check(ctx context.Context, params map[string]any, args ...string) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	pkgs, err := goPackages(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	args = append(args, goTagArgs(params)...)
+	out, ok, err := p.runGo(ctx, dir, append(args, pkgs...)...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	diagnostics := parseGoDiagnostics(dir, out)
+	text := fmt.Sprintf("go %s: ok", args[0])
+	if !ok {
+		text = fmt.Sprintf("go %s: %d problem(s)\n\n%s", args[0], len(diagnostics), truncateText(out, maxGoOutputText))
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":         dir,
+			"packages":    pkgs,
+			"ok":          ok,
+			"diagnostics": diagnostics,
+			"output":      truncateText(out, maxGoOutputText),
+		},
+		Metadata: map[string]any{
+			"content":   []any{acp.ContentBlock{Type: "text", Text: text}},
+			"locations": diagnosticLocations(diagnostics),
+		},
+	}, nil
+}
+
+func (p *GoProvider) test(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	pkgs, err := goPackages(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	args := append([]string{"test", "-json"}, goTagArgs(params)...)
+	if run := getString(params, "run"); run != "" {
+		args = append(args, "-run", run)
+	}
+	if getBool(params, "short", false) {
+		args = append(args, "-short")
+	}
+	if getBool(params, "race", false) {
+		args = append(args, "-race")
+	}
+	out, ok, err := p.runGo(ctx, dir, append(args, pkgs...)...)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+
+	report := parseGoTestJSON(out)
+	diagnostics := parseGoDiagnostics(dir, strings.Join(report.buildOutput, ""))
+	var b strings.Builder
+	for _, pkg := range report.packages {
+		fmt.Fprintf(&b, "%s\t%s\n", strings.ToUpper(pkg["status"].(string)), pkg["package"])
+	}
+	for _, failure := range report.failures {
+		fmt.Fprintf(&b, "\n--- FAIL: %s (%s)\n%s", failure["test"], failure["package"], failure["output"])
+	}
+	if len(report.buildOutput) > 0 {
+		b.WriteString("\n" + truncateText(strings.Join(report.buildOutput, ""), maxGoOutputText))
+	}
+	if b.Len() == 0 {
+		b.WriteString(truncateText(out, maxGoOutputText))
+	}
+
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"cwd":         dir,
+			"packages":    report.packages,
+			"passed":      ok,
+			"failures":    report.failures,
+			"diagnostics": diagnostics,
+		},
+		Metadata: map[string]any{
+			"content":   []any{acp.ContentBlock{Type: "text", Text: strings.TrimRight(b.String(), "\n")}},
+			"locations": diagnosticLocations(diagnostics),
+		},
+	}, nil
+}
+
+func (p *GoProvider) listPackages(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	dir, err := p.workDir(params)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	pattern := getString(params, "pattern")
+	if pattern == "" {
+		pattern = "./..."
+	}
+	if strings.HasPrefix(pattern, "-") {
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("invalid package pattern %q", pattern)}, nil
+	}
+	out, ok, err := p.runGo(ctx, dir, "list", "-e", "-json", pattern)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	if !ok {
+		return acp.ToolResult{Success: false, Error: "go list failed: " + strings.TrimSpace(out)}, nil
+	}
+
+	packages := make([]map[string]any, 0)
+	var b strings.Builder
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var pkg struct {
+			ImportPath   string
+			Name         string
+			Dir          string
+			GoFiles      []string
+			TestGoFiles  []string
+			XTestGoFiles []string
+			Error        *struct{ Err string }
+		}
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return acp.ToolResult{Success: false, Error: "parse go list output: " + err.Error()}, nil
+		}
+		entry := map[string]any{
+			"importPath":  pkg.ImportPath,
+			"name":        pkg.Name,
+			"dir":         pkg.Dir,
+			"goFiles":     pkg.GoFiles,
+			"testGoFiles": append(pkg.TestGoFiles, pkg.XTestGoFiles...),
+		}
+		fmt.Fprintf(&b, "%s (%d files, %d test files)\n", pkg.ImportPath, len(pkg.GoFiles), len(pkg.TestGoFiles)+len(pkg.XTestGoFiles))
+		if pkg.Error != nil {
+			entry["error"] = pkg.Error.Err
+			fmt.Fprintf(&b, "  error: %s\n", pkg.Error.Err)
+		}
+		packages = append(packages, entry)
+	}
+
+	return acp.ToolResult{
+		Success: true,
+		Result:  map[string]any{"cwd": dir, "pattern": pattern, "packages": packages, "total": len(packages)},
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{Type: "text", Text: strings.TrimRight(b.String(), "\n")}},
+		},
+	}, nil
+}
+
+func (p *GoProvider) workDir(params map[string]any) (string, error) {
+	dir := getString(params, "_cwd")
+	if dir == "" {
+		roots := p.policy.Roots()
+		if len(roots) == 0 {
+			return "", fmt.Errorf("No working directory available for go commands")
+		}
+		dir = roots[0]
+	}
+	return dir, nil
+}
+
+// runGo runs the go command in dir and returns its combined output. ok is
+// false when go exited with a non-zero status; err is only set when go could
+// not be run at all.
+func (p *GoProvider) runGo(ctx context.Context, dir string, args ...string) (out string, ok bool, err error) {
+	binary := p.cfg.Tools.Go.BinaryPath
+	if binary == "" {
+		binary = "go"
+	}
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Dir = dir
+	cmd.Env = processEnv(p.cfg)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return output.String(), false, nil
+		}
+		return "", false, fmt.Errorf("go %s failed: %w", args[0], err)
+	}
+	return output.String(), true, nil
+}
+
+func goPackages(params map[string]any) ([]string, error) {
+	pkgs := []string{"./..."}
+	if raw, ok := params["packages"].([]any); ok && len(raw) > 0 {
+		pkgs = make([]string, 0, len(raw))
+		for _, item := range raw {
+			pkg, _ := item.(string)
+			if pkg == "" || strings.HasPrefix(pkg, "-") {
+				return nil, fmt.Errorf("invalid package pattern %q", pkg)
+			}
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+func goTagArgs(params map[string]any) []string {
+	if tags := getString(params, "tags"); tags != "" {
+		return []string{"-tags", tags}
+	}
+	return nil
+}
+
+func goDevNull() string {
+	if filepath.Separator == '\\' {
+		return "NUL"
+	}
+	return "/dev/null"
+}
+
+// parseGoDiagnostics extracts file:line:col findings from compiler or vet
+// output, resolving paths against dir. Indented continuation lines are
+// appended to the previous message.
+func parseGoDiagnostics(dir, out string) []map[string]any {
+	diagnostics := make([]map[string]any, 0)
+	pkg := ""
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		}
+		if m := goDiagnostic.FindStringSubmatch(line); m != nil {
+			file := m[1]
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			lineNo, _ := strconv.Atoi(m[2])
+			d := map[string]any{"file": file, "line": lineNo, "message": m[4]}
+			if m[3] != "" {
+				d["column"], _ = strconv.Atoi(m[3])
+			}
+			if pkg != "" {
+				d["package"] = pkg
+			}
+			diagnostics = append(diagnostics, d)
+			continue
+		}
+		if len(diagnostics) > 0 && strings.HasPrefix(line, "\t") {
+			last := diagnostics[len(diagnostics)-1]
+			last["message"] = last["message"].(string) + "\n" + strings.TrimSpace(line)
+		}
+	}
+	return diagnostics
+}
+
+func diagnosticLocations(diagnostics []map[string]any) []map[string]any {
+	locations := make([]map[string]any, 0, len(diagnostics))
+	seen := map[string]bool{}
+	for _, d := range diagnostics {
+		key := fmt.Sprint(d["file"], ":", d["line"])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		locations = append(locations, map[string]any{"path": d["file"], "line": d["line"]})
+	}
+	return locations
+}
+
+type goTestReport struct {
+	packages    []map[string]any
+	failures    []map[string]any
+	buildOutput []string
+	// passed, failed and skipped count tests, including subtests.
+	passed, failed, skipped int
+}
+
+// parseGoTestJSON summarizes go test -json output: the final status of each
+// package, per-test counts, the output of each failed test, and any build
+// output. Lines that
+// are not JSON (e.g. from older toolchains) count as build output.
+func parseGoTestJSON(out string) goTestReport {
+	var report goTestReport
+	type testKey struct{ pkg, test string }
+	outputs := map[testKey]*strings.Builder{}
+	status := map[string]map[string]any{}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var ev struct {
+			Action  string
+			Package string
+			Test    string
+			Output  string
+			Elapsed float64
+		}
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil {
+			report.buildOutput = append(report.buildOutput, line+"\n")
+			continue
+		}
+		switch ev.Action {
+		case "build-output":
+			report.buildOutput = append(report.buildOutput, ev.Output)
+		case "output":
+			key := testKey{ev.Package, ev.Test}
+			b := outputs[key]
+			if b == nil {
+				b = &strings.Builder{}
+				outputs[key] = b
+			}
+			if b.Len() < maxGoTestOutput {
+				b.WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			if ev.Test == "" {
+				status[ev.Package] = map[string]any{"package": ev.Package, "status": ev.Action, "elapsed": ev.Elapsed}
+				continue
+			}
+			switch ev.Action {
+			case "pass":
+				report.passed++
+			case "skip":
+				report.skipped++
+			case "fail":
+				report.failed++
+				output := ""
+				if b := outputs[testKey{ev.Package, ev.Test}]; b != nil {
+					output = truncateText(b.String(), maxGoTestOutput)
+				}
+				report.failures = append(report.failures, map[string]any{"package": ev.Package, "test": ev.Test, "elapsed": ev.Elapsed, "output": output})
+			}
+			delete(outputs, testKey{ev.Package, ev.Test})
+		}
+	}
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report.packages = make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		report.packages = append(report.packages, status[name])
+	}
+	if report.failures == nil {
+		report.failures = make([]map[string]any, 0)
+	}
+	return report
+}
+
+func truncateText(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "\n... (truncated)"
+}
+
+func goPatternsTitle(v any) string {
+	items, _ := v.([]any)
+	if len(items) == 0 {
+		return "./..."
+	}
+	patterns := make([]string, 0, len(items))
+	for _, item := range items {
+		patterns = append(patterns, fmt.Sprint(item))
+	}
+	return strings.Join(patterns, " ")
+}
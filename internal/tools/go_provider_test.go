@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func newTestGoModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not available")
+	}
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/demo\n\ngo 1.21\n"
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newTestGoProvider() *GoProvider {
+	return NewGoProvider(config.Default(), logging.NewWithOutput("error", io.Discard))
+}
+
+func TestGoProviderBuildReportsDiagnostics(t *testing.T) {
+	dir := newTestGoModule(t, map[string]string{
+		"main.go":    "package main\n\nfunc main() {}\n",
+		"lib/lib.go": "package lib\n\nfunc F() int {\n\treturn undefinedName\n}\n",
+	})
+	provider := newTestGoProvider()
+	result, _ := provider.build(context.Background(), map[string]any{"_cwd": dir})
+	body := result.Result.(map[string]any)
+	diagnostics := body["diagnostics"].([]map[string]any)
+	if !result.Success || body["ok"] != false || len(diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", result)
+	}
+	d := diagnostics[0]
+	if d["file"] != filepath.Join(dir, "lib", "lib.go") || d["line"] != 4 || !strings.Contains(d["message"].(string), "undefinedName") {
+		t.Fatalf("unexpected diagnostic %v", d)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo")); err == nil {
+		t.Fatalf("go_build must not write binaries")
+	}
+
+	result, _ = provider.build(context.Background(), map[string]any{"_cwd": dir, "packages": []any{"."}})
+	if body := result.Result.(map[string]any); body["ok"] != true {
+		t.Fatalf("expected the main package to build, got %+v", result)
+	}
+	if result, _ = provider.build(context.Background(), map[string]any{"_cwd": dir, "packages": []any{"-toolexec=sh"}}); result.Success {
+		t.Fatalf("expected flags to be rejected as package patterns")
+	}
+}
+
+func TestGoProviderTestAndListPackages(t *testing.T) {
+	dir := newTestGoModule(t, map[string]string{
+		"a/a.go":      "package a\n\nfunc Add(x, y int) int { return x + y }\n",
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 1) != 3 {\n\t\tt.Fatal(\"math is broken\")\n\t}\n}\n\nfunc TestOK(t *testing.T) {}\n",
+		"b/b.go":      "package b\n",
+	})
+	provider := newTestGoProvider()
+	for _, tool := range provider.GetTools() {
+		if tool.Name == "go_test" && !tool.RequiresPermission {
+			t.Fatal("go_test should ask for permission")
+		}
+	}
+	result, _ := provider.test(context.Background(), map[string]any{"_cwd": dir})
+	body := result.Result.(map[string]any)
+	failures := body["failures"].([]map[string]any)
+	if !result.Success || body["passed"] != false || len(failures) != 1 {
+		t.Fatalf("expected one failed test, got %+v", result)
+	}
+	if failures[0]["test"] != "TestAdd" || !strings.Contains(failures[0]["output"].(string), "math is broken") {
+		t.Fatalf("unexpected failure %v", failures[0])
+	}
+	statuses := map[string]any{}
+	for _, pkg := range body["packages"].([]map[string]any) {
+		statuses[pkg["package"].(string)] = pkg["status"]
+	}
+	if statuses["example.com/demo/a"] != "fail" {
+		t.Fatalf("unexpected package statuses %v", statuses)
+	}
+
+	result, _ = provider.listPackages(context.Background(), map[string]any{"_cwd": dir})
+	packages := result.Result.(map[string]any)["packages"].([]map[string]any)
+	if !result.Success || len(packages) != 2 || packages[0]["importPath"] != "example.com/demo/a" || len(packages[0]["testGoFiles"].([]string)) != 1 {
+		t.Fatalf("unexpected packages %+v", result)
+	}
+}
+
+func TestParseGoTestJSONBuildFailure(t *testing.T) {
+	out := `{"ImportPath":"example.com/x","Action":"build-output","Output":"# example.com/x\n"}
+{"ImportPath":"example.com/x","Action":"build-output","Output":"x/x.go:3:9: undefined: y\n"}
+{"ImportPath":"example.com/x","Action":"build-fail"}
+{"Action":"start","Package":"example.com/x"}
+{"Action":"output","Package":"example.com/x","Output":"FAIL\texample.com/x [build failed]\n"}
+{"Action":"fail","Package":"example.com/x","Elapsed":0,"FailedBuild":"example.com/x"}
+`
+	report := parseGoTestJSON(out)
+	diagnostics := parseGoDiagnostics("/src", strings.Join(report.buildOutput, ""))
+	if len(report.packages) != 1 || report.packages[0]["status"] != "fail" || len(diagnostics) != 1 {
+		t.Fatalf("unexpected report %+v, diagnostics %v", report, diagnostics)
+	}
+	if d := diagnostics[0]; d["file"] != "/src/x/x.go" || d["column"] != 9 || d["package"] != "example.com/x" {
+		t.Fatalf("unexpected diagnostic %v", d)
+	}
+}
+
+func TestParseGoTestRunCountsTestsNotPackages(t *testing.T) {
+	out := `{"Action":"run","Package":"example.com/x","Test":"TestA"}
+{"Action":"pass","Package":"example.com/x","Test":"TestA","Elapsed":0}
+{"Action":"run","Package":"example.com/x","Test":"TestB"}
+{"Action":"run","Package":"example.com/x","Test":"TestB/sub"}
+{"Action":"output","Package":"example.com/x","Test":"TestB/sub","Output":"    x_test.go:9: boom\n"}
+{"Action":"fail","Package":"example.com/x","Test":"TestB/sub","Elapsed":0}
+{"Action":"fail","Package":"example.com/x","Test":"TestB","Elapsed":0}
+{"Action":"skip","Package":"example.com/x","Test":"TestC","Elapsed":0}
+{"Action":"output","Package":"example.com/x","Output":"FAIL\texample.com/x\t0.01s\n"}
+{"Action":"fail","Package":"example.com/x","Elapsed":0.01}
+{"Action":"pass","Package":"example.com/y","Elapsed":0}
+`
+	summary := parseGoTestRun(out, "")["summary"].(map[string]any)
+	if summary["total"] != 4 || summary["passed"] != 1 || summary["failed"] != 2 || summary["skipped"] != 1 {
+		t.Fatalf("unexpected summary %v", summary)
+	}
+}
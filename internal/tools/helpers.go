@@ -2,10 +2,20 @@ package tools
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/envpolicy"
 )
 
+// processEnv is the environment of the processes tools start: the adapter's
+// own minus what the environment policy withholds.
+func processEnv(cfg config.Config) []string {
+	return envpolicy.New(cfg.Environment).Filter(os.Environ())
+}
+
 func getString(params map[string]any, key string) string {
 	if params == nil {
 		return ""
@@ -0,0 +1,239 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	// maxWorkspaceIndexes bounds the directories indexed at once; the least
+	// recently used index is stopped to make room for a new one.
+	maxWorkspaceIndexes     = 8
+	defaultIndexMaxResults  = 50
+	defaultIndexMaxRefs     = 200
+	maxIndexResultsPerQuery = 1000
+)
+
+// IndexProvider answers file, definition and reference lookups from a
+// per-directory workspaceIndex that is built in the background the first
+// time a session's working directory is queried.
+type IndexProvider struct {
+	cfg    config.Config
+	logger *logging.Logger
+	policy *fspolicy.Policy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	indexes map[string]*indexEntry
+}
+
+type indexEntry struct {
+	index    *workspaceIndex
+	cancel   context.CancelFunc
+	lastUsed time.Time
+}
+
+func NewIndexProvider(cfg config.Config, logger *logging.Logger) *IndexProvider {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &IndexProvider{
+		cfg:     cfg,
+		logger:  logger,
+		policy:  fspolicy.New(cfg.Tools.Filesystem),
+		ctx:     ctx,
+		cancel:  cancel,
+		indexes: make(map[string]*indexEntry),
+	}
+}
+
+func (p *IndexProvider) Name() string {
+	return "index"
+}
+
+func (p *IndexProvider) Description() string {
+	return "Workspace index for fast file, definition and reference lookup"
+}
+
+func (p *IndexProvider) GetTools() []Tool {
+	if !p.cfg.Tools.Index.Enabled {
+		return nil
+	}
+
+	maxResults := map[string]any{"type": "integer", "minimum": 1, "maximum": maxIndexResultsPerQuery, "description": "Optional: Maximum results to return"}
+	return []Tool{
+		{
+			Name:        "find_files",
+			Description: "Find files in the workspace by name. Accepts part of a file name or path, characters of the path in order (e.g. \"srvhnd\" for server/handler.go), or a glob such as \"*_test.go\". Honors .gitignore.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":       map[string]any{"type": "string", "minLength": 1, "description": "File name, path fragment or glob"},
+					"max_results": maxResults,
+					"root":        rootParam,
+				},
+				"required": []string{"query"},
+			},
+			Handler: p.findFiles,
+		},
+		{
+			Name:        "find_definitions",
+			Description: "Find where functions, methods, types, classes and constants are defined in the workspace.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":        map[string]any{"type": "string", "minLength": 1, "description": "Symbol name"},
+					"kind":        map[string]any{"type": "string", "description": "Optional: Only symbols of this kind (function, method, struct, interface, type, class, const, var)"},
+					"match":       map[string]any{"type": "string", "enum": []string{"exact", "prefix", "contains"}, "description": "Optional: How name is matched (default exact)"},
+					"max_results": maxResults,
+					"root":        rootParam,
+				},
+				"required": []string{"name"},
+			},
+			Handler: p.findDefinitions,
+		},
+		{
+			Name:        "find_references",
+			Description: "Find every whole-word occurrence of an identifier in the workspace, with definitions marked.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":        map[string]any{"type": "string", "minLength": 1, "description": "Identifier"},
+					"max_results": maxResults,
+					"root":        rootParam,
+				},
+				"required": []string{"name"},
+			},
+			Handler: p.findReferences,
+		},
+	}
+}
+
+func (p *IndexProvider) Cleanup() error {
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for root, entry := range p.indexes {
+		entry.cancel()
+		delete(p.indexes, root)
+	}
+	return nil
+}
+
+// index returns the ready index for the root the call names, else the
+// session working directory, starting it if needed and waiting for its first
+// scan.
+func (p *IndexProvider) index(ctx context.Context, params map[string]any) (*workspaceIndex, error) {
+	root, err := workspaceRoot(params, sessionPolicy(p.policy, params))
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		root = getString(params, "_cwd")
+	}
+	if root == "" {
+		roots := p.policy.Roots()
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("No working directory available to index")
+		}
+		root = roots[0]
+	}
+	root = filepath.Clean(root)
+
+	p.mu.Lock()
+	entry, ok := p.indexes[root]
+	if !ok {
+		if len(p.indexes) >= maxWorkspaceIndexes {
+			p.evictLocked()
+		}
+		cfg := p.cfg.Tools.Index
+		indexCtx, cancel := context.WithCancel(p.ctx)
+		entry = &indexEntry{index: newWorkspaceIndex(root, cfg.MaxFiles, cfg.MaxFileSize), cancel: cancel}
+		p.indexes[root] = entry
+		go entry.index.run(indexCtx, time.Duration(cfg.RefreshInterval)*time.Millisecond)
+		p.logger.Debug("Started workspace index", map[string]any{"root": root})
+	}
+	entry.lastUsed = time.Now()
+	p.mu.Unlock()
+
+	if err := entry.index.wait(ctx); err != nil {
+		return nil, err
+	}
+	return entry.index, nil
+}
+
+func (p *IndexProvider) evictLocked() {
+	var oldest string
+	for root, entry := range p.indexes {
+		if oldest == "" || entry.lastUsed.Before(p.indexes[oldest].lastUsed) {
+			oldest = root
+		}
+	}
+	if oldest != "" {
+		p.indexes[oldest].cancel()
+		delete(p.indexes, oldest)
+	}
+}
+
+func (p *IndexProvider) findFiles(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	index, err := p.index(ctx, params)
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	files := index.findFiles(getString(params, "query"), getInt(params, "max_results", defaultIndexMaxResults))
+	locations := make([]map[string]any, 0, len(files))
+	for _, f := range files {
+		locations = append(locations, map[string]any{"path": f})
+	}
+	stats := index.stats()
+	return acp.ToolResult{
+		Success:  true,
+		Result:   map[string]any{"files": files, "total": len(files), "index": stats},
+		Metadata: map[string]any{"locations": locations},
+	}, nil
+}
+
+func (p *IndexProvider) findDefinitions(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	index, err := p.index(ctx, params)
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	symbols := index.findDefinitions(getString(params, "name"), getString(params, "kind"), getString(params, "match"), getInt(params, "max_results", defaultIndexMaxResults))
+	locations := make([]map[string]any, 0, len(symbols))
+	for _, s := range symbols {
+		locations = append(locations, map[string]any{"path": s.Path, "line": s.Line})
+	}
+	return acp.ToolResult{
+		Success:  true,
+		Result:   map[string]any{"definitions": symbols, "total": len(symbols), "index": index.stats()},
+		Metadata: map[string]any{"locations": locations},
+	}, nil
+}
+
+func (p *IndexProvider) findReferences(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	index, err := p.index(ctx, params)
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	refs, truncated, err := index.findReferences(ctx, getString(params, "name"), getInt(params, "max_results", defaultIndexMaxRefs))
+	if err != nil {
+		return acp.ToolResult{}, err
+	}
+	locations := make([]map[string]any, 0, min(len(refs), 50))
+	for _, r := range refs[:min(len(refs), 50)] {
+		locations = append(locations, map[string]any{"path": r.Path, "line": r.Line})
+	}
+	return acp.ToolResult{
+		Success:  true,
+		Result:   map[string]any{"references": refs, "total": len(refs), "truncated": truncated, "index": index.stats()},
+		Metadata: map[string]any{"locations": locations},
+	}, nil
+}
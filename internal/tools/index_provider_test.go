@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func newTestIndexProvider(t *testing.T, files map[string]string) (*IndexProvider, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		writeIndexTestFile(t, filepath.Join(dir, name), text)
+	}
+	cfg := config.Default()
+	cfg.Tools.Filesystem.AllowedPaths = []string{dir}
+	cfg.Tools.Index.Enabled = true
+	cfg.Tools.Index.RefreshInterval = 20
+	p := NewIndexProvider(cfg, logging.NewWithOutput("error", io.Discard))
+	t.Cleanup(func() { _ = p.Cleanup() })
+	return p, dir
+}
+
+func writeIndexTestFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var indexTestFiles = map[string]string{
+	"server/handler.go":      "package server\n\ntype Handler struct{}\n\nfunc (h *Handler) Serve() { NewHandler() }\n\nfunc NewHandler() *Handler { return &Handler{} }\n",
+	"server/handler_test.go": "package server\n\nfunc useHandler() { _ = NewHandler(); _ = NewHandlerFunc }\n",
+	"web/app.ts":             "export class AppHandler {}\nexport function startApp() { new AppHandler() }\n",
+	"README.md":              "NewHandler is documented here\n",
+	"build/gen.go":           "package build\n\nfunc NewHandler() {}\n",
+	".gitignore":             "build/\n",
+}
+
+func TestIndexFindFilesRanksMatches(t *testing.T) {
+	p, dir := newTestIndexProvider(t, indexTestFiles)
+	ctx := context.Background()
+
+	res, err := p.findFiles(ctx, map[string]any{"_cwd": dir, "query": "handler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := res.Result.(map[string]any)["files"].([]string)
+	want := []string{filepath.Join(dir, "server/handler.go"), filepath.Join(dir, "server/handler_test.go")}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v", files)
+	}
+
+	res, _ = p.findFiles(ctx, map[string]any{"_cwd": dir, "query": "*_test.go"})
+	if files := res.Result.(map[string]any)["files"].([]string); len(files) != 1 {
+		t.Fatalf("glob files = %v", files)
+	}
+	res, _ = p.findFiles(ctx, map[string]any{"_cwd": dir, "query": "srvhnd"})
+	if files := res.Result.(map[string]any)["files"].([]string); len(files) != 2 {
+		t.Fatalf("fuzzy files = %v", files)
+	}
+}
+
+func TestIndexFindDefinitionsAndReferences(t *testing.T) {
+	p, dir := newTestIndexProvider(t, indexTestFiles)
+	ctx := context.Background()
+
+	res, err := p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "NewHandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs := res.Result.(map[string]any)["definitions"].([]indexedSymbol)
+	if len(defs) != 1 || defs[0].Path != filepath.Join(dir, "server/handler.go") || defs[0].Line != 7 {
+		t.Fatalf("definitions = %+v (ignored build/ must not be indexed)", defs)
+	}
+
+	res, _ = p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "handler", "match": "contains", "kind": "class"})
+	if defs := res.Result.(map[string]any)["definitions"].([]indexedSymbol); len(defs) != 1 || defs[0].Name != "AppHandler" {
+		t.Fatalf("class definitions = %+v", defs)
+	}
+
+	res, err = p.findReferences(ctx, map[string]any{"_cwd": dir, "name": "NewHandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := res.Result.(map[string]any)["references"].([]indexedReference)
+	if len(refs) != 3 {
+		t.Fatalf("references = %+v", refs)
+	}
+	for _, r := range refs {
+		if r.Definition != (r.Line == 7) {
+			t.Fatalf("definition flag wrong for %+v", r)
+		}
+	}
+}
+
+func TestIndexPicksUpChanges(t *testing.T) {
+	p, dir := newTestIndexProvider(t, map[string]string{"a.go": "package a\n\nfunc Old() {}\n"})
+	ctx := context.Background()
+	if _, err := p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "Old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeIndexTestFile(t, filepath.Join(dir, "a.go"), "package a\n\nfunc Renamed() {}\n")
+	writeIndexTestFile(t, filepath.Join(dir, "b.go"), "package a\n\nfunc Added() {}\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		renamed, _ := p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "Renamed"})
+		added, _ := p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "Added"})
+		old, _ := p.findDefinitions(ctx, map[string]any{"_cwd": dir, "name": "Old"})
+		if renamed.Result.(map[string]any)["total"] == 1 && added.Result.(map[string]any)["total"] == 1 && old.Result.(map[string]any)["total"] == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("index did not pick up the changed and added files")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWordOccurrences(t *testing.T) {
+	got := wordOccurrences("foo foo.bar xfoo foo_ $foo foo", "foo")
+	if len(got) != 3 || got[0] != 0 || got[1] != 4 || got[2] != 27 {
+		t.Fatalf("offsets = %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/ignore"
+)
+
+var errWalkLimit = errors.New("walk limit reached")
+
+func listLocalDirectory(root string, recursive bool, pattern string, maxEntries int) ([]client.DirectoryEntry, bool, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, fmt.Errorf("Directory not found: %s", root)
+		}
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("Path is not a directory: %s", root)
+	}
+
+	entries := make([]client.DirectoryEntry, 0)
+	truncated := false
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if p == root {
+				return walkErr
+			}
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() && d.Name() == ".git" && recursive {
+			return filepath.SkipDir
+		}
+		if pattern == "" || ignore.MatchGlob(pattern, rel) {
+			if maxEntries > 0 && len(entries) >= maxEntries {
+				truncated = true
+				return errWalkLimit
+			}
+			entries = append(entries, directoryEntry(p, d))
+		}
+		if d.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWalkLimit) {
+		return nil, false, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, truncated, nil
+}
+
+func directoryEntry(absPath string, d fs.DirEntry) client.DirectoryEntry {
+	entry := client.DirectoryEntry{Name: d.Name(), Path: absPath, Type: "file"}
+	switch {
+	case d.Type()&fs.ModeSymlink != 0:
+		entry.Type = "symlink"
+	case d.IsDir():
+		entry.Type = "directory"
+	default:
+		if info, err := d.Info(); err == nil {
+			entry.Size = info.Size()
+		}
+	}
+	return entry
+}
+
+// readLocalBinaryFile reads at most maxSize bytes (when positive) from path and
+// detects its mime type from the extension, falling back to content sniffing.
+func readLocalBinaryFile(path string, maxSize int64) ([]byte, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", fmt.Errorf("File not found: %s", path)
+		}
+		return nil, "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		return nil, "", fmt.Errorf("Path is a directory: %s", path)
+	}
+
+	var reader io.Reader = f
+	if maxSize > 0 {
+		reader = io.LimitReader(f, maxSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("Access to %s is not allowed: file exceeds maxFileSize of %d bytes", path, maxSize)
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/ignore"
+)
+
+const (
+	maxSearchLineLength = 500
+	binarySniffBytes    = 8000
+)
+
+var errSearchLimit = errors.New("search result limit reached")
+
+type searchOptions struct {
+	Pattern     *regexp.Regexp
+	Include     string
+	MaxResults  int
+	MaxFileSize int64
+}
+
+type searchMatch struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text"`
+}
+
+type searchSummary struct {
+	FilesScanned int
+	FilesMatched int
+	Matches      int
+	Truncated    bool
+	// Skipped lists the files whose search stopped early, such as at a line
+	// too long to scan.
+	Skipped []searchSkip
+}
+
+type searchSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func compileSearchPattern(query string, fixed bool, caseSensitive bool) (*regexp.Regexp, error) {
+	if fixed {
+		query = regexp.QuoteMeta(query)
+	}
+	if !caseSensitive {
+		query = "(?i)" + query
+	}
+	return regexp.Compile(query)
+}
+
+// searchLocalFiles walks roots (honoring .gitignore), skipping binary and
+// oversized files, and calls onMatch for every matching line.
+func searchLocalFiles(roots []string, opts searchOptions, onMatch func(searchMatch)) (searchSummary, error) {
+	var summary searchSummary
+	for _, root := range roots {
+		err := ignore.Walk(root, nil, func(p string, rel string, d fs.DirEntry) error {
+			if d.IsDir() || !d.Type().IsRegular() {
+				return nil
+			}
+			if opts.Include != "" && !ignore.MatchGlob(opts.Include, rel) {
+				return nil
+			}
+			if opts.MaxFileSize > 0 {
+				if info, err := d.Info(); err == nil && info.Size() > opts.MaxFileSize {
+					return nil
+				}
+			}
+			summary.FilesScanned++
+			matched, err := searchFile(p, opts.Pattern, func(m searchMatch) bool {
+				if opts.MaxResults > 0 && summary.Matches >= opts.MaxResults {
+					summary.Truncated = true
+					return false
+				}
+				summary.Matches++
+				onMatch(m)
+				return true
+			})
+			if matched {
+				summary.FilesMatched++
+			}
+			if errors.Is(err, errSearchLimit) {
+				return err
+			}
+			if err != nil {
+				summary.Skipped = append(summary.Skipped, searchSkip{Path: filepath.Clean(p), Reason: err.Error()})
+			}
+			return nil
+		})
+		if errors.Is(err, errSearchLimit) {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+func searchFile(path string, pattern *regexp.Regexp, emit func(searchMatch) bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(binarySniffBytes)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false, nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	matched := false
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		loc := pattern.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		matched = true
+		text := strings.TrimRight(line, "\r")
+		if len(text) > maxSearchLineLength {
+			text = strings.ToValidUTF8(text[:maxSearchLineLength], "") + "…"
+		}
+		if !emit(searchMatch{Path: filepath.Clean(path), Line: lineNo, Column: loc[0] + 1, Text: text}) {
+			return matched, errSearchLimit
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return matched, fmt.Errorf("search stopped at line %d: %w", lineNo+1, err)
+	}
+	return matched, nil
+}
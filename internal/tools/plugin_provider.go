@@ -0,0 +1,261 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	pluginProtocolVersion = 1
+	pluginListTimeout     = 10 * time.Second
+	maxPluginOutput       = 16 << 20
+	maxPluginStderr       = 4 << 10
+)
+
+// pluginKinds are the ACP tool kinds a plugin may declare; anything else is
+// reported as "other".
+var pluginKinds = map[string]bool{
+	"read": true, "edit": true, "delete": true, "move": true, "search": true,
+	"execute": true, "think": true, "fetch": true, "other": true,
+}
+
+// PluginProvider exposes the tools of an external executable configured in
+// tools.plugins. Every request runs the executable once, writes a single
+// JSON object to its stdin and reads a single JSON object from its stdout:
+//
+//	{"version":1,"method":"list_tools"}
+//	-> {"tools":[{"name":"query","description":"...","parameters":{...},"kind":"read"}]}
+//
+//	{"version":1,"method":"call_tool","tool":"query","arguments":{...},"sessionId":"...","cwd":"..."}
+//	-> {"success":true,"result":...} or {"success":false,"error":"..."}
+//
+// Tools may also declare "destructive" and "requiresPermission". They are
+// listed once, by Load, and exposed as <plugin>_<tool>. A non-zero exit
+// status fails the call with the plugin's stderr.
+type PluginProvider struct {
+	plugin config.PluginConfig
+	logger *logging.Logger
+	env    []string
+
+	// ctx is cancelled by Cleanup, which stops a listing still in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	once  sync.Once
+	mu    sync.Mutex
+	tools []Tool
+}
+
+type pluginRequest struct {
+	Version   int            `json:"version"`
+	Method    string         `json:"method"`
+	Tool      string         `json:"tool,omitempty"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	SessionID string         `json:"sessionId,omitempty"`
+	Cwd       string         `json:"cwd,omitempty"`
+}
+
+type pluginToolSpec struct {
+	Name               string         `json:"name"`
+	Description        string         `json:"description"`
+	Parameters         map[string]any `json:"parameters,omitempty"`
+	Kind               string         `json:"kind,omitempty"`
+	Destructive        bool           `json:"destructive,omitempty"`
+	RequiresPermission bool           `json:"requiresPermission,omitempty"`
+}
+
+func NewPluginProvider(cfg config.Config, plugin config.PluginConfig, logger *logging.Logger) *PluginProvider {
+	env := processEnv(cfg)
+	keys := make([]string, 0, len(plugin.Env))
+	for key := range plugin.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+plugin.Env[key])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PluginProvider{plugin: plugin, logger: logger, env: env, ctx: ctx, cancel: cancel}
+}
+
+func (p *PluginProvider) Name() string {
+	return p.plugin.Name
+}
+
+func (p *PluginProvider) Description() string {
+	return "Tools provided by the " + p.plugin.Command + " plugin"
+}
+
+// GetTools returns the plugin's tools, or none until Load has listed them.
+func (p *PluginProvider) GetTools() []Tool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tools
+}
+
+// Load lists the plugin's tools unless that has been done already. It can
+// take up to pluginListTimeout, so the registry runs it in the background.
+func (p *PluginProvider) Load() {
+	p.once.Do(p.discover)
+}
+
+func (p *PluginProvider) Cleanup() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	return nil
+}
+
+// sameAs reports whether other runs the same plugin the same way, so a
+// reload can keep the tools p has already listed.
+func (p *PluginProvider) sameAs(other *PluginProvider) bool {
+	return reflect.DeepEqual(p.plugin, other.plugin) && slices.Equal(p.env, other.env)
+}
+
+func (p *PluginProvider) discover() {
+	ctx, cancel := context.WithTimeout(p.ctx, pluginListTimeout)
+	defer cancel()
+	var listing struct {
+		Tools []pluginToolSpec `json:"tools"`
+	}
+	if err := p.run(ctx, pluginRequest{Method: "list_tools"}, "", &listing); err != nil {
+		p.logger.Warn("Failed to list plugin tools", map[string]any{"plugin": p.plugin.Name, "error": err.Error()})
+		return
+	}
+	tools := make([]Tool, 0, len(listing.Tools))
+	for _, spec := range listing.Tools {
+		if !validPluginToolName(spec.Name) {
+			p.logger.Warn("Ignoring plugin tool with an invalid name", map[string]any{"plugin": p.plugin.Name, "tool": spec.Name})
+			continue
+		}
+		params := spec.Parameters
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		kind := spec.Kind
+		if !pluginKinds[kind] {
+			kind = "other"
+		}
+		name := spec.Name
+		tools = append(tools, Tool{
+			Name:        p.plugin.Name + "_" + name,
+			Description: spec.Description,
+			Parameters:  params,
+			Kind:        kind,
+			Handler: func(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+				return p.call(ctx, name, params)
+			},
+			RequiresPermission: spec.RequiresPermission || p.plugin.RequirePermission,
+			Destructive:        spec.Destructive,
+		})
+	}
+	p.mu.Lock()
+	p.tools = tools
+	p.mu.Unlock()
+	p.logger.Debug("Loaded plugin tools", map[string]any{"plugin": p.plugin.Name, "count": len(tools)})
+}
+
+func (p *PluginProvider) call(ctx context.Context, tool string, params map[string]any) (acp.ToolResult, error) {
+	args := map[string]any{}
+	for key, value := range params {
+		if strings.HasPrefix(key, "_") || key == "sessionId" || key == "session_id" {
+			continue
+		}
+		args[key] = value
+	}
+	sessionID, _ := params["_sessionId"].(string)
+	cwd, _ := params["_cwd"].(string)
+	req := pluginRequest{Method: "call_tool", Tool: tool, Arguments: args, SessionID: sessionID, Cwd: cwd}
+
+	var result acp.ToolResult
+	if err := p.run(ctx, req, cwd, &result); err != nil {
+		return acp.ToolResult{}, err
+	}
+	if !result.Success && result.Error == "" {
+		result.Error = fmt.Sprintf("%s_%s failed", p.plugin.Name, tool)
+	}
+	return result, nil
+}
+
+// run executes the plugin for one request and decodes its response into out.
+func (p *PluginProvider) run(ctx context.Context, req pluginRequest, cwd string, out any) error {
+	req.Version = pluginProtocolVersion
+	input, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("encode plugin request: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, p.plugin.Command, p.plugin.Args...)
+	cmd.Env = p.env
+	cmd.Dir = p.plugin.Cwd
+	if cmd.Dir == "" {
+		cmd.Dir = cwd
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &cappedBuffer{limit: maxPluginOutput}
+	stderr := &cappedBuffer{limit: maxPluginStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("plugin %s failed: %s", p.plugin.Name, msg)
+			}
+		}
+		return fmt.Errorf("plugin %s failed: %w", p.plugin.Name, err)
+	}
+	if stdout.truncated {
+		return fmt.Errorf("plugin %s response exceeds %d bytes", p.plugin.Name, maxPluginOutput)
+	}
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return fmt.Errorf("plugin %s returned an invalid response: %w", p.plugin.Name, err)
+	}
+	return nil
+}
+
+func validPluginToolName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && r != '-' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a runaway plugin cannot exhaust memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+const fakePlugin = `#!/bin/sh
+req=$(cat)
+case "$req" in
+*'"list_tools"'*)
+  printf '{"tools":[{"name":"lookup","description":"Look up a ticket","kind":"read","parameters":{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}},{"name":"boom","description":"Always fails"},{"name":"read file"}]}'
+  ;;
+*'"boom"'*)
+  echo "ticket service unavailable" >&2
+  exit 3
+  ;;
+*)
+  printf '{"success":true,"result":{"request":%s,"env":"%s|%s"}}' "$req" "$PLUGIN_VAR" "$SECRET_TOKEN"
+  ;;
+esac
+`
+
+func TestPluginProviderListsAndCallsTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin script test is unix-only")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "tickets")
+	if err := os.WriteFile(script, []byte(fakePlugin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRET_TOKEN", "leaked")
+
+	r := newTestRegistry()
+	r.cfg.Tools.Plugins = []config.PluginConfig{{Name: "jira", Command: script, Env: map[string]string{"PLUGIN_VAR": "set"}}}
+	r.Reconfigure(r.cfg)
+	r.pluginLoads.Wait()
+	r.SetSessionCwdResolver(func(string) string { return dir })
+
+	tool := r.GetTool("jira_lookup")
+	if tool == nil || r.GetTool("jira_read file") != nil {
+		t.Fatalf("expected only valid plugin tools, got %v", r.GetTools())
+	}
+	if got := toolAnnotations(*tool); !got.ReadOnly || got.Provider != "jira" {
+		t.Fatalf("unexpected annotations %+v", got)
+	}
+
+	result, _ := r.ExecuteToolWithSession(context.Background(), ToolCall{Name: "jira_lookup", Parameters: map[string]any{"id": "OPS-1"}}, "s1")
+	body, _ := result.Result.(map[string]any)
+	req, _ := body["request"].(map[string]any)
+	if !result.Success || req["tool"] != "lookup" || req["sessionId"] != "s1" || req["cwd"] != dir {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if args, _ := req["arguments"].(map[string]any); len(args) != 1 || args["id"] != "OPS-1" {
+		t.Fatalf("expected only the tool arguments, got %v", req["arguments"])
+	}
+	if body["env"] != "set|" {
+		t.Fatalf("expected plugin env without denied variables, got %q", body["env"])
+	}
+
+	result, _ = r.ExecuteTool(context.Background(), ToolCall{Name: "jira_boom"})
+	if result.Success || !strings.Contains(result.Error, "ticket service unavailable") {
+		t.Fatalf("expected the plugin's stderr in the error, got %+v", result)
+	}
+}
+
+func TestPluginToolsDoNotShadowBuiltins(t *testing.T) {
+	r := newTestRegistry(Tool{Name: "read_file", Handler: okHandler})
+	shadow := &PluginProvider{plugin: config.PluginConfig{Name: "read"}, tools: []Tool{{Name: "read_file", Handler: okHandler}}}
+	shadow.once.Do(func() {}) // tools are already listed
+	r.RegisterProvider(shadow)
+	if tool := r.GetTool("read_file"); tool == nil || tool.provider != "fake" {
+		t.Fatalf("expected the built-in read_file to stay registered, got %+v", tool)
+	}
+}
+
+func TestSlowPluginDoesNotHoldUpReconfigure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin script test is unix-only")
+	}
+	script := filepath.Join(t.TempDir(), "slow")
+	body := "#!/bin/sh\ncat >/dev/null\nsleep 1\nprintf '{\"tools\":[{\"name\":\"lookup\"}]}'\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRegistry()
+	r.cfg.Tools.Plugins = []config.PluginConfig{{Name: "slow", Command: script}}
+	start := time.Now()
+	r.Reconfigure(r.cfg)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Reconfigure waited %v for the plugin", elapsed)
+	}
+	if r.GetTool("slow_lookup") != nil {
+		t.Fatal("expected the plugin tools to be listed in the background")
+	}
+	r.pluginLoads.Wait()
+	if r.GetTool("slow_lookup") == nil {
+		t.Fatalf("expected slow_lookup once listed, got %v", r.GetTools())
+	}
+
+	// Reloading the same plugin keeps its tools instead of listing again.
+	r.Reconfigure(r.cfg)
+	if r.GetTool("slow_lookup") == nil {
+		t.Fatal("expected slow_lookup to survive an unchanged reload")
+	}
+}
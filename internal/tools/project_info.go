@@ -0,0 +1,360 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/ignore"
+)
+
+const (
+	defaultProjectStructureDepth = 2
+	maxProjectStructureDepth     = 5
+	maxProjectStructureSize      = 200
+)
+
+type projectInfoOptions struct {
+	Dependencies bool
+	Scripts      bool
+	Structure    bool
+	// Depth is how many directory levels the structure lists.
+	Depth int
+}
+
+// projectManifests maps manifest files to the project type they indicate.
+var projectManifests = []struct{ file, kind string }{
+	{"go.mod", "go"},
+	{"package.json", "node"},
+	{"Cargo.toml", "rust"},
+	{"pyproject.toml", "python"},
+	{"requirements.txt", "python"},
+	{"Pipfile", "python"},
+	{"setup.py", "python"},
+	{"pom.xml", "java"},
+	{"build.gradle", "java"},
+	{"build.gradle.kts", "kotlin"},
+	{"Gemfile", "ruby"},
+	{"composer.json", "php"},
+	{"Makefile", "make"},
+}
+
+// collectProjectInfo describes the project rooted at dir from its manifest
+// files: name, project types, package managers, dependencies, scripts and
+// optionally the top of its directory tree (honoring .gitignore).
+func collectProjectInfo(dir string, opts projectInfoOptions) map[string]any {
+	info := map[string]any{"root": dir, "name": filepath.Base(dir)}
+	types := make([]string, 0)
+	manifests := make([]string, 0)
+	for _, m := range projectManifests {
+		if fileExists(dir, m.file) {
+			manifests = append(manifests, m.file)
+			if !slices.Contains(types, m.kind) {
+				types = append(types, m.kind)
+			}
+		}
+	}
+	info["types"] = types
+	info["manifests"] = manifests
+	info["packageManagers"] = detectPackageManagers(dir)
+	if fileExists(dir, ".git") {
+		info["vcs"] = "git"
+	}
+
+	dependencies := map[string]any{}
+	scripts := map[string]any{}
+	if name, deps, ok := goModInfo(filepath.Join(dir, "go.mod")); ok {
+		info["name"] = name
+		dependencies["go"] = deps
+	}
+	if pkg, ok := packageJSONInfo(filepath.Join(dir, "package.json")); ok {
+		if pkg.Name != "" {
+			info["name"] = pkg.Name
+		}
+		if pkg.Version != "" {
+			info["version"] = pkg.Version
+		}
+		dependencies["node"] = map[string]any{"dependencies": pkg.Dependencies, "devDependencies": pkg.DevDependencies}
+		if len(pkg.Scripts) > 0 {
+			scripts["npm"] = pkg.Scripts
+		}
+	}
+	if sections, ok := readTOMLSections(filepath.Join(dir, "Cargo.toml")); ok {
+		if name := sections["package"]["name"]; name != "" {
+			info["name"] = name
+		}
+		dependencies["rust"] = sortedKeys(sections["dependencies"])
+	}
+	if sections, ok := readTOMLSections(filepath.Join(dir, "pyproject.toml")); ok {
+		for _, section := range []string{"project", "tool.poetry"} {
+			if name := sections[section]["name"]; name != "" {
+				info["name"] = name
+				break
+			}
+		}
+		deps := tomlStringArray(sections["project"]["dependencies"])
+		deps = append(deps, sortedKeys(sections["tool.poetry.dependencies"])...)
+		dependencies["python"] = deps
+		entryPoints := map[string]string{}
+		for _, section := range []string{"project.scripts", "tool.poetry.scripts"} {
+			for name, target := range sections[section] {
+				entryPoints[name] = target
+			}
+		}
+		if len(entryPoints) > 0 {
+			scripts["python"] = entryPoints
+		}
+	}
+	if reqs, ok := requirementsTxt(filepath.Join(dir, "requirements.txt")); ok {
+		existing, _ := dependencies["python"].([]string)
+		dependencies["python"] = append(existing, reqs...)
+	}
+	if targets := makeTargets(dir); len(targets) > 0 {
+		scripts["make"] = targets
+	}
+
+	if opts.Dependencies {
+		info["dependencies"] = dependencies
+	}
+	if opts.Scripts {
+		info["scripts"] = scripts
+	}
+	if opts.Structure {
+		structure, truncated := projectStructure(dir, opts.Depth)
+		info["structure"] = structure
+		info["structureTruncated"] = truncated
+	}
+	return info
+}
+
+// detectPackageManagers names the package managers the project uses, from
+// lockfiles and manifest fields.
+func detectPackageManagers(dir string) []string {
+	managers := make([]string, 0)
+	add := func(name string) {
+		if !slices.Contains(managers, name) {
+			managers = append(managers, name)
+		}
+	}
+	if fileExists(dir, "go.mod") {
+		add("go")
+	}
+	if pkg, ok := packageJSONInfo(filepath.Join(dir, "package.json")); ok {
+		switch {
+		case pkg.PackageManager != "":
+			name, _, _ := strings.Cut(pkg.PackageManager, "@")
+			add(name)
+		case fileExists(dir, "pnpm-lock.yaml"):
+			add("pnpm")
+		case fileExists(dir, "yarn.lock"):
+			add("yarn")
+		case fileExists(dir, "bun.lockb") || fileExists(dir, "bun.lock"):
+			add("bun")
+		default:
+			add("npm")
+		}
+	}
+	if fileExists(dir, "Cargo.toml") {
+		add("cargo")
+	}
+	pyproject, _ := readTOMLSections(filepath.Join(dir, "pyproject.toml"))
+	switch {
+	case fileExists(dir, "poetry.lock") || pyproject["tool.poetry"] != nil:
+		add("poetry")
+	case fileExists(dir, "uv.lock"):
+		add("uv")
+	case fileExists(dir, "pdm.lock"):
+		add("pdm")
+	case fileExists(dir, "Pipfile"):
+		add("pipenv")
+	case pyproject != nil || fileExists(dir, "requirements.txt") || fileExists(dir, "setup.py"):
+		add("pip")
+	}
+	for _, m := range []struct{ file, name string }{
+		{"Gemfile", "bundler"}, {"composer.json", "composer"}, {"pom.xml", "maven"},
+		{"build.gradle", "gradle"}, {"build.gradle.kts", "gradle"},
+	} {
+		if fileExists(dir, m.file) {
+			add(m.name)
+		}
+	}
+	return managers
+}
+
+var goRequirePattern = regexp.MustCompile(`^(\S+)\s+(v\S+)`)
+
+func goModInfo(path string) (module string, deps []string, ok bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, false
+	}
+	deps = make([]string, 0)
+	inRequire := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "//"); i >= 0 && !strings.HasSuffix(line, "// indirect") {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case strings.HasPrefix(line, "module "):
+			module = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module ")), `"`)
+		case line == "require (":
+			inRequire = true
+		case inRequire && line == ")":
+			inRequire = false
+		case inRequire || strings.HasPrefix(line, "require "):
+			if m := goRequirePattern.FindStringSubmatch(strings.TrimPrefix(line, "require ")); m != nil {
+				dep := m[1] + " " + m[2]
+				if strings.HasSuffix(line, "// indirect") {
+					dep += " (indirect)"
+				}
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return module, deps, true
+}
+
+type packageJSON struct {
+	Name            string            `json:"name"`
+	Version         string            `json:"version"`
+	PackageManager  string            `json:"packageManager"`
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+func packageJSONInfo(path string) (packageJSON, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return packageJSON{}, false
+	}
+	var pkg packageJSON
+	if json.Unmarshal(data, &pkg) != nil {
+		return packageJSON{}, false
+	}
+	return pkg, true
+}
+
+// readTOMLSections is a minimal TOML reader for manifests: it returns the
+// raw value of every key by section, joining multi-line arrays. It does not
+// handle inline tables spanning lines or multi-line strings.
+func readTOMLSections(path string) (map[string]map[string]string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	sections := map[string]map[string]string{}
+	section := ""
+	var pendingKey string
+	var pending strings.Builder
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if pendingKey != "" {
+			pending.WriteString(" " + line)
+			if strings.HasPrefix(line, "]") || strings.HasSuffix(line, "]") {
+				sections[section][pendingKey] = pending.String()
+				pendingKey = ""
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[] ")
+			if sections[section] == nil {
+				sections[section] = map[string]string{}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+		if sections[section] == nil {
+			sections[section] = map[string]string{}
+		}
+		if strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+			pendingKey = key
+			pending.Reset()
+			pending.WriteString(value)
+			continue
+		}
+		sections[section][key] = strings.Trim(value, `"'`)
+	}
+	return sections, true
+}
+
+var tomlStringPattern = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+
+func tomlStringArray(raw string) []string {
+	out := make([]string, 0)
+	for _, m := range tomlStringPattern.FindAllStringSubmatch(raw, -1) {
+		out = append(out, m[1]+m[2])
+	}
+	return out
+}
+
+func requirementsTxt(path string) ([]string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	reqs := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		reqs = append(reqs, line)
+	}
+	return reqs, true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var errStructureLimit = errors.New("structure limit reached")
+
+// projectStructure lists paths up to depth levels deep, directories with a
+// trailing slash.
+func projectStructure(dir string, depth int) ([]string, bool) {
+	entries := make([]string, 0)
+	truncated := false
+	_ = ignore.Walk(dir, nil, func(p string, rel string, d fs.DirEntry) error {
+		if len(entries) >= maxProjectStructureSize {
+			truncated = true
+			return errStructureLimit
+		}
+		level := strings.Count(rel, "/") + 1
+		if d.IsDir() {
+			entries = append(entries, rel+"/")
+			if level >= depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries = append(entries, rel)
+		return nil
+	})
+	return entries, truncated
+}
@@ -1,16 +1,24 @@
 package tools
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/audit"
 	"github.com/spjoes/cursor-agent-acp/internal/client"
 	"github.com/spjoes/cursor-agent-acp/internal/config"
 	"github.com/spjoes/cursor-agent-acp/internal/cursor"
+	"github.com/spjoes/cursor-agent-acp/internal/errcode"
+	"github.com/spjoes/cursor-agent-acp/internal/jsonschema"
 	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/permissions"
+	"github.com/spjoes/cursor-agent-acp/internal/terminal"
 	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
 )
 
@@ -18,9 +26,29 @@ type Tool struct {
 	Name        string
 	Description string
 	Parameters  map[string]any
-	Handler     func(params map[string]any) (acp.ToolResult, error)
+	// Handler runs the tool. ctx is cancelled when the tool times out, its
+	// session is cancelled or the request is abandoned; the registry stops
+	// waiting at that point even if the handler does not return.
+	Handler func(ctx context.Context, params map[string]any) (acp.ToolResult, error)
+	// RequiresPermission makes the registry ask the client (via the tool call
+	// manager) before running the handler.
+	RequiresPermission bool
+	// Destructive marks a tool that may overwrite or discard data. Delete,
+	// move and execute tools are destructive regardless.
+	Destructive bool
+	// Kind is the ACP tool kind; when empty it is derived from the name.
+	Kind string
+	// Precheck refuses a call before permission is requested, so the user is
+	// never asked to approve something the handler would reject anyway.
+	Precheck func(params map[string]any) error
+
+	provider string // set by the registry
 }
 
+// ProgressFunc lets a tool handler stream tool_call_update fields (content,
+// locations, ...) while it runs. Handlers receive it as params["_progress"].
+type ProgressFunc func(update map[string]any)
+
 type ToolProvider interface {
 	Name() string
 	Description() string
@@ -35,14 +63,34 @@ type ToolCall struct {
 }
 
 type Registry struct {
-	cfg    config.Config
 	logger *logging.Logger
 
+	// mu guards cfg, providers, tools and the filesystem client, which
+	// Reconfigure replaces while requests are being served.
+	mu        sync.RWMutex
+	cfg       config.Config
 	providers map[string]ToolProvider
 	tools     map[string]Tool
-
-	cursorBridge *cursor.Bridge
-	toolCalls    *toolcall.Manager
+	fsCaps    map[string]any
+	fsClient  client.FileSystemClient
+	fsReady   bool
+
+	cursorBridge   *cursor.Bridge
+	terminals      *terminal.Manager
+	toolCalls      *toolcall.Manager
+	sessionCwd     func(sessionID string) string
+	sessionFolders func(sessionID string) []string
+	audit          *audit.Log
+
+	runningMu sync.Mutex
+	running   map[string]map[uint64]context.CancelFunc // by session ID
+	runSeq    uint64
+
+	results resultCache
+
+	// pluginLoads tracks plugin tool listings still running in the
+	// background.
+	pluginLoads sync.WaitGroup
 }
 
 func NewRegistry(cfg config.Config, logger *logging.Logger, cursorBridge *cursor.Bridge) *Registry {
@@ -52,47 +100,119 @@ func NewRegistry(cfg config.Config, logger *logging.Logger, cursorBridge *cursor
 		providers:    map[string]ToolProvider{},
 		tools:        map[string]Tool{},
 		cursorBridge: cursorBridge,
+		running:      map[string]map[uint64]context.CancelFunc{},
 	}
-	r.initializeProviders()
+	providers := r.newProviders(cfg, nil)
+	for _, provider := range providers {
+		r.registerProvider(provider)
+	}
+	r.loadPlugins(providers)
 	return r
 }
 
+func (r *Registry) SetAuditLog(log *audit.Log) {
+	r.audit = log
+}
+
 func (r *Registry) SetToolCallManager(manager *toolcall.Manager) {
 	r.toolCalls = manager
 	r.logger.Debug("ToolCallManager registered with ToolRegistry", nil)
 }
 
+// SetTerminalManager registers the run_command tool, which runs commands in
+// client terminals created through manager.
+func (r *Registry) SetTerminalManager(manager *terminal.Manager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.terminals = manager
+	if r.cfg.Tools.Terminal.Enabled {
+		r.registerProvider(NewTerminalProvider(r.cfg, r.logger, manager))
+	}
+}
+
+// SetSessionCwdResolver lets tools receive the session working directory as
+// params["_cwd"].
+func (r *Registry) SetSessionCwdResolver(resolve func(sessionID string) string) {
+	r.sessionCwd = resolve
+}
+
+// SetWorkspaceFoldersResolver lets tools receive the session's workspace
+// folders as params["_workspaceFolders"].
+func (r *Registry) SetWorkspaceFoldersResolver(resolve func(sessionID string) []string) {
+	r.sessionFolders = resolve
+}
+
 func (r *Registry) RegisterProvider(provider ToolProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registerProvider(provider)
+}
+
+func (r *Registry) registerProvider(provider ToolProvider) {
+	r.addProvider(r.providers, r.tools, provider)
+}
+
+// addProvider adds provider and its tools to the given maps, which are
+// either the registry's own (under r.mu) or ones being built to replace
+// them.
+func (r *Registry) addProvider(providers map[string]ToolProvider, tools map[string]Tool, provider ToolProvider) {
 	r.logger.Debug("Registering tool provider", map[string]any{"provider": provider.Name()})
-	r.providers[provider.Name()] = provider
+	providers[provider.Name()] = provider
+	_, plugin := provider.(*PluginProvider)
 	for _, t := range provider.GetTools() {
-		r.tools[t.Name] = t
+		if existing, taken := tools[t.Name]; taken && existing.provider != provider.Name() {
+			// Built-in tools take precedence over plugin tools of the same name.
+			if _, shadowed := providers[existing.provider].(*PluginProvider); plugin || !shadowed {
+				r.logger.Warn("Tool name already registered", map[string]any{"tool": t.Name, "provider": provider.Name(), "registeredBy": existing.provider})
+				continue
+			}
+		}
+		t.provider = provider.Name()
+		tools[t.Name] = t
 		r.logger.Debug("Registered tool", map[string]any{"tool": t.Name})
 	}
 }
 
 func (r *Registry) UnregisterProvider(providerName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unregisterProvider(providerName)
+}
+
+func (r *Registry) unregisterProvider(providerName string) {
 	provider, ok := r.providers[providerName]
 	if !ok {
 		r.logger.Warn("Tool provider not found", map[string]any{"provider": providerName})
 		return
 	}
 	for _, t := range provider.GetTools() {
-		delete(r.tools, t.Name)
+		if r.tools[t.Name].provider == providerName {
+			delete(r.tools, t.Name)
+		}
 	}
 	delete(r.providers, providerName)
 }
 
 func (r *Registry) ConfigureFilesystemProvider(clientCapabilities map[string]any, fsClient client.FileSystemClient) {
-	r.UnregisterProvider("filesystem")
-	if !r.cfg.Tools.Filesystem.Enabled {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fsCaps, r.fsClient, r.fsReady = clientCapabilities, fsClient, true
+	r.configureFilesystemProvider()
+}
+
+func (r *Registry) configureFilesystemProvider() {
+	if _, ok := r.providers["filesystem"]; ok {
+		r.unregisterProvider("filesystem")
+	}
+	if !r.cfg.Tools.Filesystem.Enabled || !r.fsReady {
 		return
 	}
-	provider := NewFilesystemProvider(r.cfg, r.logger, clientCapabilities, fsClient)
-	r.RegisterProvider(provider)
+	r.registerProvider(NewFilesystemProvider(r.cfg, r.logger, r.fsCaps, r.fsClient))
 }
 
 func (r *Registry) GetTools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	tools := make([]Tool, 0, len(r.tools))
 	for _, t := range r.tools {
 		tools = append(tools, t)
@@ -101,15 +221,32 @@ func (r *Registry) GetTools() []Tool {
 }
 
 func (r *Registry) ToolDescriptors() []acp.ToolDescriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	descriptors := make([]acp.ToolDescriptor, 0, len(r.tools))
 	for _, t := range r.tools {
-		descriptors = append(descriptors, acp.ToolDescriptor{Name: t.Name, Description: t.Description, Parameters: t.Parameters})
+		descriptors = append(descriptors, acp.ToolDescriptor{Name: t.Name, Description: t.Description, Parameters: t.Parameters, Annotations: toolAnnotations(t)})
 	}
 	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].Name < descriptors[j].Name })
 	return descriptors
 }
 
+func toolAnnotations(t Tool) *acp.ToolAnnotations {
+	kind := t.kind()
+	destructive := t.Destructive || kind == "delete" || kind == "move" || kind == "execute"
+	readOnly := !destructive && (kind == "read" || kind == "search" || kind == "fetch" || kind == "think")
+	return &acp.ToolAnnotations{
+		ReadOnly:           readOnly,
+		Destructive:        destructive,
+		RequiresPermission: t.RequiresPermission,
+		Kind:               kind,
+		Provider:           t.provider,
+	}
+}
+
 func (r *Registry) GetTool(name string) *Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	tool, ok := r.tools[name]
 	if !ok {
 		return nil
@@ -119,6 +256,8 @@ func (r *Registry) GetTool(name string) *Tool {
 }
 
 func (r *Registry) GetProviders() []ToolProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	providers := make([]ToolProvider, 0, len(r.providers))
 	for _, p := range r.providers {
 		providers = append(providers, p)
@@ -127,19 +266,142 @@ func (r *Registry) GetProviders() []ToolProvider {
 }
 
 func (r *Registry) HasTool(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hasTool(name)
+}
+
+func (r *Registry) hasTool(name string) bool {
 	_, ok := r.tools[name]
 	return ok
 }
 
-func (r *Registry) ExecuteTool(toolCall ToolCall) (acp.ToolResult, error) {
-	return r.ExecuteToolWithSession(toolCall, "")
+func (r *Registry) ExecuteTool(ctx context.Context, toolCall ToolCall) (acp.ToolResult, error) {
+	return r.ExecuteToolWithSession(ctx, toolCall, "")
 }
 
-func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (acp.ToolResult, error) {
+func (r *Registry) ExecuteToolWithSession(ctx context.Context, toolCall ToolCall, sessionID string) (acp.ToolResult, error) {
 	start := time.Now()
+	result, err := r.executeTool(ctx, toolCall, sessionID)
+	r.auditToolCall(toolCall, sessionID, result, err, time.Since(start))
+	return result, err
+}
+
+// InvalidateResults drops sessionID's cached tool results, e.g. when a new
+// prompt turn starts or files were restored outside of tool calls.
+func (r *Registry) InvalidateResults(sessionID string) {
+	r.results.clear(sessionID)
+}
+
+// CancelSession aborts the tool calls running for sessionID and returns how
+// many there were.
+func (r *Registry) CancelSession(sessionID string) int {
+	r.results.clear(sessionID)
+	r.runningMu.Lock()
+	calls := r.running[sessionID]
+	delete(r.running, sessionID)
+	r.runningMu.Unlock()
+	for _, cancel := range calls {
+		cancel()
+	}
+	return len(calls)
+}
+
+func (r *Registry) track(sessionID string, cancel context.CancelFunc) (untrack func()) {
+	r.runningMu.Lock()
+	defer r.runningMu.Unlock()
+	r.runSeq++
+	id := r.runSeq
+	if r.running[sessionID] == nil {
+		r.running[sessionID] = map[uint64]context.CancelFunc{}
+	}
+	r.running[sessionID][id] = cancel
+	return func() {
+		r.runningMu.Lock()
+		defer r.runningMu.Unlock()
+		delete(r.running[sessionID], id)
+		if len(r.running[sessionID]) == 0 {
+			delete(r.running, sessionID)
+		}
+	}
+}
+
+// toolTimeout is tools.timeouts[name] if set, else tools.timeoutMs. Zero
+// means no limit.
+func (r *Registry) toolTimeout(name string) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ms, ok := r.cfg.Tools.Timeouts[name]
+	if !ok {
+		ms = r.cfg.Tools.TimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// runHandler runs the handler under the tool's timeout and returns as soon
+// as ctx is done, leaving a handler that ignores ctx to finish on its own.
+func (r *Registry) runHandler(ctx context.Context, tool Tool, params map[string]any) (acp.ToolResult, error) {
+	timeout := r.toolTimeout(tool.Name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type outcome struct {
+		result acp.ToolResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := tool.Handler(ctx, params)
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0 {
+			return acp.ToolResult{}, fmt.Errorf("%s timed out after %s", tool.Name, timeout)
+		}
+		return acp.ToolResult{}, ctx.Err()
+	}
+}
+
+// runCached serves read-only tools from the session's result cache when
+// tools.resultCache is on, and clears the cache whenever a tool that may
+// change files or run commands is called.
+func (r *Registry) runCached(ctx context.Context, tool Tool, callParams, params map[string]any, sessionID string) (acp.ToolResult, error) {
+	r.mu.RLock()
+	enabled := r.cfg.Tools.ResultCache
+	r.mu.RUnlock()
+	if !enabled || sessionID == "" {
+		return r.runHandler(ctx, tool, params)
+	}
+	if !toolAnnotations(tool).ReadOnly {
+		defer r.results.clear(sessionID)
+		return r.runHandler(ctx, tool, params)
+	}
+	key, ok := resultCacheKey(tool.Name, callParams)
+	if !ok {
+		return r.runHandler(ctx, tool, params)
+	}
+	if cached, hit := r.results.get(sessionID, key); hit {
+		return cached, nil
+	}
+	result, err := r.runHandler(ctx, tool, params)
+	if err == nil && result.Success {
+		r.results.put(sessionID, key, result)
+	}
+	return result, err
+}
+
+func (r *Registry) executeTool(ctx context.Context, toolCall ToolCall, sessionID string) (acp.ToolResult, error) {
+	start := time.Now()
+	r.mu.RLock()
 	tool, ok := r.tools[toolCall.Name]
+	r.mu.RUnlock()
 	if !ok {
-		return acp.ToolResult{Success: false, Error: "Tool not found: " + toolCall.Name, Metadata: map[string]any{"toolName": toolCall.Name, "duration": 0, "executedAt": time.Now().UTC()}}, nil
+		return acp.ToolResult{Success: false, Error: "Tool not found: " + toolCall.Name, Metadata: map[string]any{"toolName": toolCall.Name, "duration": 0, "executedAt": time.Now().UTC(), "errorCode": errcode.ToolNotFound}}, nil
 	}
 
 	if toolCall.Parameters == nil {
@@ -147,7 +409,17 @@ func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (
 	}
 
 	if err := validateToolParameters(tool, toolCall.Parameters); err != nil {
-		return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid parameters for %s: %s", toolCall.Name, err.Error()), Metadata: map[string]any{"toolName": toolCall.Name, "duration": 0, "executedAt": time.Now().UTC()}}, nil
+		metadata := map[string]any{"toolName": toolCall.Name, "duration": 0, "executedAt": time.Now().UTC()}
+		var schemaErr *jsonschema.Error
+		if errors.As(err, &schemaErr) {
+			metadata["violations"] = schemaErr.Violations
+		}
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("Invalid parameters for %s: %s", toolCall.Name, err.Error()), Metadata: metadata}, nil
+	}
+	if tool.Precheck != nil {
+		if err := tool.Precheck(toolCall.Parameters); err != nil {
+			return acp.ToolResult{Success: false, Error: err.Error(), Metadata: map[string]any{"toolName": toolCall.Name, "duration": 0, "executedAt": time.Now().UTC(), "errorCode": errcode.PermissionDenied}}, nil
+		}
 	}
 
 	var toolCallID string
@@ -155,7 +427,7 @@ func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (
 		locations := extractLocations(toolCall.Parameters)
 		report := map[string]any{
 			"title":    toolTitle(toolCall.Name, toolCall.Parameters),
-			"kind":     toolKind(toolCall.Name),
+			"kind":     tool.kind(),
 			"status":   "pending",
 			"rawInput": toolCall.Parameters,
 		}
@@ -166,13 +438,49 @@ func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (
 		r.toolCalls.UpdateToolCall(sessionID, toolCallID, map[string]any{"status": "in_progress"})
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if sessionID != "" {
+		defer r.track(sessionID, cancel)()
+	}
+
+	if tool.RequiresPermission {
+		if denied := r.checkPermission(toolCall.Name, sessionID, toolCallID); denied != "" {
+			if sessionID != "" && r.toolCalls != nil && toolCallID != "" {
+				r.toolCalls.FailToolCall(sessionID, toolCallID, map[string]any{"error": denied})
+			}
+			return acp.ToolResult{Success: false, Error: denied, Metadata: map[string]any{"toolName": toolCall.Name, "duration": time.Since(start).Milliseconds(), "executedAt": time.Now().UTC(), "toolCallId": toolCallID, "errorCode": errcode.PermissionDenied}}, nil
+		}
+	}
+
 	params := cloneMap(toolCall.Parameters)
 	if sessionID != "" {
 		params["_sessionId"] = sessionID
+		if r.sessionCwd != nil {
+			if cwd := r.sessionCwd(sessionID); cwd != "" {
+				params["_cwd"] = cwd
+			}
+		}
+		if r.sessionFolders != nil {
+			if folders := r.sessionFolders(sessionID); len(folders) > 0 {
+				params["_workspaceFolders"] = folders
+			}
+		}
+	}
+	if sessionID != "" && r.toolCalls != nil && toolCallID != "" {
+		params["_progress"] = ProgressFunc(func(update map[string]any) {
+			r.toolCalls.UpdateToolCall(sessionID, toolCallID, update)
+		})
 	}
 
-	result, err := tool.Handler(params)
+	result, err := r.runCached(ctx, tool, toolCall.Parameters, params, sessionID)
 	duration := time.Since(start).Milliseconds()
+	if errors.Is(err, context.Canceled) {
+		if sessionID != "" && r.toolCalls != nil && toolCallID != "" {
+			r.toolCalls.CancelToolCall(sessionID, toolCallID, "Cancelled by user")
+		}
+		return acp.ToolResult{Success: false, Error: "Tool call cancelled: " + toolCall.Name, Metadata: map[string]any{"toolName": toolCall.Name, "duration": duration, "executedAt": time.Now().UTC(), "toolCallId": toolCallID, "cancelled": true}}, nil
+	}
 	if err != nil {
 		if sessionID != "" && r.toolCalls != nil && toolCallID != "" {
 			r.toolCalls.FailToolCall(sessionID, toolCallID, map[string]any{"error": err.Error()})
@@ -195,6 +503,11 @@ func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (
 			complete := map[string]any{"rawOutput": result.Result}
 			if diffs, ok := result.Metadata["diffs"].([]any); ok {
 				complete["content"] = r.toolCalls.ConvertDiffContent(diffs)
+			} else if content, ok := result.Metadata["content"].([]any); ok && len(content) > 0 {
+				complete["content"] = r.toolCalls.ConvertDiffContent(content)
+			}
+			if locations, ok := result.Metadata["locations"].([]map[string]any); ok && len(locations) > 0 {
+				complete["locations"] = locations
 			}
 			r.toolCalls.CompleteToolCall(sessionID, toolCallID, complete)
 		} else {
@@ -205,7 +518,53 @@ func (r *Registry) ExecuteToolWithSession(toolCall ToolCall, sessionID string) (
 	return result, nil
 }
 
+func (r *Registry) auditToolCall(toolCall ToolCall, sessionID string, result acp.ToolResult, err error, elapsed time.Duration) {
+	if r.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		SessionID:  sessionID,
+		Kind:       audit.KindTool,
+		Name:       toolCall.Name,
+		Outcome:    "success",
+		DurationMs: elapsed.Milliseconds(),
+		Details:    map[string]any{},
+	}
+	switch {
+	case err != nil:
+		entry.Outcome, entry.Error = "failure", err.Error()
+	case !result.Success:
+		entry.Outcome, entry.Error = "failure", result.Error
+	}
+	if id, ok := result.Metadata["toolCallId"].(string); ok && id != "" {
+		entry.Details["toolCallId"] = id
+	}
+	if locations := extractLocations(toolCall.Parameters); len(locations) > 0 {
+		entry.Details["locations"] = locations
+	}
+	r.audit.Record(entry)
+}
+
+// checkPermission asks the client whether a permission-gated tool may run and
+// returns a non-empty reason when it may not.
+func (r *Registry) checkPermission(toolName string, sessionID string, toolCallID string) string {
+	if sessionID == "" || r.toolCalls == nil || toolCallID == "" {
+		return fmt.Sprintf("Permission denied: %s requires a session so the client can approve it", toolName)
+	}
+	outcome := r.toolCalls.RequestToolPermission(sessionID, toolCallID, []permissions.PermissionOption{
+		{OptionID: "allow-once", Name: "Allow", Kind: "allow_once"},
+		{OptionID: "reject-once", Name: "Reject", Kind: "reject_once"},
+	})
+	if outcome.Outcome == "selected" && outcome.OptionID == "allow-once" {
+		return ""
+	}
+	r.logger.Info("Tool call rejected by permission request", map[string]any{"tool": toolName, "sessionId": sessionID, "outcome": outcome.Outcome, "optionId": outcome.OptionID})
+	return fmt.Sprintf("Permission denied: the user did not approve %s", toolName)
+}
+
 func (r *Registry) GetCapabilities() map[string]any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	toolNames := make([]string, 0, len(r.tools))
 	for name := range r.tools {
 		toolNames = append(toolNames, name)
@@ -218,12 +577,14 @@ func (r *Registry) GetCapabilities() map[string]any {
 		"tools":     toolNames,
 		"providers": providerNames,
 	}
-	cap["filesystem"] = r.HasTool("read_file") || r.HasTool("write_file")
-	cap["cursor"] = r.HasTool("search_codebase") || r.HasTool("analyze_code")
+	cap["filesystem"] = r.hasTool("read_file") || r.hasTool("write_file")
+	cap["cursor"] = r.hasTool("search_codebase") || r.hasTool("analyze_code")
 	return cap
 }
 
 func (r *Registry) Metrics() map[string]any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	providers := make([]string, 0, len(r.providers))
 	for name := range r.providers {
 		providers = append(providers, name)
@@ -232,6 +593,8 @@ func (r *Registry) Metrics() map[string]any {
 }
 
 func (r *Registry) ValidateConfiguration() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	errs := make([]string, 0)
 	if r.cfg.Tools.Filesystem.Enabled {
 		if _, ok := r.providers["filesystem"]; !ok {
@@ -241,23 +604,102 @@ func (r *Registry) ValidateConfiguration() []string {
 	if r.cfg.Tools.Terminal.Enabled && r.cfg.Tools.Terminal.MaxProcesses <= 0 {
 		errs = append(errs, "Terminal enabled but maxProcesses is invalid")
 	}
-	if r.cfg.Tools.Cursor.Enabled && !r.HasTool("search_codebase") && !r.HasTool("analyze_code") {
+	if r.cfg.Tools.Cursor.Enabled && !r.hasTool("search_codebase") && !r.hasTool("analyze_code") {
 		errs = append(errs, "Cursor tools enabled but not properly registered")
 	}
 	return errs
 }
 
 func (r *Registry) Reload() error {
-	for _, provider := range r.providers {
-		_ = provider.Cleanup()
-	}
-	r.providers = map[string]ToolProvider{}
-	r.tools = map[string]Tool{}
-	r.initializeProviders()
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+	r.Reconfigure(cfg)
 	return nil
 }
 
+// Reconfigure rebuilds every provider from cfg, including the filesystem
+// provider when the client's filesystem has been configured. The new
+// providers are built without holding r.mu, since that can be slow, and
+// swapped in at once. Calls already running keep the handler they looked
+// up.
+func (r *Registry) Reconfigure(cfg config.Config) {
+	r.mu.RLock()
+	terminals, fsReady := r.terminals, r.fsReady
+	fsCaps, fsClient := r.fsCaps, r.fsClient
+	r.mu.RUnlock()
+
+	built := r.newProviders(cfg, terminals)
+	// A plugin that has not changed keeps its provider, and with it the
+	// tools it has listed.
+	for i, provider := range built {
+		if plugin, ok := provider.(*PluginProvider); ok {
+			if existing, ok := r.pluginProvider(plugin.Name()); ok && existing.sameAs(plugin) {
+				_ = plugin.Cleanup()
+				built[i] = existing
+			}
+		}
+	}
+	providers, tools := map[string]ToolProvider{}, map[string]Tool{}
+	for _, provider := range built {
+		r.addProvider(providers, tools, provider)
+	}
+	if cfg.Tools.Filesystem.Enabled && fsReady {
+		r.addProvider(providers, tools, NewFilesystemProvider(cfg, r.logger, fsCaps, fsClient))
+	}
+
+	r.mu.Lock()
+	previous := r.providers
+	r.cfg, r.providers, r.tools = cfg, providers, tools
+	// The client or terminal manager may have been set up meanwhile.
+	if r.terminals != terminals && cfg.Tools.Terminal.Enabled && r.terminals != nil {
+		r.registerProvider(NewTerminalProvider(cfg, r.logger, r.terminals))
+	}
+	if r.fsReady != fsReady || r.fsClient != fsClient {
+		r.configureFilesystemProvider()
+	}
+	r.mu.Unlock()
+
+	for name, provider := range previous {
+		if providers[name] != provider {
+			_ = provider.Cleanup()
+		}
+	}
+	r.loadPlugins(built)
+}
+
+func (r *Registry) pluginProvider(name string) (*PluginProvider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	plugin, ok := r.providers[name].(*PluginProvider)
+	return plugin, ok
+}
+
+// loadPlugins lists the tools of each plugin provider in the background, so
+// a slow plugin holds up neither initialize nor a reload, and registers them
+// if the provider is still in use by then.
+func (r *Registry) loadPlugins(providers []ToolProvider) {
+	for _, provider := range providers {
+		plugin, ok := provider.(*PluginProvider)
+		if !ok {
+			continue
+		}
+		r.pluginLoads.Add(1)
+		go func() {
+			defer r.pluginLoads.Done()
+			plugin.Load()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.providers[plugin.Name()] == provider {
+				r.registerProvider(plugin)
+			}
+		}()
+	}
+}
+
 func (r *Registry) Cleanup() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, provider := range r.providers {
 		if err := provider.Cleanup(); err != nil {
 			r.logger.Warn("Failed to cleanup provider", map[string]any{"provider": provider.Name(), "error": err.Error()})
@@ -266,32 +708,48 @@ func (r *Registry) Cleanup() error {
 	return nil
 }
 
-func (r *Registry) initializeProviders() {
-	if r.cfg.Tools.Cursor.Enabled {
-		r.RegisterProvider(NewCursorProvider(r.cfg, r.logger, r.cursorBridge))
+// newProviders builds the providers cfg enables, besides the filesystem
+// provider. The terminal provider needs terminals.
+func (r *Registry) newProviders(cfg config.Config, terminals *terminal.Manager) []ToolProvider {
+	providers := make([]ToolProvider, 0)
+	if cfg.Tools.Cursor.Enabled {
+		providers = append(providers, NewCursorProvider(cfg, r.logger, r.cursorBridge))
+	}
+	if cfg.Tools.Git.Enabled {
+		providers = append(providers, NewGitProvider(cfg, r.logger))
+	}
+	if cfg.Tools.Go.Enabled {
+		providers = append(providers, NewGoProvider(cfg, r.logger))
+	}
+	if cfg.Tools.Web.Enabled || cfg.Tools.Web.Search.Enabled {
+		providers = append(providers, NewWebProvider(cfg, r.logger))
+	}
+	if cfg.Tools.Index.Enabled {
+		providers = append(providers, NewIndexProvider(cfg, r.logger))
 	}
+	if cfg.Tools.Terminal.Enabled && terminals != nil {
+		providers = append(providers, NewTerminalProvider(cfg, r.logger, terminals))
+	}
+	for _, plugin := range cfg.Tools.Plugins {
+		providers = append(providers, NewPluginProvider(cfg, plugin, r.logger))
+	}
+	return providers
 }
 
+// validateToolParameters checks params against the tool's JSON Schema. The
+// session ID keys callers add for routing are not tool parameters, so they
+// never count as unknown properties.
 func validateToolParameters(tool Tool, params map[string]any) error {
 	if params == nil {
 		return fmt.Errorf("parameters are required and must be an object")
 	}
-	required, _ := tool.Parameters["required"].([]string)
-	if len(required) == 0 {
-		if raw, ok := tool.Parameters["required"].([]any); ok {
-			required = make([]string, 0, len(raw))
-			for _, v := range raw {
-				required = append(required, fmt.Sprint(v))
-			}
-		}
-	}
-	for _, key := range required {
-		value, ok := params[key]
-		if !ok || value == nil {
-			return fmt.Errorf("Missing required parameter: %s", key)
+	if _, declared := tool.Parameters["additionalProperties"]; declared {
+		params = cloneMap(params)
+		for _, key := range []string{"sessionId", "session_id", "_sessionId"} {
+			delete(params, key)
 		}
 	}
-	return nil
+	return jsonschema.Validate(tool.Parameters, params)
 }
 
 func extractLocations(parameters map[string]any) []map[string]any {
@@ -308,24 +766,47 @@ func extractLocations(parameters map[string]any) []map[string]any {
 	}
 	if files, ok := parameters["files"].([]any); ok {
 		for _, file := range files {
-			locations = append(locations, map[string]any{"path": file})
+			switch f := file.(type) {
+			case string:
+				locations = append(locations, map[string]any{"path": f})
+			case map[string]any:
+				// read_files items: {"path": ..., "line": ...}
+				path, ok := f["path"].(string)
+				if !ok || path == "" {
+					continue
+				}
+				location := map[string]any{"path": path}
+				if line := getInt(f, "line", 0); line > 0 {
+					location["line"] = line
+				}
+				locations = append(locations, location)
+			}
 		}
 	}
 	return locations
 }
 
+func (t Tool) kind() string {
+	if t.Kind != "" {
+		return t.Kind
+	}
+	return toolKind(t.Name)
+}
+
 func toolKind(name string) string {
 	kindMap := map[string]string{
-		"read_file": "read", "copy_file": "read", "list_directory": "read", "get_file_info": "read",
-		"write_file": "edit", "append_file": "edit", "create_file": "edit", "patch_file": "edit", "apply_code_changes": "edit",
+		"read_file": "read", "read_binary_file": "read", "read_files": "read", "copy_file": "read", "list_directory": "read", "get_file_info": "read",
+		"git_status": "read", "git_diff": "read", "git_log": "read", "git_blame": "read", "git_commit": "edit",
+		"write_file": "edit", "append_file": "edit", "create_file": "edit", "patch_file": "edit", "edit_file": "edit", "apply_code_changes": "edit",
 		"delete_file": "delete", "remove_file": "delete", "remove_directory": "delete",
 		"move_file": "move", "rename_file": "move",
-		"search_codebase": "search", "search_files": "search", "grep": "search", "find_files": "search", "find_references": "search", "find_definitions": "search",
+		"search_codebase": "search", "search_files": "search", "grep": "search", "find_files": "search", "find_references": "search", "find_definitions": "search", "glob": "search",
 		"run_tests": "execute", "run_command": "execute", "execute_command": "execute", "run_script": "execute", "shell": "execute",
 		"fetch_url": "fetch", "http_request": "fetch", "download_file": "fetch", "api_request": "fetch", "web_search": "fetch",
 		"think": "think", "reason": "think", "plan": "think", "analyze": "think", "explain_code": "think",
 		"switch_mode": "switch_mode", "set_mode": "switch_mode", "change_mode": "switch_mode",
 		"analyze_code": "read", "get_project_info": "read",
+		"go_test": "execute", "list_packages": "read",
 	}
 	if kind, ok := kindMap[name]; ok {
 		return kind
@@ -335,12 +816,47 @@ func toolKind(name string) string {
 
 func toolTitle(toolName string, parameters map[string]any) string {
 	switch toolName {
-	case "read_file":
+	case "read_file", "read_binary_file":
 		return "Reading file: " + str(parameters["path"], "unknown")
 	case "write_file":
 		return "Writing file: " + str(parameters["path"], "unknown")
+	case "edit_file":
+		return "Editing file: " + str(parameters["path"], "unknown")
+	case "read_files":
+		if files, ok := parameters["files"].([]any); ok {
+			return fmt.Sprintf("Reading %d files", len(files))
+		}
+		return "Reading files"
 	case "list_directory":
 		return "Listing directory: " + str(parameters["path"], "unknown")
+	case "search_files":
+		return "Searching files: " + str(parameters["query"], "unknown")
+	case "glob":
+		return "Finding files: " + str(parameters["pattern"], "unknown")
+	case "find_files":
+		return "Finding files: " + str(parameters["query"], "unknown")
+	case "find_definitions":
+		return "Finding definitions: " + str(parameters["name"], "unknown")
+	case "find_references":
+		return "Finding references: " + str(parameters["name"], "unknown")
+	case "fetch_url":
+		return "Fetching: " + str(parameters["url"], "unknown")
+	case "web_search":
+		return "Searching the web: " + str(parameters["query"], "unknown")
+	case "git_status":
+		return "Checking git status"
+	case "git_diff":
+		return "Viewing git diff: " + str(parameters["path"], str(parameters["ref"], "working tree"))
+	case "git_log":
+		return "Viewing git log: " + str(parameters["path"], str(parameters["ref"], "HEAD"))
+	case "git_blame":
+		return "Blaming file: " + str(parameters["path"], "unknown")
+	case "git_commit":
+		return "Committing: " + str(parameters["message"], "unknown")
+	case "go_build", "go_vet", "go_test":
+		return "Running " + strings.Replace(toolName, "_", " ", 1) + ": " + goPatternsTitle(parameters["packages"])
+	case "list_packages":
+		return "Listing Go packages: " + str(parameters["pattern"], "./...")
 	case "delete_file", "remove_file":
 		return "Deleting file: " + str(parameters["path"], "unknown")
 	case "remove_directory":
@@ -364,6 +880,12 @@ func toolTitle(toolName string, parameters map[string]any) string {
 	}
 }
 
+func reportProgress(params map[string]any, update map[string]any) {
+	if fn, ok := params["_progress"].(ProgressFunc); ok && fn != nil {
+		fn(update)
+	}
+}
+
 func str(v any, def string) string {
 	if v == nil {
 		return def
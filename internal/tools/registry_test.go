@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
+)
+
+type fakeProvider struct {
+	tools []Tool
+}
+
+func (p *fakeProvider) Name() string        { return "fake" }
+func (p *fakeProvider) Description() string { return "fake tools" }
+func (p *fakeProvider) GetTools() []Tool    { return p.tools }
+func (p *fakeProvider) Cleanup() error      { return nil }
+
+func newTestRegistry(tools ...Tool) *Registry {
+	cfg := config.Default()
+	cfg.Tools.Cursor.Enabled = false
+	cfg.Tools.Git.Enabled = false
+	cfg.Tools.Go.Enabled = false
+	cfg.Tools.Web.Enabled = false
+	cfg.Tools.Web.Search.Enabled = false
+	r := NewRegistry(cfg, logging.New("error"), nil)
+	r.RegisterProvider(&fakeProvider{tools: tools})
+	return r
+}
+
+func okHandler(context.Context, map[string]any) (acp.ToolResult, error) {
+	return acp.ToolResult{Success: true}, nil
+}
+
+func TestToolDescriptorsCarryAnnotations(t *testing.T) {
+	r := newTestRegistry(
+		Tool{Name: "read_file", Handler: okHandler},
+		Tool{Name: "write_file", Handler: okHandler, Destructive: true},
+		Tool{Name: "git_commit", Handler: okHandler, RequiresPermission: true},
+		Tool{Name: "run_tests", Handler: okHandler},
+	)
+	got := map[string]acp.ToolAnnotations{}
+	for _, d := range r.ToolDescriptors() {
+		got[d.Name] = *d.Annotations
+	}
+	want := map[string]acp.ToolAnnotations{
+		"read_file":  {ReadOnly: true, Kind: "read", Provider: "fake"},
+		"write_file": {Destructive: true, Kind: "edit", Provider: "fake"},
+		"git_commit": {RequiresPermission: true, Kind: "edit", Provider: "fake"},
+		"run_tests":  {Destructive: true, Kind: "execute", Provider: "fake"},
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("%s annotations = %+v, want %+v", name, got[name], w)
+		}
+	}
+}
+
+func TestExecuteToolValidatesParameterSchema(t *testing.T) {
+	called := false
+	r := newTestRegistry(Tool{
+		Name: "git_log",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"max_count": map[string]any{"type": "number"},
+				"ref":       map[string]any{"type": "string"},
+			},
+			"required": []string{"ref"},
+		},
+		Handler: func(context.Context, map[string]any) (acp.ToolResult, error) {
+			called = true
+			return acp.ToolResult{Success: true}, nil
+		},
+	})
+
+	result, err := r.ExecuteTool(context.Background(), ToolCall{Name: "git_log", Parameters: map[string]any{"ref": "HEAD", "max_count": "ten"}})
+	if err != nil || result.Success || called {
+		t.Fatalf("expected a validation failure without running the handler, got %+v (%v)", result, err)
+	}
+	if !strings.Contains(result.Error, "/max_count: must be number, got string") {
+		t.Fatalf("unexpected error %q", result.Error)
+	}
+
+	result, _ = r.ExecuteTool(context.Background(), ToolCall{Name: "git_log", Parameters: map[string]any{"ref": "HEAD", "max_count": 5.0}})
+	if !result.Success || !called {
+		t.Fatalf("expected valid parameters to run the handler, got %+v", result)
+	}
+}
+
+func TestExecuteToolTimesOutAndCancels(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hang := func(context.Context, map[string]any) (acp.ToolResult, error) {
+		<-release // ignores ctx, like a stuck handler
+		return acp.ToolResult{Success: true}, nil
+	}
+	r := newTestRegistry(Tool{Name: "slow", Handler: hang}, Tool{Name: "stuck", Handler: hang})
+	r.cfg.Tools.Timeouts = map[string]int64{"slow": 20, "stuck": 0}
+
+	result, _ := r.ExecuteTool(context.Background(), ToolCall{Name: "slow"})
+	if result.Success || !strings.Contains(result.Error, "slow timed out after 20ms") {
+		t.Fatalf("expected a timeout, got %+v", result)
+	}
+
+	var mu sync.Mutex
+	var statuses []string
+	r.SetToolCallManager(toolcall.NewManager(logging.New("error"), func(n map[string]any) {
+		update, _ := n["params"].(map[string]any)["update"].(map[string]any)
+		mu.Lock()
+		statuses = append(statuses, fmt.Sprint(update["status"], " ", update["title"]))
+		mu.Unlock()
+	}, nil))
+	done := make(chan acp.ToolResult)
+	go func() {
+		result, _ := r.ExecuteToolWithSession(context.Background(), ToolCall{Name: "stuck"}, "s1")
+		done <- result
+	}()
+	deadline := time.Now().Add(time.Second)
+	for r.CancelSession("s1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("tool call never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	result = <-done
+	if result.Success || result.Metadata["cancelled"] != true {
+		t.Fatalf("expected a cancelled result, got %+v", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last := statuses[len(statuses)-1]; last != "failed Cancelled by user" {
+		t.Fatalf("expected a cancellation update, got %q", statuses)
+	}
+}
+
+func TestExecuteToolCachesReadOnlyResultsPerTurn(t *testing.T) {
+	reads := 0
+	read := func(context.Context, map[string]any) (acp.ToolResult, error) {
+		reads++
+		return acp.ToolResult{Success: true, Result: reads}, nil
+	}
+	r := newTestRegistry(
+		Tool{Name: "read_file", Handler: read},
+		Tool{Name: "write_file", Handler: okHandler, Destructive: true},
+	)
+	r.cfg.Tools.ResultCache = true
+	ctx := context.Background()
+	readCall := ToolCall{Name: "read_file", Parameters: map[string]any{"path": "a.go"}}
+
+	first, _ := r.ExecuteToolWithSession(ctx, readCall, "s1")
+	second, _ := r.ExecuteToolWithSession(ctx, readCall, "s1")
+	if reads != 1 || second.Result != first.Result || second.Metadata["cached"] != true {
+		t.Fatalf("expected a cached result, got %+v after %d reads", second, reads)
+	}
+	if _, _ = r.ExecuteToolWithSession(ctx, readCall, "s2"); reads != 2 {
+		t.Fatalf("sessions must not share cached results")
+	}
+	other := ToolCall{Name: "read_file", Parameters: map[string]any{"path": "b.go"}}
+	if _, _ = r.ExecuteToolWithSession(ctx, other, "s1"); reads != 3 {
+		t.Fatalf("different parameters must not hit the cache")
+	}
+
+	r.ExecuteToolWithSession(ctx, ToolCall{Name: "write_file"}, "s1")
+	if _, _ = r.ExecuteToolWithSession(ctx, readCall, "s1"); reads != 4 {
+		t.Fatalf("a write must invalidate the session's cache")
+	}
+	r.InvalidateResults("s1")
+	if _, _ = r.ExecuteToolWithSession(ctx, readCall, "s1"); reads != 5 {
+		t.Fatalf("a new turn must invalidate the session's cache")
+	}
+}
+
+func TestExtractLocationsReadsFileItems(t *testing.T) {
+	got := extractLocations(map[string]any{"files": []any{
+		"/w/a.go",
+		map[string]any{"path": "/w/b.go", "line": float64(12), "limit": float64(5)},
+		map[string]any{"line": float64(3)},
+		float64(7),
+	}})
+	want := []map[string]any{{"path": "/w/a.go"}, {"path": "/w/b.go", "line": 12}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractLocations = %#v, want %#v", got, want)
+	}
+}
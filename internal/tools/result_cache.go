@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+// maxCachedResults bounds one session's result cache; past it the oldest
+// turn's results are simply not kept.
+const maxCachedResults = 256
+
+// resultCache remembers successful read-only tool results for the current
+// turn of each session, so repeated read_file or search calls skip the work
+// and the client round trips. Any tool that may change state clears the
+// session's entries, as does the start of a new prompt.
+type resultCache struct {
+	mu       sync.Mutex
+	sessions map[string]map[string]acp.ToolResult
+}
+
+func (c *resultCache) get(sessionID, key string) (acp.ToolResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.sessions[sessionID][key]
+	if !ok {
+		return acp.ToolResult{}, false
+	}
+	result.Metadata = cloneMap(result.Metadata)
+	result.Metadata["cached"] = true
+	return result, true
+}
+
+func (c *resultCache) put(sessionID, key string, result acp.ToolResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions == nil {
+		c.sessions = map[string]map[string]acp.ToolResult{}
+	}
+	entries := c.sessions[sessionID]
+	if entries == nil {
+		entries = map[string]acp.ToolResult{}
+		c.sessions[sessionID] = entries
+	}
+	if len(entries) >= maxCachedResults {
+		return
+	}
+	result.Metadata = cloneMap(result.Metadata)
+	entries[key] = result
+}
+
+func (c *resultCache) clear(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, sessionID)
+}
+
+// resultCacheKey hashes the tool name and its parameters. encoding/json
+// sorts map keys, so equal parameters always hash alike.
+func resultCacheKey(name string, params map[string]any) (string, bool) {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(append([]byte(name+"\x00"), buf...))
+	return hex.EncodeToString(sum[:]), true
+}
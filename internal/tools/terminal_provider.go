@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/terminal"
+)
+
+// defaultCommandTimeout bounds run_command when the call does not pass
+// timeout_ms.
+const defaultCommandTimeout = 2 * time.Minute
+
+// TerminalProvider runs commands in client terminals. Every command goes
+// through the terminal manager, so the command safety check, the environment
+// policy, the concurrency limit and the idle reaper all apply.
+type TerminalProvider struct {
+	cfg     config.Config
+	logger  *logging.Logger
+	manager *terminal.Manager
+}
+
+func NewTerminalProvider(cfg config.Config, logger *logging.Logger, manager *terminal.Manager) *TerminalProvider {
+	return &TerminalProvider{cfg: cfg, logger: logger, manager: manager}
+}
+
+func (p *TerminalProvider) Name() string {
+	return "terminal"
+}
+
+func (p *TerminalProvider) Description() string {
+	return "Run commands in a client terminal"
+}
+
+func (p *TerminalProvider) GetTools() []Tool {
+	if !p.cfg.Tools.Terminal.Enabled || p.manager == nil || !p.manager.CanCreateTerminals() {
+		return nil
+	}
+	return []Tool{
+		{
+			Name:        "run_command",
+			Description: "Run a command in a client terminal and return its exit status and output. The command runs in the session working directory unless cwd is given.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"command":    map[string]any{"type": "string", "description": "Command to run"},
+					"args":       map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Optional: Command arguments"},
+					"cwd":        map[string]any{"type": "string", "description": "Optional: Working directory (absolute path)"},
+					"env":        map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}, "description": "Optional: Extra environment variables"},
+					"timeout_ms": map[string]any{"type": "integer", "minimum": 1, "description": "Optional: Kill the command after this many milliseconds (default 120000)"},
+				},
+				"required": []string{"command"},
+			},
+			Precheck:           p.checkCommand,
+			Handler:            p.runCommand,
+			RequiresPermission: true,
+			Kind:               "execute",
+		},
+	}
+}
+
+func (p *TerminalProvider) Cleanup() error { return nil }
+
+// checkCommand applies the terminal manager's command safety and environment
+// policies before the user is asked to approve the command.
+func (p *TerminalProvider) checkCommand(params map[string]any) error {
+	names := make([]string, 0)
+	if env, ok := params["env"].(map[string]any); ok {
+		for name := range env {
+			names = append(names, name)
+		}
+	}
+	return p.manager.CheckCommand(getString(params, "command"), commandArgs(params), names...)
+}
+
+func commandArgs(params map[string]any) []string {
+	args := make([]string, 0)
+	if raw, ok := params["args"].([]any); ok {
+		for _, item := range raw {
+			arg, _ := item.(string)
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+func (p *TerminalProvider) runCommand(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	sessionID := getString(params, "_sessionId")
+	if sessionID == "" {
+		return acp.ToolResult{Success: false, Error: "run_command requires a session"}, nil
+	}
+	args := commandArgs(params)
+	options := &terminal.CreateParams{Cwd: getString(params, "cwd")}
+	if options.Cwd == "" {
+		options.Cwd = getString(params, "_cwd")
+	}
+	if env, ok := params["env"].(map[string]any); ok {
+		names := make([]string, 0, len(env))
+		for name := range env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			options.Env = append(options.Env, client.EnvVariable{Name: name, Value: fmt.Sprint(env[name])})
+		}
+	}
+	timeout := defaultCommandTimeout
+	if ms := getInt(params, "timeout_ms", 0); ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	command := getString(params, "command")
+	result, err := terminal.ExecuteWithTimeout(ctx, p.manager, sessionID, command, args, timeout, options)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	out := map[string]any{
+		"output":    result.Output,
+		"truncated": result.Truncated,
+		"timedOut":  result.TimedOut,
+	}
+	if result.ExitCode != nil {
+		out["exitCode"] = *result.ExitCode
+	}
+	if result.Signal != nil {
+		out["signal"] = *result.Signal
+	}
+	return acp.ToolResult{Success: true, Result: out}, nil
+}
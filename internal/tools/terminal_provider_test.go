@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/client"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+	"github.com/spjoes/cursor-agent-acp/internal/permissions"
+	"github.com/spjoes/cursor-agent-acp/internal/terminal"
+	"github.com/spjoes/cursor-agent-acp/internal/toolcall"
+)
+
+// fakeTerminalClient is a client whose terminals exit at once with the
+// configured output.
+type fakeTerminalClient struct {
+	mu       sync.Mutex
+	created  []client.CreateTerminalRequest
+	released []string
+	output   string
+	exitCode int
+}
+
+func (f *fakeTerminalClient) ReadTextFile(context.Context, client.ReadTextFileRequest) (client.ReadTextFileResponse, error) {
+	return client.ReadTextFileResponse{}, nil
+}
+
+func (f *fakeTerminalClient) WriteTextFile(context.Context, client.WriteTextFileRequest) (client.WriteTextFileResponse, error) {
+	return client.WriteTextFileResponse{}, nil
+}
+
+func (f *fakeTerminalClient) ListDirectory(context.Context, client.ListDirectoryRequest) (client.ListDirectoryResponse, error) {
+	return client.ListDirectoryResponse{}, nil
+}
+
+func (f *fakeTerminalClient) CreateTerminal(_ context.Context, params client.CreateTerminalRequest) (client.CreateTerminalResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, params)
+	return client.CreateTerminalResponse{TerminalID: "term-1"}, nil
+}
+
+func (f *fakeTerminalClient) GetTerminalOutput(context.Context, client.TerminalOutputRequest) (client.TerminalOutputResponse, error) {
+	return client.TerminalOutputResponse{Output: f.output}, nil
+}
+
+func (f *fakeTerminalClient) WaitForTerminalExit(context.Context, client.WaitForTerminalExitRequest) (client.WaitForTerminalExitResponse, error) {
+	code := f.exitCode
+	return client.WaitForTerminalExitResponse{ExitCode: &code}, nil
+}
+
+func (f *fakeTerminalClient) KillTerminal(context.Context, client.KillTerminalRequest) error {
+	return nil
+}
+
+func (f *fakeTerminalClient) ReleaseTerminal(_ context.Context, params client.ReleaseTerminalRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = append(f.released, params.TerminalID)
+	return nil
+}
+
+func newTerminalRegistry(t *testing.T, conn client.Connection, cwd string) (*Registry, *terminal.Manager, *int) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Tools.Cursor.Enabled = false
+	logger := logging.NewWithOutput("error", io.Discard)
+	manager := terminal.NewManager(terminal.ManagerConfig{
+		ClientSupportsTerminals: true,
+		ForbiddenCommands:       cfg.Tools.Terminal.ForbiddenCommands,
+		CommandSafety:           cfg.Tools.Terminal.CommandSafety,
+	}, conn, logger)
+	t.Cleanup(manager.Close)
+	registry := NewRegistry(cfg, logger, nil)
+	registry.SetTerminalManager(manager)
+	registry.SetSessionCwdResolver(func(string) string { return cwd })
+	asked := 0
+	registry.SetToolCallManager(toolcall.NewManager(logger, func(map[string]any) {}, func(permissions.RequestPermissionParams) permissions.PermissionOutcome {
+		asked++
+		return permissions.PermissionOutcome{Outcome: "selected", OptionID: "allow-once"}
+	}))
+	return registry, manager, &asked
+}
+
+func TestRunCommandRunsInAManagedTerminal(t *testing.T) {
+	conn := &fakeTerminalClient{output: "ok\n"}
+	registry, manager, asked := newTerminalRegistry(t, conn, "/work")
+
+	call := ToolCall{Name: "run_command", Parameters: map[string]any{"command": "make", "args": []any{"test"}}}
+	result, _ := registry.ExecuteToolWithSession(context.Background(), call, "s1")
+	if !result.Success || *asked != 1 {
+		t.Fatalf("expected an approved run_command to succeed, got %s (asked %d)", result.Error, *asked)
+	}
+	out := result.Result.(map[string]any)
+	if out["output"] != "ok\n" || out["exitCode"] != 0 {
+		t.Fatalf("unexpected result %#v", out)
+	}
+	if len(conn.created) != 1 || conn.created[0].Command != "make" || conn.created[0].Cwd != "/work" || conn.created[0].SessionID != "s1" {
+		t.Fatalf("expected one terminal in the session cwd, got %#v", conn.created)
+	}
+	if len(conn.released) != 1 || len(manager.ActiveTerminals()) != 0 {
+		t.Fatalf("expected the terminal to be released, got %v (%d tracked)", conn.released, len(manager.ActiveTerminals()))
+	}
+}
+
+func TestRunCommandRefusesForbiddenCommandsWithoutAsking(t *testing.T) {
+	conn := &fakeTerminalClient{}
+	registry, _, asked := newTerminalRegistry(t, conn, "/work")
+
+	for _, params := range []map[string]any{
+		{"command": "rm", "args": []any{"-rf", "/"}},
+		{"command": "bash", "args": []any{"-c", "rm -rf /"}},
+		{"command": "env", "args": []any{"sudo", "true"}},
+	} {
+		result, _ := registry.ExecuteToolWithSession(context.Background(), ToolCall{Name: "run_command", Parameters: params}, "s1")
+		if result.Success || !strings.Contains(result.Error, "forbidden") {
+			t.Fatalf("expected %v to be refused, got %#v", params, result)
+		}
+	}
+	if *asked != 0 || len(conn.created) != 0 {
+		t.Fatalf("refused commands asked for permission %d times and created %d terminals", *asked, len(conn.created))
+	}
+}
@@ -0,0 +1,425 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+)
+
+// testRunner is a native test command for run_tests.
+type testRunner struct {
+	name    string
+	command func(pattern string, coverage bool) []string
+	parse   func(stdout, stderr string) map[string]any
+}
+
+var testFrameworks = []string{"go", "npm", "yarn", "pnpm", "jest", "vitest", "mocha", "pytest", "cargo", "make"}
+
+// detectTestRunner picks the runner named by framework or, for "" and
+// "auto", the first one whose project files are present in dir.
+func detectTestRunner(dir, framework string) (testRunner, error) {
+	if framework == "" || framework == "auto" {
+		switch {
+		case fileExists(dir, "go.mod"):
+			framework = "go"
+		case hasNpmTestScript(dir):
+			framework = "npm"
+			if fileExists(dir, "pnpm-lock.yaml") {
+				framework = "pnpm"
+			} else if fileExists(dir, "yarn.lock") {
+				framework = "yarn"
+			}
+		case fileExists(dir, "Cargo.toml"):
+			framework = "cargo"
+		case fileExists(dir, "pytest.ini") || fileExists(dir, "pyproject.toml") || fileExists(dir, "setup.py") ||
+			fileExists(dir, "setup.cfg") || fileExists(dir, "tox.ini") || fileExists(dir, "conftest.py"):
+			framework = "pytest"
+		case hasMakeTarget(dir, "test"):
+			framework = "make"
+		default:
+			return testRunner{}, fmt.Errorf("No test runner detected in %s; pass test_framework (one of %s)", dir, strings.Join(testFrameworks, ", "))
+		}
+	}
+
+	withArgs := func(base []string, extra ...string) []string {
+		out := append([]string{}, base...)
+		for _, arg := range extra {
+			if arg != "" {
+				out = append(out, arg)
+			}
+		}
+		return out
+	}
+
+	switch framework {
+	case "go":
+		return testRunner{name: "go", parse: parseGoTestRun, command: func(pattern string, coverage bool) []string {
+			argv := []string{"go", "test", "-json"}
+			if coverage {
+				argv = append(argv, "-cover")
+			}
+			if pattern != "" {
+				argv = append(argv, "-run="+pattern)
+			}
+			return append(argv, "./...")
+		}}, nil
+	case "npm", "yarn", "pnpm":
+		return testRunner{name: framework, parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			argv := []string{framework, "test"}
+			if framework == "npm" && (pattern != "" || coverage) {
+				argv = append(argv, "--")
+			}
+			return withArgs(argv, pattern, ternary(coverage, "--coverage", ""))
+		}}, nil
+	case "jest":
+		return testRunner{name: "jest", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			return withArgs([]string{"npx", "jest"}, pattern, ternary(coverage, "--coverage", ""))
+		}}, nil
+	case "vitest":
+		return testRunner{name: "vitest", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			return withArgs([]string{"npx", "vitest", "run"}, pattern, ternary(coverage, "--coverage", ""))
+		}}, nil
+	case "mocha":
+		return testRunner{name: "mocha", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			if pattern != "" {
+				return []string{"npx", "mocha", "--grep=" + pattern}
+			}
+			return []string{"npx", "mocha"}
+		}}, nil
+	case "pytest":
+		python := "python3"
+		if _, err := exec.LookPath(python); err != nil {
+			python = "python"
+		}
+		return testRunner{name: "pytest", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			argv := []string{python, "-m", "pytest", "-v"}
+			if pattern != "" {
+				argv = append(argv, "-k", pattern)
+			}
+			return withArgs(argv, ternary(coverage, "--cov", ""))
+		}}, nil
+	case "cargo":
+		return testRunner{name: "cargo", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			return withArgs([]string{"cargo", "test"}, pattern)
+		}}, nil
+	case "make":
+		return testRunner{name: "make", parse: parseTestResults, command: func(pattern string, coverage bool) []string {
+			return []string{"make", "test"}
+		}}, nil
+	}
+	return testRunner{}, fmt.Errorf("Unsupported test_framework %q; use one of %s", framework, strings.Join(testFrameworks, ", "))
+}
+
+// runTestCommand runs argv in dir with env as its environment, passing each output line to onLine (if
+// set) as it is written. A non-zero exit status is reported through
+// exitCode; err is only set when the command could not run.
+func runTestCommand(ctx context.Context, dir string, env []string, argv []string, onLine func(string)) (stdout, stderr string, exitCode int, err error) {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out := &lineWriter{onLine: onLine}
+	errOut := &lineWriter{onLine: onLine}
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", "", -1, ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return out.String(), errOut.String(), exitErr.ExitCode(), nil
+		}
+		return "", "", -1, fmt.Errorf("failed to run %s: %w", argv[0], err)
+	}
+	return out.String(), errOut.String(), 0, nil
+}
+
+// lineWriter collects output and hands complete lines to onLine. The buffer
+// is not embedded: io.Copy would use its ReadFrom and bypass Write.
+type lineWriter struct {
+	buf     bytes.Buffer
+	pending []byte
+	onLine  func(string)
+}
+
+func (w *lineWriter) String() string { return w.buf.String() }
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if w.onLine == nil {
+		return len(p), nil
+	}
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(w.pending[:i]))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+const (
+	testProgressInterval = 500 * time.Millisecond
+	testProgressTail     = 4 << 10
+)
+
+// testProgress turns test runner output into tool_call_update content: a
+// running pass/fail tally (for go test -json) and the tail of the output,
+// sent at most every testProgressInterval.
+type testProgress struct {
+	mu      sync.Mutex
+	report  func(map[string]any)
+	command string
+	goJSON  bool
+	tail    string
+	passed  int
+	failed  int
+	skipped int
+	last    time.Time
+}
+
+func (t *testProgress) line(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.goJSON {
+		line = t.goEventLine(line)
+		if line == "" {
+			return
+		}
+	}
+	t.tail += line + "\n"
+	if len(t.tail) > testProgressTail {
+		t.tail = t.tail[len(t.tail)-testProgressTail:]
+		if i := strings.IndexByte(t.tail, '\n'); i >= 0 {
+			t.tail = t.tail[i+1:]
+		}
+	}
+	if time.Since(t.last) < testProgressInterval {
+		return
+	}
+	t.last = time.Now()
+	text := "$ " + t.command + "\n"
+	if t.goJSON {
+		text += fmt.Sprintf("%d passed, %d failed, %d skipped so far\n", t.passed, t.failed, t.skipped)
+	}
+	t.report(map[string]any{
+		"content": []map[string]any{{"type": "content", "content": acp.ContentBlock{Type: "text", Text: text + t.tail}}},
+	})
+}
+
+// goEventLine renders a go test -json event as a short status line, or ""
+// for events not worth showing.
+func (t *testProgress) goEventLine(line string) string {
+	var ev struct {
+		Action  string
+		Package string
+		Test    string
+		Elapsed float64
+	}
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil {
+		return line
+	}
+	switch ev.Action {
+	case "pass", "fail", "skip":
+	default:
+		return ""
+	}
+	if ev.Test == "" {
+		return fmt.Sprintf("%s\t%s\t%.2fs", strings.ToUpper(ev.Action), ev.Package, ev.Elapsed)
+	}
+	switch ev.Action {
+	case "pass":
+		t.passed++
+	case "fail":
+		t.failed++
+	case "skip":
+		t.skipped++
+	}
+	return fmt.Sprintf("--- %s: %s (%.2fs)", strings.ToUpper(ev.Action), ev.Test, ev.Elapsed)
+}
+
+func parseGoTestRun(stdout, stderr string) map[string]any {
+	report := parseGoTestJSON(stdout + stderr)
+	return map[string]any{
+		"packages": report.packages,
+		"tests":    report.failures,
+		"summary": map[string]any{
+			"total":   report.passed + report.failed + report.skipped,
+			"passed":  report.passed,
+			"failed":  report.failed,
+			"skipped": report.skipped,
+		},
+		"buildOutput": truncateText(strings.Join(report.buildOutput, ""), maxGoOutputText),
+	}
+}
+
+// testCountPattern matches runner summaries such as pytest's "3 passed, 1
+// failed", jest's "Tests: 1 failed, 3 passed, 4 total" and cargo's "3
+// passed; 0 failed; 1 ignored".
+var testCountPattern = regexp.MustCompile(`(\d+) (passed|failed|skipped|ignored|pending|errors?)\b`)
+
+var testLinePattern = regexp.MustCompile(`(PASS|FAIL|SKIP)\s+(.+?)(?:\s+\((\d+(?:\.\d+)?)s\))?$`)
+
+// pytestLinePattern matches pytest -v lines such as
+// "tests/test_api.py::TestUser::test_create PASSED [ 50%]".
+var pytestLinePattern = regexp.MustCompile(`^(\S+?\.py)::(\S+)\s+(PASSED|FAILED|SKIPPED|ERROR|XFAIL|XPASS)\b`)
+
+// cargoLinePattern matches cargo test lines such as "test parser::tests::empty ... ok".
+var cargoLinePattern = regexp.MustCompile(`^test (\S+) \.\.\. (ok|FAILED|ignored)`)
+
+var testStatuses = map[string]string{
+	"PASS": "pass", "PASSED": "pass", "XPASS": "pass", "ok": "pass",
+	"FAIL": "fail", "FAILED": "fail", "ERROR": "fail",
+	"SKIP": "skip", "SKIPPED": "skip", "XFAIL": "skip", "ignored": "skip",
+}
+
+func parseTestResults(stdout, stderr string) map[string]any {
+	combined := stdout + "\n" + stderr
+
+	tests := make([]map[string]any, 0)
+	for _, line := range strings.Split(combined, "\n") {
+		line = strings.TrimSpace(line)
+		if m := pytestLinePattern.FindStringSubmatch(line); m != nil {
+			suite, name := "", m[2]
+			if i := strings.LastIndex(name, "::"); i >= 0 {
+				suite, name = name[:i], name[i+2:]
+			}
+			tests = append(tests, map[string]any{"file": m[1], "suite": suite, "test": name, "status": testStatuses[m[3]]})
+			continue
+		}
+		if m := cargoLinePattern.FindStringSubmatch(line); m != nil {
+			suite, name := "", m[1]
+			if i := strings.LastIndex(name, "::"); i >= 0 {
+				suite, name = name[:i], name[i+2:]
+			}
+			tests = append(tests, map[string]any{"file": "", "suite": suite, "test": name, "status": testStatuses[m[2]]})
+			continue
+		}
+		m := testLinePattern.FindStringSubmatch(line)
+		if len(m) > 0 {
+			test := map[string]any{"file": m[2], "suite": "", "test": m[2], "status": testStatuses[m[1]]}
+			if m[3] != "" {
+				if d, err := strconv.ParseFloat(m[3], 64); err == nil {
+					test["duration"] = d
+				}
+			}
+			tests = append(tests, test)
+		}
+	}
+
+	summary := map[string]any{
+		"total":   len(tests),
+		"passed":  countStatus(tests, "pass"),
+		"failed":  countStatus(tests, "fail"),
+		"skipped": countStatus(tests, "skip"),
+	}
+	// A runner's own summary line is more reliable than per-test lines.
+	for _, line := range strings.Split(combined, "\n") {
+		counts := testCountPattern.FindAllStringSubmatch(line, -1)
+		if len(counts) == 0 {
+			continue
+		}
+		found := map[string]int{}
+		for _, c := range counts {
+			n, _ := strconv.Atoi(c[1])
+			switch c[2] {
+			case "passed":
+				found["passed"] += n
+			case "failed", "error", "errors":
+				found["failed"] += n
+			default:
+				found["skipped"] += n
+			}
+		}
+		summary = map[string]any{
+			"total":   found["passed"] + found["failed"] + found["skipped"],
+			"passed":  found["passed"],
+			"failed":  found["failed"],
+			"skipped": found["skipped"],
+		}
+	}
+
+	return map[string]any{
+		"tests":   tests,
+		"summary": summary,
+		"raw":     truncateText(combined, maxGoOutputText),
+	}
+}
+
+func countStatus(tests []map[string]any, status string) int {
+	count := 0
+	for _, t := range tests {
+		if strings.Contains(strings.ToLower(fmt.Sprint(t["status"])), status) {
+			count++
+		}
+	}
+	return count
+}
+
+func ternary(cond bool, whenTrue string, whenFalse string) string {
+	if cond {
+		return whenTrue
+	}
+	return whenFalse
+}
+
+func fileExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+// hasNpmTestScript reports whether package.json defines a test script other
+// than the placeholder npm init writes.
+func hasNpmTestScript(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return false
+	}
+	script := pkg.Scripts["test"]
+	return script != "" && !strings.Contains(script, "no test specified")
+}
+
+func hasMakeTarget(dir, target string) bool {
+	return slices.Contains(makeTargets(dir), target)
+}
+
+var makeTargetPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.-]*)\s*:([^=]|$)`)
+
+func makeTargets(dir string) []string {
+	var data []byte
+	for _, name := range []string{"GNUmakefile", "makefile", "Makefile"} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			data = b
+			break
+		}
+	}
+	targets := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := makeTargetPattern.FindStringSubmatch(line); m != nil && !slices.Contains(targets, m[1]) {
+			targets = append(targets, m[1])
+		}
+	}
+	return targets
+}
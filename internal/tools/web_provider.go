@@ -0,0 +1,375 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/htmlmd"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	defaultWebUserAgent = "cursor-agent-acp (+https://github.com/spjoes/cursor-agent-acp)"
+	maxWebRedirects     = 5
+)
+
+var errPrivateAddress = errors.New("private network address")
+
+type WebProvider struct {
+	cfg    config.Config
+	logger *logging.Logger
+	client *http.Client
+
+	searcher *webSearcher
+
+	mu    sync.Mutex
+	cache map[string]webCacheEntry
+}
+
+type webCacheEntry struct {
+	result    map[string]any
+	fetchedAt time.Time
+}
+
+func NewWebProvider(cfg config.Config, logger *logging.Logger) *WebProvider {
+	p := &WebProvider{cfg: cfg, logger: logger, cache: map[string]webCacheEntry{}}
+	webCfg := cfg.Tools.Web
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !webCfg.AllowPrivateNetworks {
+		// Checked after DNS resolution so hostnames pointing at internal
+		// addresses are refused too.
+		dialer.Control = func(_ string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && isPrivateIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	// Requests are bounded by the tool call's context, with the configured
+	// timeout applied to it in fetch, rather than by a client-wide timeout.
+	p.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebRedirects)
+			}
+			return p.checkURL(req.URL)
+		},
+	}
+
+	if searcher, reason := newWebSearcher(webCfg); searcher != nil {
+		p.searcher = searcher
+	} else if webCfg.Search.Enabled {
+		logger.Warn("web_search is enabled but unavailable", map[string]any{"provider": webCfg.Search.Provider, "reason": reason})
+	}
+	return p
+}
+
+func (p *WebProvider) Name() string {
+	return "web"
+}
+
+func (p *WebProvider) Description() string {
+	return "Fetch web pages (converted to Markdown) within the configured domain allow/deny lists, and search the web via a configured search API"
+}
+
+func (p *WebProvider) GetTools() []Tool {
+	tools := make([]Tool, 0, 2)
+	if p.cfg.Tools.Web.Enabled {
+		tools = append(tools, Tool{
+			Name:        "fetch_url",
+			Description: "Fetch a web page or text document over HTTP(S). HTML is converted to Markdown. Responses are size-limited and cached briefly.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"url":       map[string]any{"type": "string", "description": "Absolute http(s) URL to fetch"},
+					"format":    map[string]any{"type": "string", "enum": []string{"markdown", "text", "raw"}, "description": "Optional: Output format for HTML pages (default markdown)"},
+					"max_bytes": map[string]any{"type": "number", "description": "Optional: Maximum number of bytes to read (capped by tools.web.maxResponseBytes)"},
+					"no_cache":  map[string]any{"type": "boolean", "description": "Optional: Bypass the response cache"},
+				},
+				"required": []string{"url"},
+			},
+			Handler: p.fetchURL,
+		})
+	}
+	if p.searcher != nil {
+		tools = append(tools, Tool{
+			Name:        "web_search",
+			Description: "Search the web and return result titles, URLs and snippets. Use fetch_url to read a result.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":       map[string]any{"type": "string", "description": "Search query"},
+					"max_results": map[string]any{"type": "number", "minimum": 1, "maximum": 20, "description": "Optional: Number of results (1-20, default from tools.web.search.maxResults)"},
+				},
+				"required": []string{"query"},
+			},
+			Handler: p.webSearch,
+		})
+	}
+	return tools
+}
+
+func (p *WebProvider) Cleanup() error {
+	p.mu.Lock()
+	p.cache = map[string]webCacheEntry{}
+	p.mu.Unlock()
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+func (p *WebProvider) fetchURL(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	raw := strings.TrimSpace(getString(params, "url"))
+	if raw == "" {
+		return acp.ToolResult{Success: false, Error: "url is required and must be a non-empty string"}, nil
+	}
+	target, err := url.Parse(raw)
+	if err != nil || target.Host == "" {
+		return acp.ToolResult{Success: false, Error: "Invalid URL: " + raw}, nil
+	}
+	if err := p.checkURL(target); err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	format := getString(params, "format")
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "text" && format != "raw" {
+		return acp.ToolResult{Success: false, Error: "format must be one of markdown, text, raw"}, nil
+	}
+	limit := p.cfg.Tools.Web.MaxResponseBytes
+	if n := int64(getInt(params, "max_bytes", 0)); n > 0 && (limit <= 0 || n < limit) {
+		limit = n
+	}
+
+	cacheKey := fmt.Sprintf("%s|%s|%d", target.String(), format, limit)
+	if !getBool(params, "no_cache", false) {
+		if cached, ok := p.cached(cacheKey); ok {
+			cached["cached"] = true
+			return webResult(cached), nil
+		}
+	}
+
+	result, err := p.fetch(ctx, target, format, limit)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	result["cached"] = false
+	if status, _ := result["status"].(int); status >= 400 {
+		// Error pages are returned for the agent to read but not cached, so
+		// the next fetch sees the page once it is back.
+		failed := webResult(result)
+		failed.Success = false
+		failed.Error = fmt.Sprintf("HTTP %d fetching %s", status, target)
+		return failed, nil
+	}
+	p.store(cacheKey, result)
+	return webResult(result), nil
+}
+
+func (p *WebProvider) fetch(ctx context.Context, target *url.URL, format string, limit int64) (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.cfg.Tools.Web.Timeout)*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := p.cfg.Tools.Web.UserAgent
+	if userAgent == "" {
+		userAgent = defaultWebUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,text/markdown,text/plain,application/json;q=0.9,*/*;q=0.5")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, fmt.Errorf("Access to %s is not allowed: it resolves to a private network address", target.Host)
+		}
+		return nil, fmt.Errorf("Failed to fetch %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "" && !isTextualMediaType(mediaType) {
+		return nil, fmt.Errorf("Unsupported content type %s for %s (only text documents can be fetched)", mediaType, target)
+	}
+
+	reader := io.Reader(resp.Body)
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read response from %s: %v", target, err)
+	}
+	truncated := false
+	if limit > 0 && int64(len(body)) > limit {
+		body = body[:limit]
+		truncated = true
+	}
+
+	text := string(body)
+	title := ""
+	isHTML := mediaType == "text/html" || mediaType == "application/xhtml+xml" || (mediaType == "" && strings.Contains(strings.ToLower(text[:min(len(text), 512)]), "<html"))
+	if isHTML {
+		title = htmlmd.Title(text)
+		switch format {
+		case "markdown":
+			text = htmlmd.Convert(text)
+		case "text":
+			text = markdownToText(htmlmd.Convert(text))
+		}
+	}
+
+	result := map[string]any{
+		"url":         target.String(),
+		"finalUrl":    resp.Request.URL.String(),
+		"status":      resp.StatusCode,
+		"contentType": contentType,
+		"title":       title,
+		"format":      format,
+		"content":     text,
+		"bytes":       len(body),
+		"truncated":   truncated,
+	}
+	return result, nil
+}
+
+// checkURL enforces the scheme and the domain allow/deny lists.
+func (p *WebProvider) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Access to %s is not allowed: only http and https URLs can be fetched", u.String())
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, denied := range p.cfg.Tools.Web.DeniedDomains {
+		if domainMatches(host, denied) {
+			return fmt.Errorf("Access to %s is not allowed: domain is in tools.web.deniedDomains", host)
+		}
+	}
+	if allowed := p.cfg.Tools.Web.AllowedDomains; len(allowed) > 0 {
+		for _, a := range allowed {
+			if domainMatches(host, a) {
+				return nil
+			}
+		}
+		return fmt.Errorf("Access to %s is not allowed: domain is not in tools.web.allowedDomains", host)
+	}
+	return nil
+}
+
+func (p *WebProvider) cached(key string) (map[string]any, bool) {
+	ttl := time.Duration(p.cfg.Tools.Web.CacheTTL) * time.Millisecond
+	if ttl <= 0 {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.fetchedAt) > ttl {
+		delete(p.cache, key)
+		return nil, false
+	}
+	return cloneMap(entry.result), true
+}
+
+func (p *WebProvider) store(key string, result map[string]any) {
+	if p.cfg.Tools.Web.CacheTTL <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if max := p.cfg.Tools.Web.MaxCacheEntries; max > 0 && len(p.cache) >= max {
+		oldestKey := ""
+		var oldest time.Time
+		for k, e := range p.cache {
+			if oldestKey == "" || e.fetchedAt.Before(oldest) {
+				oldestKey, oldest = k, e.fetchedAt
+			}
+		}
+		delete(p.cache, oldestKey)
+	}
+	p.cache[key] = webCacheEntry{result: cloneMap(result), fetchedAt: time.Now()}
+}
+
+func webResult(result map[string]any) acp.ToolResult {
+	text, _ := result["content"].(string)
+	mimeType := "text/plain"
+	if result["format"] == "markdown" {
+		mimeType = "text/markdown"
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result:  result,
+		Metadata: map[string]any{
+			"content": []any{acp.ContentBlock{
+				Type:     "resource",
+				Resource: &acp.EmbeddedResource{URI: fmt.Sprint(result["finalUrl"]), MimeType: mimeType, Text: text},
+			}},
+		},
+	}
+}
+
+// domainMatches reports whether host is pattern or a subdomain of it.
+// A leading "*." or "." in pattern is accepted and means the same thing.
+func domainMatches(host string, pattern string) bool {
+	pattern = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(pattern)), "*"), ".")
+	if pattern == "" {
+		return false
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+func isTextualMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/xhtml+xml", "application/javascript",
+		"application/x-yaml", "application/yaml", "application/toml", "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// markdownToText strips the Markdown syntax htmlmd emits.
+func markdownToText(md string) string {
+	replacer := strings.NewReplacer("**", "", "```\n", "", "```", "", "`", "")
+	lines := strings.Split(replacer.Replace(md), "\n")
+	for i, line := range lines {
+		line = strings.TrimLeft(line, "#")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
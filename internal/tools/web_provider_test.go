@@ -0,0 +1,201 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+func newTestWebProvider(t *testing.T, configure func(*config.WebToolsConfig)) *WebProvider {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Tools.Web.AllowPrivateNetworks = true
+	if configure != nil {
+		configure(&cfg.Tools.Web)
+	}
+	return NewWebProvider(cfg, logging.NewWithOutput("error", io.Discard))
+}
+
+func TestFetchURLConvertsHTMLAndCaches(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><title>Docs</title></head><body><h2>Usage</h2><p>Call <code>Run()</code>.</p></body></html>`)
+	}))
+	defer server.Close()
+	provider := newTestWebProvider(t, nil)
+
+	result, _ := provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/docs"})
+	if !result.Success {
+		t.Fatalf("fetch_url failed: %s", result.Error)
+	}
+	payload := result.Result.(map[string]any)
+	if payload["title"] != "Docs" || payload["content"] != "## Usage\n\nCall `Run()`.\n" || payload["cached"] != false {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+
+	result, _ = provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/docs"})
+	if result.Result.(map[string]any)["cached"] != true || hits.Load() != 1 {
+		t.Fatalf("expected cached second fetch, hits=%d", hits.Load())
+	}
+	_, _ = provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/docs", "no_cache": true})
+	if hits.Load() != 2 {
+		t.Fatalf("expected no_cache to refetch, hits=%d", hits.Load())
+	}
+}
+
+func TestFetchURLFailsOnErrorStatusWithoutCaching(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "down for maintenance")
+	}))
+	defer server.Close()
+	provider := newTestWebProvider(t, nil)
+
+	for range 2 {
+		result, _ := provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/status"})
+		if result.Success || !strings.Contains(result.Error, "HTTP 503") || result.Result.(map[string]any)["content"] != "down for maintenance" {
+			t.Fatalf("expected a failed result with the error page, got %#v", result)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("expected error responses not to be cached, hits=%d", hits.Load())
+	}
+}
+
+func TestFetchURLEnforcesLimitsAndPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, strings.Repeat("a", 100))
+		}
+	}))
+	defer server.Close()
+
+	provider := newTestWebProvider(t, func(w *config.WebToolsConfig) { w.MaxResponseBytes = 10 })
+	result, _ := provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/big"})
+	payload := result.Result.(map[string]any)
+	if payload["content"] != "aaaaaaaaaa" || payload["truncated"] != true {
+		t.Fatalf("expected truncated body, got %#v", payload)
+	}
+	if result, _ := provider.fetchURL(context.Background(), map[string]any{"url": server.URL + "/image"}); result.Success || !strings.Contains(result.Error, "Unsupported content type") {
+		t.Fatalf("expected binary content to be refused, got %#v", result)
+	}
+	if result, _ := provider.fetchURL(context.Background(), map[string]any{"url": "file:///etc/passwd"}); result.Success {
+		t.Fatalf("expected non-http scheme to be refused")
+	}
+
+	denied := newTestWebProvider(t, func(w *config.WebToolsConfig) { w.DeniedDomains = []string{"127.0.0.1"} })
+	if result, _ := denied.fetchURL(context.Background(), map[string]any{"url": server.URL}); result.Success || !strings.Contains(result.Error, "deniedDomains") {
+		t.Fatalf("expected denied domain to be refused, got %#v", result)
+	}
+	allowList := newTestWebProvider(t, func(w *config.WebToolsConfig) { w.AllowedDomains = []string{"*.go.dev"} })
+	if result, _ := allowList.fetchURL(context.Background(), map[string]any{"url": server.URL}); result.Success || !strings.Contains(result.Error, "allowedDomains") {
+		t.Fatalf("expected host outside allow list to be refused, got %#v", result)
+	}
+	if !domainMatches("pkg.go.dev", "*.go.dev") || domainMatches("evilgo.dev", "go.dev") {
+		t.Fatalf("unexpected domain matching")
+	}
+
+	private := newTestWebProvider(t, func(w *config.WebToolsConfig) { w.AllowPrivateNetworks = false })
+	if result, _ := private.fetchURL(context.Background(), map[string]any{"url": server.URL}); result.Success || !strings.Contains(result.Error, "private network") {
+		t.Fatalf("expected loopback fetch to be refused, got %#v", result)
+	}
+}
+
+func TestWebSearchReturnsResultsAndRateLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Subscription-Token") != "secret" || r.URL.Query().Get("q") != "go generics" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"web":{"results":[{"title":"Tutorial","url":"https://go.dev/doc/tutorial/generics","description":"Intro to <strong>generics</strong> &amp; more"},{"title":"No URL"}]}}`)
+	}))
+	defer server.Close()
+	t.Setenv("TEST_SEARCH_KEY", "secret")
+	provider := newTestWebProvider(t, func(web *config.WebToolsConfig) {
+		web.Search = config.WebSearchConfig{Enabled: true, Provider: "brave", Endpoint: server.URL, APIKeyEnv: "TEST_SEARCH_KEY", MaxResults: 5, RateLimitPerMinute: 1}
+	})
+	if names := toolNames(provider.GetTools()); !strings.Contains(names, "web_search") {
+		t.Fatalf("expected web_search tool, got %s", names)
+	}
+
+	result, _ := provider.webSearch(context.Background(), map[string]any{"query": "go generics"})
+	if !result.Success {
+		t.Fatalf("web_search failed: %s", result.Error)
+	}
+	results := result.Result.(map[string]any)["results"].([]webSearchResult)
+	if len(results) != 1 || results[0].URL != "https://go.dev/doc/tutorial/generics" || results[0].Snippet != "Intro to generics & more" {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+	if blocks := result.Metadata["content"].([]any); len(blocks) != 1 {
+		t.Fatalf("expected one resource_link block, got %#v", blocks)
+	}
+
+	result, _ = provider.webSearch(context.Background(), map[string]any{"query": "go generics"})
+	if result.Success || !strings.Contains(result.Error, "rate limit") {
+		t.Fatalf("expected rate limit error, got %#v", result)
+	}
+}
+
+func TestWebSearchStopsWithTheToolCall(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	t.Setenv("TEST_SEARCH_KEY", "secret")
+	provider := newTestWebProvider(t, func(web *config.WebToolsConfig) {
+		web.Timeout = 60_000
+		web.Search = config.WebSearchConfig{Enabled: true, Provider: "brave", Endpoint: server.URL, APIKeyEnv: "TEST_SEARCH_KEY", MaxResults: 5}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, _ := provider.webSearch(ctx, map[string]any{"query": "slow"})
+	if result.Success || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the search to end with the tool call, got %+v after %s", result, time.Since(start))
+	}
+}
+
+func TestWebSearchRequiresAPIKey(t *testing.T) {
+	t.Setenv("TEST_SEARCH_KEY", "")
+	provider := newTestWebProvider(t, func(web *config.WebToolsConfig) {
+		web.Search.Enabled = true
+		web.Search.APIKeyEnv = "TEST_SEARCH_KEY"
+	})
+	if names := toolNames(provider.GetTools()); strings.Contains(names, "web_search") {
+		t.Fatalf("web_search must not be offered without an API key, got %s", names)
+	}
+}
+
+func toolNames(tools []Tool) string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,235 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/acp"
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+)
+
+const maxSearchResponseBytes = 1 << 20
+
+var defaultSearchEndpoints = map[string]string{
+	"brave":  "https://api.search.brave.com/res/v1/web/search",
+	"tavily": "https://api.tavily.com/search",
+}
+
+type webSearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// searchRateLimiter allows at most limit calls in any rolling minute.
+type searchRateLimiter struct {
+	limit int
+	now   func() time.Time
+
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (l *searchRateLimiter) allow() (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	cutoff := now.Add(-time.Minute)
+	kept := l.calls[:0]
+	for _, t := range l.calls {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	l.calls = kept
+	if len(l.calls) >= l.limit {
+		return false, l.calls[0].Sub(cutoff)
+	}
+	l.calls = append(l.calls, now)
+	return true, 0
+}
+
+type webSearcher struct {
+	cfg    config.WebSearchConfig
+	apiKey string
+	client *http.Client
+	// timeout bounds each search within the tool call's context.
+	timeout time.Duration
+	limiter *searchRateLimiter
+}
+
+// newWebSearcher returns nil (with a reason) when search is not usable.
+func newWebSearcher(cfg config.WebToolsConfig) (*webSearcher, string) {
+	search := cfg.Search
+	if !search.Enabled {
+		return nil, "disabled"
+	}
+	apiKey := ""
+	if search.APIKeyEnv != "" {
+		apiKey = strings.TrimSpace(os.Getenv(search.APIKeyEnv))
+	}
+	if apiKey == "" && search.Provider != "searxng" {
+		return nil, fmt.Sprintf("no API key in $%s", search.APIKeyEnv)
+	}
+	if search.Endpoint == "" {
+		search.Endpoint = defaultSearchEndpoints[search.Provider]
+	}
+	if search.Endpoint == "" {
+		return nil, "no endpoint configured for provider " + search.Provider
+	}
+	return &webSearcher{
+		cfg:     search,
+		apiKey:  apiKey,
+		client:  &http.Client{},
+		timeout: time.Duration(cfg.Timeout) * time.Millisecond,
+		limiter: &searchRateLimiter{limit: search.RateLimitPerMinute, now: time.Now},
+	}, ""
+}
+
+func (p *WebProvider) webSearch(ctx context.Context, params map[string]any) (acp.ToolResult, error) {
+	query := strings.TrimSpace(getString(params, "query"))
+	if query == "" {
+		return acp.ToolResult{Success: false, Error: "query is required and must be a non-empty string"}, nil
+	}
+	count := getInt(params, "max_results", p.searcher.cfg.MaxResults)
+	count = min(max(count, 1), 20)
+	if ok, wait := p.searcher.limiter.allow(); !ok {
+		return acp.ToolResult{Success: false, Error: fmt.Sprintf("web_search rate limit reached (%d per minute); retry in %ds", p.searcher.limiter.limit, int(wait.Seconds())+1)}, nil
+	}
+
+	results, err := p.searcher.search(ctx, query, count)
+	if err != nil {
+		return acp.ToolResult{Success: false, Error: err.Error()}, nil
+	}
+	if len(results) > count {
+		results = results[:count]
+	}
+
+	blocks := make([]any, 0, len(results))
+	for _, r := range results {
+		blocks = append(blocks, acp.ContentBlock{Type: "resource_link", URI: r.URL, Name: r.Title, Title: r.Title, Description: r.Snippet})
+	}
+	return acp.ToolResult{
+		Success: true,
+		Result: map[string]any{
+			"query":    query,
+			"provider": p.searcher.cfg.Provider,
+			"results":  results,
+			"count":    len(results),
+		},
+		Metadata: map[string]any{"content": blocks},
+	}, nil
+}
+
+func (s *webSearcher) search(ctx context.Context, query string, count int) ([]webSearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	switch s.cfg.Provider {
+	case "brave":
+		u := s.cfg.Endpoint + "?" + url.Values{"q": {query}, "count": {fmt.Sprint(count)}}.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err == nil {
+			req.Header.Set("X-Subscription-Token", s.apiKey)
+		}
+	case "tavily":
+		body, _ := json.Marshal(map[string]any{"api_key": s.apiKey, "query": query, "max_results": count})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.Endpoint, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "searxng":
+		u := strings.TrimRight(s.cfg.Endpoint, "/") + "/search?" + url.Values{"q": {query}, "format": {"json"}}.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err == nil && s.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+s.apiKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported search provider: %s", s.cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("web_search request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxSearchResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("web_search response could not be read: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("web_search provider %s returned HTTP %d: %s", s.cfg.Provider, resp.StatusCode, strings.TrimSpace(string(raw[:min(len(raw), 200)])))
+	}
+	return parseSearchResponse(s.cfg.Provider, raw)
+}
+
+func parseSearchResponse(provider string, raw []byte) ([]webSearchResult, error) {
+	type item struct {
+		Title       string `json:"title"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Content     string `json:"content"`
+	}
+	var payload struct {
+		Web struct {
+			Results []item `json:"results"`
+		} `json:"web"`
+		Results []item `json:"results"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("invalid %s search response: %w", provider, err)
+	}
+	items := payload.Results
+	if provider == "brave" {
+		items = payload.Web.Results
+	}
+	results := make([]webSearchResult, 0, len(items))
+	for _, it := range items {
+		if it.URL == "" {
+			continue
+		}
+		snippet := it.Description
+		if snippet == "" {
+			snippet = it.Content
+		}
+		results = append(results, webSearchResult{Title: it.Title, URL: it.URL, Snippet: stripTags(snippet)})
+	}
+	return results, nil
+}
+
+// stripTags removes the <strong> highlighting and entities some providers
+// put in snippets.
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(b.String()))
+}
@@ -0,0 +1,399 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/ignore"
+)
+
+var identifierPattern = regexp.MustCompile(`[A-Za-z_$][A-Za-z0-9_$]*`)
+
+type indexedSymbol struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Container string `json:"container,omitempty"`
+}
+
+type indexedFile struct {
+	rel     string
+	size    int64
+	modTime time.Time
+	symbols []indexedSymbol
+	// identifiers are the distinct identifiers in the file, used to find
+	// the files that can contain a reference without reading every file.
+	identifiers []string
+}
+
+// workspaceIndex is a file, symbol and identifier index of one directory
+// tree (honoring .gitignore), kept current by re-scanning it and
+// re-indexing files whose size or modification time changed.
+type workspaceIndex struct {
+	root        string
+	maxFiles    int
+	maxFileSize int64
+
+	ready chan struct{}
+
+	mu          sync.RWMutex
+	files       map[string]*indexedFile
+	identifiers map[string]map[string]struct{}
+	truncated   bool
+	scannedAt   time.Time
+}
+
+func newWorkspaceIndex(root string, maxFiles int, maxFileSize int64) *workspaceIndex {
+	return &workspaceIndex{
+		root:        root,
+		maxFiles:    maxFiles,
+		maxFileSize: maxFileSize,
+		ready:       make(chan struct{}),
+		files:       make(map[string]*indexedFile),
+		identifiers: make(map[string]map[string]struct{}),
+	}
+}
+
+// run builds the index, then refreshes it every interval until ctx is done.
+func (ix *workspaceIndex) run(ctx context.Context, interval time.Duration) {
+	ix.refresh()
+	close(ix.ready)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ix.refresh()
+		}
+	}
+}
+
+// wait blocks until the first scan has finished.
+func (ix *workspaceIndex) wait(ctx context.Context) error {
+	select {
+	case <-ix.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refresh re-scans the tree and returns the number of files added, changed
+// or removed. Files are read and parsed without holding the lock.
+func (ix *workspaceIndex) refresh() int {
+	type stat struct {
+		rel     string
+		size    int64
+		modTime time.Time
+	}
+	seen := make(map[string]stat)
+	truncated := false
+	_ = ignore.Walk(ix.root, nil, func(p string, rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		if len(seen) >= ix.maxFiles {
+			truncated = true
+			return fs.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		seen[p] = stat{rel: rel, size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+
+	ix.mu.RLock()
+	stale := make([]string, 0)
+	for p, st := range seen {
+		if f, ok := ix.files[p]; !ok || f.size != st.size || !f.modTime.Equal(st.modTime) {
+			stale = append(stale, p)
+		}
+	}
+	removed := make([]string, 0)
+	for p := range ix.files {
+		if _, ok := seen[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	ix.mu.RUnlock()
+
+	updated := make([]*indexedFile, 0, len(stale))
+	for _, p := range stale {
+		st := seen[p]
+		updated = append(updated, ix.indexFile(p, st.rel, st.size, st.modTime))
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, p := range removed {
+		ix.drop(p)
+	}
+	for i, p := range stale {
+		ix.drop(p)
+		ix.files[p] = updated[i]
+		for _, id := range updated[i].identifiers {
+			set := ix.identifiers[id]
+			if set == nil {
+				set = make(map[string]struct{})
+				ix.identifiers[id] = set
+			}
+			set[p] = struct{}{}
+		}
+	}
+	ix.truncated = truncated
+	ix.scannedAt = time.Now()
+	return len(stale) + len(removed)
+}
+
+// drop removes p from the index. The caller holds the write lock.
+func (ix *workspaceIndex) drop(p string) {
+	f, ok := ix.files[p]
+	if !ok {
+		return
+	}
+	for _, id := range f.identifiers {
+		delete(ix.identifiers[id], p)
+		if len(ix.identifiers[id]) == 0 {
+			delete(ix.identifiers, id)
+		}
+	}
+	delete(ix.files, p)
+}
+
+// indexFile extracts symbols and identifiers from source files in a known
+// language; other files are indexed by name only.
+func (ix *workspaceIndex) indexFile(p, rel string, size int64, modTime time.Time) *indexedFile {
+	f := &indexedFile{rel: rel, size: size, modTime: modTime}
+	if languageByExt[strings.ToLower(filepath.Ext(p))] == "" || size > ix.maxFileSize {
+		return f
+	}
+	src, err := os.ReadFile(p)
+	if err != nil {
+		return f
+	}
+	for _, sym := range analyzeSource(p, src).Symbols {
+		name, _ := sym["name"].(string)
+		kind, _ := sym["kind"].(string)
+		line, _ := sym["line"].(int)
+		container, _ := sym["receiver"].(string)
+		f.symbols = append(f.symbols, indexedSymbol{Name: name, Kind: kind, Path: p, Line: line, Container: container})
+	}
+	seen := make(map[string]bool)
+	for _, id := range identifierPattern.FindAllString(string(src), -1) {
+		if !seen[id] {
+			seen[id] = true
+			f.identifiers = append(f.identifiers, id)
+		}
+	}
+	return f
+}
+
+type indexStats struct {
+	Files     int       `json:"files"`
+	Truncated bool      `json:"truncated"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+func (ix *workspaceIndex) stats() indexStats {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return indexStats{Files: len(ix.files), Truncated: ix.truncated, ScannedAt: ix.scannedAt}
+}
+
+// findFiles matches query against relative paths: a glob (when it contains
+// *, ? or [) or, otherwise, an exact, prefix or substring match of the file
+// name, a substring of the path, or the query's characters in order. Better
+// matches and shorter paths come first.
+func (ix *workspaceIndex) findFiles(query string, limit int) []string {
+	query = strings.ToLower(query)
+	glob := strings.ContainsAny(query, "*?[")
+	type scored struct {
+		path  string
+		rel   string
+		score int
+	}
+	matches := make([]scored, 0)
+	ix.mu.RLock()
+	for p, f := range ix.files {
+		rel := strings.ToLower(f.rel)
+		base := path.Base(rel)
+		score := -1
+		switch {
+		case glob:
+			if ok, _ := path.Match(query, rel); ok {
+				score = 0
+			} else if ok, _ := path.Match(query, base); ok {
+				score = 1
+			}
+		case base == query:
+			score = 0
+		case strings.HasPrefix(base, query):
+			score = 1
+		case strings.Contains(base, query):
+			score = 2
+		case strings.Contains(rel, query):
+			score = 3
+		case isSubsequence(query, rel):
+			score = 4
+		}
+		if score >= 0 {
+			matches = append(matches, scored{path: p, rel: f.rel, score: score})
+		}
+	}
+	ix.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		if len(matches[i].rel) != len(matches[j].rel) {
+			return len(matches[i].rel) < len(matches[j].rel)
+		}
+		return matches[i].rel < matches[j].rel
+	})
+	out := make([]string, 0, min(len(matches), limit))
+	for _, m := range matches[:min(len(matches), limit)] {
+		out = append(out, m.path)
+	}
+	return out
+}
+
+func isSubsequence(needle, haystack string) bool {
+	i := 0
+	for j := 0; i < len(needle) && j < len(haystack); j++ {
+		if needle[i] == haystack[j] {
+			i++
+		}
+	}
+	return i == len(needle)
+}
+
+// findDefinitions returns symbols named name ("exact"), starting with it
+// ("prefix") or containing it ("contains", case-insensitive), optionally of
+// one kind, ordered by path and line.
+func (ix *workspaceIndex) findDefinitions(name, kind, match string, limit int) []indexedSymbol {
+	lower := strings.ToLower(name)
+	out := make([]indexedSymbol, 0)
+	ix.mu.RLock()
+	for _, f := range ix.files {
+		for _, sym := range f.symbols {
+			if kind != "" && sym.Kind != kind {
+				continue
+			}
+			var ok bool
+			switch match {
+			case "prefix":
+				ok = strings.HasPrefix(sym.Name, name)
+			case "contains":
+				ok = strings.Contains(strings.ToLower(sym.Name), lower)
+			default:
+				ok = sym.Name == name
+			}
+			if ok {
+				out = append(out, sym)
+			}
+		}
+	}
+	ix.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Line < out[j].Line
+	})
+	return out[:min(len(out), limit)]
+}
+
+type indexedReference struct {
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Text       string `json:"text"`
+	Definition bool   `json:"definition,omitempty"`
+}
+
+// findReferences returns whole-word occurrences of name in the files whose
+// identifiers include it. It reads those files, so results reflect their
+// current contents even between scans. truncated reports whether limit cut
+// the results short.
+func (ix *workspaceIndex) findReferences(ctx context.Context, name string, limit int) (refs []indexedReference, truncated bool, err error) {
+	ix.mu.RLock()
+	candidates := make([]string, 0, len(ix.identifiers[name]))
+	definitions := make(map[string]map[int]bool)
+	for p := range ix.identifiers[name] {
+		candidates = append(candidates, p)
+		for _, sym := range ix.files[p].symbols {
+			if sym.Name == name {
+				if definitions[p] == nil {
+					definitions[p] = make(map[int]bool)
+				}
+				definitions[p][sym.Line] = true
+			}
+		}
+	}
+	ix.mu.RUnlock()
+	sort.Strings(candidates)
+
+	refs = make([]indexedReference, 0)
+	for _, p := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := scanner.Text()
+			for _, col := range wordOccurrences(text, name) {
+				if len(refs) >= limit {
+					file.Close()
+					return refs, true, nil
+				}
+				refs = append(refs, indexedReference{Path: p, Line: line, Column: col + 1, Text: strings.TrimSpace(text), Definition: definitions[p][line]})
+			}
+		}
+		file.Close()
+	}
+	return refs, false, nil
+}
+
+// wordOccurrences returns the byte offsets of name in text where it is not
+// part of a longer identifier.
+func wordOccurrences(text, name string) []int {
+	var offsets []int
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for start := 0; ; {
+		i := strings.Index(text[start:], name)
+		if i < 0 {
+			return offsets
+		}
+		i += start
+		end := i + len(name)
+		if (i == 0 || !isIdent(text[i-1])) && (end == len(text) || !isIdent(text[end])) {
+			offsets = append(offsets, i)
+		}
+		start = i + 1
+	}
+}
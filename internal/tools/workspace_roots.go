@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spjoes/cursor-agent-acp/internal/fspolicy"
+)
+
+// rootParam is the schema of the "root" hint of list and search tools.
+var rootParam = map[string]any{"type": "string", "description": "Optional: Workspace root to use in a multi-root workspace, by absolute path or folder name"}
+
+// workspaceFolders returns the session's workspace folders, which the
+// registry passes as params["_workspaceFolders"].
+func workspaceFolders(params map[string]any) []string {
+	folders, _ := params["_workspaceFolders"].([]string)
+	return folders
+}
+
+// sessionPolicy is policy extended with the session's workspace folders,
+// resolving relative paths against the session working directory.
+func sessionPolicy(policy *fspolicy.Policy, params map[string]any) *fspolicy.Policy {
+	return policy.WithWorkspaceFolders(workspaceFolders(params)).WithBase(getString(params, "_cwd"))
+}
+
+// sessionRoots lists the roots a "root" hint may name: the session working
+// directory, its workspace folders and the allowed paths, without repeats.
+func sessionRoots(params map[string]any, policy *fspolicy.Policy) []string {
+	var roots []string
+	add := func(root string) {
+		if root == "" {
+			return
+		}
+		root = filepath.Clean(root)
+		if !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	add(getString(params, "_cwd"))
+	for _, folder := range workspaceFolders(params) {
+		add(folder)
+	}
+	for _, root := range policy.Roots() {
+		add(root)
+	}
+	return roots
+}
+
+// workspaceRoot resolves the optional "root" parameter of list and search
+// tools, given as an absolute path or a folder name, to one of the session
+// roots. It returns "" when no root is given.
+func workspaceRoot(params map[string]any, policy *fspolicy.Policy) (string, error) {
+	hint := fspolicy.Normalize(strings.TrimSpace(getString(params, "root")))
+	if hint == "" {
+		return "", nil
+	}
+	roots := sessionRoots(params, policy)
+	if filepath.IsAbs(hint) {
+		if slices.Contains(roots, filepath.Clean(hint)) {
+			return filepath.Clean(hint), nil
+		}
+	} else {
+		for _, root := range roots {
+			if filepath.Base(root) == hint {
+				return root, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Unknown workspace root %q; available roots: %s", hint, strings.Join(roots, ", "))
+}
+
+// inRoot makes a relative path relative to root instead of the session
+// working directory.
+func inRoot(root string, path string) string {
+	path = fspolicy.Normalize(path)
+	if root == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// spanData is an ended span waiting to be exported.
+type spanData struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	failed   bool
+	message  string
+}
+
+func (t *Tracer) enqueue(data spanData) {
+	t.mu.Lock()
+	if len(t.queue) >= maxQueue {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.queue = append(t.queue, data)
+	full := len(t.queue) >= maxBatch
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		_ = t.Flush(ctx)
+		cancel()
+	}
+}
+
+// Flush exports every queued span.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	for {
+		t.mu.Lock()
+		batch := t.queue
+		if len(batch) > maxBatch {
+			batch = batch[:maxBatch]
+		}
+		t.queue = t.queue[len(batch):]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			t.logger.Warn("Dropped spans because the export queue was full", map[string]any{"dropped": dropped})
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.export(ctx, batch); err != nil {
+			t.logger.Warn("Failed to export spans", map[string]any{"endpoint": t.endpoint, "spans": len(batch), "error": err.Error()})
+			return err
+		}
+	}
+}
+
+// Shutdown stops the background exporter and flushes what is left. Only the
+// first call has any effect.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+		err = t.Flush(ctx)
+	})
+	return err
+}
+
+func (t *Tracer) export(ctx context.Context, batch []spanData) error {
+	body, err := json.Marshal(t.payload(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// payload builds an OTLP/JSON ExportTraceServiceRequest.
+func (t *Tracer) payload(batch []spanData) map[string]any {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, data := range batch {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(data.traceID[:]),
+			"spanId":            hex.EncodeToString(data.spanID[:]),
+			"name":              data.name,
+			"kind":              data.kind,
+			"startTimeUnixNano": strconv.FormatInt(data.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(data.end.UnixNano(), 10),
+			"attributes":        attributes(data.attrs),
+		}
+		if data.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(data.parentID[:])
+		}
+		if data.failed {
+			span["status"] = map[string]any{"code": 2, "message": data.message}
+		}
+		spans = append(spans, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": attributes(map[string]any{"service.name": t.serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": scopeName},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func attributes(attrs map[string]any) []map[string]any {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]map[string]any, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, map[string]any{"key": k, "value": attributeValue(attrs[k])})
+	}
+	return out
+}
+
+func attributeValue(v any) map[string]any {
+	switch val := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": val}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(val)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		return map[string]any{"doubleValue": val}
+	case string:
+		return map[string]any{"stringValue": val}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(val)}
+	}
+}
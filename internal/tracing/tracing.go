@@ -0,0 +1,227 @@
+// Package tracing records OpenTelemetry spans and exports them to an OTLP
+// collector over HTTP/JSON. A nil *Tracer and a nil *Span are valid and do
+// nothing, so call sites never need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+const (
+	defaultEndpoint = "http://localhost:4318"
+	tracesPath      = "/v1/traces"
+	scopeName       = "github.com/spjoes/cursor-agent-acp"
+
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+	maxBatch       = 512
+	maxQueue       = 2048
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+type spanKey struct{}
+
+type Tracer struct {
+	logger      *logging.Logger
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	queue   []spanData
+	dropped int
+
+	exportMu  sync.Mutex
+	kick      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a tracer exporting to the configured collector, or nil when
+// tracing is disabled by config and environment.
+func New(cfg config.TracingConfig, logger *logging.Logger) *Tracer {
+	cfg, endpoint := applyEnv(cfg, os.Getenv)
+	if !cfg.Enabled {
+		return nil
+	}
+	t := &Tracer{
+		logger:      logger,
+		endpoint:    endpoint,
+		headers:     cfg.Headers,
+		serviceName: cfg.ServiceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		kick:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if t.serviceName == "" {
+		t.serviceName = "cursor-agent-acp"
+	}
+	go t.loop()
+	logger.Info("OTLP tracing enabled", map[string]any{"endpoint": t.endpoint, "serviceName": t.serviceName})
+	return t
+}
+
+// applyEnv overlays the standard OTEL_* variables on cfg and returns the
+// traces URL to post to. A signal-specific endpoint is used as is.
+func applyEnv(cfg config.TracingConfig, getenv func(string) string) (config.TracingConfig, string) {
+	endpoint := ""
+	if traces := strings.TrimSpace(getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")); traces != "" {
+		cfg.Enabled = true
+		endpoint = traces
+	} else if base := strings.TrimSpace(getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+		cfg.Enabled = true
+		endpoint = strings.TrimRight(base, "/") + tracesPath
+	} else {
+		endpoint = tracesURL(cfg.Endpoint)
+	}
+	if name := strings.TrimSpace(getenv("OTEL_SERVICE_NAME")); name != "" {
+		cfg.ServiceName = name
+	}
+	if raw := strings.TrimSpace(getenv("OTEL_EXPORTER_OTLP_HEADERS")); raw != "" {
+		headers := make(map[string]string, len(cfg.Headers))
+		for k, v := range cfg.Headers {
+			headers[k] = v
+		}
+		for _, pair := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				continue
+			}
+			if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+				value = decoded
+			}
+			headers[strings.TrimSpace(key)] = value
+		}
+		cfg.Headers = headers
+	}
+	if strings.EqualFold(strings.TrimSpace(getenv("OTEL_SDK_DISABLED")), "true") {
+		cfg.Enabled = false
+	}
+	return cfg, endpoint
+}
+
+// tracesURL turns a collector base URL into its traces endpoint.
+func tracesURL(endpoint string) string {
+	endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/")
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if strings.HasSuffix(endpoint, tracesPath) {
+		return endpoint
+	}
+	return endpoint + tracesPath
+}
+
+// Start begins a span that is a child of the span in ctx, or a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind int, attrs map[string]any) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: map[string]any{}}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	span.SetAttributes(attrs)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start begins a child of the span in ctx. Without one it returns a nil span,
+// so work outside a traced request is not recorded.
+func Start(ctx context.Context, name string, attrs map[string]any) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, KindInternal, attrs)
+}
+
+// FromContext returns the active span in ctx, if any.
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]any
+	ended bool
+}
+
+// SetAttributes adds attributes; empty strings and nil values are skipped.
+func (s *Span) SetAttributes(attrs map[string]any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range attrs {
+		if v == nil || v == "" {
+			continue
+		}
+		s.attrs[k] = v
+	}
+}
+
+// End finishes the span, marking it failed when err is non-nil, and queues
+// it for export. Later calls are ignored.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := spanData{
+		traceID:  s.traceID,
+		spanID:   s.spanID,
+		parentID: s.parentID,
+		name:     s.name,
+		kind:     s.kind,
+		start:    s.start,
+		end:      time.Now(),
+		attrs:    s.attrs,
+	}
+	if err != nil {
+		data.failed = true
+		data.message = err.Error()
+	}
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/spjoes/cursor-agent-acp/internal/config"
+	"github.com/spjoes/cursor-agent-acp/internal/logging"
+)
+
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func TestSpansExportAsOneTrace(t *testing.T) {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS", "OTEL_SERVICE_NAME", "OTEL_SDK_DISABLED"} {
+		t.Setenv(key, "")
+	}
+
+	var mu sync.Mutex
+	var spans []exportedSpan
+	var auth, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid OTLP payload: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		auth, path = r.Header.Get("Authorization"), r.URL.Path
+		for _, rs := range payload.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tracer := New(config.TracingConfig{Enabled: true, Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer test"}}, logging.New("error"))
+	if tracer == nil {
+		t.Fatal("expected an enabled tracer")
+	}
+
+	ctx, root := tracer.Start(context.Background(), "session/prompt", KindServer, map[string]any{"session.id": "s1"})
+	_, child := Start(ctx, "cursor-agent", map[string]any{"cursor.args": "--print"})
+	child.End(errors.New("exit status 1"))
+	root.End(nil)
+	if _, orphan := Start(context.Background(), "untraced", nil); orphan != nil {
+		t.Fatal("expected no span without a parent in the context")
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/v1/traces" || auth != "Bearer test" {
+		t.Fatalf("unexpected export request path=%q auth=%q", path, auth)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	byName := map[string]exportedSpan{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	parent, cursorSpan := byName["session/prompt"], byName["cursor-agent"]
+	if parent.Kind != KindServer || parent.ParentSpanID != "" || parent.Status != nil {
+		t.Fatalf("unexpected root span %+v", parent)
+	}
+	if cursorSpan.TraceID != parent.TraceID || cursorSpan.ParentSpanID != parent.SpanID {
+		t.Fatalf("expected cursor-agent span to be a child of the request span, got %+v", cursorSpan)
+	}
+	if cursorSpan.Status == nil || cursorSpan.Status.Code != 2 || cursorSpan.Status.Message != "exit status 1" {
+		t.Fatalf("expected error status on cursor-agent span, got %+v", cursorSpan.Status)
+	}
+	if len(parent.Attributes) != 1 || parent.Attributes[0].Key != "session.id" || parent.Attributes[0].Value["stringValue"] != "s1" {
+		t.Fatalf("unexpected attributes %+v", parent.Attributes)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, endpoint := applyEnv(config.TracingConfig{Endpoint: "http://collector:4318/"}, getenv)
+	if cfg.Enabled || endpoint != "http://collector:4318/v1/traces" {
+		t.Fatalf("expected disabled config with base endpoint, got %+v %q", cfg, endpoint)
+	}
+
+	env["OTEL_EXPORTER_OTLP_ENDPOINT"] = "https://otel.example.com"
+	env["OTEL_EXPORTER_OTLP_HEADERS"] = "x-api-key=a%20b,bad"
+	env["OTEL_SERVICE_NAME"] = "acp-test"
+	cfg, endpoint = applyEnv(config.TracingConfig{}, getenv)
+	if !cfg.Enabled || endpoint != "https://otel.example.com/v1/traces" || cfg.ServiceName != "acp-test" || cfg.Headers["x-api-key"] != "a b" || len(cfg.Headers) != 1 {
+		t.Fatalf("unexpected env config %+v %q", cfg, endpoint)
+	}
+
+	env["OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"] = "https://otel.example.com/custom"
+	if _, endpoint = applyEnv(config.TracingConfig{}, getenv); endpoint != "https://otel.example.com/custom" {
+		t.Fatalf("expected traces endpoint to be used as is, got %q", endpoint)
+	}
+
+	env["OTEL_SDK_DISABLED"] = "true"
+	if cfg, _ = applyEnv(config.TracingConfig{Enabled: true}, getenv); cfg.Enabled {
+		t.Fatal("expected OTEL_SDK_DISABLED to turn tracing off")
+	}
+}
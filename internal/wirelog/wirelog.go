@@ -0,0 +1,115 @@
+// Package wirelog records JSON-RPC traffic to a JSONL transcript for bug
+// reports. Secrets are masked and long strings truncated before writing.
+package wirelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spjoes/cursor-agent-acp/internal/redact"
+)
+
+// Directions recorded in the transcript.
+const (
+	Inbound  = "in"
+	Outbound = "out"
+)
+
+// maxString is the longest string value kept intact; prompt text, file
+// contents and images beyond it are cut.
+const maxString = 2048
+
+type record struct {
+	Time      string `json:"time"`
+	Direction string `json:"direction"`
+	Message   any    `json:"message,omitempty"`
+	Raw       string `json:"raw,omitempty"`
+}
+
+// Log is a wire transcript. A nil *Log records nothing.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	redactor *redact.Redactor
+}
+
+// Open creates (or truncates) the transcript at path. Messages are masked
+// with redactor; a nil redactor still masks secret-looking keys.
+func Open(path string, redactor *redact.Redactor) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: f, redactor: redactor}, nil
+}
+
+// Record appends one message as received or sent.
+func (l *Log) Record(direction string, raw []byte) {
+	if l == nil {
+		return
+	}
+	rec := record{Time: time.Now().UTC().Format(time.RFC3339Nano), Direction: direction}
+	var msg any
+	if err := json.Unmarshal(raw, &msg); err == nil {
+		rec.Message = truncate(l.redactor.Value(msg))
+	} else {
+		rec.Raw = truncateString(l.redactor.String(string(raw)))
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		_, _ = l.file.Write(append(line, '\n'))
+	}
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func truncate(v any) any {
+	switch val := v.(type) {
+	case string:
+		return truncateString(val)
+	case map[string]any:
+		for k, item := range val {
+			val[k] = truncate(item)
+		}
+		return val
+	case []any:
+		for i, item := range val {
+			val[i] = truncate(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func truncateString(s string) string {
+	if len(s) <= maxString {
+		return s
+	}
+	cut := maxString
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s…[truncated %d bytes]", s[:cut], len(s)-cut)
+}
@@ -0,0 +1,55 @@
+package wirelog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordMasksSecretsAndTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wire.jsonl")
+	wire, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire.Record(Inbound, []byte(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","env":{"API_KEY":"sk-123"},"authToken":"abc","maxTokens":10,"text":"`+strings.Repeat("a", 3000)+`"}}`))
+	wire.Record(Outbound, []byte(`not json`))
+	if err := wire.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var nilLog *Log
+	nilLog.Record(Inbound, []byte(`{}`))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", data)
+	}
+	if strings.Contains(lines[0], "sk-123") || strings.Contains(lines[0], `"abc"`) {
+		t.Fatalf("expected secrets to be masked: %s", lines[0])
+	}
+	var rec struct {
+		Direction string `json:"direction"`
+		Message   struct {
+			Params map[string]any `json:"params"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	params := rec.Message.Params
+	if rec.Direction != Inbound || params["cwd"] != "/tmp" || params["maxTokens"] != float64(10) {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if text, _ := params["text"].(string); !strings.HasSuffix(text, "[truncated 952 bytes]") {
+		t.Fatalf("expected long text to be truncated, got %d bytes", len(text))
+	}
+	if !strings.Contains(lines[1], `"raw":"not json"`) || !strings.Contains(lines[1], `"direction":"out"`) {
+		t.Fatalf("unexpected raw record %s", lines[1])
+	}
+}